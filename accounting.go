@@ -0,0 +1,429 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// accountingNamespace is the reserved key namespace for persisted prefix totals.
+const accountingNamespace = "accounting"
+
+// PrefixTotal is the number of keys and the total size of keys and values under a prefix.
+type PrefixTotal struct {
+	Keys  int64
+	Bytes int64
+}
+
+// AccountingDB wraps a database and maintains per-prefix key counts and byte totals, which are
+// persisted under reserved keys in the same batch as each write.
+//
+// By default, the previous value of every written key is read before the write to compute exact
+// deltas. In approximate mode (see NewApproximateAccountingDB) this read is skipped: every Set is
+// counted as an insert and every Delete removes one key without adjusting the byte total, so the
+// totals drift on overwrites and deletes of missing keys until Rebuild is called.
+type AccountingDB struct {
+	mtx         sync.Mutex
+	db          DB
+	prefixLen   int
+	approximate bool
+	totals      map[string]PrefixTotal
+}
+
+var _ DB = (*AccountingDB)(nil)
+
+// NewAccountingDB wraps db, accounting keys by their first prefixLen bytes. Totals persisted by a
+// previous AccountingDB over the same database are loaded.
+func NewAccountingDB(db DB, prefixLen int) (*AccountingDB, error) {
+	return newAccountingDB(db, prefixLen, false)
+}
+
+// NewApproximateAccountingDB is like NewAccountingDB, but skips the read before each write. See
+// AccountingDB for the implications.
+func NewApproximateAccountingDB(db DB, prefixLen int) (*AccountingDB, error) {
+	return newAccountingDB(db, prefixLen, true)
+}
+
+func newAccountingDB(db DB, prefixLen int, approximate bool) (*AccountingDB, error) {
+	if prefixLen <= 0 {
+		return nil, fmt.Errorf("invalid prefix length %d", prefixLen)
+	}
+	adb := &AccountingDB{
+		db:          db,
+		prefixLen:   prefixLen,
+		approximate: approximate,
+		totals:      make(map[string]PrefixTotal),
+	}
+	if err := adb.load(); err != nil {
+		return nil, err
+	}
+	return adb, nil
+}
+
+// load reads the persisted totals without locking the mutex.
+func (adb *AccountingDB) load() error {
+	itr, err := IteratePrefix(adb.db, reservedNamespace(accountingNamespace))
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	nsLen := len(reservedNamespace(accountingNamespace))
+	for ; itr.Valid(); itr.Next() {
+		total, err := decodePrefixTotal(itr.Value())
+		if err != nil {
+			return err
+		}
+		adb.totals[string(itr.Key()[nsLen:])] = total
+	}
+	return itr.Error()
+}
+
+// PrefixTotals returns the current totals for every prefix with at least one key.
+func (adb *AccountingDB) PrefixTotals() map[string]PrefixTotal {
+	adb.mtx.Lock()
+	defer adb.mtx.Unlock()
+
+	totals := make(map[string]PrefixTotal, len(adb.totals))
+	for prefix, total := range adb.totals {
+		totals[prefix] = total
+	}
+	return totals
+}
+
+// Rebuild recomputes all totals from a full scan of the database, replacing the persisted totals.
+// Writes are blocked while rebuilding.
+func (adb *AccountingDB) Rebuild(ctx context.Context) error {
+	adb.mtx.Lock()
+	defer adb.mtx.Unlock()
+
+	totals := make(map[string]PrefixTotal)
+	itr, err := adb.db.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	for ; itr.Valid(); itr.Next() {
+		if err := ctx.Err(); err != nil {
+			itr.Close()
+			return err
+		}
+		key := itr.Key()
+		if isReservedKey(key) {
+			continue
+		}
+		prefix := adb.prefix(key)
+		total := totals[prefix]
+		total.Keys++
+		total.Bytes += int64(len(key) + len(itr.Value()))
+		totals[prefix] = total
+	}
+	if err := itr.Error(); err != nil {
+		itr.Close()
+		return err
+	}
+	if err := itr.Close(); err != nil {
+		return err
+	}
+
+	batch := adb.db.NewBatch()
+	defer batch.Close()
+	for prefix := range adb.totals {
+		if _, ok := totals[prefix]; !ok {
+			if err := batch.Delete(reservedKey(accountingNamespace, []byte(prefix))); err != nil {
+				return err
+			}
+		}
+	}
+	for prefix, total := range totals {
+		if err := batch.Set(reservedKey(accountingNamespace, []byte(prefix)), total.encode()); err != nil {
+			return err
+		}
+	}
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+
+	adb.totals = totals
+	return nil
+}
+
+// Unwrap implements Unwrapper.
+func (adb *AccountingDB) Unwrap() DB {
+	return adb.db
+}
+
+// Get implements DB.
+func (adb *AccountingDB) Get(key []byte) ([]byte, error) {
+	return adb.db.Get(key)
+}
+
+// Has implements DB.
+func (adb *AccountingDB) Has(key []byte) (bool, error) {
+	return adb.db.Has(key)
+}
+
+// Set implements DB.
+func (adb *AccountingDB) Set(key []byte, value []byte) error {
+	return adb.write([]operation{{opTypeSet, key, value}}, false)
+}
+
+// SetSync implements DB.
+func (adb *AccountingDB) SetSync(key []byte, value []byte) error {
+	return adb.write([]operation{{opTypeSet, key, value}}, true)
+}
+
+// Delete implements DB.
+func (adb *AccountingDB) Delete(key []byte) error {
+	return adb.write([]operation{{opTypeDelete, key, nil}}, false)
+}
+
+// DeleteSync implements DB.
+func (adb *AccountingDB) DeleteSync(key []byte) error {
+	return adb.write([]operation{{opTypeDelete, key, nil}}, true)
+}
+
+// write applies the operations together with the resulting total updates in a single batch.
+func (adb *AccountingDB) write(ops []operation, sync bool) error {
+	for _, op := range ops {
+		if len(op.key) == 0 {
+			return errKeyEmpty
+		}
+		if op.opType == opTypeSet && op.value == nil {
+			return errValueNil
+		}
+		if isReservedKey(op.key) {
+			return errKeyReserved
+		}
+	}
+
+	adb.mtx.Lock()
+	defer adb.mtx.Unlock()
+
+	// Compute the new totals, tracking the pending state of keys written earlier in the batch.
+	totals := make(map[string]PrefixTotal)
+	pending := make(map[string][]byte)
+	for _, op := range ops {
+		prefix := adb.prefix(op.key)
+		total, ok := totals[prefix]
+		if !ok {
+			total = adb.totals[prefix]
+		}
+
+		if adb.approximate {
+			if op.opType == opTypeSet {
+				total.Keys++
+				total.Bytes += int64(len(op.key) + len(op.value))
+			} else {
+				total.Keys--
+			}
+			totals[prefix] = total
+			continue
+		}
+
+		existing, ok := pending[string(op.key)]
+		if !ok {
+			var err error
+			if existing, err = adb.db.Get(op.key); err != nil {
+				return err
+			}
+		}
+		if existing != nil {
+			total.Keys--
+			total.Bytes -= int64(len(op.key) + len(existing))
+		}
+		if op.opType == opTypeSet {
+			total.Keys++
+			total.Bytes += int64(len(op.key) + len(op.value))
+			pending[string(op.key)] = op.value
+		} else {
+			pending[string(op.key)] = nil
+		}
+		totals[prefix] = total
+	}
+
+	batch := adb.db.NewBatch()
+	defer batch.Close()
+	for _, op := range ops {
+		var err error
+		switch op.opType {
+		case opTypeSet:
+			err = batch.Set(op.key, op.value)
+		case opTypeDelete:
+			err = batch.Delete(op.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for prefix, total := range totals {
+		key := reservedKey(accountingNamespace, []byte(prefix))
+		var err error
+		if total.Keys <= 0 {
+			err = batch.Delete(key)
+		} else {
+			err = batch.Set(key, total.encode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if sync {
+		err = batch.WriteSync()
+	} else {
+		err = batch.Write()
+	}
+	if err != nil {
+		return err
+	}
+
+	for prefix, total := range totals {
+		if total.Keys <= 0 {
+			delete(adb.totals, prefix)
+		} else {
+			adb.totals[prefix] = total
+		}
+	}
+	return nil
+}
+
+// prefix returns the accounting prefix of a key.
+func (adb *AccountingDB) prefix(key []byte) string {
+	if len(key) < adb.prefixLen {
+		return string(key)
+	}
+	return string(key[:adb.prefixLen])
+}
+
+// Iterator implements DB. Reserved keys are skipped.
+func (adb *AccountingDB) Iterator(start, end []byte) (Iterator, error) {
+	itr, err := adb.db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newReservedKeyFilterIterator(itr), nil
+}
+
+// ReverseIterator implements DB. Reserved keys are skipped.
+func (adb *AccountingDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	itr, err := adb.db.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newReservedKeyFilterIterator(itr), nil
+}
+
+// Close implements DB.
+func (adb *AccountingDB) Close() error {
+	return adb.db.Close()
+}
+
+// NewBatch implements DB.
+func (adb *AccountingDB) NewBatch() Batch {
+	return &accountingBatch{db: adb, ops: []operation{}}
+}
+
+// Print implements DB.
+func (adb *AccountingDB) Print() error {
+	return adb.db.Print()
+}
+
+// Stats implements DB.
+func (adb *AccountingDB) Stats() map[string]string {
+	return adb.db.Stats()
+}
+
+// Compact implements DB.
+func (adb *AccountingDB) Compact(start, end []byte) error {
+	return adb.db.Compact(start, end)
+}
+
+// encode encodes the total as two big-endian 64-bit integers.
+func (t PrefixTotal) encode() []byte {
+	bz := make([]byte, 16)
+	binary.BigEndian.PutUint64(bz[:8], uint64(t.Keys))
+	binary.BigEndian.PutUint64(bz[8:], uint64(t.Bytes))
+	return bz
+}
+
+func decodePrefixTotal(bz []byte) (PrefixTotal, error) {
+	if len(bz) != 16 {
+		return PrefixTotal{}, fmt.Errorf("invalid prefix total length %d", len(bz))
+	}
+	return PrefixTotal{
+		Keys:  int64(binary.BigEndian.Uint64(bz[:8])),
+		Bytes: int64(binary.BigEndian.Uint64(bz[8:])),
+	}, nil
+}
+
+// accountingBatch buffers operations, and applies them with the total updates on Write.
+type accountingBatch struct {
+	db  *AccountingDB
+	ops []operation
+}
+
+var (
+	_ Batch           = (*accountingBatch)(nil)
+	_ ResettableBatch = (*accountingBatch)(nil)
+)
+
+// Set implements Batch.
+func (b *accountingBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeSet, key, value})
+	return nil
+}
+
+// Delete implements Batch.
+func (b *accountingBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeDelete, key, nil})
+	return nil
+}
+
+// Write implements Batch.
+func (b *accountingBatch) Write() error {
+	return b.write(false)
+}
+
+// WriteSync implements Batch.
+func (b *accountingBatch) WriteSync() error {
+	return b.write(true)
+}
+
+func (b *accountingBatch) write(sync bool) error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	if err := b.db.write(b.ops, sync); err != nil {
+		return err
+	}
+	// Make sure batch cannot be used afterwards. Callers should still call Close(), for errors.
+	return b.Close()
+}
+
+// Close implements Batch.
+func (b *accountingBatch) Close() error {
+	b.ops = nil
+	return nil
+}
+
+// Reset implements ResettableBatch.
+func (b *accountingBatch) Reset() {
+	b.ops = []operation{}
+}
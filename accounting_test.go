@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountingDB(t *testing.T) {
+	mem := NewMemDB()
+	adb, err := NewAccountingDB(mem, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, adb.Set(bz("a/1"), bz("xx")))   // a/: 1 key, 5 bytes
+	require.NoError(t, adb.Set(bz("a/2"), bz("yyyy"))) // a/: 2 keys, 12 bytes
+	require.NoError(t, adb.Set(bz("b/1"), bz("z")))    // b/: 1 key, 4 bytes
+	require.NoError(t, adb.Set(bz("a/1"), bz("x")))    // overwrite, a/: 2 keys, 11 bytes
+	require.NoError(t, adb.Delete(bz("a/2")))          // a/: 1 key, 4 bytes
+	require.NoError(t, adb.Delete(bz("c/1")))          // missing, no change
+	require.NoError(t, adb.Set(bz("c"), bz("c")))      // short key, c: 1 key, 2 bytes
+
+	batch := adb.NewBatch()
+	require.NoError(t, batch.Set(bz("b/2"), bz("zz")))  // b/: 2 keys, 9 bytes
+	require.NoError(t, batch.Set(bz("b/2"), bz("z")))   // overwrite in batch, b/: 2 keys, 8 bytes
+	require.NoError(t, batch.Delete(bz("b/1")))         // b/: 1 key, 4 bytes
+	require.NoError(t, batch.Delete(bz("c")))           // c: removed
+	require.NoError(t, batch.Set(bz("d/1"), bz("ddd"))) // d/: 1 key, 6 bytes
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	expected := map[string]PrefixTotal{
+		"a/": {Keys: 1, Bytes: 4},
+		"b/": {Keys: 1, Bytes: 4},
+		"d/": {Keys: 1, Bytes: 6},
+	}
+	require.Equal(t, expected, adb.PrefixTotals())
+
+	// Reserved keys are hidden from iterators, and cannot be written.
+	itr, err := adb.Iterator(nil, nil)
+	require.NoError(t, err)
+	var keys []string
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.NoError(t, itr.Close())
+	require.Equal(t, []string{"a/1", "b/2", "d/1"}, keys)
+	require.Equal(t, errKeyReserved, adb.Set(reservedKey(accountingNamespace, bz("a/")), bz("1")))
+
+	// Totals are persisted, and loaded by a new wrapper.
+	adb2, err := NewAccountingDB(mem, 2)
+	require.NoError(t, err)
+	require.Equal(t, expected, adb2.PrefixTotals())
+
+	// Writes bypassing the wrapper are picked up by Rebuild.
+	require.NoError(t, mem.Set(bz("e/1"), bz("e")))
+	require.NoError(t, mem.Delete(bz("d/1")))
+	require.NoError(t, adb2.Rebuild(context.Background()))
+	rebuilt := map[string]PrefixTotal{
+		"a/": {Keys: 1, Bytes: 4},
+		"b/": {Keys: 1, Bytes: 4},
+		"e/": {Keys: 1, Bytes: 4},
+	}
+	require.Equal(t, rebuilt, adb2.PrefixTotals())
+
+	adb3, err := NewAccountingDB(mem, 2)
+	require.NoError(t, err)
+	require.Equal(t, rebuilt, adb3.PrefixTotals())
+}
+
+func TestApproximateAccountingDB(t *testing.T) {
+	adb, err := NewApproximateAccountingDB(NewMemDB(), 1)
+	require.NoError(t, err)
+
+	require.NoError(t, adb.Set(bz("a1"), bz("x")))
+	require.NoError(t, adb.Set(bz("a2"), bz("x")))
+	require.NoError(t, adb.Set(bz("a1"), bz("x"))) // overwrite counted as insert
+	require.Equal(t, map[string]PrefixTotal{"a": {Keys: 3, Bytes: 9}}, adb.PrefixTotals())
+
+	require.NoError(t, adb.Rebuild(context.Background()))
+	require.Equal(t, map[string]PrefixTotal{"a": {Keys: 2, Bytes: 6}}, adb.PrefixTotals())
+
+	_, err = NewAccountingDB(NewMemDB(), 0)
+	require.Error(t, err)
+}
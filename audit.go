@@ -0,0 +1,437 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// auditNamespace is the reserved key namespace under which DB audit sinks store records.
+const auditNamespace = "audit"
+
+// Operations of AuditRecord.
+const (
+	AuditOpSet    = "set"
+	AuditOpDelete = "delete"
+	AuditOpGet    = "get"
+	AuditOpHas    = "has"
+)
+
+// AuditFailurePolicy determines what an AuditedDB does when its sink fails to store records.
+type AuditFailurePolicy int
+
+const (
+	// AuditBlock fails the operation with the error of the sink, without applying it. This is the
+	// default.
+	AuditBlock AuditFailurePolicy = iota
+	// AuditDrop drops the records and applies the operation anyway. Dropped records are reported to
+	// the log hook, see SetLogHook.
+	AuditDrop
+)
+
+// AuditRecord describes a single operation on an AuditedDB.
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Op    string    `json:"op"`
+	Key   []byte    `json:"key"`
+	// ValueHash is the SHA-256 hash of the value written by a set.
+	ValueHash []byte `json:"value_hash,omitempty"`
+	// PriorHash is the SHA-256 hash of the value the key had before a mutation. It is only recorded
+	// with AuditConfig.PriorHashes, and is empty if the key did not exist.
+	PriorHash []byte `json:"prior_hash,omitempty"`
+	// PrevHash is the Hash of the previous record, with AuditConfig.HashChain.
+	PrevHash []byte `json:"prev_hash,omitempty"`
+}
+
+// Hash returns the SHA-256 hash of the JSON encoding of the record. With hash chaining, it is
+// stored in the PrevHash of the next record.
+func (r AuditRecord) Hash() []byte {
+	bz, err := json.Marshal(r)
+	if err != nil {
+		panic(err) // records always encode
+	}
+	hash := sha256.Sum256(bz)
+	return hash[:]
+}
+
+// AuditSink stores audit records.
+type AuditSink interface {
+	// Emit stores the records, in order. It is called for one operation or batch at a time.
+	Emit(records []AuditRecord) error
+}
+
+// AuditConfig configures an AuditedDB.
+type AuditConfig struct {
+	// AuditReads also records Get and Has calls. Iterators are never audited.
+	AuditReads bool
+	// PriorHashes records the hash of the previous value of mutated keys, at the cost of a read per
+	// mutated key.
+	PriorHashes bool
+	// HashChain links each record to the previous one via AuditRecord.PrevHash, so that removing
+	// or altering a record in the trail can be detected. The chain starts anew with each AuditedDB.
+	HashChain bool
+	// FailurePolicy determines what happens when the sink fails. Defaults to AuditBlock.
+	FailurePolicy AuditFailurePolicy
+	// Clock provides the record times. Defaults to the system clock.
+	Clock Clock
+}
+
+// AuditedDB wraps a database and emits an AuditRecord to a sink for every mutation, including each
+// operation of a batch when it is written. Records are emitted before the mutation is applied, so
+// with the AuditBlock policy no mutation is applied without being recorded, though a mutation may
+// be recorded and then fail. Mutations and their records are serialized, so that the order of the
+// trail is the order in which mutations were applied.
+type AuditedDB struct {
+	DB
+
+	sink  AuditSink
+	actor func() string
+	cfg   AuditConfig
+
+	mtx      sync.Mutex
+	lastHash []byte
+}
+
+var _ DB = (*AuditedDB)(nil)
+
+// NewAuditedDB wraps db with an audit trail written to sink. actor returns the identity recorded
+// for each operation, and may be nil.
+func NewAuditedDB(db DB, sink AuditSink, actor func() string) DB {
+	return NewAuditedDBWithConfig(db, sink, actor, AuditConfig{})
+}
+
+// NewAuditedDBWithConfig is like NewAuditedDB, with the given configuration.
+func NewAuditedDBWithConfig(db DB, sink AuditSink, actor func() string, cfg AuditConfig) *AuditedDB {
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	return &AuditedDB{
+		DB:    db,
+		sink:  sink,
+		actor: actor,
+		cfg:   cfg,
+	}
+}
+
+// record creates a record of an operation, without time and actor.
+func (adb *AuditedDB) record(op string, key, value []byte) AuditRecord {
+	r := AuditRecord{Op: op, Key: cp(key)}
+	if value != nil {
+		hash := sha256.Sum256(value)
+		r.ValueHash = hash[:]
+	}
+	return r
+}
+
+// emit completes the records and passes them to the sink. The caller must hold the mutex.
+func (adb *AuditedDB) emit(records []AuditRecord) error {
+	now := adb.cfg.Clock.Now()
+	var actor string
+	if adb.actor != nil {
+		actor = adb.actor()
+	}
+	lastHash := adb.lastHash
+	for i := range records {
+		records[i].Time = now
+		records[i].Actor = actor
+		if adb.cfg.HashChain {
+			records[i].PrevHash = lastHash
+			lastHash = records[i].Hash()
+		}
+	}
+
+	if err := adb.sink.Emit(records); err != nil {
+		if adb.cfg.FailurePolicy == AuditDrop {
+			logf("audit: dropped %d records: %v", len(records), err)
+			return nil
+		}
+		return fmt.Errorf("failed to write audit records: %w", err)
+	}
+	adb.lastHash = lastHash
+	return nil
+}
+
+// priorHashes sets the prior value hashes of mutation records.
+func (adb *AuditedDB) priorHashes(records []AuditRecord) error {
+	if !adb.cfg.PriorHashes {
+		return nil
+	}
+	for i := range records {
+		prior, err := adb.DB.Get(records[i].Key)
+		if err != nil {
+			return err
+		}
+		if prior != nil {
+			hash := sha256.Sum256(prior)
+			records[i].PriorHash = hash[:]
+		}
+	}
+	return nil
+}
+
+// mutate records a mutation and applies it.
+func (adb *AuditedDB) mutate(record AuditRecord, apply func() error) error {
+	if len(record.Key) == 0 {
+		return apply() // the wrapped database rejects it
+	}
+	adb.mtx.Lock()
+	defer adb.mtx.Unlock()
+
+	records := []AuditRecord{record}
+	if err := adb.priorHashes(records); err != nil {
+		return err
+	}
+	if err := adb.emit(records); err != nil {
+		return err
+	}
+	return apply()
+}
+
+// read records a read, if reads are audited.
+func (adb *AuditedDB) read(op string, key []byte) error {
+	if !adb.cfg.AuditReads || len(key) == 0 {
+		return nil
+	}
+	adb.mtx.Lock()
+	defer adb.mtx.Unlock()
+	return adb.emit([]AuditRecord{adb.record(op, key, nil)})
+}
+
+// Unwrap implements Unwrapper.
+func (adb *AuditedDB) Unwrap() DB {
+	return adb.DB
+}
+
+// Get implements DB.
+func (adb *AuditedDB) Get(key []byte) ([]byte, error) {
+	if err := adb.read(AuditOpGet, key); err != nil {
+		return nil, err
+	}
+	return adb.DB.Get(key)
+}
+
+// Has implements DB.
+func (adb *AuditedDB) Has(key []byte) (bool, error) {
+	if err := adb.read(AuditOpHas, key); err != nil {
+		return false, err
+	}
+	return adb.DB.Has(key)
+}
+
+// Set implements DB.
+func (adb *AuditedDB) Set(key, value []byte) error {
+	if value == nil {
+		return errValueNil
+	}
+	return adb.mutate(adb.record(AuditOpSet, key, value), func() error {
+		return adb.DB.Set(key, value)
+	})
+}
+
+// SetSync implements DB.
+func (adb *AuditedDB) SetSync(key, value []byte) error {
+	if value == nil {
+		return errValueNil
+	}
+	return adb.mutate(adb.record(AuditOpSet, key, value), func() error {
+		return adb.DB.SetSync(key, value)
+	})
+}
+
+// Delete implements DB.
+func (adb *AuditedDB) Delete(key []byte) error {
+	return adb.mutate(adb.record(AuditOpDelete, key, nil), func() error {
+		return adb.DB.Delete(key)
+	})
+}
+
+// DeleteSync implements DB.
+func (adb *AuditedDB) DeleteSync(key []byte) error {
+	return adb.mutate(adb.record(AuditOpDelete, key, nil), func() error {
+		return adb.DB.DeleteSync(key)
+	})
+}
+
+// NewBatch implements DB.
+func (adb *AuditedDB) NewBatch() Batch {
+	return &auditedBatch{Batch: adb.DB.NewBatch(), db: adb}
+}
+
+// auditedBatch collects the records of a batch, and emits them when the batch is written.
+type auditedBatch struct {
+	Batch
+
+	db      *AuditedDB
+	records []AuditRecord
+}
+
+var (
+	_ Batch           = (*auditedBatch)(nil)
+	_ ResettableBatch = (*auditedBatch)(nil)
+)
+
+// Set implements Batch.
+func (b *auditedBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.records = append(b.records, b.db.record(AuditOpSet, key, value))
+	return nil
+}
+
+// Delete implements Batch.
+func (b *auditedBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.records = append(b.records, b.db.record(AuditOpDelete, key, nil))
+	return nil
+}
+
+// Write implements Batch.
+func (b *auditedBatch) Write() error {
+	return b.write(b.Batch.Write)
+}
+
+// WriteSync implements Batch.
+func (b *auditedBatch) WriteSync() error {
+	return b.write(b.Batch.WriteSync)
+}
+
+func (b *auditedBatch) write(apply func() error) error {
+	if len(b.records) == 0 {
+		return apply()
+	}
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	if err := b.db.priorHashes(b.records); err != nil {
+		return err
+	}
+	if err := b.db.emit(b.records); err != nil {
+		return err
+	}
+	b.records = nil
+	return apply()
+}
+
+// Close implements Batch.
+func (b *auditedBatch) Close() error {
+	b.records = nil
+	return b.Batch.Close()
+}
+
+// Reset implements ResettableBatch. The records of the dropped operations are not emitted.
+func (b *auditedBatch) Reset() {
+	b.records = nil
+	b.Batch = resetBatch(b.Batch, b.db.DB.NewBatch)
+}
+
+// jsonlAuditSink writes records to an io.Writer as JSON lines.
+type jsonlAuditSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewJSONLAuditSink returns a sink writing each record to w as a line of JSON.
+func NewJSONLAuditSink(w io.Writer) AuditSink {
+	return &jsonlAuditSink{w: w}
+}
+
+// Emit implements AuditSink. The records are written in a single call to the writer.
+func (s *jsonlAuditSink) Emit(records []AuditRecord) error {
+	var buf []byte
+	for _, r := range records {
+		bz, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, bz...), '\n')
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err := s.w.Write(buf)
+	return err
+}
+
+// dbAuditSink stores records in a database under reserved keys.
+type dbAuditSink struct {
+	mtx  sync.Mutex
+	db   DB
+	next uint64
+}
+
+// NewDBAuditSink returns a sink storing records in db, as JSON under reserved keys numbered in
+// order, continuing after any records already stored. The records of each Emit call are written
+// in one synchronous batch. Use AuditRecords to read them.
+func NewDBAuditSink(db DB) (AuditSink, error) {
+	namespace := reservedNamespace(auditNamespace)
+	itr, err := db.ReverseIterator(namespace, cpIncr(namespace))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	s := &dbAuditSink{db: db}
+	if itr.Valid() {
+		seq := itr.Key()[len(namespace):]
+		if len(seq) != 8 {
+			return nil, fmt.Errorf("invalid audit record key %X", itr.Key())
+		}
+		s.next = binary.BigEndian.Uint64(seq) + 1
+	}
+	return s, itr.Error()
+}
+
+// auditRecordKey returns the reserved key of the audit record with the given sequence number.
+func auditRecordKey(seq uint64) []byte {
+	return reservedKey(auditNamespace, binary.BigEndian.AppendUint64(nil, seq))
+}
+
+// Emit implements AuditSink.
+func (s *dbAuditSink) Emit(records []AuditRecord) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	batch := NewBatchWithSize(s.db, len(records))
+	defer batch.Close()
+	for i, r := range records {
+		bz, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(auditRecordKey(s.next+uint64(i)), bz); err != nil {
+			return err
+		}
+	}
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+	s.next += uint64(len(records))
+	return nil
+}
+
+// AuditRecords returns all audit records stored in db by a DB audit sink, in order.
+func AuditRecords(db DB) ([]AuditRecord, error) {
+	namespace := reservedNamespace(auditNamespace)
+	itr, err := db.Iterator(namespace, cpIncr(namespace))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	var records []AuditRecord
+	for ; itr.Valid(); itr.Next() {
+		var r AuditRecord
+		if err := json.Unmarshal(itr.Value(), &r); err != nil {
+			return nil, fmt.Errorf("invalid audit record %X: %w", itr.Key(), err)
+		}
+		records = append(records, r)
+	}
+	return records, itr.Error()
+}
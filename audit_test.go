@@ -0,0 +1,124 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingAuditSink is an AuditSink which always fails.
+type failingAuditSink struct{}
+
+func (failingAuditSink) Emit([]AuditRecord) error { return errors.New("sink unavailable") }
+
+// decodeJSONLAuditRecords decodes the records written by a JSONL audit sink.
+func decodeJSONLAuditRecords(t *testing.T, buf *bytes.Buffer) []AuditRecord {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var r AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestAuditedDBBatchExpansion(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	db := NewAuditedDBWithConfig(NewMemDB(), NewJSONLAuditSink(&buf), func() string { return "alice" },
+		AuditConfig{Clock: clock, PriorHashes: true})
+
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	_, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte("2")))
+	require.NoError(t, batch.Set([]byte("b"), []byte("3")))
+	require.NoError(t, batch.Delete([]byte("c")))
+	// Nothing is recorded until the batch is written.
+	require.Len(t, decodeJSONLAuditRecords(t, bytes.NewBuffer(buf.Bytes())), 1)
+	clock.advance(time.Second)
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	require.NoError(t, db.Delete([]byte("b")))
+
+	hash := func(s string) []byte {
+		h := sha256.Sum256([]byte(s))
+		return h[:]
+	}
+	batchTime := clock.now
+	expect := []AuditRecord{
+		{Time: batchTime.Add(-time.Second), Actor: "alice", Op: AuditOpSet, Key: []byte("a"), ValueHash: hash("1")},
+		{Time: batchTime, Actor: "alice", Op: AuditOpSet, Key: []byte("a"), ValueHash: hash("2"), PriorHash: hash("1")},
+		{Time: batchTime, Actor: "alice", Op: AuditOpSet, Key: []byte("b"), ValueHash: hash("3")},
+		{Time: batchTime, Actor: "alice", Op: AuditOpDelete, Key: []byte("c")},
+		{Time: batchTime, Actor: "alice", Op: AuditOpDelete, Key: []byte("b"), PriorHash: hash("3")},
+	}
+	require.Equal(t, expect, decodeJSONLAuditRecords(t, &buf))
+}
+
+func TestAuditedDBHashChain(t *testing.T) {
+	store := NewMemDB()
+	sink, err := NewDBAuditSink(store)
+	require.NoError(t, err)
+	db := NewAuditedDBWithConfig(NewMemDB(), sink, nil, AuditConfig{HashChain: true, AuditReads: true})
+
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	_, err = db.Has([]byte("a"))
+	require.NoError(t, err)
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("b"), []byte("2")))
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.WriteSync())
+	require.NoError(t, batch.Close())
+
+	// A new sink on the same database continues after the stored records.
+	sink, err = NewDBAuditSink(store)
+	require.NoError(t, err)
+	require.NoError(t, sink.Emit([]AuditRecord{{Op: AuditOpSet, Key: []byte("x")}}))
+
+	records, err := AuditRecords(store)
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	require.Equal(t, []string{AuditOpSet, AuditOpHas, AuditOpSet, AuditOpDelete, AuditOpSet},
+		[]string{records[0].Op, records[1].Op, records[2].Op, records[3].Op, records[4].Op})
+
+	require.Nil(t, records[0].PrevHash)
+	for i := 1; i < 4; i++ {
+		require.Equal(t, records[i-1].Hash(), records[i].PrevHash, "record %d", i)
+	}
+
+	// Altering a record breaks the chain.
+	records[1].Key = []byte("b")
+	require.NotEqual(t, records[1].Hash(), records[2].PrevHash)
+}
+
+func TestAuditedDBFailurePolicy(t *testing.T) {
+	blocking := NewAuditedDB(NewMemDB(), failingAuditSink{}, nil)
+	require.Error(t, blocking.Set([]byte("a"), []byte("1")))
+	batch := blocking.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte("1")))
+	require.Error(t, batch.Write())
+	require.NoError(t, batch.Close())
+	ok, err := blocking.Has([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	var notices []string
+	SetLogHook(func(msg string) { notices = append(notices, msg) })
+	defer SetLogHook(nil)
+
+	dropping := NewAuditedDBWithConfig(NewMemDB(), failingAuditSink{}, nil, AuditConfig{FailurePolicy: AuditDrop})
+	require.NoError(t, dropping.Set([]byte("a"), []byte("1")))
+	checkValue(t, dropping, []byte("a"), []byte("1"))
+	require.Len(t, notices, 1)
+}
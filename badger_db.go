@@ -8,10 +8,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/y"
 )
 
 func init() { registerDBCreator(BadgerDBBackend, badgerDBCreator, true) }
@@ -27,7 +30,34 @@ func badgerDBCreator(options Options) (DB, error) {
 		return nil, errors.Wrap(errMissingOption, optionDir)
 	}
 
-	return NewBadgerDB(name, dir)
+	path := filepath.Join(dir, name)
+	if err := checkDataFormat(BadgerDBBackend, path, options); err != nil {
+		return nil, err
+	}
+
+	lenient, err := lenientRanges(options)
+	if err != nil {
+		return nil, err
+	}
+
+	readOnlyAfter, err := readOnlyAfterStorageFull(options)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDB(path, true, options)
+	if err != nil {
+		return nil, err
+	}
+	db, err := NewBadgerDB(name, dir)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	db.lock = lock
+	db.lenientRanges = lenient
+	db.storageFull.threshold = int64(readOnlyAfter)
+	return db, nil
 }
 
 // NewBadgerDB creates a Badger key-value store backed to the
@@ -59,9 +89,33 @@ func NewBadgerDBWithOptions(opts badger.Options) (*BadgerDB, error) {
 
 type BadgerDB struct {
 	db *badger.DB
+
+	// lock is the database lock taken by NewDB, released on Close.
+	lock *dbLock
+
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
+
+	// storageFull tracks the storage-full errors of writes.
+	storageFull storageFullGuard
 }
 
-var _ DB = (*BadgerDB)(nil)
+var (
+	_ DB                   = (*BadgerDB)(nil)
+	_ CapabilityReporter   = (*BadgerDB)(nil)
+	_ PhysicalWriteCounter = (*BadgerDB)(nil)
+	_ StatsProvider        = (*BadgerDB)(nil)
+	_ RangeDeleter         = (*BadgerDB)(nil)
+	_ TTLSetter            = (*BadgerDB)(nil)
+)
+
+// badgerMaxKeySize is the maximum key size accepted by badger.
+const badgerMaxKeySize = 65000
+
+// Capabilities implements CapabilityReporter.
+func (b *BadgerDB) Capabilities() Capabilities {
+	return Capabilities{MaxKeySize: badgerMaxKeySize, ReadOnly: b.storageFull.isReadOnly()}
+}
 
 func (b *BadgerDB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
@@ -107,9 +161,43 @@ func (b *BadgerDB) Set(key, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
-	return b.db.Update(func(txn *badger.Txn) error {
+	if err := checkKeySize(key, badgerMaxKeySize); err != nil {
+		return err
+	}
+	if err := b.storageFull.writable(); err != nil {
+		return err
+	}
+	return b.writeError(b.db.Update(func(txn *badger.Txn) error {
 		return txn.Set(key, value)
-	})
+	}))
+}
+
+// SetWithTTL implements TTLSetter with an entry TTL. Badger expires entries at a granularity of
+// seconds, rounding the expiry time down, and deletes them during compactions.
+func (b *BadgerDB) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if ttl <= 0 {
+		return invalidTTLError(ttl)
+	}
+	if err := checkKeySize(key, badgerMaxKeySize); err != nil {
+		return err
+	}
+	if err := b.storageFull.writable(); err != nil {
+		return err
+	}
+	return b.writeError(b.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	}))
+}
+
+// writeError classifies and tracks the error of a write, see ErrStorageFull.
+func (b *BadgerDB) writeError(err error) error {
+	return b.storageFull.track(diskFullError(err))
 }
 
 func withSync(db *badger.DB, err error) error {
@@ -127,17 +215,58 @@ func (b *BadgerDB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
-	return b.db.Update(func(txn *badger.Txn) error {
+	return b.writeError(b.db.Update(func(txn *badger.Txn) error {
 		return txn.Delete(key)
-	})
+	}))
 }
 
 func (b *BadgerDB) DeleteSync(key []byte) error {
 	return withSync(b.db, b.Delete(key))
 }
 
+// DeleteRange implements RangeDeleter, deleting the keys of [start, end), read with a single
+// key-only iterator, with a write batch which badger commits in chunks. If it fails, an unknown
+// part of the range may have been deleted, and 0 is returned.
+func (b *BadgerDB) DeleteRange(start, end []byte) (int64, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return 0, errKeyEmpty
+	}
+	if err := checkRange(start, end, b.lenientRanges); err != nil {
+		return 0, err
+	}
+	txn := b.db.NewTransaction(false)
+	defer txn.Discard()
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	iter := txn.NewIterator(opts)
+	defer iter.Close()
+
+	wb := b.db.NewWriteBatch()
+	defer wb.Cancel()
+	var deleted int64
+	for iter.Seek(start); iter.Valid(); iter.Next() {
+		// Keys of the iterator are only valid until Next, while the batch keeps them.
+		key := iter.Item().KeyCopy(nil)
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if err := wb.Delete(key); err != nil {
+			return 0, b.writeError(err)
+		}
+		deleted++
+	}
+	if err := b.writeError(wb.Flush()); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
 func (b *BadgerDB) Close() error {
-	return b.db.Close()
+	reportClosed(b)
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	return b.lock.release()
 }
 
 func (b *BadgerDB) Print() error {
@@ -168,23 +297,71 @@ func (b *BadgerDB) iteratorOpts(start, end []byte, opts badger.IteratorOptions)
 }
 
 func (b *BadgerDB) Iterator(start, end []byte) (Iterator, error) {
+	if err := checkRange(start, end, b.lenientRanges); err != nil {
+		return nil, err
+	}
 	opts := badger.DefaultIteratorOptions
 	return b.iteratorOpts(start, end, opts)
 }
 
 func (b *BadgerDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	if err := checkRange(start, end, b.lenientRanges); err != nil {
+		return nil, err
+	}
 	opts := badger.DefaultIteratorOptions
 	opts.Reverse = true
 	return b.iteratorOpts(end, start, opts)
 }
 
+// Stats implements DB, reporting the storage-full counters under storage_full.
 func (b *BadgerDB) Stats() map[string]string {
-	return nil
+	stats, _ := b.TypedStats()
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider, with the sizes of the LSM tree and the value log as the
+// disk size. Badger refreshes these sizes periodically, so they lag behind recent writes. The
+// number of keys and the memory size are not known.
+func (b *BadgerDB) TypedStats() (DBStats, error) {
+	raw := make(map[string]string)
+	b.storageFull.addStats(raw)
+	lsm, vlog := b.db.Size()
+	return DBStats{KeyCount: -1, DiskSizeBytes: lsm + vlog, MemSizeBytes: -1, Raw: raw}, nil
+}
+
+// PhysicalBytesWritten implements PhysicalWriteCounter. Badger only counts the bytes written by
+// all of its databases in the process together, so the count covers other open Badger databases
+// too.
+func (b *BadgerDB) PhysicalBytesWritten() (int64, error) {
+	return y.NumBytesWritten.Value(), nil
+}
+
+// badgerGCDiscardRatio is the fraction of a value log file which must be garbage for Compact to
+// rewrite it.
+const badgerGCDiscardRatio = 0.5
+
+// Compact implements DB. Badger cannot compact a range, so the whole database is compacted: the
+// LSM tree is flattened into a single level, and the value log files are garbage collected until
+// none is worth rewriting.
+func (b *BadgerDB) Compact(_, _ []byte) error {
+	if err := b.db.Flatten(runtime.NumCPU()); err != nil {
+		return err
+	}
+	for {
+		err := b.db.RunValueLogGC(badgerGCDiscardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (b *BadgerDB) NewBatch() Batch {
 	wb := &badgerDBBatch{
 		db:         b.db,
+		parent:     b,
 		wb:         b.db.NewWriteBatch(),
 		firstFlush: make(chan struct{}, 1),
 	}
@@ -192,11 +369,19 @@ func (b *BadgerDB) NewBatch() Batch {
 	return wb
 }
 
-var _ Batch = (*badgerDBBatch)(nil)
+var (
+	_ Batch           = (*badgerDBBatch)(nil)
+	_ ResettableBatch = (*badgerDBBatch)(nil)
+)
 
 type badgerDBBatch struct {
-	db *badger.DB
-	wb *badger.WriteBatch
+	db     *badger.DB
+	parent *BadgerDB
+	wb     *badger.WriteBatch
+	// sets is set once a key is set in the batch.
+	sets bool
+	// closed is set once the batch is written or closed, see Reset.
+	closed bool
 
 	// Calling db.Flush twice panics, so we must keep track of whether we've
 	// flushed already on our own. If Write can receive from the firstFlush
@@ -214,20 +399,47 @@ func (b *badgerDBBatch) Set(key, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
-	return b.wb.Set(key, value)
+	if err := checkKeySize(key, badgerMaxKeySize); err != nil {
+		return err
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	if err := b.parent.storageFull.writable(); err != nil {
+		return err
+	}
+	b.sets = true
+	// The write batch commits in the background once it is large enough.
+	return b.parent.writeError(b.wb.Set(key, value))
 }
 
 func (b *badgerDBBatch) Delete(key []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
-	return b.wb.Delete(key)
+	if b.closed {
+		return errBatchClosed
+	}
+	return b.parent.writeError(b.wb.Delete(key))
 }
 
 func (b *badgerDBBatch) Write() error {
+	if b.closed {
+		return errBatchClosed
+	}
+	// Batches which only delete are allowed when read-only, so that space can be freed.
+	if b.sets {
+		if err := b.parent.storageFull.writable(); err != nil {
+			return err
+		}
+	}
 	select {
 	case <-b.firstFlush:
-		return b.wb.Flush()
+		if err := b.parent.writeError(b.wb.Flush()); err != nil {
+			return err
+		}
+		// Make sure batch cannot be used afterwards. Callers should still call Close(), for errors.
+		return b.Close()
 	default:
 		return fmt.Errorf("batch already flushed")
 	}
@@ -238,6 +450,10 @@ func (b *badgerDBBatch) WriteSync() error {
 }
 
 func (b *badgerDBBatch) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
 	select {
 	case <-b.firstFlush: // a Flush after Cancel panics too
 	default:
@@ -246,7 +462,21 @@ func (b *badgerDBBatch) Close() error {
 	return nil
 }
 
+// Reset implements ResettableBatch, replacing the write batch, which cannot be reused once
+// flushed or canceled.
+func (b *badgerDBBatch) Reset() {
+	b.Close()
+	b.wb = b.db.NewWriteBatch()
+	b.firstFlush <- struct{}{}
+	b.sets = false
+	b.closed = false
+}
+
+var _ SeekIterator = (*badgerDBIterator)(nil)
+
 type badgerDBIterator struct {
+	iteratorGuard
+
 	reverse    bool
 	start, end []byte
 
@@ -262,16 +492,48 @@ func (i *badgerDBIterator) Close() error {
 	return nil
 }
 
-func (i *badgerDBIterator) Domain() (start, end []byte) { return i.start, i.end }
-func (i *badgerDBIterator) Error() error                { return i.lastErr }
+func (i *badgerDBIterator) Domain() (start, end []byte) {
+	// Reverse iterators are created with swapped limits, see ReverseIterator.
+	if i.reverse {
+		return i.end, i.start
+	}
+	return i.start, i.end
+}
+func (i *badgerDBIterator) Error() error {
+	if i.lastErr != nil {
+		return i.lastErr
+	}
+	return i.misuseError()
+}
 
 func (i *badgerDBIterator) Next() {
-	if !i.Valid() {
-		panic("iterator is invalid")
+	if !i.guard(i.Valid()) {
+		return
 	}
 	i.iter.Next()
 }
 
+// Seek implements SeekIterator. Reverse iterators are created with swapped limits, see
+// ReverseIterator, and Badger seeks them to the last key <= key.
+func (i *badgerDBIterator) Seek(key []byte) bool {
+	if !i.Valid() {
+		return false
+	}
+	switch {
+	case !i.reverse && i.start != nil && bytes.Compare(key, i.start) < 0:
+		i.iter.Seek(i.start)
+	case i.reverse && i.start != nil && bytes.Compare(key, i.start) >= 0:
+		// The end of the domain is exclusive.
+		i.iter.Seek(i.start)
+		if i.iter.Valid() && bytes.Equal(i.iter.Item().Key(), i.start) {
+			i.iter.Next()
+		}
+	default:
+		i.iter.Seek(key)
+	}
+	return i.Valid()
+}
+
 func (i *badgerDBIterator) Valid() bool {
 	if !i.iter.Valid() {
 		return false
@@ -287,8 +549,8 @@ func (i *badgerDBIterator) Valid() bool {
 }
 
 func (i *badgerDBIterator) Key() []byte {
-	if !i.Valid() {
-		panic("iterator is invalid")
+	if !i.guard(i.Valid()) {
+		return nil
 	}
 	// Note that we don't use KeyCopy, so this is only valid until the next
 	// call to Next.
@@ -296,12 +558,14 @@ func (i *badgerDBIterator) Key() []byte {
 }
 
 func (i *badgerDBIterator) Value() []byte {
-	if !i.Valid() {
-		panic("iterator is invalid")
+	if !i.guard(i.Valid()) {
+		return nil
 	}
 	val, err := i.iter.Item().ValueCopy(nil)
 	if err != nil {
 		i.lastErr = err
+	} else if val == nil {
+		val = []byte{}
 	}
 	return val
 }
@@ -0,0 +1,38 @@
+//go:build badgerdb
+// +build badgerdb
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadgerDBSetWithTTL(t *testing.T) {
+	db, err := NewBadgerDB("ttl", t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, SetWithTTL(db, []byte("short"), []byte("s"), time.Second))
+	require.NoError(t, db.SetWithTTL([]byte("long"), []byte("l"), time.Hour))
+	require.NoError(t, db.Set([]byte("forever"), []byte("f")))
+	require.Error(t, db.SetWithTTL([]byte("zero"), []byte("z"), 0))
+	require.Equal(t, errKeyEmpty, db.SetWithTTL(nil, []byte("v"), time.Second))
+	checkValue(t, db, []byte("short"), []byte("s"))
+
+	// Badger expires entries at a granularity of seconds.
+	require.Eventually(t, func() bool {
+		has, err := db.Has([]byte("short"))
+		return err == nil && !has
+	}, 3*time.Second, 50*time.Millisecond)
+	checkValue(t, db, []byte("short"), nil)
+	require.Equal(t, map[string]string{"forever": "f", "long": "l"}, collectAll(t, db))
+
+	// Setting a key again without a TTL makes it permanent.
+	require.NoError(t, db.SetWithTTL([]byte("again"), []byte("a"), time.Second))
+	require.NoError(t, db.Set([]byte("again"), []byte("a2")))
+	time.Sleep(2 * time.Second)
+	checkValue(t, db, []byte("again"), []byte("a2"))
+}
@@ -4,6 +4,7 @@
 package db
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,7 +28,29 @@ func init() {
 			return nil, errors.Wrap(errMissingOption, optionDir)
 		}
 
-		return NewBoltDB(name, dir)
+		path := filepath.Join(dir, name+".db")
+		if err := checkDataFormat(BoltDBBackend, path, options); err != nil {
+			return nil, err
+		}
+
+		lenient, err := lenientRanges(options)
+		if err != nil {
+			return nil, err
+		}
+
+		// bbolt waits forever for its own lock, so take ours first to fail fast.
+		lock, err := lockDB(path, false, options)
+		if err != nil {
+			return nil, err
+		}
+		db, err := NewBoltDB(name, dir)
+		if err != nil {
+			lock.release()
+			return nil, err
+		}
+		db.(*BoltDB).lock = lock
+		db.(*BoltDB).lenientRanges = lenient
+		return db, nil
 	}, false)
 }
 
@@ -40,9 +63,19 @@ func init() {
 // lead to performance issues when/if there will be lots of keys.
 type BoltDB struct {
 	db *bbolt.DB
+
+	// lock is the database lock taken by NewDB, released on Close.
+	lock *dbLock
+
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
 }
 
-var _ DB = (*BoltDB)(nil)
+var (
+	_ DB                 = (*BoltDB)(nil)
+	_ CapabilityReporter = (*BoltDB)(nil)
+	_ RangeDeleter       = (*BoltDB)(nil)
+)
 
 // NewBoltDB returns a BoltDB with default options.
 func NewBoltDB(name, dir string) (DB, error) {
@@ -101,6 +134,11 @@ func (bdb *BoltDB) Has(key []byte) (bool, error) {
 	return bytes != nil, nil
 }
 
+// Capabilities implements CapabilityReporter.
+func (bdb *BoltDB) Capabilities() Capabilities {
+	return Capabilities{MaxKeySize: bbolt.MaxKeySize}
+}
+
 // Set implements DB.
 func (bdb *BoltDB) Set(key, value []byte) error {
 	if len(key) == 0 {
@@ -109,6 +147,9 @@ func (bdb *BoltDB) Set(key, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
+	if err := checkKeySize(key, bbolt.MaxKeySize); err != nil {
+		return err
+	}
 	err := bdb.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucket)
 		return b.Put(key, value)
@@ -143,9 +184,45 @@ func (bdb *BoltDB) DeleteSync(key []byte) error {
 	return bdb.Delete(key)
 }
 
+// DeleteRange implements RangeDeleter, deleting the keys of [start, end) with a cursor, in a single
+// transaction.
+func (bdb *BoltDB) DeleteRange(start, end []byte) (int64, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return 0, errKeyEmpty
+	}
+	if err := checkRange(start, end, bdb.lenientRanges); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	err := bdb.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		first := c.First
+		if start != nil {
+			first = func() ([]byte, []byte) { return c.Seek(start) }
+		}
+		// The cursor is moved back to the first key of the range after each delete, as Next may
+		// skip a key after Delete.
+		for k, _ := first(); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, _ = first() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
 // Close implements DB.
 func (bdb *BoltDB) Close() error {
-	return bdb.db.Close()
+	reportClosed(bdb)
+	if err := bdb.db.Close(); err != nil {
+		return err
+	}
+	return bdb.lock.release()
 }
 
 // Print implements DB.
@@ -184,6 +261,13 @@ func (bdb *BoltDB) Stats() map[string]string {
 	return m
 }
 
+// Compact implements DB. It does nothing: bbolt reuses the pages freed by deletes, and can only
+// shrink its file by copying the database to a new file, e.g. with the compact command of the
+// bbolt tool, while it is closed.
+func (bdb *BoltDB) Compact(_, _ []byte) error {
+	return nil
+}
+
 // NewBatch implements DB.
 func (bdb *BoltDB) NewBatch() Batch {
 	return newBoltDBBatch(bdb)
@@ -195,6 +279,9 @@ func (bdb *BoltDB) Iterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, bdb.lenientRanges); err != nil {
+		return nil, err
+	}
 	tx, err := bdb.db.Begin(false)
 	if err != nil {
 		return nil, err
@@ -208,6 +295,9 @@ func (bdb *BoltDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, bdb.lenientRanges); err != nil {
+		return nil, err
+	}
 	tx, err := bdb.db.Begin(false)
 	if err != nil {
 		return nil, err
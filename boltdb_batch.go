@@ -11,7 +11,10 @@ type boltDBBatch struct {
 	ops []operation
 }
 
-var _ Batch = (*boltDBBatch)(nil)
+var (
+	_ Batch           = (*boltDBBatch)(nil)
+	_ ResettableBatch = (*boltDBBatch)(nil)
+)
 
 func newBoltDBBatch(db *BoltDB) *boltDBBatch {
 	return &boltDBBatch{
@@ -28,6 +31,9 @@ func (b *boltDBBatch) Set(key, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
+	if err := checkKeySize(key, bbolt.MaxKeySize); err != nil {
+		return err
+	}
 	if b.ops == nil {
 		return errBatchClosed
 	}
@@ -85,3 +91,8 @@ func (b *boltDBBatch) Close() error {
 	b.ops = nil
 	return nil
 }
+
+// Reset implements ResettableBatch.
+func (b *boltDBBatch) Reset() {
+	b.ops = []operation{}
+}
@@ -12,6 +12,8 @@ import (
 // boltDBIterator allows you to iterate on range of keys/values given some
 // start / end keys (nil & nil will result in doing full scan).
 type boltDBIterator struct {
+	iteratorGuard
+
 	tx *bbolt.Tx
 
 	itr   *bbolt.Cursor
@@ -101,7 +103,9 @@ func (itr *boltDBIterator) Valid() bool {
 
 // Next implements Iterator.
 func (itr *boltDBIterator) Next() {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return
+	}
 	if itr.isReverse {
 		itr.currentKey, itr.currentValue = itr.itr.Prev()
 	} else {
@@ -111,23 +115,24 @@ func (itr *boltDBIterator) Next() {
 
 // Key implements Iterator.
 func (itr *boltDBIterator) Key() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return append([]byte{}, itr.currentKey...)
 }
 
 // Value implements Iterator.
 func (itr *boltDBIterator) Value() []byte {
-	itr.assertIsValid()
-	var value []byte
-	if itr.currentValue != nil {
-		value = append([]byte{}, itr.currentValue...)
+	if !itr.assertIsValid() {
+		return nil
 	}
-	return value
+	// Empty values are returned as empty, not nil, even if bbolt returns them as nil.
+	return append([]byte{}, itr.currentValue...)
 }
 
 // Error implements Iterator.
 func (itr *boltDBIterator) Error() error {
-	return nil
+	return itr.misuseError()
 }
 
 // Close implements Iterator.
@@ -135,8 +140,6 @@ func (itr *boltDBIterator) Close() error {
 	return itr.tx.Rollback()
 }
 
-func (itr *boltDBIterator) assertIsValid() {
-	if !itr.Valid() {
-		panic("iterator is invalid")
-	}
+func (itr *boltDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
 }
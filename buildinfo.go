@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// BackendBuildInfo describes how a backend was built into the binary.
+type BackendBuildInfo struct {
+	// Backend is the backend type.
+	Backend BackendType `json:"backend"`
+	// Available is set if the backend is compiled into this binary.
+	Available bool `json:"available"`
+	// Module is the path of the Go module implementing the backend.
+	Module string `json:"module"`
+	// Version is the version of the module, if it is linked into this binary.
+	Version string `json:"version,omitempty"`
+	// RequiresCgo is set if the backend wraps a C or C++ library.
+	RequiresCgo bool `json:"requires_cgo"`
+	// BuildTag is the build tag the backend is gated behind, if any.
+	BuildTag string `json:"build_tag,omitempty"`
+	// UnavailableReason explains why the backend is not available.
+	UnavailableReason string `json:"unavailable_reason,omitempty"`
+}
+
+// backendBuilds lists the known backends, and the modules which implement them.
+var backendBuilds = []BackendBuildInfo{
+	{Backend: MemDBBackend, Module: "github.com/google/btree"},
+	{Backend: GoLevelDBBackend, Module: "github.com/syndtr/goleveldb"},
+	{Backend: CLevelDBBackend, Module: "github.com/jmhodges/levigo", RequiresCgo: true, BuildTag: "cleveldb"},
+	{Backend: RocksDBBackend, Module: "github.com/linxGnu/grocksdb", RequiresCgo: true, BuildTag: "rocksdb"},
+	{Backend: BoltDBBackend, Module: "go.etcd.io/bbolt", BuildTag: "boltdb"},
+	{Backend: BadgerDBBackend, Module: "github.com/dgraph-io/badger/v2", BuildTag: "badgerdb"},
+	{Backend: MongoDBBackend, Module: "go.mongodb.org/mongo-driver"},
+	{Backend: RedisDBBackend, Module: "github.com/redis/go-redis/v9", BuildTag: "redisdb"},
+}
+
+// BuildInfo returns the build information of every known backend, including whether it is
+// available in this binary and the version of the module backing it, for use in bug reports and
+// health endpoints. Versions are unknown if the binary was built without module support.
+func BuildInfo() []BackendBuildInfo {
+	versions := make(map[string]string)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			version := dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+			versions[dep.Path] = version
+		}
+	}
+
+	infos := make([]BackendBuildInfo, 0, len(backendBuilds))
+	for _, info := range backendBuilds {
+		_, info.Available = backends[info.Backend]
+		if info.Available {
+			info.Version = versions[info.Module]
+		} else {
+			info.UnavailableReason = fmt.Sprintf("not compiled in, build with -tags %s", info.BuildTag)
+			if info.RequiresCgo {
+				info.UnavailableReason += " and cgo enabled"
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfo(t *testing.T) {
+	infos := make(map[BackendType]BackendBuildInfo)
+	for _, info := range BuildInfo() {
+		infos[info.Backend] = info
+	}
+
+	for _, backend := range []BackendType{MemDBBackend, GoLevelDBBackend, MongoDBBackend} {
+		info, ok := infos[backend]
+		require.True(t, ok, backend)
+		require.True(t, info.Available, backend)
+		require.NotEmpty(t, info.Module, backend)
+		require.NotEmpty(t, info.Version, backend)
+		require.Empty(t, info.UnavailableReason, backend)
+	}
+
+	for backend, info := range infos {
+		_, registered := backends[backend]
+		require.Equal(t, registered, info.Available, backend)
+		if !info.Available {
+			require.Contains(t, info.UnavailableReason, info.BuildTag)
+		}
+	}
+
+	bz, err := json.Marshal(infos[MongoDBBackend])
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(bz, &decoded))
+	require.Equal(t, "mongodb", decoded["backend"])
+	require.Equal(t, "go.mongodb.org/mongo-driver", decoded["module"])
+	require.Equal(t, infos[MongoDBBackend].Version, decoded["version"])
+}
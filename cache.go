@@ -0,0 +1,425 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+)
+
+// defaultCacheMaxBytes is the default of CacheConfig.MaxBytes.
+const defaultCacheMaxBytes = 64 << 20
+
+// CacheConfig configures a CacheDB.
+type CacheConfig struct {
+	// MaxBytes is the capacity of the cache, counting the size of keys and values. The least
+	// recently used entries are evicted beyond it. Defaults to 64 MiB.
+	MaxBytes int64
+	// MaxEntries is the maximum number of cached entries, beyond which the least recently used
+	// ones are evicted as well. Zero means no limit.
+	MaxEntries int
+}
+
+// CacheStats are the counters of a CacheDB.
+type CacheStats struct {
+	// Hits and Misses count the Get and Has calls served from the cache and from the database.
+	// Get calls finding a presence-only entry are misses.
+	Hits   uint64
+	Misses uint64
+	// Entries and Bytes are the number and total size of the cached entries.
+	Entries int
+	Bytes   int64
+}
+
+// cacheEntry is a cached value, or the presence of a key cached by Has.
+type cacheEntry struct {
+	key string
+	// value is nil for presence-only entries, which serve Has but not Get.
+	value []byte
+}
+
+// size returns the size accounted for the entry.
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// CacheDB wraps a database with a read-through LRU cache of values. Get and Has are served from the
+// cache when possible. Otherwise Get reads the value from the database and caches it, while Has
+// calls the Has of the database, which does not load the value, and caches the presence of the
+// key if it exists. A later Get of the key reads its value and replaces the presence. Writes go to
+// the database, and invalidate the cached values of the keys written, including those written by
+// batches. Once a write returned, Get and Has no longer serve the values it replaced, even if they
+// were read concurrently with it. Writes which bypass the CacheDB, e.g. through the wrapped
+// database, leave stale values in the cache. Iterators bypass the cache, and read the database
+// directly. The cache can be prefilled with Warmup.
+type CacheDB struct {
+	DB
+
+	maxBytes   int64
+	maxEntries int
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, most recently used first.
+	lru   *list.List
+	bytes int64
+	// gen is incremented by every invalidation, so that values read from the database while keys
+	// were written are not cached.
+	gen    uint64
+	hits   uint64
+	misses uint64
+}
+
+var (
+	_ DB            = (*CacheDB)(nil)
+	_ Warmer        = (*CacheDB)(nil)
+	_ StatsProvider = (*CacheDB)(nil)
+)
+
+// NewCacheDB wraps db with a value cache of the default capacity.
+func NewCacheDB(db DB) DB {
+	return NewCacheDBWithConfig(db, CacheConfig{})
+}
+
+// NewCachingDB wraps db with a value cache configured by cfg. It is NewCacheDBWithConfig returning
+// a DB.
+func NewCachingDB(db DB, cfg CacheConfig) DB {
+	return NewCacheDBWithConfig(db, cfg)
+}
+
+// NewCacheDBWithConfig is like NewCacheDB, with the given configuration.
+func NewCacheDBWithConfig(db DB, cfg CacheConfig) *CacheDB {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultCacheMaxBytes
+	}
+	return &CacheDB{
+		DB:         db,
+		maxBytes:   cfg.MaxBytes,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (cdb *CacheDB) Unwrap() DB {
+	return cdb.DB
+}
+
+// CacheStats returns the counters of the cache.
+func (cdb *CacheDB) CacheStats() CacheStats {
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+	return CacheStats{Hits: cdb.hits, Misses: cdb.misses, Entries: len(cdb.entries), Bytes: cdb.bytes}
+}
+
+// HitCount returns the number of Get and Has calls served from the cache, see CacheStats.
+func (cdb *CacheDB) HitCount() uint64 {
+	return cdb.CacheStats().Hits
+}
+
+// MissCount returns the number of Get and Has calls served from the database, see CacheStats.
+func (cdb *CacheDB) MissCount() uint64 {
+	return cdb.CacheStats().Misses
+}
+
+// lookup returns the cached entry of key, and counts a hit or a miss. Presence-only entries are
+// misses if the value is needed.
+func (cdb *CacheDB) lookup(key []byte, needValue bool) (*cacheEntry, bool, uint64) {
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+	if elem, ok := cdb.entries[string(key)]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.value != nil || !needValue {
+			cdb.hits++
+			cdb.lru.MoveToFront(elem)
+			return entry, true, cdb.gen
+		}
+	}
+	cdb.misses++
+	return nil, false, cdb.gen
+}
+
+// insert caches the value of key, or its presence if value is nil, unless keys were invalidated
+// since gen, and returns whether it did. The presence of a key does not replace its cached value.
+// Values larger than the cache are not cached.
+func (cdb *CacheDB) insert(key, value []byte, gen uint64) bool {
+	entry := &cacheEntry{key: string(key)}
+	if value != nil {
+		entry.value = cp(value)
+	}
+	if entry.size() > cdb.maxBytes {
+		return false
+	}
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+	if cdb.gen != gen {
+		return false
+	}
+	if elem, ok := cdb.entries[entry.key]; ok && entry.value == nil && elem.Value.(*cacheEntry).value != nil {
+		cdb.lru.MoveToFront(elem)
+		return true
+	}
+	cdb.remove(entry.key)
+	cdb.entries[entry.key] = cdb.lru.PushFront(entry)
+	cdb.bytes += entry.size()
+	for cdb.bytes > cdb.maxBytes || (cdb.maxEntries > 0 && len(cdb.entries) > cdb.maxEntries) {
+		cdb.remove(cdb.lru.Back().Value.(*cacheEntry).key)
+	}
+	return true
+}
+
+// generation returns the current invalidation generation.
+func (cdb *CacheDB) generation() uint64 {
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+	return cdb.gen
+}
+
+// remove removes the entry of key, if any, without locking the mutex.
+func (cdb *CacheDB) remove(key string) {
+	elem, ok := cdb.entries[key]
+	if !ok {
+		return
+	}
+	cdb.lru.Remove(elem)
+	delete(cdb.entries, key)
+	cdb.bytes -= elem.Value.(*cacheEntry).size()
+}
+
+// invalidate removes the entries of the given keys.
+func (cdb *CacheDB) invalidate(keys ...[]byte) {
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+	cdb.gen++
+	for _, key := range keys {
+		cdb.remove(string(key))
+	}
+}
+
+// Get implements DB.
+func (cdb *CacheDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	entry, ok, gen := cdb.lookup(key, true)
+	if ok {
+		return cp(entry.value), nil
+	}
+	value, err := cdb.DB.Get(key)
+	if err == nil && value != nil {
+		cdb.insert(key, value, gen)
+	}
+	return value, err
+}
+
+// Has implements DB.
+func (cdb *CacheDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	_, ok, gen := cdb.lookup(key, false)
+	if ok {
+		return true, nil
+	}
+	ok, err := cdb.DB.Has(key)
+	if err == nil && ok {
+		cdb.insert(key, nil, gen)
+	}
+	return ok, err
+}
+
+// Set implements DB.
+func (cdb *CacheDB) Set(key, value []byte) error {
+	defer cdb.invalidate(key)
+	return cdb.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (cdb *CacheDB) SetSync(key, value []byte) error {
+	defer cdb.invalidate(key)
+	return cdb.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (cdb *CacheDB) Delete(key []byte) error {
+	defer cdb.invalidate(key)
+	return cdb.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (cdb *CacheDB) DeleteSync(key []byte) error {
+	defer cdb.invalidate(key)
+	return cdb.DB.DeleteSync(key)
+}
+
+// NewBatch implements DB. The batch implements SizedBatch if the batch of the wrapped database
+// does, see cacheBatch for the other optional interfaces.
+func (cdb *CacheDB) NewBatch() Batch {
+	b := &cacheBatch{Batch: cdb.DB.NewBatch(), db: cdb}
+	if _, ok := b.Batch.(SizedBatch); ok {
+		return sizedCacheBatch{b}
+	}
+	return b
+}
+
+// Stats implements DB, adding the counters of the cache under cache.
+func (cdb *CacheDB) Stats() map[string]string {
+	stats, _ := cdb.TypedStats()
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider, with the statistics of the wrapped database and the
+// counters of the cache as the Raw statistics cache.hits, cache.misses, cache.entries and
+// cache.bytes. cache.hits and cache.misses are HitCount and MissCount. The sizes are unknown if the wrapped database does not implement StatsProvider.
+func (cdb *CacheDB) TypedStats() (DBStats, error) {
+	var stats DBStats
+	var err error
+	if sp, ok := cdb.DB.(StatsProvider); ok {
+		stats, err = sp.TypedStats()
+	} else {
+		stats = DBStats{KeyCount: -1, DiskSizeBytes: -1, MemSizeBytes: -1, Raw: cdb.DB.Stats()}
+	}
+	raw := make(map[string]string, len(stats.Raw)+4)
+	for key, value := range stats.Raw {
+		raw[key] = value
+	}
+	cs := cdb.CacheStats()
+	raw["cache.hits"] = strconv.FormatUint(cs.Hits, 10)
+	raw["cache.misses"] = strconv.FormatUint(cs.Misses, 10)
+	raw["cache.entries"] = strconv.Itoa(cs.Entries)
+	raw["cache.bytes"] = strconv.FormatInt(cs.Bytes, 10)
+	stats.Raw = raw
+	return stats, err
+}
+
+// cacheBatch invalidates the keys written by a batch when it is written. It implements
+// ResettableBatch and ContextBatch, which it emulates if the wrapped batch does not implement them,
+// and forwards StrictSetBatch and StrictBatch, which return ErrNotSupported if the wrapped batch
+// does not implement them.
+type cacheBatch struct {
+	Batch
+
+	db   *CacheDB
+	keys [][]byte
+}
+
+var (
+	_ ResettableBatch = (*cacheBatch)(nil)
+	_ ContextBatch    = (*cacheBatch)(nil)
+	_ StrictSetBatch  = (*cacheBatch)(nil)
+	_ StrictBatch     = (*cacheBatch)(nil)
+	_ SizedBatch      = sizedCacheBatch{}
+)
+
+// Set implements Batch.
+func (b *cacheBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, cp(key))
+	return nil
+}
+
+// Delete implements Batch.
+func (b *cacheBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, cp(key))
+	return nil
+}
+
+// SetInsertOnly implements StrictSetBatch.
+func (b *cacheBatch) SetInsertOnly(key, value []byte) error {
+	sb, ok := b.Batch.(StrictSetBatch)
+	if !ok {
+		return ErrNotSupported{Op: "SetInsertOnly"}
+	}
+	if err := sb.SetInsertOnly(key, value); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, cp(key))
+	return nil
+}
+
+// SetUpdateOnly implements StrictSetBatch.
+func (b *cacheBatch) SetUpdateOnly(key, value []byte) error {
+	sb, ok := b.Batch.(StrictSetBatch)
+	if !ok {
+		return ErrNotSupported{Op: "SetUpdateOnly"}
+	}
+	if err := sb.SetUpdateOnly(key, value); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, cp(key))
+	return nil
+}
+
+// Write implements Batch.
+func (b *cacheBatch) Write() error {
+	defer b.db.invalidate(b.keys...)
+	return b.Batch.Write()
+}
+
+// WriteSync implements Batch.
+func (b *cacheBatch) WriteSync() error {
+	defer b.db.invalidate(b.keys...)
+	return b.Batch.WriteSync()
+}
+
+// WriteStrict implements StrictBatch.
+func (b *cacheBatch) WriteStrict() error {
+	sb, ok := b.Batch.(StrictBatch)
+	if !ok {
+		return ErrNotSupported{Op: "WriteStrict"}
+	}
+	defer b.db.invalidate(b.keys...)
+	return sb.WriteStrict()
+}
+
+// WriteContext implements ContextBatch. If the wrapped batch does not implement it, ctx is only
+// checked before the write.
+func (b *cacheBatch) WriteContext(ctx context.Context) error {
+	cb, ok := b.Batch.(ContextBatch)
+	if !ok {
+		if err := checkContext(ctx, "write"); err != nil {
+			return err
+		}
+		return b.Write()
+	}
+	defer b.db.invalidate(b.keys...)
+	return cb.WriteContext(ctx)
+}
+
+// Reset implements ResettableBatch, invalidating the keys of the dropped operations in case they
+// were partially written. If the wrapped batch does not implement it, it is closed and replaced
+// with a new batch.
+func (b *cacheBatch) Reset() {
+	if len(b.keys) > 0 {
+		b.db.invalidate(b.keys...)
+		b.keys = nil
+	}
+	b.Batch = resetBatch(b.Batch, b.db.DB.NewBatch)
+}
+
+// Close implements Batch.
+func (b *cacheBatch) Close() error {
+	b.keys = nil
+	return b.Batch.Close()
+}
+
+// sizedCacheBatch is a cacheBatch whose wrapped batch implements SizedBatch.
+type sizedCacheBatch struct {
+	*cacheBatch
+}
+
+// Count implements SizedBatch.
+func (b sizedCacheBatch) Count() int {
+	return b.Batch.(SizedBatch).Count()
+}
+
+// SizeBytes implements SizedBatch.
+func (b sizedCacheBatch) SizeBytes() int {
+	return b.Batch.(SizedBatch).SizeBytes()
+}
@@ -0,0 +1,405 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheDB(t *testing.T) {
+	backing := NewMemDB()
+	db := NewCacheDBWithConfig(backing, CacheConfig{MaxBytes: 20})
+
+	require.NoError(t, db.Set(bz("a"), bz("1234")))
+	checkValue(t, db, bz("a"), bz("1234"))
+	checkValue(t, db, bz("a"), bz("1234"))
+	ok, err := db.Has(bz("a"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1, Bytes: 5}, db.CacheStats())
+
+	// Returned values are copies.
+	value, err := db.Get(bz("a"))
+	require.NoError(t, err)
+	value[0] = 'x'
+	checkValue(t, db, bz("a"), bz("1234"))
+
+	// Writes invalidate the cached values.
+	require.NoError(t, db.Set(bz("a"), bz("5")))
+	checkValue(t, db, bz("a"), bz("5"))
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set(bz("a"), bz("6")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	checkValue(t, db, bz("a"), bz("6"))
+	require.NoError(t, db.Delete(bz("a")))
+	checkValue(t, db, bz("a"), nil)
+
+	// The least recently used entries are evicted.
+	for _, key := range []string{"b", "c", "d"} {
+		require.NoError(t, db.Set(bz(key), bz("123456")))
+		checkValue(t, db, bz(key), bz("123456"))
+	}
+	checkValue(t, db, bz("b"), bz("123456"))
+	require.NoError(t, db.Set(bz("e"), bz("123456")))
+	checkValue(t, db, bz("e"), bz("123456"))
+	stats := db.CacheStats()
+	require.Equal(t, 2, stats.Entries)
+	require.EqualValues(t, 14, stats.Bytes)
+	require.Equal(t, "2", db.Stats()["cache.entries"])
+
+	caching := NewCachingDB(backing, CacheConfig{MaxEntries: 1})
+	require.IsType(t, &CacheDB{}, caching)
+	require.Equal(t, 1, caching.(*CacheDB).maxEntries)
+}
+
+func TestCacheDBMaxEntries(t *testing.T) {
+	backing := &countingGetDB{DB: NewMemDB()}
+	db := NewCacheDBWithConfig(backing, CacheConfig{MaxEntries: 2})
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, db.Set(bz(key), bz("1")))
+	}
+
+	checkValue(t, db, bz("a"), bz("1"))
+	checkValue(t, db, bz("b"), bz("1"))
+
+	// Has is served from the cache, and otherwise caches the presence of the key without reading
+	// its value, evicting b, the least recently used entry. Absent keys are not cached.
+	for _, key := range []string{"a", "c", "c"} {
+		ok, err := db.Has(bz(key))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	ok, err := db.Has(bz("x"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, []string{"a", "b"}, backing.gets)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 4, Entries: 2, Bytes: 3}, db.CacheStats())
+	require.Equal(t, uint64(2), db.HitCount())
+	require.Equal(t, uint64(4), db.MissCount())
+
+	// Get reads the value of a key whose presence is cached, and caches it.
+	backing.gets = nil
+	checkValue(t, db, bz("c"), bz("1"))
+	checkValue(t, db, bz("a"), bz("1"))
+	checkValue(t, db, bz("c"), bz("1"))
+	checkValue(t, db, bz("b"), bz("1"))
+	require.Equal(t, []string{"c", "b"}, backing.gets)
+	require.Equal(t, CacheStats{Hits: 4, Misses: 6, Entries: 2, Bytes: 4}, db.CacheStats())
+
+	// The presence of a key, as cached by a concurrent Has, does not replace its cached value.
+	require.True(t, db.insert(bz("b"), nil, db.generation()))
+	backing.gets = nil
+	checkValue(t, db, bz("b"), bz("1"))
+	require.Empty(t, backing.gets)
+
+	// Writes invalidate cached presences.
+	require.NoError(t, db.Delete(bz("c")))
+	ok, err = db.Has(bz("c"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Warmup loads at most MaxEntries keys.
+	db = NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{MaxEntries: 10})
+	require.NoError(t, db.Warmup(context.Background(), WarmupSpec{Prefixes: []WarmupPrefix{{Prefix: bz("a/")}}}))
+	require.Equal(t, 10, db.CacheStats().Entries)
+	require.Equal(t, 0.2, warmupReadSet(t, db))
+}
+
+// sizedBatchDB returns batches which implement SizedBatch with fixed sizes.
+type sizedBatchDB struct {
+	DB
+}
+
+type sizedBatch struct {
+	Batch
+}
+
+func (db sizedBatchDB) NewBatch() Batch {
+	return sizedBatch{db.DB.NewBatch()}
+}
+
+func (sizedBatch) Count() int     { return 7 }
+func (sizedBatch) SizeBytes() int { return 42 }
+
+func TestCacheDBBatchInterfaces(t *testing.T) {
+	db := NewCacheDBWithConfig(NewMemDB(), CacheConfig{})
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	checkValue(t, db, bz("a"), bz("1"))
+
+	// Strict sets are forwarded, and invalidate the keys they write.
+	batch := db.NewBatch()
+	require.NoError(t, batch.(StrictSetBatch).SetUpdateOnly(bz("a"), bz("2")))
+	require.NoError(t, batch.(StrictSetBatch).SetInsertOnly(bz("b"), bz("1")))
+	require.Equal(t, ErrNotSupported{Op: "WriteStrict"}, batch.(StrictBatch).WriteStrict())
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	checkValue(t, db, bz("a"), bz("2"))
+	checkValue(t, db, bz("b"), bz("1"))
+	_, ok := batch.(SizedBatch)
+	require.False(t, ok)
+
+	// Reset drops the pending operations and invalidates their keys.
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set(bz("a"), bz("3")))
+	batch.(ResettableBatch).Reset()
+	require.NoError(t, batch.Set(bz("c"), bz("1")))
+	require.NoError(t, batch.Write())
+	batch.(ResettableBatch).Reset()
+	require.NoError(t, batch.Set(bz("c"), bz("2")))
+	require.NoError(t, batch.(ContextBatch).WriteContext(context.Background()))
+	require.NoError(t, batch.Close())
+	checkValue(t, db, bz("a"), bz("2"))
+	checkValue(t, db, bz("c"), bz("2"))
+
+	// Batches without Reset are replaced, and a context is checked before writes without it.
+	db = NewCacheDBWithConfig(&failingBatchDB{DB: NewMemDB(), failAfter: 100}, CacheConfig{})
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set(bz("a"), bz("1")))
+	batch.(ResettableBatch).Reset()
+	require.NoError(t, batch.Set(bz("b"), bz("1")))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var aborted *ErrAborted
+	require.ErrorAs(t, batch.(ContextBatch).WriteContext(ctx), &aborted)
+	require.Equal(t, ErrNotSupported{Op: "SetInsertOnly"}, batch.(StrictSetBatch).SetInsertOnly(bz("c"), bz("1")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	checkValue(t, db, bz("a"), nil)
+	checkValue(t, db, bz("b"), bz("1"))
+
+	// SizedBatch is forwarded if the wrapped batch implements it.
+	db = NewCacheDBWithConfig(sizedBatchDB{NewMemDB()}, CacheConfig{})
+	batch = db.NewBatch()
+	sized, ok := batch.(SizedBatch)
+	require.True(t, ok)
+	require.Equal(t, 7, sized.Count())
+	require.Equal(t, 42, sized.SizeBytes())
+	require.NoError(t, batch.Close())
+}
+
+func TestCacheDBTypedStats(t *testing.T) {
+	db := NewCacheDBWithConfig(NewMemDB(), CacheConfig{})
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	checkValue(t, db, bz("a"), bz("1"))
+	checkValue(t, db, bz("a"), bz("1"))
+
+	stats, err := db.TypedStats()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, stats.KeyCount)
+	require.Equal(t, "1", stats.Raw["cache.hits"])
+	require.Equal(t, "1", stats.Raw["cache.misses"])
+	require.Equal(t, stats.Map(), db.Stats())
+
+	// The sizes are unknown for databases without typed statistics.
+	db = NewCacheDBWithConfig(&countingGetDB{DB: NewMemDB()}, CacheConfig{})
+	stats, err = db.TypedStats()
+	require.NoError(t, err)
+	require.EqualValues(t, -1, stats.KeyCount)
+	require.Equal(t, "0", stats.Raw["cache.hits"])
+}
+
+// TestCacheDBConcurrentWrites reads and writes one key from 50 goroutines. Reads never return a
+// value older than the last write which returned before they started.
+func TestCacheDBConcurrentWrites(t *testing.T) {
+	db := NewCacheDBWithConfig(NewMemDB(), CacheConfig{})
+	key := bz("key")
+	require.NoError(t, db.Set(key, int642Bytes(0)))
+
+	const writes = 2000
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(1); i <= writes; i++ {
+			if err := db.Set(key, int642Bytes(i)); err != nil {
+				t.Error(err)
+				return
+			}
+			written.Store(i)
+		}
+	}()
+	for g := 0; g < 49; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for written.Load() < writes {
+				before := written.Load()
+				if g%7 == 0 {
+					// Deletes of another key invalidate the cache concurrently as well.
+					if err := db.Delete(bz("other")); err != nil {
+						t.Error(err)
+						return
+					}
+					continue
+				}
+				if g%2 == 0 {
+					if _, err := db.Has(key); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				value, err := db.Get(key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if v := bytes2Int64(value); v < before {
+					t.Errorf("read %d after write %d returned", v, before)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	checkValue(t, db, key, int642Bytes(writes))
+}
+
+// BenchmarkCacheDBGet reads a key through a cache over a database with the latency of a MongoDB
+// round trip. Hits are served without reading the database.
+func BenchmarkCacheDBGet(b *testing.B) {
+	backing := &latencyGetDB{DB: NewMemDB(), latency: 200 * time.Microsecond}
+	require.NoError(b, backing.Set(bz("key"), make([]byte, 1024)))
+
+	b.Run("Hit", func(b *testing.B) {
+		db := NewCacheDBWithConfig(backing, CacheConfig{})
+		_, err := db.Get(bz("key"))
+		require.NoError(b, err)
+		backing.gets.Store(0)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(bz("key")); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(backing.gets.Load())/float64(b.N), "reads/op")
+		require.Zero(b, backing.gets.Load())
+	})
+
+	b.Run("Miss", func(b *testing.B) {
+		// The value does not fit in the cache.
+		db := NewCacheDBWithConfig(backing, CacheConfig{MaxBytes: 1})
+		backing.gets.Store(0)
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(bz("key")); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(backing.gets.Load())/float64(b.N), "reads/op")
+	})
+}
+
+// latencyGetDB counts the calls to Get, which each wait for the latency.
+type latencyGetDB struct {
+	DB
+	latency time.Duration
+	gets    atomic.Int64
+}
+
+func (db *latencyGetDB) Get(key []byte) ([]byte, error) {
+	db.gets.Add(1)
+	time.Sleep(db.latency)
+	return db.DB.Get(key)
+}
+
+// newWarmupTestDB returns a database with 100 keys under each of the prefixes a/ and b/.
+func newWarmupTestDB(t *testing.T) DB {
+	db := NewMemDB()
+	for _, prefix := range []string{"a/", "b/"} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Set(bz(fmt.Sprintf("%s%03d", prefix, i)), bz("0123456789")))
+		}
+	}
+	return db
+}
+
+// warmupReadSet reads the first 50 keys under a/ and returns the hit rate of the cache.
+func warmupReadSet(t *testing.T, db *CacheDB) float64 {
+	before := db.CacheStats()
+	for i := 0; i < 50; i++ {
+		_, err := db.Get(bz(fmt.Sprintf("a/%03d", i)))
+		require.NoError(t, err)
+	}
+	after := db.CacheStats()
+	return float64(after.Hits-before.Hits) / 50
+}
+
+func TestCacheDBWarmup(t *testing.T) {
+	cold := NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{})
+	require.Zero(t, warmupReadSet(t, cold))
+
+	warm := NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{})
+	var progress []WarmupProgress
+	require.NoError(t, Warmup(context.Background(), NewQuotaDB(warm, 0, 0), WarmupSpec{
+		Prefixes: []WarmupPrefix{{Prefix: bz("a/"), Limit: 60}, {Prefix: bz("b/"), Limit: 10}},
+		Progress: func(p WarmupProgress) { progress = append(progress, p) },
+	}))
+	require.Equal(t, []WarmupProgress{
+		{Prefix: 0, PrefixKeys: 60, Keys: 60, Bytes: 60 * 15},
+		{Prefix: 1, PrefixKeys: 10, Keys: 70, Bytes: 70 * 15},
+	}, progress)
+	require.Equal(t, 1.0, warmupReadSet(t, warm))
+}
+
+func TestCacheDBWarmupBudget(t *testing.T) {
+	db := NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{})
+	var progress []WarmupProgress
+	require.NoError(t, db.Warmup(context.Background(), WarmupSpec{
+		Prefixes: []WarmupPrefix{{Prefix: bz("a/")}, {Prefix: bz("b/")}},
+		MaxBytes: 25*15 + 10,
+		Progress: func(p WarmupProgress) { progress = append(progress, p) },
+	}))
+	require.Equal(t, []WarmupProgress{{Prefix: 0, PrefixKeys: 25, Keys: 25, Bytes: 25 * 15}}, progress)
+	require.Equal(t, 25, db.CacheStats().Entries)
+	require.Equal(t, 0.5, warmupReadSet(t, db))
+
+	// The budget is bounded by the capacity of the cache.
+	db = NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{MaxBytes: 10 * 15})
+	require.NoError(t, db.Warmup(context.Background(), WarmupSpec{Prefixes: []WarmupPrefix{{Prefix: bz("a/")}}}))
+	require.Equal(t, 10, db.CacheStats().Entries)
+}
+
+func TestCacheDBWarmupCanceled(t *testing.T) {
+	db := NewCacheDBWithConfig(newWarmupTestDB(t), CacheConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := db.Warmup(ctx, WarmupSpec{Prefixes: []WarmupPrefix{{Prefix: bz("a/")}}})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, db.CacheStats().Entries)
+}
+
+func TestCacheDBWarmupHotKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	tracker := NewHotKeyTrackerDB(newWarmupTestDB(t), TrackerConfig{Clock: clock})
+	for _, key := range []string{"a/090", "a/050", "b/010", "a/070"} {
+		clock.advance(time.Second)
+		_, err := tracker.Get(bz(key))
+		require.NoError(t, err)
+	}
+
+	db := NewCacheDBWithConfig(tracker, CacheConfig{})
+	require.NoError(t, db.Warmup(context.Background(), WarmupSpec{
+		Prefixes: []WarmupPrefix{{Prefix: bz("a/"), Limit: 4}},
+	}))
+	// The three hot keys under a/ are loaded first, followed by the first key in key order.
+	keys := make(map[string]bool)
+	for key := range db.entries {
+		keys[key] = true
+	}
+	require.Equal(t, map[string]bool{"a/070": true, "a/050": true, "a/090": true, "a/000": true}, keys)
+}
+
+func TestWarmupFallback(t *testing.T) {
+	var progress []WarmupProgress
+	require.NoError(t, Warmup(context.Background(), newWarmupTestDB(t), WarmupSpec{
+		Prefixes: []WarmupPrefix{{Prefix: bz("b/"), Limit: 5}},
+		Progress: func(p WarmupProgress) { progress = append(progress, p) },
+	}))
+	require.Equal(t, []WarmupProgress{{Prefix: 0, PrefixKeys: 5, Keys: 5, Bytes: 5 * 15}}, progress)
+}
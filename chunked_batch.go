@@ -0,0 +1,204 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+)
+
+// chunkedBatch is a batch which is written as several native batches, see NewChunkedBatch.
+type chunkedBatch struct {
+	db       DB
+	chunkOps int
+	ops      []operation
+	progress func(done, total int)
+	stats    BatchStats
+	closed   bool
+
+	// duplicateThreshold and duplicateHook are set by SetDuplicateHook.
+	duplicateThreshold int
+	duplicateHook      func(BatchStats)
+
+	mtx sync.Mutex
+}
+
+var (
+	_ Batch           = (*chunkedBatch)(nil)
+	_ ProgressBatch   = (*chunkedBatch)(nil)
+	_ CoalescingBatch = (*chunkedBatch)(nil)
+)
+
+// NewChunkedBatch returns a batch which is written as a sequence of native batches of at most
+// chunkOps operations each, reporting its progress after each of them if a progress function is
+// set. This bounds the size of each native write for very large batches. Operations are coalesced
+// per key before writing, so only the last operation on each key is written.
+//
+// The batch is NOT atomic: each chunk is written atomically if the backend writes batches
+// atomically, but a failed write leaves the preceding chunks applied. Callers must be able to
+// recover from a partially written batch, e.g. by retrying it, as with pruning.
+func NewChunkedBatch(db DB, chunkOps int) Batch {
+	if chunkOps <= 0 {
+		chunkOps = defaultPruneBatchSize
+	}
+	return &chunkedBatch{db: db, chunkOps: chunkOps}
+}
+
+// Set implements Batch.
+func (b *chunkedBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return b.add(operation{opTypeSet, key, value})
+}
+
+// Delete implements Batch.
+func (b *chunkedBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return b.add(operation{opTypeDelete, key, nil})
+}
+
+func (b *chunkedBatch) add(op operation) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+// SetProgressFunc implements ProgressBatch.
+func (b *chunkedBatch) SetProgressFunc(fn func(done, total int)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.progress = fn
+}
+
+// Stats implements CoalescingBatch.
+func (b *chunkedBatch) Stats() BatchStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	stats := b.stats
+	stats.Staged += len(b.ops)
+	return stats
+}
+
+// SetDuplicateHook implements CoalescingBatch.
+func (b *chunkedBatch) SetDuplicateHook(threshold int, fn func(BatchStats)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.duplicateThreshold = threshold
+	b.duplicateHook = fn
+}
+
+// Write implements Batch.
+func (b *chunkedBatch) Write() error {
+	return b.write(false)
+}
+
+// WriteSync implements Batch. Each chunk is written synchronously.
+func (b *chunkedBatch) WriteSync() error {
+	return b.write(true)
+}
+
+func (b *chunkedBatch) write(sync bool) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return errBatchClosed
+	}
+
+	ops := coalesceOperations(b.ops)
+	total := len(ops)
+	for done := 0; done < total; {
+		n := b.chunkOps
+		if total-done < n {
+			n = total - done
+		}
+		if err := b.writeChunk(ops[done:done+n], sync); err != nil {
+			return fmt.Errorf("failed to write chunk after %d of %d operations: %w", done, total, err)
+		}
+		done += n
+		if b.progress != nil {
+			b.progress(done, total)
+		}
+	}
+
+	b.stats = BatchStats{
+		Staged:     len(b.ops),
+		Emitted:    total,
+		Collapsed:  len(b.ops) - total,
+		BytesSaved: operationsSize(b.ops) - operationsSize(ops),
+	}
+	if b.duplicateHook != nil && b.stats.Collapsed > b.duplicateThreshold {
+		b.duplicateHook(b.stats)
+	}
+
+	// Make sure batch cannot be used afterwards. Callers should still call Close(), for errors.
+	b.closed = true
+	b.ops = nil
+	return nil
+}
+
+// coalesceOperations returns the last operation on each key, in order of first appearance.
+func coalesceOperations(ops []operation) []operation {
+	index := make(map[string]int, len(ops))
+	coalesced := make([]operation, 0, len(ops))
+	for _, op := range ops {
+		k := string(op.key)
+		if i, ok := index[k]; ok {
+			coalesced[i] = op
+			continue
+		}
+		index[k] = len(coalesced)
+		coalesced = append(coalesced, op)
+	}
+	return coalesced
+}
+
+// operationsSize returns the total key and value size of ops.
+func operationsSize(ops []operation) int64 {
+	var size int64
+	for _, op := range ops {
+		size += int64(len(op.key) + len(op.value))
+	}
+	return size
+}
+
+// writeChunk writes operations as a native batch.
+func (b *chunkedBatch) writeChunk(ops []operation, sync bool) error {
+	batch := NewBatchWithSize(b.db, len(ops))
+	defer batch.Close()
+
+	for _, op := range ops {
+		var err error
+		switch op.opType {
+		case opTypeSet:
+			err = batch.Set(op.key, op.value)
+		case opTypeDelete:
+			err = batch.Delete(op.key)
+		default:
+			err = fmt.Errorf("unknown operation type %v (%v)", op.opType, op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if sync {
+		return batch.WriteSync()
+	}
+	return batch.Write()
+}
+
+// Close implements Batch.
+func (b *chunkedBatch) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	b.ops = nil
+	return nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedBatch(t *testing.T) {
+	db := NewMemDB()
+	require.NoError(t, db.Set([]byte("stale"), []byte{0}))
+
+	batch := NewChunkedBatch(db, 3)
+	var progress [][2]int
+	batch.(ProgressBatch).SetProgressFunc(func(done, total int) {
+		progress = append(progress, [2]int{done, total})
+	})
+	for i := 0; i < 7; i++ {
+		require.NoError(t, batch.Set([]byte(fmt.Sprintf("key%d", i)), []byte{byte(i)}))
+	}
+	require.NoError(t, batch.Delete([]byte("stale")))
+	require.Equal(t, errValueNil, batch.Set([]byte("key"), nil))
+	require.Equal(t, errKeyEmpty, batch.Delete(nil))
+
+	require.NoError(t, batch.Write())
+	require.Equal(t, [][2]int{{3, 8}, {6, 8}, {8, 8}}, progress)
+	require.Equal(t, errBatchClosed, batch.Write())
+	require.Equal(t, errBatchClosed, batch.Set([]byte("key"), []byte{1}))
+	require.NoError(t, batch.Close())
+
+	values := collectAll(t, db)
+	require.Len(t, values, 7)
+	require.NotContains(t, values, "stale")
+	require.Equal(t, string([]byte{6}), values["key6"])
+}
+
+func TestChunkedBatchEmpty(t *testing.T) {
+	batch := NewChunkedBatch(NewMemDB(), 0)
+	called := false
+	batch.(ProgressBatch).SetProgressFunc(func(done, total int) { called = true })
+	require.NoError(t, batch.WriteSync())
+	require.False(t, called)
+	require.NoError(t, batch.Close())
+}
+
+func TestChunkedBatchStats(t *testing.T) {
+	db := NewMemDB()
+	batch := NewChunkedBatch(db, 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, batch.Set([]byte("key"), []byte(fmt.Sprintf("value%03d", i))))
+	}
+	require.NoError(t, batch.Delete([]byte("gone")))
+	require.Equal(t, BatchStats{Staged: 1001}, batch.(CoalescingBatch).Stats())
+
+	var warned []BatchStats
+	batch.(CoalescingBatch).SetDuplicateHook(100, func(stats BatchStats) {
+		warned = append(warned, stats)
+	})
+	var progress [][2]int
+	batch.(ProgressBatch).SetProgressFunc(func(done, total int) {
+		progress = append(progress, [2]int{done, total})
+	})
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	expected := BatchStats{Staged: 1001, Emitted: 2, Collapsed: 999, BytesSaved: 999 * 11}
+	require.Equal(t, expected, batch.(CoalescingBatch).Stats())
+	require.Equal(t, []BatchStats{expected}, warned)
+	require.Equal(t, [][2]int{{2, 2}}, progress)
+	require.Equal(t, map[string]string{"key": "value999"}, collectAll(t, db))
+
+	// The hook only fires above the threshold.
+	batch = NewChunkedBatch(db, 100)
+	batch.(CoalescingBatch).SetDuplicateHook(1, func(stats BatchStats) { t.Fatal("unexpected warning") })
+	require.NoError(t, batch.Set([]byte("key"), []byte{1}))
+	require.NoError(t, batch.Set([]byte("key"), []byte{2}))
+	require.NoError(t, batch.Write())
+	require.Equal(t, 1, batch.(CoalescingBatch).Stats().Collapsed)
+}
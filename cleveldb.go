@@ -24,7 +24,21 @@ func init() {
 			return nil, errors.Wrap(errMissingOption, optionDir)
 		}
 
-		return NewCLevelDB(name, dir)
+		if err := checkDataFormat(CLevelDBBackend, filepath.Join(dir, name+".db"), options); err != nil {
+			return nil, err
+		}
+
+		lenient, err := lenientRanges(options)
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := NewCLevelDB(name, dir)
+		if err != nil {
+			return nil, err
+		}
+		db.lenientRanges = lenient
+		return db, nil
 	}
 	registerDBCreator(CLevelDBBackend, dbCreator, false)
 }
@@ -35,9 +49,15 @@ type CLevelDB struct {
 	ro     *levigo.ReadOptions
 	wo     *levigo.WriteOptions
 	woSync *levigo.WriteOptions
+
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
 }
 
-var _ DB = (*CLevelDB)(nil)
+var (
+	_ DB                 = (*CLevelDB)(nil)
+	_ CapabilityReporter = (*CLevelDB)(nil)
+)
 
 // NewCLevelDB creates a new CLevelDB.
 func NewCLevelDB(name string, dir string) (*CLevelDB, error) {
@@ -139,8 +159,14 @@ func (db *CLevelDB) DB() *levigo.DB {
 	return db.db
 }
 
+// Capabilities implements CapabilityReporter. Keys are unlimited.
+func (db *CLevelDB) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
 // Close implements DB.
 func (db *CLevelDB) Close() error {
+	reportClosed(db)
 	db.db.Close()
 	db.ro.Close()
 	db.wo.Close()
@@ -184,6 +210,12 @@ func (db *CLevelDB) Stats() map[string]string {
 	return stats
 }
 
+// Compact implements DB, compacting the range with CompactRange.
+func (db *CLevelDB) Compact(start, end []byte) error {
+	db.db.CompactRange(levigo.Range{Start: start, Limit: end})
+	return nil
+}
+
 // NewBatch implements DB.
 func (db *CLevelDB) NewBatch() Batch {
 	return newCLevelDBBatch(db)
@@ -194,6 +226,9 @@ func (db *CLevelDB) Iterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(db.ro)
 	return newCLevelDBIterator(itr, start, end, false), nil
 }
@@ -203,6 +238,9 @@ func (db *CLevelDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(db.ro)
 	return newCLevelDBIterator(itr, start, end, true), nil
 }
@@ -11,6 +11,11 @@ type cLevelDBBatch struct {
 	batch *levigo.WriteBatch
 }
 
+var (
+	_ Batch           = (*cLevelDBBatch)(nil)
+	_ ResettableBatch = (*cLevelDBBatch)(nil)
+)
+
 func newCLevelDBBatch(db *CLevelDB) *cLevelDBBatch {
 	return &cLevelDBBatch{
 		db:    db,
@@ -80,3 +85,12 @@ func (b *cLevelDBBatch) Close() error {
 	}
 	return nil
 }
+
+// Reset implements ResettableBatch.
+func (b *cLevelDBBatch) Reset() {
+	if b.batch == nil {
+		b.batch = levigo.NewWriteBatch()
+	} else {
+		b.batch.Clear()
+	}
+}
@@ -11,6 +11,8 @@ import (
 
 // cLevelDBIterator is a cLevelDB iterator.
 type cLevelDBIterator struct {
+	iteratorGuard
+
 	source     *levigo.Iterator
 	start, end []byte
 	isReverse  bool
@@ -51,12 +53,12 @@ func newCLevelDBIterator(source *levigo.Iterator, start, end []byte, isReverse b
 }
 
 // Domain implements Iterator.
-func (itr cLevelDBIterator) Domain() ([]byte, []byte) {
+func (itr *cLevelDBIterator) Domain() ([]byte, []byte) {
 	return itr.start, itr.end
 }
 
 // Valid implements Iterator.
-func (itr cLevelDBIterator) Valid() bool {
+func (itr *cLevelDBIterator) Valid() bool {
 	// Once invalid, forever invalid.
 	if itr.isInvalid {
 		return false
@@ -95,20 +97,26 @@ func (itr cLevelDBIterator) Valid() bool {
 }
 
 // Key implements Iterator.
-func (itr cLevelDBIterator) Key() []byte {
-	itr.assertIsValid()
+func (itr *cLevelDBIterator) Key() []byte {
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return itr.source.Key()
 }
 
 // Value implements Iterator.
-func (itr cLevelDBIterator) Value() []byte {
-	itr.assertIsValid()
+func (itr *cLevelDBIterator) Value() []byte {
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return itr.source.Value()
 }
 
 // Next implements Iterator.
-func (itr cLevelDBIterator) Next() {
-	itr.assertIsValid()
+func (itr *cLevelDBIterator) Next() {
+	if !itr.assertIsValid() {
+		return
+	}
 	if itr.isReverse {
 		itr.source.Prev()
 	} else {
@@ -117,18 +125,19 @@ func (itr cLevelDBIterator) Next() {
 }
 
 // Error implements Iterator.
-func (itr cLevelDBIterator) Error() error {
-	return itr.source.GetError()
+func (itr *cLevelDBIterator) Error() error {
+	if err := itr.source.GetError(); err != nil {
+		return err
+	}
+	return itr.misuseError()
 }
 
 // Close implements Iterator.
-func (itr cLevelDBIterator) Close() error {
+func (itr *cLevelDBIterator) Close() error {
 	itr.source.Close()
 	return nil
 }
 
-func (itr cLevelDBIterator) assertIsValid() {
-	if !itr.Valid() {
-		panic("iterator is invalid")
-	}
+func (itr *cLevelDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
 }
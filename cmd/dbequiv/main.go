@@ -0,0 +1,127 @@
+// Command dbequiv applies a seeded random workload to a memdb database and to databases of other
+// backends, and reports the first operation whose results differ, see db.RunEquivalence.
+//
+// Databases are given with -db flags, as a backend name optionally followed by a colon and its
+// options as a JSON object. Flat-file backends without a dir option are created in a temporary
+// directory, which is removed afterwards. For example:
+//
+//	dbequiv -ops 100000 -db goleveldb \
+//		-db 'mongodb:{"connection_string":"mongodb://localhost:27017","database":"equiv","collection":"equiv"}'
+//
+// All databases must be empty. The exit status is 1 if the databases diverged, and 2 on errors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	db "github.com/cometbft/cometbft-db"
+)
+
+// dbFlags collects the -db flags.
+type dbFlags []string
+
+func (f *dbFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *dbFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseDBFlag returns the backend and options of a -db flag.
+func parseDBFlag(value string) (db.BackendType, db.Options, error) {
+	backend, options, ok := strings.Cut(value, ":")
+	if backend == "" {
+		return "", nil, fmt.Errorf("invalid -db %q: missing backend", value)
+	}
+	opts := db.Options{}
+	if ok {
+		if err := opts.UnmarshalJSON([]byte(options)); err != nil {
+			return "", nil, fmt.Errorf("invalid options of -db %q: %w", value, err)
+		}
+	}
+	return db.BackendType(backend), opts, nil
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	var (
+		dbs    dbFlags
+		script db.WorkloadScript
+	)
+	flag.Var(&dbs, "db", "database to compare with memdb, as backend[:JSON options] (repeatable)")
+	flag.Int64Var(&script.Seed, "seed", 1, "seed of the workload")
+	flag.IntVar(&script.Ops, "ops", 100000, "number of operations")
+	flag.IntVar(&script.Keys, "keys", 0, "number of distinct keys (default 1024)")
+	flag.IntVar(&script.MaxValueSize, "max-value-size", 0, "maximum value size (default 32)")
+	flag.IntVar(&script.MaxBatchSize, "max-batch-size", 0, "maximum number of writes per batch (default 16)")
+	flag.IntVar(&script.MaxScanLength, "max-scan-length", 0, "maximum number of entries compared per scan (default 64)")
+	flag.Parse()
+	if len(dbs) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one -db is required")
+		flag.Usage()
+		return 2
+	}
+
+	opened := map[string]db.DB{"memdb": db.NewMemDB()}
+	var cleanup []func() error
+	defer func() {
+		for _, fn := range cleanup {
+			if err := fn(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}()
+	for _, value := range dbs {
+		backend, options, err := parseDBFlag(value)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		name := string(backend)
+		for i := 2; opened[name] != nil; i++ {
+			name = fmt.Sprintf("%s-%d", backend, i)
+		}
+		if _, ok := options["dir"]; !ok && backend != db.MongoDBBackend && backend != db.MemDBBackend {
+			dir, err := os.MkdirTemp("", "dbequiv-")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			cleanup = append(cleanup, func() error { return os.RemoveAll(dir) })
+			options["dir"] = dir
+			if _, ok := options["name"]; !ok {
+				options["name"] = name
+			}
+		}
+		d, err := db.NewDB(backend, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "opening %s: %v\n", name, err)
+			return 2
+		}
+		// Databases are closed before their directories are removed.
+		cleanup = append([]func() error{d.Close}, cleanup...)
+		opened[name] = d
+	}
+
+	report, err := db.RunEquivalence(opened, script)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("applied %d operations, compared %d reads and %d iterated entries\n",
+		report.Ops, report.Reads, report.Entries)
+	if report.Divergence != nil {
+		fmt.Println(report.Divergence)
+		return 1
+	}
+	fmt.Println("no divergence")
+	return 0
+}
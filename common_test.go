@@ -164,6 +164,97 @@ func benchmarkRandomReadsWrites(b *testing.B, db DB) {
 	}
 }
 
+// benchmarkBatchBuild builds and closes a batch of numOps sets, created with or without a size
+// hint, to measure the allocations saved by pre-sizing.
+func benchmarkBatchBuild(b *testing.B, db DB, numOps int, sized bool) {
+	keys := make([][]byte, numOps)
+	for i := range keys {
+		keys[i] = int642Bytes(int64(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var batch Batch
+		if sized {
+			batch = NewBatchWithSize(db, numOps)
+		} else {
+			batch = db.NewBatch()
+		}
+		for _, key := range keys {
+			if err := batch.Set(key, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := batch.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkValueReads reads 1 KB values with Get and with an iterator, as separate benchmarks, to
+// measure the cost of copying returned values per read.
+func benchmarkValueReads(b *testing.B, db DB) {
+	const numItems = 1000
+	value := bytes.Repeat([]byte{'v'}, 1024)
+	for i := int64(0); i < numItems; i++ {
+		if err := db.Set(int642Bytes(i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			got, err := db.Get(int642Bytes(int64(i % numItems)))
+			if err != nil || len(got) != len(value) {
+				b.Fatalf("unexpected value of length %d: %v", len(got), err)
+			}
+		}
+	})
+
+	// Each operation reads one value, with a new iterator every numItems operations.
+	b.Run("Iterator", func(b *testing.B) {
+		b.ReportAllocs()
+		var itr Iterator
+		for i := 0; i < b.N; i++ {
+			if itr == nil || !itr.Valid() {
+				if itr != nil {
+					itr.Close()
+				}
+				var err error
+				if itr, err = db.Iterator(nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if len(itr.Value()) != len(value) {
+				b.Fatal("unexpected value length")
+			}
+			itr.Next()
+		}
+		itr.Close()
+	})
+}
+
+// checkValueCopies checks that mutating values returned by Get and iterators does not change
+// the stored values.
+func checkValueCopies(t *testing.T, db DB) {
+	t.Helper()
+	require.NoError(t, db.Set([]byte("key"), []byte("value")))
+
+	value, err := db.Get([]byte("key"))
+	require.NoError(t, err)
+	value[0] = 'X'
+	checkValue(t, db, []byte("key"), []byte("value"))
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.True(t, itr.Valid())
+	itr.Value()[0] = 'X'
+	require.NoError(t, itr.Close())
+	checkValue(t, db, []byte("key"), []byte("value"))
+}
+
 func int642Bytes(i int64) []byte {
 	buf := make([]byte, 8)
 	binary.BigEndian.PutUint64(buf, uint64(i))
@@ -173,3 +264,22 @@ func int642Bytes(i int64) []byte {
 func bytes2Int64(buf []byte) int64 {
 	return int64(binary.BigEndian.Uint64(buf))
 }
+
+// checkSameIteration checks that got iterates over the same entries as want in [start, end), in
+// both directions.
+func checkSameIteration(t *testing.T, want, got DB, start, end []byte) {
+	collect := func(itr Iterator, err error) [][2]string {
+		require.NoError(t, err)
+		defer itr.Close()
+		var entries [][2]string
+		for ; itr.Valid(); itr.Next() {
+			entries = append(entries, [2]string{string(itr.Key()), string(itr.Value())})
+		}
+		require.NoError(t, itr.Error())
+		return entries
+	}
+	require.Equal(t, collect(want.Iterator(start, end)), collect(got.Iterator(start, end)),
+		"iterating [%X, %X)", start, end)
+	require.Equal(t, collect(want.ReverseIterator(start, end)), collect(got.ReverseIterator(start, end)),
+		"reverse iterating [%X, %X)", start, end)
+}
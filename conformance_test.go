@@ -0,0 +1,27 @@
+package db_test
+
+import (
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft-db/dbtest"
+)
+
+// TestBackendConformance runs the conformance tests of dbtest against all registered backends,
+// including the test backends of this package, and MongoDB with the hex record codec registered
+// by mongodb_test.go. The MongoDB backends need Docker.
+func TestBackendConformance(t *testing.T) {
+	for _, backend := range dbm.RegisteredBackends() {
+		t.Run(string(backend), func(t *testing.T) {
+			dbtest.Run(t, func(t *testing.T) dbm.DB {
+				return dbm.NewTestDB(t, backend, nil)
+			})
+		})
+	}
+
+	t.Run("MongoDBHexCodec", func(t *testing.T) {
+		dbtest.Run(t, func(t *testing.T) dbm.DB {
+			return dbm.NewTestDB(t, dbm.MongoDBBackend, dbm.Options{"record_codec": "hexconformance"})
+		})
+	})
+}
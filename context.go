@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+)
+
+// AsContextDB returns db as a ContextDB. Databases which do not implement ContextDB are wrapped so
+// that their operations fail with an *ErrAborted if ctx is done when they start, and iterators stop
+// once ctx is done, but operations in flight are not aborted. Batches use WriteContext if they
+// implement ContextBatch.
+func AsContextDB(db DB) ContextDB {
+	if cdb, ok := db.(ContextDB); ok {
+		return cdb
+	}
+	return contextDB{db: db}
+}
+
+// contextDB implements ContextDB for databases without context support, see AsContextDB.
+type contextDB struct {
+	db DB
+}
+
+var _ ContextDB = contextDB{}
+
+// checkContext returns an *ErrAborted for the operation op if ctx is done.
+func checkContext(ctx context.Context, op string) error {
+	if err := ctx.Err(); err != nil {
+		return &ErrAborted{Op: op, Err: err}
+	}
+	return nil
+}
+
+// GetContext implements ContextDB.
+func (c contextDB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if err := checkContext(ctx, "get"); err != nil {
+		return nil, err
+	}
+	return c.db.Get(key)
+}
+
+// HasContext implements ContextDB.
+func (c contextDB) HasContext(ctx context.Context, key []byte) (bool, error) {
+	if err := checkContext(ctx, "has"); err != nil {
+		return false, err
+	}
+	return c.db.Has(key)
+}
+
+// SetContext implements ContextDB.
+func (c contextDB) SetContext(ctx context.Context, key, value []byte) error {
+	if err := checkContext(ctx, "set"); err != nil {
+		return err
+	}
+	return c.db.Set(key, value)
+}
+
+// DeleteContext implements ContextDB.
+func (c contextDB) DeleteContext(ctx context.Context, key []byte) error {
+	if err := checkContext(ctx, "delete"); err != nil {
+		return err
+	}
+	return c.db.Delete(key)
+}
+
+// IteratorContext implements ContextDB.
+func (c contextDB) IteratorContext(ctx context.Context, start, end []byte) (Iterator, error) {
+	if err := checkContext(ctx, "iterate"); err != nil {
+		return nil, err
+	}
+	it, err := c.db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &contextIterator{Iterator: it, ctx: ctx}, nil
+}
+
+// ReverseIteratorContext implements ContextDB.
+func (c contextDB) ReverseIteratorContext(ctx context.Context, start, end []byte) (Iterator, error) {
+	if err := checkContext(ctx, "iterate"); err != nil {
+		return nil, err
+	}
+	it, err := c.db.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &contextIterator{Iterator: it, ctx: ctx}, nil
+}
+
+// NewBatchContext implements ContextDB.
+func (c contextDB) NewBatchContext(ctx context.Context) Batch {
+	return contextBatch{Batch: c.db.NewBatch(), ctx: ctx}
+}
+
+// contextIterator is an iterator which becomes invalid once its context is done.
+type contextIterator struct {
+	Iterator
+	ctx context.Context
+	err error
+}
+
+// Next implements Iterator.
+func (it *contextIterator) Next() {
+	if it.err == nil {
+		it.err = checkContext(it.ctx, "iterate")
+	}
+	if it.err == nil {
+		it.Iterator.Next()
+	}
+}
+
+// Valid implements Iterator.
+func (it *contextIterator) Valid() bool {
+	return it.err == nil && it.Iterator.Valid()
+}
+
+// Error implements Iterator.
+func (it *contextIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Error()
+}
+
+// contextBatch is a batch written within a context.
+type contextBatch struct {
+	Batch
+	ctx context.Context
+}
+
+// Write implements Batch.
+func (b contextBatch) Write() error {
+	if cb, ok := b.Batch.(ContextBatch); ok {
+		return cb.WriteContext(b.ctx)
+	}
+	if err := checkContext(b.ctx, "write"); err != nil {
+		return err
+	}
+	return b.Batch.Write()
+}
+
+// WriteSync implements Batch.
+func (b contextBatch) WriteSync() error {
+	if err := checkContext(b.ctx, "write"); err != nil {
+		return err
+	}
+	return b.Batch.WriteSync()
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestErrAborted(t *testing.T) {
+	cause := errors.New("connection closed")
+	err := error(&ErrAborted{Op: "get", Err: context.Canceled, Cause: cause})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "get aborted: context canceled: connection closed", err.Error())
+
+	err = &ErrAborted{Op: "set", Err: context.DeadlineExceeded}
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "set aborted: context deadline exceeded", err.Error())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, abortedError(ctx, "get", cause))
+	cancel()
+	assert.NoError(t, abortedError(ctx, "get", nil))
+	var aborted *ErrAborted
+	require.ErrorAs(t, abortedError(ctx, "get", cause), &aborted)
+	assert.Equal(t, &ErrAborted{Op: "get", Err: context.Canceled, Cause: cause}, aborted)
+}
+
+func TestAsContextDB(t *testing.T) {
+	mdb := NewMemDB()
+	cdb := AsContextDB(mdb)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	require.NoError(t, cdb.SetContext(ctx, bz("a"), bz("1")))
+	require.NoError(t, cdb.SetContext(ctx, bz("b"), bz("2")))
+	value, err := cdb.GetContext(ctx, bz("a"))
+	require.NoError(t, err)
+	assert.Equal(t, bz("1"), value)
+	ok, err := cdb.HasContext(ctx, bz("b"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	batch := cdb.NewBatchContext(ctx)
+	require.NoError(t, batch.Set(bz("c"), bz("3")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	checkValue(t, mdb, bz("c"), bz("3"))
+
+	itr, err := cdb.ReverseIteratorContext(ctx, nil, nil)
+	require.NoError(t, err)
+	require.True(t, itr.Valid())
+	assert.Equal(t, bz("c"), itr.Key())
+	itr.Next()
+	require.True(t, itr.Valid())
+	cancel()
+	// The iterator stops once the context is done.
+	itr.Next()
+	assert.False(t, itr.Valid())
+	assert.ErrorIs(t, itr.Error(), context.Canceled)
+	require.NoError(t, itr.Close())
+
+	var aborted *ErrAborted
+	_, err = cdb.GetContext(ctx, bz("a"))
+	require.ErrorAs(t, err, &aborted)
+	assert.Equal(t, "get", aborted.Op)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = cdb.HasContext(ctx, bz("a"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, cdb.SetContext(ctx, bz("d"), bz("4")), context.Canceled)
+	assert.ErrorIs(t, cdb.DeleteContext(ctx, bz("a")), context.Canceled)
+	_, err = cdb.IteratorContext(ctx, nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	batch = cdb.NewBatchContext(ctx)
+	require.NoError(t, batch.Set(bz("d"), bz("4")))
+	assert.ErrorIs(t, batch.Write(), context.Canceled)
+	require.NoError(t, batch.Close())
+
+	// Nothing was written with the canceled context.
+	checkValue(t, mdb, bz("a"), bz("1"))
+	checkValue(t, mdb, bz("d"), nil)
+
+	// Databases implementing ContextDB are returned as is.
+	mongoDB := NewMongoDB(&mongo.Collection{})
+	assert.Same(t, mongoDB, AsContextDB(mongoDB))
+}
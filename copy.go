@@ -0,0 +1,126 @@
+package db
+
+import (
+	"time"
+)
+
+// defaultCopyBatchSize is the number of keys written per batch by CopyDB by default.
+const defaultCopyBatchSize = 1000
+
+// CopyOptions configures CopyDB.
+type CopyOptions struct {
+	// BatchSize is the number of keys written per batch to the destination. Defaults to 1000.
+	BatchSize int
+	// Prefix restricts the copy to the keys with this prefix, if set.
+	Prefix []byte
+	// DeleteDestinationFirst deletes the keys of the destination in the copied domain, i.e. all
+	// keys or the keys with Prefix, before copying, so that the destination ends up with exactly
+	// the keys of the source. Otherwise, other keys of the destination are kept.
+	DeleteDestinationFirst bool
+	// ProgressInterval is the number of keys copied between calls of Progress. Defaults to
+	// BatchSize.
+	ProgressInterval int
+	// Progress, if set, is called with the number of keys and bytes copied so far every
+	// ProgressInterval keys, once they are written, and with the totals at the end of the copy
+	// unless they were just reported.
+	Progress func(copied, bytes int64)
+}
+
+// CopyReport describes the progress of CopyDB.
+type CopyReport struct {
+	// Keys is the number of copied keys.
+	Keys int64
+	// Bytes is the total size of the copied keys and values.
+	Bytes int64
+	// Duration is the time taken, including the deletes of DeleteDestinationFirst.
+	Duration time.Duration
+}
+
+// CopyDB copies the keys of src to dst, e.g. to migrate between backends. The keys are read with
+// a single iterator and written in batches, so src may be larger than memory. dst must not be src,
+// and should not be written concurrently. On failure, the returned report tells how many keys
+// were written; copying again from the start is safe.
+func CopyDB(src, dst DB, opts CopyOptions) (report CopyReport, err error) {
+	began := time.Now()
+	defer func() { report.Duration = time.Since(began) }()
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCopyBatchSize
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = batchSize
+	}
+
+	start, end := prefixDomain(opts.Prefix)
+	if opts.DeleteDestinationFirst {
+		if _, err := PruneRange(dst, start, end, PruneOptions{BatchSize: batchSize}); err != nil {
+			return report, err
+		}
+	}
+
+	itr, err := src.Iterator(start, end)
+	if err != nil {
+		return report, err
+	}
+	defer itr.Close()
+
+	var (
+		batch   Batch
+		pending int
+		size    int64
+		// reported is the number of keys copied at the last call of Progress, if any.
+		reported int64
+		called   bool
+	)
+	defer func() {
+		if batch != nil {
+			batch.Close()
+		}
+	}()
+	write := func() error {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Close()
+		batch = nil
+		report.Keys += int64(pending)
+		report.Bytes += size
+		pending, size = 0, 0
+		if opts.Progress != nil && report.Keys-reported >= int64(interval) {
+			opts.Progress(report.Keys, report.Bytes)
+			reported, called = report.Keys, true
+		}
+		return nil
+	}
+
+	for ; itr.Valid(); itr.Next() {
+		if batch == nil {
+			batch = NewBatchWithSize(dst, batchSize)
+		}
+		key, value := itr.Key(), itr.Value()
+		if err := batch.Set(key, value); err != nil {
+			return report, err
+		}
+		pending++
+		size += int64(len(key) + len(value))
+		if pending == batchSize || (opts.Progress != nil && report.Keys+int64(pending)-reported >= int64(interval)) {
+			if err := write(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return report, err
+	}
+	if batch != nil {
+		if err := write(); err != nil {
+			return report, err
+		}
+	}
+	if opts.Progress != nil && (!called || report.Keys != reported) {
+		opts.Progress(report.Keys, report.Bytes)
+	}
+	return report, nil
+}
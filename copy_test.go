@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkCopyDB copies src, which must have keys with the prefixes a/ and b/ and no key with the
+// prefix c/, into a memdb database, and checks the copies of the whole database and of a prefix.
+func checkCopyDB(t *testing.T, src DB) {
+	var progress [][2]int64
+	dst := NewMemDB()
+	report, err := CopyDB(src, dst, CopyOptions{
+		BatchSize: 7,
+		Progress:  func(copied, bytes int64) { progress = append(progress, [2]int64{copied, bytes}) },
+	})
+	require.NoError(t, err)
+	checkSameIteration(t, src, dst, nil, nil)
+	var size int64
+	for key, value := range collectAll(t, src) {
+		size += int64(len(key) + len(value))
+	}
+	require.EqualValues(t, len(collectAll(t, src)), report.Keys)
+	require.Equal(t, size, report.Bytes)
+	require.Positive(t, report.Duration)
+	require.NotEmpty(t, progress)
+	require.Equal(t, [2]int64{report.Keys, report.Bytes}, progress[len(progress)-1])
+	for i := 1; i < len(progress); i++ {
+		require.Greater(t, progress[i][0], progress[i-1][0])
+	}
+
+	// Only the keys with the prefix are copied, and the other keys of the destination are kept
+	// unless they are in the domain of the prefix and DeleteDestinationFirst is set.
+	for _, deleteFirst := range []bool{false, true} {
+		dst := NewMemDB()
+		require.NoError(t, dst.Set(bz("a/stale"), bz("stale")))
+		require.NoError(t, dst.Set(bz("c/kept"), bz("kept")))
+		report, err := CopyDB(src, dst, CopyOptions{Prefix: bz("b/"), DeleteDestinationFirst: deleteFirst})
+		require.NoError(t, err)
+
+		want := NewMemDB()
+		require.NoError(t, want.Set(bz("a/stale"), bz("stale")))
+		require.NoError(t, want.Set(bz("c/kept"), bz("kept")))
+		itr, err := IteratePrefix(src, bz("b/"))
+		require.NoError(t, err)
+		var keys int64
+		for ; itr.Valid(); itr.Next() {
+			require.NoError(t, want.Set(itr.Key(), itr.Value()))
+			keys++
+		}
+		require.NoError(t, itr.Close())
+		require.Equal(t, keys, report.Keys)
+		checkSameIteration(t, want, dst, nil, nil)
+
+		// Keys of the destination with the prefix are deleted only with DeleteDestinationFirst.
+		require.NoError(t, dst.Set(bz("b/stale"), bz("stale")))
+		_, err = CopyDB(src, dst, CopyOptions{Prefix: bz("b/"), DeleteDestinationFirst: deleteFirst})
+		require.NoError(t, err)
+		if !deleteFirst {
+			require.NoError(t, want.Set(bz("b/stale"), bz("stale")))
+		}
+		checkSameIteration(t, want, dst, nil, nil)
+	}
+}
+
+// setCopyTestData sets n keys under each of the prefixes a/ and b/ of db.
+func setCopyTestData(t *testing.T, db DB, n int) {
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("a/%04d", i)), []byte(fmt.Sprintf("value%d", i))))
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("b/%04d", i)), []byte{}))
+	}
+}
+
+func TestCopyDB(t *testing.T) {
+	src, err := NewGoLevelDB("copy", t.TempDir())
+	require.NoError(t, err)
+	defer src.Close()
+	setCopyTestData(t, src, 50)
+	checkCopyDB(t, src)
+
+	// Progress is reported every ProgressInterval keys, and at the end.
+	var progress []int64
+	_, err = CopyDB(src, NewMemDB(), CopyOptions{
+		BatchSize:        100,
+		ProgressInterval: 30,
+		Progress:         func(copied, _ int64) { progress = append(progress, copied) },
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30, 60, 90, 100}, progress)
+
+	// An empty copy reports its totals once.
+	progress = nil
+	_, err = CopyDB(NewMemDB(), NewMemDB(), CopyOptions{Progress: func(copied, _ int64) { progress = append(progress, copied) }})
+	require.NoError(t, err)
+	require.Equal(t, []int64{0}, progress)
+}
+
+func TestCopyDBFailure(t *testing.T) {
+	src := NewMemDB()
+	setCopyTestData(t, src, 10)
+	dst := &failingBatchDB{DB: NewMemDB(), failAfter: 2}
+
+	report, err := CopyDB(src, dst, CopyOptions{BatchSize: 3})
+	require.Error(t, err)
+	require.EqualValues(t, 6, report.Keys)
+
+	// Copying again from the start completes the copy.
+	dst.failAfter = -1
+	report, err = CopyDB(src, dst, CopyOptions{BatchSize: 3})
+	require.NoError(t, err)
+	require.EqualValues(t, 20, report.Keys)
+	checkSameIteration(t, src, dst, nil, nil)
+}
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -40,41 +42,125 @@ const (
 	// MongoDBBackend represents a remote (i.e. not connected via a network
 	// or unix socket) MongoDB server.
 	MongoDBBackend BackendType = "mongodb"
+
+	// RedisDBBackend represents a remote Redis server (uses
+	// github.com/redis/go-redis)
+	//   - EXPERIMENTAL
+	//   - for ephemeral deployments, such as testnets: durability depends on
+	//     the persistence configuration of the server, see RedisDB
+	//   - use redisdb build tag (go build -tags redisdb)
+	RedisDBBackend BackendType = "redisdb"
 )
 
 type Options map[string]string
 
 type dbCreator func(options Options) (DB, error)
 
-var backends = map[BackendType]dbCreator{}
+// dbCreatorWithContext is a dbCreator which aborts construction once ctx is canceled, for backends
+// which connect to a server or otherwise block when created.
+type dbCreatorWithContext func(ctx context.Context, options Options) (DB, error)
+
+var backends = map[BackendType]dbCreatorWithContext{}
 
 func registerDBCreator(backend BackendType, creator dbCreator, force bool) {
+	registerDBCreatorWithContext(backend, func(_ context.Context, options Options) (DB, error) {
+		return creator(options)
+	}, force)
+}
+
+func registerDBCreatorWithContext(backend BackendType, creator dbCreatorWithContext, force bool) {
 	_, ok := backends[backend]
 	if !force && ok {
 		return
 	}
-	backends[backend] = creator
+	backends[backend] = func(ctx context.Context, options Options) (DB, error) {
+		db, err := creator(ctx, options)
+		if err != nil {
+			reportCreationFailure(backend, err)
+			return nil, err
+		}
+		reportCreated(backend, db)
+		return db, nil
+	}
 }
 
-// NewDB creates a new database of type backend with the given name.
+// NewDB creates a new database of type backend with the given name. The backend names and option
+// keys of tm-db configurations are accepted as well, see BackendFromLegacyName.
 func NewDB(backend BackendType, options Options) (DB, error) {
+	return NewDBWithContext(context.Background(), backend, options)
+}
+
+// NewDBWithContext is like NewDB, but aborts creating the database once ctx is canceled, e.g.
+// while connecting to a MongoDB server. The context is only used during creation. MongoDB pings
+// the server when created, so an unreachable server fails the creation once ctx is done or the
+// server selection timeout of the driver expires, whichever comes first.
+func NewDBWithContext(ctx context.Context, backend BackendType, options Options) (DB, error) {
+	backend, options, err := normalizeLegacyOptions(backend, options)
+	if err != nil {
+		reportCreationFailure(backend, err)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
 	dbCreator, ok := backends[backend]
 	if !ok {
-		keys := make([]string, 0, len(backends))
-		for k := range backends {
-			keys = append(keys, string(k))
-		}
-		return nil, fmt.Errorf("unknown db_backend %s, expected one of %v",
-			backend, strings.Join(keys, ","))
+		reportCreationFailure(backend, errUnknownBackend)
+		return nil, unknownBackendError(backend)
+	}
+
+	maxIterators, maxBatches, limited, err := quotaFromOptions(options)
+	if err != nil {
+		reportCreationFailure(backend, err)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	db, err := dbCreator(options)
+	db, err := dbCreator(ctx, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
+	if limited {
+		return NewQuotaDB(db, maxIterators, maxBatches), nil
+	}
 	return db, nil
 }
 
+// RegisteredBackends returns the backends compiled into this binary, sorted by name.
+func RegisteredBackends() []BackendType {
+	registered := make([]BackendType, 0, len(backends))
+	for backend := range backends {
+		registered = append(registered, backend)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i] < registered[j] })
+	return registered
+}
+
+// ParseBackendType returns the registered backend named s, e.g. the db_backend value of a
+// configuration file. Names are case-insensitive and surrounding whitespace is ignored. The tm-db
+// backend names, such as "leveldb" for goleveldb, are accepted as well if their backend is
+// registered, see BackendFromLegacyName.
+func ParseBackendType(s string) (BackendType, error) {
+	backend := BackendType(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := backends[backend]; ok {
+		return backend, nil
+	}
+	if legacy, err := BackendFromLegacyName(s); err == nil {
+		if _, ok := backends[legacy]; ok {
+			return legacy, nil
+		}
+	}
+	return "", unknownBackendError(BackendType(s))
+}
+
+// unknownBackendError returns an error listing the registered backends in sorted order.
+func unknownBackendError(backend BackendType) error {
+	registered := RegisteredBackends()
+	keys := make([]string, len(registered))
+	for i, k := range registered {
+		keys[i] = string(k)
+	}
+	return fmt.Errorf("unknown db_backend %s, expected one of %v",
+		backend, strings.Join(keys, ","))
+}
+
 const (
 	optionName = "name"
 	optionDir  = "dir"
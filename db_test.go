@@ -3,9 +3,12 @@ package db
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func (s *BackendTestSuite) TestDBIteratorSingleKey() {
@@ -137,3 +140,133 @@ func (s *BackendTestSuite) TestDBIteratorNonemptyBeginAfter() {
 		})
 	}
 }
+
+func (s *BackendTestSuite) TestDBIteratorMisusePolicy() {
+	defer SetIteratorMisusePolicy(PanicPolicy)
+
+	for backend := range backends {
+		s.T().Run(fmt.Sprintf("Backend %s", backend), func(t *testing.T) {
+			db, dir := s.newTempDB(t, backend)
+			defer os.RemoveAll(dir)
+
+			err := db.SetSync(bz("1"), bz("value_1"))
+			assert.NoError(t, err)
+
+			// Panic policy, the default.
+			SetIteratorMisusePolicy(PanicPolicy)
+			itr, err := db.Iterator(nil, nil)
+			assert.NoError(t, err)
+			checkNext(t, itr, false)
+			checkInvalid(t, itr)
+			assert.NoError(t, itr.Error())
+			assert.NoError(t, itr.Close())
+
+			// Error policy.
+			SetIteratorMisusePolicy(ErrorPolicy)
+			itr, err = db.Iterator(nil, nil)
+			assert.NoError(t, err)
+			checkNext(t, itr, false)
+			assert.NoError(t, itr.Error())
+
+			assert.NotPanics(t, func() {
+				assert.Nil(t, itr.Key())
+				assert.Nil(t, itr.Value())
+				itr.Next()
+			})
+			assert.Equal(t, errIteratorInvalid, itr.Error())
+			checkValid(t, itr, false)
+			assert.NoError(t, itr.Close())
+		})
+	}
+}
+
+func (s *BackendTestSuite) TestDBIteratorDomainThroughWrappers() {
+	for backend := range backends {
+		s.T().Run(fmt.Sprintf("Backend %s", backend), func(t *testing.T) {
+			db, dir := s.newTempDB(t, backend)
+			defer os.RemoveAll(dir)
+
+			// Two layers of wrappers, which both translate keys.
+			outer, err := NewAccountingDB(NewPrefixDB(NewPrefixDB(db, bz("a/")), bz("b/")), 1)
+			assert.NoError(t, err)
+
+			for _, key := range []string{"1", "2", "3"} {
+				assert.NoError(t, outer.Set(bz(key), bz("value_"+key)))
+			}
+
+			domains := [][2][]byte{
+				{nil, nil},
+				{bz("1"), nil},
+				{nil, bz("3")},
+				{bz("1"), bz("3")},
+			}
+			for _, domain := range domains {
+				itr, err := outer.Iterator(domain[0], domain[1])
+				assert.NoError(t, err)
+				checkDomain(t, itr, domain[0], domain[1])
+				assert.NoError(t, itr.Close())
+
+				ritr, err := outer.ReverseIterator(domain[0], domain[1])
+				assert.NoError(t, err)
+				checkDomain(t, ritr, domain[0], domain[1])
+				assert.NoError(t, ritr.Close())
+			}
+
+			// The underlying database reports its own key space.
+			itr, err := db.Iterator(bz("a/b/1"), bz("a/b/3"))
+			assert.NoError(t, err)
+			checkDomain(t, itr, bz("a/b/1"), bz("a/b/3"))
+			assert.NoError(t, itr.Close())
+		})
+	}
+}
+
+func TestParseBackendType(t *testing.T) {
+	testCases := map[string]BackendType{
+		"goleveldb":     GoLevelDBBackend,
+		" MemDB\n":      MemDBBackend,
+		"MONGODB":       MongoDBBackend,
+		"leveldb":       GoLevelDBBackend,
+		"\tLevelDB ":    GoLevelDBBackend,
+		"prefixmongodb": prefixMongoDBBackend,
+	}
+	for name, expected := range testCases {
+		backend, err := ParseBackendType(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, backend, name)
+	}
+
+	for _, name := range []string{"", "fsdb", "go leveldb"} {
+		_, err := ParseBackendType(name)
+		assert.ErrorContains(t, err, "unknown db_backend", name)
+	}
+
+	// tm-db names of backends which are not compiled in are rejected.
+	if _, ok := backends[RocksDBBackend]; !ok {
+		_, err := ParseBackendType("rocksdb")
+		assert.Error(t, err)
+	}
+}
+
+func TestRegisteredBackends(t *testing.T) {
+	registered := RegisteredBackends()
+	assert.Len(t, registered, len(backends))
+	assert.True(t, sort.SliceIsSorted(registered, func(i, j int) bool { return registered[i] < registered[j] }))
+	assert.Contains(t, registered, GoLevelDBBackend)
+	assert.Contains(t, registered, MemDBBackend)
+}
+
+func TestNewDBUnknownBackendError(t *testing.T) {
+	_, err := NewDB("fsdb", Options{})
+	require.Error(t, err)
+	names := make([]string, 0, len(backends))
+	for _, backend := range RegisteredBackends() {
+		names = append(names, string(backend))
+	}
+	expected := "unknown db_backend fsdb, expected one of " + strings.Join(names, ",")
+	assert.EqualError(t, err, expected)
+	for i := 0; i < 10; i++ {
+		_, again := NewDB("fsdb", Options{})
+		assert.Equal(t, err.Error(), again.Error())
+	}
+}
@@ -0,0 +1,499 @@
+// Package dbfault provides a database wrapper which injects faults and latency, for testing the
+// failure paths of database users without an external database.
+package dbfault
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// Op is an operation intercepted by a FaultInjectingDB.
+type Op string
+
+const (
+	OpGet             Op = "get"
+	OpHas             Op = "has"
+	OpSet             Op = "set"
+	OpSetSync         Op = "set_sync"
+	OpDelete          Op = "delete"
+	OpDeleteSync      Op = "delete_sync"
+	OpIterator        Op = "iterator"
+	OpReverseIterator Op = "reverse_iterator"
+	OpIteratorNext    Op = "iterator_next"
+	OpNewBatch        Op = "new_batch"
+	OpBatchSet        Op = "batch_set"
+	OpBatchDelete     Op = "batch_delete"
+	OpBatchWrite      Op = "batch_write"
+	OpBatchWriteSync  Op = "batch_write_sync"
+)
+
+var (
+	// ReadOps are the operations which read from the database.
+	ReadOps = []Op{OpGet, OpHas, OpIterator, OpReverseIterator, OpIteratorNext}
+	// WriteOps are the operations which write to the database, including batch operations.
+	WriteOps = []Op{
+		OpSet, OpSetSync, OpDelete, OpDeleteSync,
+		OpNewBatch, OpBatchSet, OpBatchDelete, OpBatchWrite, OpBatchWriteSync,
+	}
+)
+
+var (
+	// ErrInjected is returned by failing operations of rules without an error.
+	ErrInjected = errors.New("injected fault")
+	// ErrOutage is returned by all operations during an outage.
+	ErrOutage = errors.New("injected outage")
+)
+
+// Rule describes operations to fail or delay. All conditions must hold for a rule to match.
+type Rule struct {
+	// Ops are the operations the rule applies to. If empty, it applies to all operations.
+	Ops []Op
+	// Key, if set, restricts the rule to operations on keys for which it returns true. Operations
+	// without a key (NewBatch, batch writes) do not match rules with a key predicate. For iterator
+	// operations, the key is the start of the domain and the current key respectively.
+	Key func(key []byte) bool
+	// Nth, if set, makes the rule fail only the Nth matching operation (starting from 1), rather
+	// than every one.
+	Nth int
+	// Err is the error returned by failing operations. If nil, ErrInjected is returned, unless
+	// the rule only adds latency.
+	Err error
+	// Latency is added to every matching operation, plus a random duration up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	matched int
+}
+
+// delayOnly returns whether the rule only adds latency.
+func (r *Rule) delayOnly() bool {
+	return r.Err == nil && r.Nth == 0 && (r.Latency > 0 || r.Jitter > 0)
+}
+
+func (r *Rule) matches(op Op, key []byte) bool {
+	if len(r.Ops) > 0 {
+		found := false
+		for _, o := range r.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Key != nil && (key == nil || !r.Key(key)) {
+		return false
+	}
+	return true
+}
+
+// FaultInjectingDB wraps a database and applies rules to every operation, including operations on
+// its batches and iterators.
+type FaultInjectingDB struct {
+	dbm.DB
+
+	mtx      sync.Mutex
+	rules    []*Rule
+	outageAt time.Time
+	// iteratorFailAfter is the number of keys yielded by iterators before failing, or -1.
+	iteratorFailAfter int
+	iteratorErr       error
+	now               func() time.Time
+	sleep             func(time.Duration)
+	intercepted       map[Op]int
+	injected          map[Op]int
+}
+
+var _ dbm.DB = (*FaultInjectingDB)(nil)
+
+// New wraps the database without any rules.
+func New(db dbm.DB) *FaultInjectingDB {
+	return &FaultInjectingDB{
+		DB:                db,
+		iteratorFailAfter: -1,
+		now:               time.Now,
+		sleep:             time.Sleep,
+		intercepted:       make(map[Op]int),
+		injected:          make(map[Op]int),
+	}
+}
+
+// SetClock replaces the clock used for outages and the sleep function used for latency.
+func (fdb *FaultInjectingDB) SetClock(now func() time.Time, sleep func(time.Duration)) {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	fdb.now = now
+	fdb.sleep = sleep
+}
+
+// AddRule adds a rule. Rules are evaluated in the order they were added, and the first failing
+// rule determines the error, but the latency of all matching rules is added.
+func (fdb *FaultInjectingDB) AddRule(rule Rule) {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	fdb.rules = append(fdb.rules, &rule)
+}
+
+// FailNth fails the nth operation of the given kinds (of any kind if none are given) with err.
+func (fdb *FaultInjectingDB) FailNth(n int, err error, ops ...Op) {
+	fdb.AddRule(Rule{Ops: ops, Nth: n, Err: err})
+}
+
+// FailKeys fails all operations of the given kinds (of any kind if none are given) on keys
+// matching the predicate with err.
+func (fdb *FaultInjectingDB) FailKeys(predicate func(key []byte) bool, err error, ops ...Op) {
+	fdb.AddRule(Rule{Ops: ops, Key: predicate, Err: err})
+}
+
+// FailWrites fails all write operations with err, while reads succeed.
+func (fdb *FaultInjectingDB) FailWrites(err error) {
+	fdb.AddRule(Rule{Ops: WriteOps, Err: err})
+}
+
+// FailIteratorsAfter makes iterators created from now on become invalid after yielding k keys,
+// with Error returning err (ErrInjected if nil). A negative k disables this.
+func (fdb *FaultInjectingDB) FailIteratorsAfter(k int, err error) {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	if err == nil {
+		err = ErrInjected
+	}
+	fdb.iteratorFailAfter = k
+	fdb.iteratorErr = err
+}
+
+// AddLatency delays all operations of the given kinds (of any kind if none are given) by latency
+// plus a random duration up to jitter.
+func (fdb *FaultInjectingDB) AddLatency(latency, jitter time.Duration, ops ...Op) {
+	fdb.AddRule(Rule{Ops: ops, Latency: latency, Jitter: jitter})
+}
+
+// OutageAt fails all operations with ErrOutage from the given time on.
+func (fdb *FaultInjectingDB) OutageAt(t time.Time) {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	fdb.outageAt = t
+}
+
+// Reset removes all rules and the outage, and resets the counters.
+func (fdb *FaultInjectingDB) Reset() {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	fdb.rules = nil
+	fdb.outageAt = time.Time{}
+	fdb.iteratorFailAfter = -1
+	fdb.iteratorErr = nil
+	fdb.intercepted = make(map[Op]int)
+	fdb.injected = make(map[Op]int)
+}
+
+// Intercepted returns the number of intercepted operations of the given kinds, or of all kinds if
+// none are given.
+func (fdb *FaultInjectingDB) Intercepted(ops ...Op) int {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	return count(fdb.intercepted, ops)
+}
+
+// Injected returns the number of operations of the given kinds which failed due to an injected
+// fault or outage, or of all kinds if none are given.
+func (fdb *FaultInjectingDB) Injected(ops ...Op) int {
+	fdb.mtx.Lock()
+	defer fdb.mtx.Unlock()
+	return count(fdb.injected, ops)
+}
+
+func count(counts map[Op]int, ops []Op) int {
+	n := 0
+	if len(ops) == 0 {
+		for _, c := range counts {
+			n += c
+		}
+		return n
+	}
+	for _, op := range ops {
+		n += counts[op]
+	}
+	return n
+}
+
+// TestingT is the subset of testing.TB used by the assertion helpers.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertIntercepted reports a test error unless exactly expected operations of the given kinds
+// (of any kind if none are given) were intercepted.
+func (fdb *FaultInjectingDB) AssertIntercepted(t TestingT, expected int, ops ...Op) bool {
+	t.Helper()
+	if actual := fdb.Intercepted(ops...); actual != expected {
+		t.Errorf("expected %d intercepted operations %v, got %d", expected, ops, actual)
+		return false
+	}
+	return true
+}
+
+// AssertInjected reports a test error unless exactly expected operations of the given kinds (of
+// any kind if none are given) failed due to injected faults.
+func (fdb *FaultInjectingDB) AssertInjected(t TestingT, expected int, ops ...Op) bool {
+	t.Helper()
+	if actual := fdb.Injected(ops...); actual != expected {
+		t.Errorf("expected %d injected faults in operations %v, got %d", expected, ops, actual)
+		return false
+	}
+	return true
+}
+
+// intercept applies the rules to an operation, sleeping for any latency, and returns the error
+// the operation should fail with, if any.
+func (fdb *FaultInjectingDB) intercept(op Op, key []byte) error {
+	fdb.mtx.Lock()
+	fdb.intercepted[op]++
+
+	var (
+		err   error
+		delay time.Duration
+	)
+	if !fdb.outageAt.IsZero() && !fdb.now().Before(fdb.outageAt) {
+		err = ErrOutage
+	}
+	for _, rule := range fdb.rules {
+		if !rule.matches(op, key) {
+			continue
+		}
+		rule.matched++
+		delay += rule.Latency
+		if rule.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(rule.Jitter))) //nolint:gosec
+		}
+		if err != nil || rule.delayOnly() || (rule.Nth > 0 && rule.matched != rule.Nth) {
+			continue
+		}
+		err = rule.Err
+		if err == nil {
+			err = ErrInjected
+		}
+	}
+	if err != nil {
+		fdb.injected[op]++
+	}
+	sleep := fdb.sleep
+	fdb.mtx.Unlock()
+
+	if delay > 0 {
+		sleep(delay)
+	}
+	return err
+}
+
+// Get implements DB.
+func (fdb *FaultInjectingDB) Get(key []byte) ([]byte, error) {
+	if err := fdb.intercept(OpGet, key); err != nil {
+		return nil, err
+	}
+	return fdb.DB.Get(key)
+}
+
+// Has implements DB.
+func (fdb *FaultInjectingDB) Has(key []byte) (bool, error) {
+	if err := fdb.intercept(OpHas, key); err != nil {
+		return false, err
+	}
+	return fdb.DB.Has(key)
+}
+
+// Set implements DB.
+func (fdb *FaultInjectingDB) Set(key, value []byte) error {
+	if err := fdb.intercept(OpSet, key); err != nil {
+		return err
+	}
+	return fdb.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (fdb *FaultInjectingDB) SetSync(key, value []byte) error {
+	if err := fdb.intercept(OpSetSync, key); err != nil {
+		return err
+	}
+	return fdb.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (fdb *FaultInjectingDB) Delete(key []byte) error {
+	if err := fdb.intercept(OpDelete, key); err != nil {
+		return err
+	}
+	return fdb.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (fdb *FaultInjectingDB) DeleteSync(key []byte) error {
+	if err := fdb.intercept(OpDeleteSync, key); err != nil {
+		return err
+	}
+	return fdb.DB.DeleteSync(key)
+}
+
+// Iterator implements DB.
+func (fdb *FaultInjectingDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if err := fdb.intercept(OpIterator, start); err != nil {
+		return nil, err
+	}
+	itr, err := fdb.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return fdb.newFaultIterator(itr), nil
+}
+
+// ReverseIterator implements DB.
+func (fdb *FaultInjectingDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	if err := fdb.intercept(OpReverseIterator, start); err != nil {
+		return nil, err
+	}
+	itr, err := fdb.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return fdb.newFaultIterator(itr), nil
+}
+
+// NewBatch implements DB. If NewBatch fails, the error is returned by all methods of the batch
+// except Close.
+func (fdb *FaultInjectingDB) NewBatch() dbm.Batch {
+	b := &faultBatch{Batch: fdb.DB.NewBatch(), db: fdb}
+	b.err = fdb.intercept(OpNewBatch, nil)
+	return b
+}
+
+// faultIterator applies the rules to every step of an iterator. Once a step fails, the iterator
+// becomes invalid and Error returns the failure.
+type faultIterator struct {
+	dbm.Iterator
+	db        *FaultInjectingDB
+	failAfter int
+	yielded   int
+	err       error
+}
+
+func (fdb *FaultInjectingDB) newFaultIterator(source dbm.Iterator) *faultIterator {
+	fdb.mtx.Lock()
+	itr := &faultIterator{Iterator: source, db: fdb, failAfter: fdb.iteratorFailAfter}
+	fdb.mtx.Unlock()
+
+	if source.Valid() {
+		itr.yielded = 1
+		itr.checkFailAfter()
+	}
+	return itr
+}
+
+// checkFailAfter fails the iterator if it has yielded more keys than allowed.
+func (itr *faultIterator) checkFailAfter() {
+	if itr.failAfter < 0 || itr.yielded <= itr.failAfter || !itr.Iterator.Valid() {
+		return
+	}
+	itr.db.mtx.Lock()
+	itr.err = itr.db.iteratorErr
+	itr.db.injected[OpIteratorNext]++
+	itr.db.mtx.Unlock()
+}
+
+// Valid implements Iterator.
+func (itr *faultIterator) Valid() bool {
+	return itr.err == nil && itr.Iterator.Valid()
+}
+
+// Next implements Iterator.
+func (itr *faultIterator) Next() {
+	if itr.err != nil {
+		// The iterator has failed, and will not move again.
+		return
+	}
+	if err := itr.db.intercept(OpIteratorNext, itr.Iterator.Key()); err != nil {
+		itr.err = err
+		return
+	}
+	itr.Iterator.Next()
+	if itr.Iterator.Valid() {
+		itr.yielded++
+		itr.checkFailAfter()
+	}
+}
+
+// Key implements Iterator.
+func (itr *faultIterator) Key() []byte {
+	if itr.err != nil {
+		return nil
+	}
+	return itr.Iterator.Key()
+}
+
+// Value implements Iterator.
+func (itr *faultIterator) Value() []byte {
+	if itr.err != nil {
+		return nil
+	}
+	return itr.Iterator.Value()
+}
+
+// Error implements Iterator.
+func (itr *faultIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.Iterator.Error()
+}
+
+// faultBatch applies the rules to every batch operation.
+type faultBatch struct {
+	dbm.Batch
+	db  *FaultInjectingDB
+	err error
+}
+
+func (b *faultBatch) intercept(op Op, key []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.db.intercept(op, key)
+}
+
+// Set implements Batch.
+func (b *faultBatch) Set(key, value []byte) error {
+	if err := b.intercept(OpBatchSet, key); err != nil {
+		return err
+	}
+	return b.Batch.Set(key, value)
+}
+
+// Delete implements Batch.
+func (b *faultBatch) Delete(key []byte) error {
+	if err := b.intercept(OpBatchDelete, key); err != nil {
+		return err
+	}
+	return b.Batch.Delete(key)
+}
+
+// Write implements Batch.
+func (b *faultBatch) Write() error {
+	if err := b.intercept(OpBatchWrite, nil); err != nil {
+		return err
+	}
+	return b.Batch.Write()
+}
+
+// WriteSync implements Batch.
+func (b *faultBatch) WriteSync() error {
+	if err := b.intercept(OpBatchWriteSync, nil); err != nil {
+		return err
+	}
+	return b.Batch.WriteSync()
+}
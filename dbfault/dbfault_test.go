@@ -0,0 +1,167 @@
+package dbfault
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+var errTest = errors.New("test error")
+
+func newTestDB(t *testing.T, keys ...string) *FaultInjectingDB {
+	mdb := dbm.NewMemDB()
+	for _, key := range keys {
+		require.NoError(t, mdb.Set([]byte(key), []byte(key)))
+	}
+	return New(mdb)
+}
+
+func TestFailNth(t *testing.T) {
+	fdb := newTestDB(t, "a")
+	fdb.FailNth(2, errTest, OpGet)
+
+	_, err := fdb.Get([]byte("a"))
+	require.NoError(t, err)
+	_, err = fdb.Has([]byte("a"))
+	require.NoError(t, err)
+	_, err = fdb.Get([]byte("a"))
+	require.ErrorIs(t, err, errTest)
+	_, err = fdb.Get([]byte("a"))
+	require.NoError(t, err)
+
+	fdb.AssertIntercepted(t, 3, OpGet)
+	fdb.AssertIntercepted(t, 4)
+	fdb.AssertInjected(t, 1)
+}
+
+func TestFailKeys(t *testing.T) {
+	fdb := newTestDB(t)
+	fdb.FailKeys(func(key []byte) bool { return key[0] == 'x' }, nil)
+
+	require.NoError(t, fdb.Set([]byte("a"), []byte("a")))
+	require.ErrorIs(t, fdb.Set([]byte("x"), []byte("x")), ErrInjected)
+	require.ErrorIs(t, fdb.Delete([]byte("x")), ErrInjected)
+
+	batch := fdb.NewBatch()
+	defer batch.Close()
+	require.NoError(t, batch.Set([]byte("b"), []byte("b")))
+	require.ErrorIs(t, batch.Set([]byte("xb"), []byte("b")), ErrInjected)
+	require.NoError(t, batch.Write())
+
+	fdb.AssertInjected(t, 3)
+	fdb.AssertInjected(t, 1, OpBatchSet)
+}
+
+func TestFailWrites(t *testing.T) {
+	fdb := newTestDB(t, "a")
+	fdb.FailWrites(errTest)
+
+	value, err := fdb.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), value)
+	require.ErrorIs(t, fdb.Set([]byte("b"), []byte("b")), errTest)
+	require.ErrorIs(t, fdb.DeleteSync([]byte("a")), errTest)
+
+	// A batch which failed to be created fails all operations.
+	batch := fdb.NewBatch()
+	require.ErrorIs(t, batch.Set([]byte("b"), []byte("b")), errTest)
+	require.ErrorIs(t, batch.WriteSync(), errTest)
+	require.NoError(t, batch.Close())
+
+	fdb.AssertIntercepted(t, 3, WriteOps...)
+}
+
+func TestFailIteratorsAfter(t *testing.T) {
+	fdb := newTestDB(t, "a", "b", "c", "d")
+	fdb.FailIteratorsAfter(2, errTest)
+
+	for _, reverse := range []bool{false, true} {
+		var (
+			itr dbm.Iterator
+			err error
+		)
+		if reverse {
+			itr, err = fdb.ReverseIterator(nil, nil)
+		} else {
+			itr, err = fdb.Iterator(nil, nil)
+		}
+		require.NoError(t, err)
+		keys := 0
+		for ; itr.Valid(); itr.Next() {
+			keys++
+		}
+		require.Equal(t, 2, keys)
+		require.ErrorIs(t, itr.Error(), errTest)
+		require.Nil(t, itr.Key())
+		require.NoError(t, itr.Close())
+	}
+
+	// Iterators over fewer keys are not affected.
+	itr, err := fdb.Iterator([]byte("c"), nil)
+	require.NoError(t, err)
+	for ; itr.Valid(); itr.Next() {
+	}
+	require.NoError(t, itr.Error())
+	require.NoError(t, itr.Close())
+	fdb.AssertInjected(t, 2, OpIteratorNext)
+}
+
+func TestIteratorNextRule(t *testing.T) {
+	fdb := newTestDB(t, "a", "b", "c")
+	fdb.FailKeys(func(key []byte) bool { return string(key) == "b" }, errTest, OpIteratorNext)
+
+	itr, err := fdb.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+	keys := []string{}
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.Equal(t, []string{"a", "b"}, keys)
+	require.ErrorIs(t, itr.Error(), errTest)
+}
+
+func TestLatency(t *testing.T) {
+	fdb := newTestDB(t, "a")
+	var slept []time.Duration
+	fdb.SetClock(time.Now, func(d time.Duration) { slept = append(slept, d) })
+	fdb.AddLatency(10*time.Millisecond, 0, OpGet)
+	fdb.AddLatency(5*time.Millisecond, 5*time.Millisecond, OpGet)
+
+	_, err := fdb.Get([]byte("a"))
+	require.NoError(t, err)
+	_, err = fdb.Has([]byte("a"))
+	require.NoError(t, err)
+
+	require.Len(t, slept, 1)
+	require.GreaterOrEqual(t, slept[0], 15*time.Millisecond)
+	require.Less(t, slept[0], 20*time.Millisecond)
+	fdb.AssertInjected(t, 0)
+}
+
+func TestOutageAt(t *testing.T) {
+	fdb := newTestDB(t, "a")
+	now := time.Unix(100, 0)
+	fdb.SetClock(func() time.Time { return now }, time.Sleep)
+	fdb.OutageAt(time.Unix(200, 0))
+
+	_, err := fdb.Get([]byte("a"))
+	require.NoError(t, err)
+
+	now = time.Unix(200, 0)
+	_, err = fdb.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrOutage)
+	_, err = fdb.Iterator(nil, nil)
+	require.ErrorIs(t, err, ErrOutage)
+	require.ErrorIs(t, fdb.Set([]byte("a"), []byte("a")), ErrOutage)
+	fdb.AssertInjected(t, 3)
+
+	fdb.Reset()
+	_, err = fdb.Get([]byte("a"))
+	require.NoError(t, err)
+	fdb.AssertIntercepted(t, 1)
+}
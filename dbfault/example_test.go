@@ -0,0 +1,37 @@
+package dbfault_test
+
+import (
+	"errors"
+	"fmt"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft-db/dbfault"
+)
+
+// saveHeight is an example of database-using code whose failure path is under test.
+func saveHeight(db dbm.DB, height int) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set([]byte("height"), []byte(fmt.Sprint(height))); err != nil {
+		return fmt.Errorf("failed to save height: %w", err)
+	}
+	return batch.WriteSync()
+}
+
+func Example() {
+	fdb := dbfault.New(dbm.NewMemDB())
+	errDiskFull := errors.New("disk full")
+	fdb.FailNth(2, errDiskFull, dbfault.OpBatchWriteSync)
+
+	fmt.Println(saveHeight(fdb, 1))
+	fmt.Println(saveHeight(fdb, 2))
+	fmt.Println(fdb.Intercepted(dbfault.OpBatchWriteSync), fdb.Injected())
+
+	height, _ := fdb.Get([]byte("height"))
+	fmt.Println(string(height))
+	// Output:
+	// <nil>
+	// disk full
+	// 2 1
+	// 1
+}
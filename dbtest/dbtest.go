@@ -0,0 +1,434 @@
+// Package dbtest provides the conformance tests which all backends of this repository pass, so
+// that implementations of db.DB outside of it can be checked against the same behavior.
+//
+//	func TestConformance(t *testing.T) {
+//		dbtest.Run(t, func(t *testing.T) dbm.DB {
+//			return newMyDB(t)
+//		})
+//	}
+package dbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// Run runs the conformance tests against the databases returned by newDB, each as a subtest of t.
+// newDB is called once per subtest and must return a new, empty database, which Run closes at the
+// end of the subtest. Files or other resources of the database should be removed with t.Cleanup.
+//
+// The tests cover the semantics of Get, Has, Set and Delete and their sync variants, the errors
+// for empty keys and nil values, the bounds, domains and order of forward, reverse and prefix
+// iterators, the panics of exhausted iterators, batches, and that Close is idempotent. They do not
+// cover concurrency, durability or optional interfaces.
+func Run(t *testing.T, newDB func(t *testing.T) dbm.DB) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, db dbm.DB)
+	}{
+		{"GetSetDelete", testGetSetDelete},
+		{"EmptyKey", testEmptyKey},
+		{"NilValue", testNilValue},
+		{"Iterator", testIterator},
+		{"ReverseIterator", testReverseIterator},
+		{"EmptyIterator", testEmptyIterator},
+		{"PrefixIterator", testPrefixIterator},
+		{"Batch", func(t *testing.T, db dbm.DB) { testBatch(t, db, db.NewBatch) }},
+		{"BatchWithSize", func(t *testing.T, db dbm.DB) {
+			testBatch(t, db, func() dbm.Batch { return dbm.NewBatchWithSize(db, 16) })
+		}},
+		{"BatchClose", testBatchClose},
+		{"Close", testClose},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newDB(t)
+			require.NotNil(t, db, "newDB returned nil")
+			defer func() {
+				assert.NoError(t, db.Close(), "Close of the database")
+			}()
+			tc.test(t, db)
+		})
+	}
+}
+
+func testGetSetDelete(t *testing.T, db dbm.DB) {
+	value, err := db.Get([]byte("a"))
+	require.NoError(t, err, "Get of a missing key")
+	require.Nil(t, value, "Get of a missing key must return nil")
+	ok, err := db.Has([]byte("a"))
+	require.NoError(t, err, "Has of a missing key")
+	require.False(t, ok, "Has of a missing key must return false")
+
+	require.NoError(t, db.Set([]byte("a"), []byte{0x01}), "Set")
+	ok, err = db.Has([]byte("a"))
+	require.NoError(t, err, "Has after Set")
+	require.True(t, ok, "Has after Set must return true")
+	requireValue(t, db, []byte("a"), []byte{0x01}, "Get after Set")
+
+	require.NoError(t, db.SetSync([]byte("b"), []byte{0x02}), "SetSync")
+	requireValue(t, db, []byte("b"), []byte{0x02}, "Get after SetSync")
+
+	require.NoError(t, db.Set([]byte("a"), []byte{0x03}), "Set of an existing key")
+	requireValue(t, db, []byte("a"), []byte{0x03}, "Get after overwriting Set")
+
+	require.NoError(t, db.Set([]byte("c"), []byte{0x04}), "Set")
+
+	require.NoError(t, db.Delete([]byte("x")), "Delete of a missing key must succeed")
+	require.NoError(t, db.DeleteSync([]byte("x")), "DeleteSync of a missing key must succeed")
+
+	require.NoError(t, db.Delete([]byte("a")), "Delete")
+	requireValue(t, db, []byte("a"), nil, "Get after Delete")
+	ok, err = db.Has([]byte("a"))
+	require.NoError(t, err, "Has after Delete")
+	require.False(t, ok, "Has after Delete must return false")
+
+	require.NoError(t, db.DeleteSync([]byte("b")), "DeleteSync")
+	requireValue(t, db, []byte("b"), nil, "Get after DeleteSync")
+	requireValue(t, db, []byte("c"), []byte{0x04}, "Get of a key which was not deleted")
+}
+
+func testEmptyKey(t *testing.T, db dbm.DB) {
+	for _, key := range [][]byte{nil, {}} {
+		_, err := db.Get(key)
+		require.Error(t, err, "Get of key %#v must fail", key)
+		_, err = db.Has(key)
+		require.Error(t, err, "Has of key %#v must fail", key)
+		require.Error(t, db.Set(key, []byte{0x01}), "Set of key %#v must fail", key)
+		require.Error(t, db.SetSync(key, []byte{0x01}), "SetSync of key %#v must fail", key)
+		require.Error(t, db.Delete(key), "Delete of key %#v must fail", key)
+		require.Error(t, db.DeleteSync(key), "DeleteSync of key %#v must fail", key)
+	}
+	_, err := db.Iterator([]byte{}, nil)
+	require.Error(t, err, "Iterator with an empty start must fail")
+	_, err = db.Iterator(nil, []byte{})
+	require.Error(t, err, "Iterator with an empty end must fail")
+	_, err = db.ReverseIterator([]byte{}, nil)
+	require.Error(t, err, "ReverseIterator with an empty start must fail")
+	_, err = db.ReverseIterator(nil, []byte{})
+	require.Error(t, err, "ReverseIterator with an empty end must fail")
+	requireKeys(t, db, nil, nil, false, nil, "no key may be written by failed writes")
+}
+
+func testNilValue(t *testing.T, db dbm.DB) {
+	require.Error(t, db.Set([]byte("x"), nil), "Set of a nil value must fail")
+	require.Error(t, db.SetSync([]byte("x"), nil), "SetSync of a nil value must fail")
+	ok, err := db.Has([]byte("x"))
+	require.NoError(t, err, "Has")
+	require.False(t, ok, "Has must return false after a failed Set")
+
+	// Empty values are stored, and read back as empty rather than nil.
+	require.NoError(t, db.Set([]byte("x"), []byte{}), "Set of an empty value")
+	require.NoError(t, db.SetSync([]byte("y"), []byte{}), "SetSync of an empty value")
+	for _, key := range []string{"x", "y"} {
+		value, err := db.Get([]byte(key))
+		require.NoError(t, err, "Get of an empty value")
+		require.NotNil(t, value, "Get of an empty value must not return nil")
+		require.Empty(t, value, "Get of an empty value")
+		ok, err := db.Has([]byte(key))
+		require.NoError(t, err, "Has of an empty value")
+		require.True(t, ok, "Has of an empty value must return true")
+	}
+	it, err := db.Iterator(nil, nil)
+	require.NoError(t, err, "Iterator")
+	defer it.Close()
+	require.True(t, it.Valid(), "iterators must return keys with empty values")
+	require.NotNil(t, it.Value(), "iterators must return empty values as empty rather than nil")
+	require.Empty(t, it.Value(), "iterator value")
+}
+
+// iteratorKeys are the numbers of the keys set by setIteratorKeys: 0 to 9, without 6.
+var iteratorKeys = []uint64{0, 1, 2, 3, 4, 5, 7, 8, 9}
+
+// setIteratorKeys sets the keys of iteratorKeys, each with its big-endian encoding as value.
+func setIteratorKeys(t *testing.T, db dbm.DB) {
+	for _, i := range iteratorKeys {
+		require.NoError(t, db.Set(key(i), key(i)), "Set")
+	}
+}
+
+// key returns the big-endian encoding of i, so that keys sort as their numbers.
+func key(i uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, i)
+}
+
+// bound returns the key of i, or nil if i is negative.
+func bound(i int) []byte {
+	if i < 0 {
+		return nil
+	}
+	return key(uint64(i))
+}
+
+// iteratorCases are domains of setIteratorKeys with the keys within them in ascending order, where
+// -1 is an open bound.
+var iteratorCases = []struct {
+	start, end int
+	keys       []uint64
+}{
+	{-1, -1, iteratorKeys},
+	{-1, 0, nil},
+	{-1, 8, []uint64{0, 1, 2, 3, 4, 5, 7}},
+	{-1, 9, []uint64{0, 1, 2, 3, 4, 5, 7, 8}},
+	{-1, 10, iteratorKeys},
+	{0, -1, iteratorKeys},
+	{1, -1, []uint64{1, 2, 3, 4, 5, 7, 8, 9}},
+	{5, -1, []uint64{5, 7, 8, 9}},
+	{6, -1, []uint64{7, 8, 9}},
+	{10, -1, nil},
+	{2, 4, []uint64{2, 3}},
+	{4, 5, []uint64{4}},
+	{4, 6, []uint64{4, 5}},
+	{4, 7, []uint64{4, 5}},
+	{5, 6, []uint64{5}},
+	{5, 7, []uint64{5}},
+	{5, 8, []uint64{5, 7}},
+	{6, 7, nil},
+	{6, 8, []uint64{7}},
+	{7, 8, []uint64{7}},
+	{8, 9, []uint64{8}},
+	{5, 5, nil},
+}
+
+func testIterator(t *testing.T, db dbm.DB) {
+	setIteratorKeys(t, db)
+	for _, tc := range iteratorCases {
+		requireKeys(t, db, bound(tc.start), bound(tc.end), false, tc.keys,
+			"Iterator(%d, %d) must return the keys from start, inclusive, to end, exclusive, in ascending order",
+			tc.start, tc.end)
+	}
+	_, err := db.Iterator(key(4), key(2))
+	require.Error(t, err, "Iterator with start after end must fail")
+}
+
+func testReverseIterator(t *testing.T, db dbm.DB) {
+	setIteratorKeys(t, db)
+	for _, tc := range iteratorCases {
+		keys := slices.Clone(tc.keys)
+		slices.Reverse(keys)
+		requireKeys(t, db, bound(tc.start), bound(tc.end), true, keys,
+			"ReverseIterator(%d, %d) must return the keys from end, exclusive, to start, inclusive, in descending order",
+			tc.start, tc.end)
+	}
+	_, err := db.ReverseIterator(key(4), key(2))
+	require.Error(t, err, "ReverseIterator with start after end must fail")
+}
+
+func testEmptyIterator(t *testing.T, db dbm.DB) {
+	requireKeys(t, db, nil, nil, false, nil, "Iterator of an empty database must be empty")
+	requireKeys(t, db, nil, nil, true, nil, "ReverseIterator of an empty database must be empty")
+
+	// Iterators are positioned at their first key when created, and Next advances them.
+	require.NoError(t, db.Set([]byte("a"), []byte{1}), "Set")
+	require.NoError(t, db.Set([]byte("b"), []byte{2}), "Set")
+	it, err := db.Iterator(nil, nil)
+	require.NoError(t, err, "Iterator")
+	defer it.Close()
+	start, end := it.Domain()
+	require.Nil(t, start, "Domain of Iterator(nil, nil)")
+	require.Nil(t, end, "Domain of Iterator(nil, nil)")
+	require.True(t, it.Valid(), "Iterator of a non-empty database must be valid")
+	require.Equal(t, []byte("a"), it.Key(), "first key of Iterator")
+	require.Equal(t, []byte{1}, it.Value(), "first value of Iterator")
+	it.Next()
+	require.True(t, it.Valid(), "Iterator after Next")
+	require.Equal(t, []byte("b"), it.Key(), "second key of Iterator")
+	it.Next()
+	require.False(t, it.Valid(), "Iterator must be invalid after its last key")
+	require.NoError(t, it.Error(), "Error of an exhausted iterator")
+	requireExhausted(t, it, "Iterator after its last key")
+
+	it, err = db.ReverseIterator(nil, nil)
+	require.NoError(t, err, "ReverseIterator")
+	defer it.Close()
+	it.Next()
+	it.Next()
+	require.False(t, it.Valid(), "ReverseIterator must be invalid after its last key")
+	requireExhausted(t, it, "ReverseIterator after its last key")
+
+	it, err = db.Iterator([]byte("c"), nil)
+	require.NoError(t, err, "Iterator")
+	defer it.Close()
+	require.False(t, it.Valid(), "Iterator past the last key must be invalid")
+	requireExhausted(t, it, "Iterator past the last key")
+}
+
+func testPrefixIterator(t *testing.T, db dbm.DB) {
+	for _, k := range []string{"a", "b", "ba", "bb", "b\xff", "b\xff\xff", "c", "\xff", "\xff\x01"} {
+		require.NoError(t, db.Set([]byte(k), []byte(k)), "Set")
+	}
+	for _, tc := range []struct {
+		prefix string
+		keys   []string
+	}{
+		{"b", []string{"b", "ba", "bb", "b\xff", "b\xff\xff"}},
+		{"ba", []string{"ba"}},
+		{"b\xff", []string{"b\xff", "b\xff\xff"}},
+		{"\xff", []string{"\xff", "\xff\x01"}},
+		{"d", nil},
+	} {
+		it, err := dbm.IteratePrefix(db, []byte(tc.prefix))
+		require.NoError(t, err, "IteratePrefix(%q)", tc.prefix)
+		require.Equal(t, tc.keys, collectKeys(t, it),
+			"IteratePrefix(%q) must return the keys with the prefix in ascending order", tc.prefix)
+
+		it, err = dbm.IteratePrefixReverse(db, []byte(tc.prefix))
+		require.NoError(t, err, "IteratePrefixReverse(%q)", tc.prefix)
+		reversed := slices.Clone(tc.keys)
+		slices.Reverse(reversed)
+		require.Equal(t, reversed, collectKeys(t, it),
+			"IteratePrefixReverse(%q) must return the keys with the prefix in descending order", tc.prefix)
+	}
+}
+
+func testBatch(t *testing.T, db dbm.DB, newBatch func() dbm.Batch) {
+	// Writes are not visible until the batch is written.
+	batch := newBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}), "batch Set")
+	require.NoError(t, batch.Set([]byte("b"), []byte{2}), "batch Set")
+	require.NoError(t, batch.Set([]byte("c"), []byte{3}), "batch Set")
+	requireKeyValues(t, db, map[string][]byte{}, "batch writes must not be visible before Write")
+	require.NoError(t, batch.Write(), "batch Write")
+	requireKeyValues(t, db, map[string][]byte{"a": {1}, "b": {2}, "c": {3}}, "batch writes after Write")
+
+	// A written batch can only be closed.
+	require.Error(t, batch.Set([]byte("a"), []byte{9}), "Set on a written batch must fail")
+	require.Error(t, batch.Delete([]byte("a")), "Delete on a written batch must fail")
+	require.Error(t, batch.Write(), "Write of a written batch must fail")
+	require.Error(t, batch.WriteSync(), "WriteSync of a written batch must fail")
+	require.NoError(t, batch.Close(), "Close of a written batch")
+
+	// Operations apply in order.
+	batch = newBatch()
+	require.NoError(t, batch.Delete([]byte("a")), "batch Delete")
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}), "batch Set")
+	require.NoError(t, batch.Set([]byte("b"), []byte{1}), "batch Set")
+	require.NoError(t, batch.Set([]byte("b"), []byte{2}), "batch Set")
+	require.NoError(t, batch.Set([]byte("c"), []byte{3}), "batch Set")
+	require.NoError(t, batch.Delete([]byte("c")), "batch Delete")
+	require.NoError(t, batch.WriteSync(), "batch WriteSync")
+	require.NoError(t, batch.Close(), "batch Close")
+	requireKeyValues(t, db, map[string][]byte{"a": {1}, "b": {2}}, "batch operations must apply in order")
+
+	// Empty keys and nil values are rejected, without affecting the rest of the batch.
+	batch = newBatch()
+	for _, key := range [][]byte{nil, {}} {
+		require.Error(t, batch.Set(key, []byte{1}), "batch Set of key %#v must fail", key)
+		require.Error(t, batch.Delete(key), "batch Delete of key %#v must fail", key)
+	}
+	require.Error(t, batch.Set([]byte("n"), nil), "batch Set of a nil value must fail")
+	require.NoError(t, batch.Set([]byte("e"), []byte{}), "batch Set of an empty value")
+	require.NoError(t, batch.Write(), "batch Write")
+	require.NoError(t, batch.Close(), "batch Close")
+	requireKeyValues(t, db, map[string][]byte{"a": {1}, "b": {2}, "e": {}},
+		"rejected batch operations must not affect the others")
+	value, err := db.Get([]byte("e"))
+	require.NoError(t, err, "Get")
+	require.NotNil(t, value, "Get of an empty value written by a batch must not return nil")
+
+	// Empty batches can be written.
+	batch = newBatch()
+	require.NoError(t, batch.Write(), "Write of an empty batch")
+	require.NoError(t, batch.Close(), "Close of an empty batch")
+	requireKeyValues(t, db, map[string][]byte{"a": {1}, "b": {2}, "e": {}}, "after writing an empty batch")
+}
+
+func testBatchClose(t *testing.T, db dbm.DB) {
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}), "batch Set")
+	require.NoError(t, batch.Close(), "Close of an unwritten batch")
+	require.NoError(t, batch.Close(), "Close must be idempotent")
+	require.Error(t, batch.Set([]byte("a"), []byte{9}), "Set on a closed batch must fail")
+	require.Error(t, batch.Delete([]byte("a")), "Delete on a closed batch must fail")
+	require.Error(t, batch.Write(), "Write of a closed batch must fail")
+	require.Error(t, batch.WriteSync(), "WriteSync of a closed batch must fail")
+	requireKeyValues(t, db, map[string][]byte{}, "a closed batch must not be written")
+
+	batch = db.NewBatch()
+	require.NoError(t, batch.Write(), "batch Write")
+	require.NoError(t, batch.Close(), "Close of a written batch")
+	require.NoError(t, batch.Close(), "Close must be idempotent")
+}
+
+func testClose(t *testing.T, db dbm.DB) {
+	require.NoError(t, db.Set([]byte("a"), []byte{1}), "Set")
+	require.NoError(t, db.Close(), "Close")
+	require.NoError(t, db.Close(), "Close must be idempotent")
+}
+
+// requireExhausted checks that Key, Value and Next of an invalid iterator panic.
+func requireExhausted(t *testing.T, it dbm.Iterator, msg string) {
+	t.Helper()
+	require.Panics(t, func() { it.Key() }, "Key of an invalid iterator must panic: %s", msg)
+	require.Panics(t, func() { it.Value() }, "Value of an invalid iterator must panic: %s", msg)
+	require.Panics(t, func() { it.Next() }, "Next of an invalid iterator must panic: %s", msg)
+}
+
+// requireValue checks the value of key.
+func requireValue(t *testing.T, db dbm.DB, key, expected []byte, msg string) {
+	t.Helper()
+	value, err := db.Get(key)
+	require.NoError(t, err, msg)
+	require.Equal(t, expected, value, msg)
+}
+
+// requireKeys checks the keys of an iterator, created with start and end, against the numbers
+// encoded by key, that their values are the keys themselves, and that its domain is start and end.
+func requireKeys(t *testing.T, db dbm.DB, start, end []byte, reverse bool, expected []uint64, msgAndArgs ...interface{}) {
+	t.Helper()
+	var it dbm.Iterator
+	var err error
+	if reverse {
+		it, err = db.ReverseIterator(start, end)
+	} else {
+		it, err = db.Iterator(start, end)
+	}
+	require.NoError(t, err, msgAndArgs...)
+	domainStart, domainEnd := it.Domain()
+	require.Equal(t, start, domainStart, msgAndArgs...)
+	require.Equal(t, end, domainEnd, msgAndArgs...)
+	var keys []uint64
+	for ; it.Valid(); it.Next() {
+		require.Len(t, it.Key(), 8, msgAndArgs...)
+		require.True(t, bytes.Equal(it.Key(), it.Value()), msgAndArgs...)
+		keys = append(keys, binary.BigEndian.Uint64(it.Key()))
+	}
+	require.NoError(t, it.Error(), msgAndArgs...)
+	require.NoError(t, it.Close(), msgAndArgs...)
+	require.Equal(t, expected, keys, msgAndArgs...)
+}
+
+// collectKeys returns the keys of an iterator, and closes it.
+func collectKeys(t *testing.T, it dbm.Iterator) []string {
+	t.Helper()
+	var keys []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Error(), "iterator Error")
+	require.NoError(t, it.Close(), "iterator Close")
+	return keys
+}
+
+// requireKeyValues checks all keys and values of the database.
+func requireKeyValues(t *testing.T, db dbm.DB, expected map[string][]byte, msg string) {
+	t.Helper()
+	it, err := db.Iterator(nil, nil)
+	require.NoError(t, err, msg)
+	actual := make(map[string][]byte)
+	for ; it.Valid(); it.Next() {
+		actual[string(it.Key())] = it.Value()
+	}
+	require.NoError(t, it.Error(), msg)
+	require.NoError(t, it.Close(), msg)
+	require.Equal(t, expected, actual, msg)
+}
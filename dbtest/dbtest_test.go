@@ -0,0 +1,56 @@
+package dbtest_test
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft-db/dbtest"
+)
+
+func TestMemDB(t *testing.T) {
+	dbtest.Run(t, func(*testing.T) dbm.DB { return dbm.NewMemDB() })
+}
+
+func TestGoLevelDB(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) dbm.DB {
+		db, err := dbm.NewGoLevelDB("conformance", t.TempDir())
+		require.NoError(t, err)
+		return db
+	})
+}
+
+// brokenDB is a MemDB whose Delete does nothing.
+type brokenDB struct {
+	*dbm.MemDB
+}
+
+func (brokenDB) Delete([]byte) error {
+	return nil
+}
+
+// dbtestBrokenEnv makes TestBrokenDB run the conformance tests against brokenDB in a child
+// process, whose failure is expected.
+const dbtestBrokenEnv = "DBTEST_BROKEN"
+
+func TestBrokenDB(t *testing.T) {
+	if os.Getenv(dbtestBrokenEnv) != "" {
+		dbtest.Run(t, func(*testing.T) dbm.DB { return brokenDB{dbm.NewMemDB()} })
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBrokenDB$", "-test.v")
+	cmd.Env = append(os.Environ(), dbtestBrokenEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr, "the conformance tests passed for a broken database:\n%s", out)
+
+	// The failure names the subtest and the violated expectation, and the other subtests pass.
+	assert.Contains(t, string(out), "--- FAIL: TestBrokenDB/GetSetDelete")
+	assert.Contains(t, string(out), "Get after Delete")
+	assert.Contains(t, string(out), "--- PASS: TestBrokenDB/Iterator")
+}
@@ -0,0 +1,414 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Defaults of the zero fields of a WorkloadScript.
+const (
+	defaultWorkloadKeys          = 1024
+	defaultWorkloadMaxValueSize  = 32
+	defaultWorkloadMaxBatchSize  = 16
+	defaultWorkloadMaxScanLength = 64
+)
+
+// workloadPrefixes are the prefixes of the keys of workloads, so that prefix scans match several
+// keys. They include prefixes of 0x00 and 0xFF bytes, at the edges of the key order.
+var workloadPrefixes = [][]byte{[]byte("a/"), []byte("b/"), []byte("ab"), {0x00}, {0xff}, {0xff, 0xff}, {0x80}}
+
+// WorkloadScript describes a seeded random sequence of operations, see RunEquivalence. The same
+// script always produces the same operations.
+type WorkloadScript struct {
+	// Seed seeds the random generator of the keys, values and operations.
+	Seed int64
+	// Ops is the number of operations.
+	Ops int
+	// Keys is the number of distinct keys operated on. Defaults to 1024.
+	Keys int
+	// MaxValueSize is the maximum size of the values set. Defaults to 32.
+	MaxValueSize int
+	// MaxBatchSize is the maximum number of writes in a batch. Defaults to 16.
+	MaxBatchSize int
+	// MaxScanLength is the maximum number of entries compared per scan. Defaults to 64.
+	MaxScanLength int
+}
+
+// withDefaults returns the script with its zero fields set to their defaults.
+func (s WorkloadScript) withDefaults() WorkloadScript {
+	if s.Keys == 0 {
+		s.Keys = defaultWorkloadKeys
+	}
+	if s.MaxValueSize == 0 {
+		s.MaxValueSize = defaultWorkloadMaxValueSize
+	}
+	if s.MaxBatchSize == 0 {
+		s.MaxBatchSize = defaultWorkloadMaxBatchSize
+	}
+	if s.MaxScanLength == 0 {
+		s.MaxScanLength = defaultWorkloadMaxScanLength
+	}
+	return s
+}
+
+// validate returns an error if a field is invalid.
+func (s WorkloadScript) validate() error {
+	switch {
+	case s.Ops < 0:
+		return fmt.Errorf("invalid number of operations %d", s.Ops)
+	case s.Keys < 0:
+		return fmt.Errorf("invalid number of keys %d", s.Keys)
+	case s.MaxValueSize < 0:
+		return fmt.Errorf("invalid maximum value size %d", s.MaxValueSize)
+	case s.MaxBatchSize < 0:
+		return fmt.Errorf("invalid maximum batch size %d", s.MaxBatchSize)
+	case s.MaxScanLength < 0:
+		return fmt.Errorf("invalid maximum scan length %d", s.MaxScanLength)
+	}
+	return nil
+}
+
+// EquivalenceReport is the result of RunEquivalence.
+type EquivalenceReport struct {
+	// Ops is the number of operations applied to all databases, including the diverging one.
+	Ops int
+	// Reads is the number of Get and Has results compared, and Entries the number of iterated
+	// entries compared.
+	Reads   int
+	Entries int
+	// Divergence is the first operation whose results differ, or nil if all results were equal.
+	Divergence *Divergence
+}
+
+// Divergence describes an operation whose results differ between databases.
+type Divergence struct {
+	// Op is the index of the operation in the script, and Kind its kind: get, has, set, delete,
+	// batch, scan, reverse_scan or prefix_scan.
+	Op   int
+	Kind string
+	// Key is the key of the operation, the start of a scan, or the prefix of a prefix scan. End is
+	// the end of a scan.
+	Key, End []byte
+	// Writes describes the writes of a batch.
+	Writes []string
+	// Results holds the result of the operation in each database, by name.
+	Results map[string]string
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "operation %d (%s", d.Op, d.Kind)
+	switch d.Kind {
+	case workloadScan, workloadReverseScan:
+		fmt.Fprintf(&b, " [%X, %X)", d.Key, d.End)
+	default:
+		fmt.Fprintf(&b, " %X", d.Key)
+	}
+	b.WriteString(") diverged:")
+	for _, write := range d.Writes {
+		fmt.Fprintf(&b, "\n  write: %s", write)
+	}
+	names := make([]string, 0, len(d.Results))
+	for name := range d.Results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n  %s: %s", name, d.Results[name])
+	}
+	return b.String()
+}
+
+// Kinds of workload operations.
+const (
+	workloadGet         = "get"
+	workloadHas         = "has"
+	workloadSet         = "set"
+	workloadDelete      = "delete"
+	workloadBatch       = "batch"
+	workloadScan        = "scan"
+	workloadReverseScan = "reverse_scan"
+	workloadPrefixScan  = "prefix_scan"
+)
+
+// workloadKinds holds the kinds of operations, each as many times as its weight.
+var workloadKinds = []string{
+	workloadGet, workloadGet, workloadGet, workloadGet,
+	workloadHas, workloadHas,
+	workloadSet, workloadSet, workloadSet, workloadSet, workloadSet,
+	workloadDelete, workloadDelete,
+	workloadBatch, workloadBatch,
+	workloadScan, workloadScan,
+	workloadReverseScan, workloadReverseScan,
+	workloadPrefixScan,
+}
+
+// workloadOp is an operation of a workload. Batches hold their writes in batch.
+type workloadOp struct {
+	kind       string
+	key, end   []byte
+	value      []byte
+	batch      []workloadOp
+	scanLength int
+}
+
+// workload generates the operations of a script.
+type workload struct {
+	script WorkloadScript
+	rand   *rand.Rand
+	keys   [][]byte
+}
+
+func newWorkload(script WorkloadScript) *workload {
+	w := &workload{script: script, rand: rand.New(rand.NewSource(script.Seed))} //nolint:gosec // reproducible
+	seen := make(map[string]bool, script.Keys)
+	for len(w.keys) < script.Keys {
+		prefix := workloadPrefixes[w.rand.Intn(len(workloadPrefixes))]
+		key := append(cp(prefix), w.randomBytes(1+w.rand.Intn(6))...)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			w.keys = append(w.keys, key)
+		}
+	}
+	return w
+}
+
+// randomBytes returns n random bytes, biased towards the edges of the byte range.
+func (w *workload) randomBytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		switch w.rand.Intn(4) {
+		case 0:
+			b[i] = []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}[w.rand.Intn(6)]
+		default:
+			b[i] = byte(w.rand.Intn(256))
+		}
+	}
+	return b
+}
+
+// key returns a random key of the key space.
+func (w *workload) key() []byte {
+	return w.keys[w.rand.Intn(len(w.keys))]
+}
+
+// bound returns a random key of the key space, a random key which may not exist, or nil.
+func (w *workload) bound() []byte {
+	switch w.rand.Intn(5) {
+	case 0:
+		return nil
+	case 1:
+		return w.randomBytes(1 + w.rand.Intn(3))
+	default:
+		return w.key()
+	}
+}
+
+// value returns a random value. Empty values are valid, and must be read as empty rather than nil.
+func (w *workload) value() []byte {
+	return w.randomBytes(w.rand.Intn(w.script.MaxValueSize + 1))
+}
+
+// write returns a random set or delete of a batch.
+func (w *workload) write() workloadOp {
+	if w.rand.Intn(3) == 0 {
+		return workloadOp{kind: workloadDelete, key: w.key()}
+	}
+	return workloadOp{kind: workloadSet, key: w.key(), value: w.value()}
+}
+
+// next returns the next operation.
+func (w *workload) next() workloadOp {
+	op := workloadOp{kind: workloadKinds[w.rand.Intn(len(workloadKinds))]}
+	switch op.kind {
+	case workloadGet, workloadHas, workloadDelete:
+		op.key = w.key()
+	case workloadSet:
+		op.key, op.value = w.key(), w.value()
+	case workloadBatch:
+		op.batch = make([]workloadOp, 1+w.rand.Intn(max(w.script.MaxBatchSize, 1)))
+		for i := range op.batch {
+			op.batch[i] = w.write()
+		}
+	case workloadScan, workloadReverseScan:
+		op.key, op.end = w.bound(), w.bound()
+		if op.key != nil && op.end != nil && bytes.Compare(op.key, op.end) > 0 {
+			op.key, op.end = op.end, op.key
+		}
+		op.scanLength = w.script.MaxScanLength
+	case workloadPrefixScan:
+		key := w.key()
+		op.key = key[:1+w.rand.Intn(len(key))]
+		op.scanLength = w.script.MaxScanLength
+	}
+	return op
+}
+
+// workloadResult is the result of an operation on a database.
+type workloadResult struct {
+	// text describes the result, and is compared between databases.
+	text string
+	// reads and entries are the number of read results and iterated entries.
+	reads, entries int
+}
+
+// apply applies an operation to db and returns its result.
+func (op workloadOp) apply(db DB) workloadResult {
+	switch op.kind {
+	case workloadGet:
+		value, err := db.Get(op.key)
+		if err != nil {
+			return workloadResult{text: "error: " + err.Error()}
+		}
+		if value == nil {
+			return workloadResult{text: "nil", reads: 1}
+		}
+		return workloadResult{text: fmt.Sprintf("%X (%d bytes)", value, len(value)), reads: 1}
+	case workloadHas:
+		ok, err := db.Has(op.key)
+		if err != nil {
+			return workloadResult{text: "error: " + err.Error()}
+		}
+		return workloadResult{text: fmt.Sprint(ok), reads: 1}
+	case workloadSet:
+		return workloadErrorResult(db.Set(op.key, op.value))
+	case workloadDelete:
+		return workloadErrorResult(db.Delete(op.key))
+	case workloadBatch:
+		batch := db.NewBatch()
+		defer batch.Close()
+		for i, write := range op.batch {
+			var err error
+			if write.kind == workloadSet {
+				err = batch.Set(write.key, write.value)
+			} else {
+				err = batch.Delete(write.key)
+			}
+			if err != nil {
+				return workloadResult{text: fmt.Sprintf("error in write %d: %v", i, err)}
+			}
+		}
+		return workloadErrorResult(batch.Write())
+	case workloadScan:
+		itr, err := db.Iterator(op.key, op.end)
+		return scanResult(itr, err, op.scanLength)
+	case workloadReverseScan:
+		itr, err := db.ReverseIterator(op.key, op.end)
+		return scanResult(itr, err, op.scanLength)
+	case workloadPrefixScan:
+		itr, err := IteratePrefix(db, op.key)
+		return scanResult(itr, err, op.scanLength)
+	default:
+		panic(fmt.Sprintf("unknown workload operation %q", op.kind))
+	}
+}
+
+// workloadErrorResult returns the result of a write.
+func workloadErrorResult(err error) workloadResult {
+	if err != nil {
+		return workloadResult{text: "error: " + err.Error()}
+	}
+	return workloadResult{text: "ok"}
+}
+
+// scanResult returns the result of a scan of up to length entries.
+func scanResult(itr Iterator, err error, length int) workloadResult {
+	if err != nil {
+		return workloadResult{text: "error: " + err.Error()}
+	}
+	var b strings.Builder
+	var entries int
+	b.WriteByte('[')
+	for ; itr.Valid() && entries < length; itr.Next() {
+		if entries > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%X=%X", itr.Key(), itr.Value())
+		entries++
+	}
+	b.WriteByte(']')
+	if err := errors.Join(itr.Error(), itr.Close()); err != nil {
+		fmt.Fprintf(&b, " error: %v", err)
+	}
+	return workloadResult{text: b.String(), entries: entries}
+}
+
+// RunEquivalence applies the operations of script to every database, which must be empty, and
+// compares their results: the values of Gets, the results of Has, the errors of writes and
+// batches, and the entries of forward, reverse and prefix scans, up to the maximum scan length.
+// It stops at the first operation whose results differ, which is returned in the report. An error
+// is only returned if the script or databases are invalid.
+func RunEquivalence(dbs map[string]DB, script WorkloadScript) (EquivalenceReport, error) {
+	if len(dbs) < 2 {
+		return EquivalenceReport{}, fmt.Errorf("at least 2 databases are required, got %d", len(dbs))
+	}
+	if err := script.validate(); err != nil {
+		return EquivalenceReport{}, err
+	}
+	script = script.withDefaults()
+
+	names := make([]string, 0, len(dbs))
+	for name, db := range dbs {
+		itr, err := db.Iterator(nil, nil)
+		if err != nil {
+			return EquivalenceReport{}, fmt.Errorf("checking database %s: %w", name, err)
+		}
+		empty := !itr.Valid()
+		if err := errors.Join(itr.Error(), itr.Close()); err != nil {
+			return EquivalenceReport{}, fmt.Errorf("checking database %s: %w", name, err)
+		}
+		if !empty {
+			return EquivalenceReport{}, fmt.Errorf("database %s is not empty", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var report EquivalenceReport
+	w := newWorkload(script)
+	for i := 0; i < script.Ops; i++ {
+		op := w.next()
+		results := make(map[string]string, len(names))
+		var diverged bool
+		var first workloadResult
+		for j, name := range names {
+			res := op.apply(dbs[name])
+			results[name] = res.text
+			if j == 0 {
+				first = res
+			} else if res.text != first.text {
+				diverged = true
+			}
+		}
+		report.Ops++
+		if diverged {
+			report.Divergence = &Divergence{
+				Op: i, Kind: op.kind, Key: op.key, End: op.end, Writes: op.describeWrites(), Results: results,
+			}
+			return report, nil
+		}
+		report.Reads += first.reads
+		report.Entries += first.entries
+	}
+	return report, nil
+}
+
+// describeWrites describes the writes of a batch, or returns nil for other operations.
+func (op workloadOp) describeWrites() []string {
+	if op.kind != workloadBatch {
+		return nil
+	}
+	writes := make([]string, len(op.batch))
+	for i, write := range op.batch {
+		if write.kind == workloadSet {
+			writes[i] = fmt.Sprintf("set %X=%X", write.key, write.value)
+		} else {
+			writes[i] = fmt.Sprintf("delete %X", write.key)
+		}
+	}
+	return writes
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lostDeleteDB ignores deletes, to check that RunEquivalence detects divergences.
+type lostDeleteDB struct {
+	DB
+}
+
+func (db lostDeleteDB) Delete([]byte) error {
+	return nil
+}
+
+func TestRunEquivalence(t *testing.T) {
+	dir := t.TempDir()
+	leveldb, err := NewGoLevelDB("equivalence", dir)
+	require.NoError(t, err)
+	defer leveldb.Close()
+
+	script := WorkloadScript{Seed: 7, Ops: 3000, Keys: 200}
+	report, err := RunEquivalence(map[string]DB{"memdb": NewMemDB(), "goleveldb": leveldb}, script)
+	require.NoError(t, err)
+	assert.Nil(t, report.Divergence)
+	assert.Equal(t, 3000, report.Ops)
+	assert.Positive(t, report.Reads)
+	assert.Positive(t, report.Entries)
+}
+
+func TestRunEquivalenceDivergence(t *testing.T) {
+	script := WorkloadScript{Seed: 3, Ops: 1000, Keys: 20}
+	run := func() EquivalenceReport {
+		report, err := RunEquivalence(map[string]DB{"memdb": NewMemDB(), "lost": lostDeleteDB{NewMemDB()}}, script)
+		require.NoError(t, err)
+		return report
+	}
+	report := run()
+	d := report.Divergence
+	require.NotNil(t, d)
+	assert.Equal(t, d.Op+1, report.Ops)
+	assert.Contains(t, []string{workloadGet, workloadHas, workloadScan, workloadReverseScan, workloadPrefixScan}, d.Kind)
+	require.Len(t, d.Results, 2)
+	assert.NotEqual(t, d.Results["memdb"], d.Results["lost"])
+	assert.Contains(t, d.String(), "lost: ")
+
+	// The same script diverges at the same operation.
+	assert.Equal(t, report, run())
+}
+
+func TestRunEquivalenceInvalid(t *testing.T) {
+	_, err := RunEquivalence(map[string]DB{"memdb": NewMemDB()}, WorkloadScript{Ops: 1})
+	assert.Error(t, err)
+
+	_, err = RunEquivalence(map[string]DB{"a": NewMemDB(), "b": NewMemDB()}, WorkloadScript{Ops: -1})
+	assert.Error(t, err)
+
+	full := NewMemDB()
+	require.NoError(t, full.Set(bz("key"), bz("value")))
+	_, err = RunEquivalence(map[string]DB{"a": NewMemDB(), "b": full}, WorkloadScript{Ops: 1})
+	assert.ErrorContains(t, err, "database b is not empty")
+}
@@ -0,0 +1,415 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// The export format is a versioned container of set and delete records, shared by all exports:
+//
+//	header: magic (8 bytes) | version (1 byte)
+//	record: kind (1 byte) | key length (uvarint) | key | [value length (uvarint) | value]
+//	end:    exportRecordEnd (1 byte) | record count (uvarint) | checksum (4 bytes)
+//
+// Values are only present in set records. The end record guards against truncated exports, and
+// its checksum, the big-endian CRC-32C of all preceding bytes, against corrupt ones. Exports of
+// version 1 have no checksum, and are still read.
+const (
+	exportMagic   = "CBDBEXPT"
+	exportVersion = 2
+
+	// exportVersionNoChecksum is the version of exports without a checksum.
+	exportVersionNoChecksum = 1
+)
+
+// Export record kinds.
+const (
+	exportRecordEnd    byte = 0
+	exportRecordSet    byte = 1
+	exportRecordDelete byte = 2
+)
+
+// exportApplyBatchSize is the number of records applied per batch by ApplyIncremental.
+const exportApplyBatchSize = 1000
+
+var errExportTruncated = errors.New("export is truncated")
+
+// exportChecksumTable is the CRC-32C table of export checksums.
+var exportChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ExportStats describes the records in an export.
+type ExportStats struct {
+	// Sets is the number of set records.
+	Sets int64
+	// Deletes is the number of delete records.
+	Deletes int64
+}
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	// Canonical makes the export reproducible: the same data produces byte-identical exports on
+	// every backend. Keys are checked to be strictly ascending, and the SHA-256 of the export is
+	// recorded in the report.
+	Canonical bool
+}
+
+// ExportReport describes a completed export.
+type ExportReport struct {
+	ExportStats
+	// SHA256 is the hash of the exported bytes. It is only set for canonical exports.
+	SHA256 [32]byte
+}
+
+// exportWriter writes an export container.
+type exportWriter struct {
+	w *bufio.Writer
+	// crc is the checksum of the bytes flushed by w.
+	crc   hash.Hash32
+	stats ExportStats
+	buf   [binary.MaxVarintLen64]byte
+}
+
+// newExportWriter writes the export header to w.
+func newExportWriter(w io.Writer) (*exportWriter, error) {
+	crc := crc32.New(exportChecksumTable)
+	ew := &exportWriter{w: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc}
+	if _, err := ew.w.WriteString(exportMagic); err != nil {
+		return nil, err
+	}
+	if err := ew.w.WriteByte(exportVersion); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (ew *exportWriter) writeBytes(bz []byte) error {
+	n := binary.PutUvarint(ew.buf[:], uint64(len(bz)))
+	if _, err := ew.w.Write(ew.buf[:n]); err != nil {
+		return err
+	}
+	_, err := ew.w.Write(bz)
+	return err
+}
+
+// set writes a set record.
+func (ew *exportWriter) set(key, value []byte) error {
+	if err := ew.w.WriteByte(exportRecordSet); err != nil {
+		return err
+	}
+	if err := ew.writeBytes(key); err != nil {
+		return err
+	}
+	if err := ew.writeBytes(value); err != nil {
+		return err
+	}
+	ew.stats.Sets++
+	return nil
+}
+
+// delete writes a delete record.
+func (ew *exportWriter) delete(key []byte) error {
+	if err := ew.w.WriteByte(exportRecordDelete); err != nil {
+		return err
+	}
+	if err := ew.writeBytes(key); err != nil {
+		return err
+	}
+	ew.stats.Deletes++
+	return nil
+}
+
+// close writes the end record and its checksum, and flushes the writer.
+func (ew *exportWriter) close() error {
+	if err := ew.w.WriteByte(exportRecordEnd); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(ew.buf[:], uint64(ew.stats.Sets+ew.stats.Deletes))
+	if _, err := ew.w.Write(ew.buf[:n]); err != nil {
+		return err
+	}
+	if err := ew.w.Flush(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(ew.buf[:4], ew.crc.Sum32())
+	if _, err := ew.w.Write(ew.buf[:4]); err != nil {
+		return err
+	}
+	return ew.w.Flush()
+}
+
+// checksumReader is a buffered reader which computes the checksum of the bytes read.
+type checksumReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+// Read implements io.Reader.
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc.Write(p[:n])
+	return n, err
+}
+
+// ReadByte implements io.ByteReader.
+func (cr *checksumReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// exportReader reads an export container.
+type exportReader struct {
+	r       *checksumReader
+	version byte
+	stats   ExportStats
+}
+
+// newExportReader reads and checks the export header from r.
+func newExportReader(r io.Reader) (*exportReader, error) {
+	er := &exportReader{r: &checksumReader{r: bufio.NewReader(r), crc: crc32.New(exportChecksumTable)}}
+	header := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(er.r, header); err != nil {
+		return nil, fmt.Errorf("failed to read export header: %w", err)
+	}
+	if string(header[:len(exportMagic)]) != exportMagic {
+		return nil, errors.New("not an export")
+	}
+	er.version = header[len(exportMagic)]
+	if er.version != exportVersion && er.version != exportVersionNoChecksum {
+		return nil, fmt.Errorf("unsupported export version %d", er.version)
+	}
+	return er, nil
+}
+
+func (er *exportReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(er.r)
+	if err != nil {
+		return nil, err
+	}
+	bz := make([]byte, n)
+	if _, err := io.ReadFull(er.r, bz); err != nil {
+		return nil, err
+	}
+	return bz, nil
+}
+
+// next reads the next record. At the end of the export, it returns io.EOF.
+func (er *exportReader) next() (op operation, err error) {
+	defer func() {
+		if errors.Is(err, io.EOF) && op.opType != 0 || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = errExportTruncated
+		}
+	}()
+
+	kind, err := er.r.ReadByte()
+	if err != nil {
+		return op, errExportTruncated
+	}
+	switch kind {
+	case exportRecordEnd:
+		count, err := binary.ReadUvarint(er.r)
+		if err != nil {
+			return op, errExportTruncated
+		}
+		if int64(count) != er.stats.Sets+er.stats.Deletes {
+			return op, fmt.Errorf("export has %d records, expected %d", er.stats.Sets+er.stats.Deletes, count)
+		}
+		if er.version == exportVersionNoChecksum {
+			return op, io.EOF
+		}
+		expected := er.r.crc.Sum32()
+		var checksum [4]byte
+		if _, err := io.ReadFull(er.r.r, checksum[:]); err != nil {
+			return op, errExportTruncated
+		}
+		if binary.BigEndian.Uint32(checksum[:]) != expected {
+			return op, errors.New("export checksum mismatch")
+		}
+		return op, io.EOF
+	case exportRecordSet:
+		op.opType = opTypeSet
+		if op.key, err = er.readBytes(); err != nil {
+			return op, err
+		}
+		if op.value, err = er.readBytes(); err != nil {
+			return op, err
+		}
+		er.stats.Sets++
+	case exportRecordDelete:
+		op.opType = opTypeDelete
+		if op.key, err = er.readBytes(); err != nil {
+			return op, err
+		}
+		er.stats.Deletes++
+	default:
+		return op, fmt.Errorf("invalid export record kind %d", kind)
+	}
+	return op, nil
+}
+
+// ApplyIncremental replays the sets and deletes of an export onto db, in batches. Replaying an
+// export is idempotent, so a failed replay can be retried from the start.
+func ApplyIncremental(db DB, r io.Reader) (ExportStats, error) {
+	return applyExport(db, r, RestoreOptions{})
+}
+
+// RestoreOptions configures RestoreWithOptions.
+type RestoreOptions struct {
+	// ErrorOnConflict makes the restore fail with an ErrKeyExists if a key of the dump is set in
+	// the database with a different value. By default, the values of the dump overwrite them.
+	ErrorOnConflict bool
+}
+
+// Dump writes all keys of db to w, with values exactly as stored, in the export format. It is an
+// Export of the whole key space, and can be loaded with Restore.
+func Dump(db DB, w io.Writer) error {
+	_, err := Export(db, w, nil, nil, ExportOptions{})
+	return err
+}
+
+// Restore loads a dump written by Dump, or any export, into db, in batches. Keys of db which are
+// not in the dump are kept, while the values of the dump overwrite existing ones, see
+// RestoreWithOptions.
+func Restore(db DB, r io.Reader) error {
+	return RestoreWithOptions(db, r, RestoreOptions{})
+}
+
+// RestoreWithOptions is like Restore, with the given options. A truncated or corrupt dump, or a
+// conflict, fails the restore, possibly after the batches read before were written. Restoring a
+// dump is idempotent, so a failed restore can be retried from the start.
+func RestoreWithOptions(db DB, r io.Reader, opts RestoreOptions) error {
+	_, err := applyExport(db, r, opts)
+	return err
+}
+
+// applyExport applies the records of the export read from r to db, in batches of
+// exportApplyBatchSize records.
+func applyExport(db DB, r io.Reader, opts RestoreOptions) (ExportStats, error) {
+	er, err := newExportReader(r)
+	if err != nil {
+		return ExportStats{}, err
+	}
+
+	ops := make([]operation, 0, exportApplyBatchSize)
+	for {
+		op, err := er.next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return er.stats, err
+		}
+
+		ops = append(ops, op)
+		if len(ops) == exportApplyBatchSize {
+			if err := applyExportBatch(db, ops, opts); err != nil {
+				return er.stats, err
+			}
+			ops = ops[:0]
+		}
+	}
+	return er.stats, applyExportBatch(db, ops, opts)
+}
+
+// applyExportBatch writes ops to db in a batch. With opts.ErrorOnConflict, it first checks that
+// no set changes the value of an existing key.
+func applyExportBatch(db DB, ops []operation, opts RestoreOptions) error {
+	if opts.ErrorOnConflict {
+		var keys, values [][]byte
+		for _, op := range ops {
+			if op.opType == opTypeSet {
+				keys = append(keys, op.key)
+				values = append(values, op.value)
+			}
+		}
+		existing, err := GetMany(db, keys)
+		if err != nil {
+			return err
+		}
+		for i, value := range existing {
+			if value != nil && !bytes.Equal(value, values[i]) {
+				return ErrKeyExists{Key: keys[i]}
+			}
+		}
+	}
+
+	batch := NewBatchWithSize(db, len(ops))
+	defer batch.Close()
+	for _, op := range ops {
+		var err error
+		if op.opType == opTypeSet {
+			err = batch.Set(op.key, op.value)
+		} else {
+			err = batch.Delete(op.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// Export writes all keys in the domain [start, end) of db to w as set records, in ascending key
+// order, with values exactly as stored. The export format has no timestamps or other
+// backend-specific fields, so with ExportOptions.Canonical the export only depends on the data.
+// It can be loaded with ApplyIncremental.
+func Export(db DB, w io.Writer, start, end []byte, opts ExportOptions) (ExportReport, error) {
+	hash := sha256.New()
+	if opts.Canonical {
+		w = io.MultiWriter(w, hash)
+	}
+	ew, err := newExportWriter(w)
+	if err != nil {
+		return ExportReport{}, err
+	}
+
+	it, err := db.Iterator(start, end)
+	if err != nil {
+		return ExportReport{}, err
+	}
+	defer it.Close()
+
+	var last []byte
+	for ; it.Valid(); it.Next() {
+		key := it.Key()
+		if opts.Canonical {
+			if last != nil && bytes.Compare(key, last) <= 0 {
+				return ExportReport{ExportStats: ew.stats}, errIteratorOrder
+			}
+			last = append(last[:0], key...)
+		}
+		if err := ew.set(key, it.Value()); err != nil {
+			return ExportReport{ExportStats: ew.stats}, err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return ExportReport{ExportStats: ew.stats}, err
+	}
+	if err := ew.close(); err != nil {
+		return ExportReport{ExportStats: ew.stats}, err
+	}
+
+	report := ExportReport{ExportStats: ew.stats}
+	if opts.Canonical {
+		copy(report.SHA256[:], hash.Sum(nil))
+	}
+	return report, nil
+}
+
+// CanonicalHash returns the SHA-256 of the canonical export of the domain [start, end) of db,
+// without writing the export anywhere.
+func CanonicalHash(db DB, start, end []byte) ([32]byte, error) {
+	report, err := Export(db, io.Discard, start, end, ExportOptions{Canonical: true})
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return report.SHA256, nil
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	ew, err := newExportWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, ew.delete([]byte("a")))
+	require.NoError(t, ew.delete([]byte("c")))
+	require.NoError(t, ew.set([]byte("b"), []byte("2")))
+	require.NoError(t, ew.set([]byte("c"), []byte{}))
+	require.NoError(t, ew.close())
+	require.Equal(t, ExportStats{Sets: 2, Deletes: 2}, ew.stats)
+	export := buf.Bytes()
+
+	db := NewMemDB()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Set([]byte("c"), []byte("3")))
+
+	stats, err := ApplyIncremental(db, bytes.NewReader(export))
+	require.NoError(t, err)
+	require.Equal(t, ExportStats{Sets: 2, Deletes: 2}, stats)
+	require.Equal(t, map[string]string{"b": "2", "c": ""}, collectAll(t, db))
+
+	// Applying again gives the same result.
+	_, err = ApplyIncremental(db, bytes.NewReader(export))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"b": "2", "c": ""}, collectAll(t, db))
+
+	// Truncated and corrupt exports are rejected.
+	for i := 0; i < len(export); i++ {
+		_, err = ApplyIncremental(NewMemDB(), bytes.NewReader(export[:i]))
+		require.Error(t, err, "truncated at %d", i)
+	}
+	corrupt := append([]byte{}, export...)
+	corrupt[len(exportMagic)] = exportVersion + 1
+	_, err = ApplyIncremental(NewMemDB(), bytes.NewReader(corrupt))
+	require.ErrorContains(t, err, "unsupported export version")
+	_, err = ApplyIncremental(NewMemDB(), bytes.NewReader([]byte("not an export at all")))
+	require.ErrorContains(t, err, "not an export")
+}
+
+func TestApplyIncrementalBatches(t *testing.T) {
+	var buf bytes.Buffer
+	ew, err := newExportWriter(&buf)
+	require.NoError(t, err)
+	n := exportApplyBatchSize*2 + 7
+	for i := 0; i < n; i++ {
+		require.NoError(t, ew.set(int642Bytes(int64(i)), []byte{byte(i)}))
+	}
+	require.NoError(t, ew.close())
+
+	db := NewMemDB()
+	stats, err := ApplyIncremental(db, &buf)
+	require.NoError(t, err)
+	require.EqualValues(t, n, stats.Sets)
+	require.Len(t, collectAll(t, db), n)
+}
+
+// collectAll returns all key/value pairs in db.
+func collectAll(t *testing.T, db DB) map[string]string {
+	t.Helper()
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+
+	kvs := make(map[string]string)
+	for ; itr.Valid(); itr.Next() {
+		kvs[string(itr.Key())] = string(itr.Value())
+	}
+	require.NoError(t, itr.Error())
+	return kvs
+}
+
+func TestCanonicalExport(t *testing.T) {
+	memDB := NewMemDB()
+	levelDB, err := NewGoLevelDB("canonical", t.TempDir())
+	require.NoError(t, err)
+	defer levelDB.Close()
+	for _, db := range []DB{memDB, levelDB} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Set(int642Bytes(int64(i)), []byte(fmt.Sprintf("value%d", i))))
+		}
+		require.NoError(t, db.Set([]byte("empty"), []byte{}))
+	}
+
+	var buf bytes.Buffer
+	report, err := Export(memDB, &buf, nil, nil, ExportOptions{Canonical: true})
+	require.NoError(t, err)
+	require.Equal(t, ExportStats{Sets: 101}, report.ExportStats)
+	require.Equal(t, sha256.Sum256(buf.Bytes()), report.SHA256)
+
+	// The same data produces the same hash on every backend.
+	hash, err := CanonicalHash(levelDB, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, report.SHA256, hash)
+
+	// The export can be loaded into another database.
+	copied := NewMemDB()
+	_, err = ApplyIncremental(copied, &buf)
+	require.NoError(t, err)
+	require.Equal(t, collectAll(t, memDB), collectAll(t, copied))
+	hash, err = CanonicalHash(copied, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, report.SHA256, hash)
+
+	// Changing a single value changes the hash.
+	require.NoError(t, levelDB.Set(int642Bytes(50), []byte("changed")))
+	hash, err = CanonicalHash(levelDB, nil, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, report.SHA256, hash)
+
+	// Hashes cover only the given domain.
+	hash, err = CanonicalHash(levelDB, int642Bytes(0), int642Bytes(50))
+	require.NoError(t, err)
+	expected, err := CanonicalHash(memDB, int642Bytes(0), int642Bytes(50))
+	require.NoError(t, err)
+	require.Equal(t, expected, hash)
+
+	// Non-canonical exports have no hash.
+	report, err = Export(memDB, io.Discard, nil, nil, ExportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, [32]byte{}, report.SHA256)
+}
+
+// setRandomEntries sets n random keys of db, with random values which are empty for some keys.
+func setRandomEntries(t *testing.T, db DB, n int) {
+	t.Helper()
+	r := rand.New(rand.NewSource(1)) //nolint:gosec
+	batch := db.NewBatch()
+	for i := 0; i < n; i++ {
+		key := make([]byte, 1+r.Intn(32))
+		value := make([]byte, r.Intn(4)*r.Intn(64))
+		r.Read(key)
+		r.Read(value)
+		require.NoError(t, batch.Set(key, value))
+		if (i+1)%exportApplyBatchSize == 0 {
+			require.NoError(t, batch.Write())
+			require.NoError(t, batch.Close())
+			batch = db.NewBatch()
+		}
+	}
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+}
+
+// checkDumpRestore dumps n random entries of a memdb database, restores them into db, dumps db
+// and restores that into another memdb database, and checks that all of them iterate identically.
+func checkDumpRestore(t *testing.T, db DB, n int) {
+	src := NewMemDB()
+	setRandomEntries(t, src, n)
+
+	var dump bytes.Buffer
+	require.NoError(t, Dump(src, &dump))
+	require.NoError(t, Restore(db, &dump))
+	checkSameIteration(t, src, db, nil, nil)
+
+	dump.Reset()
+	require.NoError(t, Dump(db, &dump))
+	dst := NewMemDB()
+	require.NoError(t, Restore(dst, &dump))
+	checkSameIteration(t, src, dst, nil, nil)
+}
+
+func TestDumpRestore(t *testing.T) {
+	db, err := NewGoLevelDB("dump", t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+	checkDumpRestore(t, db, 50000)
+}
+
+func TestRestoreConflict(t *testing.T) {
+	src := NewMemDB()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, src.Set([]byte(key), []byte("dump")))
+	}
+	var dump bytes.Buffer
+	require.NoError(t, Dump(src, &dump))
+
+	// Existing keys are overwritten by default, and other keys are kept.
+	db := NewMemDB()
+	require.NoError(t, db.Set([]byte("b"), []byte("old")))
+	require.NoError(t, db.Set([]byte("d"), []byte("old")))
+	require.NoError(t, Restore(db, bytes.NewReader(dump.Bytes())))
+	require.Equal(t, map[string]string{"a": "dump", "b": "dump", "c": "dump", "d": "old"}, collectAll(t, db))
+
+	// Keys with the same value are not conflicts.
+	opts := RestoreOptions{ErrorOnConflict: true}
+	require.NoError(t, RestoreWithOptions(db, bytes.NewReader(dump.Bytes()), opts))
+
+	db = NewMemDB()
+	require.NoError(t, db.Set([]byte("b"), []byte("old")))
+	err := RestoreWithOptions(db, bytes.NewReader(dump.Bytes()), opts)
+	require.Equal(t, ErrKeyExists{Key: []byte("b")}, err)
+	require.Equal(t, map[string]string{"b": "old"}, collectAll(t, db))
+}
+
+func TestExportChecksum(t *testing.T) {
+	src := NewMemDB()
+	require.NoError(t, src.Set([]byte("key"), []byte("value")))
+	var buf bytes.Buffer
+	require.NoError(t, Dump(src, &buf))
+	dump := buf.Bytes()
+
+	// A corrupt value is detected by the checksum.
+	corrupt := append([]byte{}, dump...)
+	corrupt[bytes.Index(corrupt, []byte("value"))] = 'V'
+	err := Restore(NewMemDB(), bytes.NewReader(corrupt))
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	// Exports of version 1 have no checksum.
+	v1 := append([]byte{}, dump[:len(dump)-4]...)
+	v1[len(exportMagic)] = exportVersionNoChecksum
+	db := NewMemDB()
+	require.NoError(t, Restore(db, bytes.NewReader(v1)))
+	checkSameIteration(t, src, db, nil, nil)
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// expvarPrefix is the prefix of the names of the variables published by ExpvarDB.
+const expvarPrefix = "cometbftdb."
+
+// Operations counted by ExpvarDB.
+const (
+	expvarOpGet             = "get"
+	expvarOpHas             = "has"
+	expvarOpSet             = "set"
+	expvarOpSetSync         = "set_sync"
+	expvarOpDelete          = "delete"
+	expvarOpDeleteSync      = "delete_sync"
+	expvarOpIterator        = "iterator"
+	expvarOpReverseIterator = "reverse_iterator"
+	expvarOpBatchWrite      = "batch_write"
+	expvarOpBatchWriteSync  = "batch_write_sync"
+)
+
+// expvarMtx serializes the lookup and publication of variables.
+var expvarMtx sync.Mutex
+
+// expvarMap returns the published map with the given name, publishing it if needed.
+func expvarMap(name string) *expvar.Map {
+	expvarMtx.Lock()
+	defer expvarMtx.Unlock()
+	if v := expvar.Get(name); v != nil {
+		m, ok := v.(*expvar.Map)
+		if !ok {
+			panic(fmt.Sprintf("expvar %s is already published as %T", name, v))
+		}
+		return m
+	}
+	return expvar.NewMap(name)
+}
+
+// expvarInt returns the published integer with the given name, publishing it if needed.
+func expvarInt(name string) *expvar.Int {
+	expvarMtx.Lock()
+	defer expvarMtx.Unlock()
+	if v := expvar.Get(name); v != nil {
+		i, ok := v.(*expvar.Int)
+		if !ok {
+			panic(fmt.Sprintf("expvar %s is already published as %T", name, v))
+		}
+		return i
+	}
+	return expvar.NewInt(name)
+}
+
+// ExpvarDB wraps a database and publishes operation metrics as expvar variables, for setups
+// without Prometheus. With the name given to NewExpvarDB, the variables are:
+//
+//   - cometbftdb.<name>.ops: the number of operations, per operation
+//   - cometbftdb.<name>.errors: the number of failed operations, per operation
+//   - cometbftdb.<name>.latency_ns: the cumulative latency of operations in nanoseconds, per
+//     operation
+//   - cometbftdb.<name>.open_iterators: the number of open iterators
+//   - cometbftdb.<name>.open_batches: the number of open batches
+//
+// Operations are get, has, set, set_sync, delete, delete_sync, iterator and reverse_iterator (the
+// creation of iterators), and batch_write and batch_write_sync. Databases with distinct names
+// publish distinct variables, while databases with the same name share them, e.g. when a database
+// is reopened.
+type ExpvarDB struct {
+	DB
+
+	ops           *expvar.Map
+	errors        *expvar.Map
+	latency       *expvar.Map
+	openIterators *expvar.Int
+	openBatches   *expvar.Int
+}
+
+var _ DB = (*ExpvarDB)(nil)
+
+// NewExpvarDB wraps db, publishing its metrics under cometbftdb.<name>. It panics if one of the
+// variables is already published with a different type.
+func NewExpvarDB(db DB, name string) DB {
+	prefix := expvarPrefix + name + "."
+	return &ExpvarDB{
+		DB:            db,
+		ops:           expvarMap(prefix + "ops"),
+		errors:        expvarMap(prefix + "errors"),
+		latency:       expvarMap(prefix + "latency_ns"),
+		openIterators: expvarInt(prefix + "open_iterators"),
+		openBatches:   expvarInt(prefix + "open_batches"),
+	}
+}
+
+// observe records an operation which started at start and returned err.
+func (edb *ExpvarDB) observe(op string, start time.Time, err error) {
+	edb.ops.Add(op, 1)
+	edb.latency.Add(op, int64(time.Since(start)))
+	if err != nil {
+		edb.errors.Add(op, 1)
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (edb *ExpvarDB) Unwrap() DB {
+	return edb.DB
+}
+
+// Get implements DB.
+func (edb *ExpvarDB) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := edb.DB.Get(key)
+	edb.observe(expvarOpGet, start, err)
+	return value, err
+}
+
+// Has implements DB.
+func (edb *ExpvarDB) Has(key []byte) (bool, error) {
+	start := time.Now()
+	ok, err := edb.DB.Has(key)
+	edb.observe(expvarOpHas, start, err)
+	return ok, err
+}
+
+// Set implements DB.
+func (edb *ExpvarDB) Set(key, value []byte) error {
+	start := time.Now()
+	err := edb.DB.Set(key, value)
+	edb.observe(expvarOpSet, start, err)
+	return err
+}
+
+// SetSync implements DB.
+func (edb *ExpvarDB) SetSync(key, value []byte) error {
+	start := time.Now()
+	err := edb.DB.SetSync(key, value)
+	edb.observe(expvarOpSetSync, start, err)
+	return err
+}
+
+// Delete implements DB.
+func (edb *ExpvarDB) Delete(key []byte) error {
+	start := time.Now()
+	err := edb.DB.Delete(key)
+	edb.observe(expvarOpDelete, start, err)
+	return err
+}
+
+// DeleteSync implements DB.
+func (edb *ExpvarDB) DeleteSync(key []byte) error {
+	start := time.Now()
+	err := edb.DB.DeleteSync(key)
+	edb.observe(expvarOpDeleteSync, start, err)
+	return err
+}
+
+// Iterator implements DB.
+func (edb *ExpvarDB) Iterator(start, end []byte) (Iterator, error) {
+	now := time.Now()
+	itr, err := edb.DB.Iterator(start, end)
+	edb.observe(expvarOpIterator, now, err)
+	if err != nil {
+		return nil, err
+	}
+	return edb.newIterator(itr), nil
+}
+
+// ReverseIterator implements DB.
+func (edb *ExpvarDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	now := time.Now()
+	itr, err := edb.DB.ReverseIterator(start, end)
+	edb.observe(expvarOpReverseIterator, now, err)
+	if err != nil {
+		return nil, err
+	}
+	return edb.newIterator(itr), nil
+}
+
+func (edb *ExpvarDB) newIterator(itr Iterator) Iterator {
+	edb.openIterators.Add(1)
+	return &expvarIterator{Iterator: itr, db: edb}
+}
+
+// NewBatch implements DB.
+func (edb *ExpvarDB) NewBatch() Batch {
+	edb.openBatches.Add(1)
+	return &expvarBatch{Batch: edb.DB.NewBatch(), db: edb}
+}
+
+// expvarIterator keeps the open iterator gauge of an ExpvarDB up to date.
+type expvarIterator struct {
+	Iterator
+
+	db     *ExpvarDB
+	closed bool
+}
+
+// Close implements Iterator.
+func (itr *expvarIterator) Close() error {
+	if !itr.closed {
+		itr.closed = true
+		itr.db.openIterators.Add(-1)
+	}
+	return itr.Iterator.Close()
+}
+
+// expvarBatch counts the writes of a batch, and keeps the open batch gauge of an ExpvarDB up to
+// date.
+type expvarBatch struct {
+	Batch
+
+	db     *ExpvarDB
+	closed bool
+}
+
+// Write implements Batch.
+func (b *expvarBatch) Write() error {
+	start := time.Now()
+	err := b.Batch.Write()
+	b.db.observe(expvarOpBatchWrite, start, err)
+	return err
+}
+
+// WriteSync implements Batch.
+func (b *expvarBatch) WriteSync() error {
+	start := time.Now()
+	err := b.Batch.WriteSync()
+	b.db.observe(expvarOpBatchWriteSync, start, err)
+	return err
+}
+
+// Close implements Batch.
+func (b *expvarBatch) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.db.openBatches.Add(-1)
+	}
+	return b.Batch.Close()
+}
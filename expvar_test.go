@@ -0,0 +1,75 @@
+package db
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readExpvar decodes the JSON of a published variable.
+func readExpvar(t *testing.T, name string, v any) {
+	published := expvar.Get(name)
+	require.NotNil(t, published, name)
+	require.NoError(t, json.Unmarshal([]byte(published.String()), v))
+}
+
+func TestExpvarDB(t *testing.T) {
+	// Variables cannot be unpublished, so each run uses new names.
+	name := "expvar_test_" + randStr(8)
+	db := NewExpvarDB(NewMemDB(), name)
+
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.SetSync([]byte("b"), []byte("2")))
+	_, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	_, err = db.Get(nil)
+	require.Error(t, err)
+	_, err = db.Has([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, db.Delete([]byte("b")))
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	_, err = db.ReverseIterator([]byte("b"), []byte("a"))
+	require.Error(t, err)
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("c"), []byte("3")))
+	require.NoError(t, batch.Write())
+
+	var openIterators, openBatches int64
+	readExpvar(t, "cometbftdb."+name+".open_iterators", &openIterators)
+	readExpvar(t, "cometbftdb."+name+".open_batches", &openBatches)
+	require.EqualValues(t, 1, openIterators)
+	require.EqualValues(t, 1, openBatches)
+	require.NoError(t, itr.Close())
+	require.NoError(t, itr.Close())
+	require.NoError(t, batch.Close())
+
+	var ops, errs, latency map[string]int64
+	readExpvar(t, "cometbftdb."+name+".ops", &ops)
+	readExpvar(t, "cometbftdb."+name+".errors", &errs)
+	readExpvar(t, "cometbftdb."+name+".latency_ns", &latency)
+	readExpvar(t, "cometbftdb."+name+".open_iterators", &openIterators)
+	readExpvar(t, "cometbftdb."+name+".open_batches", &openBatches)
+	require.Equal(t, map[string]int64{
+		"set": 1, "set_sync": 1, "get": 2, "has": 1, "delete": 1,
+		"iterator": 1, "reverse_iterator": 1, "batch_write": 1,
+	}, ops)
+	require.Equal(t, map[string]int64{"get": 1, "reverse_iterator": 1}, errs)
+	require.Len(t, latency, len(ops))
+	require.Zero(t, openIterators)
+	require.Zero(t, openBatches)
+
+	// Another database with the same name reuses the variables, while a different name does not.
+	reopened := NewExpvarDB(NewMemDB(), name)
+	require.NoError(t, reopened.Set([]byte("a"), []byte("1")))
+	other := NewExpvarDB(NewMemDB(), name+"_other")
+	require.NoError(t, other.Set([]byte("a"), []byte("1")))
+	readExpvar(t, "cometbftdb."+name+".ops", &ops)
+	require.EqualValues(t, 2, ops["set"])
+	var otherOps map[string]int64
+	readExpvar(t, "cometbftdb."+name+"_other.ops", &otherOps)
+	require.Equal(t, map[string]int64{"set": 1}, otherOps)
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dbLockFileName is the name of the lock file taken by the flat-file backends.
+	dbLockFileName = "LOCK.cometbftdb"
+
+	// optionLockTimeout makes the flat-file backends wait up to the given duration (e.g. "5s")
+	// for a locked database, instead of failing immediately.
+	optionLockTimeout = "lock_timeout"
+
+	// dbLockPollInterval is how often a locked database is retried while waiting.
+	dbLockPollInterval = 50 * time.Millisecond
+)
+
+// errLockHeld is returned by tryLockFile if the file is locked by someone else.
+var errLockHeld = errors.New("lock held")
+
+// ErrDBLocked is returned by NewDB when a flat-file database is already open, either by another
+// process or by another DB value of the same process.
+type ErrDBLocked struct {
+	Path string
+	// HolderPID is the process ID of the lock holder, or 0 if unknown.
+	HolderPID int
+}
+
+func (e ErrDBLocked) Error() string {
+	if e.HolderPID == 0 {
+		return fmt.Sprintf("database %s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("database %s is locked by process %d", e.Path, e.HolderPID)
+}
+
+// dbLock is an exclusive advisory lock on a database. A nil dbLock is valid and locks nothing.
+type dbLock struct {
+	file *os.File
+}
+
+// lockTimeout parses the lock_timeout option.
+func lockTimeout(options Options) (time.Duration, error) {
+	value, ok := options[optionLockTimeout]
+	if !ok {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err == nil && timeout < 0 {
+		err = errors.New("must not be negative")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", optionLockTimeout, value, err)
+	}
+	return timeout, nil
+}
+
+// lockDB takes an exclusive lock on the database at path, writing the process ID into the lock
+// file. The lock file is placed inside path for directory layouts, and next to it otherwise.
+// It returns ErrDBLocked if the lock is held and the lock_timeout option, if any, expires.
+func lockDB(path string, isDir bool, options Options) (*dbLock, error) {
+	timeout, err := lockTimeout(options)
+	if err != nil {
+		return nil, err
+	}
+
+	lockPath := path + "." + dbLockFileName
+	if isDir {
+		lockPath = filepath.Join(path, dbLockFileName)
+	}
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLockFile(file)
+		if !errors.Is(err, errLockHeld) || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(dbLockPollInterval)
+	}
+	if errors.Is(err, errLockHeld) {
+		file.Close()
+		return nil, ErrDBLocked{Path: path, HolderPID: lockHolderPID(lockPath)}
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &dbLock{file: file}, nil
+}
+
+// lockHolderPID returns the process ID written into a lock file, or 0 if it cannot be read.
+func lockHolderPID(lockPath string) int {
+	bz, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bz)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// release releases the lock. The lock file is kept, since removing it could race with a waiting
+// opener which already opened it.
+func (l *dbLock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	if err := unlockFile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
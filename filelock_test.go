@@ -0,0 +1,61 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatFileDBLocked(t *testing.T) {
+	dir := t.TempDir()
+	options := Options{optionName: "locked", optionDir: dir}
+
+	db, err := NewDB(GoLevelDBBackend, options)
+	require.NoError(t, err)
+
+	_, err = NewDB(GoLevelDBBackend, options)
+	var locked ErrDBLocked
+	require.True(t, errors.As(err, &locked), "unexpected error %v", err)
+	require.Equal(t, filepath.Join(dir, "locked.db"), locked.Path)
+	require.Equal(t, os.Getpid(), locked.HolderPID)
+
+	require.NoError(t, db.Set([]byte("a"), []byte{1}))
+	require.NoError(t, db.Close())
+
+	db, err = NewDB(GoLevelDBBackend, options)
+	require.NoError(t, err)
+	value, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, value)
+	require.NoError(t, db.Close())
+}
+
+func TestFlatFileDBLockTimeout(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(GoLevelDBBackend, Options{optionName: "wait", optionDir: dir})
+	require.NoError(t, err)
+
+	// The wait gives up once the timeout expires.
+	start := time.Now()
+	_, err = NewDB(GoLevelDBBackend, Options{optionName: "wait", optionDir: dir, optionLockTimeout: "100ms"})
+	require.ErrorAs(t, err, new(ErrDBLocked))
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// The wait succeeds once the holder closes the database.
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		closed <- db.Close()
+	}()
+	db, err = NewDB(GoLevelDBBackend, Options{optionName: "wait", optionDir: dir, optionLockTimeout: "10s"})
+	require.NoError(t, err)
+	require.NoError(t, <-closed)
+	require.NoError(t, db.Close())
+
+	_, err = NewDB(GoLevelDBBackend, Options{optionName: "wait", optionDir: dir, optionLockTimeout: "soon"})
+	require.ErrorContains(t, err, `invalid lock_timeout "soon"`)
+}
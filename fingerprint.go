@@ -0,0 +1,99 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// optionForceOpen skips the data format check of the flat-file backends when set.
+const optionForceOpen = "force_open"
+
+// leveldbFamily is reported as the detected backend of goleveldb, cleveldb and rocksdb data,
+// which share a directory layout and cannot be told apart reliably.
+const leveldbFamily BackendType = "leveldb"
+
+// ErrWrongBackendForData is returned by NewDB when the data at the database path clearly belongs
+// to a different backend than the requested one. It can be overridden with the force_open option.
+type ErrWrongBackendForData struct {
+	Detected  BackendType
+	Requested BackendType
+}
+
+func (e ErrWrongBackendForData) Error() string {
+	return fmt.Sprintf("data looks like it belongs to the %s backend, but %s was requested",
+		e.Detected, e.Requested)
+}
+
+// checkDataFormat returns ErrWrongBackendForData if the data at path was written by a different
+// backend than requested. Missing paths, empty directories and unrecognized layouts pass through.
+func checkDataFormat(requested BackendType, path string, options Options) error {
+	if _, ok := options[optionForceOpen]; ok {
+		return nil
+	}
+	detected, ok, err := detectBackend(path)
+	if err != nil || !ok {
+		return err
+	}
+	if backendFamily(requested) != detected {
+		return ErrWrongBackendForData{Detected: detected, Requested: requested}
+	}
+	return nil
+}
+
+// backendFamily returns the backend as it would be reported by detectBackend.
+func backendFamily(backend BackendType) BackendType {
+	switch backend {
+	case GoLevelDBBackend, CLevelDBBackend, RocksDBBackend:
+		return leveldbFamily
+	default:
+		return backend
+	}
+}
+
+// detectBackend inspects the path for signature files of the flat-file backends.
+func detectBackend(path string) (BackendType, bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	if info.Mode().IsRegular() {
+		return BoltDBBackend, true, nil
+	}
+	if !info.IsDir() {
+		return "", false, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", false, err
+	}
+	var hasCurrent, hasManifest, hasKeyRegistry, hasBoltFile bool
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == "CURRENT":
+			hasCurrent = true
+		case name == "KEYREGISTRY":
+			hasKeyRegistry = true
+		case strings.HasPrefix(name, "MANIFEST"):
+			hasManifest = true
+		case entry.Type().IsRegular() && filepath.Ext(name) == ".db":
+			hasBoltFile = true
+		}
+	}
+
+	switch {
+	case hasKeyRegistry && hasManifest:
+		return BadgerDBBackend, true, nil
+	case hasCurrent && hasManifest:
+		return leveldbFamily, true, nil
+	case hasBoltFile && len(entries) == 1:
+		return BoltDBBackend, true, nil
+	default:
+		return "", false, nil
+	}
+}
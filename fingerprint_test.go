@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeLayout creates the given files under path. A nil file list creates path as a bolt file.
+func writeLayout(t *testing.T, path string, files []string) {
+	t.Helper()
+	if files == nil {
+		require.NoError(t, os.WriteFile(path, []byte("bolt"), 0o600))
+		return
+	}
+	require.NoError(t, os.MkdirAll(path, 0o755))
+	for _, file := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(path, file), []byte{}, 0o600))
+	}
+}
+
+func TestCheckDataFormat(t *testing.T) {
+	layouts := map[BackendType][]string{
+		leveldbFamily:   {"CURRENT", "MANIFEST-000001", "LOG", "000001.ldb"},
+		BadgerDBBackend: {"KEYREGISTRY", "MANIFEST", "000001.vlog"},
+		BoltDBBackend:   nil,
+	}
+	requested := []BackendType{
+		GoLevelDBBackend, CLevelDBBackend, RocksDBBackend, BoltDBBackend, BadgerDBBackend,
+	}
+
+	for detected, files := range layouts {
+		path := filepath.Join(t.TempDir(), "data.db")
+		writeLayout(t, path, files)
+
+		for _, backend := range requested {
+			err := checkDataFormat(backend, path, Options{})
+			if backendFamily(backend) == detected {
+				require.NoError(t, err, "%s data opened as %s", detected, backend)
+				continue
+			}
+			var wrongErr ErrWrongBackendForData
+			require.True(t, errors.As(err, &wrongErr), "%s data opened as %s", detected, backend)
+			require.Equal(t, ErrWrongBackendForData{Detected: detected, Requested: backend}, wrongErr)
+
+			require.NoError(t, checkDataFormat(backend, path, Options{optionForceOpen: "true"}))
+		}
+	}
+}
+
+func TestCheckDataFormatBoltDirectory(t *testing.T) {
+	path := t.TempDir()
+	writeLayout(t, filepath.Join(path, "data.db"), nil)
+
+	err := checkDataFormat(BadgerDBBackend, path, Options{})
+	require.Equal(t, ErrWrongBackendForData{Detected: BoltDBBackend, Requested: BadgerDBBackend}, err)
+}
+
+func TestCheckDataFormatPassThrough(t *testing.T) {
+	dir := t.TempDir()
+	for _, backend := range []BackendType{GoLevelDBBackend, BoltDBBackend, BadgerDBBackend} {
+		// Missing path.
+		require.NoError(t, checkDataFormat(backend, filepath.Join(dir, "missing"), Options{}))
+		// Empty directory.
+		require.NoError(t, checkDataFormat(backend, dir, Options{}))
+	}
+
+	// Unrecognized layout.
+	writeLayout(t, filepath.Join(dir, "other"), []string{"README", "notes.txt"})
+	require.NoError(t, checkDataFormat(GoLevelDBBackend, filepath.Join(dir, "other"), Options{}))
+}
+
+func TestNewDBWrongBackendForData(t *testing.T) {
+	dir := t.TempDir()
+	writeLayout(t, filepath.Join(dir, "test.db"), []string{"KEYREGISTRY", "MANIFEST"})
+
+	_, err := NewFlatFileDB("test", GoLevelDBBackend, dir)
+	var wrongErr ErrWrongBackendForData
+	require.True(t, errors.As(err, &wrongErr))
+	require.Equal(t, ErrWrongBackendForData{Detected: BadgerDBBackend, Requested: GoLevelDBBackend}, wrongErr)
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -24,16 +25,86 @@ func init() {
 			return nil, errors.Wrap(errMissingOption, optionDir)
 		}
 
-		return NewGoLevelDB(name, dir)
+		path := filepath.Join(dir, name+".db")
+		if err := checkDataFormat(GoLevelDBBackend, path, options); err != nil {
+			return nil, err
+		}
+
+		repair, err := recoverOnCorruption(options)
+		if err != nil {
+			return nil, err
+		}
+
+		zeroCopy, err := unsafeZeroCopy(options)
+		if err != nil {
+			return nil, err
+		}
+
+		lenient, err := lenientRanges(options)
+		if err != nil {
+			return nil, err
+		}
+
+		readOnlyAfter, err := readOnlyAfterStorageFull(options)
+		if err != nil {
+			return nil, err
+		}
+
+		lock, err := lockDB(path, true, options)
+		if err != nil {
+			return nil, err
+		}
+		db, err := openGoLevelDB(name, dir, repair)
+		if err != nil {
+			lock.release()
+			return nil, err
+		}
+		db.lock = lock
+		db.zeroCopy = zeroCopy
+		db.lenientRanges = lenient
+		db.storageFull.threshold = int64(readOnlyAfter)
+		return db, nil
 	}
 	registerDBCreator(GoLevelDBBackend, dbCreator, false)
 }
 
 type GoLevelDB struct {
 	db *leveldb.DB
+
+	monitorMtx  sync.Mutex
+	monitorStop func()
+
+	// strictMtx serializes strict deletes and sets, see DeleteStrict and SetInsertOnly.
+	strictMtx sync.Mutex
+
+	// lock is the database lock taken by NewDB, released on Close.
+	lock *dbLock
+
+	// recovery is set if the database was recovered when opened, see RecoveryReport.
+	recovery *RecoveryReport
+
+	// zeroCopy makes iterators return values referencing the buffers of goleveldb instead of
+	// copies. Get always returns a copy made by goleveldb.
+	zeroCopy bool
+
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
+
+	// storageFull tracks the storage-full errors of writes.
+	storageFull storageFullGuard
 }
 
-var _ DB = (*GoLevelDB)(nil)
+var (
+	_ DB                   = (*GoLevelDB)(nil)
+	_ ValueSizer           = (*GoLevelDB)(nil)
+	_ StrictDeleter        = (*GoLevelDB)(nil)
+	_ StrictSetter         = (*GoLevelDB)(nil)
+	_ MultiDeleter         = (*GoLevelDB)(nil)
+	_ CapabilityReporter   = (*GoLevelDB)(nil)
+	_ PhysicalWriteCounter = (*GoLevelDB)(nil)
+	_ StatsProvider        = (*GoLevelDB)(nil)
+	_ RangeDeleter         = (*GoLevelDB)(nil)
+)
 
 func NewGoLevelDB(name string, dir string) (*GoLevelDB, error) {
 	return NewGoLevelDBWithOpts(name, dir, nil)
@@ -75,6 +146,18 @@ func (db *GoLevelDB) Has(key []byte) (bool, error) {
 	return bytes != nil, nil
 }
 
+// ValueSize implements ValueSizer.
+func (db *GoLevelDB) ValueSize(key []byte) (int, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return -1, nil
+	}
+	return len(value), nil
+}
+
 // Set implements DB.
 func (db *GoLevelDB) Set(key []byte, value []byte) error {
 	if len(key) == 0 {
@@ -83,10 +166,10 @@ func (db *GoLevelDB) Set(key []byte, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
-	if err := db.db.Put(key, value, nil); err != nil {
+	if err := db.storageFull.writable(); err != nil {
 		return err
 	}
-	return nil
+	return db.writeError(db.db.Put(key, value, nil))
 }
 
 // SetSync implements DB.
@@ -97,10 +180,10 @@ func (db *GoLevelDB) SetSync(key []byte, value []byte) error {
 	if value == nil {
 		return errValueNil
 	}
-	if err := db.db.Put(key, value, &opt.WriteOptions{Sync: true}); err != nil {
+	if err := db.storageFull.writable(); err != nil {
 		return err
 	}
-	return nil
+	return db.writeError(db.db.Put(key, value, &opt.WriteOptions{Sync: true}))
 }
 
 // Delete implements DB.
@@ -108,34 +191,186 @@ func (db *GoLevelDB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
-	if err := db.db.Delete(key, nil); err != nil {
+	return db.writeError(db.db.Delete(key, nil))
+}
+
+// writeError classifies and tracks the error of a write, see ErrStorageFull.
+func (db *GoLevelDB) writeError(err error) error {
+	return db.storageFull.track(diskFullError(err))
+}
+
+// DeleteStrict implements StrictDeleter. The check and delete are serialized with other strict
+// deletes, so that exactly one of several concurrent strict deletes of a key succeeds.
+func (db *GoLevelDB) DeleteStrict(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	db.strictMtx.Lock()
+	defer db.strictMtx.Unlock()
+
+	ok, err := db.db.Has(key, nil)
+	if err != nil {
 		return err
 	}
-	return nil
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return db.writeError(db.db.Delete(key, nil))
 }
 
-// DeleteSync implements DB.
-func (db *GoLevelDB) DeleteSync(key []byte) error {
+// SetInsertOnly implements StrictSetter. The check and set are serialized with other strict sets
+// and deletes, but not with plain sets.
+func (db *GoLevelDB) SetInsertOnly(key, value []byte) error {
+	return db.setStrict(key, value, true)
+}
+
+// SetUpdateOnly implements StrictSetter. The check and set are serialized with other strict sets
+// and deletes, but not with plain sets.
+func (db *GoLevelDB) SetUpdateOnly(key, value []byte) error {
+	return db.setStrict(key, value, false)
+}
+
+// setStrict sets a key if its existence is the opposite of insert.
+func (db *GoLevelDB) setStrict(key, value []byte, insert bool) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
-	err := db.db.Delete(key, &opt.WriteOptions{Sync: true})
+	if value == nil {
+		return errValueNil
+	}
+	if err := db.storageFull.writable(); err != nil {
+		return err
+	}
+	db.strictMtx.Lock()
+	defer db.strictMtx.Unlock()
+
+	ok, err := db.db.Has(key, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	switch {
+	case insert && ok:
+		return ErrKeyExists{Key: key}
+	case !insert && !ok:
+		return ErrKeyNotFound
+	}
+	return db.writeError(db.db.Put(key, value, nil))
+}
+
+// DeleteKeys implements MultiDeleter, deleting the keys in a single native batch. The existing keys
+// are counted first, serialized with strict deletes.
+func (db *GoLevelDB) DeleteKeys(keys [][]byte) (int64, error) {
+	if err := checkKeys(keys); err != nil {
+		return 0, err
+	}
+	db.strictMtx.Lock()
+	defer db.strictMtx.Unlock()
+
+	var deleted int64
+	batch := new(leveldb.Batch)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		ok, err := db.db.Has(key, nil)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			deleted++
+			batch.Delete(key)
+		}
+	}
+	if err := db.writeError(db.db.Write(batch, nil)); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// DeleteRange implements RangeDeleter, deleting the keys of [start, end) in batches of
+// defaultPruneBatchSize keys, read with a single iterator over a snapshot of the database.
+func (db *GoLevelDB) DeleteRange(start, end []byte) (int64, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return 0, errKeyEmpty
+	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return 0, err
+	}
+	itr := db.db.NewIterator(&util.Range{Start: start, Limit: end}, nil)
+	defer itr.Release()
+
+	var deleted int64
+	batch := new(leveldb.Batch)
+	write := func() error {
+		if err := db.writeError(db.db.Write(batch, nil)); err != nil {
+			return err
+		}
+		deleted += int64(batch.Len())
+		batch.Reset()
+		return nil
+	}
+	for itr.Next() {
+		// The batch copies the key.
+		batch.Delete(itr.Key())
+		if batch.Len() == defaultPruneBatchSize {
+			if err := write(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+	if batch.Len() > 0 {
+		if err := write(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteSync implements DB.
+func (db *GoLevelDB) DeleteSync(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return db.writeError(db.db.Delete(key, &opt.WriteOptions{Sync: true}))
 }
 
 func (db *GoLevelDB) DB() *leveldb.DB {
 	return db.db
 }
 
-// Close implements DB.
+// Capabilities implements CapabilityReporter. Keys are unlimited.
+func (db *GoLevelDB) Capabilities() Capabilities {
+	return Capabilities{ZeroCopy: db.zeroCopy, ReadOnly: db.storageFull.isReadOnly()}
+}
+
+// Close implements DB. Closing a closed database is a no-op.
 func (db *GoLevelDB) Close() error {
-	if err := db.db.Close(); err != nil {
+	db.stopStallMonitor()
+	reportClosed(db)
+	if err := db.db.Close(); err != nil && !errors.Is(err, leveldb.ErrClosed) {
 		return err
 	}
-	return nil
+	return db.lock.release()
+}
+
+// Compact implements DB, compacting the range with CompactRange.
+func (db *GoLevelDB) Compact(start, end []byte) error {
+	return db.db.CompactRange(util.Range{Start: start, Limit: end})
+}
+
+// PhysicalBytesWritten implements PhysicalWriteCounter, returning the bytes written to the journal
+// and tables, including compactions, since the database was opened.
+func (db *GoLevelDB) PhysicalBytesWritten() (int64, error) {
+	var stats leveldb.DBStats
+	if err := db.db.Stats(&stats); err != nil {
+		return 0, err
+	}
+	return int64(stats.IOWrite), nil
 }
 
 // Print implements DB.
@@ -157,6 +392,15 @@ func (db *GoLevelDB) Print() error {
 
 // Stats implements DB.
 func (db *GoLevelDB) Stats() map[string]string {
+	stats, _ := db.TypedStats()
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider, with the properties of goleveldb as Raw statistics. The
+// disk size is the size of the tables of all levels reported by leveldb.stats, which excludes the
+// writes not yet flushed from the journal, and the memory size is the size of the block cache. The
+// number of keys is not known.
+func (db *GoLevelDB) TypedStats() (DBStats, error) {
 	keys := []string{
 		"leveldb.num-files-at-level{n}",
 		"leveldb.stats",
@@ -166,16 +410,28 @@ func (db *GoLevelDB) Stats() map[string]string {
 		"leveldb.openedtables",
 		"leveldb.alivesnaps",
 		"leveldb.aliveiters",
+		"leveldb.writedelay",
+		"leveldb.compcount",
+		"leveldb.iostats",
 	}
 
-	stats := make(map[string]string)
+	raw := make(map[string]string)
 	for _, key := range keys {
 		str, err := db.db.GetProperty(key)
 		if err == nil {
-			stats[key] = str
+			raw[key] = str
 		}
 	}
-	return stats
+	db.storageFull.addStats(raw)
+
+	stats := DBStats{KeyCount: -1, DiskSizeBytes: -1, MemSizeBytes: -1, Raw: raw}
+	var s leveldb.DBStats
+	if err := db.db.Stats(&s); err != nil {
+		return stats, err
+	}
+	stats.DiskSizeBytes = s.LevelSizes.Sum()
+	stats.MemSizeBytes = int64(s.BlockCacheSize)
+	return stats, nil
 }
 
 // NewBatch implements DB.
@@ -183,13 +439,21 @@ func (db *GoLevelDB) NewBatch() Batch {
 	return newGoLevelDBBatch(db)
 }
 
+// NewBatchWithSize implements BatchCreator.
+func (db *GoLevelDB) NewBatchWithSize(size int) Batch {
+	return newGoLevelDBBatchWithSize(db, size)
+}
+
 // Iterator implements DB.
 func (db *GoLevelDB) Iterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(&util.Range{Start: start, Limit: end}, nil)
-	return newGoLevelDBIterator(itr, start, end, false), nil
+	return newGoLevelDBIterator(itr, start, end, false, db.zeroCopy), nil
 }
 
 // ReverseIterator implements DB.
@@ -197,6 +461,9 @@ func (db *GoLevelDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(&util.Range{Start: start, Limit: end}, nil)
-	return newGoLevelDBIterator(itr, start, end, true), nil
+	return newGoLevelDBIterator(itr, start, end, true, db.zeroCopy), nil
 }
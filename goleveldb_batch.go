@@ -8,9 +8,18 @@ import (
 type goLevelDBBatch struct {
 	db    *GoLevelDB
 	batch *leveldb.Batch
+	// sets is set once a key is set in the batch.
+	sets bool
 }
 
-var _ Batch = (*goLevelDBBatch)(nil)
+var (
+	_ Batch           = (*goLevelDBBatch)(nil)
+	_ ResettableBatch = (*goLevelDBBatch)(nil)
+)
+
+// goLevelDBBatchOpSizeHint is the estimated encoded size of a batch operation, used to pre-size
+// batches.
+const goLevelDBBatchOpSizeHint = 64
 
 func newGoLevelDBBatch(db *GoLevelDB) *goLevelDBBatch {
 	return &goLevelDBBatch{
@@ -19,6 +28,13 @@ func newGoLevelDBBatch(db *GoLevelDB) *goLevelDBBatch {
 	}
 }
 
+func newGoLevelDBBatchWithSize(db *GoLevelDB, size int) *goLevelDBBatch {
+	return &goLevelDBBatch{
+		db:    db,
+		batch: leveldb.MakeBatch(size * goLevelDBBatchOpSizeHint),
+	}
+}
+
 // Set implements Batch.
 func (b *goLevelDBBatch) Set(key, value []byte) error {
 	if len(key) == 0 {
@@ -31,6 +47,7 @@ func (b *goLevelDBBatch) Set(key, value []byte) error {
 		return errBatchClosed
 	}
 	b.batch.Put(key, value)
+	b.sets = true
 	return nil
 }
 
@@ -60,7 +77,13 @@ func (b *goLevelDBBatch) write(sync bool) error {
 	if b.batch == nil {
 		return errBatchClosed
 	}
-	err := b.db.db.Write(b.batch, &opt.WriteOptions{Sync: sync})
+	// Batches which only delete are allowed when read-only, so that space can be freed.
+	if b.sets {
+		if err := b.db.storageFull.writable(); err != nil {
+			return err
+		}
+	}
+	err := b.db.writeError(b.db.db.Write(b.batch, &opt.WriteOptions{Sync: sync}))
 	if err != nil {
 		return err
 	}
@@ -76,3 +99,13 @@ func (b *goLevelDBBatch) Close() error {
 	}
 	return nil
 }
+
+// Reset implements ResettableBatch.
+func (b *goLevelDBBatch) Reset() {
+	if b.batch == nil {
+		b.batch = new(leveldb.Batch)
+	} else {
+		b.batch.Reset()
+	}
+	b.sets = false
+}
@@ -7,16 +7,24 @@ import (
 )
 
 type goLevelDBIterator struct {
+	iteratorGuard
+
 	source    iterator.Iterator
 	start     []byte
 	end       []byte
 	isReverse bool
 	isInvalid bool
+
+	// zeroCopy makes Value return the buffer of the source iterator instead of a copy.
+	zeroCopy bool
 }
 
-var _ Iterator = (*goLevelDBIterator)(nil)
+var (
+	_ Iterator     = (*goLevelDBIterator)(nil)
+	_ SeekIterator = (*goLevelDBIterator)(nil)
+)
 
-func newGoLevelDBIterator(source iterator.Iterator, start, end []byte, isReverse bool) *goLevelDBIterator {
+func newGoLevelDBIterator(source iterator.Iterator, start, end []byte, isReverse, zeroCopy bool) *goLevelDBIterator {
 	if isReverse {
 		if end == nil {
 			source.Last()
@@ -44,6 +52,7 @@ func newGoLevelDBIterator(source iterator.Iterator, start, end []byte, isReverse
 		end:       end,
 		isReverse: isReverse,
 		isInvalid: false,
+		zeroCopy:  zeroCopy,
 	}
 }
 
@@ -96,21 +105,60 @@ func (itr *goLevelDBIterator) Valid() bool {
 func (itr *goLevelDBIterator) Key() []byte {
 	// Key returns a copy of the current key.
 	// See https://github.com/syndtr/goleveldb/blob/52c212e6c196a1404ea59592d3f1c227c9f034b2/leveldb/iterator/iter.go#L88
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return cp(itr.source.Key())
 }
 
 // Value implements Iterator.
 func (itr *goLevelDBIterator) Value() []byte {
-	// Value returns a copy of the current value.
+	// Value returns a copy of the current value, unless zero-copy is enabled.
 	// See https://github.com/syndtr/goleveldb/blob/52c212e6c196a1404ea59592d3f1c227c9f034b2/leveldb/iterator/iter.go#L88
-	itr.assertIsValid()
-	return cp(itr.source.Value())
+	if !itr.assertIsValid() {
+		return nil
+	}
+	return valueCopy(itr.source.Value(), itr.zeroCopy)
 }
 
 // Next implements Iterator.
 func (itr *goLevelDBIterator) Next() {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return
+	}
+	itr.step()
+}
+
+// Seek implements SeekIterator.
+func (itr *goLevelDBIterator) Seek(key []byte) bool {
+	if !itr.Valid() {
+		return false
+	}
+	if itr.isReverse {
+		switch {
+		case itr.end != nil && bytes.Compare(key, itr.end) >= 0:
+			// The end is exclusive.
+			if itr.source.Seek(itr.end) {
+				itr.source.Prev()
+			} else {
+				itr.source.Last()
+			}
+		case !itr.source.Seek(key):
+			itr.source.Last()
+		case !bytes.Equal(itr.source.Key(), key):
+			itr.source.Prev()
+		}
+	} else {
+		if itr.start != nil && bytes.Compare(key, itr.start) < 0 {
+			key = itr.start
+		}
+		itr.source.Seek(key)
+	}
+	return itr.Valid()
+}
+
+// step moves the source one key in the iteration direction.
+func (itr *goLevelDBIterator) step() {
 	if itr.isReverse {
 		itr.source.Prev()
 	} else {
@@ -120,7 +168,10 @@ func (itr *goLevelDBIterator) Next() {
 
 // Error implements Iterator.
 func (itr *goLevelDBIterator) Error() error {
-	return itr.source.Error()
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
 }
 
 // Close implements Iterator.
@@ -129,8 +180,6 @@ func (itr *goLevelDBIterator) Close() error {
 	return nil
 }
 
-func (itr goLevelDBIterator) assertIsValid() {
-	if !itr.Valid() {
-		panic("iterator is invalid")
-	}
+func (itr *goLevelDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
 }
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	leveldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// optionRecoverOnCorruption makes goleveldb recover databases which fail to open with a corruption
+// error, instead of failing. See RecoveryReport.
+const optionRecoverOnCorruption = "recover_on_corruption"
+
+// RecoveryReport describes the recovery of a corrupted goleveldb database, see
+// GoLevelDB.RecoveryReport. Recovery rebuilds the manifest from the table files found in the
+// database directory, so data in missing or corrupted tables may be lost.
+type RecoveryReport struct {
+	// Path is the path of the database.
+	Path string
+	// Cause is the corruption error which triggered the recovery.
+	Cause error
+	// Tables is the number of table files the database was recovered from.
+	Tables int
+}
+
+// String implements fmt.Stringer.
+func (r *RecoveryReport) String() string {
+	return fmt.Sprintf("recovered goleveldb database %s from %d tables after corruption (%v); data may have been lost",
+		r.Path, r.Tables, r.Cause)
+}
+
+// recoverOnCorruption parses the recover_on_corruption option.
+func recoverOnCorruption(options Options) (bool, error) {
+	s, ok := options[optionRecoverOnCorruption]
+	if !ok {
+		return false, nil
+	}
+	repair, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", optionRecoverOnCorruption, s, err)
+	}
+	return repair, nil
+}
+
+// openGoLevelDB opens a goleveldb database, recovering it if it is corrupted and repair is set.
+func openGoLevelDB(name, dir string, repair bool) (*GoLevelDB, error) {
+	db, err := NewGoLevelDB(name, dir)
+	if err == nil || !repair || !leveldbErrors.IsCorrupted(err) {
+		return db, err
+	}
+
+	dbPath := filepath.Join(dir, name+".db")
+	ldb, recErr := leveldb.RecoverFile(dbPath, nil)
+	if recErr != nil {
+		return nil, fmt.Errorf("failed to recover corrupted database (%v): %w", err, recErr)
+	}
+	report := &RecoveryReport{Path: dbPath, Cause: err}
+	if entries, err := os.ReadDir(dbPath); err == nil {
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); ext == ".ldb" || ext == ".sst" {
+				report.Tables++
+			}
+		}
+	}
+	return &GoLevelDB{db: ldb, recovery: report}, nil
+}
+
+// RecoveryReport returns the report of the recovery performed when the database was opened with
+// the recover_on_corruption option, or nil if it was not recovered.
+func (db *GoLevelDB) RecoveryReport() *RecoveryReport {
+	return db.recovery
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// stallMonitorInterval is the interval between write latency probes of the stall monitor.
+const stallMonitorInterval = time.Second
+
+// stallProbeKey is the reserved key of the probe writes of the stall monitor, which delete it in
+// the same batch, so that it is never visible.
+var stallProbeKey = reservedKey("goleveldb", []byte("stall_probe"))
+
+// StallEvent describes a probe write which exceeded the stall monitor threshold.
+type StallEvent struct {
+	// Time is when the probe write started.
+	Time time.Time
+	// Latency is how long the probe write took.
+	Latency time.Duration
+	// Threshold is the configured stall threshold.
+	Threshold time.Duration
+	// WriteDelay is the value of the leveldb.writedelay property after the probe write.
+	WriteDelay string
+	// Err is the error returned by the probe write, if any.
+	Err error
+}
+
+// stallMonitor samples write latency by timing probe writes.
+type stallMonitor struct {
+	threshold time.Duration
+	callback  func(StallEvent)
+
+	// now, probe and writeDelay are replaceable for testing.
+	now        func() time.Time
+	probe      func() error
+	writeDelay func() string
+}
+
+// sample times a single probe write, and invokes the callback if it exceeds the threshold or
+// fails.
+func (m *stallMonitor) sample() {
+	start := m.now()
+	err := m.probe()
+	latency := m.now().Sub(start)
+	if err == nil && latency <= m.threshold {
+		return
+	}
+	m.callback(StallEvent{
+		Time:       start,
+		Latency:    latency,
+		Threshold:  m.threshold,
+		WriteDelay: m.writeDelay(),
+		Err:        err,
+	})
+}
+
+// run samples on every tick until done is closed.
+func (m *stallMonitor) run(ticks <-chan time.Time, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticks:
+			m.sample()
+		}
+	}
+}
+
+// StallMonitor starts monitoring write latency by periodically timing a small probe write, which
+// sets and deletes a reserved key in one batch so that the data is unchanged, and invokes callback
+// whenever a probe write takes longer than threshold or fails. Calling StallMonitor again replaces
+// the previous monitor. The monitor is stopped on Close.
+func (db *GoLevelDB) StallMonitor(threshold time.Duration, callback func(StallEvent)) {
+	m := &stallMonitor{
+		threshold: threshold,
+		callback:  callback,
+		now:       time.Now,
+		probe: func() error {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+			batch := new(leveldb.Batch)
+			batch.Put(stallProbeKey, value)
+			batch.Delete(stallProbeKey)
+			return db.db.Write(batch, nil)
+		},
+		writeDelay: func() string {
+			delay, _ := db.db.GetProperty("leveldb.writedelay")
+			return delay
+		},
+	}
+
+	db.stopStallMonitor()
+
+	db.monitorMtx.Lock()
+	defer db.monitorMtx.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(stallMonitorInterval)
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		m.run(ticker.C, done)
+	}()
+	db.monitorStop = func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// stopStallMonitor stops the stall monitor, if running, and waits for it to exit.
+func (db *GoLevelDB) stopStallMonitor() {
+	db.monitorMtx.Lock()
+	defer db.monitorMtx.Unlock()
+
+	if db.monitorStop != nil {
+		db.monitorStop()
+		db.monitorStop = nil
+	}
+}
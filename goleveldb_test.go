@@ -2,7 +2,12 @@ package db
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -41,3 +46,267 @@ func BenchmarkGoLevelDBRandomReadsWrites(b *testing.B) {
 
 	benchmarkRandomReadsWrites(b, db)
 }
+
+func TestGoLevelDBStallMonitorSample(t *testing.T) {
+	now := time.Unix(0, 0)
+	latency := time.Duration(0)
+	var events []StallEvent
+	m := &stallMonitor{
+		threshold: 100 * time.Millisecond,
+		callback:  func(e StallEvent) { events = append(events, e) },
+		now:       func() time.Time { return now },
+		probe: func() error {
+			now = now.Add(latency)
+			return nil
+		},
+		writeDelay: func() string { return "DelayN:1 Delay:1s Paused:false" },
+	}
+
+	latency = 50 * time.Millisecond
+	m.sample()
+	latency = 100 * time.Millisecond
+	m.sample()
+	latency = 250 * time.Millisecond
+	m.sample()
+
+	require.Len(t, events, 1)
+	require.Equal(t, 250*time.Millisecond, events[0].Latency)
+	require.Equal(t, 100*time.Millisecond, events[0].Threshold)
+	require.Equal(t, time.Unix(0, 0).Add(150*time.Millisecond), events[0].Time)
+	require.Equal(t, "DelayN:1 Delay:1s Paused:false", events[0].WriteDelay)
+	require.NoError(t, events[0].Err)
+}
+
+func TestGoLevelDBStallMonitorProbeHidden(t *testing.T) {
+	name := fmt.Sprintf("test_%x", randStr(12))
+	db, err := NewGoLevelDB(name, "")
+	require.NoError(t, err)
+	defer cleanupDBDir("", name)
+
+	require.NoError(t, db.Set([]byte("a"), []byte{1}))
+	require.NoError(t, db.Set([]byte{0xff, 0xff}, []byte{2}))
+
+	// Use a negative threshold so that every probe is reported.
+	events := make(chan StallEvent, 1)
+	db.StallMonitor(-1, func(e StallEvent) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	select {
+	case e := <-events:
+		require.NoError(t, e.Err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for probe")
+	}
+
+	// The probe key is never visible, nor deleted or counted by DeleteRange.
+	ok, err := db.Has(stallProbeKey)
+	require.NoError(t, err)
+	require.False(t, ok)
+	probe, err := db.Get(stallProbeKey)
+	require.NoError(t, err)
+	require.Nil(t, probe)
+
+	for _, reverse := range []bool{false, true} {
+		var itr Iterator
+		if reverse {
+			itr, err = db.ReverseIterator(nil, nil)
+		} else {
+			itr, err = db.Iterator(nil, nil)
+		}
+		require.NoError(t, err)
+		keys := [][]byte{}
+		for ; itr.Valid(); itr.Next() {
+			keys = append(keys, itr.Key())
+		}
+		require.NoError(t, itr.Close())
+		if reverse {
+			require.Equal(t, [][]byte{{0xff, 0xff}, []byte("a")}, keys)
+		} else {
+			require.Equal(t, [][]byte{[]byte("a"), {0xff, 0xff}}, keys)
+		}
+	}
+
+	deleted, err := db.DeleteRange(nil, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, deleted)
+
+	require.NoError(t, db.Close())
+}
+
+func TestGoLevelDBStallMonitorRun(t *testing.T) {
+	probes := make(chan struct{}, 3)
+	m := &stallMonitor{
+		threshold: time.Second,
+		callback:  func(StallEvent) {},
+		now:       time.Now,
+		probe: func() error {
+			probes <- struct{}{}
+			return nil
+		},
+		writeDelay: func() string { return "" },
+	}
+
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		m.run(ticks, done)
+	}()
+
+	ticks <- time.Time{}
+	ticks <- time.Time{}
+	close(done)
+	<-stopped
+	require.Len(t, probes, 2)
+}
+
+func benchmarkGoLevelDBBatch(b *testing.B, sized bool) {
+	name := fmt.Sprintf("test_%x", randStr(12))
+	db, err := NewGoLevelDB(name, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		cleanupDBDir("", name)
+	}()
+
+	benchmarkBatchBuild(b, db, 100000, sized)
+}
+
+func BenchmarkGoLevelDBBatch100k(b *testing.B) {
+	benchmarkGoLevelDBBatch(b, false)
+}
+
+func BenchmarkGoLevelDBBatchWithSize100k(b *testing.B) {
+	benchmarkGoLevelDBBatch(b, true)
+}
+
+func benchmarkGoLevelDBValueReads(b *testing.B, zeroCopy bool) {
+	dir := b.TempDir()
+	db, err := NewDB(GoLevelDBBackend, Options{
+		optionName:           "values",
+		optionDir:            dir,
+		optionUnsafeZeroCopy: fmt.Sprint(zeroCopy),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	benchmarkValueReads(b, db)
+}
+
+func BenchmarkGoLevelDBValueReads(b *testing.B) {
+	benchmarkGoLevelDBValueReads(b, false)
+}
+
+func BenchmarkGoLevelDBValueReadsZeroCopy(b *testing.B) {
+	benchmarkGoLevelDBValueReads(b, true)
+}
+
+func TestGoLevelDBZeroCopy(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(GoLevelDBBackend, Options{optionName: "copies", optionDir: dir})
+	require.NoError(t, err)
+	defer db.Close()
+	require.False(t, DBCapabilities(db).ZeroCopy)
+	checkValueCopies(t, db)
+
+	zeroCopyDB, err := NewDB(GoLevelDBBackend, Options{
+		optionName:           "zerocopy",
+		optionDir:            dir,
+		optionUnsafeZeroCopy: "true",
+	})
+	require.NoError(t, err)
+	defer zeroCopyDB.Close()
+	require.True(t, DBCapabilities(zeroCopyDB).ZeroCopy)
+
+	require.NoError(t, zeroCopyDB.Set([]byte("a"), []byte("1")))
+	require.NoError(t, zeroCopyDB.Set([]byte("b"), []byte{}))
+	itr, err := zeroCopyDB.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"a", "1"}, {"b", ""}}, collectIterator(t, itr))
+}
+
+func TestGoLevelDBRecoverOnCorruption(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewGoLevelDB("corrupt", dir)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set(int642Bytes(int64(i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, db.Compact(nil, nil))
+	require.NoError(t, db.Close())
+
+	// Overwrite the manifest with garbage.
+	path := filepath.Join(dir, "corrupt.db")
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	corrupted := false
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "MANIFEST-") {
+			require.NoError(t, os.WriteFile(filepath.Join(path, entry.Name()), []byte("garbage garbage garbage"), 0o644))
+			corrupted = true
+		}
+	}
+	require.True(t, corrupted)
+
+	// Opening fails by default.
+	_, err = NewDB(GoLevelDBBackend, Options{optionName: "corrupt", optionDir: dir})
+	require.ErrorContains(t, err, "corrupted")
+
+	_, err = NewDB(GoLevelDBBackend, Options{optionName: "corrupt", optionDir: dir, optionRecoverOnCorruption: "maybe"})
+	require.ErrorContains(t, err, `invalid recover_on_corruption "maybe"`)
+
+	// With recovery, the database is rebuilt from its tables.
+	rdb, err := NewDB(GoLevelDBBackend, Options{optionName: "corrupt", optionDir: dir, optionRecoverOnCorruption: "true"})
+	require.NoError(t, err)
+	report := rdb.(*GoLevelDB).RecoveryReport()
+	require.NotNil(t, report)
+	require.Equal(t, path, report.Path)
+	require.ErrorContains(t, report.Cause, "corrupted")
+	require.Positive(t, report.Tables)
+	require.Contains(t, report.String(), "data may have been lost")
+
+	value, err := rdb.Get(int642Bytes(42))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value42"), value)
+	require.NoError(t, rdb.Close())
+
+	// The recovered database opens normally afterwards.
+	rdb, err = NewDB(GoLevelDBBackend, Options{optionName: "corrupt", optionDir: dir, optionRecoverOnCorruption: "true"})
+	require.NoError(t, err)
+	require.Nil(t, rdb.(*GoLevelDB).RecoveryReport())
+	require.NoError(t, rdb.Close())
+}
+
+func TestGoLevelDBTypedStats(t *testing.T) {
+	name := fmt.Sprintf("test_%x", randStr(12))
+	db, err := NewGoLevelDB(name, "")
+	require.NoError(t, err)
+	defer cleanupDBDir("", name)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value")))
+	}
+	// Flushes the journal to tables.
+	require.NoError(t, db.Compact(nil, nil))
+
+	stats, err := db.TypedStats()
+	require.NoError(t, err)
+	require.EqualValues(t, -1, stats.KeyCount)
+	require.Positive(t, stats.DiskSizeBytes)
+	require.GreaterOrEqual(t, stats.MemSizeBytes, int64(0))
+	require.Contains(t, stats.Raw, "leveldb.stats")
+
+	m := db.Stats()
+	require.Equal(t, strconv.FormatInt(stats.DiskSizeBytes, 10), m["stats.disk_size_bytes"])
+	require.NotContains(t, m, "stats.key_count")
+	require.Contains(t, m, "leveldb.stats")
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults of TrackerConfig.
+const (
+	defaultHotKeyTopK        = 32
+	defaultHotKeySketchWidth = 2048
+	defaultHotKeySketchDepth = 4
+	hotKeyOpRead             = "read"
+	hotKeyOpWrite            = "write"
+)
+
+// TrackerConfig configures a HotKeyTrackerDB.
+type TrackerConfig struct {
+	// TopK is the number of hot keys tracked for reads and for writes each. Defaults to 32.
+	TopK int
+	// PrefixLength truncates keys to their first PrefixLength bytes, so that accesses to keys
+	// sharing a prefix are counted together. Zero tracks full keys.
+	PrefixLength int
+	// SketchWidth and SketchDepth size the count-min sketch estimating access counts. Larger
+	// sketches overestimate less. Default to 2048 and 4.
+	SketchWidth int
+	SketchDepth int
+	// Clock provides the access times. Defaults to the system clock.
+	Clock Clock
+}
+
+// HotKey is a frequently accessed key reported by HotKeyTrackerDB.
+type HotKey struct {
+	// Op is "read" or "write".
+	Op string
+	// Key is the key, truncated to the configured prefix length.
+	Key []byte
+	// Count is the estimated number of accesses. It may overestimate, but never underestimates.
+	Count uint64
+	// LastAccess is the time of the last access while the key was tracked.
+	LastAccess time.Time
+}
+
+// HotKeyTrackerDB wraps a database and tracks the most frequently accessed keys, separately for
+// point reads (Get, Has) and writes (Set, Delete, and batch operations). Access counts are
+// estimated with a count-min sketch, and the hottest keys are kept in a small heap, so the
+// overhead per operation and the memory use are constant. Iterators are not tracked.
+type HotKeyTrackerDB struct {
+	DB
+
+	clock        Clock
+	prefixLength int
+	reads        *hotKeyTracker
+	writes       *hotKeyTracker
+}
+
+var _ DB = (*HotKeyTrackerDB)(nil)
+
+// NewHotKeyTrackerDB wraps db with hot key tracking.
+func NewHotKeyTrackerDB(db DB, cfg TrackerConfig) *HotKeyTrackerDB {
+	if cfg.TopK <= 0 {
+		cfg.TopK = defaultHotKeyTopK
+	}
+	if cfg.SketchWidth <= 0 {
+		cfg.SketchWidth = defaultHotKeySketchWidth
+	}
+	if cfg.SketchDepth <= 0 {
+		cfg.SketchDepth = defaultHotKeySketchDepth
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	seed := maphash.MakeSeed()
+	return &HotKeyTrackerDB{
+		DB:           db,
+		clock:        cfg.Clock,
+		prefixLength: cfg.PrefixLength,
+		reads:        newHotKeyTracker(hotKeyOpRead, cfg, seed),
+		writes:       newHotKeyTracker(hotKeyOpWrite, cfg, seed),
+	}
+}
+
+// Report returns up to n of the hottest keys for reads, followed by up to n of the hottest keys
+// for writes, each by descending estimated count.
+func (hdb *HotKeyTrackerDB) Report(n int) []HotKey {
+	return append(hdb.reads.report(n), hdb.writes.report(n)...)
+}
+
+// trackKey truncates a key to the configured prefix length.
+func (hdb *HotKeyTrackerDB) trackKey(key []byte) []byte {
+	if hdb.prefixLength > 0 && len(key) > hdb.prefixLength {
+		return key[:hdb.prefixLength]
+	}
+	return key
+}
+
+func (hdb *HotKeyTrackerDB) read(key []byte) {
+	if len(key) > 0 {
+		hdb.reads.access(hdb.trackKey(key), hdb.clock.Now())
+	}
+}
+
+func (hdb *HotKeyTrackerDB) write(key []byte) {
+	if len(key) > 0 {
+		hdb.writes.access(hdb.trackKey(key), hdb.clock.Now())
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (hdb *HotKeyTrackerDB) Unwrap() DB {
+	return hdb.DB
+}
+
+// Get implements DB.
+func (hdb *HotKeyTrackerDB) Get(key []byte) ([]byte, error) {
+	hdb.read(key)
+	return hdb.DB.Get(key)
+}
+
+// Has implements DB.
+func (hdb *HotKeyTrackerDB) Has(key []byte) (bool, error) {
+	hdb.read(key)
+	return hdb.DB.Has(key)
+}
+
+// Set implements DB.
+func (hdb *HotKeyTrackerDB) Set(key, value []byte) error {
+	hdb.write(key)
+	return hdb.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (hdb *HotKeyTrackerDB) SetSync(key, value []byte) error {
+	hdb.write(key)
+	return hdb.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (hdb *HotKeyTrackerDB) Delete(key []byte) error {
+	hdb.write(key)
+	return hdb.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (hdb *HotKeyTrackerDB) DeleteSync(key []byte) error {
+	hdb.write(key)
+	return hdb.DB.DeleteSync(key)
+}
+
+// NewBatch implements DB.
+func (hdb *HotKeyTrackerDB) NewBatch() Batch {
+	return hotKeyBatch{Batch: hdb.DB.NewBatch(), db: hdb}
+}
+
+// hotKeyBatch tracks the writes of a batch as they are added.
+type hotKeyBatch struct {
+	Batch
+	db *HotKeyTrackerDB
+}
+
+// Set implements Batch.
+func (b hotKeyBatch) Set(key, value []byte) error {
+	b.db.write(key)
+	return b.Batch.Set(key, value)
+}
+
+// Delete implements Batch.
+func (b hotKeyBatch) Delete(key []byte) error {
+	b.db.write(key)
+	return b.Batch.Delete(key)
+}
+
+// hotKeyTracker estimates access counts with a count-min sketch, and keeps the keys with the
+// highest estimates in a min-heap.
+type hotKeyTracker struct {
+	mtx    sync.Mutex
+	op     string
+	seed   maphash.Seed
+	width  uint64
+	sketch [][]uint64
+	top    hotKeyHeap
+	// index maps tracked keys to their position in top.
+	index map[string]int
+	topK  int
+}
+
+func newHotKeyTracker(op string, cfg TrackerConfig, seed maphash.Seed) *hotKeyTracker {
+	sketch := make([][]uint64, cfg.SketchDepth)
+	for i := range sketch {
+		sketch[i] = make([]uint64, cfg.SketchWidth)
+	}
+	t := &hotKeyTracker{
+		op:     op,
+		seed:   seed,
+		width:  uint64(cfg.SketchWidth),
+		sketch: sketch,
+		index:  make(map[string]int, cfg.TopK),
+		topK:   cfg.TopK,
+	}
+	t.top.index = t.index
+	return t
+}
+
+// estimate increments the sketch counters of a key, and returns its estimated count. The row
+// positions are derived from a single hash by double hashing.
+func (t *hotKeyTracker) estimate(key []byte) uint64 {
+	h := maphash.Bytes(t.seed, key)
+	h1, h2 := h&0xffffffff, (h>>32)|1
+	var count uint64
+	for i, row := range t.sketch {
+		pos := (h1 + uint64(i)*h2) % t.width
+		row[pos]++
+		if i == 0 || row[pos] < count {
+			count = row[pos]
+		}
+	}
+	return count
+}
+
+// access records an access to key at now.
+func (t *hotKeyTracker) access(key []byte, now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	count := t.estimate(key)
+	if i, ok := t.index[string(key)]; ok {
+		t.top.items[i].Count = count
+		t.top.items[i].LastAccess = now
+		heap.Fix(&t.top, i)
+		return
+	}
+
+	hot := HotKey{Op: t.op, Count: count, LastAccess: now}
+	if len(t.top.items) < t.topK {
+		hot.Key = cp(key)
+		heap.Push(&t.top, hot)
+		return
+	}
+	if coldest := t.top.items[0]; count > coldest.Count {
+		delete(t.index, string(coldest.Key))
+		hot.Key = cp(key)
+		t.top.items[0] = hot
+		t.index[string(hot.Key)] = 0
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// report returns up to n of the tracked keys by descending count.
+func (t *hotKeyTracker) report(n int) []HotKey {
+	t.mtx.Lock()
+	hot := make([]HotKey, len(t.top.items))
+	copy(hot, t.top.items)
+	t.mtx.Unlock()
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Count != hot[j].Count {
+			return hot[i].Count > hot[j].Count
+		}
+		return string(hot[i].Key) < string(hot[j].Key)
+	})
+	if n >= 0 && len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// hotKeyHeap is a min-heap of hot keys by count, which keeps index up to date.
+type hotKeyHeap struct {
+	items []HotKey
+	index map[string]int
+}
+
+func (h *hotKeyHeap) Len() int           { return len(h.items) }
+func (h *hotKeyHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *hotKeyHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[string(h.items[i].Key)] = i
+	h.index[string(h.items[j].Key)] = j
+}
+
+func (h *hotKeyHeap) Push(x any) {
+	item := x.(HotKey)
+	h.index[string(item.Key)] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *hotKeyHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, string(item.Key))
+	return item
+}
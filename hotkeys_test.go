@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotKeyTrackerSkewedWorkload(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	db := NewHotKeyTrackerDB(NewMemDB(), TrackerConfig{TopK: 8, Clock: clock})
+
+	// Three hot keys with decreasing access counts, interleaved with many cold keys.
+	hot := map[string]int{"hot0": 1000, "hot1": 500, "hot2": 250}
+	for i := 0; i < 1000; i++ {
+		for key, n := range hot {
+			if i < n {
+				require.NoError(t, db.Set([]byte(key), []byte{1}))
+				_, err := db.Get([]byte(key))
+				require.NoError(t, err)
+			}
+		}
+		cold := []byte(fmt.Sprintf("cold%05d", i))
+		require.NoError(t, db.Set(cold, []byte{1}))
+		_, err := db.Has(cold)
+		require.NoError(t, err)
+		clock.advance(time.Second)
+	}
+
+	report := db.Report(3)
+	require.Len(t, report, 6)
+	for i, op := range []string{"read", "read", "read", "write", "write", "write"} {
+		require.Equal(t, op, report[i].Op)
+		require.Equal(t, fmt.Sprintf("hot%d", i%3), string(report[i].Key))
+		// The sketch may overestimate, but never underestimates.
+		n := uint64(hot[string(report[i].Key)])
+		require.GreaterOrEqual(t, report[i].Count, n)
+		require.Less(t, report[i].Count, n+n/10)
+	}
+	require.Equal(t, time.Unix(1_700_000_999, 0), report[0].LastAccess)
+	require.Equal(t, time.Unix(1_700_000_499, 0), report[1].LastAccess)
+}
+
+func TestHotKeyTrackerBatchAndPrefix(t *testing.T) {
+	db := NewHotKeyTrackerDB(NewMemDB(), TrackerConfig{PrefixLength: 2})
+
+	batch := db.NewBatch()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, batch.Set([]byte(fmt.Sprintf("aa%d", i)), []byte{1}))
+	}
+	require.NoError(t, batch.Delete([]byte("bb1")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	report := db.Report(10)
+	require.Len(t, report, 2)
+	require.Equal(t, "write", report[0].Op)
+	require.Equal(t, []byte("aa"), report[0].Key)
+	require.EqualValues(t, 10, report[0].Count)
+	require.Equal(t, []byte("bb"), report[1].Key)
+	require.EqualValues(t, 1, report[1].Count)
+
+	value, err := db.Get([]byte("aa3"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, value)
+	report = db.Report(1)
+	require.Len(t, report, 2)
+	require.Equal(t, HotKey{Op: "read", Key: []byte("aa"), Count: 1, LastAccess: report[0].LastAccess}, report[0])
+}
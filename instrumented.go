@@ -0,0 +1,380 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations of InstrumentedDB, as reported to a MetricsSink.
+const (
+	InstrumentedOpGet             = "get"
+	InstrumentedOpHas             = "has"
+	InstrumentedOpSet             = "set"
+	InstrumentedOpSetSync         = "set_sync"
+	InstrumentedOpDelete          = "delete"
+	InstrumentedOpDeleteSync      = "delete_sync"
+	InstrumentedOpIterator        = "iterator"
+	InstrumentedOpReverseIterator = "reverse_iterator"
+	InstrumentedOpIteratorNext    = "iterator_next"
+	InstrumentedOpIteratorClose   = "iterator_close"
+	InstrumentedOpBatchSet        = "batch_set"
+	InstrumentedOpBatchDelete     = "batch_delete"
+	InstrumentedOpBatchWrite      = "batch_write"
+	InstrumentedOpBatchWriteSync  = "batch_write_sync"
+	InstrumentedOpBatchClose      = "batch_close"
+	InstrumentedOpCompact         = "compact"
+	InstrumentedOpPrint           = "print"
+	InstrumentedOpClose           = "close"
+)
+
+// MetricsSink receives the measurements of an InstrumentedDB. It must be safe for concurrent use.
+// See PrometheusMetricsSink.
+type MetricsSink interface {
+	// ObserveOperation records an operation, how long it took, and its error, if any.
+	ObserveOperation(op string, duration time.Duration, err error)
+	// AddOpenIterators adds delta to the number of open iterators.
+	AddOpenIterators(delta int)
+	// AddPendingBatchOps adds delta to the number of operations of batches which are neither
+	// written nor closed.
+	AddPendingBatchOps(delta int)
+}
+
+// PrometheusMetricsSink is a MetricsSink exporting Prometheus metrics: a histogram of the durations
+// and a counter of the errors of the operations, per operation, and gauges of the open iterators
+// and pending batch operations.
+type PrometheusMetricsSink struct {
+	durations       *prometheus.HistogramVec
+	errors          *prometheus.CounterVec
+	openIterators   prometheus.Gauge
+	pendingBatchOps prometheus.Gauge
+}
+
+var _ MetricsSink = (*PrometheusMetricsSink)(nil)
+
+// NewPrometheusMetricsSink creates the metrics of a sink with the given constant labels, and
+// registers them with registerer. Several sinks may be registered with the same registerer if their
+// labels differ.
+func NewPrometheusMetricsSink(registerer prometheus.Registerer, labels map[string]string) (*PrometheusMetricsSink, error) {
+	s := &PrometheusMetricsSink{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of database operations, per operation.",
+			Buckets:     prometheus.ExponentialBuckets(0.00001, 4, 10),
+			ConstLabels: labels,
+		}, []string{"op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "operation_errors_total",
+			Help:        "Number of failed database operations, per operation.",
+			ConstLabels: labels,
+		}, []string{"op"}),
+		openIterators: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "open_iterators",
+			Help:        "Number of open database iterators.",
+			ConstLabels: labels,
+		}),
+		pendingBatchOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "pending_batch_operations",
+			Help:        "Number of operations of database batches which are neither written nor closed.",
+			ConstLabels: labels,
+		}),
+	}
+	var registered []prometheus.Collector
+	for _, c := range []prometheus.Collector{s.durations, s.errors, s.openIterators, s.pendingBatchOps} {
+		if err := registerer.Register(c); err != nil {
+			for _, c := range registered {
+				registerer.Unregister(c)
+			}
+			return nil, err
+		}
+		registered = append(registered, c)
+	}
+	return s, nil
+}
+
+// ObserveOperation implements MetricsSink.
+func (s *PrometheusMetricsSink) ObserveOperation(op string, duration time.Duration, err error) {
+	s.durations.WithLabelValues(op).Observe(duration.Seconds())
+	if err != nil {
+		s.errors.WithLabelValues(op).Inc()
+	}
+}
+
+// AddOpenIterators implements MetricsSink.
+func (s *PrometheusMetricsSink) AddOpenIterators(delta int) {
+	s.openIterators.Add(float64(delta))
+}
+
+// AddPendingBatchOps implements MetricsSink.
+func (s *PrometheusMetricsSink) AddPendingBatchOps(delta int) {
+	s.pendingBatchOps.Add(float64(delta))
+}
+
+// InstrumentedDB wraps a database and reports the duration and error of every operation, including
+// the operations of its batches and iterators, to a MetricsSink, as well as the number of open
+// iterators and pending batch operations. Optional interfaces of the wrapped database are not
+// exposed.
+type InstrumentedDB struct {
+	db   DB
+	sink MetricsSink
+}
+
+var _ DB = (*InstrumentedDB)(nil)
+
+// NewInstrumentedDB wraps db with Prometheus metrics registered with registerer, see
+// PrometheusMetricsSink. The metrics have the given constant labels, and a backend label with the
+// backend of db if it was created with NewDB and labels has none, or "unknown".
+func NewInstrumentedDB(db DB, registerer prometheus.Registerer, labels map[string]string) (DB, error) {
+	constLabels := prometheus.Labels{"backend": "unknown"}
+	if backend, ok := openDBs.Load(db); ok {
+		constLabels["backend"] = string(backend.(BackendType))
+	}
+	for name, value := range labels {
+		constLabels[name] = value
+	}
+	sink, err := NewPrometheusMetricsSink(registerer, constLabels)
+	if err != nil {
+		return nil, err
+	}
+	return NewInstrumentedDBWithSink(db, sink), nil
+}
+
+// NewInstrumentedDBWithSink wraps db, reporting to sink.
+func NewInstrumentedDBWithSink(db DB, sink MetricsSink) *InstrumentedDB {
+	return &InstrumentedDB{db: db, sink: sink}
+}
+
+// observe reports an operation which started at began.
+func (idb *InstrumentedDB) observe(op string, began time.Time, err error) {
+	idb.sink.ObserveOperation(op, time.Since(began), err)
+}
+
+// Get implements DB.
+func (idb *InstrumentedDB) Get(key []byte) ([]byte, error) {
+	began := time.Now()
+	value, err := idb.db.Get(key)
+	idb.observe(InstrumentedOpGet, began, err)
+	return value, err
+}
+
+// Has implements DB.
+func (idb *InstrumentedDB) Has(key []byte) (bool, error) {
+	began := time.Now()
+	ok, err := idb.db.Has(key)
+	idb.observe(InstrumentedOpHas, began, err)
+	return ok, err
+}
+
+// Set implements DB.
+func (idb *InstrumentedDB) Set(key, value []byte) error {
+	began := time.Now()
+	err := idb.db.Set(key, value)
+	idb.observe(InstrumentedOpSet, began, err)
+	return err
+}
+
+// SetSync implements DB.
+func (idb *InstrumentedDB) SetSync(key, value []byte) error {
+	began := time.Now()
+	err := idb.db.SetSync(key, value)
+	idb.observe(InstrumentedOpSetSync, began, err)
+	return err
+}
+
+// Delete implements DB.
+func (idb *InstrumentedDB) Delete(key []byte) error {
+	began := time.Now()
+	err := idb.db.Delete(key)
+	idb.observe(InstrumentedOpDelete, began, err)
+	return err
+}
+
+// DeleteSync implements DB.
+func (idb *InstrumentedDB) DeleteSync(key []byte) error {
+	began := time.Now()
+	err := idb.db.DeleteSync(key)
+	idb.observe(InstrumentedOpDeleteSync, began, err)
+	return err
+}
+
+// Iterator implements DB.
+func (idb *InstrumentedDB) Iterator(start, end []byte) (Iterator, error) {
+	began := time.Now()
+	itr, err := idb.db.Iterator(start, end)
+	idb.observe(InstrumentedOpIterator, began, err)
+	if err != nil {
+		return nil, err
+	}
+	return idb.newIterator(itr), nil
+}
+
+// ReverseIterator implements DB.
+func (idb *InstrumentedDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	began := time.Now()
+	itr, err := idb.db.ReverseIterator(start, end)
+	idb.observe(InstrumentedOpReverseIterator, began, err)
+	if err != nil {
+		return nil, err
+	}
+	return idb.newIterator(itr), nil
+}
+
+// Close implements DB.
+func (idb *InstrumentedDB) Close() error {
+	began := time.Now()
+	err := idb.db.Close()
+	idb.observe(InstrumentedOpClose, began, err)
+	return err
+}
+
+// NewBatch implements DB.
+func (idb *InstrumentedDB) NewBatch() Batch {
+	return &instrumentedBatch{Batch: idb.db.NewBatch(), db: idb}
+}
+
+// Print implements DB.
+func (idb *InstrumentedDB) Print() error {
+	began := time.Now()
+	err := idb.db.Print()
+	idb.observe(InstrumentedOpPrint, began, err)
+	return err
+}
+
+// Stats implements DB.
+func (idb *InstrumentedDB) Stats() map[string]string {
+	return idb.db.Stats()
+}
+
+// Compact implements DB.
+func (idb *InstrumentedDB) Compact(start, end []byte) error {
+	began := time.Now()
+	err := idb.db.Compact(start, end)
+	idb.observe(InstrumentedOpCompact, began, err)
+	return err
+}
+
+// newIterator wraps an iterator which was just opened.
+func (idb *InstrumentedDB) newIterator(itr Iterator) *instrumentedIterator {
+	idb.sink.AddOpenIterators(1)
+	return &instrumentedIterator{Iterator: itr, db: idb}
+}
+
+// instrumentedIterator reports the durations of Next and Close, with the error of the iterator
+// after Next as the error of Next.
+type instrumentedIterator struct {
+	Iterator
+	db *InstrumentedDB
+
+	closeOnce sync.Once
+}
+
+var _ Iterator = (*instrumentedIterator)(nil)
+
+// Next implements Iterator.
+func (itr *instrumentedIterator) Next() {
+	began := time.Now()
+	itr.Iterator.Next()
+	itr.db.observe(InstrumentedOpIteratorNext, began, itr.Iterator.Error())
+}
+
+// Close implements Iterator. Only the first call changes the number of open iterators.
+func (itr *instrumentedIterator) Close() error {
+	began := time.Now()
+	err := itr.Iterator.Close()
+	itr.db.observe(InstrumentedOpIteratorClose, began, err)
+	itr.closeOnce.Do(func() { itr.db.sink.AddOpenIterators(-1) })
+	return err
+}
+
+// instrumentedBatch reports the durations of its operations, and counts its operations as pending
+// until it is written or closed.
+type instrumentedBatch struct {
+	Batch
+	db *InstrumentedDB
+
+	// pending is the number of operations added since the batch was created or last written.
+	pending int
+}
+
+var (
+	_ Batch           = (*instrumentedBatch)(nil)
+	_ ResettableBatch = (*instrumentedBatch)(nil)
+)
+
+// Set implements Batch.
+func (b *instrumentedBatch) Set(key, value []byte) error {
+	began := time.Now()
+	err := b.Batch.Set(key, value)
+	b.db.observe(InstrumentedOpBatchSet, began, err)
+	if err == nil {
+		b.add()
+	}
+	return err
+}
+
+// Delete implements Batch.
+func (b *instrumentedBatch) Delete(key []byte) error {
+	began := time.Now()
+	err := b.Batch.Delete(key)
+	b.db.observe(InstrumentedOpBatchDelete, began, err)
+	if err == nil {
+		b.add()
+	}
+	return err
+}
+
+// Write implements Batch.
+func (b *instrumentedBatch) Write() error {
+	began := time.Now()
+	err := b.Batch.Write()
+	b.db.observe(InstrumentedOpBatchWrite, began, err)
+	if err == nil {
+		b.release()
+	}
+	return err
+}
+
+// WriteSync implements Batch.
+func (b *instrumentedBatch) WriteSync() error {
+	began := time.Now()
+	err := b.Batch.WriteSync()
+	b.db.observe(InstrumentedOpBatchWriteSync, began, err)
+	if err == nil {
+		b.release()
+	}
+	return err
+}
+
+// Close implements Batch.
+func (b *instrumentedBatch) Close() error {
+	began := time.Now()
+	err := b.Batch.Close()
+	b.db.observe(InstrumentedOpBatchClose, began, err)
+	b.release()
+	return err
+}
+
+// Reset implements ResettableBatch.
+func (b *instrumentedBatch) Reset() {
+	b.Batch = resetBatch(b.Batch, b.db.db.NewBatch)
+	b.release()
+}
+
+// add counts an operation added to the batch as pending.
+func (b *instrumentedBatch) add() {
+	b.pending++
+	b.db.sink.AddPendingBatchOps(1)
+}
+
+// release stops counting the operations of the batch as pending.
+func (b *instrumentedBatch) release() {
+	if b.pending > 0 {
+		b.db.sink.AddPendingBatchOps(-b.pending)
+		b.pending = 0
+	}
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMetricsSink records the measurements of an InstrumentedDB.
+type fakeMetricsSink struct {
+	mtx             sync.Mutex
+	ops             map[string]int
+	errors          map[string]int
+	openIterators   int
+	pendingBatchOps int
+}
+
+func newFakeMetricsSink() *fakeMetricsSink {
+	return &fakeMetricsSink{ops: map[string]int{}, errors: map[string]int{}}
+}
+
+func (s *fakeMetricsSink) ObserveOperation(op string, duration time.Duration, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if duration < 0 {
+		panic("negative duration")
+	}
+	s.ops[op]++
+	if err != nil {
+		s.errors[op]++
+	}
+}
+
+func (s *fakeMetricsSink) AddOpenIterators(delta int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.openIterators += delta
+}
+
+func (s *fakeMetricsSink) AddPendingBatchOps(delta int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.pendingBatchOps += delta
+}
+
+func TestInstrumentedDB(t *testing.T) {
+	sink := newFakeMetricsSink()
+	db := NewInstrumentedDBWithSink(NewMemDB(), sink)
+
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	require.NoError(t, db.SetSync(bz("b"), bz("2")))
+	checkValue(t, db, bz("a"), bz("1"))
+	_, err := db.Get(nil)
+	require.ErrorIs(t, err, errKeyEmpty)
+	require.ErrorIs(t, db.Set(nil, bz("1")), errKeyEmpty)
+	ok, err := db.Has(bz("b"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	rev, err := db.ReverseIterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, sink.openIterators)
+	var keys []string
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.Equal(t, []string{"a", "b"}, keys)
+	require.NoError(t, itr.Close())
+	require.NoError(t, itr.Close())
+	require.NoError(t, rev.Close())
+	require.Zero(t, sink.openIterators)
+	_, err = db.Iterator(bz("b"), bz("a"))
+	require.Error(t, err)
+	require.Zero(t, sink.openIterators)
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set(bz("c"), bz("3")))
+	require.NoError(t, batch.Delete(bz("a")))
+	require.ErrorIs(t, batch.Set(nil, bz("3")), errKeyEmpty)
+	require.Equal(t, 2, sink.pendingBatchOps)
+	require.NoError(t, batch.Write())
+	require.Zero(t, sink.pendingBatchOps)
+	require.NoError(t, batch.Close())
+
+	// Closing a batch which was not written releases its pending operations.
+	batch = db.NewBatch()
+	require.NoError(t, batch.Delete(bz("b")))
+	require.Equal(t, 1, sink.pendingBatchOps)
+	require.NoError(t, batch.Close())
+	require.Zero(t, sink.pendingBatchOps)
+	checkValue(t, db, bz("b"), bz("2"))
+
+	require.NoError(t, db.Delete(bz("b")))
+	require.NoError(t, db.Compact(nil, nil))
+	require.NoError(t, db.Close())
+
+	require.Equal(t, map[string]int{
+		InstrumentedOpSet:             2,
+		InstrumentedOpSetSync:         1,
+		InstrumentedOpGet:             3,
+		InstrumentedOpHas:             1,
+		InstrumentedOpIterator:        2,
+		InstrumentedOpReverseIterator: 1,
+		InstrumentedOpIteratorNext:    2,
+		InstrumentedOpIteratorClose:   3,
+		InstrumentedOpBatchSet:        2,
+		InstrumentedOpBatchDelete:     2,
+		InstrumentedOpBatchWrite:      1,
+		InstrumentedOpBatchClose:      2,
+		InstrumentedOpDelete:          1,
+		InstrumentedOpCompact:         1,
+		InstrumentedOpClose:           1,
+	}, sink.ops)
+	require.Equal(t, map[string]int{
+		InstrumentedOpGet:      1,
+		InstrumentedOpSet:      1,
+		InstrumentedOpIterator: 1,
+		InstrumentedOpBatchSet: 1,
+	}, sink.errors)
+}
+
+func TestInstrumentedDBPrometheus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	mdb, err := NewDB(MemDBBackend, nil)
+	require.NoError(t, err)
+	db, err := NewInstrumentedDB(mdb, registry, map[string]string{"name": "state"})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	_, err = db.Get(nil)
+	require.ErrorIs(t, err, errKeyEmpty)
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP cometbft_db_open_iterators Number of open database iterators.
+# TYPE cometbft_db_open_iterators gauge
+cometbft_db_open_iterators{backend="memdb",name="state"} 1
+# HELP cometbft_db_operation_errors_total Number of failed database operations, per operation.
+# TYPE cometbft_db_operation_errors_total counter
+cometbft_db_operation_errors_total{backend="memdb",name="state",op="get"} 1
+`), "cometbft_db_open_iterators", "cometbft_db_operation_errors_total"))
+	require.Equal(t, 3, testutil.CollectAndCount(registry, "cometbft_db_operation_duration_seconds"))
+	require.NoError(t, itr.Close())
+
+	// The metrics of another database must have other labels.
+	_, err = NewInstrumentedDB(mdb, registry, map[string]string{"name": "state"})
+	require.Error(t, err)
+	_, err = NewInstrumentedDB(NewMemDB(), registry, map[string]string{"name": "blockstore"})
+	require.NoError(t, err)
+}
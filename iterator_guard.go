@@ -0,0 +1,59 @@
+package db
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// IteratorMisusePolicy determines what happens when Key, Value or Next is called on an invalid
+// iterator.
+type IteratorMisusePolicy int32
+
+const (
+	// PanicPolicy panics on iterator misuse. This is the default, for compatibility with upstream.
+	PanicPolicy IteratorMisusePolicy = iota
+	// ErrorPolicy records a sticky error on iterator misuse, which is returned by Error, and
+	// returns zero values instead of panicking.
+	ErrorPolicy
+)
+
+// errIteratorInvalid is recorded when an invalid iterator is used under ErrorPolicy.
+var errIteratorInvalid = errors.New("iterator is invalid")
+
+var iteratorMisusePolicy atomic.Int32
+
+// SetIteratorMisusePolicy sets the package-wide iterator misuse policy. It applies to all
+// iterators, including those which are already open.
+func SetIteratorMisusePolicy(policy IteratorMisusePolicy) {
+	iteratorMisusePolicy.Store(int32(policy))
+}
+
+// GetIteratorMisusePolicy returns the package-wide iterator misuse policy.
+func GetIteratorMisusePolicy() IteratorMisusePolicy {
+	return IteratorMisusePolicy(iteratorMisusePolicy.Load())
+}
+
+// iteratorGuard implements the iterator misuse policy, and is embedded by every iterator.
+type iteratorGuard struct {
+	misuseErr error
+}
+
+// guard returns valid. If the iterator is not valid, it either panics or records a sticky error
+// depending on the iterator misuse policy.
+func (g *iteratorGuard) guard(valid bool) bool {
+	if valid {
+		return true
+	}
+	if GetIteratorMisusePolicy() != ErrorPolicy {
+		panic("iterator is invalid")
+	}
+	if g.misuseErr == nil {
+		g.misuseErr = errIteratorInvalid
+	}
+	return false
+}
+
+// misuseError returns the sticky misuse error, if any.
+func (g *iteratorGuard) misuseError() error {
+	return g.misuseErr
+}
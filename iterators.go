@@ -0,0 +1,418 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// errIteratorOrder is returned by composed iterators when a source yields keys out of order.
+var errIteratorOrder = errors.New("iterator keys are out of order")
+
+// coverDomain returns the smallest domain covering the domains of all iterators.
+func coverDomain(its []Iterator) (start, end []byte) {
+	for i, it := range its {
+		s, e := it.Domain()
+		if i == 0 || (start != nil && (s == nil || bytes.Compare(s, start) < 0)) {
+			start = s
+		}
+		if i == 0 || (end != nil && (e == nil || bytes.Compare(e, end) > 0)) {
+			end = e
+		}
+	}
+	return start, end
+}
+
+// closeIterators closes all iterators, and returns their errors joined.
+func closeIterators(its []Iterator) error {
+	var errs []error
+	for _, it := range its {
+		errs = append(errs, it.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// iteratorErrors returns the errors of all iterators joined.
+func iteratorErrors(its []Iterator) error {
+	var errs []error
+	for _, it := range its {
+		errs = append(errs, it.Error())
+	}
+	return errors.Join(errs...)
+}
+
+// MergeIterators merges iterators into a single iterator over all their keys, in ascending order if
+// asc is set and descending order otherwise. The iterators must all iterate in that order. If
+// several iterators contain the same key, the entry of the first of them is used. Keys and values
+// are returned as given by the source iterators, and closing the merged iterator closes them all.
+// If a source yields keys out of order, the merged iterator becomes invalid and Error returns an
+// error.
+func MergeIterators(asc bool, its ...Iterator) Iterator {
+	itr := &mergeIterator{sources: its, asc: asc}
+	itr.settle()
+	return itr
+}
+
+type mergeIterator struct {
+	iteratorGuard
+
+	sources []Iterator
+	asc     bool
+	// cur is the index of the source positioned at the current key, or -1 once exhausted.
+	cur int
+	// last is a copy of the current key, used to skip duplicates and check the key order.
+	last []byte
+	err  error
+}
+
+var _ Iterator = (*mergeIterator)(nil)
+
+// before returns whether a comes before b in iteration order.
+func (itr *mergeIterator) before(a, b []byte) bool {
+	if itr.asc {
+		return bytes.Compare(a, b) < 0
+	}
+	return bytes.Compare(a, b) > 0
+}
+
+// settle positions the iterator on the first key in iteration order among all sources, preferring
+// the first source on equal keys. A source which fails stops the iteration, rather than silently
+// omitting its remaining keys.
+func (itr *mergeIterator) settle() {
+	itr.cur = -1
+	var key []byte
+	for i, source := range itr.sources {
+		if !source.Valid() {
+			if source.Error() != nil {
+				itr.cur = -1
+				return
+			}
+			continue
+		}
+		if k := source.Key(); itr.cur < 0 || itr.before(k, key) {
+			itr.cur, key = i, k
+		}
+	}
+	if itr.cur < 0 {
+		return
+	}
+	if itr.last != nil && !itr.before(itr.last, key) {
+		itr.err = fmt.Errorf("%w: merged key %X after %X", errIteratorOrder, key, itr.last)
+		itr.cur = -1
+		return
+	}
+	itr.last = cp(key)
+}
+
+// Domain implements Iterator. It covers the domains of all sources.
+func (itr *mergeIterator) Domain() (start []byte, end []byte) {
+	return coverDomain(itr.sources)
+}
+
+// Valid implements Iterator.
+func (itr *mergeIterator) Valid() bool {
+	return itr.cur >= 0
+}
+
+// Next implements Iterator.
+func (itr *mergeIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	// Advance every source positioned at the current key, skipping the duplicates.
+	for _, source := range itr.sources {
+		if source.Valid() && bytes.Equal(source.Key(), itr.last) {
+			source.Next()
+		}
+	}
+	itr.settle()
+}
+
+// Key implements Iterator.
+func (itr *mergeIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.sources[itr.cur].Key()
+}
+
+// Value implements Iterator.
+func (itr *mergeIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.sources[itr.cur].Value()
+}
+
+// Error implements Iterator.
+func (itr *mergeIterator) Error() error {
+	if err := errors.Join(iteratorErrors(itr.sources), itr.err); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *mergeIterator) Close() error {
+	return closeIterators(itr.sources)
+}
+
+// ConcatIterators concatenates ascending iterators over disjoint domains, given in ascending order
+// of their domains. Keys and values are returned as given by the source iterators, and closing the
+// concatenated iterator closes them all. If a key is not strictly greater than the previous one,
+// e.g. because the domains overlap, the concatenated iterator becomes invalid and Error returns an
+// error.
+func ConcatIterators(its ...Iterator) Iterator {
+	itr := &concatIterator{sources: its}
+	itr.settle()
+	return itr
+}
+
+type concatIterator struct {
+	iteratorGuard
+
+	sources []Iterator
+	// pos is the index of the current source, or len(sources) once exhausted.
+	pos int
+	// last is a copy of the current key, used to check the key order.
+	last []byte
+	err  error
+}
+
+var _ Iterator = (*concatIterator)(nil)
+
+// settle moves to the next source with a valid position, and checks the order of its key. A
+// source which fails stops the iteration, rather than silently skipping its remaining keys.
+func (itr *concatIterator) settle() {
+	for itr.pos < len(itr.sources) && !itr.sources[itr.pos].Valid() {
+		if itr.sources[itr.pos].Error() != nil {
+			itr.pos = len(itr.sources)
+			return
+		}
+		itr.pos++
+	}
+	if itr.pos == len(itr.sources) {
+		return
+	}
+	key := itr.sources[itr.pos].Key()
+	if itr.last != nil && bytes.Compare(key, itr.last) <= 0 {
+		itr.err = fmt.Errorf("%w: concatenated key %X after %X", errIteratorOrder, key, itr.last)
+		itr.pos = len(itr.sources)
+		return
+	}
+	itr.last = cp(key)
+}
+
+// Domain implements Iterator. It covers the domains of all sources.
+func (itr *concatIterator) Domain() (start []byte, end []byte) {
+	return coverDomain(itr.sources)
+}
+
+// Valid implements Iterator.
+func (itr *concatIterator) Valid() bool {
+	return itr.pos < len(itr.sources)
+}
+
+// Next implements Iterator.
+func (itr *concatIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	itr.sources[itr.pos].Next()
+	itr.settle()
+}
+
+// Key implements Iterator.
+func (itr *concatIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.sources[itr.pos].Key()
+}
+
+// Value implements Iterator.
+func (itr *concatIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.sources[itr.pos].Value()
+}
+
+// Error implements Iterator.
+func (itr *concatIterator) Error() error {
+	if err := errors.Join(iteratorErrors(itr.sources), itr.err); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *concatIterator) Close() error {
+	return closeIterators(itr.sources)
+}
+
+// FilterIterator returns an iterator over the entries of it for which keep returns true. Keys and
+// values are returned as given by it, and closing the filtered iterator closes it.
+func FilterIterator(it Iterator, keep func(key, value []byte) bool) Iterator {
+	itr := &filterIterator{source: it, keep: keep}
+	itr.skip()
+	return itr
+}
+
+type filterIterator struct {
+	iteratorGuard
+
+	source Iterator
+	keep   func(key, value []byte) bool
+}
+
+var _ Iterator = (*filterIterator)(nil)
+
+// skip advances the source past any entries which are not kept.
+func (itr *filterIterator) skip() {
+	for itr.source.Valid() && !itr.keep(itr.source.Key(), itr.source.Value()) {
+		itr.source.Next()
+	}
+}
+
+// Domain implements Iterator.
+func (itr *filterIterator) Domain() (start []byte, end []byte) {
+	return itr.source.Domain()
+}
+
+// Valid implements Iterator.
+func (itr *filterIterator) Valid() bool {
+	return itr.source.Valid()
+}
+
+// Next implements Iterator.
+func (itr *filterIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	itr.source.Next()
+	itr.skip()
+}
+
+// Key implements Iterator.
+func (itr *filterIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Key()
+}
+
+// Value implements Iterator.
+func (itr *filterIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Value()
+}
+
+// Error implements Iterator.
+func (itr *filterIterator) Error() error {
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *filterIterator) Close() error {
+	return itr.source.Close()
+}
+
+// GroupIterator returns an iterator over the first entry of each group of it, in iteration order,
+// where group returns the group portion of a key, which must be a prefix of the key. For a reverse
+// iterator (asc false) the first entry of a group is its greatest key, e.g. the latest entry of
+// each group with keys such as prefix/group/id. If it implements SeekIterator, the remainder of
+// each group is skipped by seeking past it, otherwise by advancing through it. Closing the group
+// iterator closes it.
+func GroupIterator(it Iterator, asc bool, group func(key []byte) []byte) Iterator {
+	return &groupIterator{source: it, asc: asc, group: group}
+}
+
+type groupIterator struct {
+	iteratorGuard
+
+	source Iterator
+	asc    bool
+	group  func(key []byte) []byte
+}
+
+var _ Iterator = (*groupIterator)(nil)
+
+// groupEnd returns the smallest key greater than all keys with the given prefix, or nil if there
+// is none.
+func groupEnd(prefix []byte) []byte {
+	end := cp(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return nil
+	}
+	end[len(end)-1]++
+	return end
+}
+
+// Domain implements Iterator.
+func (itr *groupIterator) Domain() (start []byte, end []byte) {
+	return itr.source.Domain()
+}
+
+// Valid implements Iterator.
+func (itr *groupIterator) Valid() bool {
+	return itr.source.Valid()
+}
+
+// Next implements Iterator.
+func (itr *groupIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	current := cp(itr.group(itr.source.Key()))
+
+	if seeker, ok := itr.source.(SeekIterator); ok && len(current) > 0 {
+		if !itr.asc {
+			// Seeks to the last key <= current, which is in the group only if it is current.
+			seeker.Seek(current)
+		} else if end := groupEnd(current); end != nil {
+			seeker.Seek(end)
+		}
+	}
+	// Advance through whatever remains of the group, which is all of it without a SeekIterator.
+	for itr.source.Valid() && bytes.Equal(itr.group(itr.source.Key()), current) {
+		itr.source.Next()
+	}
+}
+
+// Key implements Iterator.
+func (itr *groupIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Key()
+}
+
+// Value implements Iterator.
+func (itr *groupIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Value()
+}
+
+// Error implements Iterator.
+func (itr *groupIterator) Error() error {
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *groupIterator) Close() error {
+	return itr.source.Close()
+}
@@ -0,0 +1,384 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newIteratorTestDB returns a MemDB with each key set to the given value.
+func newIteratorTestDB(t *testing.T, value string, keys ...string) *MemDB {
+	db := NewMemDB()
+	for _, key := range keys {
+		require.NoError(t, db.Set([]byte(key), []byte(value)))
+	}
+	return db
+}
+
+// trackedIterator records whether it was closed, and can fail with err.
+type trackedIterator struct {
+	Iterator
+	err    error
+	closed bool
+}
+
+func (itr *trackedIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.Iterator.Error()
+}
+
+func (itr *trackedIterator) Close() error {
+	itr.closed = true
+	return itr.Iterator.Close()
+}
+
+func TestMergeIterators(t *testing.T) {
+	dbs := []*MemDB{
+		newIteratorTestDB(t, "1", "a", "c", "e"),
+		newIteratorTestDB(t, "2", "b", "c", "f"),
+		newIteratorTestDB(t, "3", "c", "d", "g", "h"),
+	}
+	iterators := func(reverse bool) []Iterator {
+		var its []Iterator
+		for _, db := range dbs {
+			var itr Iterator
+			var err error
+			if reverse {
+				itr, err = db.ReverseIterator(nil, []byte("h"))
+			} else {
+				itr, err = db.Iterator(nil, []byte("h"))
+			}
+			require.NoError(t, err)
+			its = append(its, itr)
+		}
+		return its
+	}
+
+	expected := [][2]string{{"a", "1"}, {"b", "2"}, {"c", "1"}, {"d", "3"}, {"e", "1"}, {"f", "2"}, {"g", "3"}}
+	require.Equal(t, expected, collectIterator(t, MergeIterators(true, iterators(false)...)))
+
+	// Reverse merges keep the first source on equal keys as well.
+	reversed := make([][2]string, len(expected))
+	for i, pair := range expected {
+		reversed[len(expected)-1-i] = pair
+	}
+	require.Equal(t, reversed, collectIterator(t, MergeIterators(false, iterators(true)...)))
+
+	// Later sources win if given first.
+	its := iterators(false)
+	its[0], its[2] = its[2], its[0]
+	merged := collectIterator(t, MergeIterators(true, its...))
+	require.Equal(t, [2]string{"c", "3"}, merged[2])
+
+	// All sources at the same keys yield each key once.
+	its = []Iterator{}
+	for i := 0; i < 3; i++ {
+		itr, err := newIteratorTestDB(t, fmt.Sprint(i), "x", "y").Iterator(nil, nil)
+		require.NoError(t, err)
+		its = append(its, itr)
+	}
+	require.Equal(t, [][2]string{{"x", "0"}, {"y", "0"}}, collectIterator(t, MergeIterators(true, its...)))
+
+	// Empty merges are invalid.
+	require.Empty(t, collectIterator(t, MergeIterators(true)))
+}
+
+func TestMergeIteratorsDomain(t *testing.T) {
+	db := newIteratorTestDB(t, "v", "a", "b", "c")
+	first, err := db.Iterator([]byte("b"), []byte("c"))
+	require.NoError(t, err)
+	second, err := db.Iterator([]byte("a"), []byte("bb"))
+	require.NoError(t, err)
+	itr := MergeIterators(true, first, second)
+	start, end := itr.Domain()
+	require.Equal(t, []byte("a"), start)
+	require.Equal(t, []byte("c"), end)
+	require.NoError(t, itr.Close())
+
+	first, err = db.Iterator([]byte("b"), nil)
+	require.NoError(t, err)
+	second, err = db.Iterator([]byte("a"), []byte("b"))
+	require.NoError(t, err)
+	itr = MergeIterators(true, first, second)
+	start, end = itr.Domain()
+	require.Equal(t, []byte("a"), start)
+	require.Nil(t, end)
+	require.NoError(t, itr.Close())
+}
+
+func TestMergeIteratorsOrder(t *testing.T) {
+	// A source iterating in the wrong direction is detected.
+	db := newIteratorTestDB(t, "v", "a", "b", "c")
+	source, err := db.ReverseIterator(nil, nil)
+	require.NoError(t, err)
+	itr := MergeIterators(true, source)
+	require.True(t, itr.Valid())
+	itr.Next()
+	require.False(t, itr.Valid())
+	require.ErrorIs(t, itr.Error(), errIteratorOrder)
+	require.NoError(t, itr.Close())
+}
+
+func TestMergeIteratorsRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		n := rng.Intn(5)
+		// The first source containing a key wins, so later sources only add new keys.
+		expected := map[string]string{}
+		var asc, desc []Iterator
+		for i := 0; i < n; i++ {
+			db := NewMemDB()
+			for j := rng.Intn(20); j > 0; j-- {
+				key := fmt.Sprintf("%02d", rng.Intn(30))
+				require.NoError(t, db.Set([]byte(key), []byte(fmt.Sprint(i))))
+				if _, ok := expected[key]; !ok {
+					expected[key] = fmt.Sprint(i)
+				}
+			}
+			itr, err := db.Iterator(nil, nil)
+			require.NoError(t, err)
+			asc = append(asc, itr)
+			itr, err = db.ReverseIterator(nil, nil)
+			require.NoError(t, err)
+			desc = append(desc, itr)
+		}
+
+		pairs := [][2]string{}
+		for key, value := range expected {
+			pairs = append(pairs, [2]string{key, value})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+		require.Equal(t, pairs, collectIterator(t, MergeIterators(true, asc...)))
+		for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+			pairs[i], pairs[j] = pairs[j], pairs[i]
+		}
+		require.Equal(t, pairs, collectIterator(t, MergeIterators(false, desc...)))
+	}
+}
+
+func TestConcatIterators(t *testing.T) {
+	db := newIteratorTestDB(t, "v", "a", "b", "c", "d", "e")
+	ranges := [][2][]byte{{nil, []byte("b")}, {[]byte("b"), []byte("bb")}, {[]byte("bb"), []byte("d")}, {[]byte("d"), nil}}
+	var its []Iterator
+	for _, r := range ranges {
+		itr, err := db.Iterator(r[0], r[1])
+		require.NoError(t, err)
+		its = append(its, itr)
+	}
+	itr := ConcatIterators(its...)
+	start, end := itr.Domain()
+	require.Nil(t, start)
+	require.Nil(t, end)
+	require.Equal(t, [][2]string{{"a", "v"}, {"b", "v"}, {"c", "v"}, {"d", "v"}, {"e", "v"}}, collectIterator(t, itr))
+
+	// Overlapping sources are detected.
+	first, err := db.Iterator(nil, []byte("c"))
+	require.NoError(t, err)
+	second, err := db.Iterator([]byte("b"), nil)
+	require.NoError(t, err)
+	itr = ConcatIterators(first, second)
+	var keys []string
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.Equal(t, []string{"a", "b"}, keys)
+	require.ErrorIs(t, itr.Error(), errIteratorOrder)
+	require.NoError(t, itr.Close())
+
+	require.Empty(t, collectIterator(t, ConcatIterators()))
+}
+
+func TestFilterIterator(t *testing.T) {
+	db := NewMemDB()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set([]byte{byte('a' + i)}, []byte{byte(i)}))
+	}
+	source, err := db.Iterator([]byte("b"), []byte("j"))
+	require.NoError(t, err)
+	itr := FilterIterator(source, func(_, value []byte) bool { return value[0]%3 == 0 })
+	start, end := itr.Domain()
+	require.Equal(t, []byte("b"), start)
+	require.Equal(t, []byte("j"), end)
+	require.Equal(t, [][2]string{{"d", "\x03"}, {"g", "\x06"}}, collectIterator(t, itr))
+
+	source, err = db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, collectIterator(t, FilterIterator(source, func(_, _ []byte) bool { return false })))
+}
+
+func TestComposedIteratorsCloseAndError(t *testing.T) {
+	db := newIteratorTestDB(t, "v", "a", "b")
+	failing := errors.New("source failed")
+	newSources := func() []*trackedIterator {
+		var sources []*trackedIterator
+		for i := 0; i < 2; i++ {
+			itr, err := db.Iterator(nil, nil)
+			require.NoError(t, err)
+			sources = append(sources, &trackedIterator{Iterator: itr})
+		}
+		sources[1].err = failing
+		return sources
+	}
+	compose := map[string]func([]Iterator) Iterator{
+		"Merge":  func(its []Iterator) Iterator { return MergeIterators(true, its...) },
+		"Concat": func(its []Iterator) Iterator { return ConcatIterators(its...) },
+		"Filter": func(its []Iterator) Iterator {
+			return MergeIterators(true, FilterIterator(its[0], func(_, _ []byte) bool { return true }), its[1])
+		},
+	}
+	for name, fn := range compose {
+		t.Run(name, func(t *testing.T) {
+			sources := newSources()
+			its := []Iterator{sources[0], sources[1]}
+			itr := fn(its)
+			require.ErrorIs(t, itr.Error(), failing)
+			require.NoError(t, itr.Close())
+			for _, source := range sources {
+				require.True(t, source.closed)
+			}
+		})
+	}
+}
+
+// groupTestData fills db with groups of keys g/<group>/<id> of very different sizes, and returns
+// the first and last key of each group in ascending group order.
+func groupTestData(t *testing.T, db DB) (first, last []string) {
+	sizes := []int{1, 500, 2, 1, 1000, 3}
+	for g, size := range sizes {
+		for id := 0; id < size; id++ {
+			require.NoError(t, db.Set([]byte(fmt.Sprintf("g/%02d/%04d", g, id)), []byte{byte(g)}))
+		}
+		first = append(first, fmt.Sprintf("g/%02d/%04d", g, 0))
+		last = append(last, fmt.Sprintf("g/%02d/%04d", g, size-1))
+	}
+	// Keys outside the groups.
+	require.NoError(t, db.Set([]byte("a"), []byte{}))
+	require.NoError(t, db.Set([]byte("g0"), []byte{}))
+	return first, last
+}
+
+// testGroupPrefix returns the g/<group>/ prefix of a key.
+func testGroupPrefix(key []byte) []byte {
+	if !bytes.HasPrefix(key, []byte("g/")) {
+		return key
+	}
+	if i := bytes.IndexByte(key[2:], '/'); i >= 0 {
+		return key[:i+3]
+	}
+	return key
+}
+
+// nextCounter counts the calls to Next of an iterator, and hides any SeekIterator implementation.
+type nextCounter struct {
+	Iterator
+	nexts int
+}
+
+func (itr *nextCounter) Next() {
+	itr.nexts++
+	itr.Iterator.Next()
+}
+
+// seekCounter counts the calls to Next of a seeking iterator.
+type seekCounter struct {
+	nextCounter
+}
+
+func (itr *seekCounter) Seek(key []byte) bool {
+	return itr.Iterator.(SeekIterator).Seek(key)
+}
+
+func checkGroupIterator(t *testing.T, db DB) {
+	first, last := groupTestData(t, db)
+	start, end := []byte("g/"), []byte("g/99")
+
+	it, err := db.Iterator(start, end)
+	require.NoError(t, err)
+	itr := GroupIterator(it, true, testGroupPrefix)
+	domainStart, domainEnd := itr.Domain()
+	require.Equal(t, start, domainStart)
+	require.Equal(t, end, domainEnd)
+	var keys []string
+	for _, kv := range collectIterator(t, itr) {
+		keys = append(keys, kv[0])
+	}
+	require.Equal(t, first, keys)
+
+	it, err = db.ReverseIterator(start, end)
+	require.NoError(t, err)
+	keys = nil
+	for _, kv := range collectIterator(t, GroupIterator(it, false, testGroupPrefix)) {
+		keys = append(keys, kv[0])
+	}
+	for i := len(last) - 1; i >= 0; i-- {
+		require.Equal(t, last[i], keys[len(last)-1-i])
+	}
+	require.Len(t, keys, len(last))
+
+	// Unbounded iteration includes the keys outside the groups.
+	it, err = db.Iterator(nil, nil)
+	require.NoError(t, err)
+	keys = nil
+	for _, kv := range collectIterator(t, GroupIterator(it, true, testGroupPrefix)) {
+		keys = append(keys, kv[0])
+	}
+	require.Equal(t, append(append([]string{"a"}, first...), "g0"), keys)
+}
+
+func TestGroupIterator(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) { checkGroupIterator(t, NewMemDB()) })
+	t.Run("GoLevelDB", func(t *testing.T) {
+		db, err := NewGoLevelDB("groups", t.TempDir())
+		require.NoError(t, err)
+		defer db.Close()
+		checkGroupIterator(t, db)
+	})
+}
+
+func TestGroupIteratorSeeks(t *testing.T) {
+	db, err := NewGoLevelDB("groups", t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+	first, last := groupTestData(t, db)
+
+	for _, asc := range []bool{true, false} {
+		// Without seeking, all keys of the groups are visited.
+		var it Iterator
+		if asc {
+			it, err = db.Iterator([]byte("g/"), []byte("g/99"))
+		} else {
+			it, err = db.ReverseIterator([]byte("g/"), []byte("g/99"))
+		}
+		require.NoError(t, err)
+		plain := &nextCounter{Iterator: it}
+		require.Len(t, collectIterator(t, GroupIterator(plain, asc, testGroupPrefix)), len(first))
+		require.Equal(t, 1507, plain.nexts)
+
+		// With seeking, the groups are skipped.
+		if asc {
+			it, err = db.Iterator([]byte("g/"), []byte("g/99"))
+		} else {
+			it, err = db.ReverseIterator([]byte("g/"), []byte("g/99"))
+		}
+		require.NoError(t, err)
+		seeking := &seekCounter{nextCounter{Iterator: it}}
+		groups := collectIterator(t, GroupIterator(seeking, asc, testGroupPrefix))
+		require.Len(t, groups, len(last))
+		require.Zero(t, seeking.nexts)
+	}
+}
+
+func TestGroupEnd(t *testing.T) {
+	require.Equal(t, []byte("ab"), groupEnd([]byte("aa")))
+	require.Equal(t, []byte("b"), groupEnd([]byte("a\xff\xff")))
+	require.Nil(t, groupEnd([]byte("\xff")))
+	require.Nil(t, groupEnd(nil))
+}
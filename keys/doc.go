@@ -0,0 +1,17 @@
+/*
+keys is a package for building composite database keys, such as a block key made of a height and
+a hash, without ad-hoc delimiters.
+
+Keys are encoded such that comparing two encoded keys byte-wise gives the same result as comparing
+their components one by one, so range iteration over composite keys follows component order:
+
+	key := keys.Append(nil, keys.String("block"), keys.Uint64(height), keys.Bytes(hash))
+
+Every component is self-delimiting, so the encoding of the leading components is a prefix of the
+keys which start with them, and can be passed to db.IteratePrefix:
+
+	itr, err := db.IteratePrefix(store, keys.PrefixOf(keys.String("block")))
+
+Encoded keys are decoded with Split.
+*/
+package keys
@@ -0,0 +1,149 @@
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Kind is the type of a key component. Components of different kinds order by kind.
+type Kind byte
+
+const (
+	// KindBytes is a raw byte string component.
+	KindBytes Kind = 0x01
+	// KindString is a string component.
+	KindString Kind = 0x02
+	// KindUint64 is an unsigned 64-bit integer component.
+	KindUint64 Kind = 0x03
+)
+
+// Byte strings are terminated by escapeByte followed by terminatorByte, and occurrences of
+// escapeByte in the content are followed by escapedByte. Since terminatorByte sorts below
+// escapedByte and every other content byte, a byte string sorts before all its extensions.
+const (
+	escapeByte     = 0x00
+	terminatorByte = 0x01
+	escapedByte    = 0xff
+)
+
+var errTruncated = errors.New("truncated key")
+
+// Component is a single component of a composite key.
+type Component struct {
+	kind   Kind
+	bytes  []byte
+	number uint64
+}
+
+// Bytes returns a raw byte string component.
+func Bytes(b []byte) Component {
+	return Component{kind: KindBytes, bytes: b}
+}
+
+// String returns a string component.
+func String(s string) Component {
+	return Component{kind: KindString, bytes: []byte(s)}
+}
+
+// Uint64 returns an unsigned integer component.
+func Uint64(n uint64) Component {
+	return Component{kind: KindUint64, number: n}
+}
+
+// Kind returns the kind of the component.
+func (c Component) Kind() Kind {
+	return c.kind
+}
+
+// Bytes returns the content of a bytes or string component.
+func (c Component) Bytes() []byte {
+	return c.bytes
+}
+
+// Text returns the content of a string or bytes component as a string.
+func (c Component) Text() string {
+	return string(c.bytes)
+}
+
+// Uint64 returns the value of an integer component.
+func (c Component) Uint64() uint64 {
+	return c.number
+}
+
+// Append appends the encoding of the components to buf, and returns the extended buffer.
+func Append(buf []byte, components ...Component) []byte {
+	for _, c := range components {
+		buf = append(buf, byte(c.kind))
+		switch c.kind {
+		case KindUint64:
+			buf = binary.BigEndian.AppendUint64(buf, c.number)
+		default:
+			for _, b := range c.bytes {
+				buf = append(buf, b)
+				if b == escapeByte {
+					buf = append(buf, escapedByte)
+				}
+			}
+			buf = append(buf, escapeByte, terminatorByte)
+		}
+	}
+	return buf
+}
+
+// PrefixOf returns the encoding of the components, which is a prefix of every key starting with
+// them. It is intended for use with IteratePrefix.
+func PrefixOf(components ...Component) []byte {
+	return Append(nil, components...)
+}
+
+// Split decodes a key encoded by Append into its components. Byte string components are copied.
+func Split(key []byte) ([]Component, error) {
+	var components []Component
+	for len(key) > 0 {
+		kind := Kind(key[0])
+		key = key[1:]
+		switch kind {
+		case KindUint64:
+			if len(key) < 8 {
+				return nil, errTruncated
+			}
+			components = append(components, Uint64(binary.BigEndian.Uint64(key)))
+			key = key[8:]
+		case KindBytes, KindString:
+			content, rest, err := splitBytes(key)
+			if err != nil {
+				return nil, err
+			}
+			components = append(components, Component{kind: kind, bytes: content})
+			key = rest
+		default:
+			return nil, fmt.Errorf("invalid component kind 0x%02x", byte(kind))
+		}
+	}
+	return components, nil
+}
+
+// splitBytes decodes an escaped byte string, returning its content and the remaining key.
+func splitBytes(key []byte) (content, rest []byte, err error) {
+	content = []byte{}
+	for i := 0; i < len(key); i++ {
+		if key[i] != escapeByte {
+			content = append(content, key[i])
+			continue
+		}
+		if i+1 == len(key) {
+			return nil, nil, errTruncated
+		}
+		switch key[i+1] {
+		case terminatorByte:
+			return content, key[i+2:], nil
+		case escapedByte:
+			content = append(content, escapeByte)
+			i++
+		default:
+			return nil, nil, fmt.Errorf("invalid escape sequence 0x%02x%02x", key[i], key[i+1])
+		}
+	}
+	return nil, nil, errTruncated
+}
@@ -0,0 +1,139 @@
+package keys_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft-db/keys"
+)
+
+// alphabet is biased towards the bytes used by the escaping.
+var alphabet = []byte{0x00, 0x01, 0x02, 0xfe, 0xff, 'a'}
+
+func randomComponent(r *rand.Rand) keys.Component {
+	switch r.Intn(3) {
+	case 0:
+		return keys.Uint64([]uint64{0, 1, 255, 256, 1 << 63, ^uint64(0), r.Uint64()}[r.Intn(7)])
+	default:
+		b := make([]byte, r.Intn(5))
+		for i := range b {
+			b[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		if r.Intn(2) == 0 {
+			return keys.String(string(b))
+		}
+		return keys.Bytes(b)
+	}
+}
+
+func randomTuple(r *rand.Rand) []keys.Component {
+	tuple := make([]keys.Component, r.Intn(4))
+	for i := range tuple {
+		tuple[i] = randomComponent(r)
+	}
+	return tuple
+}
+
+func compareComponent(a, b keys.Component) int {
+	if a.Kind() != b.Kind() {
+		if a.Kind() < b.Kind() {
+			return -1
+		}
+		return 1
+	}
+	if a.Kind() == keys.KindUint64 {
+		switch {
+		case a.Uint64() < b.Uint64():
+			return -1
+		case a.Uint64() > b.Uint64():
+			return 1
+		default:
+			return 0
+		}
+	}
+	return bytes.Compare(a.Bytes(), b.Bytes())
+}
+
+func compareTuple(a, b []keys.Component) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareComponent(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func TestOrderPreserving(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		a, b := randomTuple(r), randomTuple(r)
+		// Make shared leading components likely.
+		if len(a) > 0 && len(b) > 0 && r.Intn(2) == 0 {
+			b[0] = a[0]
+		}
+		require.Equal(t, compareTuple(a, b), bytes.Compare(keys.Append(nil, a...), keys.Append(nil, b...)),
+			"tuples %v and %v", a, b)
+	}
+}
+
+func TestSplitRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 10000; i++ {
+		tuple := randomTuple(r)
+		key := keys.Append([]byte{}, tuple...)
+		split, err := keys.Split(key)
+		require.NoError(t, err)
+		require.Len(t, split, len(tuple))
+		for j := range tuple {
+			require.Zero(t, compareComponent(tuple[j], split[j]), "key %x component %d", key, j)
+		}
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	for _, key := range [][]byte{
+		{0x00},
+		{byte(keys.KindUint64), 0x01},
+		{byte(keys.KindBytes), 'a'},
+		{byte(keys.KindBytes), 'a', 0x00},
+		{byte(keys.KindString), 0x00, 0x02},
+	} {
+		_, err := keys.Split(key)
+		require.Error(t, err, "key %x", key)
+	}
+}
+
+func TestPrefixOf(t *testing.T) {
+	mdb := db.NewMemDB()
+	for _, name := range []string{"block", "block\x00", "blocks"} {
+		for height := uint64(1); height <= 3; height++ {
+			key := keys.Append(nil, keys.String(name), keys.Uint64(height), keys.Bytes([]byte{0x00, byte(height)}))
+			require.NoError(t, mdb.Set(key, []byte{byte(height)}))
+		}
+	}
+
+	itr, err := db.IteratePrefix(mdb, keys.PrefixOf(keys.String("block")))
+	require.NoError(t, err)
+	defer itr.Close()
+
+	heights := []uint64{}
+	for ; itr.Valid(); itr.Next() {
+		components, err := keys.Split(itr.Key())
+		require.NoError(t, err)
+		require.Equal(t, "block", components[0].Text())
+		heights = append(heights, components[1].Uint64())
+	}
+	require.NoError(t, itr.Error())
+	require.Equal(t, []uint64{1, 2, 3}, heights)
+}
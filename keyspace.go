@@ -0,0 +1,141 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// KeyRange is a range of keys, from Start inclusive to End exclusive. A nil Start is the first key,
+// and a nil End is past the last key.
+type KeyRange struct {
+	Start []byte
+	End   []byte
+}
+
+// KeySpaceReport describes the distribution of the keys of a database, see AnalyzeKeySpace.
+type KeySpaceReport struct {
+	// Keys and Bytes are the number and total size of the keys and values, which are estimated
+	// when the backend does not count them exactly.
+	Keys  int64
+	Bytes int64
+	// Sampled is the number of keys sampled.
+	Sampled int
+	// Buckets are contiguous key ranges holding roughly the same number of keys, in key order. The
+	// first bucket starts at the first key, and the last one ends past the last key, so that
+	// together they cover the whole key space.
+	Buckets []KeySpaceBucket
+}
+
+// KeySpaceBucket is a bucket of a KeySpaceReport.
+type KeySpaceBucket struct {
+	Range KeyRange
+	// Keys and Bytes are the estimated number and total size of the keys and values in the range.
+	Keys  int64
+	Bytes int64
+}
+
+// Ranges returns the key ranges of the buckets, e.g. to split a scan or a database into shards of
+// similar size.
+func (r KeySpaceReport) Ranges() []KeyRange {
+	ranges := make([]KeyRange, len(r.Buckets))
+	for i, b := range r.Buckets {
+		ranges[i] = b.Range
+	}
+	return ranges
+}
+
+// AnalyzeKeySpace samples up to sample keys of db, and splits the key space into at most buckets
+// ranges holding roughly the same number of keys, whose boundaries are sampled keys. Fewer buckets
+// are returned if fewer keys are sampled. It uses KeySpaceAnalyzer if the database implements it,
+// and otherwise iterates over all keys, counting them exactly and keeping a uniform sample.
+func AnalyzeKeySpace(db DB, buckets int, sample int) (KeySpaceReport, error) {
+	if buckets <= 0 {
+		return KeySpaceReport{}, fmt.Errorf("invalid bucket count %d", buckets)
+	}
+	if sample <= 0 {
+		return KeySpaceReport{}, fmt.Errorf("invalid sample size %d", sample)
+	}
+	if a, ok := db.(KeySpaceAnalyzer); ok {
+		return a.AnalyzeKeySpace(buckets, sample)
+	}
+	return iterateKeySpace(db, buckets, sample)
+}
+
+// keySample is a sampled key, with the size of its key and value.
+type keySample struct {
+	key  []byte
+	size int64
+}
+
+// iterateKeySpace analyzes the key space by iterating over all keys, with reservoir sampling.
+func iterateKeySpace(db DB, buckets int, sample int) (KeySpaceReport, error) {
+	samples, keys, size, err := sampleKeys(db, sample)
+	if err != nil {
+		return KeySpaceReport{}, err
+	}
+	sort.Slice(samples, func(i, j int) bool { return bytes.Compare(samples[i].key, samples[j].key) < 0 })
+	return KeySpaceReport{
+		Keys:    keys,
+		Bytes:   size,
+		Sampled: len(samples),
+		Buckets: keySpaceBuckets(samples, buckets, keys),
+	}, nil
+}
+
+// sampleKeys iterates over all keys of db, and returns a uniform sample of up to sample keys in
+// no particular order, with the number and total size of all keys and values.
+func sampleKeys(db DB, sample int) (samples []keySample, keys, size int64, err error) {
+	itr, err := db.Iterator(nil, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer itr.Close()
+
+	rnd := rand.New(rand.NewSource(rand.Int63())) //nolint:gosec // sampling only
+	for ; itr.Valid(); itr.Next() {
+		s := keySample{key: itr.Key(), size: int64(len(itr.Key()) + len(itr.Value()))}
+		keys++
+		size += s.size
+		if len(samples) < sample {
+			s.key = cp(s.key)
+			samples = append(samples, s)
+		} else if i := rnd.Int63n(keys); i < int64(sample) {
+			s.key = cp(s.key)
+			samples[i] = s
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return nil, 0, 0, err
+	}
+	return samples, keys, size, nil
+}
+
+// keySpaceBuckets splits sorted samples into buckets of equal sample counts, scaling the counts
+// and sizes of the samples to a total of keys.
+func keySpaceBuckets(samples []keySample, buckets int, keys int64) []KeySpaceBucket {
+	n := len(samples)
+	if n == 0 {
+		return nil
+	}
+	buckets = min(buckets, n)
+	scale := float64(keys) / float64(n)
+
+	result := make([]KeySpaceBucket, buckets)
+	for i := range result {
+		lo, hi := i*n/buckets, (i+1)*n/buckets
+		var size int64
+		for _, s := range samples[lo:hi] {
+			size += s.size
+		}
+		b := &result[i]
+		b.Keys = int64(float64(hi-lo) * scale)
+		b.Bytes = int64(float64(size) * scale)
+		if i > 0 {
+			b.Range.Start = samples[lo].key
+			result[i-1].Range.End = samples[lo].key
+		}
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setSkewedKeys sets 2000 keys, 90% of which share a prefix, and returns their number.
+func setSkewedKeys(t *testing.T, db DB) int {
+	batch := db.NewBatch()
+	defer batch.Close()
+	n := 0
+	for i := 0; i < 1800; i++ {
+		require.NoError(t, batch.Set([]byte(fmt.Sprintf("hot/%05d", i)), []byte("value")))
+		n++
+	}
+	for prefix := 'b'; prefix < 'b'+20; prefix++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("%c/%02d", prefix, i)), []byte("value")))
+			n++
+		}
+	}
+	require.NoError(t, batch.Write())
+	return n
+}
+
+// countRange returns the number of keys in a range.
+func countRange(t *testing.T, db DB, r KeyRange) int {
+	itr, err := db.Iterator(r.Start, r.End)
+	require.NoError(t, err)
+	defer itr.Close()
+	n := 0
+	for ; itr.Valid(); itr.Next() {
+		n++
+	}
+	require.NoError(t, itr.Error())
+	return n
+}
+
+// checkAnalyzeKeySpace checks that the buckets of AnalyzeKeySpace cover the key space, and hold
+// the same number of keys within tolerance, as a fraction of the even share.
+func checkAnalyzeKeySpace(t *testing.T, db DB, sample int, tolerance float64) {
+	total := setSkewedKeys(t, db)
+	const buckets = 10
+
+	report, err := AnalyzeKeySpace(db, buckets, sample)
+	require.NoError(t, err)
+	require.Equal(t, min(sample, total), report.Sampled)
+	require.Len(t, report.Buckets, buckets)
+	require.InDelta(t, total, report.Keys, float64(total)*tolerance)
+
+	ranges := report.Ranges()
+	require.Nil(t, ranges[0].Start)
+	require.Nil(t, ranges[len(ranges)-1].End)
+	counted := 0
+	hot := 0
+	for i, r := range ranges {
+		if i > 0 {
+			require.Equal(t, ranges[i-1].End, r.Start)
+		}
+		n := countRange(t, db, r)
+		require.InDelta(t, total/buckets, n, float64(total/buckets)*tolerance, "bucket %d", i)
+		require.InDelta(t, n, report.Buckets[i].Keys, float64(total/buckets)*tolerance, "bucket %d", i)
+		counted += n
+		if bytes.HasPrefix(r.Start, []byte("hot/")) {
+			hot++
+		}
+	}
+	require.Equal(t, total, counted)
+	// Most boundaries fall within the hot prefix, which holds 90% of the keys.
+	require.GreaterOrEqual(t, hot, buckets*7/10)
+}
+
+func TestAnalyzeKeySpace(t *testing.T) {
+	t.Run("Exact", func(t *testing.T) {
+		checkAnalyzeKeySpace(t, NewMemDB(), 5000, 0.01)
+	})
+	t.Run("Sampled", func(t *testing.T) {
+		checkAnalyzeKeySpace(t, NewMemDB(), 500, 0.5)
+	})
+
+	db := NewMemDB()
+	report, err := AnalyzeKeySpace(db, 4, 100)
+	require.NoError(t, err)
+	require.Equal(t, KeySpaceReport{}, report)
+
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Set([]byte("b"), []byte("23")))
+	report, err = AnalyzeKeySpace(db, 4, 100)
+	require.NoError(t, err)
+	require.Equal(t, KeySpaceReport{
+		Keys:    2,
+		Bytes:   5,
+		Sampled: 2,
+		Buckets: []KeySpaceBucket{
+			{Range: KeyRange{End: []byte("b")}, Keys: 1, Bytes: 2},
+			{Range: KeyRange{Start: []byte("b")}, Keys: 1, Bytes: 3},
+		},
+	}, report)
+
+	_, err = AnalyzeKeySpace(db, 0, 100)
+	require.Error(t, err)
+	_, err = AnalyzeKeySpace(db, 4, 0)
+	require.Error(t, err)
+}
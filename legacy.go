@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option keys of tm-db configurations, which are accepted by NewDB for compatibility.
+const (
+	legacyOptionDir     = "db_dir"
+	legacyOptionBackend = "db_backend"
+)
+
+// legacyBackendNames maps the db_backend values of tm-db configurations to backends. The formats
+// are compatible, so data directories written by tm-db can be opened directly.
+var legacyBackendNames = map[string]BackendType{
+	// leveldb is the deprecated tm-db alias of goleveldb.
+	"leveldb":   GoLevelDBBackend,
+	"goleveldb": GoLevelDBBackend,
+	"cleveldb":  CLevelDBBackend,
+	"memdb":     MemDBBackend,
+	"boltdb":    BoltDBBackend,
+	"rocksdb":   RocksDBBackend,
+	"badgerdb":  BadgerDBBackend,
+}
+
+// BackendFromLegacyName returns the backend for a tm-db db_backend configuration value.
+func BackendFromLegacyName(name string) (BackendType, error) {
+	backend, ok := legacyBackendNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown tm-db backend %q", errUnknownBackend, name)
+	}
+	return backend, nil
+}
+
+// normalizeLegacyOptions translates the tm-db spellings of the backend and options given to NewDB,
+// notifying the log hook of each deprecated spelling. The given options are not modified.
+func normalizeLegacyOptions(backend BackendType, options Options) (BackendType, Options, error) {
+	if _, ok := backends[backend]; !ok && backend != "" {
+		if legacy, err := BackendFromLegacyName(string(backend)); err == nil && legacy != backend {
+			logf("db backend %q is deprecated, use %q", backend, legacy)
+			backend = legacy
+		}
+	}
+
+	_, hasDir := options[legacyOptionDir]
+	_, hasBackend := options[legacyOptionBackend]
+	if !hasDir && !hasBackend {
+		return backend, options, nil
+	}
+
+	normalized := make(Options, len(options))
+	for k, v := range options {
+		normalized[k] = v
+	}
+
+	if name, ok := normalized[legacyOptionBackend]; ok {
+		legacy, err := BackendFromLegacyName(name)
+		if err != nil {
+			return backend, nil, err
+		}
+		if backend != "" && backend != legacy {
+			return backend, nil, fmt.Errorf("option %s %q conflicts with backend %q", legacyOptionBackend, name, backend)
+		}
+		logf("option %s is deprecated, pass the backend to NewDB instead", legacyOptionBackend)
+		backend = legacy
+		delete(normalized, legacyOptionBackend)
+	}
+
+	if dir, ok := normalized[legacyOptionDir]; ok {
+		if current, ok := normalized[optionDir]; ok && current != dir {
+			return backend, nil, fmt.Errorf("option %s %q conflicts with option %s %q", legacyOptionDir, dir, optionDir, current)
+		}
+		logf("option %s is deprecated, use %s", legacyOptionDir, optionDir)
+		normalized[optionDir] = dir
+		delete(normalized, legacyOptionDir)
+	}
+
+	return backend, normalized, nil
+}
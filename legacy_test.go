@@ -0,0 +1,83 @@
+package db
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendFromLegacyName(t *testing.T) {
+	testCases := map[string]BackendType{
+		"leveldb":    GoLevelDBBackend,
+		"goleveldb":  GoLevelDBBackend,
+		"cleveldb":   CLevelDBBackend,
+		"memdb":      MemDBBackend,
+		"boltdb":     BoltDBBackend,
+		"rocksdb":    RocksDBBackend,
+		"badgerdb":   BadgerDBBackend,
+		" GoLevelDB": GoLevelDBBackend,
+	}
+	for name, expected := range testCases {
+		backend, err := BackendFromLegacyName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, backend, name)
+	}
+
+	_, err := BackendFromLegacyName("fsdb")
+	assert.ErrorIs(t, err, errUnknownBackend)
+}
+
+func TestNewDBLegacyOptions(t *testing.T) {
+	var notices []string
+	SetLogHook(func(msg string) { notices = append(notices, msg) })
+	defer SetLogHook(nil)
+
+	dir, err := os.MkdirTemp("", "legacy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	options := Options{legacyOptionBackend: "leveldb", legacyOptionDir: dir, optionName: "legacy"}
+	db, err := NewDB("", options)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.IsType(t, &GoLevelDB{}, db)
+	assert.DirExists(t, dir+"/legacy.db")
+	assert.Len(t, notices, 2)
+
+	// The caller's options are left untouched.
+	assert.Contains(t, options, legacyOptionDir)
+	assert.NotContains(t, options, optionDir)
+}
+
+func TestNewDBLegacyBackendName(t *testing.T) {
+	var notices []string
+	SetLogHook(func(msg string) { notices = append(notices, msg) })
+	defer SetLogHook(nil)
+
+	dir, err := os.MkdirTemp("", "legacy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := NewDB("leveldb", Options{optionDir: dir, optionName: "legacy"})
+	require.NoError(t, err)
+	defer db.Close()
+	assert.IsType(t, &GoLevelDB{}, db)
+	assert.Len(t, notices, 1)
+
+	db, err = NewDB("", Options{legacyOptionBackend: "memdb"})
+	require.NoError(t, err)
+	assert.IsType(t, &MemDB{}, db)
+}
+
+func TestNewDBLegacyOptionConflicts(t *testing.T) {
+	_, err := NewDB(MemDBBackend, Options{legacyOptionBackend: "goleveldb"})
+	assert.Error(t, err)
+
+	_, err = NewDB(MemDBBackend, Options{legacyOptionDir: "a", optionDir: "b"})
+	assert.Error(t, err)
+
+	_, err = NewDB("", Options{legacyOptionBackend: "fsdb"})
+	assert.ErrorIs(t, err, errUnknownBackend)
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+var logHook atomic.Pointer[func(msg string)]
+
+// SetLogHook sets a function receiving notices from the package, such as deprecation warnings. By
+// default, notices are discarded. A nil hook restores the default.
+func SetLogHook(hook func(msg string)) {
+	if hook == nil {
+		logHook.Store(nil)
+		return
+	}
+	logHook.Store(&hook)
+}
+
+// logf formats a notice and passes it to the log hook, if any.
+func logf(format string, args ...any) {
+	if hook := logHook.Load(); hook != nil {
+		(*hook)(fmt.Sprintf(format, args...))
+	}
+}
+
+// Logger receives the diagnostic output of a database, see MongoDB.SetLogger.
+type Logger interface {
+	// Debugf logs details of operations, such as query filters and batch sizes.
+	Debugf(format string, args ...any)
+	// Errorf logs failures which are not returned to a caller.
+	Errorf(format string, args ...any)
+}
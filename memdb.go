@@ -15,7 +15,18 @@ const (
 
 func init() {
 	registerDBCreator(MemDBBackend, func(options Options) (DB, error) {
-		return NewMemDB(), nil
+		zeroCopy, err := unsafeZeroCopy(options)
+		if err != nil {
+			return nil, err
+		}
+		lenient, err := lenientRanges(options)
+		if err != nil {
+			return nil, err
+		}
+		db := NewMemDB()
+		db.zeroCopy = zeroCopy
+		db.lenientRanges = lenient
+		return db, nil
 	}, false)
 }
 
@@ -25,7 +36,8 @@ type item struct {
 	value []byte
 }
 
-// Less implements btree.Item.
+// Less implements btree.Item. Keys are ordered as unsigned bytes, like goleveldb and the other
+// backends order them, and must not be compared as strings with collation or UTF-8 decoding.
 func (i *item) Less(other btree.Item) bool {
 	// this considers nil == []byte{}, but that's ok since we handle nil endpoints
 	// in iterators specially anyway
@@ -44,16 +56,32 @@ func newPair(key, value []byte) *item {
 
 // MemDB is an in-memory database backend using a B-tree for storage.
 //
-// For performance reasons, all given and returned keys and values are pointers to the in-memory
-// database, so modifying them will cause the stored values to be modified as well. All DB methods
-// already specify that keys and values should be considered read-only, but this is especially
-// important with MemDB.
+// For performance reasons, all given keys and values and all returned keys are pointers to the
+// in-memory database, so modifying them will cause the stored data to be modified as well. All DB
+// methods already specify that keys and values should be considered read-only, but this is
+// especially important with MemDB. Returned values are copies, unless the unsafe_zero_copy option
+// is set, see Capabilities.ZeroCopy.
 type MemDB struct {
 	mtx   sync.RWMutex
 	btree *btree.BTree
+
+	// zeroCopy makes Get and iterators return the stored values instead of copies.
+	zeroCopy bool
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
 }
 
-var _ DB = (*MemDB)(nil)
+var (
+	_ DB                 = (*MemDB)(nil)
+	_ ValueSizer         = (*MemDB)(nil)
+	_ StrictDeleter      = (*MemDB)(nil)
+	_ MultiDeleter       = (*MemDB)(nil)
+	_ ConditionalSetter  = (*MemDB)(nil)
+	_ StrictSetter       = (*MemDB)(nil)
+	_ CapabilityReporter = (*MemDB)(nil)
+	_ StatsProvider      = (*MemDB)(nil)
+	_ Snapshotter        = (*MemDB)(nil)
+)
 
 // NewMemDB creates a new in-memory database.
 func NewMemDB() *MemDB {
@@ -73,11 +101,26 @@ func (db *MemDB) Get(key []byte) ([]byte, error) {
 
 	i := db.btree.Get(newKey(key))
 	if i != nil {
-		return i.(*item).value, nil
+		return valueCopy(i.(*item).value, db.zeroCopy), nil
 	}
 	return nil, nil
 }
 
+// ValueSize implements ValueSizer.
+func (db *MemDB) ValueSize(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, errKeyEmpty
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	i := db.btree.Get(newKey(key))
+	if i == nil {
+		return -1, nil
+	}
+	return len(i.(*item).value), nil
+}
+
 // Has implements DB.
 func (db *MemDB) Has(key []byte) (bool, error) {
 	if len(key) == 0 {
@@ -131,13 +174,95 @@ func (db *MemDB) delete(key []byte) {
 	db.btree.Delete(newKey(key))
 }
 
+// SetIfAbsent implements ConditionalSetter.
+func (db *MemDB) SetIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	if value == nil {
+		return false, errValueNil
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if db.btree.Has(newKey(key)) {
+		return false, nil
+	}
+	db.set(key, value)
+	return true, nil
+}
+
+// SetInsertOnly implements StrictSetter.
+func (db *MemDB) SetInsertOnly(key []byte, value []byte) error {
+	set, err := db.SetIfAbsent(key, value)
+	if err == nil && !set {
+		err = ErrKeyExists{Key: key}
+	}
+	return err
+}
+
+// SetUpdateOnly implements StrictSetter.
+func (db *MemDB) SetUpdateOnly(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if !db.btree.Has(newKey(key)) {
+		return ErrKeyNotFound
+	}
+	db.set(key, value)
+	return nil
+}
+
+// DeleteStrict implements StrictDeleter.
+func (db *MemDB) DeleteStrict(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if db.btree.Delete(newKey(key)) == nil {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// DeleteKeys implements MultiDeleter. The keys are deleted atomically.
+func (db *MemDB) DeleteKeys(keys [][]byte) (int64, error) {
+	if err := checkKeys(keys); err != nil {
+		return 0, err
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var deleted int64
+	for _, key := range keys {
+		if db.btree.Delete(newKey(key)) != nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
 // DeleteSync implements DB.
 func (db *MemDB) DeleteSync(key []byte) error {
 	return db.Delete(key)
 }
 
+// Capabilities implements CapabilityReporter. Keys are unlimited.
+func (db *MemDB) Capabilities() Capabilities {
+	return Capabilities{ZeroCopy: db.zeroCopy}
+}
+
 // Close implements DB.
 func (db *MemDB) Close() error {
+	reportClosed(db)
 	// Close is a noop since for an in-memory database, we don't have a destination to flush
 	// contents to nor do we want any data loss on invoking Close().
 	return nil
@@ -158,13 +283,58 @@ func (db *MemDB) Print() error {
 
 // Stats implements DB.
 func (db *MemDB) Stats() map[string]string {
+	stats, _ := db.TypedStats()
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider. The memory size is the total size of the keys and values,
+// and nothing is stored on disk.
+func (db *MemDB) TypedStats() (DBStats, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	var size int64
+	db.btree.Ascend(func(i btree.Item) bool {
+		item := i.(*item)
+		size += int64(len(item.key) + len(item.value))
+		return true
+	})
+	return DBStats{
+		KeyCount:      int64(db.btree.Len()),
+		DiskSizeBytes: 0,
+		MemSizeBytes:  size,
+		Raw: map[string]string{
+			"database.type": "memDB",
+			"database.size": fmt.Sprintf("%d", db.btree.Len()),
+		},
+	}, nil
+}
+
+// Snapshot implements Snapshotter.
+func (db *MemDB) Snapshot() (DB, error) {
+	return &snapshotDB{db: db.clone()}, nil
+}
+
+// clone returns a deep copy of the database, with copies of the keys and values, so that neither
+// writes to the database nor changes to the values it returned with zero copy affect the copy.
+func (db *MemDB) clone() *MemDB {
 	db.mtx.RLock()
 	defer db.mtx.RUnlock()
 
-	stats := make(map[string]string)
-	stats["database.type"] = "memDB"
-	stats["database.size"] = fmt.Sprintf("%d", db.btree.Len())
-	return stats
+	clone := NewMemDB()
+	clone.zeroCopy = db.zeroCopy
+	clone.lenientRanges = db.lenientRanges
+	db.btree.Ascend(func(i btree.Item) bool {
+		item := i.(*item)
+		clone.btree.ReplaceOrInsert(newPair(cp(item.key), cp(item.value)))
+		return true
+	})
+	return clone
+}
+
+// Compact implements DB. It does nothing, since the B-tree holds no deleted keys.
+func (db *MemDB) Compact(_, _ []byte) error {
+	return nil
 }
 
 // NewBatch implements DB.
@@ -172,12 +342,20 @@ func (db *MemDB) NewBatch() Batch {
 	return newMemDBBatch(db)
 }
 
+// NewBatchWithSize implements BatchCreator.
+func (db *MemDB) NewBatchWithSize(size int) Batch {
+	return newMemDBBatchWithSize(db, size)
+}
+
 // Iterator implements DB.
 // Takes out a read-lock on the database until the iterator is closed.
 func (db *MemDB) Iterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	return newMemDBIterator(db, start, end, false), nil
 }
 
@@ -187,6 +365,9 @@ func (db *MemDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	return newMemDBIterator(db, start, end, true), nil
 }
 
@@ -195,6 +376,9 @@ func (db *MemDB) IteratorNoMtx(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	return newMemDBIteratorMtxChoice(db, start, end, false, false), nil
 }
 
@@ -203,5 +387,8 @@ func (db *MemDB) ReverseIteratorNoMtx(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	return newMemDBIteratorMtxChoice(db, start, end, true, false), nil
 }
@@ -8,6 +8,9 @@ type opType int
 const (
 	opTypeSet opType = iota + 1
 	opTypeDelete
+	// opTypeInsert and opTypeUpdate are insert-only and update-only sets, see StrictSetBatch.
+	opTypeInsert
+	opTypeUpdate
 )
 
 type operation struct {
@@ -22,7 +25,11 @@ type memDBBatch struct {
 	ops []operation
 }
 
-var _ Batch = (*memDBBatch)(nil)
+var (
+	_ Batch           = (*memDBBatch)(nil)
+	_ StrictSetBatch  = (*memDBBatch)(nil)
+	_ ResettableBatch = (*memDBBatch)(nil)
+)
 
 // newMemDBBatch creates a new memDBBatch
 func newMemDBBatch(db *MemDB) *memDBBatch {
@@ -32,8 +39,30 @@ func newMemDBBatch(db *MemDB) *memDBBatch {
 	}
 }
 
+// newMemDBBatchWithSize creates a new memDBBatch with room for size operations.
+func newMemDBBatchWithSize(db *MemDB, size int) *memDBBatch {
+	return &memDBBatch{
+		db:  db,
+		ops: make([]operation, 0, size),
+	}
+}
+
 // Set implements Batch.
 func (b *memDBBatch) Set(key, value []byte) error {
+	return b.set(opTypeSet, key, value)
+}
+
+// SetInsertOnly implements StrictSetBatch. If a condition does not hold, nothing is written.
+func (b *memDBBatch) SetInsertOnly(key, value []byte) error {
+	return b.set(opTypeInsert, key, value)
+}
+
+// SetUpdateOnly implements StrictSetBatch. If a condition does not hold, nothing is written.
+func (b *memDBBatch) SetUpdateOnly(key, value []byte) error {
+	return b.set(opTypeUpdate, key, value)
+}
+
+func (b *memDBBatch) set(opType opType, key, value []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
@@ -43,7 +72,7 @@ func (b *memDBBatch) Set(key, value []byte) error {
 	if b.ops == nil {
 		return errBatchClosed
 	}
-	b.ops = append(b.ops, operation{opTypeSet, key, value})
+	b.ops = append(b.ops, operation{opType, key, value})
 	return nil
 }
 
@@ -67,9 +96,12 @@ func (b *memDBBatch) Write() error {
 	b.db.mtx.Lock()
 	defer b.db.mtx.Unlock()
 
+	if err := b.checkConditions(); err != nil {
+		return err
+	}
 	for _, op := range b.ops {
 		switch op.opType {
-		case opTypeSet:
+		case opTypeSet, opTypeInsert, opTypeUpdate:
 			b.db.set(op.key, op.value)
 		case opTypeDelete:
 			b.db.delete(op.key)
@@ -82,6 +114,32 @@ func (b *memDBBatch) Write() error {
 	return b.Close()
 }
 
+// checkConditions checks the conditions of the last write of each key against the database,
+// without locking the mutex.
+func (b *memDBBatch) checkConditions() error {
+	last := make(map[string]int, len(b.ops))
+	for i, op := range b.ops {
+		last[string(op.key)] = i
+	}
+	// The operations are checked in order, so that the first failed condition is reported.
+	for i, op := range b.ops {
+		if last[string(op.key)] != i {
+			continue
+		}
+		switch op.opType {
+		case opTypeInsert:
+			if b.db.btree.Has(newKey(op.key)) {
+				return ErrKeyExists{Key: op.key}
+			}
+		case opTypeUpdate:
+			if !b.db.btree.Has(newKey(op.key)) {
+				return fmt.Errorf("%w: %X", ErrKeyNotFound, op.key)
+			}
+		}
+	}
+	return nil
+}
+
 // WriteSync implements Batch.
 func (b *memDBBatch) WriteSync() error {
 	return b.Write()
@@ -92,3 +150,12 @@ func (b *memDBBatch) Close() error {
 	b.ops = nil
 	return nil
 }
+
+// Reset implements ResettableBatch.
+func (b *memDBBatch) Reset() {
+	if b.ops == nil {
+		b.ops = []operation{}
+	} else {
+		b.ops = b.ops[:0]
+	}
+}
@@ -16,15 +16,22 @@ const (
 
 // memDBIterator is a memDB iterator.
 type memDBIterator struct {
-	ch     <-chan *item
-	cancel context.CancelFunc
-	item   *item
-	start  []byte
-	end    []byte
-	useMtx bool
+	iteratorGuard
+
+	db      *MemDB
+	ch      <-chan *item
+	cancel  context.CancelFunc
+	item    *item
+	start   []byte
+	end     []byte
+	reverse bool
+	useMtx  bool
+
+	// zeroCopy makes Value return the stored value instead of a copy.
+	zeroCopy bool
 }
 
-var _ Iterator = (*memDBIterator)(nil)
+var _ SeekIterator = (*memDBIterator)(nil)
 
 // newMemDBIterator creates a new memDBIterator.
 func newMemDBIterator(db *MemDB, start []byte, end []byte, reverse bool) *memDBIterator {
@@ -32,15 +39,25 @@ func newMemDBIterator(db *MemDB, start []byte, end []byte, reverse bool) *memDBI
 }
 
 func newMemDBIteratorMtxChoice(db *MemDB, start []byte, end []byte, reverse bool, useMtx bool) *memDBIterator {
-	ctx, cancel := context.WithCancel(context.Background())
-	ch := make(chan *item, chBufferSize)
 	iter := &memDBIterator{
-		ch:     ch,
-		cancel: cancel,
-		start:  start,
-		end:    end,
-		useMtx: useMtx,
+		db:       db,
+		start:    start,
+		end:      end,
+		reverse:  reverse,
+		useMtx:   useMtx,
+		zeroCopy: db.zeroCopy,
 	}
+	iter.traverse(start, end)
+	return iter
+}
+
+// traverse starts a traversal of the keys of the tree in [start, end), in the iteration order,
+// and positions the iterator at the first of them.
+func (i *memDBIterator) traverse(start, end []byte) {
+	db, reverse, useMtx := i.db, i.reverse, i.useMtx
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan *item, chBufferSize)
+	i.ch, i.cancel, i.item = ch, cancel, nil
 
 	if useMtx {
 		db.mtx.RLock()
@@ -96,10 +113,25 @@ func newMemDBIteratorMtxChoice(db *MemDB, start []byte, end []byte, reverse bool
 
 	// prime the iterator with the first value, if any
 	if item, ok := <-ch; ok {
-		iter.item = item
+		i.item = item
 	}
+}
 
-	return iter
+// Seek implements SeekIterator by restarting the traversal at key.
+func (i *memDBIterator) Seek(key []byte) bool {
+	if !i.Valid() {
+		return false
+	}
+	i.cancel()
+	for range i.ch { // drain channel
+	}
+	start, end, ok := seekDomain(i.start, i.end, key, i.reverse)
+	if !ok {
+		i.item = nil
+		return false
+	}
+	i.traverse(start, end)
+	return i.Valid()
 }
 
 // Close implements Iterator.
@@ -123,7 +155,9 @@ func (i *memDBIterator) Valid() bool {
 
 // Next implements Iterator.
 func (i *memDBIterator) Next() {
-	i.assertIsValid()
+	if !i.assertIsValid() {
+		return
+	}
 	item, ok := <-i.ch
 	switch {
 	case ok:
@@ -135,23 +169,25 @@ func (i *memDBIterator) Next() {
 
 // Error implements Iterator.
 func (i *memDBIterator) Error() error {
-	return nil // famous last words
+	return i.misuseError()
 }
 
 // Key implements Iterator.
 func (i *memDBIterator) Key() []byte {
-	i.assertIsValid()
+	if !i.assertIsValid() {
+		return nil
+	}
 	return i.item.key
 }
 
 // Value implements Iterator.
 func (i *memDBIterator) Value() []byte {
-	i.assertIsValid()
-	return i.item.value
+	if !i.assertIsValid() {
+		return nil
+	}
+	return valueCopy(i.item.value, i.zeroCopy)
 }
 
-func (i *memDBIterator) assertIsValid() {
-	if !i.Valid() {
-		panic("iterator is invalid")
-	}
+func (i *memDBIterator) assertIsValid() bool {
+	return i.guard(i.Valid())
 }
@@ -1,7 +1,11 @@
 package db
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkMemDBRangeScans1M(b *testing.B) {
@@ -24,3 +28,73 @@ func BenchmarkMemDBRandomReadsWrites(b *testing.B) {
 
 	benchmarkRandomReadsWrites(b, db)
 }
+
+func BenchmarkMemDBBatch100k(b *testing.B) {
+	db := NewMemDB()
+	defer db.Close()
+
+	benchmarkBatchBuild(b, db, 100000, false)
+}
+
+func BenchmarkMemDBBatchWithSize100k(b *testing.B) {
+	db := NewMemDB()
+	defer db.Close()
+
+	benchmarkBatchBuild(b, db, 100000, true)
+}
+
+func BenchmarkMemDBValueReads(b *testing.B) {
+	db := NewMemDB()
+	defer db.Close()
+
+	benchmarkValueReads(b, db)
+}
+
+func BenchmarkMemDBValueReadsZeroCopy(b *testing.B) {
+	db, err := NewDB(MemDBBackend, Options{optionUnsafeZeroCopy: "true"})
+	require.NoError(b, err)
+	defer db.Close()
+
+	benchmarkValueReads(b, db)
+}
+
+func TestMemDBZeroCopy(t *testing.T) {
+	db := NewMemDB()
+	assert.False(t, DBCapabilities(db).ZeroCopy)
+	checkValueCopies(t, db)
+
+	zeroCopyDB, err := NewDB(MemDBBackend, Options{optionUnsafeZeroCopy: "true"})
+	require.NoError(t, err)
+	defer zeroCopyDB.Close()
+	assert.True(t, DBCapabilities(zeroCopyDB).ZeroCopy)
+
+	// Values reference the stored data, so mutating them mutates the database.
+	require.NoError(t, zeroCopyDB.Set([]byte("key"), []byte("value")))
+	value, err := zeroCopyDB.Get([]byte("key"))
+	require.NoError(t, err)
+	value[0] = 'X'
+	checkValue(t, zeroCopyDB, []byte("key"), []byte("Xalue"))
+
+	_, err = NewDB(MemDBBackend, Options{optionUnsafeZeroCopy: "maybe"})
+	assert.Error(t, err)
+}
+
+func TestMemDBTypedStats(t *testing.T) {
+	db := NewMemDB()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+
+	stats, err := db.TypedStats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, stats.KeyCount)
+	assert.EqualValues(t, 0, stats.DiskSizeBytes)
+	assert.EqualValues(t, 10*len("key0value"), stats.MemSizeBytes)
+	assert.Equal(t, map[string]string{
+		"database.type":         "memDB",
+		"database.size":         "10",
+		"stats.key_count":       "10",
+		"stats.disk_size_bytes": "0",
+		"stats.mem_size_bytes":  "90",
+	}, db.Stats())
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "cometbft_db"
+
+// Error classes used to label database creation failures.
+const (
+	errorClassUnknownBackend = "unknown_backend"
+	errorClassMissingOption  = "missing_option"
+	errorClassOther          = "other"
+)
+
+var errUnknownBackend = errors.New("unknown backend")
+
+// registryMetrics contains the metrics for the backend registry. They are always collected, but
+// only exported once registered via SetMeterRegistry.
+type registryMetrics struct {
+	// Created counts the databases created per backend.
+	Created *prometheus.CounterVec
+	// CreationFailures counts the failed database creations per backend and error class.
+	CreationFailures *prometheus.CounterVec
+	// Open is the number of currently open databases per backend.
+	Open *prometheus.GaugeVec
+
+	// MongoPoolEvents counts MongoDB connection pool events per event and reason, for clients
+	// monitored by a MongoDriverMonitor.
+	MongoPoolEvents *prometheus.CounterVec
+	// MongoCommands counts MongoDB commands per command name and status, for clients monitored by
+	// a MongoDriverMonitor.
+	MongoCommands *prometheus.CounterVec
+	// MongoCommandDuration observes the duration of MongoDB commands per command name, for clients
+	// monitored by a MongoDriverMonitor.
+	MongoCommandDuration *prometheus.HistogramVec
+
+	// ReplicationEventsBehind is the number of change events waiting when Replicate last read a
+	// batch, per source collection, see ReplicationLag.
+	ReplicationEventsBehind *prometheus.GaugeVec
+	// ReplicationLastApplied is the source time of the last change applied by Replicate, as a Unix
+	// timestamp, per source collection.
+	ReplicationLastApplied *prometheus.GaugeVec
+}
+
+func newRegistryMetrics() *registryMetrics {
+	return &registryMetrics{
+		Created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "created_total",
+			Help:      "Number of databases created, per backend.",
+		}, []string{"backend"}),
+		CreationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "creation_failures_total",
+			Help:      "Number of failed database creations, per backend and error class.",
+		}, []string{"backend", "error_class"}),
+		Open: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "open",
+			Help:      "Number of currently open databases, per backend.",
+		}, []string{"backend"}),
+		MongoPoolEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_pool_events_total",
+			Help:      "Number of MongoDB connection pool events, per event and reason.",
+		}, []string{"event", "reason"}),
+		MongoCommands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_commands_total",
+			Help:      "Number of MongoDB commands, per command name and status.",
+		}, []string{"command", "status"}),
+		MongoCommandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_command_duration_seconds",
+			Help:      "Duration of MongoDB commands, per command name.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+		}, []string{"command"}),
+		ReplicationEventsBehind: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "replication_events_behind",
+			Help:      "Number of change events waiting when replication last read a batch, per source collection.",
+		}, []string{"collection"}),
+		ReplicationLastApplied: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "replication_last_applied_timestamp_seconds",
+			Help:      "Source time of the last change applied by replication, per source collection.",
+		}, []string{"collection"}),
+	}
+}
+
+var (
+	metrics = newRegistryMetrics()
+
+	// openDBs maps each open database created via the registry to its backend, so that Close can
+	// be reported to the metrics.
+	openDBs sync.Map
+)
+
+// SetMeterRegistry registers the backend registry metrics with the given Prometheus registerer.
+func SetMeterRegistry(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		metrics.Created, metrics.CreationFailures, metrics.Open,
+		metrics.MongoPoolEvents, metrics.MongoCommands, metrics.MongoCommandDuration,
+		metrics.ReplicationEventsBehind, metrics.ReplicationLastApplied,
+	} {
+		if err := registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportCreated records a successful database creation.
+func reportCreated(backend BackendType, db DB) {
+	metrics.Created.WithLabelValues(string(backend)).Inc()
+	metrics.Open.WithLabelValues(string(backend)).Inc()
+	openDBs.Store(db, backend)
+}
+
+// reportCreationFailure records a failed database creation.
+func reportCreationFailure(backend BackendType, err error) {
+	metrics.CreationFailures.WithLabelValues(string(backend), errorClass(err)).Inc()
+}
+
+// reportClosed records that a database was closed. It must be called by the Close method of every
+// backend, and is a noop for databases which were not created via the registry or are already
+// closed.
+func reportClosed(db DB) {
+	if backend, ok := openDBs.LoadAndDelete(db); ok {
+		metrics.Open.WithLabelValues(string(backend.(BackendType))).Dec()
+	}
+}
+
+// errorClass returns the label value for a creation error.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, errUnknownBackend):
+		return errorClassUnknownBackend
+	case errors.Is(err, errMissingOption):
+		return errorClassMissingOption
+	default:
+		return errorClassOther
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, SetMeterRegistry(registry))
+	require.Error(t, SetMeterRegistry(registry))
+
+	memDB := string(MemDBBackend)
+	mongoDB := string(MongoDBBackend)
+	created := testutil.ToFloat64(metrics.Created.WithLabelValues(memDB))
+	open := testutil.ToFloat64(metrics.Open.WithLabelValues(memDB))
+	failures := testutil.ToFloat64(metrics.CreationFailures.WithLabelValues(mongoDB, errorClassOther))
+	unknown := testutil.ToFloat64(metrics.CreationFailures.WithLabelValues("unknown", errorClassUnknownBackend))
+
+	db, err := NewDB(MemDBBackend, Options{})
+	require.NoError(t, err)
+	require.Equal(t, created+1, testutil.ToFloat64(metrics.Created.WithLabelValues(memDB)))
+	require.Equal(t, open+1, testutil.ToFloat64(metrics.Open.WithLabelValues(memDB)))
+
+	_, err = NewDB(MongoDBBackend, NewMongoDBOptions("not a uri", "testing", "testing"))
+	require.Error(t, err)
+	require.Equal(t, failures+1,
+		testutil.ToFloat64(metrics.CreationFailures.WithLabelValues(mongoDB, errorClassOther)))
+
+	_, err = NewDB("unknown", Options{})
+	require.Error(t, err)
+	require.Equal(t, unknown+1,
+		testutil.ToFloat64(metrics.CreationFailures.WithLabelValues("unknown", errorClassUnknownBackend)))
+
+	// Closing is only counted once.
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Close())
+	require.Equal(t, open, testutil.ToFloat64(metrics.Open.WithLabelValues(memDB)))
+
+	count, err := testutil.GatherAndCount(registry, "cometbft_db_created_total")
+	require.NoError(t, err)
+	require.NotZero(t, count)
+}
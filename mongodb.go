@@ -4,24 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func init() { registerDBCreator(MongoDBBackend, mongoDBCreator, true) }
-
-func mongoDBCreator(options Options) (DB, error) {
-	connString, ok := options["connection_string"]
-	if !ok {
-		return nil, errors.New("connection_string not provided in options")
-	}
-
-	databaseName, ok := options["database"]
-	if !ok {
-		return nil, errors.New("database not provided in options")
-	}
+func init() { registerDBCreatorWithContext(MongoDBBackend, mongoDBCreator, true) }
 
+func mongoDBCreator(ctx context.Context, options Options) (DB, error) {
 	collectionName, ok := options["collection"]
 	if !ok {
 		// If "collection" is not provided, try to use "name" for compatibility.
@@ -32,16 +28,204 @@ func mongoDBCreator(options Options) (DB, error) {
 		}
 	}
 
-	serverAPI := mongoOptions.ServerAPI(mongoOptions.ServerAPIVersion1)
-	opts := mongoOptions.Client().ApplyURI(connString).SetServerAPIOptions(serverAPI)
+	// Validate the options before connecting, so that invalid options fail at creation rather
+	// than at the first read or write.
+	if _, err := mongoSettingsFromOptions(options); err != nil {
+		return nil, err
+	}
+
+	database, monitor, err := newMongoDatabase(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return newMongoDBFromOptions(database.Collection(collectionName), options, monitor, false)
+}
+
+// mongoSettings are the settings of a MongoDB configured by the options of NewDB.
+type mongoSettings struct {
+	readPreference      *readpref.ReadPref
+	trackTimestamps     bool
+	codec               RecordCodec
+	maxQueryTime        time.Duration
+	clientTimeout       time.Duration
+	lenientRanges       bool
+	prefetch            int
+	reconnectThreshold  int
+	retryPolicy         mongoRetryPolicy
+	largeValueThreshold int
+	closeTimeout        time.Duration
+	readOnlyAfter       int
+	compression         KeyCompressionConfig
+	compressed          bool
+}
+
+// mongoSettingsFromOptions parses the options of NewDB configuring a MongoDB, other than those of
+// its client.
+func mongoSettingsFromOptions(options Options) (*mongoSettings, error) {
+	var s mongoSettings
+	var err error
+	if s.readPreference, err = mongoReadPreference(options); err != nil {
+		return nil, err
+	}
+	if s.trackTimestamps, err = mongoTrackTimestamps(options); err != nil {
+		return nil, err
+	}
+	if s.codec, err = mongoRecordCodec(options); err != nil {
+		return nil, err
+	}
+	if s.maxQueryTime, err = mongoMaxQueryTime(options); err != nil {
+		return nil, err
+	}
+	if s.clientTimeout, err = mongoClientTimeout(options); err != nil {
+		return nil, err
+	}
+	if s.lenientRanges, err = lenientRanges(options); err != nil {
+		return nil, err
+	}
+	if s.prefetch, err = mongoIteratorPrefetch(options); err != nil {
+		return nil, err
+	}
+	if s.reconnectThreshold, err = mongoReconnectThreshold(options); err != nil {
+		return nil, err
+	}
+	if s.retryPolicy, err = mongoRetryPolicyFromOptions(options); err != nil {
+		return nil, err
+	}
+	if s.largeValueThreshold, err = mongoLargeValueThreshold(options); err != nil {
+		return nil, err
+	}
+	if s.closeTimeout, err = mongoCloseTimeout(options); err != nil {
+		return nil, err
+	}
+	if s.readOnlyAfter, err = readOnlyAfterStorageFull(options); err != nil {
+		return nil, err
+	}
+	if s.compression, s.compressed, err = mongoKeyCompressionConfig(options); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
 
-	client, err := mongo.Connect(context.Background(), opts)
+// newMongoDBFromOptions returns the database of collection configured by options, for NewDB and
+// Provider, wrapped in a CompressedMongoDB if key compression is configured. monitor is the monitor
+// of the client, if any. If sharedClient is set, the client is owned by the caller and is not
+// disconnected when the database is closed. The client is disconnected if the creation fails
+// otherwise.
+func newMongoDBFromOptions(
+	collection *mongo.Collection, options Options, monitor *MongoDriverMonitor, sharedClient bool,
+) (DB, error) {
+	s, err := mongoSettingsFromOptions(options)
 	if err != nil {
+		if !sharedClient {
+			_ = mongoDisconnect(collection.Database().Client(), 0)
+		}
 		return nil, err
 	}
 
-	collection := client.Database(databaseName).Collection(collectionName)
-	return NewMongoDB(collection), nil
+	db := NewMongoDB(collection)
+	db.sharedClient = sharedClient
+	db.options = options
+	db.setReadPreference(s.readPreference)
+	db.SetRecordCodec(s.codec)
+	db.SetMaxQueryTime(s.maxQueryTime)
+	db.SetIteratorPrefetch(s.prefetch)
+	db.clientTimeout = s.clientTimeout
+	db.retryPolicy = s.retryPolicy
+	db.largeValueThreshold = s.largeValueThreshold
+	db.closeTimeout = s.closeTimeout
+	db.lenientRanges.Store(s.lenientRanges)
+	db.storageFull.threshold = int64(s.readOnlyAfter)
+	db.SetDriverMonitor(monitor)
+	if s.trackTimestamps {
+		db.TrackTimestamps()
+	}
+	if s.reconnectThreshold > 0 {
+		db.superviseClient(s.reconnectThreshold, mongoOptionsClientFactory(options, monitor))
+	}
+	if s.compressed {
+		cdb, err := NewCompressedMongoDB(db, s.compression)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return cdb, nil
+	}
+	return db, nil
+}
+
+// newMongoDatabase connects a new client using the connection_string option, and returns a handle
+// to the database named by the database option. If the monitor_driver option is set, the client
+// is monitored by the returned monitor, which is nil otherwise. The server is pinged with ctx, so
+// that an unreachable server fails the creation rather than the first read or write. If ctx is
+// canceled or the ping fails, the client is disconnected again and the error is returned.
+func newMongoDatabase(ctx context.Context, options Options) (*mongo.Database, *MongoDriverMonitor, error) {
+	databaseName, ok := options["database"]
+	if !ok {
+		return nil, nil, errors.New("database not provided in options")
+	}
+
+	opts, monitor, err := mongoClientOptions(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	rp, err := mongoReadPreference(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		_ = mongoDisconnect(client, mongoDefaultCloseTimeout)
+		return nil, nil, err
+	}
+	if err := client.Ping(ctx, rp); err != nil {
+		_ = mongoDisconnect(client, mongoDefaultCloseTimeout)
+		return nil, nil, fmt.Errorf("%s: pinging the server: %w", MongoDBBackend, err)
+	}
+
+	return client.Database(databaseName), monitor, nil
+}
+
+// mongoClientOptions returns the client options configured by the connection_string,
+// monitor_driver and client_timeout_ms options and mongoClientConfigOptions, and the driver
+// monitor if any.
+func mongoClientOptions(options Options) (*mongoOptions.ClientOptions, *MongoDriverMonitor, error) {
+	connString, ok := options["connection_string"]
+	if !ok {
+		return nil, nil, errors.New("connection_string not provided in options")
+	}
+
+	monitorDriver, err := mongoMonitorDriver(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientTimeout, err := mongoClientTimeout(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverAPI := mongoOptions.ServerAPI(mongoOptions.ServerAPIVersion1)
+	opts := mongoOptions.Client().ApplyURI(connString).SetServerAPIOptions(serverAPI)
+	if clientTimeout > 0 {
+		opts.SetTimeout(clientTimeout)
+	}
+	if err := applyMongoClientConfig(opts, options); err != nil {
+		return nil, nil, err
+	}
+	var monitor *MongoDriverMonitor
+	if monitorDriver {
+		monitor = NewMongoDriverMonitor()
+		monitor.Install(opts)
+	}
+	return opts, monitor, nil
 }
 
 func NewMongoDBOptions(connectionString, database, collection string) Options {
@@ -53,67 +237,345 @@ func NewMongoDBOptions(connectionString, database, collection string) Options {
 }
 
 type MongoDB struct {
+	// clientMtx guards collection, readCollection and journal, which are replaced when the client
+	// is rebuilt, see mongoOptionReconnectThreshold. Use coll, readColl and journalColl to read them.
+	clientMtx  sync.RWMutex
 	collection *mongo.Collection
+	// readCollection is the same collection as collection, but with the configured read
+	// preference. It is used for Get, Has and iterators, while writes always use collection.
+	readCollection *mongo.Collection
+	readPreference *readpref.ReadPref
+	// maxQueryTime is the maximum execution time of reads, see SetMaxQueryTime.
+	maxQueryTime atomic.Int64
+	// iteratorPrefetch is the prefetch window of iterators, see SetIteratorPrefetch.
+	iteratorPrefetch atomic.Int64
+	// clientTimeout is the timeout of the client set by the client_timeout_ms option.
+	clientTimeout time.Duration
+	// closeTimeout bounds the disconnect of the client by Close, see SetCloseTimeout.
+	closeTimeout time.Duration
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges atomic.Bool
+	// storageFull tracks the storage-full errors of writes, see wrapWriteError.
+	storageFull storageFullGuard
+
+	// sharedClient is set when the client is owned by someone else (e.g. a Provider), in which
+	// case Close does not disconnect it. It is cleared when the client is rebuilt. Guarded by
+	// clientMtx.
+	sharedClient bool
+
+	// seq is the sequence number of the last write operation issued, see mongoWriteOp.
+	seq atomic.Uint64
+
+	// driverMonitor, if set, monitors the client, see SetDriverMonitor.
+	driverMonitor *MongoDriverMonitor
+
+	// codec encodes and decodes the documents of the collection, see RecordCodec.
+	codec RecordCodec
+
+	// journal is the deletions journal, which records a tombstone for every deleted key. It is only
+	// set when timestamps are tracked, see TrackTimestamps.
+	journal *mongo.Collection
+
+	// options are the options the database was created with, updated by Reconfigure, which holds
+	// reconfigureMtx while changing settings.
+	options        Options
+	reconfigureMtx sync.Mutex
+
+	// supervisor, if set, rebuilds the client after sustained fatal topology errors.
+	supervisor *mongoSupervisor
+	// retryPolicy is the retry policy of unavailable reads and writes, see retry.
+	retryPolicy mongoRetryPolicy
+	// ttlIndexReady is set once the TTL index exists, see ensureTTLIndex.
+	ttlIndexReady atomic.Bool
+	// largeValueThreshold is the size above which values are split into chunks, or zero if they
+	// are not, see SetLargeValueThreshold.
+	largeValueThreshold int
+	// chunkIndexReady is set once the index of the chunk collection exists, see ensureChunkIndex.
+	chunkIndexReady atomic.Bool
+	// largeValues is set once the chunk collection is known to hold chunks, and largeValuesProbed
+	// holds the time it was last found empty, see mayHaveLargeValues.
+	largeValues       atomic.Bool
+	largeValuesProbed atomic.Int64
+	// closed is set by Close, after which the client is no longer rebuilt. Guarded by clientMtx.
+	closed bool
+
+	// logger, if set, receives diagnostic output, see SetLogger.
+	logger atomic.Pointer[Logger]
+
+	// transactions caches whether the deployment supports transactions, as a
+	// mongoTransactionSupport, see supportsTransactions.
+	transactions atomic.Int32
 }
 
 // Compile time verification of interface implementation
-var _ DB = (*MongoDB)(nil)
+var (
+	_ DB                 = (*MongoDB)(nil)
+	_ RangeDeleter       = (*MongoDB)(nil)
+	_ MultiDeleter       = (*MongoDB)(nil)
+	_ MultiGetter        = (*MongoDB)(nil)
+	_ StrictDeleter      = (*MongoDB)(nil)
+	_ ConditionalSetter  = (*MongoDB)(nil)
+	_ StrictSetter       = (*MongoDB)(nil)
+	_ CapabilityReporter = (*MongoDB)(nil)
+	_ ContextDB          = (*MongoDB)(nil)
+	_ StatsProvider      = (*MongoDB)(nil)
+)
+
+// mongoMaxKeySize is the maximum key size. Keys are stored as the _id, and some server
+// configurations limit index entries to 1024 bytes, which includes some overhead besides the key.
+const mongoMaxKeySize = 1000
+
+// Capabilities implements CapabilityReporter.
+func (db *MongoDB) Capabilities() Capabilities {
+	return Capabilities{MaxKeySize: mongoMaxKeySize, ReadOnly: db.storageFull.isReadOnly()}
+}
 
 // NewMongoDB creates a new CometBFT MongoDB wrapper.
 func NewMongoDB(collection *mongo.Collection) *MongoDB {
 	return &MongoDB{
-		collection: collection,
+		collection:          collection,
+		readCollection:      collection,
+		codec:               defaultRecordCodec{},
+		largeValueThreshold: mongoDefaultLargeValueThreshold,
+		closeTimeout:        mongoDefaultCloseTimeout,
 	}
 }
 
-// Struct representing a record in the MongoDB collection.
+// coll returns the collection used for writes.
+func (db *MongoDB) coll() *mongo.Collection {
+	db.clientMtx.RLock()
+	defer db.clientMtx.RUnlock()
+	return db.collection
+}
+
+// readColl returns the collection used for reads, with the configured read preference.
+func (db *MongoDB) readColl() *mongo.Collection {
+	db.clientMtx.RLock()
+	defer db.clientMtx.RUnlock()
+	return db.readCollection
+}
+
+// journalColl returns the deletions journal, or nil if timestamps are not tracked.
+func (db *MongoDB) journalColl() *mongo.Collection {
+	db.clientMtx.RLock()
+	defer db.clientMtx.RUnlock()
+	return db.journal
+}
+
+// nextSeq returns the sequence number for a new write operation.
+func (db *MongoDB) nextSeq() uint64 {
+	return db.seq.Add(1)
+}
+
+// Struct representing a record in the MongoDB collection. The key is stored as a hex string _id,
+// see MongoKeyID, and decoded by MongoDocumentKey rather than unmarshaled.
 type record struct {
-	Key   []byte `bson:"_id"`
+	Key   []byte `bson:"-"`
 	Value []byte `bson:"value"`
 }
 
 // Get fetches a value from the database by key.
 // Returns (nil, nil) if the key does not exist.
 func (db *MongoDB) Get(key []byte) ([]byte, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext implements ContextDB.
+func (db *MongoDB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, errKeyEmpty
 	}
 
-	res := db.collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: string(key)}})
-	if res.Err() != nil {
-		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+	var value []byte
+	err := db.retry(ctx, func() (err error) {
+		value, err = db.get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// get fetches the value of key once, see GetContext. A large value which is overwritten or deleted
+// while its chunks are read is read again.
+func (db *MongoDB) get(ctx context.Context, key []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		value, err := db.getDocument(ctx, key)
+		if errors.Is(err, errMongoLargeValueChanged) && attempt < mongoLargeValueReadAttempts {
+			continue
+		}
+		return value, err
+	}
+}
+
+// getDocument fetches the document of key, and decodes its value.
+func (db *MongoDB) getDocument(ctx context.Context, key []byte) ([]byte, error) {
+	buf := getMongoDocBuffer()
+	defer putMongoDocBuffer(buf)
+	*buf = appendMongoUnexpiredKeyFilter(*buf, key)
+
+	// The default codec stores nothing but the value, so only the value is fetched and decoded.
+	// Other codecs may need any field of the document.
+	_, defaultCodec := db.codec.(defaultRecordCodec)
+	opts := db.findOneOptions()
+	if defaultCodec {
+		opts.SetProjection(mongoValueProjection)
+	}
+	readCtx, cancel := db.readContext(ctx, db.queryTime())
+	defer cancel()
+	raw, err := db.readColl().FindOne(readCtx, bson.Raw(*buf), opts).Raw()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, nil
 		}
-		return nil, res.Err()
+		return nil, db.wrapReadErrorContext(ctx, "get", err, db.queryTime())
 	}
+	db.supervise(nil)
 
-	var record record
-	if err := res.Decode(&record); err != nil {
+	var value []byte
+	if defaultCodec {
+		value, err = decodeMongoValue(raw)
+	} else {
+		var record *record
+		record, err = db.decodeRecord(raw)
+		if record != nil {
+			value = record.Value
+		}
+	}
+	if err != nil {
 		return nil, err
 	}
+	return db.resolveLargeValue(ctx, key, raw, value)
+}
 
-	return record.Value, nil
+// GetMany implements MultiGetter, fetching the distinct keys with a single $in query per
+// mongoBatchChunkSize keys, each retried like Get. Keys given more than once get copies of the same
+// value.
+func (db *MongoDB) GetMany(keys [][]byte) ([][]byte, error) {
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+
+	var ids bson.A
+	found := make(map[string][]byte, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			ids = append(ids, MongoKeyID(key))
+		}
+	}
+	for start := 0; start < len(ids); start += mongoBatchChunkSize {
+		chunk := ids[start:min(start+mongoBatchChunkSize, len(ids))]
+		ctx := context.Background()
+		if err := db.retry(ctx, func() error { return db.getChunk(ctx, chunk, found) }); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, ok := found[string(key)]
+		if !ok {
+			continue
+		}
+		if seen[string(key)] {
+			// The first occurrence takes the decoded value, and the others copies.
+			seen[string(key)] = false
+			values[i] = value
+		} else {
+			values[i] = cp(value)
+		}
+	}
+	return values, nil
+}
+
+// getChunk fetches the documents of the keys ids once with a single query, and adds their values
+// to found.
+func (db *MongoDB) getChunk(ctx context.Context, ids bson.A, found map[string][]byte) error {
+	opts := mongoOptions.Find().SetBatchSize(int32(len(ids)))
+	if db.queryTime() > 0 {
+		opts.SetMaxTime(db.queryTime())
+	}
+	readCtx, cancel := db.readContext(ctx, db.queryTime())
+	defer cancel()
+	filter := mongoUnexpiredFilter(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	cursor, err := db.readColl().Find(readCtx, filter, opts)
+	if err != nil {
+		return db.wrapReadErrorContext(ctx, "get many", err, db.queryTime())
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(readCtx) {
+		record, err := db.decodeRecord(cursor.Current)
+		if err != nil {
+			return err
+		}
+		value, err := db.resolveLargeValue(readCtx, record.Key, cursor.Current, record.Value)
+		if errors.Is(err, errMongoLargeValueChanged) {
+			// The key was overwritten or deleted since it was found.
+			value, err = db.get(ctx, record.Key)
+		}
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			found[string(record.Key)] = value
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return db.wrapReadErrorContext(ctx, "get many", err, db.queryTime())
+	}
+	db.supervise(nil)
+	return nil
 }
 
 // Has checks if a key exists in the database.
 func (db *MongoDB) Has(key []byte) (bool, error) {
+	return db.HasContext(context.Background(), key)
+}
+
+// HasContext implements ContextDB.
+func (db *MongoDB) HasContext(ctx context.Context, key []byte) (bool, error) {
 	if len(key) == 0 {
 		return false, errKeyEmpty
 	}
 
-	res := db.collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: string(key)}})
+	var ok bool
+	err := db.retry(ctx, func() (err error) {
+		ok, err = db.has(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+// has checks once whether key exists, see HasContext.
+func (db *MongoDB) has(ctx context.Context, key []byte) (bool, error) {
+	buf := getMongoDocBuffer()
+	defer putMongoDocBuffer(buf)
+	*buf = appendMongoUnexpiredKeyFilter(*buf, key)
+
+	// Keys are stored as the _id regardless of the codec, so only the _id is fetched, and the
+	// document does not need decoding.
+	readCtx, cancel := db.readContext(ctx, db.queryTime())
+	defer cancel()
+	res := db.readColl().FindOne(readCtx, bson.Raw(*buf), db.findOneOptions().SetProjection(mongoIDProjection))
 	if res.Err() != nil {
 		if errors.Is(res.Err(), mongo.ErrNoDocuments) {
 			return false, nil
 		}
-		return false, res.Err()
+		return false, db.wrapReadErrorContext(ctx, "has", res.Err(), db.queryTime())
 	}
+	db.supervise(nil)
 
 	return true, nil
 }
 
 // Set inserts a key-value pair into the database. If the key already exists, the value is overwritten.
 func (db *MongoDB) Set(key, value []byte) error {
+	return db.SetContext(context.Background(), key, value)
+}
+
+// SetContext implements ContextDB.
+func (db *MongoDB) SetContext(ctx context.Context, key, value []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
@@ -122,13 +584,46 @@ func (db *MongoDB) Set(key, value []byte) error {
 		return errValueNil
 	}
 
-	_, err := db.collection.UpdateOne(
-		context.Background(),
-		bson.D{{Key: "_id", Value: string(key)}},
-		bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: value}}}},
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+	large, err := db.largeValue(value)
+	if err != nil {
+		return err
+	}
+	if err := db.storageFull.writable(); err != nil {
+		return err
+	}
+
+	op := mongoWriteOp{key: key, value: value, large: large}
+	return db.retry(ctx, func() error { return db.set(ctx, op) })
+}
+
+// set upserts the document of the key of op once, writing the chunks of a large value first and
+// removing the stale chunks of the key afterwards, see SetContext.
+func (db *MongoDB) set(ctx context.Context, op mongoWriteOp) error {
+	cutoff := primitive.NewObjectID()
+	var filter, update interface{}
+	if op.large != nil {
+		if err := db.writeChunks(ctx, op.key, op.value, op.large); err != nil {
+			return err
+		}
+		filter, update = op.setUpdate(db.codec, db.journalColl() != nil)
+	} else {
+		buf := getMongoDocBuffer()
+		defer putMongoDocBuffer(buf)
+		filter, update = mongoSetDocuments(buf, db.codec, op.key, op.value, db.journalColl() != nil)
+	}
+	_, err := db.coll().UpdateOne(
+		ctx,
+		filter,
+		update,
 		&mongoOptions.UpdateOptions{Upsert: ptr(true)},
 	)
-	return err
+	if err != nil {
+		return db.wrapWriteErrorContext(ctx, "set", err)
+	}
+	return db.deleteStaleChunks(ctx, []mongoWriteOp{op}, cutoff)
 }
 
 // SetSync has the same functionality as Set. The MongoDB driver handles synchronization.
@@ -138,19 +633,230 @@ func (db *MongoDB) SetSync(key, value []byte) error {
 
 // Delete removes a key-value pair from the database, if it exists.
 func (db *MongoDB) Delete(key []byte) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext implements ContextDB.
+func (db *MongoDB) DeleteContext(ctx context.Context, key []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
 
-	_, err := db.collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: string(key)}})
+	return db.retry(ctx, func() error { return db.delete(ctx, key) })
+}
+
+// delete deletes the document of key once, and the chunks of its value, see DeleteContext.
+func (db *MongoDB) delete(ctx context.Context, key []byte) error {
+	buf := getMongoDocBuffer()
+	defer putMongoDocBuffer(buf)
+	*buf = appendMongoKeyFilter(*buf, key)
+
+	cutoff := primitive.NewObjectID()
+	_, err := db.coll().DeleteOne(ctx, bson.Raw(*buf))
+	if err != nil {
+		return db.wrapWriteErrorContext(ctx, "delete", err)
+	}
+	if err := db.deleteStaleChunks(ctx, []mongoWriteOp{{key: key}}, cutoff); err != nil {
+		return err
+	}
+	err = db.journalDeletes(ctx, []mongo.WriteModel{mongoTombstoneModel(key)})
+	if aborted := abortedError(ctx, "delete", err); aborted != nil {
+		return aborted
+	}
 	return err
 }
 
+// mongoExpiredCondition matches the documents which expired, i.e. those not matched by
+// mongoUnexpiredCondition.
+var mongoExpiredCondition = bson.E{Key: "$expr", Value: bson.D{{Key: "$not", Value: bson.A{mongoUnexpiredCondition.Value}}}}
+
+// mongoWriteOnceFilter extends the filter of a set with mongoExpiredCondition. An upsert with it
+// replaces the document of the key if it expired but was not deleted by the server yet, inserts one
+// if there is none, and fails with a duplicate key error if the key exists, so that keys are absent
+// for writes exactly when they are absent for reads.
+func mongoWriteOnceFilter(filter bson.D) bson.D {
+	return append(filter, mongoExpiredCondition)
+}
+
+// SetIfAbsent implements ConditionalSetter with a single upsert which only matches an expired
+// document, and thus sets the value or fails with a duplicate key error on the _id. It is not
+// retried, since a retry of an upsert which was applied although it failed would find the key.
+func (db *MongoDB) SetIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	if value == nil {
+		return false, errValueNil
+	}
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return false, err
+	}
+	if err := checkValueLimit(value, mongoMaxValueSize); err != nil {
+		return false, err
+	}
+	if err := db.storageFull.writable(); err != nil {
+		return false, err
+	}
+
+	// An expired document replaced by the upsert may have had a large value.
+	cutoff := primitive.NewObjectID()
+	filter, update := mongoSetUpdate(db.codec, key, value, db.journalColl() != nil)
+	ctx := context.Background()
+	_, err := db.coll().UpdateOne(
+		ctx,
+		mongoWriteOnceFilter(filter),
+		update,
+		&mongoOptions.UpdateOptions{Upsert: ptr(true)},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		db.supervise(nil)
+		return false, nil
+	}
+	if err := db.wrapWriteErrorContext(ctx, "set if absent", err); err != nil {
+		return false, err
+	}
+	if err := db.deleteStaleChunks(ctx, []mongoWriteOp{{key: key, value: value}}, cutoff); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetInsertOnly implements StrictSetter with the conditional upsert of SetIfAbsent.
+func (db *MongoDB) SetInsertOnly(key, value []byte) error {
+	set, err := db.SetIfAbsent(key, value)
+	if err == nil && !set {
+		err = ErrKeyExists{Key: key}
+	}
+	return err
+}
+
+// SetUpdateOnly implements StrictSetter with an update without upsert, using its matched count. It
+// is retried like Set.
+func (db *MongoDB) SetUpdateOnly(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+	if err := checkValueLimit(value, mongoMaxValueSize); err != nil {
+		return err
+	}
+	if err := db.storageFull.writable(); err != nil {
+		return err
+	}
+
+	filter, update := mongoSetUpdate(db.codec, key, value, db.journalColl() != nil)
+	ctx := context.Background()
+	return db.retry(ctx, func() error {
+		cutoff := primitive.NewObjectID()
+		res, err := db.coll().UpdateOne(ctx, filter, update)
+		if err := db.wrapWriteErrorContext(ctx, "set update only", err); err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return ErrKeyNotFound
+		}
+		return db.deleteStaleChunks(ctx, []mongoWriteOp{{key: key, value: value}}, cutoff)
+	})
+}
+
+// DeleteStrict implements StrictDeleter, using the deleted count of the delete. It is not retried,
+// since a retry of a delete which was applied although it failed would not find the key.
+func (db *MongoDB) DeleteStrict(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+
+	ctx := context.Background()
+	cutoff := primitive.NewObjectID()
+	res, err := db.coll().DeleteOne(ctx, mongoKeyFilter(key))
+	if err != nil {
+		return db.wrapWriteErrorContext(ctx, "delete strict", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrKeyNotFound
+	}
+	if err := db.deleteStaleChunks(ctx, []mongoWriteOp{{key: key}}, cutoff); err != nil {
+		return err
+	}
+	return db.journalDeletes(ctx, []mongo.WriteModel{mongoTombstoneModel(key)})
+}
+
 // DeleteSync has the same functionality as Delete. The MongoDB driver handles synchronization.
 func (db *MongoDB) DeleteSync(key []byte) error {
 	return db.Delete(key)
 }
 
+// DeleteRange implements RangeDeleter, deleting all keys in [start, end) with a single request,
+// which is retried like Delete. The keys deleted by an attempt which failed are not counted.
+func (db *MongoDB) DeleteRange(start, end []byte) (int64, error) {
+	filter, err := mongoKeyRangeFilter(start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	ctx := context.Background()
+	err = db.retry(ctx, func() error {
+		// The deleted keys must be journaled individually, so they are looked up first.
+		if db.journalColl() != nil {
+			if err := db.journalRange(ctx, filter); err != nil {
+				return err
+			}
+		}
+		res, err := db.coll().DeleteMany(ctx, filter)
+		if err != nil {
+			return db.wrapWriteErrorContext(ctx, "delete range", err)
+		}
+		deleted = res.DeletedCount
+		return db.deleteChunkRange(ctx, start, end)
+	})
+	return deleted, err
+}
+
+// DeleteKeys implements MultiDeleter, deleting the keys with one request per mongoBatchChunkSize
+// keys, each retried like Delete. Keys are deleted atomically only within a request, and the keys
+// deleted by an attempt which failed are not counted.
+func (db *MongoDB) DeleteKeys(keys [][]byte) (int64, error) {
+	if err := checkKeys(keys); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for start := 0; start < len(keys); start += mongoBatchChunkSize {
+		chunk := keys[start:min(start+mongoBatchChunkSize, len(keys))]
+		ids := make(bson.A, len(chunk))
+		ops := make([]mongoWriteOp, len(chunk))
+		tombstones := make([]mongo.WriteModel, len(chunk))
+		for i, key := range chunk {
+			ids[i] = MongoKeyID(key)
+			ops[i] = mongoWriteOp{key: key}
+			tombstones[i] = mongoTombstoneModel(key)
+		}
+		ctx := context.Background()
+		err := db.retry(ctx, func() error {
+			cutoff := primitive.NewObjectID()
+			res, err := db.coll().DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+			if err != nil {
+				return db.wrapWriteErrorContext(ctx, "delete keys", err)
+			}
+			deleted += res.DeletedCount
+			if err := db.deleteStaleChunks(ctx, ops, cutoff); err != nil {
+				return err
+			}
+			return db.journalDeletes(ctx, tombstones)
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
 // Iterator returns an iterator over a domain of keys, in ascending order. Close() must be called when done.
 // Start is inclusive, and end is exclusive.
 // Example usage:
@@ -164,11 +870,11 @@ func (db *MongoDB) DeleteSync(key []byte) error {
 //			...
 //		}
 func (db *MongoDB) Iterator(start, end []byte) (Iterator, error) {
-	return newMongoDBIterator(db, start, end, false)
+	return db.IteratorWithOptions(start, end, IteratorOptions{})
 }
 
 // ReverseIterator returns an iterator over a domain of keys, in descending order. Close() must be called when done.
-// Start is exclusive, and end is inclusive.
+// Like Iterator, start is inclusive, and end is exclusive, so the first key is the last one before end.
 // Example usage:
 //
 //		itr, err := db.ReverseIterator(start, end)
@@ -180,19 +886,79 @@ func (db *MongoDB) Iterator(start, end []byte) (Iterator, error) {
 //			...
 //		}
 func (db *MongoDB) ReverseIterator(start, end []byte) (Iterator, error) {
-	return newMongoDBIterator(db, start, end, true)
+	return db.IteratorWithOptions(start, end, IteratorOptions{Reverse: true})
+}
+
+// IteratorWithOptions returns an iterator over a domain of keys, like Iterator or ReverseIterator
+// depending on opts.Reverse, and with the maximum query time and the prefetch window overridden by
+// opts.MaxQueryTime and opts.Prefetch.
+func (db *MongoDB) IteratorWithOptions(start, end []byte, opts IteratorOptions) (Iterator, error) {
+	return db.iteratorContext(context.Background(), start, end, opts)
+}
+
+// IteratorContext implements ContextDB.
+func (db *MongoDB) IteratorContext(ctx context.Context, start, end []byte) (Iterator, error) {
+	return db.iteratorContext(ctx, start, end, IteratorOptions{})
 }
 
-// Close closes the underlying MongoDB client.
+// ReverseIteratorContext implements ContextDB.
+func (db *MongoDB) ReverseIteratorContext(ctx context.Context, start, end []byte) (Iterator, error) {
+	return db.iteratorContext(ctx, start, end, IteratorOptions{Reverse: true})
+}
+
+// iteratorContext returns an iterator with the options opts, whose reads are bounded by ctx.
+func (db *MongoDB) iteratorContext(ctx context.Context, start, end []byte, opts IteratorOptions) (Iterator, error) {
+	maxTime := db.queryTime()
+	if opts.MaxQueryTime != 0 {
+		maxTime = max(opts.MaxQueryTime, 0)
+	}
+	prefetch := db.prefetch()
+	if opts.Prefetch > 0 {
+		prefetch = opts.Prefetch
+	}
+	return newMongoDBIterator(ctx, db, start, end, opts.Reverse, maxTime, prefetch)
+}
+
+// Close closes the underlying MongoDB client, unless it is shared with other databases. Closing a
+// closed database is a no-op.
 func (db *MongoDB) Close() error {
-	return db.collection.Database().Client().Disconnect(context.Background())
+	reportClosed(db)
+	db.clientMtx.Lock()
+	closed := db.closed
+	db.closed = true
+	client := db.collection.Database().Client()
+	shared := db.sharedClient
+	db.clientMtx.Unlock()
+
+	if closed || shared {
+		return nil
+	}
+	return mongoDisconnect(client, db.closeTimeout)
 }
 
 // NewBatch returns a new write batch for the database. Batch.Write() must be called to commit the batch.
+//
+// On a replica set or sharded cluster, batches are written in a single transaction, so that they
+// are applied entirely or not at all. Standalone servers do not support transactions: a batch is
+// then written in several bulk writes, and a write which fails or is interrupted may leave part of
+// the batch applied, see ErrBatchIncomplete.
 func (db *MongoDB) NewBatch() Batch {
 	return newMongoDBBatch(db)
 }
 
+// NewBatchContext implements ContextDB. Write and WriteStrict of the batch behave like
+// WriteContext with ctx.
+func (db *MongoDB) NewBatchContext(ctx context.Context) Batch {
+	b := newMongoDBBatch(db)
+	b.ctx = ctx
+	return b
+}
+
+// NewBatchWithSize implements BatchCreator.
+func (db *MongoDB) NewBatchWithSize(size int) Batch {
+	return newMongoDBBatchWithSize(db, size)
+}
+
 // Print prints debug information about the database. This should not be used in production.
 func (db *MongoDB) Print() error {
 	stats := db.Stats()
@@ -204,26 +970,98 @@ func (db *MongoDB) Print() error {
 	return nil
 }
 
-// Stats returns a map of property values provided by the collStats MongoDB command.
+// Stats returns a map of property values provided by the collStats MongoDB command, the driver
+// counters if the client is monitored, and the client rebuild counters if the client is
+// supervised. If collStats fails, the error is returned under "error" instead of its properties.
 func (db *MongoDB) Stats() map[string]string {
-	result := db.collection.Database().RunCommand(
+	stats, _ := db.TypedStats()
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider, with the count, storageSize and size of the collection
+// reported by collStats as the number of keys, the disk size and the memory size, which is the
+// uncompressed size of the documents. Raw holds the statistics of Stats. If collStats fails, its
+// error is returned, and the other statistics are still reported.
+func (db *MongoDB) TypedStats() (DBStats, error) {
+	stats := DBStats{KeyCount: -1, DiskSizeBytes: -1, MemSizeBytes: -1, Raw: make(map[string]string)}
+	err := db.collStats(&stats)
+	if err != nil {
+		stats.Raw = map[string]string{"error": err.Error()}
+	}
+	if db.driverMonitor != nil {
+		for key, value := range db.driverMonitor.Stats() {
+			stats.Raw[key] = value
+		}
+		if rp := db.EffectiveReadPreference(); rp != nil && len(rp.TagSets()) > 0 {
+			for key, value := range db.driverMonitor.readTagSetStats(rp.TagSets()) {
+				stats.Raw[key] = value
+			}
+		}
+	}
+	if db.supervisor != nil {
+		for key, value := range db.supervisor.stats() {
+			stats.Raw[key] = value
+		}
+	}
+	db.storageFull.addStats(stats.Raw)
+	return stats, err
+}
+
+var _ PhysicalWriteCounter = (*MongoDB)(nil)
+
+// PhysicalBytesWritten implements PhysicalWriteCounter, returning the bytes written to the
+// WiredTiger file of the collection, as reported by the collStats command. The count restarts when
+// the server restarts, and is not available with other storage engines.
+func (db *MongoDB) PhysicalBytesWritten() (int64, error) {
+	collection := db.coll()
+	var document struct {
+		WiredTiger *struct {
+			BlockManager struct {
+				BytesWritten int64 `bson:"bytes written"`
+			} `bson:"block-manager"`
+		} `bson:"wiredTiger"`
+	}
+	err := collection.Database().RunCommand(
 		context.Background(),
-		bson.M{"collStats": db.collection.Name()},
+		bson.M{"collStats": collection.Name()},
+	).Decode(&document)
+	if err != nil {
+		return 0, err
+	}
+	if document.WiredTiger == nil {
+		return 0, errors.New("collStats does not report WiredTiger statistics")
+	}
+	return document.WiredTiger.BlockManager.BytesWritten, nil
+}
+
+// collStats adds the properties returned by the collStats command to the Raw statistics of
+// stats, and sets its typed statistics from them.
+func (db *MongoDB) collStats(stats *DBStats) error {
+	collection := db.coll()
+	result := collection.Database().RunCommand(
+		context.Background(),
+		bson.M{"collStats": collection.Name()},
 	)
 
-	if result.Err() != nil {
-		return map[string]string{"error": result.Err().Error()}
+	raw, err := result.Raw()
+	if err != nil {
+		return err
 	}
-
 	var document bson.M
-	if err := result.Decode(&document); err != nil {
-		return map[string]string{"error": err.Error()}
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return err
 	}
-
-	stats := make(map[string]string)
 	for key, value := range document {
-		stats[key] = fmt.Sprintf("%v", value)
+		stats.Raw[key] = fmt.Sprintf("%v", value)
 	}
-
-	return stats
+	for field, n := range map[string]*int64{
+		"count":       &stats.KeyCount,
+		"storageSize": &stats.DiskSizeBytes,
+		"size":        &stats.MemSizeBytes,
+	} {
+		if value, ok := raw.Lookup(field).AsInt64OK(); ok {
+			*n = value
+		}
+	}
+	return nil
 }
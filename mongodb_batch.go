@@ -2,32 +2,90 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// mongoBatchChunkSize is the number of write models sent per bulk write by batches. The chunks of
+// a batch are written in a single transaction when the deployment supports it, see
+// mongoDBBatch.write, and bulk writes are not atomic otherwise, so chunking does not weaken the
+// guarantees of batches.
+const mongoBatchChunkSize = 1000
+
 type mongoDBBatch struct {
-	db     *MongoDB
-	batch  []mongo.WriteModel
-	closed bool
+	db       *MongoDB
+	group    *mongoWriteGroup
+	progress func(done, total int)
+	closed   bool
+	// ctx bounds Write and WriteStrict, see MongoDB.NewBatchContext.
+	ctx context.Context
+	// resumeFrom is the index of the first chunk to write, see ResumeFrom.
+	resumeFrom int
+	// writeChunk writes a chunk of models. Defaults to an unordered bulk write to the collection.
+	writeChunk func(ctx context.Context, models []mongo.WriteModel) (*mongo.BulkWriteResult, error)
+
+	// duplicateThreshold and duplicateHook are set by SetDuplicateHook.
+	duplicateThreshold int
+	duplicateHook      func(BatchStats)
+
+	// sizes holds the key and value size of the latest operation on each key, and size their
+	// total, see Count and SizeBytes.
+	sizes map[string]int
+	size  int
 
 	mu sync.Mutex
 }
 
-var _ Batch = (*mongoDBBatch)(nil)
+var (
+	_ Batch           = (*mongoDBBatch)(nil)
+	_ StrictBatch     = (*mongoDBBatch)(nil)
+	_ ProgressBatch   = (*mongoDBBatch)(nil)
+	_ CoalescingBatch = (*mongoDBBatch)(nil)
+	_ StrictSetBatch  = (*mongoDBBatch)(nil)
+	_ ContextBatch    = (*mongoDBBatch)(nil)
+	_ ResumableBatch  = (*mongoDBBatch)(nil)
+	_ SizedBatch      = (*mongoDBBatch)(nil)
+	_ ResettableBatch = (*mongoDBBatch)(nil)
+)
 
 func newMongoDBBatch(db *MongoDB) *mongoDBBatch {
+	return newMongoDBBatchWithSize(db, 0)
+}
+
+func newMongoDBBatchWithSize(db *MongoDB, size int) *mongoDBBatch {
+	group := newMongoWriteGroupWithSize(size)
+	group.codec = db.codec
+	group.trackTimestamps = db.journalColl() != nil
 	return &mongoDBBatch{
 		db:     db,
-		batch:  make([]mongo.WriteModel, 0),
+		group:  group,
 		closed: false,
+		ctx:    context.Background(),
 	}
 }
 
 // Set implements Batch.
 func (b *mongoDBBatch) Set(key, value []byte) error {
+	return b.set(key, value, mongoSetUpsert)
+}
+
+// SetInsertOnly implements StrictSetBatch, with the conditional upsert of MongoDB.SetIfAbsent.
+func (b *mongoDBBatch) SetInsertOnly(key, value []byte) error {
+	return b.set(key, value, mongoSetInsertOnly)
+}
+
+// SetUpdateOnly implements StrictSetBatch, with an update without upsert. Update-only sets are sent
+// in separate bulk writes, whose matched count is compared to the number of updates.
+func (b *mongoDBBatch) SetUpdateOnly(key, value []byte) error {
+	return b.set(key, value, mongoSetUpdateOnly)
+}
+
+func (b *mongoDBBatch) set(key, value []byte, mode mongoSetMode) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
@@ -36,6 +94,21 @@ func (b *mongoDBBatch) Set(key, value []byte) error {
 		return errValueNil
 	}
 
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+
+	// Only unconditional sets split large values into chunks.
+	var large *mongoLargeValue
+	if mode == mongoSetUpsert {
+		var err error
+		if large, err = b.db.largeValue(value); err != nil {
+			return err
+		}
+	} else if err := checkValueLimit(value, mongoMaxValueSize); err != nil {
+		return err
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -43,12 +116,8 @@ func (b *mongoDBBatch) Set(key, value []byte) error {
 		return errBatchClosed
 	}
 
-	b.batch = append(b.batch,
-		mongo.NewUpdateOneModel().
-			SetFilter(bson.D{{Key: "_id", Value: string(key)}}).
-			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: value}}}}).
-			SetUpsert(true),
-	)
+	b.group.add(mongoWriteOp{seq: b.db.nextSeq(), key: key, value: value, mode: mode, large: large})
+	b.track(key, len(key)+len(value))
 	return nil
 }
 
@@ -64,29 +133,287 @@ func (b *mongoDBBatch) Delete(key []byte) error {
 		return errBatchClosed
 	}
 
-	b.batch = append(b.batch, mongo.NewDeleteOneModel().SetFilter(bson.D{{Key: "_id", Value: string(key)}}))
+	b.group.add(mongoWriteOp{seq: b.db.nextSeq(), key: key})
+	b.track(key, len(key))
 	return nil
 }
 
+// track records size as the size of the latest operation on key. Operations are added in order of
+// their sequence numbers, so the latest operation is the one kept by coalescing.
+func (b *mongoDBBatch) track(key []byte, size int) {
+	if b.sizes == nil {
+		b.sizes = make(map[string]int)
+	}
+	b.size += size - b.sizes[string(key)]
+	b.sizes[string(key)] = size
+}
+
+// Count implements SizedBatch.
+func (b *mongoDBBatch) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.sizes)
+}
+
+// SizeBytes implements SizedBatch.
+func (b *mongoDBBatch) SizeBytes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.size
+}
+
+// SetProgressFunc implements ProgressBatch. The progress is reported after each bulk write of
+// mongoBatchChunkSize operations, counting operations after coalescing multiple writes per key.
+func (b *mongoDBBatch) SetProgressFunc(fn func(done, total int)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.progress = fn
+}
+
+// Stats implements CoalescingBatch.
+func (b *mongoDBBatch) Stats() BatchStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.group.stats
+	stats.Staged += b.group.len()
+	return stats
+}
+
+// SetDuplicateHook implements CoalescingBatch.
+func (b *mongoDBBatch) SetDuplicateHook(threshold int, fn func(BatchStats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.duplicateThreshold = threshold
+	b.duplicateHook = fn
+}
+
 func (b *mongoDBBatch) Write() error {
+	return b.write(b.ctx, false, false)
+}
+
+// WriteContext implements ContextBatch. Each chunk is written with a context derived from ctx,
+// bounded by the client timeout if one is set, so that the chunks and the retries of the driver
+// share the deadline of ctx. No chunk is started once ctx is done.
+func (b *mongoDBBatch) WriteContext(ctx context.Context) error {
+	return b.write(ctx, false, false)
+}
+
+// WriteStrict implements StrictBatch, reconciling the deleted count of the bulk write with the
+// number of deletes. Deletes are coalesced per key, so a key which is set and then deleted in the
+// same batch only counts as deleted if it existed before.
+func (b *mongoDBBatch) WriteStrict() error {
+	return b.write(b.ctx, true, false)
+}
+
+// ResumeFrom implements ResumableBatch. Conflicts and deletes of the skipped chunks are not
+// reported again by the next write.
+func (b *mongoDBBatch) ResumeFrom(chunkIndex int) error {
+	if chunkIndex < 0 {
+		return fmt.Errorf("invalid chunk index %d", chunkIndex)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	if b.closed {
 		return errBatchClosed
 	}
+	b.resumeFrom = chunkIndex
+	return nil
+}
+
+func (b *mongoDBBatch) write(ctx context.Context, strict, sync bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+	if err := b.db.writableFor(b.group.ops); err != nil {
+		return err
+	}
+	if b.db.debugEnabled() {
+		b.db.debugf("mongodb: writing batch of %d operations to %s", b.group.len(), b.db.coll().Name())
+	}
 
-	if len(b.batch) > 0 {
-		if _, err := b.db.collection.BulkWrite(context.Background(), b.batch); err != nil {
+	var expected, deleted int64
+	var conflict error
+	cutoff := primitive.NewObjectID()
+	err := b.group.flush(func(ops []mongoWriteOp, models, tombstones []mongo.WriteModel) error {
+		// The chunks of large values are written first, so that no document refers to missing
+		// chunks, and the chunks they replace are removed once the documents are written.
+		if err := b.db.writeLargeValues(ctx, ops); err != nil {
 			return err
 		}
+		var err error
+		if b.db.supportsTransactions(ctx) {
+			deleted, expected, conflict, err = b.writeTransaction(ctx, ops, models, tombstones, sync)
+		} else {
+			deleted, expected, conflict, err = b.bulkWrite(ctx, ops, models, false)
+			if err == nil {
+				err = b.journal(ctx, tombstones)
+			}
+		}
+		if err != nil || conflict != nil {
+			return err
+		}
+		return b.db.deleteStaleChunks(ctx, ops, cutoff)
+	})
+	if err != nil {
+		return b.db.wrapWriteError(err)
+	}
+	if b.duplicateHook != nil && b.group.stats.Collapsed > b.duplicateThreshold {
+		b.duplicateHook(b.group.stats)
 	}
 
-	return b.closeUnsafe()
+	if err := b.closeUnsafe(); err != nil {
+		return err
+	}
+	if conflict != nil {
+		return conflict
+	}
+	if strict && deleted < expected {
+		return fmt.Errorf("%w: %d of %d deleted keys did not exist", ErrKeyNotFound, expected-deleted, expected)
+	}
+	return nil
+}
+
+// bulkWrite writes the coalesced operations ops with their models in unordered bulk writes of at
+// most mongoBatchChunkSize models, skipping the chunks before resumeFrom, and returns the number of
+// deleted keys and of written deletes. Operations are coalesced per key, so the bulk writes do not
+// need to preserve order. Update-only sets are written last, in separate bulk writes, so that their
+// matched count tells whether all keys existed. A failed condition is returned as conflict, after
+// all writes were attempted, and any other error of a chunk as an *ErrBatchIncomplete. Within a
+// transaction, which the server aborts on the first failed write, a failed condition is returned
+// as soon as it is detected.
+func (b *mongoDBBatch) bulkWrite(
+	ctx context.Context, ops []mongoWriteOp, models []mongo.WriteModel, inTransaction bool,
+) (deleted, expected int64, conflict, err error) {
+	chunks, updates := mongoBatchChunks(ops)
+	var done, matched int64
+	for c, chunk := range chunks {
+		updateOnly := ops[chunk[0]].mode == mongoSetUpdateOnly
+		if c < b.resumeFrom {
+			// The chunk was applied by an earlier write.
+			if updateOnly {
+				matched += int64(len(chunk))
+			}
+			done += int64(len(chunk))
+			continue
+		}
+		incomplete := func(err error) error {
+			return &ErrBatchIncomplete{Chunk: c, Applied: int(done), Total: len(models), Err: err}
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, 0, nil, incomplete(err)
+		}
+
+		chunkModels := make([]mongo.WriteModel, len(chunk))
+		for j, i := range chunk {
+			chunkModels[j] = models[i]
+			if ops[i].isDelete() {
+				expected++
+			}
+		}
+		res, err := b.bulkWriteChunk(ctx, chunkModels)
+		if err != nil {
+			existing, ok := mongoInsertConflict(err, ops, chunk)
+			if !ok {
+				return 0, 0, nil, incomplete(err)
+			}
+			if conflict == nil {
+				conflict = ErrKeyExists{Key: existing}
+			}
+			if inTransaction {
+				return 0, 0, conflict, nil
+			}
+		}
+		deleted += res.DeletedCount
+		if updateOnly {
+			matched += res.MatchedCount
+		}
+		done += int64(len(chunk))
+		if b.progress != nil {
+			b.progress(int(done), len(models))
+		}
+	}
+	if conflict == nil && matched < int64(updates) {
+		conflict = fmt.Errorf("%w: %d of %d updated keys did not exist", ErrKeyNotFound,
+			int64(updates)-matched, updates)
+	}
+	return deleted, expected, conflict, nil
+}
+
+// journal writes the tombstones of the deletions journal. Errors are not wrapped, see write.
+func (b *mongoDBBatch) journal(ctx context.Context, tombstones []mongo.WriteModel) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+	_, err := b.db.journalColl().BulkWrite(ctx, tombstones, options.BulkWrite().SetOrdered(false))
+	return err
 }
 
+// bulkWriteChunk writes a chunk of models, with a context derived from ctx and bounded by the
+// client timeout.
+func (b *mongoDBBatch) bulkWriteChunk(ctx context.Context, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	if b.db.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.db.clientTimeout)
+		defer cancel()
+	}
+	if b.writeChunk != nil {
+		return b.writeChunk(ctx, models)
+	}
+	return b.db.coll().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+}
+
+// mongoBatchChunks splits the indices of ops into the chunks of bulkWrite, with the update-only
+// sets in the last chunks, and returns them with the number of update-only sets.
+func mongoBatchChunks(ops []mongoWriteOp) (chunks [][]int, updates int) {
+	var others, updateOnly []int
+	for i, op := range ops {
+		if op.mode == mongoSetUpdateOnly {
+			updateOnly = append(updateOnly, i)
+		} else {
+			others = append(others, i)
+		}
+	}
+	for _, indices := range [][]int{others, updateOnly} {
+		for len(indices) > 0 {
+			chunk := indices[:min(len(indices), mongoBatchChunkSize)]
+			indices = indices[len(chunk):]
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, len(updateOnly)
+}
+
+// mongoInsertConflict returns the first key of a bulk write error which only failed insert-only
+// sets of existing keys, and false for any other error. chunk holds the indices in ops of the
+// models of the bulk write.
+func mongoInsertConflict(err error, ops []mongoWriteOp, chunk []int) ([]byte, bool) {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return nil, false
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Index >= len(chunk) || ops[chunk[we.Index]].mode != mongoSetInsertOnly ||
+			!mongo.IsDuplicateKeyError(we.WriteError) {
+			return nil, false
+		}
+	}
+	return ops[chunk[bwe.WriteErrors[0].Index]].key, true
+}
+
+// WriteSync implements Batch. Within a transaction, the transaction is committed with a majority
+// write concern, so that it survives a failover once WriteSync returns.
 func (b *mongoDBBatch) WriteSync() error {
-	return b.Write()
+	return b.write(b.ctx, false, true)
 }
 
 func (b *mongoDBBatch) Close() error {
@@ -98,5 +425,21 @@ func (b *mongoDBBatch) Close() error {
 
 func (b *mongoDBBatch) closeUnsafe() error {
 	b.closed = true
+	b.sizes = nil
+	b.size = 0
 	return nil
 }
+
+// Reset implements ResettableBatch. The statistics and resume point of the batch are cleared,
+// while its context, progress function and duplicate hook are kept.
+func (b *mongoDBBatch) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = false
+	b.group.ops = b.group.ops[:0]
+	b.group.stats = BatchStats{}
+	b.resumeFrom = 0
+	b.sizes = nil
+	b.size = 0
+}
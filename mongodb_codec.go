@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// mongoOptionRecordCodec is the name of the RecordCodec used for the documents of a MongoDB,
+// see RegisterRecordCodec. Defaults to DefaultRecordCodec.
+const mongoOptionRecordCodec = "record_codec"
+
+// DefaultRecordCodec is the name of the default RecordCodec, which stores the value as binary data
+// in the value field.
+const DefaultRecordCodec = "default"
+
+// RecordCodec encodes and decodes the MongoDB documents storing key/value pairs. Keys are always
+// stored as the _id of the document, encoded with MongoKeyID, since key lookups, ranges and
+// ordering rely on it; codecs control how the value and any additional fields are stored, and use
+// MongoKeyID and MongoDocumentKey for the key.
+type RecordCodec interface {
+	// EncodeSet returns the filter and update document which set key to value. The update is
+	// applied as an upsert, and may be extended with further update operators by the backend.
+	EncodeSet(key, value []byte) (filter, update bson.D)
+
+	// Decode decodes a document written by EncodeSet. Meta holds any additional fields exposed by
+	// the codec, and may be nil.
+	Decode(raw bson.Raw) (key, value []byte, meta bson.M, err error)
+}
+
+var (
+	recordCodecsMtx sync.RWMutex
+	recordCodecs    = map[string]RecordCodec{DefaultRecordCodec: defaultRecordCodec{}}
+)
+
+// RegisterRecordCodec registers a RecordCodec under the given name, which can then be selected with
+// the record_codec option. Registering a codec under an existing name replaces it.
+func RegisterRecordCodec(name string, codec RecordCodec) {
+	recordCodecsMtx.Lock()
+	defer recordCodecsMtx.Unlock()
+	recordCodecs[name] = codec
+}
+
+// mongoRecordCodec returns the RecordCodec selected by the record_codec option.
+func mongoRecordCodec(options Options) (RecordCodec, error) {
+	name, ok := options[mongoOptionRecordCodec]
+	if !ok {
+		name = DefaultRecordCodec
+	}
+
+	recordCodecsMtx.RLock()
+	defer recordCodecsMtx.RUnlock()
+	codec, ok := recordCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %q: no such codec", mongoOptionRecordCodec, name)
+	}
+	return codec, nil
+}
+
+// MongoKeyID returns the _id of the document of a key: the key encoded as a lowercase hex string.
+// Hex strings are valid UTF-8 whatever the key bytes, and MongoDB compares them bytewise, so they
+// sort like the keys themselves in the order of bytes.Compare. Binary _ids would not, since
+// MongoDB orders binary data by length first.
+func MongoKeyID(key []byte) string {
+	return hex.EncodeToString(key)
+}
+
+// MongoDocumentKey returns the key of a document, decoded from its _id, see MongoKeyID. Documents
+// with an _id which is not a hex-encoded key, such as those written before keys were hex-encoded,
+// are rejected, since they are never matched by key filters. Such collections must be rewritten
+// with MongoHexKeyMigration before they can be read.
+func MongoDocumentKey(raw bson.Raw) ([]byte, error) {
+	id, err := raw.LookupErr("_id")
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	s, ok := id.StringValueOK()
+	if !ok {
+		return nil, fmt.Errorf("invalid document: _id is of type %s, keys must be stored as hex strings", id.Type)
+	}
+	return mongoKeyFromID(s)
+}
+
+// mongoKeyFromID decodes a key from the _id of its document, see MongoKeyID.
+func mongoKeyFromID(id string) ([]byte, error) {
+	valid := len(id)%2 == 0
+	for i := 0; i < len(id) && valid; i++ {
+		// Upper case digits would decode, but not sort or match like the _ids of MongoKeyID.
+		valid = '0' <= id[i] && id[i] <= '9' || 'a' <= id[i] && id[i] <= 'f'
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid document: _id %q is not a hex-encoded key, see MongoHexKeyMigration", id)
+	}
+	return hex.DecodeString(id)
+}
+
+// mongoKeyFilter returns the filter matching the document of a key, by its _id, see MongoKeyID.
+// The filters of ranges, see mongoKeyRangeFilter, compare the _ids of the same encoding.
+func mongoKeyFilter(key []byte) bson.D {
+	return bson.D{{Key: "_id", Value: MongoKeyID(key)}}
+}
+
+// defaultRecordCodec stores documents as {_id: key, value: value}.
+type defaultRecordCodec struct{}
+
+var _ RecordCodec = defaultRecordCodec{}
+
+// EncodeSet implements RecordCodec.
+func (defaultRecordCodec) EncodeSet(key, value []byte) (bson.D, bson.D) {
+	return mongoKeyFilter(key), bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: value}}}}
+}
+
+// Decode implements RecordCodec.
+func (defaultRecordCodec) Decode(raw bson.Raw) ([]byte, []byte, bson.M, error) {
+	key, err := MongoDocumentKey(raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var rec record
+	if err := bson.Unmarshal(raw, &rec); err != nil {
+		return nil, nil, nil, err
+	}
+	return key, rec.Value, nil, nil
+}
+
+// mongoIDProjection projects documents on their _id, i.e. their key, for reads which do not need
+// the value. mongoValueProjection projects them on the value of the default codec, and the marker
+// of large values.
+var (
+	mongoIDProjection    = bson.D{{Key: "_id", Value: 1}}
+	mongoValueProjection = bson.D{{Key: "_id", Value: 0}, {Key: "value", Value: 1}, {Key: mongoLargeValueField, Value: 1}}
+)
+
+// decodeMongoValue decodes the value of a document of the default codec projected with
+// mongoValueProjection, like defaultRecordCodec.Decode and decodeRecord would decode it.
+func decodeMongoValue(raw bson.Raw) ([]byte, error) {
+	var value []byte
+	rv, err := raw.LookupErr("value")
+	switch {
+	case err == nil:
+		if err := rv.Unmarshal(&value); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, bsoncore.ErrElementNotFound):
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// decodeRecord decodes a document with the codec of the database. Values are never nil, since nil
+// values cannot be set: codecs, or the driver, may decode an empty binary value as nil, which is
+// returned as an empty value so that it is not mistaken for a missing key.
+func (db *MongoDB) decodeRecord(raw bson.Raw) (*record, error) {
+	key, value, _, err := db.codec.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return &record{Key: key, Value: value}, nil
+}
+
+// SetRecordCodec sets the codec used for the documents of the database. It must be called before
+// the database is used, and all writers of a collection must use the same codec.
+func (db *MongoDB) SetRecordCodec(codec RecordCodec) {
+	db.codec = codec
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDB server error codes which indicate that the compact command is not allowed, besides
+// mongoCodeAtlasError, which Atlas shared tiers return for forbidden commands.
+const (
+	mongoCodeUnauthorized        = 13
+	mongoCodeCommandNotFound     = 59
+	mongoCodeCommandNotSupported = 115
+)
+
+// Compact implements DB, running the compact command on the collection, and on the deletions
+// journal if timestamps are tracked. MongoDB compacts whole collections, so the domain is ignored.
+// The command blocks other operations on the collection on servers before MongoDB 4.4. It fails
+// with an *ErrCompactUnsupported if the server forbids the command, as Atlas shared tiers do, or
+// if the user lacks the privilege to run it.
+func (db *MongoDB) Compact(_, _ []byte) error {
+	collections := []*mongo.Collection{db.coll()}
+	if journal := db.journalColl(); journal != nil {
+		collections = append(collections, journal)
+	}
+	for _, collection := range collections {
+		err := collection.Database().RunCommand(context.Background(),
+			bson.D{{Key: "compact", Value: collection.Name()}}).Err()
+		if err != nil {
+			return wrapCompactError(err)
+		}
+	}
+	return nil
+}
+
+// wrapCompactError returns err as an *ErrCompactUnsupported if the server refused the compact
+// command. Other errors are returned unchanged.
+func wrapCompactError(err error) error {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return err
+	}
+	for _, code := range []int{
+		mongoCodeAtlasError, mongoCodeUnauthorized, mongoCodeCommandNotFound, mongoCodeCommandNotSupported,
+	} {
+		if serverErr.HasErrorCode(code) {
+			return &ErrCompactUnsupported{Backend: MongoDBBackend, Err: err}
+		}
+	}
+	return err
+}
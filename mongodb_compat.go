@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDB server error codes which indicate that the collection cannot store the documents written
+// by this backend.
+const (
+	mongoCodeIllegalOperation          = 20
+	mongoCodeDocumentValidationFailure = 121
+	mongoCodeCappedSizeChange          = 10003
+)
+
+// MongoDB server error codes which indicate that storage is full.
+const (
+	mongoCodeOutOfDiskSpace = 14031
+	// mongoCodeAtlasError is used by Atlas for various errors, including exceeded space quotas,
+	// which are identified by mongoAtlasSpaceQuotaMessage.
+	mongoCodeAtlasError         = 8000
+	mongoAtlasSpaceQuotaMessage = "space quota"
+)
+
+// ErrIncompatibleCollection is returned when the MongoDB collection backing a MongoDB is configured
+// in a way that rejects the documents written by this backend, e.g. because it is capped or has a
+// validator which the documents do not satisfy.
+type ErrIncompatibleCollection struct {
+	// Collection is the name of the offending collection.
+	Collection string
+	// Reason describes the offending collection option, including the validator rule if any.
+	Reason string
+	// Err is the underlying server error, if the incompatibility was detected on write.
+	Err error
+}
+
+// Error implements error.
+func (e *ErrIncompatibleCollection) Error() string {
+	msg := fmt.Sprintf("mongodb collection %q is incompatible: %s", e.Collection, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying server error.
+func (e *ErrIncompatibleCollection) Unwrap() error {
+	return e.Err
+}
+
+// collectionOptions is the subset of the listCollections options relevant to compatibility.
+type collectionOptions struct {
+	Capped           bool     `bson:"capped"`
+	Validator        bson.Raw `bson:"validator"`
+	ValidationLevel  string   `bson:"validationLevel"`
+	ValidationAction string   `bson:"validationAction"`
+}
+
+// ValidateCompatibility checks the options of the underlying collection and returns an
+// *ErrIncompatibleCollection if the collection is capped, or enforces a validator on writes. It is
+// intended to be called at startup, before any writes are made. A collection which does not exist
+// yet is considered compatible, as it will be created on the first write.
+func (db *MongoDB) ValidateCompatibility(ctx context.Context) error {
+	opts, err := db.collectionOptions(ctx)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		return nil
+	}
+
+	if reason := opts.incompatibility(); reason != "" {
+		return &ErrIncompatibleCollection{Collection: db.coll().Name(), Reason: reason}
+	}
+	return nil
+}
+
+// collectionOptions fetches the options of the underlying collection via listCollections. Returns
+// nil options if the collection does not exist.
+func (db *MongoDB) collectionOptions(ctx context.Context) (*collectionOptions, error) {
+	specs, err := db.coll().Database().ListCollectionSpecifications(
+		ctx,
+		bson.D{{Key: "name", Value: db.coll().Name()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	var opts collectionOptions
+	if len(specs[0].Options) > 0 {
+		if err := bson.Unmarshal(specs[0].Options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
+
+// incompatibility returns a description of why the collection cannot be used, or an empty string
+// if it can.
+func (o *collectionOptions) incompatibility() string {
+	if o.Capped {
+		return "collection is capped"
+	}
+	if len(o.Validator) > 0 && o.ValidationLevel != "off" && o.ValidationAction != "warn" {
+		return fmt.Sprintf("collection enforces validator %s", o.Validator.String())
+	}
+	return ""
+}
+
+// wrapWriteError converts server errors caused by the collection's configuration into an
+// *ErrIncompatibleCollection, and errors caused by full storage into ErrStorageFull, which it
+// tracks to make the database read-only, see optionReadOnlyAfterStorageFull. Fatal topology errors
+// are wrapped with ErrUnavailable, and other errors are returned unchanged.
+func (db *MongoDB) wrapWriteError(err error) error {
+	db.supervise(err)
+	return db.storageFull.track(db.classifyWriteError(err))
+}
+
+// classifyWriteError converts the error of a write, see wrapWriteError.
+func (db *MongoDB) classifyWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return unavailableError(err)
+	}
+
+	var reason string
+	switch {
+	case serverErr.HasErrorCode(mongoCodeOutOfDiskSpace),
+		serverErr.HasErrorCodeWithMessage(mongoCodeAtlasError, mongoAtlasSpaceQuotaMessage):
+		return ErrStorageFull{Err: err}
+	case serverErr.HasErrorCode(mongoCodeDocumentValidationFailure):
+		reason = "document failed validation"
+	case serverErr.HasErrorCode(mongoCodeCappedSizeChange):
+		reason = "collection is capped"
+	case serverErr.HasErrorCode(mongoCodeIllegalOperation):
+		// IllegalOperation is also used for unrelated errors, so only treat it as an
+		// incompatibility if the collection is actually capped.
+		opts, optsErr := db.collectionOptions(context.Background())
+		if optsErr != nil || opts == nil || !opts.Capped {
+			return err
+		}
+		reason = "collection is capped"
+	default:
+		return err
+	}
+
+	// Prefer the offending rule from listCollections, if we can fetch it.
+	if opts, optsErr := db.collectionOptions(context.Background()); optsErr == nil && opts != nil {
+		if r := opts.incompatibility(); r != "" {
+			reason = r
+		}
+	}
+
+	return &ErrIncompatibleCollection{Collection: db.coll().Name(), Reason: reason, Err: err}
+}
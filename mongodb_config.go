@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	// mongoOptionConnectTimeout is the timeout, in milliseconds, of establishing a connection to a
+	// server. Zero or absent uses the driver default of 30 seconds.
+	mongoOptionConnectTimeout = "connect_timeout_ms"
+	// mongoOptionWriteConcern is the write concern of the client: "majority", or the number of
+	// members which must acknowledge writes. Absent uses the connection string or server default.
+	mongoOptionWriteConcern = "write_concern"
+	// mongoOptionReadConcern is the read concern level of the client, e.g. "majority". Absent uses
+	// the connection string or server default.
+	mongoOptionReadConcern = "read_concern"
+	// mongoOptionMaxPoolSize is the maximum number of connections per server. Zero means no limit,
+	// and absent uses the driver default of 100.
+	mongoOptionMaxPoolSize = "max_pool_size"
+	// mongoOptionTLS enables TLS if "true". It is implied by the other TLS options.
+	mongoOptionTLS = "tls"
+	// mongoOptionTLSCAFile is a PEM file with the certificate authorities verifying servers.
+	mongoOptionTLSCAFile = "tls_ca_file"
+	// mongoOptionTLSCertificateKeyFile is a PEM file with the client certificate and its key.
+	mongoOptionTLSCertificateKeyFile = "tls_certificate_key_file"
+	// mongoOptionTLSInsecureSkipVerify disables the verification of servers if "true".
+	mongoOptionTLSInsecureSkipVerify = "tls_insecure_skip_verify"
+)
+
+// mongoClientConfigOptions are the options of NewDB configuring the client, besides the connection
+// string, which are set by MongoDBConfig.
+var mongoClientConfigOptions = []string{
+	mongoOptionConnectTimeout,
+	mongoOptionWriteConcern,
+	mongoOptionReadConcern,
+	mongoOptionMaxPoolSize,
+	mongoOptionTLS,
+	mongoOptionTLSCAFile,
+	mongoOptionTLSCertificateKeyFile,
+	mongoOptionTLSInsecureSkipVerify,
+}
+
+// MongoDBConfig is the typed configuration of a MongoDB database, see NewMongoDBFromConfig. Its
+// fields correspond to options of NewDB, see Options. Zero values keep the defaults of the
+// connection string or the driver.
+type MongoDBConfig struct {
+	// ConnectionString is the MongoDB connection string, e.g. "mongodb://localhost:27017".
+	ConnectionString string
+	// Database and Collection name the collection storing the keys.
+	Database   string
+	Collection string
+
+	// ConnectTimeout is the timeout of establishing a connection to a server.
+	ConnectTimeout time.Duration
+	// WriteConcern is "majority", or the number of members which must acknowledge writes.
+	WriteConcern string
+	// ReadConcern is the read concern level: "local", "available", "majority", "linearizable" or
+	// "snapshot".
+	ReadConcern string
+	// ReadPreference is the read mode: "primary", "nearest" or "secondary".
+	ReadPreference string
+	// MaxPoolSize is the maximum number of connections per server.
+	MaxPoolSize uint64
+	// TLS enables TLS with the given settings, if set.
+	TLS *MongoDBTLSConfig
+	// RetryAttempts is the number of times Get, Has, Set and Delete are retried when the server
+	// is unavailable, see ErrUnavailable. Zero never retries.
+	RetryAttempts int
+	// RetryBackoff is the delay before the first retry, which doubles for every further retry.
+	// Zero uses a delay of 100ms.
+	RetryBackoff time.Duration
+	// LargeValueThreshold is the size in bytes above which values are split into chunks, see
+	// MongoDB.SetLargeValueThreshold. Zero uses 15 MiB, and a negative value disables chunking.
+	LargeValueThreshold int
+	// CloseTimeout is the time which Close waits for the client to disconnect, see
+	// MongoDB.SetCloseTimeout. Zero uses 10s, and a negative value waits without limit.
+	CloseTimeout time.Duration
+}
+
+// MongoDBTLSConfig configures TLS connections to MongoDB servers.
+type MongoDBTLSConfig struct {
+	// CAFile is a PEM file with the certificate authorities verifying servers. The system
+	// certificate authorities are used if empty.
+	CAFile string
+	// CertificateKeyFile is a PEM file with the client certificate and its private key, for
+	// clients authenticating with X.509 certificates.
+	CertificateKeyFile string
+	// InsecureSkipVerify disables the verification of server certificates. For testing only.
+	InsecureSkipVerify bool
+}
+
+// Options returns the options of NewDB corresponding to cfg.
+func (cfg MongoDBConfig) Options() Options {
+	options := NewMongoDBOptions(cfg.ConnectionString, cfg.Database, cfg.Collection)
+	if cfg.ConnectTimeout != 0 {
+		options[mongoOptionConnectTimeout] = strconv.FormatInt(cfg.ConnectTimeout.Milliseconds(), 10)
+	}
+	if cfg.WriteConcern != "" {
+		options[mongoOptionWriteConcern] = cfg.WriteConcern
+	}
+	if cfg.ReadConcern != "" {
+		options[mongoOptionReadConcern] = cfg.ReadConcern
+	}
+	if cfg.ReadPreference != "" {
+		options[mongoOptionReadMode] = cfg.ReadPreference
+	}
+	if cfg.MaxPoolSize != 0 {
+		options[mongoOptionMaxPoolSize] = strconv.FormatUint(cfg.MaxPoolSize, 10)
+	}
+	if cfg.RetryAttempts != 0 {
+		options[mongoOptionRetryAttempts] = strconv.Itoa(cfg.RetryAttempts)
+	}
+	if cfg.RetryBackoff != 0 {
+		options[mongoOptionRetryBackoff] = strconv.FormatInt(cfg.RetryBackoff.Milliseconds(), 10)
+	}
+	if cfg.LargeValueThreshold != 0 {
+		options[mongoOptionLargeValueThreshold] = strconv.Itoa(max(cfg.LargeValueThreshold, 0))
+	}
+	if cfg.CloseTimeout != 0 {
+		options[mongoOptionCloseTimeout] = strconv.FormatInt(max(cfg.CloseTimeout.Milliseconds(), 0), 10)
+	}
+	if cfg.TLS != nil {
+		options[mongoOptionTLS] = "true"
+		if cfg.TLS.CAFile != "" {
+			options[mongoOptionTLSCAFile] = cfg.TLS.CAFile
+		}
+		if cfg.TLS.CertificateKeyFile != "" {
+			options[mongoOptionTLSCertificateKeyFile] = cfg.TLS.CertificateKeyFile
+		}
+		if cfg.TLS.InsecureSkipVerify {
+			options[mongoOptionTLSInsecureSkipVerify] = "true"
+		}
+	}
+	return options
+}
+
+// Validate returns an error naming the first invalid field of cfg, if any.
+func (cfg MongoDBConfig) Validate() error {
+	for _, f := range []struct{ field, value string }{
+		{"ConnectionString", cfg.ConnectionString},
+		{"Database", cfg.Database},
+		{"Collection", cfg.Collection},
+	} {
+		if f.value == "" {
+			return fmt.Errorf("invalid MongoDBConfig.%s: must not be empty", f.field)
+		}
+	}
+	if cfg.ConnectTimeout < 0 || (cfg.ConnectTimeout > 0 && cfg.ConnectTimeout < time.Millisecond) {
+		return fmt.Errorf("invalid MongoDBConfig.ConnectTimeout %v: must be zero or at least 1ms", cfg.ConnectTimeout)
+	}
+	if cfg.RetryAttempts < 0 {
+		return fmt.Errorf("invalid MongoDBConfig.RetryAttempts %d: must not be negative", cfg.RetryAttempts)
+	}
+	if cfg.RetryBackoff < 0 || (cfg.RetryBackoff > 0 && cfg.RetryBackoff < time.Millisecond) {
+		return fmt.Errorf("invalid MongoDBConfig.RetryBackoff %v: must be zero or at least 1ms", cfg.RetryBackoff)
+	}
+	if cfg.LargeValueThreshold > mongoMaxValueSize {
+		return fmt.Errorf("invalid MongoDBConfig.LargeValueThreshold %d: must be at most %d", cfg.LargeValueThreshold,
+			mongoMaxValueSize)
+	}
+	if cfg.CloseTimeout > 0 && cfg.CloseTimeout < time.Millisecond {
+		return fmt.Errorf("invalid MongoDBConfig.CloseTimeout %v: must be at least 1ms if positive", cfg.CloseTimeout)
+	}
+
+	options := cfg.Options()
+	checks := []struct {
+		field string
+		check func(Options) error
+	}{
+		{"WriteConcern", func(o Options) error { _, err := mongoWriteConcern(o); return err }},
+		{"ReadConcern", func(o Options) error { _, err := mongoReadConcern(o); return err }},
+		{"ReadPreference", func(o Options) error { _, err := mongoReadPreference(o); return err }},
+		{"TLS", func(o Options) error { _, err := mongoTLSConfig(o); return err }},
+	}
+	for _, c := range checks {
+		if err := c.check(options); err != nil {
+			return fmt.Errorf("invalid MongoDBConfig.%s: %w", c.field, err)
+		}
+	}
+	return nil
+}
+
+// NewMongoDBFromConfig connects to MongoDB with the configuration cfg, and returns the database of
+// its collection. It is equivalent to NewDB with cfg.Options(), but fails with an error naming
+// the offending field if cfg is invalid.
+func NewMongoDBFromConfig(cfg MongoDBConfig) (*MongoDB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	db, err := mongoDBCreator(context.Background(), cfg.Options())
+	if err != nil {
+		return nil, err
+	}
+	return db.(*MongoDB), nil
+}
+
+// applyMongoClientConfig applies the client options of mongoClientConfigOptions to opts.
+func applyMongoClientConfig(opts *mongoOptions.ClientOptions, options Options) error {
+	if s, ok := options[mongoOptionConnectTimeout]; ok {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", mongoOptionConnectTimeout, s, err)
+		}
+		if ms < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", mongoOptionConnectTimeout, ms)
+		}
+		if ms > 0 {
+			opts.SetConnectTimeout(time.Duration(ms) * time.Millisecond)
+		}
+	}
+	wc, err := mongoWriteConcern(options)
+	if err != nil {
+		return err
+	}
+	if wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	rc, err := mongoReadConcern(options)
+	if err != nil {
+		return err
+	}
+	if rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	if s, ok := options[mongoOptionMaxPoolSize]; ok {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", mongoOptionMaxPoolSize, s, err)
+		}
+		opts.SetMaxPoolSize(n)
+	}
+	tlsConfig, err := mongoTLSConfig(options)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return nil
+}
+
+// mongoWriteConcern returns the write concern configured by the write_concern option, or nil if
+// it is not set.
+func mongoWriteConcern(options Options) (*writeconcern.WriteConcern, error) {
+	s, ok := options[mongoOptionWriteConcern]
+	if !ok {
+		return nil, nil
+	}
+	if s == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	w, err := strconv.Atoi(s)
+	if err != nil || w < 0 {
+		return nil, fmt.Errorf("invalid %s %q, expected \"majority\" or a number of members", mongoOptionWriteConcern, s)
+	}
+	return &writeconcern.WriteConcern{W: w}, nil
+}
+
+// mongoReadConcern returns the read concern configured by the read_concern option, or nil if it
+// is not set.
+func mongoReadConcern(options Options) (*readconcern.ReadConcern, error) {
+	s, ok := options[mongoOptionReadConcern]
+	if !ok {
+		return nil, nil
+	}
+	switch s {
+	case "local":
+		return readconcern.Local(), nil
+	case "available":
+		return readconcern.Available(), nil
+	case "majority":
+		return readconcern.Majority(), nil
+	case "linearizable":
+		return readconcern.Linearizable(), nil
+	case "snapshot":
+		return readconcern.Snapshot(), nil
+	default:
+		return nil, fmt.Errorf("invalid %s %q, expected one of local, available, majority, linearizable, snapshot",
+			mongoOptionReadConcern, s)
+	}
+}
+
+// mongoTLSConfig returns the TLS configuration of the TLS options, or nil if TLS is not enabled by
+// any of them.
+func mongoTLSConfig(options Options) (*tls.Config, error) {
+	flags := make(map[string]bool, 2)
+	for _, key := range []string{mongoOptionTLS, mongoOptionTLSInsecureSkipVerify} {
+		s, ok := options[key]
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, s, err)
+		}
+		flags[key] = b
+	}
+	caFile, hasCA := options[mongoOptionTLSCAFile]
+	certFile, hasCert := options[mongoOptionTLSCertificateKeyFile]
+	insecure := flags[mongoOptionTLSInsecureSkipVerify]
+	if !flags[mongoOptionTLS] && !insecure && !hasCA && !hasCert {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure} //nolint:gosec
+	if hasCA {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", mongoOptionTLSCAFile, err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid %s %q: no certificates found", mongoOptionTLSCAFile, caFile)
+		}
+	}
+	if hasCert {
+		cert, err := tls.LoadX509KeyPair(certFile, certFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", mongoOptionTLSCertificateKeyFile, certFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestMongoDBConfigOptions(t *testing.T) {
+	cfg := MongoDBConfig{
+		ConnectionString: "mongodb://127.0.0.1:1",
+		Database:         "testing",
+		Collection:       "config",
+		ConnectTimeout:   5 * time.Second,
+		WriteConcern:     "majority",
+		ReadConcern:      "majority",
+		ReadPreference:   "nearest",
+		MaxPoolSize:      10,
+		TLS:              &MongoDBTLSConfig{InsecureSkipVerify: true},
+		RetryAttempts:    3,
+		RetryBackoff:     50 * time.Millisecond,
+	}
+	require.NoError(t, cfg.Validate())
+	options := cfg.Options()
+	assert.Equal(t, Options{
+		"connection_string":              "mongodb://127.0.0.1:1",
+		"database":                       "testing",
+		"collection":                     "config",
+		mongoOptionConnectTimeout:        "5000",
+		mongoOptionWriteConcern:          "majority",
+		mongoOptionReadConcern:           "majority",
+		mongoOptionReadMode:              "nearest",
+		mongoOptionMaxPoolSize:           "10",
+		mongoOptionTLS:                   "true",
+		mongoOptionTLSInsecureSkipVerify: "true",
+		mongoOptionRetryAttempts:         "3",
+		mongoOptionRetryBackoff:          "50",
+	}, options)
+
+	opts, _, err := mongoClientOptions(options)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, *opts.ConnectTimeout)
+	assert.Equal(t, writeconcern.Majority(), opts.WriteConcern)
+	assert.Equal(t, "majority", opts.ReadConcern.Level)
+	assert.Equal(t, uint64(10), *opts.MaxPoolSize)
+	require.NotNil(t, opts.TLSConfig)
+	assert.True(t, opts.TLSConfig.InsecureSkipVerify)
+
+	// Unset fields keep the defaults.
+	opts, _, err = mongoClientOptions(MongoDBConfig{ConnectionString: "mongodb://127.0.0.1:1"}.Options())
+	require.NoError(t, err)
+	assert.Nil(t, opts.ConnectTimeout)
+	assert.Nil(t, opts.WriteConcern)
+	assert.Nil(t, opts.ReadConcern)
+	assert.Nil(t, opts.TLSConfig)
+
+	opts, _, err = mongoClientOptions(Options{"connection_string": "mongodb://127.0.0.1:1", mongoOptionWriteConcern: "2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, opts.WriteConcern.W)
+
+	// A negative large value threshold disables chunking.
+	assert.Equal(t, "0", MongoDBConfig{LargeValueThreshold: -1}.Options()[mongoOptionLargeValueThreshold])
+
+	// NewMongoDBFromConfig fails once the server selection times out.
+	cfg.ConnectionString = "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=100"
+	_, err = NewMongoDBFromConfig(cfg)
+	assert.ErrorContains(t, err, string(MongoDBBackend))
+}
+
+func TestMongoDBConfigValidate(t *testing.T) {
+	valid := MongoDBConfig{ConnectionString: "mongodb://127.0.0.1:1", Database: "testing", Collection: "config"}
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	for field, modify := range map[string]func(*MongoDBConfig){
+		"ConnectionString": func(cfg *MongoDBConfig) { cfg.ConnectionString = "" },
+		"Database":         func(cfg *MongoDBConfig) { cfg.Database = "" },
+		"Collection":       func(cfg *MongoDBConfig) { cfg.Collection = "" },
+		"ConnectTimeout":   func(cfg *MongoDBConfig) { cfg.ConnectTimeout = -time.Second },
+		"WriteConcern":     func(cfg *MongoDBConfig) { cfg.WriteConcern = "most" },
+		"ReadConcern":      func(cfg *MongoDBConfig) { cfg.ReadConcern = "eventual" },
+		"ReadPreference":   func(cfg *MongoDBConfig) { cfg.ReadPreference = "secondaryPreferred" },
+		"TLS":              func(cfg *MongoDBConfig) { cfg.TLS = &MongoDBTLSConfig{CAFile: notPEM} },
+		"RetryAttempts":    func(cfg *MongoDBConfig) { cfg.RetryAttempts = -1 },
+		"RetryBackoff":     func(cfg *MongoDBConfig) { cfg.RetryBackoff = time.Microsecond },
+		"CloseTimeout":     func(cfg *MongoDBConfig) { cfg.CloseTimeout = time.Microsecond },
+		"LargeValueThreshold": func(cfg *MongoDBConfig) {
+			cfg.LargeValueThreshold = mongoMaxValueSize + 1
+		},
+	} {
+		cfg := valid
+		modify(&cfg)
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "MongoDBConfig."+field, field)
+		_, err = NewMongoDBFromConfig(cfg)
+		assert.ErrorContains(t, err, "MongoDBConfig."+field, field)
+	}
+
+	cfg := valid
+	cfg.TLS = &MongoDBTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}
+	assert.ErrorContains(t, cfg.Validate(), mongoOptionTLSCAFile)
+
+	// The options of NewDB are validated before connecting, and name the option.
+	for _, o := range []struct{ key, value string }{
+		{mongoOptionConnectTimeout, "-1"},
+		{mongoOptionConnectTimeout, "soon"},
+		{mongoOptionWriteConcern, "-1"},
+		{mongoOptionReadConcern, "eventual"},
+		{mongoOptionMaxPoolSize, "-1"},
+		{mongoOptionTLS, "maybe"},
+		{mongoOptionTLSCertificateKeyFile, notPEM},
+		{mongoOptionLargeValueThreshold, "-1"},
+		{mongoOptionCloseTimeout, "-1"},
+	} {
+		options := valid.Options()
+		options[o.key] = o.value
+		_, err := NewDB(MongoDBBackend, options)
+		assert.ErrorContains(t, err, o.key, o.value)
+	}
+}
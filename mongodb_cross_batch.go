@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrTransactionsUnsupported is returned when writing a CrossBatch to a MongoDB deployment which
+// does not support multi-document transactions, i.e. a standalone server rather than a replica
+// set or sharded cluster.
+type ErrTransactionsUnsupported struct {
+	// Err is the underlying server error.
+	Err error
+}
+
+// Error implements error.
+func (e *ErrTransactionsUnsupported) Error() string {
+	return fmt.Sprintf("mongodb deployment does not support transactions, a replica set is required: %v", e.Err)
+}
+
+// Unwrap returns the underlying server error.
+func (e *ErrTransactionsUnsupported) Unwrap() error {
+	return e.Err
+}
+
+// wrapTransactionError converts the error returned by standalone servers for transactions into an
+// *ErrTransactionsUnsupported. Other errors are returned unchanged.
+func wrapTransactionError(err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoCodeIllegalOperation) &&
+		strings.Contains(err.Error(), "Transaction numbers") {
+		return &ErrTransactionsUnsupported{Err: err}
+	}
+	return err
+}
+
+// mongoProvider is the Provider for MongoDBBackend, which can write to several collections
+// atomically using transactions.
+type mongoProvider struct {
+	*provider
+}
+
+var (
+	_ Provider      = (*mongoProvider)(nil)
+	_ ProviderBatch = (*mongoProvider)(nil)
+)
+
+// NewCrossBatch implements ProviderBatch.
+func (p *mongoProvider) NewCrossBatch() CrossBatch {
+	return &mongoCrossBatch{
+		provider: p.provider,
+		groups:   make(map[string]*mongoWriteGroup),
+		dbs:      make(map[string]*MongoDB),
+	}
+}
+
+// mongoCrossBatch is a CrossBatch writing the bulk writes of all collections in a single
+// transaction. Each collection is written in chunks of mongoBatchChunkSize operations, and the
+// transaction is aborted if any of them fails, e.g. because it exceeds the transaction limits of
+// the server.
+type mongoCrossBatch struct {
+	provider *provider
+	groups   map[string]*mongoWriteGroup
+	dbs      map[string]*MongoDB
+	// names holds the names of the databases in order of first use, so that collections are
+	// always written in the same order.
+	names []string
+	// compressed holds the databases with compressed keys by name. Their prefixes must not change
+	// while the batch is written, see CompressedMongoDB.
+	compressed map[string]*CompressedMongoDB
+	closed     bool
+
+	mtx sync.Mutex
+}
+
+var _ CrossBatch = (*mongoCrossBatch)(nil)
+
+// group returns the write group and database of the named database, opening it if necessary.
+func (b *mongoCrossBatch) group(name string) (*mongoWriteGroup, *MongoDB, error) {
+	if g, ok := b.groups[name]; ok {
+		return g, b.dbs[name], nil
+	}
+	pdb, err := b.provider.DB(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := newMongoWriteGroup()
+	var db *MongoDB
+	switch pdb := pdb.(*providerDB).DB.(type) {
+	case *MongoDB:
+		db = pdb
+	case *CompressedMongoDB:
+		db = pdb.mdb
+		g.keyFilter = pdb.keyFilter
+		if b.compressed == nil {
+			b.compressed = make(map[string]*CompressedMongoDB)
+		}
+		b.compressed[name] = pdb
+	default:
+		return nil, nil, fmt.Errorf("unexpected database of type %T", pdb)
+	}
+	g.codec = db.codec
+	g.trackTimestamps = db.journalColl() != nil
+	b.groups[name] = g
+	b.dbs[name] = db
+	b.names = append(b.names, name)
+	return g, db, nil
+}
+
+// Set implements CrossBatch.
+func (b *mongoCrossBatch) Set(name string, key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+	// Large values are not split into chunks, see MongoDB.SetLargeValueThreshold.
+	if err := checkValueLimit(value, mongoMaxValueSize); err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+	g, db, err := b.group(name)
+	if err != nil {
+		return err
+	}
+	g.add(mongoWriteOp{seq: db.nextSeq(), key: key, value: value})
+	return nil
+}
+
+// Delete implements CrossBatch.
+func (b *mongoCrossBatch) Delete(name string, key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+	g, db, err := b.group(name)
+	if err != nil {
+		return err
+	}
+	g.add(mongoWriteOp{seq: db.nextSeq(), key: key})
+	return nil
+}
+
+// Write implements CrossBatch. It returns an *ErrTransactionsUnsupported if the server does not
+// support transactions.
+func (b *mongoCrossBatch) Write() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+
+	learn := make(map[*CompressedMongoDB][][]byte, len(b.compressed))
+	for name, cdb := range b.compressed {
+		for _, op := range b.groups[name].ops {
+			if !op.isDelete() {
+				learn[cdb] = append(learn[cdb], op.key)
+			}
+		}
+	}
+	if err := b.write(); err != nil {
+		return err
+	}
+	for cdb, keys := range learn {
+		cdb.learn(keys)
+	}
+	b.closed = true
+	return nil
+}
+
+// write writes the batch in a transaction while no prefix of its compressed databases is being
+// registered.
+func (b *mongoCrossBatch) write() error {
+	for _, cdb := range b.compressed {
+		cdb.mtx.RLock()
+		defer cdb.mtx.RUnlock()
+	}
+
+	// The models are built up front, since the transaction may be retried.
+	type pending struct {
+		db                 *MongoDB
+		ops                []mongoWriteOp
+		models, tombstones []mongo.WriteModel
+	}
+	writes := make([]pending, 0, len(b.names))
+	for _, name := range b.names {
+		ops, models, tombstones := b.groups[name].writeModels()
+		if err := b.dbs[name].writableFor(ops); err != nil {
+			return err
+		}
+		writes = append(writes, pending{db: b.dbs[name], ops: ops, models: models, tombstones: tombstones})
+	}
+
+	if len(writes) > 0 {
+		cutoff := primitive.NewObjectID()
+		session, err := b.provider.mongoDatabase.Client().StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(context.Background())
+
+		_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
+			for _, w := range writes {
+				if err := mongoChunkedBulkWrite(ctx, w.db.coll(), w.models); err != nil {
+					return nil, w.db.wrapWriteError(err)
+				}
+				if err := mongoChunkedBulkWrite(ctx, w.db.journalColl(), w.tombstones); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return wrapTransactionError(err)
+		}
+		for _, w := range writes {
+			if err := w.db.deleteStaleChunks(context.Background(), w.ops, cutoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, name := range b.names {
+		b.groups[name].flushed(writes[i].ops)
+	}
+	return nil
+}
+
+// mongoChunkedBulkWrite writes models to collection in unordered bulk writes of at most
+// mongoBatchChunkSize models.
+func mongoChunkedBulkWrite(ctx context.Context, collection *mongo.Collection, models []mongo.WriteModel) error {
+	for len(models) > 0 {
+		chunk := models
+		if len(chunk) > mongoBatchChunkSize {
+			chunk = chunk[:mongoBatchChunkSize]
+		}
+		if _, err := collection.BulkWrite(ctx, chunk, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		models = models[len(chunk):]
+	}
+	return nil
+}
+
+// Close implements CrossBatch.
+func (b *mongoCrossBatch) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.closed = true
+	return nil
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoOptionTrackTimestamps enables TrackTimestamps when set to true.
+const mongoOptionTrackTimestamps = "track_timestamps"
+
+// mongoJournalSuffix is appended to the collection name to name the deletions journal.
+const mongoJournalSuffix = ".deletions"
+
+var errTimestampsNotTracked = errors.New("timestamps are not tracked")
+
+// mongoTrackTimestamps returns whether the track_timestamps option is set.
+func mongoTrackTimestamps(options Options) (bool, error) {
+	s, ok := options[mongoOptionTrackTimestamps]
+	if !ok {
+		return false, nil
+	}
+	track, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", mongoOptionTrackTimestamps, s, err)
+	}
+	return track, nil
+}
+
+// TrackTimestamps makes all subsequent writes record the modification time of each key, and the
+// deletion time of each deleted key in a deletions journal collection. This is required by
+// IncrementalExport. Writes made before timestamps are tracked are only included in full exports.
+func (db *MongoDB) TrackTimestamps() {
+	db.clientMtx.Lock()
+	defer db.clientMtx.Unlock()
+
+	db.journal = mongoJournal(db.collection)
+}
+
+// mongoJournal returns the deletions journal of a collection.
+func mongoJournal(collection *mongo.Collection) *mongo.Collection {
+	return collection.Database().Collection(collection.Name() + mongoJournalSuffix)
+}
+
+// journalDeletes writes tombstone models to the deletions journal, if timestamps are tracked.
+func (db *MongoDB) journalDeletes(ctx context.Context, tombstones []mongo.WriteModel) error {
+	if db.journalColl() == nil || len(tombstones) == 0 {
+		return nil
+	}
+	_, err := db.journalColl().BulkWrite(ctx, tombstones,
+		mongoOptions.BulkWrite().SetOrdered(false))
+	return db.wrapWriteError(err)
+}
+
+// journalRange journals tombstones for all keys matching filter.
+func (db *MongoDB) journalRange(ctx context.Context, filter bson.D) error {
+	opts := mongoOptions.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.coll().Find(ctx, filter, opts)
+	if err != nil {
+		return db.wrapReadErrorContext(ctx, "delete range", err, 0)
+	}
+	defer cursor.Close(context.Background())
+
+	var tombstones []mongo.WriteModel
+	for cursor.Next(ctx) {
+		key, err := MongoDocumentKey(cursor.Current)
+		if err != nil {
+			return err
+		}
+		tombstones = append(tombstones, mongoTombstoneModel(key))
+	}
+	if err := cursor.Err(); err != nil {
+		return db.wrapReadErrorContext(ctx, "delete range", err, 0)
+	}
+	return db.journalDeletes(ctx, tombstones)
+}
+
+// IncrementalExport writes all keys set or deleted at or after since to w, in the format read by
+// ApplyIncremental. A zero since exports every key, which gives a full export to apply
+// incremental exports onto. Timestamps must be tracked, see TrackTimestamps.
+//
+// Deletes are written before sets, so a key deleted and then set again since the checkpoint is
+// restored with its current value. To avoid missing writes which race with the export, the next
+// checkpoint should be taken before the export starts.
+func (db *MongoDB) IncrementalExport(w io.Writer, since time.Time) (ExportStats, error) {
+	if db.journalColl() == nil {
+		return ExportStats{}, errTimestampsNotTracked
+	}
+
+	ew, err := newExportWriter(w)
+	if err != nil {
+		return ExportStats{}, err
+	}
+
+	if !since.IsZero() {
+		_, err = db.coll().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+			Keys: bson.D{{Key: "modifiedAt", Value: 1}},
+		})
+		if err != nil {
+			return ew.stats, err
+		}
+
+		deleted := bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$gte", Value: since}}}}
+		err = db.exportCursor(db.journalColl(), deleted, func(raw bson.Raw) error {
+			key, err := MongoDocumentKey(raw)
+			if err != nil {
+				return err
+			}
+			return ew.delete(key)
+		})
+		if err != nil {
+			return ew.stats, err
+		}
+	}
+
+	modified := bson.D{}
+	if !since.IsZero() {
+		modified = bson.D{{Key: "modifiedAt", Value: bson.D{{Key: "$gte", Value: since}}}}
+	}
+	err = db.exportCursor(db.readColl(), modified, func(raw bson.Raw) error {
+		rec, err := db.decodeRecord(raw)
+		if err != nil {
+			return err
+		}
+		return ew.set(rec.Key, rec.Value)
+	})
+	if err != nil {
+		return ew.stats, err
+	}
+
+	return ew.stats, ew.close()
+}
+
+// exportCursor calls fn for every document in collection matching filter.
+func (db *MongoDB) exportCursor(collection *mongo.Collection, filter bson.D, fn func(bson.Raw) error) error {
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		if err := fn(cursor.Current); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// MongoHexKeyMigration rewrites a collection written before keys were stored as hex-encoded _ids,
+// see MongoKeyID, together with its deletions journal and chunk collection. Its documents cannot be
+// read by this version until it has run, see MongoDocumentKey.
+//
+// Run it with RunMigrations on a database opened without key compression, and while no other
+// process uses the collection. Each collection is copied with its keys re-encoded and its indexes
+// to a temporary collection, which then replaces it with renameCollection. This needs the
+// privileges of renameCollection, and does not work for sharded collections.
+var MongoHexKeyMigration = Migration{ID: mongoHexKeysID, Up: migrateMongoHexKeys}
+
+// mongoHexKeysID is the ID of MongoHexKeyMigration, and of the document recording the collections
+// it rewrote in the migrations collection, so that a rerun after a crash skips them.
+const mongoHexKeysID = "hex-keys"
+
+// mongoHexKeysSuffix is appended to the name of a collection to name its temporary copy.
+const mongoHexKeysSuffix = ".hex-keys"
+
+// mongoHexKeysMarker is the _id of the document marking a rewritten collection until its rewrite
+// is recorded, for the case of a crash in between. No key is stored with such an _id, compressed or
+// not, see CompressedMongoDB.
+var mongoHexKeysMarker = bson.D{{Key: "migration", Value: mongoHexKeysID}}
+
+// migrateMongoHexKeys applies MongoHexKeyMigration.
+func migrateMongoHexKeys(ctx context.Context, db *MongoDB) error {
+	collection := db.coll()
+	meta := collection.Database().Collection(collection.Name() + mongoMigrationsSuffix)
+	var progress struct {
+		Done []string `bson:"done"`
+	}
+	err := meta.FindOne(ctx, bson.D{{Key: "_id", Value: mongoHexKeysID}}).Decode(&progress)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	rewrites := []struct {
+		collection *mongo.Collection
+		rewrite    func(raw bson.Raw) (bson.Raw, error)
+	}{
+		{collection, mongoHexKeyDocument},
+		{mongoJournal(collection), mongoHexKeyDocument},
+		{mongoChunks(collection), mongoHexKeyChunk},
+	}
+	for _, r := range rewrites {
+		name := r.collection.Name()
+		if !slices.Contains(progress.Done, name) {
+			if err := rewriteMongoCollection(ctx, r.collection, r.rewrite); err != nil {
+				return fmt.Errorf("rewriting %s: %w", name, err)
+			}
+			_, err := meta.UpdateOne(ctx,
+				bson.D{{Key: "_id", Value: mongoHexKeysID}},
+				bson.D{{Key: "$addToSet", Value: bson.D{{Key: "done", Value: name}}}},
+				mongoOptions.Update().SetUpsert(true))
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := r.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: mongoHexKeysMarker}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteMongoCollection replaces the documents of a collection with those returned by rewrite. The
+// documents and indexes are copied to a temporary collection together with mongoHexKeysMarker,
+// which then replaces the collection. A collection which does not exist, or holds the marker since
+// it was replaced already, is left as is.
+func rewriteMongoCollection(
+	ctx context.Context, collection *mongo.Collection, rewrite func(raw bson.Raw) (bson.Raw, error),
+) error {
+	database := collection.Database()
+	names, err := database.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collection.Name()}})
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	n, err := collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: mongoHexKeysMarker}})
+	if err != nil || n > 0 {
+		return err
+	}
+
+	tmp := database.Collection(collection.Name() + mongoHexKeysSuffix)
+	if err := tmp.Drop(ctx); err != nil {
+		return err
+	}
+	if err := copyMongoIndexes(ctx, collection, tmp); err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	docs := make([]interface{}, 0, mongoBatchChunkSize)
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		_, err := tmp.InsertMany(ctx, docs, mongoOptions.InsertMany().SetOrdered(false))
+		docs = docs[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		doc, err := rewrite(cursor.Current)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		if len(docs) == mongoBatchChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if _, err := tmp.InsertOne(ctx, bson.D{{Key: "_id", Value: mongoHexKeysMarker}}); err != nil {
+		return err
+	}
+
+	return database.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: database.Name() + "." + tmp.Name()},
+		{Key: "to", Value: database.Name() + "." + collection.Name()},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+// copyMongoIndexes creates the indexes of a collection, other than that of _id, on another one.
+func copyMongoIndexes(ctx context.Context, from, to *mongo.Collection) error {
+	cursor, err := from.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var specs []bson.D
+	if err := cursor.All(ctx, &specs); err != nil {
+		return err
+	}
+	indexes := bson.A{}
+	for _, spec := range specs {
+		var name interface{}
+		index := bson.D{}
+		for _, e := range spec {
+			switch e.Key {
+			case "name":
+				name = e.Value
+			case "ns":
+				// Older servers list the namespace of the index, which is not an index option.
+				continue
+			}
+			index = append(index, e)
+		}
+		if name != "_id_" {
+			indexes = append(indexes, index)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	return to.Database().RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: to.Name()},
+		{Key: "indexes", Value: indexes},
+	}).Err()
+}
+
+// mongoHexKeyDocument returns a document of a collection or its deletions journal with its key
+// re-encoded, see MongoKeyID. Keys were stored as strings holding their bytes, or as compressed
+// _ids holding the rest of the key as a string in a field named by the hex ID of its prefix, see
+// CompressedMongoDB. Other _ids, such as mongoHexKeysMarker, are kept.
+func mongoHexKeyDocument(raw bson.Raw) (bson.Raw, error) {
+	id, err := raw.LookupErr("_id")
+	if err != nil {
+		return nil, err
+	}
+	switch id.Type {
+	case bsontype.String:
+		return mongoReplaceID(raw, func(dst []byte) []byte {
+			return bsoncore.AppendStringElement(dst, "_id", MongoKeyID([]byte(id.StringValue())))
+		})
+	case bsontype.EmbeddedDocument:
+		elems, err := id.Document().Elements()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) != 1 || elems[0].Value().Type != bsontype.String {
+			break
+		}
+		if _, err := strconv.ParseUint(elems[0].Key(), 16, 32); err == nil {
+			return mongoReplaceID(raw, func(dst []byte) []byte {
+				idx, dst := bsoncore.AppendDocumentElementStart(dst, "_id")
+				dst = bsoncore.AppendStringElement(dst, elems[0].Key(), MongoKeyID([]byte(elems[0].Value().StringValue())))
+				dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+				return dst
+			})
+		}
+	}
+	return slices.Clone(raw), nil
+}
+
+// mongoHexKeyChunk returns a document of a chunk collection with the key it belongs to re-encoded,
+// see mongoLargeValue.
+func mongoHexKeyChunk(raw bson.Raw) (bson.Raw, error) {
+	key, ok := raw.Lookup("key").StringValueOK()
+	if !ok {
+		return slices.Clone(raw), nil
+	}
+	return mongoReplaceElement(raw, "key", func(dst []byte) []byte {
+		return bsoncore.AppendStringElement(dst, "key", MongoKeyID([]byte(key)))
+	})
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoHexKeyDocument(t *testing.T) {
+	// Keys are re-encoded in place, keeping the other fields and their order.
+	raw := marshalDocument(t, bson.D{
+		{Key: "value", Value: []byte("value")},
+		{Key: "_id", Value: "cafe\x00key"},
+		{Key: "modifiedAt", Value: int64(1)},
+	})
+	rewritten, err := mongoHexKeyDocument(raw)
+	require.NoError(t, err)
+	assert.Equal(t, marshalDocument(t, bson.D{
+		{Key: "value", Value: []byte("value")},
+		{Key: "_id", Value: MongoKeyID(bz("cafe\x00key"))},
+		{Key: "modifiedAt", Value: int64(1)},
+	}), rewritten)
+	key, err := MongoDocumentKey(rewritten)
+	require.NoError(t, err)
+	assert.Equal(t, bz("cafe\x00key"), key)
+
+	// Compressed keys keep their prefix field, and have the rest of the key re-encoded.
+	raw = marshalDocument(t, bson.D{{Key: "_id", Value: bson.D{{Key: "0001", Value: ":info"}}}})
+	rewritten, err = mongoHexKeyDocument(raw)
+	require.NoError(t, err)
+	assert.Equal(t, marshalDocument(t, bson.D{{Key: "_id", Value: bson.D{{Key: "0001", Value: MongoKeyID(bz(":info"))}}}}),
+		rewritten)
+
+	// Other _ids, such as the marker of rewritten collections, are kept.
+	for _, id := range []interface{}{int32(1), mongoHexKeysMarker, bson.D{{Key: "a", Value: "b"}, {Key: "c", Value: "d"}}} {
+		raw := marshalDocument(t, bson.D{{Key: "_id", Value: id}})
+		rewritten, err := mongoHexKeyDocument(raw)
+		require.NoError(t, err)
+		assert.Equal(t, raw, rewritten)
+	}
+	_, err = mongoHexKeyDocument(marshalDocument(t, bson.D{{Key: "value", Value: []byte("value")}}))
+	assert.Error(t, err)
+}
+
+func TestMongoHexKeyChunk(t *testing.T) {
+	gen := primitive.NewObjectID()
+	raw := marshalDocument(t, bson.D{
+		{Key: "_id", Value: mongoChunkID(gen, 0)},
+		{Key: "key", Value: "big"},
+		{Key: "gen", Value: gen},
+		{Key: "data", Value: []byte("data")},
+	})
+	rewritten, err := mongoHexKeyChunk(raw)
+	require.NoError(t, err)
+	assert.Equal(t, marshalDocument(t, bson.D{
+		{Key: "_id", Value: mongoChunkID(gen, 0)},
+		{Key: "key", Value: MongoKeyID(bz("big"))},
+		{Key: "gen", Value: gen},
+		{Key: "data", Value: []byte("data")},
+	}), rewritten)
+
+	raw = marshalDocument(t, bson.D{{Key: "_id", Value: mongoHexKeysMarker}})
+	rewritten, err = mongoHexKeyChunk(raw)
+	require.NoError(t, err)
+	assert.Equal(t, raw, rewritten)
+}
+
+func TestMongoKeyFromID(t *testing.T) {
+	key, err := mongoKeyFromID("00ff61")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff, 'a'}, key)
+	key, err = mongoKeyFromID("")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	// Upper case digits would not match the _ids of MongoKeyID, so they are rejected too.
+	for _, id := range []string{"a", "abc", "key", "00FF", "0x00"} {
+		_, err := mongoKeyFromID(id)
+		assert.ErrorContains(t, err, "MongoHexKeyMigration", id)
+	}
+}
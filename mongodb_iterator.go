@@ -2,28 +2,110 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// mongoOptionIteratorPrefetch is the number of records iterators fetch and decode ahead, see
+// MongoDB.SetIteratorPrefetch. Absent or zero means mongoDefaultIteratorPrefetch.
+const mongoOptionIteratorPrefetch = "iterator_prefetch"
+
+// mongoDefaultIteratorPrefetch is the default number of records iterators fetch and decode ahead.
+const mongoDefaultIteratorPrefetch = 1000
+
+// mongoIteratorPrefetch returns the prefetch window configured by the iterator_prefetch option.
+func mongoIteratorPrefetch(options Options) (int, error) {
+	s, ok := options[mongoOptionIteratorPrefetch]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", mongoOptionIteratorPrefetch, s, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s %d: must not be negative", mongoOptionIteratorPrefetch, n)
+	}
+	return n, nil
+}
+
+// SetIteratorPrefetch sets the number of records which iterators fetch from the server in one
+// batch and decode ahead, trading memory for fewer round trips. Zero restores the default of 1000.
+// It applies to subsequent iterators, and can be overridden per iterator, see IteratorOptions.
+func (db *MongoDB) SetIteratorPrefetch(n int) {
+	db.iteratorPrefetch.Store(int64(max(n, 0)))
+}
+
+// prefetch returns the prefetch window of iterators, see SetIteratorPrefetch.
+func (db *MongoDB) prefetch() int {
+	if n := db.iteratorPrefetch.Load(); n > 0 {
+		return int(n)
+	}
+	return mongoDefaultIteratorPrefetch
+}
+
 type mongoDBIterator struct {
+	iteratorGuard
+
 	db     *MongoDB
 	cursor *mongo.Cursor
+	// ctx bounds the reads of the cursor, see ContextDB.IteratorContext.
+	ctx context.Context
 
 	start, end []byte
+	isReverse  bool
 
-	lastErr       error
-	current, next *record
+	lastErr error
+
+	// buf holds the records decoded ahead, of which buf[pos] is the current one. The iterator is
+	// valid while pos is within buf. Once the records of buf are consumed, up to prefetch records
+	// are decoded from the cursor, unless drained is set. pending is the error which stopped the
+	// last fill, which becomes lastErr once the records decoded before it are consumed.
+	buf      []*mongoIteratorRecord
+	pos      int
+	prefetch int
+	drained  bool
+	pending  error
+
+	// maxTime is the maximum query time of the cursor, see ErrQueryTimeout.
+	maxTime time.Duration
+
+	// rangeFilter returns the filter of the documents of the keys in a domain, and decode decodes
+	// the documents. They are mongoKeyRangeFilter and MongoDB.decodeRecord, unless the keys are
+	// stored differently, see CompressedMongoDB.
+	rangeFilter func(start, end []byte) (bson.D, error)
+	decode      func(raw bson.Raw) (*record, error)
 
 	mu sync.Mutex
 }
 
-var _ Iterator = (*mongoDBIterator)(nil)
+var (
+	_ Iterator     = (*mongoDBIterator)(nil)
+	_ SeekIterator = (*mongoDBIterator)(nil)
+)
 
-func newMongoDBIterator(db *MongoDB, start, end []byte, isReverse bool) (*mongoDBIterator, error) {
+// mongoIteratorRecord is a record decoded ahead by an iterator. A large value is only loaded from
+// its chunks once the iterator reaches its record and the value is read, so that the prefetch
+// window holds no more than one large value, see mongoDBIterator.Value.
+type mongoIteratorRecord struct {
+	record
+	// large is the marker of the value of the record if it is not loaded yet, see mongoLargeValue,
+	// and id the _id of its document, by which it is read again if the value changed since.
+	large *mongoLargeValue
+	id    bson.RawValue
+}
+
+// mongoKeyRangeFilter returns a filter matching the keys in the domain [start, end).
+func mongoKeyRangeFilter(start, end []byte) (bson.D, error) {
 	var filter bson.D
 	if start == nil && end == nil {
 		filter = bson.D{}
@@ -34,7 +116,7 @@ func newMongoDBIterator(db *MongoDB, start, end []byte, isReverse bool) (*mongoD
 				return nil, errKeyEmpty
 			}
 
-			filterArray = append(filterArray, bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: string(start)}}}})
+			filterArray = append(filterArray, bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: MongoKeyID(start)}}}})
 		}
 
 		if end != nil {
@@ -42,49 +124,116 @@ func newMongoDBIterator(db *MongoDB, start, end []byte, isReverse bool) (*mongoD
 				return nil, errKeyEmpty
 			}
 
-			filterArray = append(filterArray, bson.D{{Key: "_id", Value: bson.D{{Key: "$lt", Value: string(end)}}}})
+			filterArray = append(filterArray, bson.D{{Key: "_id", Value: bson.D{{Key: "$lt", Value: MongoKeyID(end)}}}})
 		}
 
 		filter = bson.D{{Key: "$and", Value: filterArray}}
 	}
 
+	return filter, nil
+}
+
+// newMongoDBIterator returns an iterator whose reads are bounded by ctx.
+func newMongoDBIterator(
+	ctx context.Context, db *MongoDB, start, end []byte, isReverse bool, maxTime time.Duration, prefetch int,
+) (*mongoDBIterator, error) {
+	return newMongoDBIteratorWith(ctx, db, start, end, isReverse, maxTime, prefetch, mongoKeyRangeFilter, db.decodeRecord)
+}
+
+// newMongoDBIteratorWith is like newMongoDBIterator, with the documents selected by rangeFilter and
+// decoded by decode.
+func newMongoDBIteratorWith(
+	ctx context.Context, db *MongoDB, start, end []byte, isReverse bool, maxTime time.Duration, prefetch int,
+	rangeFilter func(start, end []byte) (bson.D, error), decode func(raw bson.Raw) (*record, error),
+) (*mongoDBIterator, error) {
+	filter, err := rangeFilter(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRange(start, end, db.lenientRanges.Load()); err != nil {
+		return nil, err
+	}
+
 	var opts *options.FindOptions
 	if isReverse {
 		opts = options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
 	} else {
 		opts = options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
 	}
-
-	cursor, err := db.collection.Find(context.Background(), filter, opts)
-	if err != nil {
-		return nil, err
+	if maxTime > 0 {
+		opts.SetMaxTime(maxTime)
 	}
+	opts.SetBatchSize(int32(min(prefetch, math.MaxInt32)))
 
-	it := &mongoDBIterator{
-		db:     db,
-		cursor: cursor,
-		start:  start,
-		end:    end,
+	if db.debugEnabled() {
+		db.debugf("mongodb: iterating %s with filter %v, reverse %t", db.coll().Name(), filter, isReverse)
 	}
-
-	// Load current and next records
-	if !cursor.Next(context.Background()) {
-		return it, nil
+	readCtx, cancel := db.readContext(ctx, maxTime)
+	// Expired documents which the server has not deleted yet are skipped, see SetWithTTL.
+	cursor, err := db.readColl().Find(readCtx, mongoUnexpiredFilter(filter), opts)
+	cancel()
+	if err != nil {
+		return nil, db.wrapReadErrorContext(ctx, "iterate", err, maxTime)
 	}
 
-	if err := it.cursor.Decode(&it.current); err != nil {
-		return nil, err
+	it := &mongoDBIterator{
+		db:          db,
+		ctx:         ctx,
+		cursor:      cursor,
+		start:       start,
+		end:         end,
+		isReverse:   isReverse,
+		maxTime:     maxTime,
+		prefetch:    prefetch,
+		rangeFilter: rangeFilter,
+		decode:      decode,
 	}
 
-	if !cursor.Next(context.Background()) {
-		return it, nil
+	// An error before the first record fails the creation of the iterator.
+	it.fill()
+	if len(it.buf) == 0 && it.pending != nil {
+		cursor.Close(context.Background())
+		return nil, it.pending
 	}
+	return it, nil
+}
 
-	if err := it.cursor.Decode(&it.next); err != nil {
-		return nil, err
+// fill replaces the consumed records of buf with up to prefetch records decoded from the cursor.
+// Each fill is bounded like a single read, see MongoDB.cursorContext. A decode or cursor error
+// stops the fill, and is kept as pending.
+func (it *mongoDBIterator) fill() {
+	ctx, cancel := it.db.cursorContext(it.ctx, it.maxTime)
+	defer cancel()
+	it.buf, it.pos = it.buf[:0], 0
+	for len(it.buf) < it.prefetch {
+		if !it.cursor.Next(ctx) {
+			it.drained = true
+			it.pending = it.db.wrapReadErrorContext(it.ctx, "iterate", it.cursor.Err(), it.maxTime)
+			return
+		}
+		record, err := it.decode(it.cursor.Current)
+		rec := &mongoIteratorRecord{}
+		if err == nil {
+			rec.record = *record
+			rec.large, err = mongoLookupLargeValue(it.cursor.Current)
+		}
+		if err != nil {
+			it.drained = true
+			it.pending = err
+			return
+		}
+		if rec.large != nil {
+			// The cursor reuses the memory of Current for the next batch.
+			id := it.cursor.Current.Lookup("_id")
+			rec.id = bson.RawValue{Type: id.Type, Value: slices.Clone(id.Value)}
+		}
+		it.buf = append(it.buf, rec)
 	}
+}
 
-	return it, nil
+// valid returns whether the iterator is positioned at a record.
+func (it *mongoDBIterator) valid() bool {
+	return it.pos < len(it.buf)
 }
 
 // Domain implements Iterator.
@@ -99,61 +248,127 @@ func (it *mongoDBIterator) Valid() bool {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
-	return it.current != nil
+	return it.valid()
 }
 
 func (it *mongoDBIterator) Next() {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
-	if it.current == nil {
-		panic("invalid iterator: current is nil - call Valid() first")
-	}
-
-	it.current = it.next
-	it.next = nil
-
-	// Load next record
-	if !it.cursor.Next(context.Background()) {
+	if !it.guard(it.valid()) {
 		return
 	}
 
-	var record record
-	if err := it.cursor.Decode(&record); err != nil {
-		it.lastErr = err
+	it.buf[it.pos] = nil
+	it.pos++
+	if it.valid() {
 		return
 	}
+	if !it.drained {
+		it.fill()
+	}
+	if !it.valid() {
+		it.lastErr, it.pending = it.pending, nil
+	}
+}
 
-	it.next = &record
+// Seek implements SeekIterator by replacing the cursor with one starting at key, so that the
+// skipped documents are not transferred.
+func (it *mongoDBIterator) Seek(key []byte) bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if !it.valid() {
+		return false
+	}
+	it.cursor.Close(context.Background())
+	start, end, ok := seekDomain(it.start, it.end, key, it.isReverse)
+	if !ok {
+		it.buf, it.pos = nil, 0
+		return false
+	}
+	seeked, err := newMongoDBIteratorWith(
+		it.ctx, it.db, start, end, it.isReverse, it.maxTime, it.prefetch, it.rangeFilter, it.decode)
+	if err != nil {
+		it.buf, it.pos, it.lastErr = nil, 0, err
+		return false
+	}
+	it.cursor = seeked.cursor
+	it.buf, it.pos, it.drained, it.pending, it.lastErr = seeked.buf, seeked.pos, seeked.drained, seeked.pending, nil
+	return it.valid()
 }
 
 func (it *mongoDBIterator) Key() (key []byte) {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
-	if it.current == nil {
-		panic("invalid iterator: current is nil - call Next() first")
+	if !it.guard(it.valid()) {
+		return nil
 	}
 
-	return it.current.Key
+	return it.buf[it.pos].Key
 }
 
+// Value implements Iterator. A large value is loaded from its chunks when it is first read, or
+// read again from its document if its key was overwritten since the iterator found it. If the
+// value cannot be loaded, or the key was deleted since, Value returns nil and the error is
+// returned by Error.
 func (it *mongoDBIterator) Value() (value []byte) {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
-	if it.current == nil {
-		panic("invalid iterator: current is nil - call Next() first")
+	if !it.guard(it.valid()) {
+		return nil
 	}
 
-	return it.current.Value
+	rec := it.buf[it.pos]
+	if rec.large != nil {
+		value, err := it.loadLargeValue(rec)
+		if err != nil {
+			it.lastErr = err
+			return nil
+		}
+		rec.Value, rec.large = value, nil
+	}
+	return rec.Value
+}
+
+// loadLargeValue loads the large value of rec from its chunks. If they changed, the document of
+// rec is read again, up to mongoLargeValueReadAttempts times in all.
+func (it *mongoDBIterator) loadLargeValue(rec *mongoIteratorRecord) ([]byte, error) {
+	ctx, cancel := it.db.cursorContext(it.ctx, it.maxTime)
+	defer cancel()
+	value, err := it.db.loadLargeValue(ctx, rec.Key, rec.large)
+	for attempt := 2; attempt <= mongoLargeValueReadAttempts; attempt++ {
+		if !errors.Is(err, errMongoLargeValueChanged) {
+			break
+		}
+		filter := mongoUnexpiredFilter(bson.D{{Key: "_id", Value: rec.id}})
+		var raw bson.Raw
+		raw, err = it.db.readColl().FindOne(ctx, filter).Raw()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: key %X was deleted", errMongoLargeValueChanged, rec.Key)
+		}
+		if err != nil {
+			return nil, it.db.wrapReadErrorContext(ctx, "iterate", err, it.maxTime)
+		}
+		var record *record
+		if record, err = it.decode(raw); err != nil {
+			return nil, err
+		}
+		value, err = it.db.resolveLargeValue(ctx, rec.Key, raw, record.Value)
+	}
+	return value, err
 }
 
 func (it *mongoDBIterator) Error() error {
 	it.mu.Lock()
 	defer it.mu.Unlock()
 
-	return it.lastErr
+	if it.lastErr != nil {
+		return it.lastErr
+	}
+	return it.misuseError()
 }
 
 func (it *mongoDBIterator) Close() error {
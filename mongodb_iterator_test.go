@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/cometbft/cometbft-db/mongotest"
+)
+
+// newDocumentIterator returns an iterator over a cursor of the given documents, filled like by
+// newMongoDBIteratorWith.
+func newDocumentIterator(t *testing.T, docs []interface{}, prefetch int) *mongoDBIterator {
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	require.NoError(t, err)
+	db := NewMongoDB(&mongo.Collection{})
+	it := &mongoDBIterator{
+		db:       db,
+		ctx:      context.Background(),
+		cursor:   cursor,
+		prefetch: prefetch,
+		decode:   db.decodeRecord,
+	}
+	it.fill()
+	return it
+}
+
+func TestMongoDBIteratorPrefetch(t *testing.T) {
+	var docs []interface{}
+	for i := 0; i < 10; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: MongoKeyID([]byte(fmt.Sprintf("key%d", i)))}, {Key: "value", Value: []byte{byte(i)}}})
+	}
+	for _, prefetch := range []int{1, 3, 10, 1000} {
+		it := newDocumentIterator(t, docs, prefetch)
+		assert.LessOrEqual(t, len(it.buf), prefetch)
+		var keys []string
+		for ; it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+			assert.Equal(t, []byte{byte(len(keys) - 1)}, it.Value())
+		}
+		assert.NoError(t, it.Error())
+		assert.Len(t, keys, 10, "prefetch %d", prefetch)
+		assert.Equal(t, "key9", keys[9])
+		require.NoError(t, it.Close())
+	}
+}
+
+func TestMongoDBIteratorDecodeError(t *testing.T) {
+	docs := []interface{}{
+		bson.D{{Key: "_id", Value: MongoKeyID([]byte("key0"))}, {Key: "value", Value: []byte("0")}},
+		bson.D{{Key: "_id", Value: MongoKeyID([]byte("key1"))}, {Key: "value", Value: []byte("1")}},
+		bson.D{{Key: "_id", Value: int32(2)}, {Key: "value", Value: []byte("2")}},
+		bson.D{{Key: "_id", Value: MongoKeyID([]byte("key3"))}, {Key: "value", Value: []byte("3")}},
+	}
+	for _, prefetch := range []int{1, 2, 1000} {
+		// The records before the failing document are served, and the error surfaces when the
+		// iterator reaches it.
+		it := newDocumentIterator(t, docs, prefetch)
+		require.True(t, it.Valid())
+		assert.Equal(t, []byte("key0"), it.Key())
+		it.Next()
+		require.True(t, it.Valid())
+		assert.Equal(t, []byte("key1"), it.Key())
+		assert.NoError(t, it.Error())
+		it.Next()
+		assert.False(t, it.Valid())
+		assert.ErrorContains(t, it.Error(), "_id", "prefetch %d", prefetch)
+		require.NoError(t, it.Close())
+	}
+}
+
+func TestMongoDBIteratorLargeValueLazy(t *testing.T) {
+	large := newMongoLargeValue(100, 10)
+	docs := []interface{}{
+		bson.D{{Key: "_id", Value: MongoKeyID([]byte("key0"))}, {Key: "value", Value: []byte{}}, {Key: mongoLargeValueField, Value: large}},
+		bson.D{{Key: "_id", Value: MongoKeyID([]byte("key1"))}, {Key: "value", Value: []byte("1")}},
+	}
+	// The iterator has no collection to read chunks from, so the large value must not be loaded
+	// unless it is read.
+	it := newDocumentIterator(t, docs, 1000)
+	require.True(t, it.Valid())
+	assert.Equal(t, []byte("key0"), it.Key())
+	assert.Equal(t, large, it.buf[it.pos].large)
+	it.Next()
+	require.True(t, it.Valid())
+	assert.Equal(t, []byte("1"), it.Value())
+	it.Next()
+	assert.False(t, it.Valid())
+	assert.NoError(t, it.Error())
+	require.NoError(t, it.Close())
+}
+
+func TestMongoIteratorPrefetchOption(t *testing.T) {
+	n, err := mongoIteratorPrefetch(Options{})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	n, err = mongoIteratorPrefetch(Options{mongoOptionIteratorPrefetch: "50"})
+	require.NoError(t, err)
+	assert.Equal(t, 50, n)
+	for _, value := range []string{"-1", "many"} {
+		_, err = mongoIteratorPrefetch(Options{mongoOptionIteratorPrefetch: value})
+		assert.Error(t, err, value)
+	}
+
+	db := NewMongoDB(connectUnreachable(t).Database("testing").Collection("prefetch"))
+	defer db.Close()
+	assert.Equal(t, mongoDefaultIteratorPrefetch, db.prefetch())
+	db.SetIteratorPrefetch(10)
+	assert.Equal(t, 10, db.prefetch())
+	require.NoError(t, db.Reconfigure(Options{mongoOptionIteratorPrefetch: "20"}))
+	assert.Equal(t, 20, db.prefetch())
+	assert.Error(t, db.Reconfigure(Options{mongoOptionIteratorPrefetch: "-1"}))
+	db.SetIteratorPrefetch(0)
+	assert.Equal(t, mongoDefaultIteratorPrefetch, db.prefetch())
+}
+
+// benchmarkMongoDBIterator iterates over 100k keys with the given prefetch window. A window of 1
+// decodes one record at a time, with a cursor batch per record.
+func benchmarkMongoDBIterator(b *testing.B, prefetch int) {
+	_, client, err := setupMongoDB(mongotest.Standalone)
+	if err != nil {
+		b.Skipf("MongoDB is not available: %v", err)
+	}
+	defer client.Disconnect(context.Background()) //nolint:errcheck
+	db := NewMongoDB(client.Database("testing").Collection("iterator_benchmark"))
+	db.sharedClient = true
+
+	const keys = 100000
+	batch := db.NewBatch()
+	for i := 0; i < keys; i++ {
+		require.NoError(b, batch.Set(int642Bytes(int64(i)), []byte("value")))
+	}
+	require.NoError(b, batch.Write())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		itr, err := db.IteratorWithOptions(nil, nil, IteratorOptions{Prefetch: prefetch})
+		require.NoError(b, err)
+		count := 0
+		for ; itr.Valid(); itr.Next() {
+			count++
+		}
+		require.NoError(b, itr.Error())
+		require.NoError(b, itr.Close())
+		require.Equal(b, keys, count)
+	}
+}
+
+func BenchmarkMongoDBIterator100k(b *testing.B) {
+	benchmarkMongoDBIterator(b, 1)
+}
+
+func BenchmarkMongoDBIteratorPrefetch100k(b *testing.B) {
+	benchmarkMongoDBIterator(b, mongoDefaultIteratorPrefetch)
+}
@@ -0,0 +1,873 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+const (
+	// mongoOptionKeyPrefixes is a JSON array of key prefixes to compress, e.g.
+	// ["blockstore:block_part:"], see CompressedMongoDB.
+	mongoOptionKeyPrefixes = "key_prefixes"
+	// mongoOptionLearnKeyPrefixes is the number of written keys from which further key prefixes to
+	// compress are learned, see KeyCompressionConfig.LearnWrites.
+	mongoOptionLearnKeyPrefixes = "learn_key_prefixes"
+)
+
+const (
+	// mongoKeyPrefixesSuffix is appended to the collection name to name the collection of the
+	// registered key prefixes.
+	mongoKeyPrefixesSuffix = ".key_prefixes"
+	// mongoMaxKeyPrefixID is the largest prefix ID. IDs are stored as 4 hexadecimal digits, and
+	// the upper bound of the keys of a prefix uses the next ID.
+	mongoMaxKeyPrefixID = 0xfffe
+	// mongoCompressedIDOverhead is the number of bytes a compressed _id takes in addition to the
+	// suffix, compared to the _id of the key without its prefix: the embedded document
+	// header and terminator, and the type and name of its single field.
+	mongoCompressedIDOverhead = 11
+	// mongoMinLearnedPrefixSize is the minimum size of learned prefixes, so that compression saves
+	// at least a few bytes per key.
+	mongoMinLearnedPrefixSize = 16
+	// mongoMinLearnedPrefixKeys is the minimum number of sampled keys with a learned prefix.
+	mongoMinLearnedPrefixKeys = 2
+)
+
+// KeyCompressionConfig configures a CompressedMongoDB.
+type KeyCompressionConfig struct {
+	// Prefixes are registered when the database is opened, unless they are already registered.
+	Prefixes [][]byte
+	// LearnWrites is the number of keys written after the database is opened from which further
+	// prefixes are learned, see CompressedMongoDB. Zero disables learning.
+	LearnWrites int
+}
+
+// CompressedMongoDB stores the keys of a MongoDB with registered prefixes as a small prefix ID and
+// the rest of the key, to save index and storage space when many keys share long prefixes, e.g.
+// "blockstore:block_part:". Keys without a registered prefix are stored as usual.
+//
+// The registered prefixes are stored in a separate collection named after the collection with the
+// suffix ".key_prefixes", which maps each prefix to its ID. The document of a key with a registered
+// prefix has an embedded document as _id, with the ID as 4 hexadecimal digits as its only field
+// name and the rest of the key, hex-encoded like uncompressed keys (see MongoKeyID), as string
+// value, e.g. {_id: {"0001": "34323a30"}}. Such an _id takes 11 bytes more than the _id of the rest
+// of the key, and saves 2 bytes per byte of the prefix, so only prefixes longer than 5 bytes save
+// space.
+// Registered prefixes cannot be prefixes of one another.
+//
+// Since documents with the same field name are ordered by their value, the keys of a prefix keep
+// their order, and ranges are translated to a range query per registered prefix intersecting the
+// range, and a range query over the uncompressed keys. The results of these queries are merged in
+// key order.
+//
+// Prefixes are registered when the database is opened, by RegisterKeyPrefix, or learned from the
+// first keys written: once KeyCompressionConfig.LearnWrites keys were written, the shortest
+// prefixes of at least 16 bytes ending with ':' or '/' shared by several of these keys are
+// registered. Registering a prefix moves the existing keys with the prefix to their compressed
+// form, blocking other operations until it is done. Iterators opened before may miss or repeat
+// moved keys.
+//
+// All writers of the collection must use CompressedMongoDB, and prefixes should only be registered
+// by one of them. Other features of MongoDB which query keys directly, such as range deletes,
+// exports and summaries, are not available on the compressed database.
+type CompressedMongoDB struct {
+	DB
+
+	mdb *MongoDB
+	// prefixes is the collection of the registered prefixes.
+	prefixes *mongo.Collection
+	// dict holds the registered prefixes. It is replaced while mtx is held for writing, which
+	// reads and writes hold for reading.
+	dict atomic.Pointer[mongoKeyDict]
+	mtx  sync.RWMutex
+
+	// learnMtx guards learnKeys, the keys sampled for learning, which is nil once learning is
+	// done or disabled.
+	learnMtx    sync.Mutex
+	learnKeys   [][]byte
+	learnWrites int
+}
+
+var _ DB = (*CompressedMongoDB)(nil)
+
+// NewCompressedMongoDB returns a database compressing the keys of db with the prefixes registered
+// in the collection of db and configured by cfg. Keys with a registered prefix which are still
+// uncompressed, e.g. because a registration was interrupted, are compressed.
+func NewCompressedMongoDB(db *MongoDB, cfg KeyCompressionConfig) (*CompressedMongoDB, error) {
+	if cfg.LearnWrites < 0 {
+		return nil, fmt.Errorf("invalid number of writes to learn key prefixes from %d", cfg.LearnWrites)
+	}
+	collection := db.coll()
+	cdb := &CompressedMongoDB{
+		DB:          db,
+		mdb:         db,
+		prefixes:    collection.Database().Collection(collection.Name() + mongoKeyPrefixesSuffix),
+		learnWrites: cfg.LearnWrites,
+	}
+	if cfg.LearnWrites > 0 {
+		cdb.learnKeys = make([][]byte, 0, cfg.LearnWrites)
+	}
+
+	dict, err := cdb.loadKeyPrefixes()
+	if err != nil {
+		return nil, fmt.Errorf("loading key prefixes: %w", err)
+	}
+	cdb.dict.Store(dict)
+	for _, p := range dict.prefixes {
+		if err := cdb.compressExisting(p); err != nil {
+			return nil, err
+		}
+	}
+	for _, prefix := range cfg.Prefixes {
+		if err := cdb.RegisterKeyPrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+	return cdb, nil
+}
+
+// mongoKeyCompressionConfig returns the configuration set by the key_prefixes and
+// learn_key_prefixes options, and whether any of them is set.
+func mongoKeyCompressionConfig(options Options) (KeyCompressionConfig, bool, error) {
+	var cfg KeyCompressionConfig
+	s, hasPrefixes := options[mongoOptionKeyPrefixes]
+	if hasPrefixes {
+		var prefixes []string
+		if err := json.Unmarshal([]byte(s), &prefixes); err != nil {
+			return cfg, false, fmt.Errorf("invalid %s %q: %w", mongoOptionKeyPrefixes, s, err)
+		}
+		dict := &mongoKeyDict{}
+		for i, prefix := range prefixes {
+			next, err := dict.with([]byte(prefix), i)
+			if err != nil {
+				return cfg, false, fmt.Errorf("invalid %s %q: %w", mongoOptionKeyPrefixes, s, err)
+			}
+			dict = next
+			cfg.Prefixes = append(cfg.Prefixes, []byte(prefix))
+		}
+	}
+	n, hasLearn := options[mongoOptionLearnKeyPrefixes]
+	if hasLearn {
+		writes, err := strconv.Atoi(n)
+		if err != nil || writes < 0 {
+			return cfg, false, fmt.Errorf("invalid %s %q", mongoOptionLearnKeyPrefixes, n)
+		}
+		cfg.LearnWrites = writes
+	}
+	return cfg, hasPrefixes || hasLearn, nil
+}
+
+// KeyPrefixes returns the registered prefixes, in order.
+func (cdb *CompressedMongoDB) KeyPrefixes() [][]byte {
+	dict := cdb.dict.Load()
+	prefixes := make([][]byte, len(dict.prefixes))
+	for i, p := range dict.prefixes {
+		prefixes[i] = cp(p.prefix)
+	}
+	return prefixes
+}
+
+// loadKeyPrefixes reads the registered prefixes.
+func (cdb *CompressedMongoDB) loadKeyPrefixes() (*mongoKeyDict, error) {
+	cursor, err := cdb.prefixes.Find(context.Background(), bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID     int    `bson:"_id"`
+		Prefix []byte `bson:"prefix"`
+	}
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		return nil, err
+	}
+	dict := &mongoKeyDict{}
+	for _, doc := range docs {
+		if dict, err = dict.with(doc.Prefix, doc.ID); err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
+// RegisterKeyPrefix registers a prefix, and compresses the existing keys with it. Registering a
+// prefix again compresses any of its keys which are still uncompressed, e.g. because an earlier
+// registration failed.
+func (cdb *CompressedMongoDB) RegisterKeyPrefix(prefix []byte) error {
+	cdb.mtx.Lock()
+	defer cdb.mtx.Unlock()
+
+	dict := cdb.dict.Load()
+	p := dict.find(prefix)
+	if p == nil {
+		next, err := dict.with(prefix, dict.nextID())
+		if err != nil {
+			return err
+		}
+		p = next.find(prefix)
+		if p.id > mongoMaxKeyPrefixID {
+			return fmt.Errorf("cannot register more than %d key prefixes", mongoMaxKeyPrefixID+1)
+		}
+		// The prefix is recorded first, so that the keys compressed below are found even if the
+		// registration is interrupted.
+		_, err = cdb.prefixes.InsertOne(context.Background(), bson.D{
+			{Key: "_id", Value: p.id},
+			{Key: "prefix", Value: p.prefix},
+		})
+		if err != nil {
+			return fmt.Errorf("registering key prefix %X: %w", prefix, err)
+		}
+		cdb.dict.Store(next)
+	}
+	return cdb.compressExisting(p)
+}
+
+// compressExisting replaces the uncompressed documents of the keys with prefix p by compressed
+// ones, in chunks of mongoBatchChunkSize documents.
+func (cdb *CompressedMongoDB) compressExisting(p *mongoKeyPrefix) error {
+	filter, err := mongoKeyRangeFilter(prefixDomain(p.prefix))
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for {
+		cursor, err := cdb.mdb.coll().Find(ctx, filter, mongoOptions.Find().SetLimit(mongoBatchChunkSize))
+		if err != nil {
+			return fmt.Errorf("compressing keys with prefix %X: %w", p.prefix, err)
+		}
+		var models []mongo.WriteModel
+		var ids bson.A
+		for cursor.Next(ctx) {
+			key, doc, err := mongoCompressDocument(cursor.Current, p)
+			if err != nil {
+				cursor.Close(ctx)
+				return fmt.Errorf("compressing keys with prefix %X: %w", p.prefix, err)
+			}
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(p.keyFilter(key)).
+				SetReplacement(doc).
+				SetUpsert(true))
+			ids = append(ids, MongoKeyID(key))
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("compressing keys with prefix %X: %w", p.prefix, err)
+		}
+		if len(models) == 0 {
+			return nil
+		}
+		// The compressed documents are written before the uncompressed ones are deleted, so that no
+		// key is lost if this is interrupted, and the next attempt overwrites them.
+		if _, err := cdb.mdb.coll().BulkWrite(ctx, models, mongoOptions.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("compressing keys with prefix %X: %w", p.prefix, cdb.mdb.wrapWriteError(err))
+		}
+		if _, err := cdb.mdb.coll().DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}); err != nil {
+			return fmt.Errorf("compressing keys with prefix %X: %w", p.prefix, cdb.mdb.wrapWriteError(err))
+		}
+	}
+}
+
+// keyFilter returns the filter matching the document of a key.
+func (cdb *CompressedMongoDB) keyFilter(key []byte) bson.D {
+	return cdb.dict.Load().keyFilter(key)
+}
+
+// decodeRecord decodes a compressed or uncompressed document.
+func (cdb *CompressedMongoDB) decodeRecord(raw bson.Raw) (*record, error) {
+	raw, err := cdb.dict.Load().decompressDocument(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cdb.mdb.decodeRecord(raw)
+}
+
+// findOne returns the document of a key, or nil if it does not exist.
+func (cdb *CompressedMongoDB) findOne(key []byte) (bson.Raw, error) {
+	ctx, cancel := cdb.mdb.readContext(context.Background(), cdb.mdb.queryTime())
+	defer cancel()
+	raw, err := cdb.mdb.readColl().FindOne(ctx, cdb.keyFilter(key), cdb.mdb.findOneOptions()).Raw()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, cdb.mdb.wrapReadError(err, cdb.mdb.queryTime())
+	}
+	cdb.mdb.supervise(nil)
+	return raw, nil
+}
+
+// Get implements DB.
+func (cdb *CompressedMongoDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+
+	cdb.mtx.RLock()
+	defer cdb.mtx.RUnlock()
+	for attempt := 1; ; attempt++ {
+		value, err := cdb.get(key)
+		if errors.Is(err, errMongoLargeValueChanged) && attempt < mongoLargeValueReadAttempts {
+			continue
+		}
+		return value, err
+	}
+}
+
+// get fetches the value of key once, see Get.
+func (cdb *CompressedMongoDB) get(key []byte) ([]byte, error) {
+	raw, err := cdb.findOne(key)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	record, err := cdb.decodeRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cdb.mdb.resolveLargeValue(context.Background(), key, raw, record.Value)
+}
+
+// Has implements DB.
+func (cdb *CompressedMongoDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+
+	cdb.mtx.RLock()
+	defer cdb.mtx.RUnlock()
+	raw, err := cdb.findOne(key)
+	return raw != nil, err
+}
+
+// Set implements DB.
+func (cdb *CompressedMongoDB) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+	large, err := cdb.mdb.largeValue(value)
+	if err != nil {
+		return err
+	}
+	if err := cdb.mdb.storageFull.writable(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	op := mongoWriteOp{key: key, value: value, large: large}
+	cutoff := primitive.NewObjectID()
+	if large != nil {
+		if err := cdb.mdb.writeChunks(ctx, key, value, large); err != nil {
+			return err
+		}
+	}
+	_, update := op.setUpdate(cdb.mdb.codec, cdb.mdb.journalColl() != nil)
+	cdb.mtx.RLock()
+	_, err = cdb.mdb.coll().UpdateOne(
+		ctx,
+		cdb.keyFilter(key),
+		update,
+		&mongoOptions.UpdateOptions{Upsert: ptr(true)},
+	)
+	cdb.mtx.RUnlock()
+	if err := cdb.mdb.wrapWriteError(err); err != nil {
+		return err
+	}
+	if err := cdb.mdb.deleteStaleChunks(ctx, []mongoWriteOp{op}, cutoff); err != nil {
+		return err
+	}
+	cdb.learn([][]byte{key})
+	return nil
+}
+
+// SetSync implements DB.
+func (cdb *CompressedMongoDB) SetSync(key, value []byte) error {
+	return cdb.Set(key, value)
+}
+
+// Delete implements DB.
+func (cdb *CompressedMongoDB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+
+	cdb.mtx.RLock()
+	defer cdb.mtx.RUnlock()
+	cutoff := primitive.NewObjectID()
+	if _, err := cdb.mdb.coll().DeleteOne(context.Background(), cdb.keyFilter(key)); err != nil {
+		return cdb.mdb.wrapWriteError(err)
+	}
+	if err := cdb.mdb.deleteStaleChunks(context.Background(), []mongoWriteOp{{key: key}}, cutoff); err != nil {
+		return err
+	}
+	return cdb.mdb.journalDeletes(context.Background(), []mongo.WriteModel{mongoTombstoneModel(key)})
+}
+
+// DeleteSync implements DB.
+func (cdb *CompressedMongoDB) DeleteSync(key []byte) error {
+	return cdb.Delete(key)
+}
+
+// Iterator implements DB.
+func (cdb *CompressedMongoDB) Iterator(start, end []byte) (Iterator, error) {
+	return cdb.iterator(start, end, false)
+}
+
+// ReverseIterator implements DB.
+func (cdb *CompressedMongoDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	return cdb.iterator(start, end, true)
+}
+
+// iterator merges an iterator over the uncompressed keys in [start, end) with an iterator over the
+// compressed keys of each registered prefix intersecting [start, end).
+func (cdb *CompressedMongoDB) iterator(start, end []byte, isReverse bool) (Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	if err := checkRange(start, end, cdb.mdb.lenientRanges.Load()); err != nil {
+		return nil, err
+	}
+
+	cdb.mtx.RLock()
+	defer cdb.mtx.RUnlock()
+	dict := cdb.dict.Load()
+	maxTime := cdb.mdb.queryTime()
+
+	its := []Iterator{}
+	add := func(rangeFilter func(start, end []byte) (bson.D, error)) error {
+		it, err := newMongoDBIteratorWith(
+			context.Background(), cdb.mdb, start, end, isReverse, maxTime, cdb.mdb.prefetch(), rangeFilter, cdb.decodeRecord)
+		if err != nil {
+			_ = closeIterators(its)
+			return err
+		}
+		its = append(its, it)
+		return nil
+	}
+	if err := add(mongoStringKeyRangeFilter); err != nil {
+		return nil, err
+	}
+	for _, p := range dict.prefixes {
+		if _, ok := p.suffixRange(start, end); !ok {
+			continue
+		}
+		if err := add(p.rangeFilter); err != nil {
+			return nil, err
+		}
+	}
+	if len(its) == 1 {
+		return its[0], nil
+	}
+	return MergeIterators(!isReverse, its...), nil
+}
+
+// Ping implements Pinger.
+func (cdb *CompressedMongoDB) Ping(ctx context.Context) error {
+	return cdb.mdb.Ping(ctx)
+}
+
+// NewBatch implements DB.
+func (cdb *CompressedMongoDB) NewBatch() Batch {
+	b := newMongoDBBatch(cdb.mdb)
+	b.group.keyFilter = cdb.keyFilter
+	return &compressedMongoDBBatch{mongoDBBatch: b, cdb: cdb}
+}
+
+// Stats implements DB. In addition to the statistics of MongoDB, it reports the number of
+// registered prefixes under key_compression.prefixes, the number of compressed keys under
+// key_compression.keys, and an estimate of the bytes saved in the _id index and in the documents
+// by the compression of these keys under key_compression.saved_bytes.
+func (cdb *CompressedMongoDB) Stats() map[string]string {
+	stats := cdb.DB.Stats()
+	dict := cdb.dict.Load()
+	stats["key_compression.prefixes"] = strconv.Itoa(len(dict.prefixes))
+
+	var keys, saved int64
+	for _, p := range dict.prefixes {
+		filter, _ := p.rangeFilter(nil, nil)
+		n, err := cdb.mdb.readColl().CountDocuments(context.Background(), filter)
+		if err != nil {
+			stats["key_compression.error"] = err.Error()
+			return stats
+		}
+		keys += n
+		saved += n * int64(2*len(p.prefix)-mongoCompressedIDOverhead)
+	}
+	stats["key_compression.keys"] = strconv.FormatInt(keys, 10)
+	stats["key_compression.saved_bytes"] = strconv.FormatInt(saved, 10)
+	return stats
+}
+
+// learn samples written keys, and registers the prefixes learned from the sample once it holds
+// the configured number of keys.
+func (cdb *CompressedMongoDB) learn(keys [][]byte) {
+	cdb.learnMtx.Lock()
+	if cdb.learnKeys == nil {
+		cdb.learnMtx.Unlock()
+		return
+	}
+	for _, key := range keys {
+		if len(cdb.learnKeys) == cdb.learnWrites {
+			break
+		}
+		cdb.learnKeys = append(cdb.learnKeys, cp(key))
+	}
+	sample := cdb.learnKeys
+	if len(sample) < cdb.learnWrites {
+		cdb.learnMtx.Unlock()
+		return
+	}
+	cdb.learnKeys = nil
+	cdb.learnMtx.Unlock()
+
+	for _, prefix := range learnKeyPrefixes(sample, cdb.dict.Load()) {
+		if err := cdb.RegisterKeyPrefix(prefix); err != nil {
+			logf("registering learned key prefix %q: %v", prefix, err)
+		}
+	}
+}
+
+// learnKeyPrefixes returns the prefixes to register for a sample of keys: the prefixes ending with
+// ':' or '/' of at least mongoMinLearnedPrefixSize bytes, shared by at least
+// mongoMinLearnedPrefixKeys and 1% of the sampled keys, and neither a prefix of a registered prefix
+// nor prefixed by one. The shortest of nested candidates is preferred, since it covers the keys of
+// the others.
+func learnKeyPrefixes(keys [][]byte, dict *mongoKeyDict) [][]byte {
+	candidates := make(map[string]int)
+	for _, key := range keys {
+		for i, c := range key {
+			if (c == ':' || c == '/') && i+1 >= mongoMinLearnedPrefixSize {
+				candidates[string(key[:i+1])]++
+			}
+		}
+	}
+	sorted := make([]string, 0, len(candidates))
+	for candidate := range candidates {
+		sorted = append(sorted, candidate)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) < len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	minKeys := max(mongoMinLearnedPrefixKeys, len(keys)/100)
+	var learned [][]byte
+	for _, candidate := range sorted {
+		if candidates[candidate] < minKeys {
+			continue
+		}
+		next, err := dict.with([]byte(candidate), dict.nextID())
+		if err != nil {
+			continue
+		}
+		dict = next
+		learned = append(learned, []byte(candidate))
+	}
+	return learned
+}
+
+// compressedMongoDBBatch is a MongoDB batch writing compressed keys, and sampling the written keys
+// for learning.
+type compressedMongoDBBatch struct {
+	*mongoDBBatch
+	cdb *CompressedMongoDB
+}
+
+var (
+	_ Batch           = (*compressedMongoDBBatch)(nil)
+	_ StrictBatch     = (*compressedMongoDBBatch)(nil)
+	_ StrictSetBatch  = (*compressedMongoDBBatch)(nil)
+	_ ContextBatch    = (*compressedMongoDBBatch)(nil)
+	_ ResettableBatch = (*compressedMongoDBBatch)(nil)
+)
+
+// Write implements Batch.
+func (b *compressedMongoDBBatch) Write() error {
+	return b.writeCompressed(context.Background(), false, false)
+}
+
+// WriteSync implements Batch.
+func (b *compressedMongoDBBatch) WriteSync() error {
+	return b.writeCompressed(context.Background(), false, true)
+}
+
+// WriteStrict implements StrictBatch.
+func (b *compressedMongoDBBatch) WriteStrict() error {
+	return b.writeCompressed(context.Background(), true, false)
+}
+
+// WriteContext implements ContextBatch.
+func (b *compressedMongoDBBatch) WriteContext(ctx context.Context) error {
+	return b.writeCompressed(ctx, false, false)
+}
+
+// writeCompressed writes the batch while no prefix is being registered, so that the filters of its
+// keys stay valid.
+func (b *compressedMongoDBBatch) writeCompressed(ctx context.Context, strict, sync bool) error {
+	b.mu.Lock()
+	keys := make([][]byte, 0, len(b.group.ops))
+	for _, op := range b.group.ops {
+		if !op.isDelete() {
+			keys = append(keys, op.key)
+		}
+	}
+	b.mu.Unlock()
+
+	b.cdb.mtx.RLock()
+	err := b.write(ctx, strict, sync)
+	b.cdb.mtx.RUnlock()
+	if err == nil {
+		b.cdb.learn(keys)
+	}
+	return err
+}
+
+// mongoKeyPrefix is a registered key prefix.
+type mongoKeyPrefix struct {
+	id     int
+	prefix []byte
+	// field is the field name of the compressed _id of the keys with the prefix, and nextField the
+	// field name of the next ID, which bounds the _ids of the prefix.
+	field, nextField string
+}
+
+// mongoKeyPrefixField returns the field name of the compressed _ids of a prefix ID.
+func mongoKeyPrefixField(id int) string {
+	return fmt.Sprintf("%04x", id)
+}
+
+// keyFilter returns the filter matching the document of a key with the prefix. The suffix of the
+// key is encoded like uncompressed keys, see MongoKeyID.
+func (p *mongoKeyPrefix) keyFilter(key []byte) bson.D {
+	return bson.D{{Key: "_id", Value: bson.D{{Key: p.field, Value: MongoKeyID(key[len(p.prefix):])}}}}
+}
+
+// suffixRange returns the range of the suffixes of the keys with the prefix in the domain
+// [start, end), where a nil end suffix is after all suffixes. Returns false if no key with the
+// prefix is in the domain.
+func (p *mongoKeyPrefix) suffixRange(start, end []byte) ([2][]byte, bool) {
+	r := [2][]byte{{}, nil}
+	if start != nil && bytes.Compare(start, p.prefix) > 0 {
+		if !bytes.HasPrefix(start, p.prefix) {
+			return r, false
+		}
+		r[0] = start[len(p.prefix):]
+	}
+	if end != nil {
+		if bytes.Compare(end, p.prefix) <= 0 {
+			return r, false
+		}
+		if bytes.HasPrefix(end, p.prefix) {
+			r[1] = end[len(p.prefix):]
+		}
+	}
+	return r, true
+}
+
+// rangeFilter returns the filter matching the documents of the keys with the prefix in the domain
+// [start, end). Compressed _ids are embedded documents, which MongoDB orders by field name and
+// then by value, so the keys of the prefix are those from {field: ""} until {nextField: ""}.
+func (p *mongoKeyPrefix) rangeFilter(start, end []byte) (bson.D, error) {
+	r, ok := p.suffixRange(start, end)
+	if !ok {
+		return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}, nil
+	}
+	upper := bson.D{{Key: p.nextField, Value: ""}}
+	if r[1] != nil {
+		upper = bson.D{{Key: p.field, Value: MongoKeyID(r[1])}}
+	}
+	return bson.D{{Key: "_id", Value: bson.D{
+		{Key: "$gte", Value: bson.D{{Key: p.field, Value: MongoKeyID(r[0])}}},
+		{Key: "$lt", Value: upper},
+	}}}, nil
+}
+
+// mongoStringKeyRangeFilter is like mongoKeyRangeFilter, but only matches uncompressed keys.
+// Comparisons with strings only match strings, so this only differs for the full domain.
+func mongoStringKeyRangeFilter(start, end []byte) (bson.D, error) {
+	if start == nil && end == nil {
+		return bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: ""}}}}, nil
+	}
+	return mongoKeyRangeFilter(start, end)
+}
+
+// mongoKeyDict is an immutable set of registered key prefixes, none of which is a prefix of
+// another.
+type mongoKeyDict struct {
+	// prefixes are sorted by prefix.
+	prefixes []*mongoKeyPrefix
+}
+
+// with returns a copy of the dictionary with an additional prefix.
+func (d *mongoKeyDict) with(prefix []byte, id int) (*mongoKeyDict, error) {
+	if len(prefix) == 0 {
+		return nil, errors.New("key prefix cannot be empty")
+	}
+	if len(prefix) >= mongoMaxKeySize {
+		return nil, fmt.Errorf("key prefix %X is too long", prefix)
+	}
+	if id < 0 {
+		return nil, fmt.Errorf("invalid key prefix ID %d", id)
+	}
+	for _, p := range d.prefixes {
+		if p.id == id {
+			return nil, fmt.Errorf("duplicate key prefix ID %d", id)
+		}
+		if bytes.HasPrefix(p.prefix, prefix) || bytes.HasPrefix(prefix, p.prefix) {
+			return nil, fmt.Errorf("key prefix %X overlaps registered prefix %X", prefix, p.prefix)
+		}
+	}
+	prefixes := make([]*mongoKeyPrefix, 0, len(d.prefixes)+1)
+	prefixes = append(prefixes, d.prefixes...)
+	prefixes = append(prefixes, &mongoKeyPrefix{
+		id:        id,
+		prefix:    cp(prefix),
+		field:     mongoKeyPrefixField(id),
+		nextField: mongoKeyPrefixField(id + 1),
+	})
+	sort.Slice(prefixes, func(i, j int) bool { return bytes.Compare(prefixes[i].prefix, prefixes[j].prefix) < 0 })
+	return &mongoKeyDict{prefixes: prefixes}, nil
+}
+
+// nextID returns the ID of the next registered prefix.
+func (d *mongoKeyDict) nextID() int {
+	id := 0
+	for _, p := range d.prefixes {
+		id = max(id, p.id+1)
+	}
+	return id
+}
+
+// find returns the registered prefix equal to prefix, or nil.
+func (d *mongoKeyDict) find(prefix []byte) *mongoKeyPrefix {
+	if p := d.lookup(prefix); p != nil && len(p.prefix) == len(prefix) {
+		return p
+	}
+	return nil
+}
+
+// lookup returns the registered prefix of a key, or nil. Since registered prefixes are not nested,
+// it is the greatest prefix not after the key, if it is a prefix of the key.
+func (d *mongoKeyDict) lookup(key []byte) *mongoKeyPrefix {
+	i := sort.Search(len(d.prefixes), func(i int) bool { return bytes.Compare(d.prefixes[i].prefix, key) > 0 })
+	if i > 0 && bytes.HasPrefix(key, d.prefixes[i-1].prefix) {
+		return d.prefixes[i-1]
+	}
+	return nil
+}
+
+// byField returns the registered prefix with the given field name, or nil.
+func (d *mongoKeyDict) byField(field string) *mongoKeyPrefix {
+	for _, p := range d.prefixes {
+		if p.field == field {
+			return p
+		}
+	}
+	return nil
+}
+
+// keyFilter returns the filter matching the document of a key.
+func (d *mongoKeyDict) keyFilter(key []byte) bson.D {
+	if p := d.lookup(key); p != nil {
+		return p.keyFilter(key)
+	}
+	return mongoKeyFilter(key)
+}
+
+// decompressDocument returns a document with a compressed _id as if it was written uncompressed,
+// so that it can be decoded by the record codec. Uncompressed documents are returned as is.
+func (d *mongoKeyDict) decompressDocument(raw bson.Raw) (bson.Raw, error) {
+	id, err := raw.LookupErr("_id")
+	if err != nil {
+		return nil, err
+	}
+	if id.Type != bsontype.EmbeddedDocument {
+		return raw, nil
+	}
+	elems, err := id.Document().Elements()
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) != 1 || elems[0].Value().Type != bsontype.String {
+		return nil, fmt.Errorf("invalid compressed key %s", id)
+	}
+	p := d.byField(elems[0].Key())
+	if p == nil {
+		return nil, fmt.Errorf("compressed key %s has an unknown prefix", id)
+	}
+	// The hex encoding of a key is that of its prefix followed by that of its suffix.
+	keyID := MongoKeyID(p.prefix) + elems[0].Value().StringValue()
+	return mongoReplaceID(raw, func(dst []byte) []byte {
+		return bsoncore.AppendStringElement(dst, "_id", keyID)
+	})
+}
+
+// mongoCompressDocument returns the key of an uncompressed document with prefix p, and the document
+// with its _id compressed.
+func mongoCompressDocument(raw bson.Raw, p *mongoKeyPrefix) ([]byte, bson.Raw, error) {
+	id, err := raw.LookupErr("_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID, ok := id.StringValueOK()
+	prefixID := MongoKeyID(p.prefix)
+	if !ok || !strings.HasPrefix(keyID, prefixID) {
+		return nil, nil, fmt.Errorf("document %s does not have key prefix %X", id, p.prefix)
+	}
+	key, err := mongoKeyFromID(keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := mongoReplaceID(raw, func(dst []byte) []byte {
+		idx, dst := bsoncore.AppendDocumentElementStart(dst, "_id")
+		dst = bsoncore.AppendStringElement(dst, p.field, keyID[len(prefixID):])
+		dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+		return dst
+	})
+	return key, doc, err
+}
+
+// mongoReplaceID returns a copy of a document with its _id element replaced by the one appended by
+// appendID.
+func mongoReplaceID(raw bson.Raw, appendID func(dst []byte) []byte) (bson.Raw, error) {
+	return mongoReplaceElement(raw, "_id", appendID)
+}
+
+// mongoReplaceElement returns a copy of a document with its element named key replaced by the one
+// appended by appendElem.
+func mongoReplaceElement(raw bson.Raw, key string, appendElem func(dst []byte) []byte) (bson.Raw, error) {
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	idx, dst := bsoncore.AppendDocumentStart(make([]byte, 0, len(raw)+16))
+	for _, elem := range elems {
+		if elem.Key() == key {
+			dst = appendElem(dst)
+		} else {
+			dst = append(dst, elem...)
+		}
+	}
+	dst, err = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst, err
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keyCompressionPrefixes are the prefixes registered in the key compression tests. They include a
+// prefix of 0xFF bytes, whose domain has no end.
+var keyCompressionPrefixes = [][]byte{bz("blockstore:block_part:"), bz("state:abci"), {0xff, 0xff}, {0x80}}
+
+// keyCompressionDict returns a dictionary of keyCompressionPrefixes.
+func keyCompressionDict(t testing.TB) *mongoKeyDict {
+	dict := &mongoKeyDict{}
+	for i, prefix := range keyCompressionPrefixes {
+		var err error
+		dict, err = dict.with(prefix, i)
+		require.NoError(t, err)
+	}
+	return dict
+}
+
+// randomCompressionKey returns a random key, often with one of keyCompressionPrefixes or near one.
+func randomCompressionKey(r *rand.Rand) []byte {
+	alphabet := []byte{0x00, ':', 'a', 'b', 'z', 0x7f, 0x80, 0xfe, 0xff}
+	suffix := make([]byte, r.Intn(4))
+	for i := range suffix {
+		suffix[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	if r.Intn(4) == 0 {
+		return append([]byte{alphabet[r.Intn(len(alphabet))]}, suffix...)
+	}
+	prefix := keyCompressionPrefixes[r.Intn(len(keyCompressionPrefixes))]
+	// Cut the prefix short sometimes, for keys just before or after the domain of a prefix.
+	prefix = prefix[:len(prefix)-r.Intn(2)]
+	key := append(cp(prefix), suffix...)
+	if len(key) == 0 {
+		return []byte{'a'}
+	}
+	return key
+}
+
+func TestMongoKeyDict(t *testing.T) {
+	dict := keyCompressionDict(t)
+	assert.Equal(t, len(keyCompressionPrefixes), dict.nextID())
+
+	_, err := dict.with(bz("blockstore:"), 10)
+	assert.ErrorContains(t, err, "overlaps")
+	_, err = dict.with(bz("state:abci:info"), 10)
+	assert.ErrorContains(t, err, "overlaps")
+	_, err = dict.with(bz(""), 10)
+	assert.Error(t, err)
+	_, err = dict.with(bz("other"), 0)
+	assert.ErrorContains(t, err, "duplicate")
+
+	assert.NotNil(t, dict.find(bz("state:abci")))
+	assert.Nil(t, dict.find(bz("state:abci:")))
+	assert.Nil(t, dict.find(bz("state:")))
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := randomCompressionKey(r)
+		var want *mongoKeyPrefix
+		for _, p := range dict.prefixes {
+			if bytes.HasPrefix(key, p.prefix) {
+				want = p
+			}
+		}
+		require.Equal(t, want, dict.lookup(key), "key %X", key)
+	}
+}
+
+// TestMongoKeyPrefixRanges checks the translation of ranges: every key in a range, and no other
+// key, is either uncompressed and matched by the string range, or has a prefix intersecting the
+// range with its suffix in the suffix range of the prefix.
+func TestMongoKeyPrefixRanges(t *testing.T) {
+	dict := keyCompressionDict(t)
+	r := rand.New(rand.NewSource(2))
+	inRange := func(key, start, end []byte) bool {
+		return (start == nil || bytes.Compare(key, start) >= 0) && (end == nil || bytes.Compare(key, end) < 0)
+	}
+	for i := 0; i < 2000; i++ {
+		var start, end []byte
+		if r.Intn(4) > 0 {
+			start = randomCompressionKey(r)
+		}
+		if r.Intn(4) > 0 {
+			end = randomCompressionKey(r)
+		}
+		if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+			start, end = end, start
+		}
+
+		for j := 0; j < 50; j++ {
+			key := randomCompressionKey(r)
+			p := dict.lookup(key)
+			if p == nil {
+				// Uncompressed keys are matched by the string range of the domain itself.
+				continue
+			}
+			suffix := key[len(p.prefix):]
+			sr, ok := p.suffixRange(start, end)
+			matched := ok && bytes.Compare(suffix, sr[0]) >= 0 && (sr[1] == nil || bytes.Compare(suffix, sr[1]) < 0)
+			require.Equal(t, inRange(key, start, end), matched, "key %X in [%X, %X)", key, start, end)
+		}
+	}
+}
+
+func TestMongoKeyPrefixRangeFilter(t *testing.T) {
+	p := keyCompressionDict(t).find(bz("state:abci"))
+	require.NotNil(t, p)
+
+	filter, err := p.rangeFilter(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: bson.D{
+		{Key: "$gte", Value: bson.D{{Key: "0001", Value: ""}}},
+		{Key: "$lt", Value: bson.D{{Key: "0002", Value: ""}}},
+	}}}, filter)
+
+	filter, err = p.rangeFilter(bz("state:abci:1"), bz("state:abci:5"))
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: bson.D{
+		{Key: "$gte", Value: bson.D{{Key: "0001", Value: MongoKeyID(bz(":1"))}}},
+		{Key: "$lt", Value: bson.D{{Key: "0001", Value: MongoKeyID(bz(":5"))}}},
+	}}}, filter)
+
+	// A range outside of the prefix matches nothing.
+	filter, err = p.rangeFilter(bz("z"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}, filter)
+
+	filter, err = mongoStringKeyRangeFilter(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: ""}}}}, filter)
+}
+
+func TestMongoCompressDocument(t *testing.T) {
+	dict := keyCompressionDict(t)
+	for _, key := range [][]byte{bz("state:abci"), bz("state:abci:info"), {0xff, 0xff, 0x00, 0xfe}, {0x80, 'x'}} {
+		p := dict.lookup(key)
+		require.NotNil(t, p)
+		raw := marshalDocument(t, bson.D{
+			{Key: "value", Value: []byte("value")},
+			{Key: "_id", Value: MongoKeyID(key)},
+			{Key: "modifiedAt", Value: int64(1)},
+		})
+
+		gotKey, compressed, err := mongoCompressDocument(raw, p)
+		require.NoError(t, err)
+		assert.Equal(t, key, gotKey)
+		id := compressed.Lookup("_id").Document()
+		assert.Equal(t, MongoKeyID(key[len(p.prefix):]), id.Lookup(p.field).StringValue())
+		assert.Equal(t, raw.Lookup("modifiedAt"), compressed.Lookup("modifiedAt"))
+		filter := marshalDocument(t, p.keyFilter(key))
+		assert.Equal(t, filter.Lookup("_id"), compressed.Lookup("_id"))
+
+		decompressed, err := dict.decompressDocument(compressed)
+		require.NoError(t, err)
+		assert.Equal(t, raw, decompressed)
+		rec, err := (&MongoDB{codec: defaultRecordCodec{}}).decodeRecord(decompressed)
+		require.NoError(t, err)
+		assert.Equal(t, key, rec.Key)
+		assert.Equal(t, bz("value"), rec.Value)
+	}
+
+	_, _, err := mongoCompressDocument(marshalDocument(t, bson.D{{Key: "_id", Value: MongoKeyID(bz("other"))}}), dict.prefixes[0])
+	assert.Error(t, err)
+
+	// Uncompressed documents are decoded as is, and unknown prefixes fail.
+	raw := marshalDocument(t, bson.D{{Key: "_id", Value: MongoKeyID(bz("other"))}})
+	decompressed, err := dict.decompressDocument(raw)
+	require.NoError(t, err)
+	assert.Equal(t, raw, decompressed)
+	_, err = dict.decompressDocument(marshalDocument(t, bson.D{{Key: "_id", Value: bson.D{{Key: "00ff", Value: "x"}}}}))
+	assert.ErrorContains(t, err, "unknown prefix")
+}
+
+func TestMongoCompressedWriteModels(t *testing.T) {
+	dict := keyCompressionDict(t)
+	group := newMongoWriteGroup()
+	group.keyFilter = dict.keyFilter
+	group.add(mongoWriteOp{seq: 1, key: bz("state:abci:1"), value: bz("1")})
+	group.add(mongoWriteOp{seq: 2, key: bz("state:abci:2"), value: bz("2"), mode: mongoSetInsertOnly})
+	group.add(mongoWriteOp{seq: 3, key: bz("state:abci:3"), value: bz("3"), mode: mongoSetUpdateOnly})
+	group.add(mongoWriteOp{seq: 4, key: bz("state:abci:4")})
+	group.add(mongoWriteOp{seq: 5, key: bz("other"), value: bz("5")})
+
+	_, models, _ := group.writeModels()
+	require.Len(t, models, 5)
+	id := func(key string) bson.D { return bson.D{{Key: "0001", Value: MongoKeyID(bz(key))}} }
+
+	set := models[0].(*mongo.UpdateOneModel)
+	assert.Equal(t, bson.D{{Key: "_id", Value: id(":1")}}, set.Filter)
+	assert.True(t, *set.Upsert)
+	insert := models[1].(*mongo.UpdateOneModel)
+	assert.Equal(t, mongoWriteOnceFilter(bson.D{{Key: "_id", Value: id(":2")}}), insert.Filter)
+	assert.True(t, *insert.Upsert)
+	update := models[2].(*mongo.UpdateOneModel)
+	assert.Equal(t, bson.D{{Key: "_id", Value: id(":3")}}, update.Filter)
+	assert.Nil(t, update.Upsert)
+	del := models[3].(*mongo.DeleteOneModel)
+	assert.Equal(t, bson.D{{Key: "_id", Value: id(":4")}}, del.Filter)
+	assert.Equal(t, mongoKeyFilter(bz("other")), models[4].(*mongo.UpdateOneModel).Filter)
+}
+
+func TestLearnKeyPrefixes(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		keys = append(keys,
+			[]byte(fmt.Sprintf("blockstore:block_part:%d:%d", i, i%3)),
+			[]byte(fmt.Sprintf("H:%d", i)),
+		)
+	}
+	keys = append(keys, bz("consensus/wal/single:entry"), bz("evidence/committed/pending:1"), bz("evidence/committed/pending:2"))
+
+	learned := learnKeyPrefixes(keys, &mongoKeyDict{})
+	// The shortest candidates are preferred over the nested per-height prefixes, and short or rare
+	// prefixes are ignored.
+	assert.Equal(t, [][]byte{bz("evidence/committed/"), bz("blockstore:block_part:")}, learned)
+
+	// Prefixes nested with registered ones are not learned.
+	dict, err := (&mongoKeyDict{}).with(bz("blockstore:"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{bz("evidence/committed/")}, learnKeyPrefixes(keys, dict))
+}
+
+func TestMongoKeyCompressionConfig(t *testing.T) {
+	cfg, ok, err := mongoKeyCompressionConfig(Options{})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, KeyCompressionConfig{}, cfg)
+
+	cfg, ok, err = mongoKeyCompressionConfig(Options{
+		mongoOptionKeyPrefixes:      `["blockstore:block_part:", "state:"]`,
+		mongoOptionLearnKeyPrefixes: "1000",
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, KeyCompressionConfig{
+		Prefixes:    [][]byte{bz("blockstore:block_part:"), bz("state:")},
+		LearnWrites: 1000,
+	}, cfg)
+
+	for _, options := range []Options{
+		{mongoOptionKeyPrefixes: `"state:"`},
+		{mongoOptionKeyPrefixes: `["state:", "state:abci"]`},
+		{mongoOptionKeyPrefixes: `[""]`},
+		{mongoOptionLearnKeyPrefixes: "-1"},
+		{mongoOptionLearnKeyPrefixes: "many"},
+	} {
+		_, _, err := mongoKeyCompressionConfig(options)
+		assert.Error(t, err, options)
+	}
+}
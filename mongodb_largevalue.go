@@ -0,0 +1,350 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Values larger than the large value threshold do not fit in a document, whose size MongoDB limits
+// to 16 MiB, and are split into chunks stored as documents of the chunk collection, see
+// mongoChunks. The document of the key then holds a mongoLargeValue marker instead of the value,
+// and Get, GetMany and iterators reassemble the value from its chunks. Keys are only ever stored
+// in the documents of the collection, so Has, iterators and statistics never see the chunks.
+
+// mongoOptionLargeValueThreshold is the size in bytes above which values are split into chunks, see
+// MongoDB.SetLargeValueThreshold. Absent uses mongoDefaultLargeValueThreshold, and zero disables
+// chunking.
+const mongoOptionLargeValueThreshold = "large_value_threshold"
+
+const (
+	// mongoMaxValueSize is the size of the largest value stored in the document of its key: the
+	// document size limit of MongoDB, less room for the key and the other fields.
+	mongoMaxValueSize = 16<<20 - 16<<10
+	// mongoDefaultLargeValueThreshold is the default size above which values are split into chunks.
+	mongoDefaultLargeValueThreshold = 15 << 20
+)
+
+const (
+	// mongoLargeValueField is the field holding the mongoLargeValue marker of a large value.
+	mongoLargeValueField = "largeValue"
+	// mongoChunksSuffix is appended to the collection name to name the chunk collection.
+	mongoChunksSuffix = ".chunks"
+	// mongoChunkIndexName is the name of the index of the chunk collection on the keys and
+	// generations of the chunks, which serves the deletion of stale chunks.
+	mongoChunkIndexName = "key_gen"
+	// mongoLargeValueReadAttempts is the number of times Get reads a large value which is
+	// overwritten or deleted while its chunks are read.
+	mongoLargeValueReadAttempts = 3
+	// mongoLargeValueProbeInterval is the time for which the chunk collection is assumed to stay
+	// empty once found empty, see mayHaveLargeValues.
+	mongoLargeValueProbeInterval = time.Minute
+)
+
+// errMongoLargeValueChanged is returned when the chunks of a large value were removed while they
+// were read, because the key was overwritten or deleted.
+var errMongoLargeValueChanged = errors.New("large value changed while reading its chunks")
+
+// mongoLargeValueThreshold returns the large value threshold configured by the
+// large_value_threshold option.
+func mongoLargeValueThreshold(options Options) (int, error) {
+	s, ok := options[mongoOptionLargeValueThreshold]
+	if !ok {
+		return mongoDefaultLargeValueThreshold, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", mongoOptionLargeValueThreshold, s, err)
+	}
+	if err := checkMongoLargeValueThreshold(n); err != nil {
+		return 0, fmt.Errorf("invalid %s %d: %w", mongoOptionLargeValueThreshold, n, err)
+	}
+	return n, nil
+}
+
+// checkMongoLargeValueThreshold returns an error if n is not a valid large value threshold.
+func checkMongoLargeValueThreshold(n int) error {
+	if n < 0 || n > mongoMaxValueSize {
+		return fmt.Errorf("must be between 0 and %d", mongoMaxValueSize)
+	}
+	return nil
+}
+
+// SetLargeValueThreshold sets the size in bytes above which values are split into chunks of at most
+// that size, stored in the chunk collection named after the collection with a ".chunks" suffix.
+// Zero disables chunking, so that values larger than about 16 MiB fail with ErrValueTooLarge. It
+// must be called before the database is used, and defaults to 15 MiB.
+//
+// Set and batches split large values, while conditional sets, SetWithTTL and cross batches fail
+// with ErrValueTooLarge for values which do not fit in a document. The chunks of a value are
+// removed when its key is overwritten or deleted. Concurrent writes of the same key may remove the
+// chunks of the value written last, which then fails to read. Exports and replication only see the
+// documents of the keys, without the chunks of their values.
+func (db *MongoDB) SetLargeValueThreshold(n int) error {
+	if err := checkMongoLargeValueThreshold(n); err != nil {
+		return fmt.Errorf("invalid large value threshold %d: %w", n, err)
+	}
+	db.largeValueThreshold = n
+	return nil
+}
+
+// mongoLargeValue is the marker stored in the document of a key in place of a large value. The
+// chunks of the value are the documents of the chunk collection with the IDs mongoChunkID(Gen, n)
+// for n from 0 to Chunks-1. Gen identifies the write, so that the chunks of different writes of a
+// key never mix.
+type mongoLargeValue struct {
+	Gen    primitive.ObjectID `bson:"gen"`
+	Chunks int                `bson:"chunks"`
+	Size   int                `bson:"size"`
+}
+
+// newMongoLargeValue returns the marker of a new large value of size bytes, split into chunks of
+// at most threshold bytes.
+func newMongoLargeValue(size, threshold int) *mongoLargeValue {
+	return &mongoLargeValue{
+		Gen:    primitive.NewObjectID(),
+		Chunks: (size + threshold - 1) / threshold,
+		Size:   size,
+	}
+}
+
+// chunkSize returns the size of the chunks of the value, of which the last may be smaller.
+func (v *mongoLargeValue) chunkSize() int {
+	return (v.Size + v.Chunks - 1) / v.Chunks
+}
+
+// mongoChunkID returns the _id of chunk n of the value written as gen. The IDs of the chunks of a
+// value sort in order of their index, so that they are read with a single range query.
+func mongoChunkID(gen primitive.ObjectID, n int) string {
+	return fmt.Sprintf("%s/%08x", gen.Hex(), n)
+}
+
+// mongoChunks returns the chunk collection of a collection.
+func mongoChunks(collection *mongo.Collection) *mongo.Collection {
+	return collection.Database().Collection(collection.Name() + mongoChunksSuffix)
+}
+
+// mongoLargeSetUpdate is like mongoSetUpdate for a large value, whose document holds the marker
+// large and an empty value.
+func mongoLargeSetUpdate(codec RecordCodec, key []byte, large *mongoLargeValue, trackTimestamps bool) (bson.D, bson.D) {
+	filter, update := codec.EncodeSet(key, []byte{})
+	if trackTimestamps {
+		update = append(update, bson.E{Key: "$currentDate", Value: bson.D{{Key: "modifiedAt", Value: true}}})
+	}
+	update = mongoExpiringUpdate(update, time.Time{})
+	return filter, mongoUpdateOperator(update, "$set", bson.E{Key: mongoLargeValueField, Value: large})
+}
+
+// mongoLookupLargeValue returns the large value marker of a document, or nil if it holds its value.
+func mongoLookupLargeValue(raw bson.Raw) (*mongoLargeValue, error) {
+	rv, err := raw.LookupErr(mongoLargeValueField)
+	if errors.Is(err, bsoncore.ErrElementNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	var large mongoLargeValue
+	if err := rv.Unmarshal(&large); err != nil {
+		return nil, fmt.Errorf("invalid document: %s: %w", mongoLargeValueField, err)
+	}
+	if large.Chunks <= 0 || large.Size < 0 {
+		return nil, fmt.Errorf("invalid document: %s has %d chunks of %d bytes", mongoLargeValueField,
+			large.Chunks, large.Size)
+	}
+	return &large, nil
+}
+
+// largeValue returns the marker of a new large value if value must be split into chunks, nil if it
+// is stored in the document of its key, or ErrValueTooLarge if it fits in neither.
+func (db *MongoDB) largeValue(value []byte) (*mongoLargeValue, error) {
+	if db.largeValueThreshold > 0 && len(value) > db.largeValueThreshold {
+		return newMongoLargeValue(len(value), db.largeValueThreshold), nil
+	}
+	return nil, checkValueLimit(value, mongoMaxValueSize)
+}
+
+// writeChunks writes the chunks of the large value of key. Chunks are replaced by their _id, so
+// that a retried write does not duplicate them.
+func (db *MongoDB) writeChunks(ctx context.Context, key, value []byte, large *mongoLargeValue) error {
+	if err := db.ensureChunkIndex(ctx); err != nil {
+		return err
+	}
+	db.largeValues.Store(true)
+
+	size := large.chunkSize()
+	models := make([]mongo.WriteModel, large.Chunks)
+	for n := range models {
+		id := mongoChunkID(large.Gen, n)
+		models[n] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetReplacement(bson.D{
+				{Key: "_id", Value: id},
+				{Key: "key", Value: MongoKeyID(key)},
+				{Key: "gen", Value: large.Gen},
+				{Key: "data", Value: value[n*size : min((n+1)*size, len(value))]},
+			}).
+			SetUpsert(true)
+	}
+	_, err := mongoChunks(db.coll()).BulkWrite(ctx, models, mongoOptions.BulkWrite().SetOrdered(false))
+	return db.wrapWriteErrorContext(ctx, "set", err)
+}
+
+// writeLargeValues writes the chunks of the large values set by ops.
+func (db *MongoDB) writeLargeValues(ctx context.Context, ops []mongoWriteOp) error {
+	for _, op := range ops {
+		if op.large != nil {
+			if err := db.writeChunks(ctx, op.key, op.value, op.large); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureChunkIndex creates the index of the chunk collection, unless a previous call did.
+func (db *MongoDB) ensureChunkIndex(ctx context.Context) error {
+	if db.chunkIndexReady.Load() {
+		return nil
+	}
+	_, err := mongoChunks(db.coll()).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}, {Key: "gen", Value: 1}},
+		Options: mongoOptions.Index().SetName(mongoChunkIndexName),
+	})
+	if err != nil {
+		return db.wrapWriteErrorContext(ctx, "set", err)
+	}
+	db.chunkIndexReady.Store(true)
+	return nil
+}
+
+// loadLargeValue reads and reassembles the chunks of the large value of key. It returns
+// errMongoLargeValueChanged if chunks are missing, because the key was overwritten or deleted
+// since its document was read.
+func (db *MongoDB) loadLargeValue(ctx context.Context, key []byte, large *mongoLargeValue) ([]byte, error) {
+	filter := bson.D{{Key: "_id", Value: bson.D{
+		{Key: "$gte", Value: mongoChunkID(large.Gen, 0)},
+		{Key: "$lte", Value: mongoChunkID(large.Gen, large.Chunks-1)},
+	}}}
+	opts := mongoOptions.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.D{{Key: "_id", Value: 0}, {Key: "data", Value: 1}})
+	if db.queryTime() > 0 {
+		opts.SetMaxTime(db.queryTime())
+	}
+	readCtx, cancel := db.readContext(ctx, db.queryTime())
+	defer cancel()
+	chunks := mongoReadCollection(mongoChunks(db.coll()), db.EffectiveReadPreference())
+	cursor, err := chunks.Find(readCtx, filter, opts)
+	if err != nil {
+		return nil, db.wrapReadErrorContext(ctx, "get", err, db.queryTime())
+	}
+	defer cursor.Close(context.Background())
+
+	value := make([]byte, 0, large.Size)
+	n := 0
+	for cursor.Next(readCtx) {
+		_, data, ok := cursor.Current.Lookup("data").BinaryOK()
+		if !ok {
+			return nil, fmt.Errorf("invalid chunk %d of key %X: no data", n, key)
+		}
+		value = append(value, data...)
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, db.wrapReadErrorContext(ctx, "get", err, db.queryTime())
+	}
+	if n != large.Chunks || len(value) != large.Size {
+		return nil, fmt.Errorf("%w: key %X", errMongoLargeValueChanged, key)
+	}
+	return value, nil
+}
+
+// resolveLargeValue returns the value of key decoded from its document raw, or reassembles it from
+// its chunks if the document holds a large value marker instead.
+func (db *MongoDB) resolveLargeValue(ctx context.Context, key []byte, raw bson.Raw, value []byte) ([]byte, error) {
+	large, err := mongoLookupLargeValue(raw)
+	if err != nil || large == nil {
+		return value, err
+	}
+	return db.loadLargeValue(ctx, key, large)
+}
+
+// mayHaveLargeValues returns whether the chunk collection may hold chunks, so that writes remove
+// the stale chunks of the keys they overwrite or delete. Once the collection was found empty, it
+// is assumed to stay empty for mongoLargeValueProbeInterval, unless a large value is written.
+func (db *MongoDB) mayHaveLargeValues(ctx context.Context) bool {
+	if db.largeValues.Load() {
+		return true
+	}
+	if probed := db.largeValuesProbed.Load(); probed != 0 &&
+		time.Since(time.Unix(0, probed)) < mongoLargeValueProbeInterval {
+		return false
+	}
+	err := mongoChunks(db.coll()).FindOne(ctx, bson.D{}, mongoOptions.FindOne().SetProjection(mongoIDProjection)).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		db.largeValuesProbed.Store(time.Now().UnixNano())
+		return false
+	}
+	if err == nil {
+		db.largeValues.Store(true)
+	}
+	// If the probe failed, the stale chunks are removed in case there are any.
+	return true
+}
+
+// deleteStaleChunks removes the chunks of the keys written by ops, except those of the large values
+// they set and those of writes started after cutoff, which is an ObjectID generated before ops were
+// written.
+func (db *MongoDB) deleteStaleChunks(ctx context.Context, ops []mongoWriteOp, cutoff primitive.ObjectID) error {
+	if len(ops) == 0 || !db.mayHaveLargeValues(ctx) {
+		return nil
+	}
+	for start := 0; start < len(ops); start += mongoBatchChunkSize {
+		chunk := ops[start:min(start+mongoBatchChunkSize, len(ops))]
+		keys := make(bson.A, len(chunk))
+		gens := bson.A{}
+		for i, op := range chunk {
+			keys[i] = MongoKeyID(op.key)
+			if op.large != nil {
+				gens = append(gens, op.large.Gen)
+			}
+		}
+		filter := bson.D{
+			{Key: "key", Value: bson.D{{Key: "$in", Value: keys}}},
+			{Key: "gen", Value: bson.D{{Key: "$lt", Value: cutoff}, {Key: "$nin", Value: gens}}},
+		}
+		if _, err := mongoChunks(db.coll()).DeleteMany(ctx, filter); err != nil {
+			return db.wrapWriteErrorContext(ctx, "delete", err)
+		}
+	}
+	return nil
+}
+
+// deleteChunkRange removes the chunks of the keys in [start, end), see DeleteRange.
+func (db *MongoDB) deleteChunkRange(ctx context.Context, start, end []byte) error {
+	if !db.mayHaveLargeValues(ctx) {
+		return nil
+	}
+	bounds := bson.D{}
+	if start != nil {
+		bounds = append(bounds, bson.E{Key: "$gte", Value: MongoKeyID(start)})
+	}
+	if end != nil {
+		bounds = append(bounds, bson.E{Key: "$lt", Value: MongoKeyID(end)})
+	}
+	filter := bson.D{}
+	if len(bounds) > 0 {
+		filter = bson.D{{Key: "key", Value: bounds}}
+	}
+	_, err := mongoChunks(db.coll()).DeleteMany(ctx, filter)
+	return db.wrapWriteErrorContext(ctx, "delete", err)
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoLargeValueThreshold(t *testing.T) {
+	n, err := mongoLargeValueThreshold(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, mongoDefaultLargeValueThreshold, n)
+	n, err = mongoLargeValueThreshold(Options{mongoOptionLargeValueThreshold: "0"})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	n, err = mongoLargeValueThreshold(Options{mongoOptionLargeValueThreshold: "1048576"})
+	require.NoError(t, err)
+	assert.Equal(t, 1<<20, n)
+
+	for _, s := range []string{"-1", "16777216", "large"} {
+		_, err := mongoLargeValueThreshold(Options{mongoOptionLargeValueThreshold: s})
+		assert.ErrorContains(t, err, mongoOptionLargeValueThreshold, s)
+	}
+
+	db := NewMongoDB(nil)
+	assert.Equal(t, mongoDefaultLargeValueThreshold, db.largeValueThreshold)
+	require.NoError(t, db.SetLargeValueThreshold(0))
+	assert.Error(t, db.SetLargeValueThreshold(-1))
+	assert.Error(t, db.SetLargeValueThreshold(mongoMaxValueSize+1))
+}
+
+func TestMongoLargeValueChunks(t *testing.T) {
+	for _, tc := range []struct{ size, threshold, chunks, chunkSize int }{
+		{size: 11, threshold: 10, chunks: 2, chunkSize: 6},
+		{size: 20, threshold: 10, chunks: 2, chunkSize: 10},
+		{size: 21, threshold: 10, chunks: 3, chunkSize: 7},
+		{size: 40 << 20, threshold: 15 << 20, chunks: 3, chunkSize: 40<<20/3 + 1},
+	} {
+		large := newMongoLargeValue(tc.size, tc.threshold)
+		assert.Equal(t, tc.chunks, large.Chunks, tc)
+		assert.Equal(t, tc.chunkSize, large.chunkSize(), tc)
+		assert.LessOrEqual(t, large.chunkSize(), tc.threshold, tc)
+		// The last chunk is not empty.
+		assert.Less(t, large.chunkSize()*(large.Chunks-1), tc.size, tc)
+	}
+
+	// The IDs of the chunks of a value sort in order of their index.
+	gen := primitive.NewObjectID()
+	assert.Less(t, mongoChunkID(gen, 9), mongoChunkID(gen, 10))
+	assert.Less(t, mongoChunkID(gen, 255), mongoChunkID(gen, 256))
+}
+
+func TestMongoLargeSetUpdate(t *testing.T) {
+	large := newMongoLargeValue(100, 10)
+	filter, update := mongoLargeSetUpdate(defaultRecordCodec{}, bz("key"), large, true)
+	require.Equal(t, mongoKeyFilter(bz("key")), filter)
+	require.Equal(t, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "value", Value: []byte{}}, {Key: mongoLargeValueField, Value: large}}},
+		{Key: "$currentDate", Value: bson.D{{Key: "modifiedAt", Value: true}}},
+		{Key: "$unset", Value: bson.D{{Key: mongoExpireAtField, Value: ""}}},
+	}, update)
+
+	// The marker round trips through the document.
+	raw, err := bson.Marshal(update[0].Value)
+	require.NoError(t, err)
+	got, err := mongoLookupLargeValue(raw)
+	require.NoError(t, err)
+	assert.Equal(t, large, got)
+
+	raw, err = bson.Marshal(bson.D{{Key: "value", Value: bz("small")}})
+	require.NoError(t, err)
+	got, err = mongoLookupLargeValue(raw)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	raw, err = bson.Marshal(bson.D{{Key: mongoLargeValueField, Value: bson.D{{Key: "chunks", Value: 0}}}})
+	require.NoError(t, err)
+	_, err = mongoLookupLargeValue(raw)
+	assert.ErrorContains(t, err, "invalid document")
+}
+
+func TestMongoLargeValue(t *testing.T) {
+	db := NewMongoDB(nil)
+	require.NoError(t, db.SetLargeValueThreshold(10))
+	large, err := db.largeValue(make([]byte, 10))
+	require.NoError(t, err)
+	assert.Nil(t, large)
+	large, err = db.largeValue(make([]byte, 11))
+	require.NoError(t, err)
+	require.NotNil(t, large)
+	assert.Equal(t, 2, large.Chunks)
+
+	// Without chunking, values which do not fit in a document are rejected before any write.
+	require.NoError(t, db.SetLargeValueThreshold(0))
+	_, err = db.largeValue(make([]byte, mongoMaxValueSize+1))
+	assert.Equal(t, ErrValueTooLarge{Size: mongoMaxValueSize + 1, Max: mongoMaxValueSize}, err)
+	batch := newMongoDBBatch(db)
+	assert.Equal(t, err, batch.Set(bz("key"), make([]byte, mongoMaxValueSize+1)))
+	assert.Zero(t, batch.Count())
+
+	// Once the chunk collection was found empty, it is not probed again until a large value is
+	// written.
+	db.largeValuesProbed.Store(time.Now().UnixNano())
+	assert.False(t, db.mayHaveLargeValues(context.Background()))
+	db.largeValues.Store(true)
+	assert.True(t, db.mayHaveLargeValues(context.Background()))
+}
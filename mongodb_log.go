@@ -0,0 +1,35 @@
+package db
+
+// SetLogger sets the logger receiving the diagnostic output of the database: the filters of
+// iterators, reads exceeding their maximum query time, and the sizes of batches at debug level, and
+// failures to rebuild the client at error level. By default, and if l is nil, the database logs
+// nothing, and failures are only passed to the log hook, see SetLogHook.
+func (db *MongoDB) SetLogger(l Logger) {
+	if l == nil {
+		db.logger.Store(nil)
+		return
+	}
+	db.logger.Store(&l)
+}
+
+// debugEnabled returns whether a logger is set. Callers check it before building expensive
+// arguments.
+func (db *MongoDB) debugEnabled() bool {
+	return db.logger.Load() != nil
+}
+
+// debugf passes a debug line to the logger, if any.
+func (db *MongoDB) debugf(format string, args ...any) {
+	if l := db.logger.Load(); l != nil {
+		(*l).Debugf(format, args...)
+	}
+}
+
+// errorf passes an error line to the logger if one is set, and to the log hook otherwise.
+func (db *MongoDB) errorf(format string, args ...any) {
+	if l := db.logger.Load(); l != nil {
+		(*l).Errorf(format, args...)
+		return
+	}
+	logf(format, args...)
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordingLogger records the lines it receives, prefixed with their level.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) {
+	l.record("debug: "+format, args...)
+}
+
+func (l *recordingLogger) Errorf(format string, args ...any) {
+	l.record("error: "+format, args...)
+}
+
+func (l *recordingLogger) record(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// captureStdout returns what fn writes to stdout.
+func captureStdout(t testing.TB, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	require.NoError(t, w.Close())
+	return <-out
+}
+
+func TestMongoLogger(t *testing.T) {
+	db := NewMongoDB(connectUnreachable(t).Database("testing").Collection("testing"))
+	defer db.Close()
+	var notices []string
+	SetLogHook(func(msg string) { notices = append(notices, msg) })
+	defer SetLogHook(nil)
+
+	// Without a logger, debug lines are discarded and errors go to the log hook.
+	expired := mongo.CommandError{Code: mongoCodeMaxTimeMSExpired, Name: "MaxTimeMSExpired"}
+	assert.Empty(t, captureStdout(t, func() {
+		_ = db.wrapReadError(expired, time.Second)
+		_, _ = db.Iterator(nil, nil)
+		db.errorf("failed: %d", 1)
+	}))
+	assert.Equal(t, []string{"failed: 1"}, notices)
+	assert.False(t, db.debugEnabled())
+
+	logger := &recordingLogger{}
+	db.SetLogger(logger)
+	assert.True(t, db.debugEnabled())
+	_ = db.wrapReadError(expired, time.Second)
+	db.errorf("failed: %d", 2)
+	lines := logger.Lines()
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `debug: mongodb query on collection "testing" exceeded the maximum query time of 1s`)
+	assert.Equal(t, "error: failed: 2", lines[1])
+	assert.Len(t, notices, 1)
+
+	// Iterators log their filter, and batches their size.
+	_, err := db.Iterator([]byte("a"), []byte("b"))
+	require.Error(t, err)
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte("1")))
+	require.NoError(t, batch.Delete([]byte("b")))
+	require.Error(t, batch.Write())
+	lines = logger.Lines()
+	require.GreaterOrEqual(t, len(lines), 4)
+	assert.Equal(t, `debug: mongodb: iterating testing with filter [{$and [[{_id [{$gte 61}]}] [{_id [{$lt 62}]}]]}], reverse false`, lines[2])
+	assert.Equal(t, "debug: mongodb: writing batch of 2 operations to testing", lines[3])
+
+	db.SetLogger(nil)
+	assert.False(t, db.debugEnabled())
+	db.debugf("discarded")
+	assert.Len(t, logger.Lines(), len(lines))
+}
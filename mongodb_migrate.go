@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoMigrationsSuffix is appended to the collection name to name the collection holding the
+// migration bookkeeping documents.
+const mongoMigrationsSuffix = ".migrations"
+
+// IDs of the migration bookkeeping documents.
+const (
+	migrationAppliedID = "applied"
+	migrationLockID    = "lock"
+)
+
+// migrationLockTTL is how long a migration lock is held without being refreshed before another
+// runner may take it over, e.g. after the holder crashed. The lock is refreshed after every
+// migration, so a single migration must not take longer than this.
+const migrationLockTTL = 10 * time.Minute
+
+// Migration is a one-time change to a MongoDB database, such as rewriting documents to a new
+// layout. Migrations are identified by their ID, which must never change once released.
+type Migration struct {
+	// ID uniquely identifies the migration.
+	ID string
+	// Up applies the migration. It should be idempotent, since it is run again if the runner
+	// crashes before the migration is recorded as applied.
+	Up func(ctx context.Context, db *MongoDB) error
+}
+
+// ErrMigrationLocked is returned by RunMigrations when another runner holds the migration lock.
+type ErrMigrationLocked struct {
+	// Owner identifies the runner holding the lock.
+	Owner string
+	// ExpiresAt is the time at which the lock may be taken over, unless it is refreshed.
+	ExpiresAt time.Time
+}
+
+// Error implements error.
+func (e *ErrMigrationLocked) Error() string {
+	return fmt.Sprintf("migrations are locked by %s until %v", e.Owner, e.ExpiresAt)
+}
+
+// migrationLock is the lock document.
+type migrationLock struct {
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// RunMigrations runs the given migrations which have not been applied to db yet, in order, and
+// returns the IDs of the migrations it applied. Applied migrations are recorded in a metadata
+// collection next to the collection of db. If a migration fails, no further migrations are run,
+// and the failed migration is retried by the next call.
+//
+// Only one runner can migrate a collection at a time: if another runner holds the lock, an
+// *ErrMigrationLocked is returned. A lock which has not been refreshed for migrationLockTTL is
+// considered stale and taken over.
+func RunMigrations(ctx context.Context, db *MongoDB, migs []Migration) (applied []string, err error) {
+	seen := make(map[string]bool, len(migs))
+	for _, mig := range migs {
+		if mig.ID == "" {
+			return nil, errors.New("migration ID cannot be empty")
+		}
+		if seen[mig.ID] {
+			return nil, fmt.Errorf("duplicate migration ID %q", mig.ID)
+		}
+		seen[mig.ID] = true
+	}
+
+	meta := db.coll().Database().Collection(db.coll().Name() + mongoMigrationsSuffix)
+	owner := migrationOwner()
+	if err := acquireMigrationLock(ctx, meta, owner); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// The lock is released even if ctx is cancelled, so it does not have to expire.
+		_, releaseErr := meta.DeleteOne(context.Background(),
+			bson.D{{Key: "_id", Value: migrationLockID}, {Key: "owner", Value: owner}})
+		if err == nil {
+			err = releaseErr
+		}
+	}()
+
+	done, err := appliedMigrations(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mig := range migs {
+		if done[mig.ID] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return applied, err
+		}
+		if err := mig.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %q failed: %w", mig.ID, err)
+		}
+
+		_, err := meta.UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: migrationAppliedID}},
+			bson.D{{Key: "$push", Value: bson.D{{Key: "ids", Value: mig.ID}}}},
+			mongoOptions.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return applied, fmt.Errorf("failed to record migration %q: %w", mig.ID, err)
+		}
+		applied = append(applied, mig.ID)
+
+		if err := acquireMigrationLock(ctx, meta, owner); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// migrationOwner returns a unique identifier for a migration runner.
+func migrationOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// acquireMigrationLock takes or refreshes the migration lock for owner. The lock document is only
+// matched if it is held by owner or stale, otherwise the upsert fails with a duplicate key error.
+func acquireMigrationLock(ctx context.Context, meta *mongo.Collection, owner string) error {
+	now := time.Now()
+	filter := bson.D{
+		{Key: "_id", Value: migrationLockID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "owner", Value: owner}},
+			bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: migrationLock{Owner: owner, ExpiresAt: now.Add(migrationLockTTL)}}}
+	_, err := meta.UpdateOne(ctx, filter, update, mongoOptions.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	var lock migrationLock
+	err = meta.FindOne(ctx, bson.D{{Key: "_id", Value: migrationLockID}}).Decode(&lock)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The lock was released in the meantime.
+		return acquireMigrationLock(ctx, meta, owner)
+	} else if err != nil {
+		return err
+	}
+	return &ErrMigrationLocked{Owner: lock.Owner, ExpiresAt: lock.ExpiresAt}
+}
+
+// appliedMigrations returns the IDs of the migrations recorded as applied.
+func appliedMigrations(ctx context.Context, meta *mongo.Collection) (map[string]bool, error) {
+	var doc struct {
+		IDs []string `bson:"ids"`
+	}
+	err := meta.FindOne(ctx, bson.D{{Key: "_id", Value: migrationAppliedID}}).Decode(&doc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	done := make(map[string]bool, len(doc.IDs))
+	for _, id := range doc.IDs {
+		done[id] = true
+	}
+	return done, nil
+}
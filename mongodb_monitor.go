@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+// mongoOptionMonitorDriver enables a MongoDriverMonitor on the client created from the options
+// when set to true.
+const mongoOptionMonitorDriver = "monitor_driver"
+
+// Pool event labels of MongoDriverMonitor.
+const (
+	mongoPoolEventCheckout          = "checkout"
+	mongoPoolEventCheckoutFailure   = "checkout_failure"
+	mongoPoolEventConnectionCreated = "connection_created"
+	mongoPoolEventConnectionClosed  = "connection_closed"
+)
+
+// mongoReadCommands are the commands issued by Get, Has, iterators and other reads, whose servers
+// are recorded by MongoDriverMonitor.
+var mongoReadCommands = map[string]bool{
+	"find":      true,
+	"getMore":   true,
+	"aggregate": true,
+	"count":     true,
+}
+
+// mongoAnyTagSet labels the empty tag set, which matches any member, in the read tag set stats.
+const mongoAnyTagSet = "any"
+
+// MongoDriverMonitor aggregates the connection pool and command events of a MongoDB client, to
+// tell server-side latency apart from connection pool exhaustion. Its counters are reported in
+// the Stats of the databases using it, and in the Prometheus metrics (see SetMeterRegistry).
+//
+// Monitors must be installed when the client is constructed. Databases created with the
+// monitor_driver option install one on the client they own; clients constructed elsewhere can
+// install one with Install, and pass it to their databases with MongoDB.SetDriverMonitor.
+type MongoDriverMonitor struct {
+	mtx sync.Mutex
+	// pool counts pool events by event and, for failures and closed connections, reason.
+	pool map[string]int64
+	// commands and commandFailures count commands by name, and commandTime is their total
+	// duration by name.
+	commands        map[string]int64
+	commandFailures map[string]int64
+	commandTime     map[string]time.Duration
+	// serverTags holds the tags of the replica set members by address, and reads counts the
+	// successful read commands by the address of the member serving them. lastRead is the
+	// address of the member which served the most recent read.
+	serverTags map[string]tag.Set
+	reads      map[string]int64
+	lastRead   string
+}
+
+// NewMongoDriverMonitor creates a new monitor.
+func NewMongoDriverMonitor() *MongoDriverMonitor {
+	return &MongoDriverMonitor{
+		pool:            make(map[string]int64),
+		commands:        make(map[string]int64),
+		commandFailures: make(map[string]int64),
+		commandTime:     make(map[string]time.Duration),
+		serverTags:      make(map[string]tag.Set),
+		reads:           make(map[string]int64),
+	}
+}
+
+// mongoMonitorDriver returns whether the monitor_driver option is set.
+func mongoMonitorDriver(options Options) (bool, error) {
+	s, ok := options[mongoOptionMonitorDriver]
+	if !ok {
+		return false, nil
+	}
+	monitor, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", mongoOptionMonitorDriver, s, err)
+	}
+	return monitor, nil
+}
+
+// Install sets the pool, command and server monitors of opts to those of the monitor, replacing
+// any existing ones.
+func (m *MongoDriverMonitor) Install(opts *mongoOptions.ClientOptions) *mongoOptions.ClientOptions {
+	return opts.SetPoolMonitor(m.PoolMonitor()).SetMonitor(m.CommandMonitor()).SetServerMonitor(m.ServerMonitor())
+}
+
+// PoolMonitor returns a connection pool monitor feeding the monitor.
+func (m *MongoDriverMonitor) PoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: m.poolEvent}
+}
+
+// CommandMonitor returns a command monitor feeding the monitor.
+func (m *MongoDriverMonitor) CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.commandFinished(e.CommandFinishedEvent, false)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.commandFinished(e.CommandFinishedEvent, true)
+		},
+	}
+}
+
+// ServerMonitor returns a server monitor feeding the monitor with the tags of the replica set
+// members.
+func (m *MongoDriverMonitor) ServerMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		ServerDescriptionChanged: func(e *event.ServerDescriptionChangedEvent) {
+			m.mtx.Lock()
+			defer m.mtx.Unlock()
+			m.serverTags[e.Address.String()] = e.NewDescription.Tags
+		},
+	}
+}
+
+func (m *MongoDriverMonitor) poolEvent(e *event.PoolEvent) {
+	var name, reason string
+	switch e.Type {
+	case event.GetSucceeded:
+		name = mongoPoolEventCheckout
+	case event.GetFailed:
+		name, reason = mongoPoolEventCheckoutFailure, e.Reason
+	case event.ConnectionCreated:
+		name = mongoPoolEventConnectionCreated
+	case event.ConnectionClosed:
+		name, reason = mongoPoolEventConnectionClosed, e.Reason
+	default:
+		return
+	}
+
+	metrics.MongoPoolEvents.WithLabelValues(name, reason).Inc()
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.pool[name]++
+	if reason != "" {
+		m.pool[name+"."+reason]++
+	}
+}
+
+func (m *MongoDriverMonitor) commandFinished(e event.CommandFinishedEvent, failed bool) {
+	status := "succeeded"
+	if failed {
+		status = "failed"
+	}
+	metrics.MongoCommands.WithLabelValues(e.CommandName, status).Inc()
+	metrics.MongoCommandDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.commands[e.CommandName]++
+	if failed {
+		m.commandFailures[e.CommandName]++
+	}
+	m.commandTime[e.CommandName] += e.Duration
+	if !failed && mongoReadCommands[e.CommandName] {
+		addr := connectionAddress(e.ConnectionID)
+		m.reads[addr]++
+		m.lastRead = addr
+	}
+}
+
+// connectionAddress returns the server address of a driver connection ID, which has the form
+// <address>[-<n>].
+func connectionAddress(connectionID string) string {
+	if i := strings.LastIndex(connectionID, "[-"); i >= 0 {
+		return connectionID[:i]
+	}
+	return connectionID
+}
+
+// Stats returns the counters of the monitor, keyed by driver.pool.<event>[.<reason>],
+// driver.commands.<name>, driver.command_failures.<name> and driver.command_seconds.<name>.
+func (m *MongoDriverMonitor) Stats() map[string]string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	stats := make(map[string]string, len(m.pool)+2*len(m.commands)+len(m.commandFailures))
+	for name, n := range m.pool {
+		stats["driver.pool."+name] = strconv.FormatInt(n, 10)
+	}
+	for name, n := range m.commands {
+		stats["driver.commands."+name] = strconv.FormatInt(n, 10)
+		stats["driver.command_seconds."+name] = strconv.FormatFloat(m.commandTime[name].Seconds(), 'f', -1, 64)
+	}
+	for name, n := range m.commandFailures {
+		stats["driver.command_failures."+name] = strconv.FormatInt(n, 10)
+	}
+	return stats
+}
+
+// readTagSetStats attributes the reads served by each member to the first of the given tag sets
+// which the tags of the member match, which is the tag set the driver selected the member with. The
+// counts are keyed by driver.reads.tag_set.<tag set>, where the tag set is formatted as
+// name=value,... or as "any" for the empty tag set, and driver.reads.last_tag_set is the tag set
+// which served the most recent read. Reads served by members matching none of the tag sets are
+// keyed by driver.reads.tag_set.none.
+func (m *MongoDriverMonitor) readTagSetStats(sets []tag.Set) map[string]string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	label := func(addr string) string {
+		for _, set := range sets {
+			if m.serverTags[addr].ContainsAll(set) {
+				if len(set) == 0 {
+					return mongoAnyTagSet
+				}
+				return set.String()
+			}
+		}
+		return "none"
+	}
+	reads := make(map[string]int64)
+	for addr, n := range m.reads {
+		reads[label(addr)] += n
+	}
+	stats := make(map[string]string, len(reads)+1)
+	for name, n := range reads {
+		stats["driver.reads.tag_set."+name] = strconv.FormatInt(n, 10)
+	}
+	if m.lastRead != "" {
+		stats["driver.reads.last_tag_set"] = label(m.lastRead)
+	}
+	return stats
+}
+
+// SetDriverMonitor makes Stats include the counters of the given monitor, which must be installed
+// on the client of the database.
+func (db *MongoDB) SetDriverMonitor(m *MongoDriverMonitor) {
+	db.driverMonitor = m
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// The documents of the most frequent operations are built as raw BSON, appending the key and value
+// to precomputed document layouts, since marshaling bson.D by reflection is a measurable cost at
+// high operation rates. The raw documents are identical to the marshaled ones, see
+// mongoKeyFilter and defaultRecordCodec.
+
+// mongoMaxPooledDocBuffer is the capacity above which document buffers are not reused, so that
+// large values do not stay referenced by the pool.
+const mongoMaxPooledDocBuffer = 64 << 10
+
+// mongoDocBuffers holds buffers for the documents of single operations, which are no longer
+// referenced once the operation returns.
+var mongoDocBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// getMongoDocBuffer returns an empty buffer, which must be returned with putMongoDocBuffer once
+// the documents built in it are no longer used.
+func getMongoDocBuffer() *[]byte {
+	buf := mongoDocBuffers.Get().(*[]byte)
+	*buf = (*buf)[:0]
+	return buf
+}
+
+// putMongoDocBuffer returns a buffer to the pool.
+func putMongoDocBuffer(buf *[]byte) {
+	if cap(*buf) <= mongoMaxPooledDocBuffer {
+		mongoDocBuffers.Put(buf)
+	}
+}
+
+// appendMongoKeyFilter appends mongoKeyFilter(key) as raw BSON to dst.
+func appendMongoKeyFilter(dst []byte, key []byte) []byte {
+	idx, dst := bsoncore.AppendDocumentStart(dst)
+	dst = appendMongoKeyID(dst, key)
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst
+}
+
+// appendMongoUnexpiredKeyFilter appends mongoUnexpiredFilter(mongoKeyFilter(key)) as raw BSON to
+// dst.
+func appendMongoUnexpiredKeyFilter(dst []byte, key []byte) []byte {
+	idx, dst := bsoncore.AppendDocumentStart(dst)
+	dst = appendMongoKeyID(dst, key)
+	dst = append(dst, mongoRawUnexpiredCondition...)
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst
+}
+
+// appendMongoKeyID appends the _id element of key, see MongoKeyID, as raw BSON to dst.
+func appendMongoKeyID(dst []byte, key []byte) []byte {
+	dst = bsoncore.AppendHeader(dst, bsontype.String, "_id")
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(hex.EncodedLen(len(key))+1))
+	n := len(dst)
+	dst = append(dst, make([]byte, hex.EncodedLen(len(key)))...)
+	hex.Encode(dst[n:], key)
+	return append(dst, 0)
+}
+
+// appendMongoSetUpdate appends the update document of defaultRecordCodec, extended as by
+// mongoSetUpdate, as raw BSON to dst.
+func appendMongoSetUpdate(dst []byte, value []byte, trackTimestamps bool) []byte {
+	idx, dst := bsoncore.AppendDocumentStart(dst)
+	setIdx, dst := bsoncore.AppendDocumentElementStart(dst, "$set")
+	dst = bsoncore.AppendBinaryElement(dst, "value", bsontype.BinaryGeneric, value)
+	dst, _ = bsoncore.AppendDocumentEnd(dst, setIdx)
+	if trackTimestamps {
+		dateIdx, d := bsoncore.AppendDocumentElementStart(dst, "$currentDate")
+		d = bsoncore.AppendBooleanElement(d, "modifiedAt", true)
+		dst, _ = bsoncore.AppendDocumentEnd(d, dateIdx)
+	}
+	unsetIdx, dst := bsoncore.AppendDocumentElementStart(dst, "$unset")
+	dst = bsoncore.AppendStringElement(dst, mongoExpireAtField, "")
+	dst = bsoncore.AppendStringElement(dst, mongoLargeValueField, "")
+	dst, _ = bsoncore.AppendDocumentEnd(dst, unsetIdx)
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst
+}
+
+// mongoRawKeyFilter returns mongoKeyFilter(key) as raw BSON in a new buffer.
+func mongoRawKeyFilter(key []byte) bson.Raw {
+	return appendMongoKeyFilter(make([]byte, 0, hex.EncodedLen(len(key))+15), key)
+}
+
+// mongoSetDocuments returns the filter and update documents of mongoSetUpdate, built in buf if it
+// is not nil and as raw BSON when codec is the default one. The documents are only valid until buf
+// is reused.
+func mongoSetDocuments(
+	buf *[]byte, codec RecordCodec, key, value []byte, trackTimestamps bool,
+) (filter, update interface{}) {
+	if _, ok := codec.(defaultRecordCodec); !ok {
+		return mongoSetUpdate(codec, key, value, trackTimestamps)
+	}
+	var doc []byte
+	if buf != nil {
+		doc = (*buf)[:0]
+	} else {
+		doc = make([]byte, 0, hex.EncodedLen(len(key))+len(value)+80)
+	}
+	doc = appendMongoKeyFilter(doc, key)
+	n := len(doc)
+	doc = appendMongoSetUpdate(doc, value, trackTimestamps)
+	if buf != nil {
+		*buf = doc
+	}
+	return bson.Raw(doc[:n:n]), bson.Raw(doc[n:])
+}
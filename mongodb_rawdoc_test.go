@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rawDocumentKeys are keys whose raw documents must match the marshaled ones, including keys which
+// are not valid UTF-8 or contain NUL bytes.
+var rawDocumentKeys = [][]byte{
+	bz("a"),
+	{0x00},
+	{0xff, 0xfe, 0x80},
+	bz("with\x00nul"),
+	bz("héllo"),
+	[]byte(randStr(1000)),
+}
+
+// marshalDocument marshals a filter or update passed to the driver.
+func marshalDocument(t testing.TB, doc interface{}) bson.Raw {
+	if raw, ok := doc.(bson.Raw); ok {
+		return raw
+	}
+	bz, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return bz
+}
+
+func TestRawMongoDocuments(t *testing.T) {
+	buf := getMongoDocBuffer()
+	defer putMongoDocBuffer(buf)
+	for _, key := range rawDocumentKeys {
+		require.Equal(t, marshalDocument(t, mongoKeyFilter(key)), mongoRawKeyFilter(key), "key %X", key)
+		require.Equal(t, marshalDocument(t, mongoUnexpiredFilter(mongoKeyFilter(key))),
+			bson.Raw(appendMongoUnexpiredKeyFilter(nil, key)), "key %X", key)
+
+		for _, value := range [][]byte{{}, {0x00}, bz("value"), []byte(randStr(70000))} {
+			for _, trackTimestamps := range []bool{false, true} {
+				filter, update := mongoSetUpdate(defaultRecordCodec{}, key, value, trackTimestamps)
+				rawFilter, rawUpdate := mongoSetDocuments(buf, defaultRecordCodec{}, key, value, trackTimestamps)
+				require.Equal(t, marshalDocument(t, filter), rawFilter, "key %X", key)
+				require.Equal(t, marshalDocument(t, update), rawUpdate, "key %X", key)
+				require.NoError(t, rawFilter.(bson.Raw).Validate())
+				require.NoError(t, rawUpdate.(bson.Raw).Validate())
+
+				rawFilter, rawUpdate = mongoSetDocuments(nil, defaultRecordCodec{}, key, value, trackTimestamps)
+				require.Equal(t, marshalDocument(t, filter), rawFilter)
+				require.Equal(t, marshalDocument(t, update), rawUpdate)
+			}
+		}
+	}
+
+	// Other codecs build their own documents, which are only extended to clear the expiry time.
+	filter, update := mongoSetDocuments(nil, hexRecordCodec{}, bz("key"), bz("value"), false)
+	expectedFilter, expectedUpdate := hexRecordCodec{}.EncodeSet(bz("key"), bz("value"))
+	require.Equal(t, expectedFilter, filter)
+	require.Equal(t, append(expectedUpdate, bson.E{Key: "$unset", Value: bson.D{
+		{Key: mongoExpireAtField, Value: ""}, {Key: mongoLargeValueField, Value: ""},
+	}}), update)
+}
+
+func TestRawMongoWriteModels(t *testing.T) {
+	for _, key := range rawDocumentKeys {
+		for _, mode := range []mongoSetMode{mongoSetUpsert, mongoSetInsertOnly, mongoSetUpdateOnly} {
+			op := mongoWriteOp{key: key, value: bz("value"), mode: mode}
+			model := op.model(defaultRecordCodec{}, true).(*mongo.UpdateOneModel)
+			filter, update := mongoSetUpdate(defaultRecordCodec{}, key, op.value, true)
+			if mode == mongoSetInsertOnly {
+				filter = mongoWriteOnceFilter(filter)
+			}
+			require.Equal(t, marshalDocument(t, filter), marshalDocument(t, model.Filter))
+			require.Equal(t, marshalDocument(t, update), marshalDocument(t, model.Update))
+			require.Equal(t, mode != mongoSetUpdateOnly, model.Upsert != nil && *model.Upsert)
+		}
+
+		model := mongoWriteOp{key: key}.model(defaultRecordCodec{}, true).(*mongo.DeleteOneModel)
+		require.Equal(t, marshalDocument(t, mongoKeyFilter(key)), marshalDocument(t, model.Filter))
+	}
+}
+
+func BenchmarkMongoSetDocuments(b *testing.B) {
+	key, value := []byte(randStr(32)), []byte(randStr(100))
+	b.Run("bson.D", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			filter, update := mongoSetUpdate(defaultRecordCodec{}, key, value, false)
+			marshalDocument(b, filter)
+			marshalDocument(b, update)
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getMongoDocBuffer()
+			filter, update := mongoSetDocuments(buf, defaultRecordCodec{}, key, value, false)
+			marshalDocument(b, filter)
+			marshalDocument(b, update)
+			putMongoDocBuffer(buf)
+		}
+	})
+}
+
+func BenchmarkMongoKeyFilter(b *testing.B) {
+	key := []byte(randStr(32))
+	b.Run("bson.D", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			marshalDocument(b, mongoKeyFilter(key))
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getMongoDocBuffer()
+			*buf = appendMongoKeyFilter(*buf, key)
+			putMongoDocBuffer(buf)
+		}
+	})
+}
+
+// TestMongoKeyEncoding checks that keys, including NUL bytes and invalid UTF-8, are stored as
+// hex strings in key and range filters, and decoded back unchanged.
+func TestMongoKeyEncoding(t *testing.T) {
+	for _, key := range rawDocumentKeys {
+		id := marshalDocument(t, mongoKeyFilter(key)).Lookup("_id")
+		require.Equal(t, bsontype.String, id.Type)
+		assert.Equal(t, hex.EncodeToString(key), id.StringValue())
+
+		filter, err := mongoKeyRangeFilter(key, append(cp(key), 0xff))
+		require.NoError(t, err)
+		bounds := marshalDocument(t, filter).Lookup("$and").Array()
+		assert.Equal(t, MongoKeyID(key), bounds.Index(0).Value().Document().Lookup("_id", "$gte").StringValue())
+		assert.Equal(t, MongoKeyID(key)+"ff", bounds.Index(1).Value().Document().Lookup("_id", "$lt").StringValue())
+
+		raw := marshalDocument(t, bson.D{{Key: "_id", Value: MongoKeyID(key)}, {Key: "value", Value: []byte{0x00, 0xff}}})
+		decoded, value, _, err := defaultRecordCodec{}.Decode(raw)
+		require.NoError(t, err)
+		assert.Equal(t, key, decoded)
+		assert.Equal(t, []byte{0x00, 0xff}, value)
+	}
+
+	// Keys stored as binary data or as raw strings, as before keys were hex-encoded, are never
+	// matched by the filters, so they are rejected rather than decoded.
+	for _, id := range []interface{}{[]byte("key"), "key", "ABCD", "abc"} {
+		raw := marshalDocument(t, bson.D{{Key: "_id", Value: id}, {Key: "value", Value: []byte("value")}})
+		_, _, _, err := defaultRecordCodec{}.Decode(raw)
+		assert.Error(t, err, "_id %v", id)
+	}
+}
+
+// TestMongoKeyIDOrder checks that the _ids of keys sort like the keys, so that range filters and
+// sorted scans match the order of bytes.Compare.
+func TestMongoKeyIDOrder(t *testing.T) {
+	keys := [][]byte{{}, {0x00}, {0x00, 0x00}, {0x00, 0xff}, {0x01}, bz("a"), bz("ab"), {0x7f}, {0x80}, {0xff}, {0xff, 0x00}}
+	for i := range keys {
+		for j := range keys {
+			expected := bytes.Compare(keys[i], keys[j])
+			assert.Equal(t, expected, strings.Compare(MongoKeyID(keys[i]), MongoKeyID(keys[j])),
+				"keys %X and %X", keys[i], keys[j])
+		}
+	}
+}
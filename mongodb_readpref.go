@@ -0,0 +1,146 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+// Options controlling which MongoDB replica set members serve reads. Writes always target the
+// primary.
+const (
+	// mongoOptionReadMode is one of mongoReadModePrimary (default), mongoReadModeNearest or
+	// mongoReadModeSecondary.
+	mongoOptionReadMode = "read_mode"
+	// mongoOptionMaxStaleness is the maximum replication lag, in seconds, of a member serving
+	// reads. Only valid with the nearest and secondary read modes.
+	mongoOptionMaxStaleness = "max_staleness_seconds"
+	// mongoOptionReadTagSets is a JSON array of tag sets, e.g. [{"region":"eu-west-1"}], in order
+	// of preference. Reads are served by the members matching the first tag set which any
+	// available member matches, and by any member when none does. Only valid with the nearest and
+	// secondary read modes.
+	mongoOptionReadTagSets = "read_tag_sets"
+
+	mongoReadModePrimary   = "primary"
+	mongoReadModeNearest   = "nearest"
+	mongoReadModeSecondary = "secondary"
+
+	// mongoMinMaxStaleness is the smallest max staleness accepted by MongoDB servers.
+	mongoMinMaxStaleness = 90 * time.Second
+)
+
+// mongoReadPreference returns the read preference configured by the read_mode,
+// max_staleness_seconds and read_tag_sets options.
+func mongoReadPreference(options Options) (*readpref.ReadPref, error) {
+	var opts []readpref.Option
+	tagSets, err := mongoReadTagSets(options)
+	if err != nil {
+		return nil, err
+	}
+	if tagSets != nil {
+		opts = append(opts, readpref.WithTagSets(tagSets...))
+	}
+	if s, ok := options[mongoOptionMaxStaleness]; ok {
+		seconds, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", mongoOptionMaxStaleness, s, err)
+		}
+		maxStaleness := time.Duration(seconds) * time.Second
+		if maxStaleness < mongoMinMaxStaleness {
+			return nil, fmt.Errorf("invalid %s %d: must be at least %d",
+				mongoOptionMaxStaleness, seconds, int(mongoMinMaxStaleness.Seconds()))
+		}
+		opts = append(opts, readpref.WithMaxStaleness(maxStaleness))
+	}
+
+	switch mode := options[mongoOptionReadMode]; mode {
+	case "", mongoReadModePrimary:
+		if len(opts) > 0 {
+			return nil, fmt.Errorf("%s and %s cannot be used with %s %s", mongoOptionMaxStaleness,
+				mongoOptionReadTagSets, mongoOptionReadMode, mongoReadModePrimary)
+		}
+		return readpref.Primary(), nil
+	case mongoReadModeNearest:
+		return readpref.Nearest(opts...), nil
+	case mongoReadModeSecondary:
+		return readpref.Secondary(opts...), nil
+	default:
+		return nil, fmt.Errorf("invalid %s %q, expected one of %s, %s, %s", mongoOptionReadMode, mode,
+			mongoReadModePrimary, mongoReadModeNearest, mongoReadModeSecondary)
+	}
+}
+
+// mongoReadTagSets returns the tag sets configured by the read_tag_sets option, in order, followed
+// by an empty tag set matching any member, so that reads fall back to any member when no member
+// matches the configured tag sets. Returns nil if the option is not set.
+func mongoReadTagSets(options Options) ([]tag.Set, error) {
+	s, ok := options[mongoOptionReadTagSets]
+	if !ok {
+		return nil, nil
+	}
+	var maps []map[string]string
+	if err := json.Unmarshal([]byte(s), &maps); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", mongoOptionReadTagSets, s, err)
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("invalid %s %q: no tag sets", mongoOptionReadTagSets, s)
+	}
+
+	sets := make([]tag.Set, 0, len(maps)+1)
+	for i, m := range maps {
+		if len(m) == 0 {
+			// An empty tag set matches any member, so it can only be the last one, which is
+			// the fallback added anyway.
+			if i != len(maps)-1 {
+				return nil, fmt.Errorf("invalid %s %q: empty tag set %d must be last", mongoOptionReadTagSets, s, i)
+			}
+			break
+		}
+		set := make(tag.Set, 0, len(m))
+		for name, value := range m {
+			if name == "" {
+				return nil, fmt.Errorf("invalid %s %q: empty tag name in tag set %d", mongoOptionReadTagSets, s, i)
+			}
+			set = append(set, tag.Tag{Name: name, Value: value})
+		}
+		sort.Slice(set, func(i, j int) bool { return set[i].Name < set[j].Name })
+		sets = append(sets, set)
+	}
+	return append(sets, tag.Set{}), nil
+}
+
+// setReadPreference makes Get, Has and iterators read using the given read preference.
+func (db *MongoDB) setReadPreference(rp *readpref.ReadPref) {
+	db.clientMtx.Lock()
+	defer db.clientMtx.Unlock()
+
+	db.readPreference = rp
+	db.readCollection = mongoReadCollection(db.collection, rp)
+}
+
+// mongoReadCollection returns the collection with the given read preference, or the collection
+// itself if rp is nil.
+func mongoReadCollection(collection *mongo.Collection, rp *readpref.ReadPref) *mongo.Collection {
+	if rp == nil {
+		return collection
+	}
+	return collection.Database().Collection(
+		collection.Name(),
+		mongoOptions.Collection().SetReadPreference(rp),
+	)
+}
+
+// EffectiveReadPreference returns the read preference used for Get, Has and iterators. Returns nil
+// if the read preference of the collection given to NewMongoDB is used.
+func (db *MongoDB) EffectiveReadPreference() *readpref.ReadPref {
+	db.clientMtx.RLock()
+	defer db.clientMtx.RUnlock()
+	return db.readPreference
+}
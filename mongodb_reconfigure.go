@@ -0,0 +1,108 @@
+package db
+
+// mongoImmutableOptions are the options of NewDB which cannot be changed by Reconfigure, besides
+// the database and collection.
+var mongoImmutableOptions = append([]string{
+	"connection_string",
+	mongoOptionRecordCodec,
+	mongoOptionTrackTimestamps,
+	mongoOptionMonitorDriver,
+	mongoOptionClientTimeout,
+	mongoOptionReconnectThreshold,
+	mongoOptionRetryAttempts,
+	mongoOptionRetryBackoff,
+	mongoOptionLargeValueThreshold,
+	mongoOptionCloseTimeout,
+}, mongoClientConfigOptions...)
+
+// mongoReadPreferenceOptions are the options making up the read preference.
+var mongoReadPreferenceOptions = []string{mongoOptionReadMode, mongoOptionMaxStaleness, mongoOptionReadTagSets}
+
+var _ Reconfigurable = (*MongoDB)(nil)
+
+// Reconfigure implements Reconfigurable. The maximum query time (max_query_time_ms), the read
+// preference (read_mode, max_staleness_seconds and read_tag_sets), lenient_ranges and
+// iterator_prefetch can be changed, and apply to subsequent reads and iterators. The database, collection, connection string, record codec and
+// other client options cannot be changed.
+func (db *MongoDB) Reconfigure(opts Options) error {
+	db.reconfigureMtx.Lock()
+	defer db.reconfigureMtx.Unlock()
+
+	collection := db.coll()
+	current := map[string]string{
+		"database":   collection.Database().Name(),
+		"collection": collection.Name(),
+		"name":       collection.Name(),
+	}
+	for _, key := range mongoImmutableOptions {
+		current[key] = db.options[key]
+	}
+	if err := checkImmutableOptions(opts, current); err != nil {
+		return err
+	}
+
+	// Options are validated before any is applied.
+	var maxQueryTime *int64
+	if _, ok := opts[mongoOptionMaxQueryTime]; ok {
+		d, err := mongoMaxQueryTime(opts)
+		if err != nil {
+			return err
+		}
+		maxQueryTime = ptr(int64(d))
+	}
+	readPrefOptions := make(Options)
+	var changeReadPref bool
+	for _, key := range mongoReadPreferenceOptions {
+		if value, ok := opts[key]; ok {
+			readPrefOptions[key] = value
+			changeReadPref = true
+		} else if value, ok := db.options[key]; ok {
+			readPrefOptions[key] = value
+		}
+	}
+	rp, err := mongoReadPreference(readPrefOptions)
+	if err != nil {
+		return err
+	}
+	var lenient *bool
+	if _, ok := opts[optionLenientRanges]; ok {
+		l, err := lenientRanges(opts)
+		if err != nil {
+			return err
+		}
+		lenient = &l
+	}
+	var prefetch *int
+	if _, ok := opts[mongoOptionIteratorPrefetch]; ok {
+		n, err := mongoIteratorPrefetch(opts)
+		if err != nil {
+			return err
+		}
+		prefetch = &n
+	}
+
+	if maxQueryTime != nil {
+		db.maxQueryTime.Store(*maxQueryTime)
+	}
+	if changeReadPref {
+		db.setReadPreference(rp)
+	}
+	if lenient != nil {
+		db.lenientRanges.Store(*lenient)
+	}
+	if prefetch != nil {
+		db.SetIteratorPrefetch(*prefetch)
+	}
+
+	options := make(Options, len(db.options))
+	for key, value := range db.options {
+		options[key] = value
+	}
+	for _, key := range append(mongoReadPreferenceOptions, mongoOptionMaxQueryTime, optionLenientRanges, mongoOptionIteratorPrefetch) {
+		if value, ok := opts[key]; ok {
+			options[key] = value
+		}
+	}
+	db.options = options
+	return nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// mongoOptionReconnectThreshold is the number of consecutive fatal topology errors after which the
+// client is rebuilt, see mongoSupervisor. Zero, the default, never rebuilds the client. A collection
+// opened through a Provider rebuilds a client of its own, and leaves the shared client to the
+// Provider; it can no longer be written by cross batches of the Provider afterwards.
+const mongoOptionReconnectThreshold = "reconnect_threshold"
+
+// mongoRebuildTimeout bounds the creation of a new client.
+const mongoRebuildTimeout = 30 * time.Second
+
+// mongoClientFactory creates and connects a new client.
+type mongoClientFactory func(ctx context.Context) (*mongo.Client, error)
+
+// mongoReconnectThreshold returns the threshold configured by the reconnect_threshold option.
+func mongoReconnectThreshold(options Options) (int, error) {
+	s, ok := options[mongoOptionReconnectThreshold]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", mongoOptionReconnectThreshold, s, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s %d: must not be negative", mongoOptionReconnectThreshold, n)
+	}
+	return n, nil
+}
+
+// mongoOptionsClientFactory returns a factory connecting clients with the options of NewDB. The
+// connection string is applied anew for each client, so that SRV records are resolved again. The
+// clients are monitored by monitor, if set.
+func mongoOptionsClientFactory(options Options, monitor *MongoDriverMonitor) mongoClientFactory {
+	return func(ctx context.Context) (*mongo.Client, error) {
+		opts, _, err := mongoClientOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		if monitor != nil {
+			monitor.Install(opts)
+		}
+		return mongo.Connect(ctx, opts)
+	}
+}
+
+// mongoSupervisor counts the fatal topology errors of a MongoDB, and rebuilds its client when they
+// persist. When DNS records change, e.g. during Atlas maintenance, an existing client sometimes
+// never recovers, while a new one does.
+//
+// Once threshold errors occurred without a successful operation in between, a new client is created
+// in the background and swapped in. Operations in flight on the old client fail, and subsequent
+// ones use the new client. If the client cannot be created, the next fatal error tries again.
+type mongoSupervisor struct {
+	threshold int
+	factory   mongoClientFactory
+
+	// errors is the number of consecutive fatal errors.
+	errors     atomic.Int64
+	rebuilding atomic.Bool
+	rebuilds   atomic.Uint64
+	failures   atomic.Uint64
+}
+
+// superviseClient makes the database rebuild its client using factory after threshold consecutive
+// fatal topology errors.
+func (db *MongoDB) superviseClient(threshold int, factory mongoClientFactory) {
+	db.supervisor = &mongoSupervisor{threshold: threshold, factory: factory}
+}
+
+// isFatalTopologyError returns whether err means that no server could be reached, as opposed to
+// an error returned by a server.
+func isFatalTopologyError(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	return errors.As(err, &selectionErr) ||
+		errors.Is(err, topology.ErrServerSelectionTimeout) ||
+		errors.Is(err, mongo.ErrClientDisconnected) ||
+		mongo.IsNetworkError(err)
+}
+
+// unavailableError wraps err with ErrUnavailable if it is a fatal topology error, and returns it
+// unchanged otherwise.
+func unavailableError(err error) error {
+	if !isFatalTopologyError(err) || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// supervise records the outcome of an operation, and starts rebuilding the client once fatal
+// topology errors persist. A nil error, or any other error, resets the count.
+func (db *MongoDB) supervise(err error) {
+	s := db.supervisor
+	if s == nil {
+		return
+	}
+	if !isFatalTopologyError(err) {
+		s.errors.Store(0)
+		return
+	}
+	if s.errors.Add(1) < int64(s.threshold) || !s.rebuilding.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.rebuilding.Store(false)
+		if err := db.rebuildClient(); err != nil {
+			s.failures.Add(1)
+			db.errorf("mongodb: failed to rebuild client of collection %s: %v", db.coll().Name(), err)
+		}
+	}()
+}
+
+// rebuildClient creates a new client, swaps it in, and disconnects the old one.
+func (db *MongoDB) rebuildClient() error {
+	s := db.supervisor
+	ctx, cancel := context.WithTimeout(context.Background(), mongoRebuildTimeout)
+	defer cancel()
+	client, err := s.factory(ctx)
+	if err != nil {
+		return err
+	}
+
+	db.clientMtx.Lock()
+	if db.closed {
+		db.clientMtx.Unlock()
+		return mongoDisconnect(client, db.closeTimeout)
+	}
+	old := db.collection.Database().Client()
+	shared := db.sharedClient
+	db.sharedClient = false
+	db.collection = client.Database(db.collection.Database().Name()).Collection(db.collection.Name())
+	db.readCollection = mongoReadCollection(db.collection, db.readPreference)
+	if db.journal != nil {
+		db.journal = mongoJournal(db.collection)
+	}
+	db.clientMtx.Unlock()
+
+	s.errors.Store(0)
+	s.rebuilds.Add(1)
+	logf("mongodb: rebuilt client of collection %s after %d fatal topology errors", db.coll().Name(), s.threshold)
+	if !shared {
+		go func() { _ = mongoDisconnect(old, db.closeTimeout) }()
+	}
+	return nil
+}
+
+// stats returns the rebuild counters, for MongoDB.Stats.
+func (s *mongoSupervisor) stats() map[string]string {
+	return map[string]string{
+		"client.rebuilds":         strconv.FormatUint(s.rebuilds.Load(), 10),
+		"client.rebuild_failures": strconv.FormatUint(s.failures.Load(), 10),
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connectUnreachable connects a client to an address where no server listens, so that every
+// operation fails with a server selection error.
+func connectUnreachable(t *testing.T) *mongo.Client {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	return client
+}
+
+func TestClientSupervisorRebuild(t *testing.T) {
+	db := NewMongoDB(connectUnreachable(t).Database("testing").Collection("reconnect"))
+	db.TrackTimestamps()
+	defer db.Close()
+
+	rebuilt := connectUnreachable(t)
+	var calls int
+	db.superviseClient(2, func(ctx context.Context) (*mongo.Client, error) {
+		if calls++; calls == 1 {
+			return nil, errors.New("no such host")
+		}
+		return rebuilt, nil
+	})
+
+	// A single fatal error does not rebuild the client, and a successful operation resets the count.
+	err := db.Set([]byte("key"), []byte("value"))
+	require.True(t, isFatalTopologyError(err), err)
+	db.supervise(nil)
+	require.Error(t, db.Set([]byte("key"), []byte("value")))
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, calls)
+
+	// The first rebuild fails, and the next fatal error retries.
+	require.Error(t, db.Set([]byte("key"), []byte("value")))
+	require.Eventually(t, func() bool { return db.supervisor.failures.Load() == 1 && !db.supervisor.rebuilding.Load() },
+		time.Second, 5*time.Millisecond)
+	_, err = db.Get([]byte("key"))
+	require.Error(t, err)
+	require.Eventually(t, func() bool { return db.supervisor.rebuilds.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// The collections of the database now use the new client.
+	require.Same(t, rebuilt, db.coll().Database().Client())
+	require.Same(t, rebuilt, db.readColl().Database().Client())
+	require.Same(t, rebuilt, db.journalColl().Database().Client())
+	require.Equal(t, "reconnect", db.coll().Name())
+
+	stats := db.Stats()
+	require.Equal(t, "1", stats["client.rebuilds"])
+	require.Equal(t, "1", stats["client.rebuild_failures"])
+}
+
+func TestClientSupervisorSharedClient(t *testing.T) {
+	shared := connectUnreachable(t)
+	db := NewMongoDB(shared.Database("testing").Collection("reconnect"))
+	db.sharedClient = true
+	defer db.Close()
+
+	rebuilt := connectUnreachable(t)
+	db.superviseClient(1, func(ctx context.Context) (*mongo.Client, error) { return rebuilt, nil })
+	require.Error(t, db.Set([]byte("key"), []byte("value")))
+	require.Eventually(t, func() bool { return db.supervisor.rebuilds.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	// The shared client is left to its owner, and the rebuilt client is owned by the database.
+	require.Same(t, rebuilt, db.coll().Database().Client())
+	require.NoError(t, shared.Disconnect(context.Background()))
+	require.NoError(t, db.Close())
+	require.ErrorIs(t, rebuilt.Disconnect(context.Background()), mongo.ErrClientDisconnected)
+}
+
+func TestClientSupervisorOption(t *testing.T) {
+	_, err := mongoReconnectThreshold(Options{mongoOptionReconnectThreshold: "-1"})
+	require.Error(t, err)
+	_, err = mongoReconnectThreshold(Options{mongoOptionReconnectThreshold: "often"})
+	require.Error(t, err)
+	n, err := mongoReconnectThreshold(Options{mongoOptionReconnectThreshold: "5"})
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	require.False(t, isFatalTopologyError(nil))
+	require.False(t, isFatalTopologyError(mongo.CommandError{Code: 11000}))
+}
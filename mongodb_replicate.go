@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// replicateNamespace is the reserved key namespace used by Replicate in the destination database.
+const replicateNamespace = "replicate"
+
+// defaultReplicateBatchSize is the default of ReplicateOptions.BatchSize.
+const defaultReplicateBatchSize = 1000
+
+// errReplicationInvalidated is returned by Replicate when the source collection is dropped or
+// renamed, which ends its change stream.
+var errReplicationInvalidated = errors.New("replication source collection was dropped or renamed")
+
+// ReplicateOptions configures Replicate.
+type ReplicateOptions struct {
+	// BatchSize is the maximum number of keys copied or change events applied per batch written to
+	// the destination. Defaults to 1000.
+	BatchSize int
+
+	// Lag, if set, is called after each batch of change events is applied.
+	Lag func(ReplicationLag)
+}
+
+// ReplicationLag describes how far a Replicate call is behind its source. It is also exported in
+// the Prometheus metrics, see SetMeterRegistry.
+type ReplicationLag struct {
+	// EventsBehind is the number of change events which were already waiting when the last batch
+	// was read, up to the batch size. It stays close to zero while replication keeps up, and a
+	// value at the batch size means replication is falling behind.
+	EventsBehind int
+	// LastApplied is the time at which the last applied change was made on the source.
+	LastApplied time.Time
+}
+
+// replicateTokenKey is the reserved key under which Replicate stores the resume token of the
+// change stream in the destination database.
+func replicateTokenKey(src *MongoDB) []byte {
+	return reservedKey(replicateNamespace, []byte(src.coll().Database().Name()+"."+src.coll().Name()))
+}
+
+// Replicate copies all keys of src into dst, and then applies every change made to src to dst
+// until ctx is canceled or an error occurs. Changes are read from a MongoDB change stream, so the
+// source must be a replica set or sharded cluster.
+//
+// The position in the change stream is stored in dst under a reserved key, which is written in the
+// same batch as the changes it covers. A later call with the same databases resumes from there
+// without copying src again; if the position has fallen out of the oplog of src, an error is
+// returned and dst must be cleared before replicating again. The initial copy does not delete keys
+// which only exist in dst, so replication should start from an empty database.
+//
+// Changes are applied in the order they were made, which preserves the order of writes to each
+// key. Replicate returns ctx.Err() once ctx is canceled.
+func Replicate(ctx context.Context, src *MongoDB, dst DB, opts ReplicateOptions) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReplicateBatchSize
+	}
+	tokenKey := replicateTokenKey(src)
+
+	token, err := dst.Get(tokenKey)
+	if err != nil {
+		return err
+	}
+
+	// The change stream is opened before the initial copy, so that no change made during the copy
+	// is missed. Changes which are already included in the copy are applied again, which is
+	// harmless since events are applied in order.
+	streamOpts := mongoOptions.ChangeStream().SetFullDocument(mongoOptions.UpdateLookup)
+	if token != nil {
+		streamOpts.SetResumeAfter(bson.Raw(token))
+	}
+	stream, err := src.coll().Watch(ctx, mongo.Pipeline{}, streamOpts)
+	if err != nil {
+		return fmt.Errorf("failed to open change stream: %w", err)
+	}
+	defer stream.Close(context.Background())
+
+	if token == nil {
+		if err := replicateSnapshot(src, dst, opts.BatchSize); err != nil {
+			return fmt.Errorf("failed to copy snapshot: %w", err)
+		}
+		if err := dst.SetSync(tokenKey, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+
+	labels := []string{src.coll().Name()}
+	for {
+		if !stream.Next(ctx) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return stream.Err()
+		}
+		events := []bson.Raw{append(bson.Raw(nil), stream.Current...)}
+		for len(events) < opts.BatchSize && stream.TryNext(ctx) {
+			events = append(events, append(bson.Raw(nil), stream.Current...))
+		}
+		if err := stream.Err(); err != nil {
+			return err
+		}
+
+		lag := ReplicationLag{EventsBehind: len(events)}
+		metrics.ReplicationEventsBehind.WithLabelValues(labels...).Set(float64(lag.EventsBehind))
+
+		lastApplied, err := applyChangeEvents(src, dst, events, tokenKey, stream.ResumeToken())
+		if err != nil {
+			return err
+		}
+		if !lastApplied.IsZero() {
+			lag.LastApplied = lastApplied
+			metrics.ReplicationLastApplied.WithLabelValues(labels...).Set(float64(lastApplied.Unix()))
+		}
+		if opts.Lag != nil {
+			opts.Lag(lag)
+		}
+	}
+}
+
+// replicateSnapshot copies all keys of src into dst, in batches of batchSize keys.
+func replicateSnapshot(src *MongoDB, dst DB, batchSize int) error {
+	itr, err := src.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	batch := NewBatchWithSize(dst, batchSize)
+	defer func() { batch.Close() }()
+	var size int
+	for ; itr.Valid(); itr.Next() {
+		if err := batch.Set(itr.Key(), itr.Value()); err != nil {
+			return err
+		}
+		if size++; size == batchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Close()
+			batch, size = NewBatchWithSize(dst, batchSize), 0
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return err
+	}
+	return batch.WriteSync()
+}
+
+// changeEvent is the part of a change stream event used by Replicate.
+type changeEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// applyChangeEvents applies the changes of events to dst in a single batch, together with the
+// resume token following them, and returns the time of the last change.
+func applyChangeEvents(src *MongoDB, dst DB, events []bson.Raw, tokenKey []byte, token bson.Raw) (time.Time, error) {
+	batch := NewBatchWithSize(dst, len(events)+1)
+	defer batch.Close()
+
+	var lastApplied time.Time
+	for _, raw := range events {
+		var event changeEvent
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return time.Time{}, fmt.Errorf("failed to decode change event: %w", err)
+		}
+
+		key, err := mongoKeyFromID(event.DocumentKey.ID)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch event.OperationType {
+		case "insert", "update", "replace":
+			// The document is looked up when the event is read, and is missing if the key has been
+			// deleted since. The delete follows in a later event.
+			doc, ok := raw.Lookup("fullDocument").DocumentOK()
+			if !ok {
+				continue
+			}
+			record, err := src.decodeRecord(doc)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if err := batch.Set(key, record.Value); err != nil {
+				return time.Time{}, err
+			}
+		case "delete":
+			if err := batch.Delete(key); err != nil {
+				return time.Time{}, err
+			}
+		case "drop", "rename", "dropDatabase", "invalidate":
+			return time.Time{}, errReplicationInvalidated
+		default:
+			continue
+		}
+		lastApplied = time.Unix(int64(event.ClusterTime.T), 0)
+	}
+
+	if err := batch.Set(tokenKey, token); err != nil {
+		return time.Time{}, err
+	}
+	return lastApplied, batch.WriteSync()
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// changeEventRaw returns a change stream event as returned with the updateLookup option.
+func changeEventRaw(t *testing.T, op string, ts uint32, key string, value []byte) bson.Raw {
+	event := bson.D{
+		{Key: "operationType", Value: op},
+		{Key: "clusterTime", Value: primitive.Timestamp{T: ts}},
+		{Key: "documentKey", Value: bson.D{{Key: "_id", Value: MongoKeyID([]byte(key))}}},
+	}
+	if value != nil {
+		event = append(event, bson.E{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: MongoKeyID([]byte(key))}, {Key: "value", Value: value}}})
+	} else if op != "delete" {
+		event = append(event, bson.E{Key: "fullDocument", Value: nil})
+	}
+	raw, err := bson.Marshal(event)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestApplyChangeEvents(t *testing.T) {
+	src := NewMongoDB(nil)
+	dst := NewMemDB()
+	require.NoError(t, dst.Set([]byte("deleted"), []byte("value")))
+	tokenKey := reservedKey(replicateNamespace, []byte("testing.replicate"))
+
+	events := []bson.Raw{
+		changeEventRaw(t, "insert", 1, "a", []byte("1")),
+		changeEventRaw(t, "update", 2, "a", []byte("2")),
+		changeEventRaw(t, "replace", 3, "empty", []byte{}),
+		// The key was deleted before the document was looked up.
+		changeEventRaw(t, "update", 4, "gone", nil),
+		changeEventRaw(t, "delete", 5, "deleted", nil),
+		changeEventRaw(t, "createIndexes", 6, "", nil),
+	}
+	lastApplied, err := applyChangeEvents(src, dst, events, tokenKey, bson.Raw("token"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(5, 0), lastApplied)
+	assert.Equal(t, map[string]string{"a": "2", "empty": "", string(tokenKey): "token"}, collectAll(t, dst))
+
+	_, err = applyChangeEvents(src, dst, []bson.Raw{changeEventRaw(t, "drop", 7, "", nil)}, tokenKey, nil)
+	assert.ErrorIs(t, err, errReplicationInvalidated)
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// mongoOptionRetryAttempts is the number of times reads and idempotent writes, such as Get,
+	// Has, Set and Delete, are retried when they fail with ErrUnavailable. Zero, the default, never
+	// retries.
+	mongoOptionRetryAttempts = "retry_attempts"
+	// mongoOptionRetryBackoff is the delay, in milliseconds, before the first retry, which doubles
+	// for every further retry up to mongoMaxRetryBackoff. Absent uses mongoDefaultRetryBackoff.
+	mongoOptionRetryBackoff = "retry_backoff_ms"
+)
+
+const (
+	// mongoDefaultRetryBackoff is the delay before the first retry, see mongoOptionRetryBackoff.
+	mongoDefaultRetryBackoff = 100 * time.Millisecond
+	// mongoMaxRetryBackoff bounds the delay between retries.
+	mongoMaxRetryBackoff = 5 * time.Second
+)
+
+// mongoRetryPolicy is the retry policy configured by the retry_attempts and retry_backoff_ms
+// options.
+type mongoRetryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// mongoRetryPolicyFromOptions returns the retry policy configured by options.
+func mongoRetryPolicyFromOptions(options Options) (mongoRetryPolicy, error) {
+	policy := mongoRetryPolicy{backoff: mongoDefaultRetryBackoff}
+	if s, ok := options[mongoOptionRetryAttempts]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s %q: %w", mongoOptionRetryAttempts, s, err)
+		}
+		if n < 0 {
+			return policy, fmt.Errorf("invalid %s %d: must not be negative", mongoOptionRetryAttempts, n)
+		}
+		policy.attempts = n
+	}
+	if s, ok := options[mongoOptionRetryBackoff]; ok {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s %q: %w", mongoOptionRetryBackoff, s, err)
+		}
+		if ms <= 0 {
+			return policy, fmt.Errorf("invalid %s %d: must be positive", mongoOptionRetryBackoff, ms)
+		}
+		policy.backoff = time.Duration(ms) * time.Millisecond
+	}
+	return policy, nil
+}
+
+// delay returns the delay before the retry following attempt failed attempts.
+func (p mongoRetryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < mongoMaxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, mongoMaxRetryBackoff)
+}
+
+// retry runs op, and runs it again after a backoff while it fails with ErrUnavailable, up to the
+// configured number of retries. It stops waiting once ctx is done, returning the last error.
+// Batches and iterators are not retried, as they may have partly succeeded, nor are SetIfAbsent
+// and DeleteStrict, whose result depends on whether an earlier attempt was applied.
+func (db *MongoDB) retry(ctx context.Context, op func() error) error {
+	err := op()
+	for attempt := 1; attempt <= db.retryPolicy.attempts && errors.Is(err, ErrUnavailable); attempt++ {
+		delay := db.retryPolicy.delay(attempt)
+		db.debugf("mongodb: retrying in %v after %v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		err = op()
+	}
+	return err
+}
+
+var _ Pinger = (*MongoDB)(nil)
+
+// Ping implements Pinger, pinging a server selected with the configured read preference, or the
+// read preference of the client if none is configured.
+func (db *MongoDB) Ping(ctx context.Context) error {
+	err := db.coll().Database().Client().Ping(ctx, db.EffectiveReadPreference())
+	if aborted := abortedError(ctx, "ping", err); aborted != nil {
+		return aborted
+	}
+	db.supervise(err)
+	return unavailableError(err)
+}
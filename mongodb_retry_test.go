@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMongoUnavailable(t *testing.T) {
+	db := NewMongoDB(connectUnreachable(t).Database("testing").Collection("unavailable"))
+	defer db.Close()
+
+	_, err := db.Get([]byte("key"))
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.True(t, isFatalTopologyError(err), err)
+	_, err = db.Has([]byte("key"))
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.ErrorIs(t, db.Set([]byte("key"), []byte("value")), ErrUnavailable)
+	require.ErrorIs(t, db.Delete([]byte("key")), ErrUnavailable)
+	require.ErrorIs(t, db.Ping(context.Background()), ErrUnavailable)
+	require.ErrorIs(t, Ping(context.Background(), NewPrefixDB(db, []byte("p/"))), ErrUnavailable)
+
+	// Canceled operations are aborted rather than unavailable.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var aborted *ErrAborted
+	require.ErrorAs(t, db.Ping(ctx), &aborted)
+
+	// Server errors are not wrapped.
+	require.NotErrorIs(t, unavailableError(mongo.CommandError{Code: 11000}), ErrUnavailable)
+	require.NoError(t, unavailableError(nil))
+
+	// Local databases have no server to ping.
+	require.NoError(t, Ping(context.Background(), NewMemDB()))
+}
+
+func TestMongoRetry(t *testing.T) {
+	db := NewMongoDB(&mongo.Collection{})
+	unavailable := func(calls *int, failures int) func() error {
+		return func() error {
+			if *calls++; *calls <= failures {
+				return unavailableError(mongo.ErrClientDisconnected)
+			}
+			return nil
+		}
+	}
+
+	// Retries are off by default.
+	var calls int
+	require.ErrorIs(t, db.retry(context.Background(), unavailable(&calls, 1)), ErrUnavailable)
+	require.Equal(t, 1, calls)
+
+	db.retryPolicy = mongoRetryPolicy{attempts: 3, backoff: time.Millisecond}
+	calls = 0
+	require.NoError(t, db.retry(context.Background(), unavailable(&calls, 3)))
+	require.Equal(t, 4, calls)
+	calls = 0
+	require.ErrorIs(t, db.retry(context.Background(), unavailable(&calls, 10)), ErrUnavailable)
+	require.Equal(t, 4, calls)
+
+	// Other errors are not retried.
+	calls = 0
+	other := errors.New("other")
+	require.Equal(t, other, db.retry(context.Background(), func() error { calls++; return other }))
+	require.Equal(t, 1, calls)
+
+	// Waiting stops once the context is done.
+	db.retryPolicy = mongoRetryPolicy{attempts: 3, backoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls = 0
+	require.ErrorIs(t, db.retry(ctx, unavailable(&calls, 10)), ErrUnavailable)
+	require.Equal(t, 1, calls)
+}
+
+func TestMongoRetryOperations(t *testing.T) {
+	db := NewMongoDB(connectUnreachable(t).Database("testing").Collection("retry"))
+	defer db.Close()
+	db.retryPolicy = mongoRetryPolicy{attempts: 1, backoff: time.Millisecond}
+	retries := func(op func() error) int {
+		logger := &recordingLogger{}
+		db.SetLogger(logger)
+		require.ErrorIs(t, op(), ErrUnavailable)
+		var n int
+		for _, line := range logger.Lines() {
+			if strings.Contains(line, "retrying") {
+				n++
+			}
+		}
+		return n
+	}
+
+	for name, op := range map[string]func() error{
+		"GetMany": func() error {
+			_, err := db.GetMany([][]byte{[]byte("key")})
+			return err
+		},
+		"SetUpdateOnly": func() error { return db.SetUpdateOnly([]byte("key"), []byte("value")) },
+		"DeleteRange": func() error {
+			_, err := db.DeleteRange(nil, nil)
+			return err
+		},
+		"DeleteKeys": func() error {
+			_, err := db.DeleteKeys([][]byte{[]byte("key")})
+			return err
+		},
+	} {
+		require.Equal(t, 1, retries(op), name)
+	}
+
+	// Conditional writes are not retried.
+	require.Zero(t, retries(func() error {
+		_, err := db.SetIfAbsent([]byte("key"), []byte("value"))
+		return err
+	}))
+	require.Zero(t, retries(func() error { return db.DeleteStrict([]byte("key")) }))
+}
+
+func TestMongoRetryPolicyOptions(t *testing.T) {
+	policy, err := mongoRetryPolicyFromOptions(Options{})
+	require.NoError(t, err)
+	require.Equal(t, mongoRetryPolicy{backoff: mongoDefaultRetryBackoff}, policy)
+
+	policy, err = mongoRetryPolicyFromOptions(Options{mongoOptionRetryAttempts: "4", mongoOptionRetryBackoff: "50"})
+	require.NoError(t, err)
+	require.Equal(t, mongoRetryPolicy{attempts: 4, backoff: 50 * time.Millisecond}, policy)
+	require.Equal(t, 50*time.Millisecond, policy.delay(1))
+	require.Equal(t, 100*time.Millisecond, policy.delay(2))
+	require.Equal(t, 200*time.Millisecond, policy.delay(3))
+	require.Equal(t, mongoMaxRetryBackoff, policy.delay(20))
+
+	for _, options := range []Options{
+		{mongoOptionRetryAttempts: "-1"},
+		{mongoOptionRetryAttempts: "twice"},
+		{mongoOptionRetryBackoff: "0"},
+		{mongoOptionRetryBackoff: "soon"},
+	} {
+		_, err := mongoRetryPolicyFromOptions(options)
+		require.Error(t, err, options)
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var _ PrefixSummarizer = (*MongoDB)(nil)
+
+// prefixSummary is the result of the PrefixSummary aggregation, with the _ids of the first and last
+// keys.
+type prefixSummary struct {
+	Count int64  `bson:"count"`
+	First string `bson:"first"`
+	Last  string `bson:"last"`
+}
+
+// PrefixSummary implements PrefixSummarizer with a single aggregation.
+func (db *MongoDB) PrefixSummary(prefix []byte) (int64, []byte, []byte, error) {
+	filter, err := mongoKeyRangeFilter(prefixDomain(prefix))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "first", Value: bson.D{{Key: "$min", Value: "$_id"}}},
+			{Key: "last", Value: bson.D{{Key: "$max", Value: "$_id"}}},
+		}}},
+	}
+	ctx, cancel := db.readContext(context.Background(), db.queryTime())
+	defer cancel()
+	cursor, err := db.readColl().Aggregate(ctx, pipeline, db.aggregateOptions())
+	if err != nil {
+		return 0, nil, nil, db.wrapReadError(err, db.queryTime())
+	}
+	defer cursor.Close(context.Background())
+
+	// No group is produced if no keys have the prefix.
+	if !cursor.Next(ctx) {
+		return 0, nil, nil, db.wrapReadError(cursor.Err(), db.queryTime())
+	}
+	var summary prefixSummary
+	if err := cursor.Decode(&summary); err != nil {
+		return 0, nil, nil, err
+	}
+	first, err := mongoKeyFromID(summary.First)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	last, err := mongoKeyFromID(summary.Last)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return summary.Count, first, last, nil
+}
+
+var _ ValueSizer = (*MongoDB)(nil)
+
+// ValueSize implements ValueSizer with an aggregation which projects only the value size.
+func (db *MongoDB) ValueSize(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, errKeyEmpty
+	}
+
+	// The projection relies on the document layout of the default codec.
+	if _, ok := db.codec.(defaultRecordCodec); !ok {
+		value, err := db.Get(key)
+		if err != nil {
+			return 0, err
+		} else if value == nil {
+			return -1, nil
+		}
+		return len(value), nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: mongoKeyFilter(key)}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "n", Value: bson.D{{Key: "$binarySize", Value: "$value"}}},
+		}}},
+	}
+	ctx, cancel := db.readContext(context.Background(), db.queryTime())
+	defer cancel()
+	cursor, err := db.readColl().Aggregate(ctx, pipeline, db.aggregateOptions())
+	if err != nil {
+		return 0, db.wrapReadError(err, db.queryTime())
+	}
+	defer cursor.Close(context.Background())
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return 0, db.wrapReadError(err, db.queryTime())
+		}
+		return -1, nil
+	}
+	var size struct {
+		N int `bson:"n"`
+	}
+	if err := cursor.Decode(&size); err != nil {
+		return 0, err
+	}
+	return size.N, nil
+}
+
+var _ KeySpaceAnalyzer = (*MongoDB)(nil)
+
+// keySpaceBucket is a bucket produced by the AnalyzeKeySpace aggregation, bounded by the _id of
+// its first key.
+type keySpaceBucket struct {
+	ID struct {
+		Min string `bson:"min"`
+	} `bson:"_id"`
+	Count int64 `bson:"count"`
+	Bytes int64 `bson:"bytes"`
+}
+
+// AnalyzeKeySpace implements KeySpaceAnalyzer with $sample and $bucketAuto, so that only the bucket
+// boundaries are transferred. The counts are scaled to the estimated document count of the
+// collection, which is read from its metadata.
+func (db *MongoDB) AnalyzeKeySpace(buckets int, sample int) (KeySpaceReport, error) {
+	// The size projection relies on the document layout of the default codec.
+	if _, ok := db.codec.(defaultRecordCodec); !ok {
+		return iterateKeySpace(db, buckets, sample)
+	}
+
+	ctx, cancel := db.readContext(context.Background(), db.queryTime())
+	defer cancel()
+	total, err := db.readColl().EstimatedDocumentCount(ctx)
+	if err != nil {
+		return KeySpaceReport{}, db.wrapReadError(err, db.queryTime())
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: sample}}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "size", Value: bson.D{{Key: "$add", Value: bson.A{
+				// The _id holds the key hex-encoded, see MongoKeyID.
+				bson.D{{Key: "$divide", Value: bson.A{bson.D{{Key: "$strLenBytes", Value: "$_id"}}, 2}}},
+				bson.D{{Key: "$binarySize", Value: "$value"}},
+			}}}},
+		}}},
+		{{Key: "$bucketAuto", Value: bson.D{
+			{Key: "groupBy", Value: "$_id"},
+			{Key: "buckets", Value: buckets},
+			{Key: "output", Value: bson.D{
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "bytes", Value: bson.D{{Key: "$sum", Value: "$size"}}},
+			}},
+		}}},
+	}
+	cursor, err := db.readColl().Aggregate(ctx, pipeline, db.aggregateOptions())
+	if err != nil {
+		return KeySpaceReport{}, db.wrapReadError(err, db.queryTime())
+	}
+	defer cursor.Close(context.Background())
+
+	var results []keySpaceBucket
+	if err := cursor.All(ctx, &results); err != nil {
+		return KeySpaceReport{}, db.wrapReadError(err, db.queryTime())
+	}
+
+	var report KeySpaceReport
+	for _, r := range results {
+		report.Sampled += int(r.Count)
+	}
+	if report.Sampled == 0 {
+		return report, nil
+	}
+	// The estimated count may lag behind the sample.
+	report.Keys = max(total, int64(report.Sampled))
+	scale := float64(report.Keys) / float64(report.Sampled)
+	report.Buckets = make([]KeySpaceBucket, len(results))
+	for i, r := range results {
+		b := &report.Buckets[i]
+		b.Keys = int64(float64(r.Count) * scale)
+		b.Bytes = int64(float64(r.Bytes) * scale)
+		report.Bytes += b.Bytes
+		if i > 0 {
+			start, err := mongoKeyFromID(r.ID.Min)
+			if err != nil {
+				return KeySpaceReport{}, err
+			}
+			b.Range.Start = start
+			report.Buckets[i-1].Range.End = start
+		}
+	}
+	return report, nil
+}
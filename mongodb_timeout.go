@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoOptionMaxQueryTime is the maximum server-side execution time, in milliseconds, of each
+// read query. Zero or absent means no limit.
+const mongoOptionMaxQueryTime = "max_query_time_ms"
+
+// mongoOptionClientTimeout is the client-side timeout, in milliseconds, of every operation of the
+// client, including writes. Zero or absent means no limit.
+const mongoOptionClientTimeout = "client_timeout_ms"
+
+// mongoOptionCloseTimeout is the time, in milliseconds, which Close waits for the client to
+// disconnect. Absent uses mongoDefaultCloseTimeout, and zero means no limit.
+const mongoOptionCloseTimeout = "close_timeout_ms"
+
+// mongoDefaultCloseTimeout is the default time which Close waits for the client to disconnect.
+const mongoDefaultCloseTimeout = 10 * time.Second
+
+// mongoCodeMaxTimeMSExpired is the MongoDB server error code for operations killed because they
+// exceeded their maxTimeMS.
+const mongoCodeMaxTimeMSExpired = 50
+
+// ErrQueryTimeout is returned by MongoDB reads which exceeded the maximum query time or the client
+// timeout, as opposed to e.g. connectivity failures.
+type ErrQueryTimeout struct {
+	// Collection is the name of the queried collection.
+	Collection string
+	// MaxTime is the maximum query time or client timeout which was exceeded, whichever is
+	// stricter.
+	MaxTime time.Duration
+	// Err is the underlying server or context error.
+	Err error
+}
+
+// Error implements error.
+func (e *ErrQueryTimeout) Error() string {
+	return fmt.Sprintf("mongodb query on collection %q exceeded the maximum query time of %v: %v",
+		e.Collection, e.MaxTime, e.Err)
+}
+
+// Unwrap returns the underlying server error.
+func (e *ErrQueryTimeout) Unwrap() error {
+	return e.Err
+}
+
+// IteratorOptions configures an iterator created by MongoDB.IteratorWithOptions.
+type IteratorOptions struct {
+	// Reverse iterates in descending order, like ReverseIterator.
+	Reverse bool
+	// MaxQueryTime overrides the maximum query time of the database for the iterator. Zero uses
+	// the maximum query time of the database, and a negative value means no limit.
+	MaxQueryTime time.Duration
+	// Prefetch overrides the number of records fetched and decoded ahead, see
+	// MongoDB.SetIteratorPrefetch. Zero uses the prefetch window of the database.
+	Prefetch int
+}
+
+// mongoMaxQueryTime returns the maximum query time configured by the max_query_time_ms option.
+func mongoMaxQueryTime(options Options) (time.Duration, error) {
+	return mongoMillisOption(options, mongoOptionMaxQueryTime)
+}
+
+// mongoClientTimeout returns the client timeout configured by the client_timeout_ms option.
+func mongoClientTimeout(options Options) (time.Duration, error) {
+	return mongoMillisOption(options, mongoOptionClientTimeout)
+}
+
+// mongoCloseTimeout returns the close timeout configured by the close_timeout_ms option.
+func mongoCloseTimeout(options Options) (time.Duration, error) {
+	if _, ok := options[mongoOptionCloseTimeout]; !ok {
+		return mongoDefaultCloseTimeout, nil
+	}
+	return mongoMillisOption(options, mongoOptionCloseTimeout)
+}
+
+// mongoMillisOption parses a non-negative duration option given in milliseconds.
+func mongoMillisOption(options Options, name string) (time.Duration, error) {
+	s, ok := options[name]
+	if !ok {
+		return 0, nil
+	}
+	ms, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, s, err)
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("invalid %s %d: must not be negative", name, ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// SetMaxQueryTime sets the maximum server-side execution time of each read query, after which the
+// server kills the query and the read fails with an *ErrQueryTimeout. Zero means no limit. Writes
+// and exports are not limited.
+func (db *MongoDB) SetMaxQueryTime(d time.Duration) {
+	db.maxQueryTime.Store(int64(d))
+}
+
+// SetCloseTimeout sets the time which Close waits for the client to disconnect, after which the
+// connections still in use are closed, e.g. when the server is unreachable. Zero means no limit.
+// It must be called before the database is used, and defaults to 10s.
+func (db *MongoDB) SetCloseTimeout(d time.Duration) {
+	db.closeTimeout = d
+}
+
+// mongoDisconnect disconnects client, waiting at most timeout for the connections in use, where
+// zero means no limit.
+func mongoDisconnect(client *mongo.Client, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return client.Disconnect(ctx)
+}
+
+// queryTime returns the maximum query time set by SetMaxQueryTime.
+func (db *MongoDB) queryTime() time.Duration {
+	return time.Duration(db.maxQueryTime.Load())
+}
+
+// stricterTimeout returns the stricter of the query time maxTime and the client timeout, where
+// zero means no limit.
+func (db *MongoDB) stricterTimeout(maxTime time.Duration) time.Duration {
+	if maxTime <= 0 || (db.clientTimeout > 0 && db.clientTimeout < maxTime) {
+		return db.clientTimeout
+	}
+	return maxTime
+}
+
+// readContext returns the context derived from ctx for a read with the maximum query time maxTime.
+// The driver ignores the maximum query time of operations when the client has a timeout, unless
+// the context has a deadline, so a maximum query time stricter than the client timeout is set as
+// the deadline.
+func (db *MongoDB) readContext(ctx context.Context, maxTime time.Duration) (context.Context, context.CancelFunc) {
+	if db.clientTimeout > 0 && maxTime > 0 && maxTime < db.clientTimeout {
+		return context.WithTimeout(ctx, maxTime)
+	}
+	return ctx, func() {}
+}
+
+// cursorContext is like readContext for the reads of the batches of a cursor after the first, which
+// the client timeout does not bound once the cursor is open. The client timeout, or the stricter
+// maximum query time, is set as their deadline.
+func (db *MongoDB) cursorContext(ctx context.Context, maxTime time.Duration) (context.Context, context.CancelFunc) {
+	if db.clientTimeout > 0 {
+		return context.WithTimeout(ctx, db.stricterTimeout(maxTime))
+	}
+	return ctx, func() {}
+}
+
+// findOneOptions returns the options for FindOne reads.
+func (db *MongoDB) findOneOptions() *mongoOptions.FindOneOptions {
+	opts := mongoOptions.FindOne()
+	if db.queryTime() > 0 {
+		opts.SetMaxTime(db.queryTime())
+	}
+	return opts
+}
+
+// aggregateOptions returns the options for aggregation reads.
+func (db *MongoDB) aggregateOptions() *mongoOptions.AggregateOptions {
+	opts := mongoOptions.Aggregate()
+	if db.queryTime() > 0 {
+		opts.SetMaxTime(db.queryTime())
+	}
+	return opts
+}
+
+// wrapReadError converts errors caused by exceeding the maximum query time maxTime or the client
+// timeout into an *ErrQueryTimeout, and wraps fatal topology errors with ErrUnavailable. Other
+// errors are returned unchanged.
+func (db *MongoDB) wrapReadError(err error, maxTime time.Duration) error {
+	db.supervise(err)
+	if err == nil {
+		return nil
+	}
+	var serverErr mongo.ServerError
+	expired := errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoCodeMaxTimeMSExpired)
+	if !expired && (db.clientTimeout <= 0 || !mongo.IsTimeout(err)) {
+		return unavailableError(err)
+	}
+	timeout := &ErrQueryTimeout{Collection: db.coll().Name(), MaxTime: db.stricterTimeout(maxTime), Err: err}
+	db.debugf("%v", timeout)
+	return timeout
+}
+
+// abortedError returns an *ErrAborted for the operation op which failed with err, if ctx is done,
+// and nil otherwise.
+func abortedError(ctx context.Context, op string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return nil
+	}
+	return &ErrAborted{Op: op, Err: ctx.Err(), Cause: err}
+}
+
+// wrapReadErrorContext is like wrapReadError for the read op with the context ctx given by the
+// caller, and returns an *ErrAborted if ctx is done. Aborted reads are not supervised, as they say
+// nothing about the client.
+func (db *MongoDB) wrapReadErrorContext(ctx context.Context, op string, err error, maxTime time.Duration) error {
+	if aborted := abortedError(ctx, op, err); aborted != nil {
+		return aborted
+	}
+	return db.wrapReadError(err, maxTime)
+}
+
+// wrapWriteErrorContext is like wrapWriteError for the write op with the context ctx given by the
+// caller, and returns an *ErrAborted if ctx is done.
+func (db *MongoDB) wrapWriteErrorContext(ctx context.Context, op string, err error) error {
+	if aborted := abortedError(ctx, op, err); aborted != nil {
+		return aborted
+	}
+	return db.wrapWriteError(err)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// mongoTransactionSupport records whether the deployment supports multi-document transactions.
+type mongoTransactionSupport int32
+
+const (
+	mongoTransactionsUnknown mongoTransactionSupport = iota
+	mongoTransactionsSupported
+	mongoTransactionsUnsupported
+)
+
+// mongoHelloResult holds the fields of the reply to the hello command telling whether the
+// deployment supports transactions.
+type mongoHelloResult struct {
+	SetName                      string `bson:"setName"`
+	Msg                          string `bson:"msg"`
+	LogicalSessionTimeoutMinutes *int64 `bson:"logicalSessionTimeoutMinutes"`
+}
+
+// supportsTransactions returns whether the deployment supports multi-document transactions, i.e.
+// whether it is a replica set or a sharded cluster with sessions. The answer of the server is
+// cached, while a failure to ask it reports no support without being cached, so that the next
+// write asks again.
+func (db *MongoDB) supportsTransactions(ctx context.Context) bool {
+	switch mongoTransactionSupport(db.transactions.Load()) {
+	case mongoTransactionsSupported:
+		return true
+	case mongoTransactionsUnsupported:
+		return false
+	}
+
+	var hello mongoHelloResult
+	err := db.coll().Database().RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		db.debugf("mongodb: detecting transaction support: %v", err)
+		return false
+	}
+	supported := hello.supportsTransactions()
+	if supported {
+		db.transactions.Store(int32(mongoTransactionsSupported))
+	} else {
+		db.transactions.Store(int32(mongoTransactionsUnsupported))
+	}
+	return supported
+}
+
+// supportsTransactions returns whether the server which replied hello belongs to a replica set or
+// is a mongos router, and supports sessions.
+func (h mongoHelloResult) supportsTransactions() bool {
+	return h.LogicalSessionTimeoutMinutes != nil && (h.SetName != "" || h.Msg == "isdbgrid")
+}
+
+// writeTransaction writes the coalesced operations ops with their models, and the tombstones of
+// the deletions journal, in a single transaction, like bulkWrite. Nothing is applied if a chunk
+// fails or a condition of the batch does not hold, so that errors of chunks are returned
+// unwrapped rather than as *ErrBatchIncomplete. If sync is set, the transaction is committed with
+// a majority write concern.
+func (b *mongoDBBatch) writeTransaction(
+	ctx context.Context, ops []mongoWriteOp, models, tombstones []mongo.WriteModel, sync bool,
+) (deleted, expected int64, conflict, err error) {
+	session, err := b.db.coll().Database().Client().StartSession()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer session.EndSession(context.Background())
+
+	opts := options.Transaction()
+	if sync {
+		opts.SetWriteConcern(writeconcern.Majority())
+	}
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var err error
+		deleted, expected, conflict, err = b.bulkWrite(sc, ops, models, true)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			// Aborts the transaction.
+			return nil, conflict
+		}
+		return nil, b.journal(sc, tombstones)
+	}, opts)
+	if conflict != nil {
+		return 0, 0, conflict, nil
+	}
+	var incomplete *ErrBatchIncomplete
+	if errors.As(err, &incomplete) {
+		err = incomplete.Err
+	}
+	return deleted, expected, nil, err
+}
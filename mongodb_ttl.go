@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoExpireAtField is the field holding the expiry time of documents written by SetWithTTL. A TTL
+// index on it makes the server delete expired documents, see ensureTTLIndex.
+const mongoExpireAtField = "expireAt"
+
+// mongoTTLIndexName is the name of the TTL index on mongoExpireAtField.
+const mongoTTLIndexName = "expireAt_ttl"
+
+// mongoUnexpiredCondition matches the documents which have not expired: those without an expiry
+// time, and those whose expiry time is after the current time of the server. The server deletes
+// expired documents only about once a minute, so reads must not rely on it. Comparing with $$NOW
+// rather than the time of the client keeps the filter constant, and consistent with the server's
+// deletions regardless of clock skew.
+var mongoUnexpiredCondition = bson.E{Key: "$expr", Value: bson.D{{Key: "$or", Value: bson.A{
+	bson.D{{Key: "$not", Value: bson.A{"$" + mongoExpireAtField}}},
+	bson.D{{Key: "$gt", Value: bson.A{"$" + mongoExpireAtField, "$$NOW"}}},
+}}}}
+
+// mongoRawUnexpiredCondition is mongoUnexpiredCondition as a raw BSON element, see
+// appendMongoUnexpiredKeyFilter.
+var mongoRawUnexpiredCondition = func() []byte {
+	doc, err := bson.Marshal(bson.D{mongoUnexpiredCondition})
+	if err != nil {
+		panic(err)
+	}
+	// Strip the length prefix and the terminating null byte of the document.
+	return doc[4 : len(doc)-1]
+}()
+
+// mongoUnexpiredFilter returns filter restricted to the documents which have not expired.
+func mongoUnexpiredFilter(filter bson.D) bson.D {
+	return append(filter[:len(filter):len(filter)], mongoUnexpiredCondition)
+}
+
+// mongoExpiringUpdate returns a copy of update which also sets the expiry time of its document to
+// expireAt, or removes it if expireAt is zero, see mongoExpiringSetUpdate.
+func mongoExpiringUpdate(update bson.D, expireAt time.Time) bson.D {
+	if expireAt.IsZero() {
+		return mongoUpdateOperator(update, "$unset", bson.E{Key: mongoExpireAtField, Value: ""})
+	}
+	return mongoUpdateOperator(update, "$set", bson.E{Key: mongoExpireAtField, Value: expireAt})
+}
+
+// mongoUpdateOperator returns a copy of update in which the update operator op, e.g. $set, also
+// applies to the field e.
+func mongoUpdateOperator(update bson.D, op string, e bson.E) bson.D {
+	update = append(make(bson.D, 0, len(update)+1), update...)
+	for i, u := range update {
+		if fields, ok := u.Value.(bson.D); ok && u.Key == op {
+			update[i].Value = append(fields[:len(fields):len(fields)], e)
+			return update
+		}
+	}
+	return append(update, bson.E{Key: op, Value: bson.D{e}})
+}
+
+var _ TTLSetter = (*MongoDB)(nil)
+
+// SetWithTTL implements TTLSetter. The document of the key gets an expireAt field, and a TTL index
+// on it is created by the first call, which the server uses to delete expired documents about once
+// a minute. Until then, Get, Has, GetMany and iterators treat expired documents as absent, while
+// conditional sets and statistics still count them. The server's deletions are not journaled, see
+// TrackTimestamps.
+func (db *MongoDB) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if ttl <= 0 {
+		return invalidTTLError(ttl)
+	}
+	if err := checkKeySize(key, mongoMaxKeySize); err != nil {
+		return err
+	}
+	if err := checkValueLimit(value, mongoMaxValueSize); err != nil {
+		return err
+	}
+	if err := db.storageFull.writable(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := db.retry(ctx, func() error { return db.ensureTTLIndex(ctx) }); err != nil {
+		return err
+	}
+
+	return db.retry(ctx, func() error {
+		cutoff := primitive.NewObjectID()
+		filter, update := mongoExpiringSetUpdate(db.codec, key, value, db.journalColl() != nil, time.Now().Add(ttl))
+		_, err := db.coll().UpdateOne(ctx, filter, update, mongoOptions.Update().SetUpsert(true))
+		if err != nil {
+			return db.wrapWriteError(err)
+		}
+		return db.deleteStaleChunks(ctx, []mongoWriteOp{{key: key, value: value}}, cutoff)
+	})
+}
+
+// ensureTTLIndex creates the TTL index on the expiry times of the documents, unless a previous
+// call did.
+func (db *MongoDB) ensureTTLIndex(ctx context.Context) error {
+	if db.ttlIndexReady.Load() {
+		return nil
+	}
+	_, err := db.coll().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: mongoExpireAtField, Value: 1}},
+		Options: mongoOptions.Index().SetName(mongoTTLIndexName).SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return db.wrapWriteError(err)
+	}
+	db.ttlIndexReady.Store(true)
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setlessRecordCodec is a RecordCodec whose updates have no $set.
+type setlessRecordCodec struct{ defaultRecordCodec }
+
+func (setlessRecordCodec) EncodeSet(key, value []byte) (bson.D, bson.D) {
+	return mongoKeyFilter(key), bson.D{{Key: "$max", Value: bson.D{{Key: "value", Value: value}}}}
+}
+
+func TestMongoExpiringSetUpdate(t *testing.T) {
+	expireAt := time.Unix(1_700_000_000, 0)
+	modified := bson.E{Key: "$currentDate", Value: bson.D{{Key: "modifiedAt", Value: true}}}
+	notLarge := bson.E{Key: "$unset", Value: bson.D{{Key: mongoLargeValueField, Value: ""}}}
+
+	_, update := mongoExpiringSetUpdate(defaultRecordCodec{}, bz("key"), bz("value"), true, expireAt)
+	require.Equal(t, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "value", Value: bz("value")}, {Key: mongoExpireAtField, Value: expireAt}}},
+		modified,
+		notLarge,
+	}, update)
+	_, update = mongoExpiringSetUpdate(hexRecordCodec{}, bz("key"), bz("value"), false, expireAt)
+	require.Equal(t, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "hex", Value: "76616c7565"}, {Key: mongoExpireAtField, Value: expireAt}}},
+		notLarge,
+	}, update)
+	_, update = mongoExpiringSetUpdate(setlessRecordCodec{}, bz("key"), bz("value"), false, expireAt)
+	require.Equal(t, bson.D{
+		{Key: "$max", Value: bson.D{{Key: "value", Value: bz("value")}}},
+		{Key: "$set", Value: bson.D{{Key: mongoExpireAtField, Value: expireAt}}},
+		notLarge,
+	}, update)
+
+	// Values without a TTL clear the expiry time of the value they replace.
+	_, update = mongoSetUpdate(defaultRecordCodec{}, bz("key"), bz("value"), true)
+	require.Equal(t, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "value", Value: bz("value")}}},
+		modified,
+		{Key: "$unset", Value: bson.D{{Key: mongoExpireAtField, Value: ""}, {Key: mongoLargeValueField, Value: ""}}},
+	}, update)
+
+	// Filters keep their conditions, and are not modified.
+	filter := mongoKeyFilter(bz("key"))
+	require.Equal(t, bson.D{{Key: "_id", Value: MongoKeyID(bz("key"))}, mongoUnexpiredCondition}, mongoUnexpiredFilter(filter))
+	require.Len(t, filter, 1)
+}
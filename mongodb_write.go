@@ -0,0 +1,255 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongoWriteOp is a single write in the MongoDB write pipeline. The sequence number is captured
+// from the database when the operation is issued, and defines the order in which operations on the
+// same key must be applied.
+type mongoWriteOp struct {
+	seq   uint64
+	key   []byte
+	value []byte // nil for deletes
+	mode  mongoSetMode
+	// large is the marker of the value if it is split into chunks, see mongoLargeValue.
+	large *mongoLargeValue
+}
+
+// mongoSetMode is the condition of a set operation, see StrictSetBatch.
+type mongoSetMode int
+
+const (
+	// mongoSetUpsert sets a key whether it exists or not.
+	mongoSetUpsert mongoSetMode = iota
+	// mongoSetInsertOnly only sets a key which does not exist.
+	mongoSetInsertOnly
+	// mongoSetUpdateOnly only sets a key which exists.
+	mongoSetUpdateOnly
+)
+
+// isDelete returns whether the operation is a delete.
+func (op mongoWriteOp) isDelete() bool {
+	return op.value == nil
+}
+
+// model returns the MongoDB write model for the operation.
+func (op mongoWriteOp) model(codec RecordCodec, trackTimestamps bool) mongo.WriteModel {
+	if op.isDelete() {
+		return mongo.NewDeleteOneModel().SetFilter(mongoRawKeyFilter(op.key))
+	}
+	if op.mode == mongoSetInsertOnly {
+		filter, update := op.setUpdate(codec, trackTimestamps)
+		return mongo.NewUpdateOneModel().
+			SetFilter(mongoWriteOnceFilter(filter)).
+			SetUpdate(update).
+			SetUpsert(true)
+	}
+	var filter, update interface{}
+	if op.large != nil {
+		filter, update = op.setUpdate(codec, trackTimestamps)
+	} else {
+		filter, update = mongoSetDocuments(nil, codec, op.key, op.value, trackTimestamps)
+	}
+	model := mongo.NewUpdateOneModel().
+		SetFilter(filter).
+		SetUpdate(update)
+	if op.mode == mongoSetUpsert {
+		model.SetUpsert(true)
+	}
+	return model
+}
+
+// modelWithFilter is like model, with the document of the key selected by keyFilter instead of its
+// _id.
+func (op mongoWriteOp) modelWithFilter(keyFilter bson.D, codec RecordCodec, trackTimestamps bool) mongo.WriteModel {
+	if op.isDelete() {
+		return mongo.NewDeleteOneModel().SetFilter(keyFilter)
+	}
+	_, update := op.setUpdate(codec, trackTimestamps)
+	filter := keyFilter
+	if op.mode == mongoSetInsertOnly {
+		filter = mongoWriteOnceFilter(append(bson.D{}, keyFilter...))
+	}
+	model := mongo.NewUpdateOneModel().
+		SetFilter(filter).
+		SetUpdate(update)
+	if op.mode != mongoSetUpdateOnly {
+		model.SetUpsert(true)
+	}
+	return model
+}
+
+// setUpdate returns the filter and update document of a set operation, see mongoSetUpdate and
+// mongoLargeSetUpdate.
+func (op mongoWriteOp) setUpdate(codec RecordCodec, trackTimestamps bool) (bson.D, bson.D) {
+	if op.large != nil {
+		return mongoLargeSetUpdate(codec, op.key, op.large, trackTimestamps)
+	}
+	return mongoSetUpdate(codec, op.key, op.value, trackTimestamps)
+}
+
+// mongoSetUpdate returns the filter and update document which set a value using codec, including
+// its modification time if timestamps are tracked. The value never expires, even if it replaces one
+// set by SetWithTTL.
+func mongoSetUpdate(codec RecordCodec, key, value []byte, trackTimestamps bool) (bson.D, bson.D) {
+	return mongoExpiringSetUpdate(codec, key, value, trackTimestamps, time.Time{})
+}
+
+// mongoExpiringSetUpdate is like mongoSetUpdate, but the value expires at expireAt, unless it is
+// zero. The value replaces any large value of the key, see mongoLargeSetUpdate.
+func mongoExpiringSetUpdate(
+	codec RecordCodec, key, value []byte, trackTimestamps bool, expireAt time.Time,
+) (bson.D, bson.D) {
+	filter, update := codec.EncodeSet(key, value)
+	if trackTimestamps {
+		update = append(update, bson.E{Key: "$currentDate", Value: bson.D{{Key: "modifiedAt", Value: true}}})
+	}
+	update = mongoExpiringUpdate(update, expireAt)
+	return filter, mongoUpdateOperator(update, "$unset", bson.E{Key: mongoLargeValueField, Value: ""})
+}
+
+// mongoTombstoneModel returns the write model recording the deletion of a key in the deletions
+// journal.
+func mongoTombstoneModel(key []byte) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(mongoKeyFilter(key)).
+		SetUpdate(bson.D{{Key: "$currentDate", Value: bson.D{{Key: "deletedAt", Value: true}}}}).
+		SetUpsert(true)
+}
+
+// mongoWriteGroup is a group of writes which are flushed to MongoDB together. Groups may be merged
+// and flushed in any order, as operations on the same key are always applied in sequence order,
+// while operations on different keys are unordered with respect to each other.
+type mongoWriteGroup struct {
+	ops []mongoWriteOp
+
+	// codec encodes the documents of set operations.
+	codec RecordCodec
+	// trackTimestamps makes flush record modification times and tombstones for deletes.
+	trackTimestamps bool
+	// keyFilter, if set, returns the filter selecting the document of a key, see CompressedMongoDB.
+	keyFilter func(key []byte) bson.D
+	// stats accumulates the coalescing statistics of the flushed operations.
+	stats BatchStats
+}
+
+func newMongoWriteGroup() *mongoWriteGroup {
+	return newMongoWriteGroupWithSize(0)
+}
+
+// newMongoWriteGroupWithSize creates a group with room for size operations.
+func newMongoWriteGroupWithSize(size int) *mongoWriteGroup {
+	return &mongoWriteGroup{
+		ops:   make([]mongoWriteOp, 0, size),
+		codec: defaultRecordCodec{},
+	}
+}
+
+// add appends an operation to the group.
+func (g *mongoWriteGroup) add(op mongoWriteOp) {
+	g.ops = append(g.ops, op)
+}
+
+// merge moves all operations of other into the group.
+func (g *mongoWriteGroup) merge(other *mongoWriteGroup) {
+	g.ops = append(g.ops, other.ops...)
+	other.ops = other.ops[:0]
+}
+
+// len returns the number of operations in the group.
+func (g *mongoWriteGroup) len() int {
+	return len(g.ops)
+}
+
+// coalesce returns the final operation for each key, in order of first appearance. Only the
+// operation with the highest sequence number for each key is kept, since it determines the final
+// state of the key.
+func (g *mongoWriteGroup) coalesce() []mongoWriteOp {
+	ops := make([]mongoWriteOp, len(g.ops))
+	copy(ops, g.ops)
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].seq < ops[j].seq
+	})
+
+	index := make(map[string]int, len(ops))
+	coalesced := make([]mongoWriteOp, 0, len(ops))
+	for _, op := range ops {
+		k := string(op.key)
+		if i, ok := index[k]; ok {
+			coalesced[i] = op
+			continue
+		}
+		index[k] = len(coalesced)
+		coalesced = append(coalesced, op)
+	}
+	return coalesced
+}
+
+// flush coalesces the group and passes the resulting operations and their write models to write,
+// which may apply them in any order. If timestamps are tracked, the tombstone models for the
+// deletions journal are passed as well. The group is emptied if write succeeds.
+func (g *mongoWriteGroup) flush(write func(ops []mongoWriteOp, models, tombstones []mongo.WriteModel) error) error {
+	if len(g.ops) == 0 {
+		return nil
+	}
+
+	ops, models, tombstones := g.writeModels()
+	if err := write(ops, models, tombstones); err != nil {
+		return err
+	}
+	g.flushed(ops)
+	return nil
+}
+
+// writeModels coalesces the group, and returns the resulting operations with their write models
+// and the tombstone models for the deletions journal, if timestamps are tracked.
+func (g *mongoWriteGroup) writeModels() (ops []mongoWriteOp, models, tombstones []mongo.WriteModel) {
+	ops = g.coalesce()
+	models = make([]mongo.WriteModel, 0, len(ops))
+	for _, op := range ops {
+		if g.keyFilter != nil {
+			models = append(models, op.modelWithFilter(g.keyFilter(op.key), g.codec, g.trackTimestamps))
+		} else {
+			models = append(models, op.model(g.codec, g.trackTimestamps))
+		}
+		if g.trackTimestamps && op.isDelete() {
+			tombstones = append(tombstones, mongoTombstoneModel(op.key))
+		}
+	}
+	return ops, models, tombstones
+}
+
+// flushed records that the coalesced operations ops returned by writeModels have been written,
+// and empties the group.
+func (g *mongoWriteGroup) flushed(ops []mongoWriteOp) {
+	g.stats.Staged += len(g.ops)
+	g.stats.Emitted += len(ops)
+	g.stats.Collapsed += len(g.ops) - len(ops)
+	g.stats.BytesSaved += mongoWriteOpsSize(g.ops) - mongoWriteOpsSize(ops)
+	g.ops = g.ops[:0]
+}
+
+// writableFor returns ErrReadOnly if ops set keys and the database became read-only. Deletes are
+// allowed, so that space can be freed.
+func (db *MongoDB) writableFor(ops []mongoWriteOp) error {
+	for _, op := range ops {
+		if !op.isDelete() {
+			return db.storageFull.writable()
+		}
+	}
+	return nil
+}
+
+// mongoWriteOpsSize returns the total key and value size of ops.
+func mongoWriteOpsSize(ops []mongoWriteOp) int64 {
+	var size int64
+	for _, op := range ops {
+		size += int64(len(op.key) + len(op.value))
+	}
+	return size
+}
@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// applyModels applies write models to a map in reverse order, to simulate an unordered bulk write.
+func applyModels(t *testing.T, state map[string][]byte, models []mongo.WriteModel) {
+	for i := len(models) - 1; i >= 0; i-- {
+		switch model := models[i].(type) {
+		case *mongo.UpdateOneModel:
+			key := mongoTestKey(t, model.Filter)
+			_, value := marshalDocument(t, model.Update).Lookup("$set", "value").Binary()
+			state[key] = value
+		case *mongo.DeleteOneModel:
+			key := mongoTestKey(t, model.Filter)
+			delete(state, key)
+		default:
+			t.Fatalf("unexpected write model %T", model)
+		}
+	}
+}
+
+// mongoTestKey returns the key matched by the filter of a write model.
+func mongoTestKey(t *testing.T, filter interface{}) string {
+	key, err := MongoDocumentKey(marshalDocument(t, filter))
+	require.NoError(t, err)
+	return string(key)
+}
+
+func TestMongoWriteGroupOrdering(t *testing.T) {
+	db := &MongoDB{}
+	first, second := newMongoWriteGroup(), newMongoWriteGroup()
+
+	// Interleave Set/Delete/Set on one key across two groups, in program order.
+	first.add(mongoWriteOp{seq: db.nextSeq(), key: bz("a"), value: bz("1")})
+	second.add(mongoWriteOp{seq: db.nextSeq(), key: bz("a")})
+	first.add(mongoWriteOp{seq: db.nextSeq(), key: bz("b"), value: bz("2")})
+	first.add(mongoWriteOp{seq: db.nextSeq(), key: bz("a"), value: bz("3")})
+	second.add(mongoWriteOp{seq: db.nextSeq(), key: bz("b")})
+
+	// Merge the groups out of order, and flush them manually.
+	second.merge(first)
+	require.Zero(t, first.len())
+	require.Equal(t, 5, second.len())
+
+	state := map[string][]byte{"b": bz("0")}
+	var flushed int
+	err := second.flush(func(_ []mongoWriteOp, models, tombstones []mongo.WriteModel) error {
+		require.Nil(t, tombstones)
+		flushed = len(models)
+		applyModels(t, state, models)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, flushed)
+	require.Equal(t, map[string][]byte{"a": bz("3")}, state)
+	require.Zero(t, second.len())
+
+	// Flushing an empty group does not write anything.
+	err = second.flush(func(_ []mongoWriteOp, _, _ []mongo.WriteModel) error {
+		t.Fatal("unexpected write")
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func benchmarkMongoWriteGroup(b *testing.B, size int) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = int642Bytes(int64(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g := newMongoWriteGroupWithSize(size)
+		for j, key := range keys {
+			g.add(mongoWriteOp{seq: uint64(j), key: key, value: key})
+		}
+	}
+}
+
+func BenchmarkMongoWriteGroup100k(b *testing.B) {
+	benchmarkMongoWriteGroup(b, 0)
+}
+
+func BenchmarkMongoWriteGroupWithSize100k(b *testing.B) {
+	benchmarkMongoWriteGroup(b, 100000)
+}
+
+func TestWriteGroupStats(t *testing.T) {
+	g := newMongoWriteGroup()
+	for i := 0; i < 1000; i++ {
+		g.add(mongoWriteOp{seq: uint64(i + 1), key: []byte("key"), value: []byte("value")})
+	}
+	g.add(mongoWriteOp{seq: 1001, key: []byte("other")})
+
+	var emitted int
+	require.NoError(t, g.flush(func(_ []mongoWriteOp, models, tombstones []mongo.WriteModel) error {
+		emitted = len(models)
+		return nil
+	}))
+	require.Equal(t, 2, emitted)
+	require.Equal(t, BatchStats{Staged: 1001, Emitted: 2, Collapsed: 999, BytesSaved: 999 * 8}, g.stats)
+}
+
+// fakeBulkWriter applies the chunks of a mongoDBBatch to a map, and fails the writes for which
+// fail returns an error.
+type fakeBulkWriter struct {
+	t      *testing.T
+	state  map[string][]byte
+	writes int
+	fail   func(ctx context.Context, write int) error
+}
+
+func (w *fakeBulkWriter) write(ctx context.Context, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	write := w.writes
+	w.writes++
+	if w.fail != nil {
+		if err := w.fail(ctx, write); err != nil {
+			return nil, err
+		}
+	}
+	applyModels(w.t, w.state, models)
+	return &mongo.BulkWriteResult{UpsertedCount: int64(len(models))}, nil
+}
+
+func TestMongoBatchWriteContext(t *testing.T) {
+	db := NewMongoDB(nil)
+	// The chunks are written outside a transaction, as on a standalone server, and there are no
+	// large values whose chunks are removed.
+	db.transactions.Store(int32(mongoTransactionsUnsupported))
+	db.largeValuesProbed.Store(time.Now().UnixNano())
+	batch := newMongoDBBatch(db)
+	total := 2*mongoBatchChunkSize + 10
+	for i := 0; i < total; i++ {
+		require.NoError(t, batch.Set(int642Bytes(int64(i)), bz("value")))
+	}
+	// The second write stalls until the budget is exhausted.
+	writer := &fakeBulkWriter{t: t, state: make(map[string][]byte), fail: func(ctx context.Context, write int) error {
+		if write == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}}
+	batch.writeChunk = writer.write
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := batch.WriteContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var incomplete *ErrBatchIncomplete
+	require.ErrorAs(t, err, &incomplete)
+	require.Equal(t, &ErrBatchIncomplete{
+		Chunk: 1, Applied: mongoBatchChunkSize, Total: total, Err: context.DeadlineExceeded,
+	}, incomplete)
+	require.Len(t, writer.state, mongoBatchChunkSize)
+
+	// No chunk is started once the budget is exhausted.
+	err = batch.WriteContext(ctx)
+	require.ErrorAs(t, err, &incomplete)
+	require.Equal(t, &ErrBatchIncomplete{Chunk: 0, Total: total, Err: context.DeadlineExceeded}, incomplete)
+	require.Equal(t, 2, writer.writes)
+
+	// Resuming skips the applied chunks.
+	var progress []int
+	batch.SetProgressFunc(func(done, _ int) { progress = append(progress, done) })
+	require.Error(t, batch.ResumeFrom(-1))
+	require.NoError(t, batch.ResumeFrom(1))
+	writer.fail = nil
+	require.NoError(t, batch.Write())
+	require.Equal(t, 4, writer.writes)
+	require.Equal(t, []int{2 * mongoBatchChunkSize, total}, progress)
+	require.Len(t, writer.state, total)
+	require.Equal(t, errBatchClosed, batch.ResumeFrom(0))
+}
+
+func TestMongoBatchClientTimeout(t *testing.T) {
+	db := NewMongoDB(nil)
+	db.transactions.Store(int32(mongoTransactionsUnsupported))
+	db.largeValuesProbed.Store(time.Now().UnixNano())
+	db.clientTimeout = 10 * time.Millisecond
+	batch := newMongoDBBatch(db)
+	require.NoError(t, batch.Set(bz("key"), bz("value")))
+
+	// Each chunk is bounded by the client timeout, even without a deadline.
+	writer := &fakeBulkWriter{t: t, state: make(map[string][]byte), fail: func(ctx context.Context, _ int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	batch.writeChunk = writer.write
+	err := batch.Write()
+	var incomplete *ErrBatchIncomplete
+	require.ErrorAs(t, err, &incomplete)
+	require.Equal(t, &ErrBatchIncomplete{Chunk: 0, Total: 1, Err: context.DeadlineExceeded}, incomplete)
+}
+
+func TestMongoHelloSupportsTransactions(t *testing.T) {
+	timeout := int64(30)
+	for _, tc := range []struct {
+		hello     mongoHelloResult
+		supported bool
+	}{
+		{mongoHelloResult{LogicalSessionTimeoutMinutes: &timeout}, false},
+		{mongoHelloResult{SetName: "rs0", LogicalSessionTimeoutMinutes: &timeout}, true},
+		{mongoHelloResult{Msg: "isdbgrid", LogicalSessionTimeoutMinutes: &timeout}, true},
+		{mongoHelloResult{SetName: "rs0"}, false},
+	} {
+		require.Equal(t, tc.supported, tc.hello.supportsTransactions(), "%+v", tc.hello)
+	}
+}
+
+func TestMongoBatchCoalescing(t *testing.T) {
+	db := NewMongoDB(nil)
+	db.transactions.Store(int32(mongoTransactionsUnsupported))
+	db.largeValuesProbed.Store(time.Now().UnixNano())
+	state := map[string][]byte{"kept": bz("old"), "deleted": bz("old")}
+	want := NewMemDB()
+	for key, value := range state {
+		require.NoError(t, want.Set(bz(key), value))
+	}
+
+	var models []mongo.WriteModel
+	writer := &fakeBulkWriter{t: t, state: state}
+	batch := newMongoDBBatch(db)
+	batch.writeChunk = func(ctx context.Context, chunk []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		models = append(models, chunk...)
+		return writer.write(ctx, chunk)
+	}
+	memBatch := want.NewBatch()
+
+	rng := rand.New(rand.NewSource(1))
+	keys := []string{"a", "b", "c", "kept", "deleted"}
+	for i := 0; i < 200; i++ {
+		key := bz(keys[rng.Intn(3)])
+		if rng.Intn(3) == 0 {
+			require.NoError(t, batch.Delete(key))
+			require.NoError(t, memBatch.Delete(key))
+		} else {
+			value := bz(fmt.Sprintf("value%d", i))
+			require.NoError(t, batch.Set(key, value))
+			require.NoError(t, memBatch.Set(key, value))
+		}
+	}
+	// The sequence of the task: set, set, delete.
+	for _, b := range []Batch{batch, memBatch} {
+		require.NoError(t, b.Set(bz("kept"), bz("v1")))
+		require.NoError(t, b.Set(bz("kept"), bz("v2")))
+		require.NoError(t, b.Set(bz("deleted"), bz("v1")))
+		require.NoError(t, b.Set(bz("deleted"), bz("v2")))
+		require.NoError(t, b.Delete(bz("deleted")))
+	}
+
+	require.Equal(t, 5, batch.Count())
+	size := batch.SizeBytes()
+
+	require.NoError(t, batch.Write())
+	require.NoError(t, memBatch.Write())
+	require.Len(t, models, 5)
+	expected := collectAll(t, want)
+	require.Equal(t, expected, stringValues(writer.state))
+
+	// Deletes count the key size only.
+	wantSize := 0
+	for _, key := range keys {
+		wantSize += len(key) + len(expected[key])
+	}
+	require.Equal(t, wantSize, size)
+	require.Zero(t, batch.Count())
+	require.Zero(t, batch.SizeBytes())
+}
+
+// stringValues converts the values of state to strings, as returned by collectAll.
+func stringValues(state map[string][]byte) map[string]string {
+	values := make(map[string]string, len(state))
+	for key, value := range state {
+		values[key] = string(value)
+	}
+	return values
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options values are strings, but often come from JSON or TOML configuration, where numbers and
+// booleans are stored as their text, see UnmarshalJSON. The typed accessors below coerce that text,
+// and return false if the option is not set or its value cannot be coerced without loss.
+
+// GetString returns the value of the option key, and whether it is set.
+func (o Options) GetString(key string) (string, bool) {
+	value, ok := o[key]
+	return value, ok
+}
+
+// GetStringOr returns the value of the option key, or def if it is not set.
+func (o Options) GetStringOr(key, def string) string {
+	if value, ok := o[key]; ok {
+		return value
+	}
+	return def
+}
+
+// GetInt64 returns the value of the option key as an integer. Decimal integers such as "42" are
+// accepted, as well as numbers in JSON notation whose value is an integer, such as "42.0" or
+// "4.2e1". Numbers with a fractional part, numbers out of the range of int64, booleans and
+// durations are rejected.
+func (o Options) GetInt64(key string) (int64, bool) {
+	value, ok := o[key]
+	if !ok {
+		return 0, false
+	}
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	// Floats of at least 2^63 are out of range, while -2^63 is the minimum int64.
+	if err != nil || f != math.Trunc(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// GetInt returns the value of the option key as an int, coerced like GetInt64, and rejected if
+// out of the range of int.
+func (o Options) GetInt(key string) (int, bool) {
+	n, ok := o.GetInt64(key)
+	if !ok || n < math.MinInt || n > math.MaxInt {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// GetIntOr returns the value of the option key as an int, like GetInt, or def if it is not set or
+// cannot be coerced.
+func (o Options) GetIntOr(key string, def int) int {
+	if n, ok := o.GetInt(key); ok {
+		return n
+	}
+	return def
+}
+
+// GetBool returns the value of the option key as a boolean. The values accepted by
+// strconv.ParseBool are accepted, such as "true", "false", "1" and "0", while other numbers are
+// rejected.
+func (o Options) GetBool(key string) (bool, bool) {
+	value, ok := o[key]
+	if !ok {
+		return false, false
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
+
+// GetDuration returns the value of the option key as a duration. Durations with units such as
+// "5s" or "1m30s" are accepted, as well as "0". Other numbers without a unit are rejected, as
+// their unit is ambiguous; options in a fixed unit, such as max_query_time_ms, use GetInt64.
+func (o Options) GetDuration(key string) (time.Duration, bool) {
+	value, ok := o[key]
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+// GetBytes returns the value of the option key as a byte slice, which the caller may modify.
+func (o Options) GetBytes(key string) ([]byte, bool) {
+	value, ok := o[key]
+	if !ok {
+		return nil, false
+	}
+	return []byte(value), true
+}
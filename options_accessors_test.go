@@ -0,0 +1,130 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsGetString(t *testing.T) {
+	o := Options{"name": "value", "empty": ""}
+	s, ok := o.GetString("name")
+	assert.True(t, ok)
+	assert.Equal(t, "value", s)
+	s, ok = o.GetString("empty")
+	assert.True(t, ok)
+	assert.Empty(t, s)
+	_, ok = o.GetString("missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, "value", o.GetStringOr("name", "default"))
+	assert.Equal(t, "", o.GetStringOr("empty", "default"))
+	assert.Equal(t, "default", o.GetStringOr("missing", "default"))
+}
+
+func TestOptionsGetInt(t *testing.T) {
+	for value, want := range map[string]int64{
+		"42":                   42,
+		"-7":                   -7,
+		"+3":                   3,
+		" 5 ":                  5,
+		"9223372036854775807":  9223372036854775807,
+		"-9223372036854775808": -9223372036854775808,
+		// JSON numbers whose value is an integer.
+		"42.0":  42,
+		"4.2e1": 42,
+		"1E3":   1000,
+		"-0.0":  0,
+	} {
+		n, ok := Options{"n": value}.GetInt64("n")
+		assert.True(t, ok, value)
+		assert.Equal(t, want, n, value)
+	}
+
+	// Rejected: fractional parts, out of range values, booleans, durations and other text.
+	for _, value := range []string{
+		"4.2", "1e-3", "9223372036854775808", "9.3e18", "-9.3e18", "NaN", "Inf",
+		"true", "5s", "", "forty-two", "0x2a",
+	} {
+		_, ok := Options{"n": value}.GetInt64("n")
+		assert.False(t, ok, value)
+		_, ok = Options{"n": value}.GetInt("n")
+		assert.False(t, ok, value)
+	}
+	_, ok := Options{}.GetInt64("n")
+	assert.False(t, ok)
+
+	n, ok := Options{"n": "1e2"}.GetInt("n")
+	require.True(t, ok)
+	assert.Equal(t, 100, n)
+
+	assert.Equal(t, 42, Options{"n": "42"}.GetIntOr("n", 1))
+	assert.Equal(t, 1, Options{"n": "4.2"}.GetIntOr("n", 1))
+	assert.Equal(t, 1, Options{}.GetIntOr("n", 1))
+}
+
+func TestOptionsGetBool(t *testing.T) {
+	for value, want := range map[string]bool{
+		"true": true, "false": false, "1": true, "0": false, "TRUE": true, "f": false, " true ": true,
+	} {
+		b, ok := Options{"b": value}.GetBool("b")
+		assert.True(t, ok, value)
+		assert.Equal(t, want, b, value)
+	}
+	for _, value := range []string{"yes", "2", "on", ""} {
+		_, ok := Options{"b": value}.GetBool("b")
+		assert.False(t, ok, value)
+	}
+	_, ok := Options{}.GetBool("b")
+	assert.False(t, ok)
+}
+
+func TestOptionsGetDuration(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"5s":    5 * time.Second,
+		"1m30s": 90 * time.Second,
+		"250ms": 250 * time.Millisecond,
+		"-1h":   -time.Hour,
+		"0":     0,
+	} {
+		d, ok := Options{"d": value}.GetDuration("d")
+		assert.True(t, ok, value)
+		assert.Equal(t, want, d, value)
+	}
+	// Numbers without a unit are ambiguous.
+	for _, value := range []string{"5", "1.5", "true", "soon", ""} {
+		_, ok := Options{"d": value}.GetDuration("d")
+		assert.False(t, ok, value)
+	}
+	_, ok := Options{}.GetDuration("d")
+	assert.False(t, ok)
+}
+
+func TestOptionsGetBytes(t *testing.T) {
+	o := Options{"b": "value"}
+	b, ok := o.GetBytes("b")
+	require.True(t, ok)
+	assert.Equal(t, []byte("value"), b)
+	b[0] = 'V'
+	assert.Equal(t, "value", o["b"])
+	_, ok = o.GetBytes("missing")
+	assert.False(t, ok)
+}
+
+func TestOptionsAccessorsFromJSON(t *testing.T) {
+	var o Options
+	require.NoError(t, o.UnmarshalJSON([]byte(`{"pool": 10, "ratio": 1.5, "sync": true, "timeout": "5s"}`)))
+	n, ok := o.GetInt("pool")
+	assert.True(t, ok)
+	assert.Equal(t, 10, n)
+	_, ok = o.GetInt("ratio")
+	assert.False(t, ok)
+	b, ok := o.GetBool("sync")
+	assert.True(t, ok)
+	assert.True(t, b)
+	d, ok := o.GetDuration("timeout")
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+}
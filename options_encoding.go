@@ -0,0 +1,360 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SensitiveOptionKeys are the substrings of option names, matched case-insensitively, whose values
+// are redacted by MarshalRedacted. The passwords of URLs, such as MongoDB connection strings, are
+// redacted whatever the option name.
+var SensitiveOptionKeys = []string{"password", "secret", "token", "credential"}
+
+// redactedOption replaces redacted values and passwords.
+const redactedOption = "REDACTED"
+
+// optionValue returns the TOML and JSON representation of an option value which is a canonical
+// integer or boolean. Other values, including durations like "5s", are encoded as strings.
+func optionValue(value string) (typed string, ok bool) {
+	if value == "true" || value == "false" {
+		return value, true
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(n, 10) == value {
+		return value, true
+	}
+	return "", false
+}
+
+// sortedKeys returns the option names in order.
+func (o Options) sortedKeys() []string {
+	keys := make([]string, 0, len(o))
+	for key := range o {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON encodes the options as a JSON object, in key order. Values which are canonical
+// integers or booleans are encoded as such, so that they decode to the same options.
+func (o Options) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.sortedKeys() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		if typed, ok := optionValue(o[key]); ok {
+			buf.WriteString(typed)
+			continue
+		}
+		value, err := json.Marshal(o[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes options from a JSON object of strings, numbers and booleans. Numbers and
+// booleans are stored as their text.
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return errors.New("options must be a JSON object")
+	}
+	options := make(Options, len(raw))
+	for key, value := range raw {
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case string:
+			options[key] = v
+		case json.Number:
+			options[key] = v.String()
+		case bool:
+			options[key] = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("invalid value of option %q: %s", key, value)
+		}
+	}
+	*o = options
+	return nil
+}
+
+// MarshalTOML encodes the options as a TOML table, one key per line in key order. Values are typed
+// as by MarshalJSON.
+func (o Options) MarshalTOML() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, key := range o.sortedKeys() {
+		if !utf8.ValidString(key) || !utf8.ValidString(o[key]) {
+			return nil, fmt.Errorf("option %q is not valid UTF-8", key)
+		}
+		if isBareTOMLKey(key) {
+			buf.WriteString(key)
+		} else {
+			buf.WriteString(quoteTOML(key))
+		}
+		buf.WriteString(" = ")
+		if typed, ok := optionValue(o[key]); ok {
+			buf.WriteString(typed)
+		} else {
+			buf.WriteString(quoteTOML(o[key]))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalTOML decodes options from a TOML table of strings, numbers and booleans, like
+// UnmarshalJSON. Comments are allowed, while tables, dotted keys, arrays and multi-line strings are
+// not.
+func (o *Options) UnmarshalTOML(data []byte) error {
+	options := make(Options)
+	for i, line := range strings.Split(string(data), "\n") {
+		key, value, ok, err := parseTOMLLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !ok {
+			continue
+		}
+		if _, dup := options[key]; dup {
+			return fmt.Errorf("line %d: duplicate option %q", i+1, key)
+		}
+		options[key] = value
+	}
+	*o = options
+	return nil
+}
+
+// MarshalRedacted encodes the options as JSON for logging, with the values of sensitive options
+// and the passwords of URLs replaced, see SensitiveOptionKeys.
+func (o Options) MarshalRedacted() ([]byte, error) {
+	redacted := make(Options, len(o))
+	for key, value := range o {
+		redacted[key] = redactOption(key, value)
+	}
+	return redacted.MarshalJSON()
+}
+
+// redactOption returns the value of an option with secrets replaced.
+func redactOption(key, value string) string {
+	lower := strings.ToLower(key)
+	for _, sensitive := range SensitiveOptionKeys {
+		if strings.Contains(lower, strings.ToLower(sensitive)) {
+			return redactedOption
+		}
+	}
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		// The URL cannot be parsed to find the password, so all of it is redacted.
+		return redactedOption
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedOption)
+		return u.String()
+	}
+	return value
+}
+
+// isBareTOMLKey returns whether key can be written without quotes.
+func isBareTOMLKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteTOML returns s as a TOML basic string.
+func quoteTOML(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// parseTOMLLine parses a line of a flat TOML table, and returns ok = false for blank lines and
+// comments.
+func parseTOMLLine(line string) (key, value string, ok bool, err error) {
+	rest := strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+	if rest == "" || rest[0] == '#' {
+		return "", "", false, nil
+	}
+	if rest[0] == '[' {
+		return "", "", false, errors.New("tables are not supported")
+	}
+
+	if rest[0] == '"' || rest[0] == '\'' {
+		key, rest, err = parseTOMLString(rest)
+		if err != nil {
+			return "", "", false, err
+		}
+	} else {
+		end := strings.IndexAny(rest, " \t=")
+		if end < 0 {
+			return "", "", false, errors.New("expected '='")
+		}
+		key, rest = rest[:end], rest[end:]
+		if !isBareTOMLKey(key) {
+			return "", "", false, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "=") {
+		return "", "", false, errors.New("expected '='")
+	}
+	rest = strings.TrimLeft(rest[1:], " \t")
+
+	switch {
+	case strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''"):
+		return "", "", false, errors.New("multi-line strings are not supported")
+	case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'"):
+		value, rest, err = parseTOMLString(rest)
+		if err != nil {
+			return "", "", false, err
+		}
+	default:
+		end := strings.IndexAny(rest, " \t#")
+		if end < 0 {
+			end = len(rest)
+		}
+		value, rest = rest[:end], rest[end:]
+		if value, err = parseTOMLScalar(value); err != nil {
+			return "", "", false, err
+		}
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if rest != "" && rest[0] != '#' {
+		return "", "", false, fmt.Errorf("unexpected %q after value", rest)
+	}
+	return key, value, true, nil
+}
+
+// parseTOMLScalar returns the canonical text of a TOML integer, float or boolean.
+func parseTOMLScalar(s string) (string, error) {
+	if s == "true" || s == "false" {
+		return s, nil
+	}
+	digits := strings.ReplaceAll(s, "_", "")
+	if n, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return strconv.FormatInt(n, 10), nil
+	}
+	if _, err := strconv.ParseFloat(digits, 64); err == nil && digits != "" && strings.ContainsAny(digits, "0123456789") {
+		return digits, nil
+	}
+	return "", fmt.Errorf("unsupported value %q", s)
+}
+
+// parseTOMLString parses a basic or literal string at the start of s, and returns it with the rest
+// of s.
+func parseTOMLString(s string) (value, rest string, err error) {
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], nil
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			break
+		}
+		switch s[i] {
+		case '"', '\\':
+			b.WriteByte(s[i])
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'u', 'U':
+			size := 4
+			if s[i] == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				return "", "", errors.New("invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", "", fmt.Errorf("invalid unicode escape %q", s[i-1:i+1+size])
+			}
+			b.WriteRune(rune(r))
+			i += size
+		default:
+			return "", "", fmt.Errorf("invalid escape %q", s[i-1:i+1])
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -12,16 +13,28 @@ type PrefixDB struct {
 	db     DB
 }
 
-var _ DB = (*PrefixDB)(nil)
+var (
+	_ DB                 = (*PrefixDB)(nil)
+	_ CapabilityReporter = (*PrefixDB)(nil)
+)
 
-// NewPrefixDB lets you namespace multiple DBs within a single DB.
+// NewPrefixDB lets you namespace multiple DBs within a single DB. It panics if prefix is empty,
+// since the namespace would then be the whole database.
 func NewPrefixDB(db DB, prefix []byte) *PrefixDB {
+	if len(prefix) == 0 {
+		panic("NewPrefixDB: prefix must not be empty")
+	}
 	return &PrefixDB{
 		prefix: prefix,
 		db:     db,
 	}
 }
 
+// Unwrap implements Unwrapper.
+func (pdb *PrefixDB) Unwrap() DB {
+	return pdb.db
+}
+
 // Get implements DB.
 func (pdb *PrefixDB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
@@ -67,7 +80,7 @@ func (pdb *PrefixDB) Set(key []byte, value []byte) error {
 
 	pkey := pdb.prefixed(key)
 	if err := pdb.db.Set(pkey, value); err != nil {
-		return err
+		return unprefixedKeyError(err, pdb.prefix)
 	}
 	return nil
 }
@@ -83,7 +96,7 @@ func (pdb *PrefixDB) SetSync(key []byte, value []byte) error {
 	pdb.mtx.Lock()
 	defer pdb.mtx.Unlock()
 
-	return pdb.db.SetSync(pdb.prefixed(key), value)
+	return unprefixedKeyError(pdb.db.SetSync(pdb.prefixed(key), value), pdb.prefix)
 }
 
 // Delete implements DB.
@@ -125,7 +138,7 @@ func (pdb *PrefixDB) Iterator(start, end []byte) (Iterator, error) {
 	}
 	itr, err := pdb.db.Iterator(pstart, pend)
 	if err != nil {
-		return nil, err
+		return nil, unprefixedRangeError(err, start, end)
 	}
 
 	return newPrefixIterator(pdb.prefix, start, end, itr)
@@ -148,7 +161,7 @@ func (pdb *PrefixDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	}
 	ritr, err := pdb.db.ReverseIterator(pstart, pend)
 	if err != nil {
-		return nil, err
+		return nil, unprefixedRangeError(err, start, end)
 	}
 
 	return newPrefixIterator(pdb.prefix, start, end, ritr)
@@ -159,11 +172,12 @@ func (pdb *PrefixDB) NewBatch() Batch {
 	pdb.mtx.Lock()
 	defer pdb.mtx.Unlock()
 
-	return newPrefixBatch(pdb.prefix, pdb.db.NewBatch())
+	return newPrefixBatch(pdb.prefix, pdb.db)
 }
 
 // Close implements DB.
 func (pdb *PrefixDB) Close() error {
+	reportClosed(pdb)
 	pdb.mtx.Lock()
 	defer pdb.mtx.Unlock()
 
@@ -187,6 +201,36 @@ func (pdb *PrefixDB) Print() error {
 	return nil
 }
 
+// Capabilities implements CapabilityReporter. The maximum key size of the underlying database is
+// reduced by the length of the prefix.
+func (pdb *PrefixDB) Capabilities() Capabilities {
+	caps := DBCapabilities(pdb.db)
+	if caps.MaxKeySize > 0 {
+		caps.MaxKeySize -= len(pdb.prefix)
+	}
+	return caps
+}
+
+// unprefixedKeyError translates an ErrKeyTooLarge for a prefixed key into one for the key without
+// the prefix. Other errors are returned unchanged.
+func unprefixedKeyError(err error, prefix []byte) error {
+	var tooLarge ErrKeyTooLarge
+	if !errors.As(err, &tooLarge) {
+		return err
+	}
+	return ErrKeyTooLarge{Size: tooLarge.Size - len(prefix), Max: tooLarge.Max - len(prefix)}
+}
+
+// unprefixedRangeError translates an ErrInvalidRange for the prefixed bounds of an iterator into
+// one for the given bounds. Other errors are returned unchanged.
+func unprefixedRangeError(err error, start, end []byte) error {
+	var invalid ErrInvalidRange
+	if !errors.As(err, &invalid) {
+		return err
+	}
+	return ErrInvalidRange{Start: start, End: end}
+}
+
 // Stats implements DB.
 func (pdb *PrefixDB) Stats() map[string]string {
 	stats := make(map[string]string)
@@ -199,6 +243,18 @@ func (pdb *PrefixDB) Stats() map[string]string {
 	return stats
 }
 
+// Compact implements DB, compacting the domain of the underlying database within the prefix.
+func (pdb *PrefixDB) Compact(start, end []byte) error {
+	pstart := append(cp(pdb.prefix), start...)
+	var pend []byte
+	if end == nil {
+		pend = cpIncr(pdb.prefix)
+	} else {
+		pend = append(cp(pdb.prefix), end...)
+	}
+	return pdb.db.Compact(pstart, pend)
+}
+
 func (pdb *PrefixDB) prefixed(key []byte) []byte {
 	return append(cp(pdb.prefix), key...)
 }
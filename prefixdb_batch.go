@@ -2,20 +2,25 @@ package db
 
 type prefixDBBatch struct {
 	prefix []byte
+	db     DB
 	source Batch
 }
 
-var _ Batch = (*prefixDBBatch)(nil)
+var (
+	_ Batch           = (*prefixDBBatch)(nil)
+	_ ResettableBatch = (*prefixDBBatch)(nil)
+)
 
-func newPrefixBatch(prefix []byte, source Batch) prefixDBBatch {
-	return prefixDBBatch{
+func newPrefixBatch(prefix []byte, db DB) *prefixDBBatch {
+	return &prefixDBBatch{
 		prefix: prefix,
-		source: source,
+		db:     db,
+		source: db.NewBatch(),
 	}
 }
 
 // Set implements Batch.
-func (pb prefixDBBatch) Set(key, value []byte) error {
+func (pb *prefixDBBatch) Set(key, value []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
@@ -23,11 +28,11 @@ func (pb prefixDBBatch) Set(key, value []byte) error {
 		return errValueNil
 	}
 	pkey := append(cp(pb.prefix), key...)
-	return pb.source.Set(pkey, value)
+	return unprefixedKeyError(pb.source.Set(pkey, value), pb.prefix)
 }
 
 // Delete implements Batch.
-func (pb prefixDBBatch) Delete(key []byte) error {
+func (pb *prefixDBBatch) Delete(key []byte) error {
 	if len(key) == 0 {
 		return errKeyEmpty
 	}
@@ -36,16 +41,21 @@ func (pb prefixDBBatch) Delete(key []byte) error {
 }
 
 // Write implements Batch.
-func (pb prefixDBBatch) Write() error {
+func (pb *prefixDBBatch) Write() error {
 	return pb.source.Write()
 }
 
 // WriteSync implements Batch.
-func (pb prefixDBBatch) WriteSync() error {
+func (pb *prefixDBBatch) WriteSync() error {
 	return pb.source.WriteSync()
 }
 
 // Close implements Batch.
-func (pb prefixDBBatch) Close() error {
+func (pb *prefixDBBatch) Close() error {
 	return pb.source.Close()
 }
+
+// Reset implements ResettableBatch.
+func (pb *prefixDBBatch) Reset() {
+	pb.source = resetBatch(pb.source, pb.db.NewBatch)
+}
@@ -5,27 +5,62 @@ import (
 	"fmt"
 )
 
+// prefixDomain returns the domain [start, end) of keys with the given prefix. The end is nil if
+// no key is greater than all keys with the prefix, i.e. the prefix consists of 0xFF bytes.
+func prefixDomain(prefix []byte) (start, end []byte) {
+	if len(prefix) == 0 {
+		return nil, nil
+	}
+	return cp(prefix), cpIncr(prefix)
+}
+
 // IteratePrefix is a convenience function for iterating over a key domain
 // restricted by prefix.
 func IteratePrefix(db DB, prefix []byte) (Iterator, error) {
-	var start, end []byte
-	if len(prefix) == 0 {
-		start = nil
-		end = nil
-	} else {
-		start = cp(prefix)
-		end = cpIncr(prefix)
+	start, end := prefixDomain(prefix)
+	itr, err := db.Iterator(start, end)
+	if err != nil {
+		return nil, err
 	}
+	return itr, nil
+}
 
-	itr, err := db.Iterator(start, end)
+// IteratePrefixReverse is like IteratePrefix, but iterates in descending key order, e.g. to scan
+// the newest entries of a height-ordered index first.
+func IteratePrefixReverse(db DB, prefix []byte) (Iterator, error) {
+	start, end := prefixDomain(prefix)
+	itr, err := db.ReverseIterator(start, end)
 	if err != nil {
 		return nil, err
 	}
 	return itr, nil
 }
 
+// PrefixRange returns the range [start, end) of the keys with the given prefix, for Iterator and
+// ReverseIterator. The end is nil if prefix consists of 0xFF bytes only, as no key is greater than
+// all keys with the prefix. Returns errKeyEmpty if prefix is empty.
+func PrefixRange(prefix []byte) (start, end []byte, err error) {
+	if len(prefix) == 0 {
+		return nil, nil, errKeyEmpty
+	}
+	start, end = prefixDomain(prefix)
+	return start, end, nil
+}
+
+// PrefixEnd returns the smallest key greater than all keys with the given prefix: the prefix without
+// its trailing 0xFF bytes, with its last byte incremented. It is nil if prefix consists of 0xFF
+// bytes only. Returns errKeyEmpty if prefix is empty.
+func PrefixEnd(prefix []byte) ([]byte, error) {
+	if len(prefix) == 0 {
+		return nil, errKeyEmpty
+	}
+	return cpIncr(prefix), nil
+}
+
 // Strips prefix while iterating from Iterator.
 type prefixDBIterator struct {
+	iteratorGuard
+
 	prefix []byte
 	start  []byte
 	end    []byte
@@ -87,7 +122,9 @@ func (itr *prefixDBIterator) Valid() bool {
 
 // Next implements Iterator.
 func (itr *prefixDBIterator) Next() {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return
+	}
 	itr.source.Next()
 
 	if !itr.source.Valid() || !bytes.HasPrefix(itr.source.Key(), itr.prefix) {
@@ -101,14 +138,18 @@ func (itr *prefixDBIterator) Next() {
 
 // Next implements Iterator.
 func (itr *prefixDBIterator) Key() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	key := itr.source.Key()
 	return key[len(itr.prefix):] // we have checked the key in Valid()
 }
 
 // Value implements Iterator.
 func (itr *prefixDBIterator) Value() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return itr.source.Value()
 }
 
@@ -117,7 +158,10 @@ func (itr *prefixDBIterator) Error() error {
 	if err := itr.source.Error(); err != nil {
 		return err
 	}
-	return itr.err
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.misuseError()
 }
 
 // Close implements Iterator.
@@ -125,8 +169,6 @@ func (itr *prefixDBIterator) Close() error {
 	return itr.source.Close()
 }
 
-func (itr *prefixDBIterator) assertIsValid() {
-	if !itr.Valid() {
-		panic("iterator is invalid")
-	}
+func (itr *prefixDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
 }
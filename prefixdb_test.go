@@ -115,3 +115,65 @@ func TestPrefixDBReverseIterator7(t *testing.T) {
 	checkInvalid(t, itr)
 	itr.Close()
 }
+
+// keySizeLimitDB is a MemDB declaring a maximum key size, which it enforces on Set.
+type keySizeLimitDB struct {
+	*MemDB
+	max int
+}
+
+func (db keySizeLimitDB) Capabilities() Capabilities {
+	return Capabilities{MaxKeySize: db.max}
+}
+
+func (db keySizeLimitDB) Set(key, value []byte) error {
+	if err := checkKeySize(key, db.max); err != nil {
+		return err
+	}
+	return db.MemDB.Set(key, value)
+}
+
+func TestPrefixDBKeySizeLimit(t *testing.T) {
+	pdb := NewPrefixDB(keySizeLimitDB{MemDB: NewMemDB(), max: 10}, []byte("pre/"))
+	require.Equal(t, Capabilities{MaxKeySize: 6}, DBCapabilities(pdb))
+	require.NoError(t, pdb.Set([]byte("123456"), []byte{1}))
+	require.Equal(t, ErrKeyTooLarge{Size: 7, Max: 6}, pdb.Set([]byte("1234567"), []byte{1}))
+
+	require.Equal(t, Capabilities{}, DBCapabilities(NewPrefixDB(NewMemDB(), []byte("pre/"))))
+}
+
+func TestPrefixDBEmptyPrefix(t *testing.T) {
+	require.Panics(t, func() { NewPrefixDB(NewMemDB(), nil) })
+	require.Panics(t, func() { NewPrefixDB(NewMemDB(), []byte{}) })
+}
+
+func TestPrefixDBFFPrefix(t *testing.T) {
+	db := NewMemDB()
+	require.NoError(t, db.Set([]byte{0x01, 0xfe}, bz("before")))
+	require.NoError(t, db.Set([]byte{0x01, 0xff, 'a'}, bz("a")))
+	require.NoError(t, db.Set([]byte{0x01, 0xff, 0xff}, bz("ff")))
+	require.NoError(t, db.Set([]byte{0x02}, bz("next")))
+	require.NoError(t, db.Set([]byte{0x02, 0x00}, bz("after")))
+
+	// Unbounded iteration stops at the keys of the next prefix, in both directions.
+	pdb := NewPrefixDB(db, []byte{0x01, 0xff})
+	checkSameIteration(t, mustMemDB(t, map[string]string{"a": "a", "\xff": "ff"}), pdb, nil, nil)
+	checkSameIteration(t, mustMemDB(t, map[string]string{"\xff": "ff"}), pdb, []byte{0xff}, nil)
+
+	// A prefix of only 0xFF bytes extends to the last key.
+	require.NoError(t, db.Set([]byte{0xff, 0xff, 'z'}, bz("z")))
+	checkSameIteration(t, mustMemDB(t, map[string]string{"z": "z"}), NewPrefixDB(db, []byte{0xff, 0xff}), nil, nil)
+
+	require.Equal(t, []byte{0x02}, cpIncr([]byte{0x01, 0xff}))
+	require.Equal(t, []byte{0x01, 0x02}, cpIncr([]byte{0x01, 0x01}))
+	require.Nil(t, cpIncr([]byte{0xff, 0xff}))
+}
+
+// mustMemDB returns a memdb database holding entries.
+func mustMemDB(t *testing.T, entries map[string]string) DB {
+	db := NewMemDB()
+	for key, value := range entries {
+		require.NoError(t, db.Set([]byte(key), []byte(value)))
+	}
+	return db
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Provider opens many named logical databases from a single set of options, sharing resources
+// (such as the MongoDB client) between them.
+type Provider interface {
+	// DB returns the database with the given name, opening it if necessary. Calling DB again with
+	// the same name returns the same database until it is closed.
+	DB(name string) (DB, error)
+
+	// Close closes all databases opened by the provider, and any shared resources.
+	Close() error
+}
+
+// ProviderBatch is implemented by providers which can write to several of their databases
+// atomically, such as the MongoDB provider.
+type ProviderBatch interface {
+	// NewCrossBatch creates a batch of writes to databases of the provider. The caller must call
+	// CrossBatch.Close.
+	NewCrossBatch() CrossBatch
+}
+
+// CrossBatch is a group of writes to several databases of a Provider, which are written atomically.
+// The databases are identified by the names passed to Provider.DB, and are opened as needed.
+type CrossBatch interface {
+	// Set sets a key/value pair in the named database.
+	// CONTRACT: key, value readonly []byte
+	Set(name string, key, value []byte) error
+
+	// Delete deletes a key/value pair from the named database.
+	// CONTRACT: key readonly []byte
+	Delete(name string, key []byte) error
+
+	// Write writes the batch. Either all or none of its writes are applied. Only Close() can be
+	// called after.
+	Write() error
+
+	// Close closes the batch. It is idempotent, but calls to other methods afterwards will error.
+	Close() error
+}
+
+var errProviderClosed = errors.New("provider has been closed")
+
+// NewProvider creates a Provider for the given backend. Flat-file backends store each named
+// database in a subdirectory of the dir option, whose name must not contain path separators or be
+// "." or "..". They share no resources: e.g. each RocksDB database has its own environment and block
+// cache. MongoDB stores each named database in a collection of the same name using one shared
+// client, configured by the options like a database created by NewDB. MemDB keeps one in-memory
+// database per name.
+func NewProvider(backend BackendType, options Options) (Provider, error) {
+	p := &provider{
+		backend: backend,
+		options: options,
+		dbs:     make(map[string]*providerDB),
+	}
+
+	switch backend {
+	case MemDBBackend:
+	case MongoDBBackend:
+		settings, err := mongoSettingsFromOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		database, monitor, err := newMongoDatabase(context.Background(), options)
+		if err != nil {
+			return nil, err
+		}
+		p.mongoDatabase = database
+		p.mongoDriverMonitor = monitor
+		p.mongoCloseTimeout = settings.closeTimeout
+		return &mongoProvider{provider: p}, nil
+	default:
+		if _, ok := backends[backend]; !ok {
+			return nil, unknownBackendError(backend)
+		}
+		if _, ok := options[optionDir]; !ok {
+			return nil, fmt.Errorf("%s: %w", optionDir, errMissingOption)
+		}
+	}
+
+	return p, nil
+}
+
+type provider struct {
+	mtx     sync.Mutex
+	backend BackendType
+	options Options
+	dbs     map[string]*providerDB
+	closed  bool
+
+	// The mongo fields are only set for MongoDBBackend, and are shared by all collections, which
+	// are configured by options like databases created by NewDB, see newMongoDBFromOptions.
+	mongoDatabase      *mongo.Database
+	mongoDriverMonitor *MongoDriverMonitor
+	mongoCloseTimeout  time.Duration
+}
+
+var _ Provider = (*provider)(nil)
+
+// DB implements Provider.
+func (p *provider) DB(name string) (DB, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%s: %w", optionName, errMissingOption)
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.closed {
+		return nil, errProviderClosed
+	}
+	if db, ok := p.dbs[name]; ok {
+		return db, nil
+	}
+
+	db, err := p.open(name)
+	if err != nil {
+		return nil, err
+	}
+	pdb := &providerDB{DB: db, provider: p, name: name}
+	p.dbs[name] = pdb
+	return pdb, nil
+}
+
+// open opens a new database without locking the mutex.
+func (p *provider) open(name string) (DB, error) {
+	switch p.backend {
+	case MemDBBackend:
+		return NewMemDB(), nil
+	case MongoDBBackend:
+		return newMongoDBFromOptions(p.mongoDatabase.Collection(name), p.options, p.mongoDriverMonitor, true)
+	default:
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid database name %q: not a single path element", name)
+		}
+		dir := filepath.Join(p.options[optionDir], name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		options := make(Options, len(p.options))
+		for k, v := range p.options {
+			options[k] = v
+		}
+		options[optionName] = name
+		options[optionDir] = dir
+		return NewDB(p.backend, options)
+	}
+}
+
+// release forgets a database which has been closed by the caller.
+func (p *provider) release(name string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	delete(p.dbs, name)
+}
+
+// Close implements Provider.
+func (p *provider) Close() error {
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return nil
+	}
+	p.closed = true
+	dbs := p.dbs
+	p.dbs = make(map[string]*providerDB)
+	p.mtx.Unlock()
+
+	var errs []error
+	for _, pdb := range dbs {
+		pdb.once.Do(func() {
+			if err := pdb.DB.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		})
+	}
+	if p.mongoDatabase != nil {
+		if err := mongoDisconnect(p.mongoDatabase.Client(), p.mongoCloseTimeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// providerDB is a database opened by a provider. Closing it releases only its own resources.
+type providerDB struct {
+	DB
+	provider *provider
+	name     string
+	once     sync.Once
+}
+
+// Unwrap implements Unwrapper.
+func (pdb *providerDB) Unwrap() DB {
+	return pdb.DB
+}
+
+// Close implements DB.
+func (pdb *providerDB) Close() error {
+	var err error
+	pdb.once.Do(func() {
+		pdb.provider.release(pdb.name)
+		err = pdb.DB.Close()
+	})
+	return err
+}
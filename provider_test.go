@@ -0,0 +1,89 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderMemDB(t *testing.T) {
+	p, err := NewProvider(MemDBBackend, Options{})
+	require.NoError(t, err)
+
+	a, err := p.DB("a")
+	require.NoError(t, err)
+	b, err := p.DB("b")
+	require.NoError(t, err)
+
+	// Names are isolated from each other.
+	require.NoError(t, a.Set(bz("key"), bz("a")))
+	value, err := b.Get(bz("key"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	// The same name returns the same database.
+	a2, err := p.DB("a")
+	require.NoError(t, err)
+	value, err = a2.Get(bz("key"))
+	require.NoError(t, err)
+	require.Equal(t, bz("a"), value)
+
+	_, err = p.DB("")
+	require.ErrorIs(t, err, errMissingOption)
+
+	// Only the MongoDB provider can write to several databases atomically.
+	_, ok := p.(ProviderBatch)
+	require.False(t, ok)
+
+	require.NoError(t, p.Close())
+	_, err = p.DB("a")
+	require.Equal(t, errProviderClosed, err)
+}
+
+func TestProviderGoLevelDB(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewProvider(GoLevelDBBackend, Options{optionDir: dir})
+	require.NoError(t, err)
+
+	a, err := p.DB("a")
+	require.NoError(t, err)
+	b, err := p.DB("b")
+	require.NoError(t, err)
+
+	require.NoError(t, a.Set(bz("key"), bz("a")))
+	require.NoError(t, b.Set(bz("key"), bz("b")))
+
+	value, err := b.Get(bz("key"))
+	require.NoError(t, err)
+	require.Equal(t, bz("b"), value)
+
+	// Closing an individual database releases it, so it can be reopened.
+	require.NoError(t, a.Close())
+	require.NoError(t, a.Close())
+	a, err = p.DB("a")
+	require.NoError(t, err)
+	value, err = a.Get(bz("key"))
+	require.NoError(t, err)
+	require.Equal(t, bz("a"), value)
+
+	require.NoError(t, p.Close())
+	require.NoError(t, a.Close())
+
+	// Names cannot escape the directory of the provider.
+	p, err = NewProvider(GoLevelDBBackend, Options{optionDir: filepath.Join(dir, "provider")})
+	require.NoError(t, err)
+	for _, name := range []string{"..", ".", "../x", "a/b", `a\b`} {
+		_, err = p.DB(name)
+		require.Error(t, err, name)
+	}
+	require.NoError(t, p.Close())
+	_, err = os.Stat(filepath.Join(dir, "x"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = NewProvider(GoLevelDBBackend, Options{})
+	require.ErrorIs(t, err, errMissingOption)
+	_, err = NewProvider("unknown", Options{optionDir: dir})
+	require.Error(t, err)
+}
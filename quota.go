@@ -0,0 +1,247 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Options limiting the number of open handles, see QuotaDB.
+const (
+	optionMaxOpenIterators = "max_open_iterators"
+	optionMaxOpenBatches   = "max_open_batches"
+)
+
+// Kinds of handles limited by QuotaDB.
+const (
+	HandleKindIterator = "iterator"
+	HandleKindBatch    = "batch"
+)
+
+// ErrTooManyOpen is returned when opening a handle would exceed the limit of open handles of its
+// kind.
+type ErrTooManyOpen struct {
+	Kind  string
+	Limit int
+	Open  int
+}
+
+func (e ErrTooManyOpen) Error() string {
+	return fmt.Sprintf("too many open %ss: %d open, limit is %d", e.Kind, e.Open, e.Limit)
+}
+
+// OpenHandles is the number of currently open handles of a QuotaDB.
+type OpenHandles struct {
+	Iterators int
+	Batches   int
+}
+
+// QuotaDB wraps a database and enforces soft limits on the number of open iterators and batches,
+// so that leaked handles are caught before they exhaust server resources (e.g. MongoDB cursors).
+// When a limit is reached, Iterator and ReverseIterator return ErrTooManyOpen, and NewBatch returns
+// a batch whose methods return ErrTooManyOpen.
+//
+// NewDB wraps databases in a QuotaDB when the max_open_iterators or max_open_batches option is set.
+type QuotaDB struct {
+	DB
+
+	mtx          sync.Mutex
+	maxIterators int
+	maxBatches   int
+	open         OpenHandles
+}
+
+var (
+	_ DB             = (*QuotaDB)(nil)
+	_ Reconfigurable = (*QuotaDB)(nil)
+	_ Unwrapper      = (*QuotaDB)(nil)
+)
+
+// NewQuotaDB wraps db with the given limits. A limit of 0 means unlimited.
+func NewQuotaDB(db DB, maxIterators, maxBatches int) *QuotaDB {
+	return &QuotaDB{
+		DB:           db,
+		maxIterators: maxIterators,
+		maxBatches:   maxBatches,
+	}
+}
+
+// quotaFromOptions parses the handle limit options. ok is false if neither is set.
+func quotaFromOptions(options Options) (maxIterators, maxBatches int, ok bool, err error) {
+	for option, limit := range map[string]*int{
+		optionMaxOpenIterators: &maxIterators,
+		optionMaxOpenBatches:   &maxBatches,
+	} {
+		value, set := options[option]
+		if !set {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, false, fmt.Errorf("invalid %s %q", option, value)
+		}
+		*limit = n
+		ok = true
+	}
+	return maxIterators, maxBatches, ok, nil
+}
+
+// Reconfigure implements Reconfigurable, changing the limits set by the max_open_iterators and
+// max_open_batches options. Handles already open are not closed when a limit is lowered, but no
+// new ones can be opened until enough of them are released.
+func (qdb *QuotaDB) Reconfigure(opts Options) error {
+	maxIterators, maxBatches, ok, err := quotaFromOptions(opts)
+	if err != nil || !ok {
+		return err
+	}
+	qdb.mtx.Lock()
+	defer qdb.mtx.Unlock()
+	if _, set := opts[optionMaxOpenIterators]; set {
+		qdb.maxIterators = maxIterators
+	}
+	if _, set := opts[optionMaxOpenBatches]; set {
+		qdb.maxBatches = maxBatches
+	}
+	return nil
+}
+
+// Unwrap implements Unwrapper.
+func (qdb *QuotaDB) Unwrap() DB {
+	return qdb.DB
+}
+
+// OpenHandles returns the number of currently open iterators and batches.
+func (qdb *QuotaDB) OpenHandles() OpenHandles {
+	qdb.mtx.Lock()
+	defer qdb.mtx.Unlock()
+	return qdb.open
+}
+
+// acquire reserves a handle of the given kind, or returns ErrTooManyOpen.
+func (qdb *QuotaDB) acquire(kind string) error {
+	qdb.mtx.Lock()
+	defer qdb.mtx.Unlock()
+
+	count, limit := &qdb.open.Iterators, qdb.maxIterators
+	if kind == HandleKindBatch {
+		count, limit = &qdb.open.Batches, qdb.maxBatches
+	}
+	if limit > 0 && *count >= limit {
+		return ErrTooManyOpen{Kind: kind, Limit: limit, Open: *count}
+	}
+	*count++
+	return nil
+}
+
+// release releases a handle of the given kind.
+func (qdb *QuotaDB) release(kind string) {
+	qdb.mtx.Lock()
+	defer qdb.mtx.Unlock()
+
+	if kind == HandleKindBatch {
+		qdb.open.Batches--
+	} else {
+		qdb.open.Iterators--
+	}
+}
+
+// Iterator implements DB.
+func (qdb *QuotaDB) Iterator(start, end []byte) (Iterator, error) {
+	return qdb.iterator(qdb.DB.Iterator, start, end)
+}
+
+// ReverseIterator implements DB.
+func (qdb *QuotaDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	return qdb.iterator(qdb.DB.ReverseIterator, start, end)
+}
+
+func (qdb *QuotaDB) iterator(open func(start, end []byte) (Iterator, error), start, end []byte) (Iterator, error) {
+	if err := qdb.acquire(HandleKindIterator); err != nil {
+		return nil, err
+	}
+	itr, err := open(start, end)
+	if err != nil {
+		qdb.release(HandleKindIterator)
+		return nil, err
+	}
+	return &quotaIterator{Iterator: itr, db: qdb}, nil
+}
+
+// NewBatch implements DB.
+func (qdb *QuotaDB) NewBatch() Batch {
+	if err := qdb.acquire(HandleKindBatch); err != nil {
+		return failedBatch{err: err}
+	}
+	return &quotaBatch{Batch: qdb.DB.NewBatch(), db: qdb}
+}
+
+// quotaIterator releases its handle when closed.
+type quotaIterator struct {
+	Iterator
+	db       *QuotaDB
+	released sync.Once
+}
+
+// Close implements Iterator. The handle is released even if closing the source fails.
+func (itr *quotaIterator) Close() error {
+	itr.released.Do(func() { itr.db.release(HandleKindIterator) })
+	return itr.Iterator.Close()
+}
+
+// quotaBatch releases its handle when closed or successfully written.
+type quotaBatch struct {
+	Batch
+	db       *QuotaDB
+	released sync.Once
+}
+
+func (b *quotaBatch) release() {
+	b.released.Do(func() { b.db.release(HandleKindBatch) })
+}
+
+// Write implements Batch.
+func (b *quotaBatch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	b.release()
+	return nil
+}
+
+// WriteSync implements Batch.
+func (b *quotaBatch) WriteSync() error {
+	if err := b.Batch.WriteSync(); err != nil {
+		return err
+	}
+	b.release()
+	return nil
+}
+
+// Close implements Batch. The handle is released even if closing the source fails.
+func (b *quotaBatch) Close() error {
+	b.release()
+	return b.Batch.Close()
+}
+
+// failedBatch is returned by NewBatch when the batch cannot be created, and returns the error from
+// all methods.
+type failedBatch struct {
+	err error
+}
+
+var _ Batch = failedBatch{}
+
+// Set implements Batch.
+func (b failedBatch) Set(_, _ []byte) error { return b.err }
+
+// Delete implements Batch.
+func (b failedBatch) Delete(_ []byte) error { return b.err }
+
+// Write implements Batch.
+func (b failedBatch) Write() error { return b.err }
+
+// WriteSync implements Batch.
+func (b failedBatch) WriteSync() error { return b.err }
+
+// Close implements Batch.
+func (b failedBatch) Close() error { return nil }
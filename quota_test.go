@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkHandleQuota checks a database opened with max_open_iterators=2 and max_open_batches=1.
+func checkHandleQuota(t *testing.T, db DB) {
+	qdb, ok := db.(*QuotaDB)
+	require.True(t, ok, "expected a QuotaDB, got %T", db)
+
+	itr1, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	itr2, err := db.ReverseIterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, OpenHandles{Iterators: 2}, qdb.OpenHandles())
+
+	_, err = db.Iterator(nil, nil)
+	var tooMany ErrTooManyOpen
+	require.True(t, errors.As(err, &tooMany))
+	require.Equal(t, ErrTooManyOpen{Kind: HandleKindIterator, Limit: 2, Open: 2}, tooMany)
+
+	require.NoError(t, itr1.Close())
+	itr3, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, itr2.Close())
+	require.NoError(t, itr3.Close())
+	// Closing twice must not release twice.
+	require.NoError(t, itr3.Close())
+	require.Equal(t, OpenHandles{}, qdb.OpenHandles())
+
+	batch1 := db.NewBatch()
+	require.Equal(t, OpenHandles{Batches: 1}, qdb.OpenHandles())
+	batch2 := db.NewBatch()
+	err = batch2.Set([]byte("key"), []byte("value"))
+	require.Equal(t, ErrTooManyOpen{Kind: HandleKindBatch, Limit: 1, Open: 1}, err)
+	require.Equal(t, err, batch2.Write())
+	require.NoError(t, batch2.Close())
+
+	require.NoError(t, batch1.Set([]byte("key"), []byte("value")))
+	require.NoError(t, batch1.Write())
+	require.Equal(t, OpenHandles{}, qdb.OpenHandles())
+	require.NoError(t, batch1.Close())
+	require.Equal(t, OpenHandles{}, qdb.OpenHandles())
+
+	batch3 := db.NewBatch()
+	require.NoError(t, batch3.Set([]byte("key"), []byte("value")))
+	require.NoError(t, batch3.Close())
+	require.Equal(t, OpenHandles{}, qdb.OpenHandles())
+}
+
+func TestQuotaDBMemDB(t *testing.T) {
+	db, err := NewDB(MemDBBackend, Options{
+		optionMaxOpenIterators: "2",
+		optionMaxOpenBatches:   "1",
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	checkHandleQuota(t, db)
+}
+
+func TestQuotaDBInvalidOption(t *testing.T) {
+	_, err := NewDB(MemDBBackend, Options{optionMaxOpenIterators: "many"})
+	require.Error(t, err)
+	_, err = NewDB(MemDBBackend, Options{optionMaxOpenBatches: "-1"})
+	require.Error(t, err)
+}
+
+func TestQuotaDBUnset(t *testing.T) {
+	db, err := NewDB(MemDBBackend, Options{})
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, ok := db.(*QuotaDB)
+	require.False(t, ok)
+}
+
+// failingCloseIterator is an iterator whose Close always fails.
+type failingCloseIterator struct {
+	Iterator
+}
+
+func (itr failingCloseIterator) Close() error {
+	_ = itr.Iterator.Close()
+	return errors.New("close failed")
+}
+
+type failingCloseDB struct {
+	DB
+}
+
+func (db failingCloseDB) Iterator(start, end []byte) (Iterator, error) {
+	itr, err := db.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return failingCloseIterator{itr}, nil
+}
+
+func TestQuotaDBReleaseOnCloseError(t *testing.T) {
+	qdb := NewQuotaDB(failingCloseDB{NewMemDB()}, 1, 0)
+
+	itr, err := qdb.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Error(t, itr.Close())
+	require.Equal(t, OpenHandles{}, qdb.OpenHandles())
+
+	itr, err = qdb.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Error(t, itr.Close())
+}
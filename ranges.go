@@ -0,0 +1,23 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// optionLenientRanges makes iterators with a start after their end return an empty iterator
+// instead of ErrInvalidRange, as older versions did.
+const optionLenientRanges = "lenient_ranges"
+
+// lenientRanges returns whether the lenient_ranges option is set.
+func lenientRanges(options Options) (bool, error) {
+	s, ok := options[optionLenientRanges]
+	if !ok {
+		return false, nil
+	}
+	lenient, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", optionLenientRanges, s, err)
+	}
+	return lenient, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// errNotReconfigurable is returned by ReconfigureAll when no layer of a database can be
+// reconfigured.
+var errNotReconfigurable = errors.New("database cannot be reconfigured")
+
+// ErrImmutableOptions is returned when reconfiguring options which cannot be changed while a
+// database is open, see Reconfigurable.
+type ErrImmutableOptions struct {
+	// Keys are the offending options, sorted.
+	Keys []string
+}
+
+func (e *ErrImmutableOptions) Error() string {
+	return fmt.Sprintf("options cannot be changed while open: %s", strings.Join(e.Keys, ", "))
+}
+
+// checkImmutableOptions returns an *ErrImmutableOptions listing the immutable keys whose value in
+// opts differs from their current value.
+func checkImmutableOptions(opts Options, current map[string]string) error {
+	var keys []string
+	for key, value := range current {
+		if newValue, ok := opts[key]; ok && newValue != value {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return &ErrImmutableOptions{Keys: keys}
+}
+
+// ReconfigureAll reconfigures every layer of db which implements Reconfigurable, from the
+// outermost wrapper to the backend, following Unwrapper. It stops at the first error, in which case
+// the outer layers have already been reconfigured. It fails if no layer is reconfigurable.
+func ReconfigureAll(db DB, opts Options) error {
+	var reconfigured bool
+	for db != nil {
+		if r, ok := db.(Reconfigurable); ok {
+			if err := r.Reconfigure(opts); err != nil {
+				return err
+			}
+			reconfigured = true
+		}
+		u, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	if !reconfigured {
+		return errNotReconfigurable
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconfigureQuota(t *testing.T) {
+	qdb := NewQuotaDB(NewMemDB(), 1, 0)
+	// The quota is reconfigured through the wrappers around it.
+	db := NewExpvarDB(NewWriteOnceDB(qdb), "reconfigure_test_"+randStr(8))
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+	_, err = db.Iterator(nil, nil)
+	require.ErrorAs(t, err, &ErrTooManyOpen{})
+
+	// Raising the limit allows more iterators at once.
+	require.NoError(t, ReconfigureAll(db, Options{optionMaxOpenIterators: "2", "unrelated": "x"}))
+	second, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = db.Iterator(nil, nil)
+	require.ErrorAs(t, err, &ErrTooManyOpen{})
+
+	// Lowering it does not close open iterators, and the batch limit is unchanged.
+	require.NoError(t, ReconfigureAll(db, Options{optionMaxOpenIterators: "1"}))
+	require.Equal(t, 2, qdb.OpenHandles().Iterators)
+	require.NoError(t, second.Close())
+	_, err = db.Iterator(nil, nil)
+	require.ErrorAs(t, err, &ErrTooManyOpen{})
+	require.NoError(t, db.NewBatch().Close())
+
+	require.Error(t, ReconfigureAll(db, Options{optionMaxOpenIterators: "-1"}))
+	require.Equal(t, errNotReconfigurable, ReconfigureAll(NewMemDB(), Options{}))
+}
+
+func TestReconfigureMongoDB(t *testing.T) {
+	mdb := NewMongoDB(connectUnreachable(t).Database("testing").Collection("reconfigure"))
+	mdb.options = Options{"connection_string": "mongodb://127.0.0.1:1", mongoOptionRecordCodec: DefaultRecordCodec}
+	defer mdb.Close()
+	db := NewQuotaDB(mdb, 0, 0)
+
+	require.NoError(t, ReconfigureAll(db, Options{
+		mongoOptionMaxQueryTime: "250",
+		mongoOptionReadMode:     mongoReadModeNearest,
+		optionLenientRanges:     "true",
+		// Unchanged immutable options are accepted.
+		"collection":           "reconfigure",
+		mongoOptionRecordCodec: DefaultRecordCodec,
+	}))
+	require.Equal(t, 250*time.Millisecond, mdb.queryTime())
+	require.Equal(t, "nearest", mdb.EffectiveReadPreference().Mode().String())
+	require.True(t, mdb.lenientRanges.Load())
+
+	// Changing immutable options fails without applying anything.
+	err := ReconfigureAll(db, Options{
+		mongoOptionMaxQueryTime: "500",
+		"collection":            "other",
+		mongoOptionRecordCodec:  "custom",
+		"connection_string":     "mongodb://127.0.0.1:1",
+	})
+	var immutable *ErrImmutableOptions
+	require.ErrorAs(t, err, &immutable)
+	require.Equal(t, []string{"collection", mongoOptionRecordCodec}, immutable.Keys)
+	require.Equal(t, 250*time.Millisecond, mdb.queryTime())
+
+	require.Error(t, mdb.Reconfigure(Options{mongoOptionReadMode: "fastest"}))
+	require.Equal(t, "nearest", mdb.EffectiveReadPreference().Mode().String())
+}
@@ -0,0 +1,284 @@
+//go:build redisdb
+// +build redisdb
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisOptionAddress is the host:port address of the Redis server.
+	redisOptionAddress = "address"
+	// redisOptionPassword is the password of the Redis server, if any.
+	redisOptionPassword = "password"
+	// redisOptionDB is the number of the Redis database. Absent uses database 0.
+	redisOptionDB = "db"
+	// redisOptionKeyPrefix is prepended to the names of the Redis keys of the database, so that
+	// several databases can share a Redis database. Absent uses the name option followed by a
+	// colon, or redisDefaultKeyPrefix without name.
+	redisOptionKeyPrefix = "key_prefix"
+)
+
+// redisDefaultKeyPrefix is the key prefix of databases without key_prefix and name options.
+const redisDefaultKeyPrefix = "cometbft-db:"
+
+// redisIteratorPageSize is the number of keys iterators read from the server at once.
+const redisIteratorPageSize = 1000
+
+func init() { registerDBCreatorWithContext(RedisDBBackend, redisDBCreator, false) }
+
+func redisDBCreator(ctx context.Context, options Options) (DB, error) {
+	address, ok := options[redisOptionAddress]
+	if !ok {
+		return nil, errors.Wrap(errMissingOption, redisOptionAddress)
+	}
+	number := 0
+	if s, ok := options[redisOptionDB]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a database number", redisOptionDB, s)
+		}
+		number = n
+	}
+	prefix := redisDefaultKeyPrefix
+	if name, ok := options[optionName]; ok {
+		prefix = name + ":"
+	}
+	prefix = options.GetStringOr(redisOptionKeyPrefix, prefix)
+	lenient, err := lenientRanges(options)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     address,
+		Password: options[redisOptionPassword],
+		DB:       number,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to Redis: %w", err)
+	}
+	db := NewRedisDB(client, prefix)
+	db.lenientRanges = lenient
+	return db, nil
+}
+
+// RedisDB is a database stored in Redis, meant for ephemeral deployments such as testnets. The
+// values are stored in a hash, and the keys are also members of a sorted set whose members all
+// have the same score, so that they are ordered lexicographically for range iteration.
+//
+// Durability depends on the persistence configuration of the server: without an append-only file
+// with appendfsync always, acknowledged writes can be lost when the server stops, and SetSync,
+// DeleteSync and WriteSync are no more durable than their asynchronous variants. Iterators read
+// the keys in pages, so they do not see a snapshot: writes made while iterating may or may not be
+// visible, though each key is seen at most once and keys deleted meanwhile are skipped.
+type RedisDB struct {
+	client *redis.Client
+	// values is the hash of the values by key, and index the sorted set of the keys.
+	values string
+	index  string
+
+	// pageSize is the number of keys iterators read at once.
+	pageSize int
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
+}
+
+var (
+	_ DB            = (*RedisDB)(nil)
+	_ StatsProvider = (*RedisDB)(nil)
+	_ Pinger        = (*RedisDB)(nil)
+)
+
+// NewRedisDB returns the database stored under the keys with the given prefix of the Redis
+// database of client. Closing the database closes the client.
+func NewRedisDB(client *redis.Client, keyPrefix string) *RedisDB {
+	return &RedisDB{
+		client:   client,
+		values:   keyPrefix + "values",
+		index:    keyPrefix + "index",
+		pageSize: redisIteratorPageSize,
+	}
+}
+
+// Get implements DB.
+func (db *RedisDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	value, err := db.client.HGet(context.Background(), db.values, string(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Has implements DB.
+func (db *RedisDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	return db.client.HExists(context.Background(), db.values, string(key)).Result()
+}
+
+// Set implements DB.
+func (db *RedisDB) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return db.write([]operation{{opTypeSet, key, value}})
+}
+
+// SetSync implements DB. It is no more durable than Set, see RedisDB.
+func (db *RedisDB) SetSync(key, value []byte) error {
+	return db.Set(key, value)
+}
+
+// Delete implements DB.
+func (db *RedisDB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return db.write([]operation{{opTypeDelete, key, nil}})
+}
+
+// DeleteSync implements DB. It is no more durable than Delete, see RedisDB.
+func (db *RedisDB) DeleteSync(key []byte) error {
+	return db.Delete(key)
+}
+
+// write applies ops atomically, in a MULTI/EXEC transaction which updates both the values and
+// the index.
+func (db *RedisDB) write(ops []operation) error {
+	ctx := context.Background()
+	_, err := db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, op := range ops {
+			key := string(op.key)
+			switch op.opType {
+			case opTypeSet:
+				pipe.HSet(ctx, db.values, key, op.value)
+				pipe.ZAdd(ctx, db.index, redis.Z{Member: key})
+			case opTypeDelete:
+				pipe.HDel(ctx, db.values, key)
+				pipe.ZRem(ctx, db.index, key)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Iterator implements DB.
+func (db *RedisDB) Iterator(start, end []byte) (Iterator, error) {
+	return db.newIterator(start, end, false)
+}
+
+// ReverseIterator implements DB.
+func (db *RedisDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	return db.newIterator(start, end, true)
+}
+
+func (db *RedisDB) newIterator(start, end []byte, reverse bool) (Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
+	return newRedisDBIterator(db, start, end, reverse)
+}
+
+// Close implements DB. Closing a closed database is a no-op.
+func (db *RedisDB) Close() error {
+	reportClosed(db)
+	if err := db.client.Close(); err != nil && !errors.Is(err, redis.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Ping implements Pinger. Any error other than the cancellation of ctx wraps ErrUnavailable.
+func (db *RedisDB) Ping(ctx context.Context) error {
+	err := db.client.Ping(ctx).Err()
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// NewBatch implements DB.
+func (db *RedisDB) NewBatch() Batch {
+	return newRedisDBBatch(db)
+}
+
+// Print implements DB.
+func (db *RedisDB) Print() error {
+	itr, err := db.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		fmt.Printf("[%X]:\t[%X]\n", itr.Key(), itr.Value())
+	}
+	return itr.Error()
+}
+
+// Stats implements DB.
+func (db *RedisDB) Stats() map[string]string {
+	stats, err := db.TypedStats()
+	if err != nil {
+		stats.Raw["error"] = err.Error()
+	}
+	return stats.Map()
+}
+
+// TypedStats implements StatsProvider, with the number of fields of the hash of values as the
+// number of keys, and the memory used by the hash and the sorted set, as estimated by the MEMORY
+// USAGE command, as the memory size. The size on disk depends on the persistence configuration
+// of the server, and is not known.
+func (db *RedisDB) TypedStats() (DBStats, error) {
+	ctx := context.Background()
+	stats := DBStats{KeyCount: -1, DiskSizeBytes: -1, MemSizeBytes: -1, Raw: map[string]string{
+		"redis.values": db.values,
+		"redis.index":  db.index,
+	}}
+	count, err := db.client.HLen(ctx, db.values).Result()
+	if err != nil {
+		return stats, err
+	}
+	stats.KeyCount = count
+	if count == 0 {
+		stats.MemSizeBytes = 0
+		return stats, nil
+	}
+
+	var size int64
+	for _, key := range []string{db.values, db.index} {
+		n, err := db.client.MemoryUsage(ctx, key).Result()
+		if err != nil {
+			return stats, err
+		}
+		size += n
+	}
+	stats.MemSizeBytes = size
+	return stats, nil
+}
+
+// Compact implements DB. It does nothing, since Redis frees the memory of deleted keys.
+func (db *RedisDB) Compact(_, _ []byte) error {
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build redisdb
+// +build redisdb
+
+package db
+
+// redisDBBatch stores operations internally and writes them to Redis in a single MULTI/EXEC
+// transaction on Write().
+type redisDBBatch struct {
+	db  *RedisDB
+	ops []operation
+}
+
+var (
+	_ Batch           = (*redisDBBatch)(nil)
+	_ ResettableBatch = (*redisDBBatch)(nil)
+)
+
+func newRedisDBBatch(db *RedisDB) *redisDBBatch {
+	return &redisDBBatch{
+		db:  db,
+		ops: []operation{},
+	}
+}
+
+// Set implements Batch.
+func (b *redisDBBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeSet, key, value})
+	return nil
+}
+
+// Delete implements Batch.
+func (b *redisDBBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeDelete, key, nil})
+	return nil
+}
+
+// Write implements Batch.
+func (b *redisDBBatch) Write() error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	if err := b.db.write(b.ops); err != nil {
+		return err
+	}
+	// Make sure batch cannot be used afterwards. Callers should still call Close(), for errors.
+	return b.Close()
+}
+
+// WriteSync implements Batch. It is no more durable than Write, see RedisDB.
+func (b *redisDBBatch) WriteSync() error {
+	return b.Write()
+}
+
+// Close implements Batch.
+func (b *redisDBBatch) Close() error {
+	b.ops = nil
+	return nil
+}
+
+// Reset implements ResettableBatch.
+func (b *redisDBBatch) Reset() {
+	b.ops = []operation{}
+}
@@ -0,0 +1,163 @@
+//go:build redisdb
+// +build redisdb
+
+package db
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDBIterator iterates over the sorted set of keys of a RedisDB in pages, reading the values of
+// each page with a single HMGET. Keys deleted between the read of a page of keys and the read of
+// their values are skipped.
+type redisDBIterator struct {
+	iteratorGuard
+
+	db      *RedisDB
+	start   []byte
+	end     []byte
+	reverse bool
+
+	// keys and values are the current page, with the current entry at index 0.
+	keys   []string
+	values [][]byte
+	// next is the lexicographical bound continuing the iteration after the current page, and done
+	// is set once the current page is the last one.
+	next string
+	done bool
+
+	err error
+}
+
+var _ Iterator = (*redisDBIterator)(nil)
+
+func newRedisDBIterator(db *RedisDB, start, end []byte, reverse bool) (*redisDBIterator, error) {
+	itr := &redisDBIterator{
+		db:      db,
+		start:   start,
+		end:     end,
+		reverse: reverse,
+	}
+	// Keys are compared bytewise by ZRANGEBYLEX, "[" includes the bound and "(" excludes it.
+	if reverse {
+		itr.next = "+"
+		if end != nil {
+			itr.next = "(" + string(end)
+		}
+	} else {
+		itr.next = "-"
+		if start != nil {
+			itr.next = "[" + string(start)
+		}
+	}
+	itr.fetch()
+	if itr.err != nil {
+		return nil, itr.err
+	}
+	return itr, nil
+}
+
+// fetch reads pages until one has a key which still exists, or the domain is exhausted.
+func (itr *redisDBIterator) fetch() {
+	ctx := context.Background()
+	for len(itr.keys) == 0 && !itr.done {
+		rng := &redis.ZRangeBy{Count: int64(itr.db.pageSize)}
+		var keys []string
+		var err error
+		if itr.reverse {
+			rng.Max, rng.Min = itr.next, "-"
+			if itr.start != nil {
+				rng.Min = "[" + string(itr.start)
+			}
+			keys, err = itr.db.client.ZRevRangeByLex(ctx, itr.db.index, rng).Result()
+		} else {
+			rng.Min, rng.Max = itr.next, "+"
+			if itr.end != nil {
+				rng.Max = "(" + string(itr.end)
+			}
+			keys, err = itr.db.client.ZRangeByLex(ctx, itr.db.index, rng).Result()
+		}
+		if err != nil {
+			itr.err = err
+			return
+		}
+		if len(keys) < itr.db.pageSize {
+			itr.done = true
+		}
+		if len(keys) == 0 {
+			return
+		}
+		itr.next = "(" + keys[len(keys)-1]
+
+		values, err := itr.db.client.HMGet(ctx, itr.db.values, keys...).Result()
+		if err != nil {
+			itr.err = err
+			return
+		}
+		for i, value := range values {
+			s, ok := value.(string)
+			if !ok {
+				// Deleted since the keys were read.
+				continue
+			}
+			itr.keys = append(itr.keys, keys[i])
+			itr.values = append(itr.values, []byte(s))
+		}
+	}
+}
+
+// Domain implements Iterator.
+func (itr *redisDBIterator) Domain() ([]byte, []byte) {
+	return itr.start, itr.end
+}
+
+// Valid implements Iterator.
+func (itr *redisDBIterator) Valid() bool {
+	return itr.err == nil && len(itr.keys) > 0
+}
+
+// Next implements Iterator.
+func (itr *redisDBIterator) Next() {
+	if !itr.assertIsValid() {
+		return
+	}
+	itr.keys, itr.values = itr.keys[1:], itr.values[1:]
+	itr.fetch()
+}
+
+// Key implements Iterator.
+func (itr *redisDBIterator) Key() []byte {
+	if !itr.assertIsValid() {
+		return nil
+	}
+	return []byte(itr.keys[0])
+}
+
+// Value implements Iterator.
+func (itr *redisDBIterator) Value() []byte {
+	if !itr.assertIsValid() {
+		return nil
+	}
+	return append([]byte{}, itr.values[0]...)
+}
+
+// Error implements Iterator.
+func (itr *redisDBIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *redisDBIterator) Close() error {
+	itr.keys, itr.values = nil, nil
+	itr.done = true
+	return nil
+}
+
+func (itr *redisDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
+}
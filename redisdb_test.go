@@ -0,0 +1,193 @@
+//go:build redisdb
+// +build redisdb
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// redisTestExpiry is the number of seconds after which Docker stops the Redis container, in case
+// the tests are interrupted before purging it.
+const redisTestExpiry = 3600
+
+var (
+	redisTestMtx      sync.Mutex
+	redisTestPool     *dockertest.Pool
+	redisTestResource *dockertest.Resource
+	redisTestAddress  string
+)
+
+func init() {
+	// Each database of the backend suite gets its own key prefix, as the names are reused.
+	testBackendOptions[RedisDBBackend] = func(t *testing.T, name, dir string) Options {
+		return Options{
+			redisOptionAddress:   redisTestServer(t),
+			redisOptionKeyPrefix: filepath.Base(dir) + ":" + name + ":",
+		}
+	}
+	testContainerPurges = append(testContainerPurges, func() error {
+		redisTestMtx.Lock()
+		defer redisTestMtx.Unlock()
+		if redisTestResource == nil {
+			return nil
+		}
+		err := redisTestPool.Purge(redisTestResource)
+		redisTestResource = nil
+		return err
+	})
+}
+
+// redisTestServer returns the address of a Redis server shared by the tests, starting it in a
+// container on first use.
+func redisTestServer(t *testing.T) string {
+	redisTestMtx.Lock()
+	defer redisTestMtx.Unlock()
+	if redisTestResource != nil {
+		return redisTestAddress
+	}
+
+	pool, err := dockertest.NewPool("")
+	require.NoError(t, err)
+	require.NoError(t, pool.Client.Ping(), "connecting to Docker")
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{Repository: "redis", Tag: "7"},
+		func(config *docker.HostConfig) {
+			config.AutoRemove = true
+			config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+		})
+	require.NoError(t, err)
+	if err := resource.Expire(redisTestExpiry); err != nil {
+		_ = pool.Purge(resource)
+		require.NoError(t, err)
+	}
+	address := "localhost:" + resource.GetPort("6379/tcp")
+	client := redis.NewClient(&redis.Options{Addr: address})
+	defer client.Close()
+	if err := pool.Retry(func() error { return client.Ping(context.Background()).Err() }); err != nil {
+		_ = pool.Purge(resource)
+		require.NoError(t, err, "waiting for Redis")
+	}
+
+	redisTestPool, redisTestResource, redisTestAddress = pool, resource, address
+	return address
+}
+
+// newTestRedisDB returns an empty database with a key prefix of its own, whose iterators read
+// pages of pageSize keys.
+func newTestRedisDB(t *testing.T, pageSize int) *RedisDB {
+	client := redis.NewClient(&redis.Options{Addr: redisTestServer(t)})
+	db := NewRedisDB(client, fmt.Sprintf("%s:", t.Name()))
+	db.pageSize = pageSize
+	ctx := context.Background()
+	require.NoError(t, client.Del(ctx, db.values, db.index).Err())
+	t.Cleanup(func() {
+		_ = client.Del(ctx, db.values, db.index).Err()
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestRedisDBOptions(t *testing.T) {
+	_, err := NewDB(RedisDBBackend, Options{})
+	require.ErrorIs(t, err, errMissingOption)
+
+	for _, number := range []string{"x", "-1"} {
+		_, err := NewDB(RedisDBBackend, Options{redisOptionAddress: "localhost:1", redisOptionDB: number})
+		require.ErrorContains(t, err, "invalid db")
+	}
+}
+
+func TestRedisDBIterationOrder(t *testing.T) {
+	// Keys sharing a long prefix, which differ only by their last bytes, including bytes which are
+	// not valid UTF-8 and the bytes the lexicographical bounds of Redis start with.
+	prefix := bytes.Repeat([]byte("long/common/prefix/"), 64)
+	var keys [][]byte
+	for _, suffix := range [][]byte{
+		nil, {0x00}, {0x00, 0x00}, {0x01}, []byte("("), []byte("["), []byte("+"), []byte("-"),
+		[]byte("a"), []byte("a\x00"), []byte("ab"), []byte("b"), {0x7f}, {0x80}, {0xfe}, {0xff}, {0xff, 0xff},
+	} {
+		keys = append(keys, append(cp(prefix), suffix...))
+	}
+	keys = append(keys, []byte{0x00}, []byte{0xff}, prefix[:len(prefix)-1])
+
+	// Small pages exercise the continuation of the iteration between pages.
+	for _, pageSize := range []int{1, 2, 3, redisIteratorPageSize} {
+		t.Run(fmt.Sprintf("page%d", pageSize), func(t *testing.T) {
+			db := newTestRedisDB(t, pageSize)
+			want := NewMemDB()
+			for i, key := range keys {
+				value := []byte(fmt.Sprintf("value%d", i))
+				require.NoError(t, db.Set(key, value))
+				require.NoError(t, want.Set(key, value))
+			}
+
+			checkSameIteration(t, want, db, nil, nil)
+			for _, start := range keys {
+				checkSameIteration(t, want, db, start, nil)
+				checkSameIteration(t, want, db, nil, start)
+				for _, end := range keys {
+					if bytes.Compare(start, end) <= 0 {
+						checkSameIteration(t, want, db, start, end)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRedisDBBatch(t *testing.T) {
+	db := newTestRedisDB(t, 2)
+	want := NewMemDB()
+	require.NoError(t, db.Set(bz("deleted"), bz("x")))
+
+	for _, d := range []DB{db, want} {
+		batch := d.NewBatch()
+		require.NoError(t, batch.Set(bz("a"), bz("1")))
+		require.NoError(t, batch.Set(bz("b"), []byte{}))
+		require.NoError(t, batch.Set(bz("a"), bz("2")))
+		require.NoError(t, batch.Delete(bz("deleted")))
+		require.NoError(t, batch.Write())
+		require.ErrorIs(t, batch.Set(bz("c"), bz("3")), errBatchClosed)
+		require.NoError(t, batch.Close())
+	}
+	checkSameIteration(t, want, db, nil, nil)
+	checkValue(t, db, bz("a"), bz("2"))
+	checkValue(t, db, bz("b"), []byte{})
+	checkValue(t, db, bz("deleted"), nil)
+
+	stats, err := db.TypedStats()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, stats.KeyCount)
+	require.Positive(t, stats.MemSizeBytes)
+	require.EqualValues(t, -1, stats.DiskSizeBytes)
+}
+
+func TestRedisDBIteratorDeletes(t *testing.T) {
+	db := newTestRedisDB(t, 2)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, db.Set(bz(key), bz(key)))
+	}
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+
+	// Keys deleted after the first page was read are skipped.
+	require.NoError(t, db.Delete(bz("c")))
+	require.NoError(t, db.Delete(bz("d")))
+	var keys []string
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.NoError(t, itr.Error())
+	require.Equal(t, []string{"a", "b", "e"}, keys)
+}
@@ -1,10 +1,15 @@
 package remotedb
 
 import (
+	"errors"
+
 	db "github.com/cometbft/cometbft-db"
 	protodb "github.com/cometbft/cometbft-db/remotedb/proto"
 )
 
+// errIteratorInvalid is recorded when an invalid iterator is used under db.ErrorPolicy.
+var errIteratorInvalid = errors.New("iterator is invalid")
+
 func makeIterator(dic protodb.DB_IteratorClient) db.Iterator {
 	itr := &iterator{dic: dic}
 	itr.Next() // We need to call Next to prime the iterator
@@ -49,13 +54,17 @@ func (rItr *reverseIterator) Next() {
 
 // Key implements Iterator.
 func (rItr *reverseIterator) Key() []byte {
-	rItr.assertIsValid()
+	if !rItr.assertIsValid() {
+		return nil
+	}
 	return rItr.cur.Key
 }
 
 // Value implements Iterator.
 func (rItr *reverseIterator) Value() []byte {
-	rItr.assertIsValid()
+	if !rItr.assertIsValid() {
+		return nil
+	}
 	return rItr.cur.Value
 }
 
@@ -69,10 +78,8 @@ func (rItr *reverseIterator) Close() error {
 	return nil
 }
 
-func (rItr *reverseIterator) assertIsValid() {
-	if !rItr.Valid() {
-		panic("iterator is invalid")
-	}
+func (rItr *reverseIterator) assertIsValid() bool {
+	return assertIsValid(rItr.Valid(), &rItr.err)
 }
 
 // iterator implements the db.Iterator by retrieving
@@ -111,13 +118,17 @@ func (itr *iterator) Next() {
 
 // Key implements Iterator.
 func (itr *iterator) Key() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return itr.cur.Key
 }
 
 // Value implements Iterator.
 func (itr *iterator) Value() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return itr.cur.Value
 }
 
@@ -131,8 +142,21 @@ func (itr *iterator) Close() error {
 	return itr.dic.CloseSend()
 }
 
-func (itr *iterator) assertIsValid() {
-	if !itr.Valid() {
+func (itr *iterator) assertIsValid() bool {
+	return assertIsValid(itr.Valid(), &itr.err)
+}
+
+// assertIsValid returns valid. If the iterator is not valid, it either panics or records an error
+// in err, depending on the iterator misuse policy.
+func assertIsValid(valid bool, err *error) bool {
+	if valid {
+		return true
+	}
+	if db.GetIteratorMisusePolicy() != db.ErrorPolicy {
 		panic("iterator is invalid")
 	}
+	if *err == nil {
+		*err = errIteratorInvalid
+	}
+	return false
 }
@@ -10,6 +10,9 @@ import (
 	protodb "github.com/cometbft/cometbft-db/remotedb/proto"
 )
 
+// remoteDBBackend names the remote database in errors. It is not a registered backend.
+const remoteDBBackend db.BackendType = "remotedb"
+
 type RemoteDB struct {
 	ctx context.Context
 	dc  protodb.DBClient
@@ -105,6 +108,12 @@ func (rd *RemoteDB) Print() error {
 	return errors.New("remoteDB.Print: unimplemented")
 }
 
+// Compact fails with an *db.ErrCompactUnsupported, since the gRPC service has no method to
+// compact the remote database.
+func (rd *RemoteDB) Compact(_, _ []byte) error {
+	return &db.ErrCompactUnsupported{Backend: remoteDBBackend}
+}
+
 func (rd *RemoteDB) Stats() map[string]string {
 	stats, err := rd.dc.Stats(rd.ctx, &protodb.Nothing{})
 	if err != nil || stats == nil {
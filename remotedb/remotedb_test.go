@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	db "github.com/cometbft/cometbft-db"
 	"github.com/cometbft/cometbft-db/remotedb"
 	"github.com/cometbft/cometbft-db/remotedb/grpcdb"
 )
@@ -165,3 +166,9 @@ func TestRemoteDB(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, rv5, v5, "expecting k5 to have been stored")
 }
+
+func TestRemoteDBCompactUnsupported(t *testing.T) {
+	var unsupported *db.ErrCompactUnsupported
+	require.ErrorAs(t, new(remotedb.RemoteDB).Compact(nil, nil), &unsupported)
+	assert.Equal(t, db.BackendType("remotedb"), unsupported.Backend)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+)
+
+// reservedKeyPrefix is the prefix of keys reserved for internal bookkeeping by wrappers and
+// backends, e.g. accounting totals. Reserved keys are hidden from iterators of the wrappers that
+// use them, and cannot be written by callers.
+var reservedKeyPrefix = []byte("\xff\xfe__cometbft_db__/")
+
+// errKeyReserved is returned when attempting to write a reserved key.
+var errKeyReserved = errors.New("key is reserved for internal use")
+
+// isReservedKey returns whether the key is in the reserved key space.
+func isReservedKey(key []byte) bool {
+	return bytes.HasPrefix(key, reservedKeyPrefix)
+}
+
+// reservedKey returns the reserved key for the given namespace and name.
+func reservedKey(namespace string, name []byte) []byte {
+	key := make([]byte, 0, len(reservedKeyPrefix)+len(namespace)+1+len(name))
+	key = append(key, reservedKeyPrefix...)
+	key = append(key, namespace...)
+	key = append(key, '/')
+	return append(key, name...)
+}
+
+// reservedNamespace returns the prefix of all reserved keys in the given namespace.
+func reservedNamespace(namespace string) []byte {
+	return reservedKey(namespace, nil)
+}
+
+// reservedKeyFilterIterator wraps an iterator and skips all reserved keys.
+type reservedKeyFilterIterator struct {
+	iteratorGuard
+
+	source Iterator
+}
+
+var _ Iterator = (*reservedKeyFilterIterator)(nil)
+
+func newReservedKeyFilterIterator(source Iterator) *reservedKeyFilterIterator {
+	itr := &reservedKeyFilterIterator{source: source}
+	itr.skipReserved()
+	return itr
+}
+
+// skipReserved advances the source past any reserved keys.
+func (itr *reservedKeyFilterIterator) skipReserved() {
+	for itr.source.Valid() && isReservedKey(itr.source.Key()) {
+		itr.source.Next()
+	}
+}
+
+// Domain implements Iterator.
+func (itr *reservedKeyFilterIterator) Domain() (start []byte, end []byte) {
+	return itr.source.Domain()
+}
+
+// Valid implements Iterator.
+func (itr *reservedKeyFilterIterator) Valid() bool {
+	return itr.source.Valid()
+}
+
+// Next implements Iterator.
+func (itr *reservedKeyFilterIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	itr.source.Next()
+	itr.skipReserved()
+}
+
+// Key implements Iterator.
+func (itr *reservedKeyFilterIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Key()
+}
+
+// Value implements Iterator.
+func (itr *reservedKeyFilterIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Value()
+}
+
+// Error implements Iterator.
+func (itr *reservedKeyFilterIterator) Error() error {
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *reservedKeyFilterIterator) Close() error {
+	return itr.source.Close()
+}
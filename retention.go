@@ -0,0 +1,364 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retentionNamespace is the reserved key namespace of the write times and index of RetentionDB.
+const retentionNamespace = "retention"
+
+// RetentionRule binds the keys under a prefix to a maximum age.
+type RetentionRule struct {
+	Prefix []byte
+	// MaxAge is the time after its last write at which a key expires. Zero or less retains the
+	// keys forever, which can exempt a longer prefix from the rule of a shorter one.
+	MaxAge time.Duration
+}
+
+// RetentionReport is the result of RetentionDB.RunRetention.
+type RetentionReport struct {
+	// Deleted is the number of expired keys deleted, by rule prefix.
+	Deleted map[string]int64
+	// Total is the number of expired keys deleted.
+	Total int64
+	// Stale is the number of outdated index entries removed, of keys written again or deleted
+	// after the indexed write.
+	Stale int64
+}
+
+// RetentionDB wraps a database, and deletes keys once they are older than the maximum age of the
+// rule of their prefix. Each rule applies to the keys under its prefix, and the rule with the
+// longest matching prefix is used. Keys without a rule are retained forever, and are written
+// without overhead.
+//
+// The time of the last write of each key with a rule is recorded under a reserved key, together
+// with an entry in a time-ordered index, in the same batch as the write. RunRetention uses the
+// index to delete the expired keys without scanning the others. Expired keys remain visible until
+// they are deleted. Keys written directly to the wrapped database, or before the RetentionDB was
+// used, are not tracked and thus never expire.
+type RetentionDB struct {
+	DB
+
+	clock Clock
+	// rules are sorted by decreasing prefix length, so that the first match is the longest.
+	rules     []RetentionRule
+	batchSize int
+
+	// mtx serializes writes with the checks of RunRetention, so that a key written while it is
+	// being pruned is not deleted.
+	mtx sync.Mutex
+}
+
+var _ DB = (*RetentionDB)(nil)
+
+// NewRetentionDB wraps db with the given retention rules, using clock for the write times. A nil
+// clock uses the system clock.
+func NewRetentionDB(db DB, rules []RetentionRule, clock Clock) *RetentionDB {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	sorted := make([]RetentionRule, len(rules))
+	for i, rule := range rules {
+		sorted[i] = RetentionRule{Prefix: cp(rule.Prefix), MaxAge: rule.MaxAge}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	return &RetentionDB{DB: db, clock: clock, rules: sorted, batchSize: defaultPruneBatchSize}
+}
+
+// Unwrap implements Unwrapper.
+func (rdb *RetentionDB) Unwrap() DB {
+	return rdb.DB
+}
+
+// rule returns the rule of key, or nil if its keys are retained forever.
+func (rdb *RetentionDB) rule(key []byte) *RetentionRule {
+	for i, rule := range rdb.rules {
+		if bytes.HasPrefix(key, rule.Prefix) {
+			if rule.MaxAge <= 0 {
+				return nil
+			}
+			return &rdb.rules[i]
+		}
+	}
+	return nil
+}
+
+// retentionTimeKey returns the reserved key holding the last write time of key.
+func retentionTimeKey(key []byte) []byte {
+	return reservedKey(retentionNamespace, append([]byte("k/"), key...))
+}
+
+// retentionIndexPrefix returns the prefix of the index entries of a rule. The length of the rule
+// prefix is included, so that the entries of a rule are not mixed with those of longer prefixes.
+func retentionIndexPrefix(rule *RetentionRule) []byte {
+	name := binary.AppendUvarint([]byte("t/"), uint64(len(rule.Prefix)))
+	return reservedKey(retentionNamespace, append(name, rule.Prefix...))
+}
+
+// retentionIndexKey returns the index entry of a write of key at t, in nanoseconds.
+func retentionIndexKey(rule *RetentionRule, t uint64, key []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(retentionIndexPrefix(rule), t), key...)
+}
+
+// Set implements DB.
+func (rdb *RetentionDB) Set(key, value []byte) error {
+	return rdb.write([]operation{{opTypeSet, key, value}}, false)
+}
+
+// SetSync implements DB.
+func (rdb *RetentionDB) SetSync(key, value []byte) error {
+	return rdb.write([]operation{{opTypeSet, key, value}}, true)
+}
+
+// Delete implements DB.
+func (rdb *RetentionDB) Delete(key []byte) error {
+	return rdb.write([]operation{{opTypeDelete, key, nil}}, false)
+}
+
+// DeleteSync implements DB.
+func (rdb *RetentionDB) DeleteSync(key []byte) error {
+	return rdb.write([]operation{{opTypeDelete, key, nil}}, true)
+}
+
+// write applies the operations together with the write times of their keys in a single batch.
+// The index entries of earlier writes are left in place, and removed by RunRetention.
+func (rdb *RetentionDB) write(ops []operation, sync bool) error {
+	for _, op := range ops {
+		if len(op.key) == 0 {
+			return errKeyEmpty
+		}
+		if op.opType == opTypeSet && op.value == nil {
+			return errValueNil
+		}
+		if isReservedKey(op.key) {
+			return errKeyReserved
+		}
+	}
+
+	rdb.mtx.Lock()
+	defer rdb.mtx.Unlock()
+
+	now := uint64(rdb.clock.Now().UnixNano())
+	batch := rdb.DB.NewBatch()
+	defer batch.Close()
+	for _, op := range ops {
+		rule := rdb.rule(op.key)
+		var err error
+		switch {
+		case op.opType == opTypeDelete:
+			err = batch.Delete(op.key)
+			if err == nil && rule != nil {
+				err = batch.Delete(retentionTimeKey(op.key))
+			}
+		case rule == nil:
+			err = batch.Set(op.key, op.value)
+		default:
+			err = batch.Set(op.key, op.value)
+			if err == nil {
+				err = batch.Set(retentionTimeKey(op.key), binary.BigEndian.AppendUint64(nil, now))
+			}
+			if err == nil {
+				err = batch.Set(retentionIndexKey(rule, now, op.key), []byte{})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if sync {
+		return batch.WriteSync()
+	}
+	return batch.Write()
+}
+
+// RunRetention deletes the keys which are older than the maximum age of their rule, in batches of
+// bounded size. It stops between batches when ctx is canceled, returning the report so far and
+// ctx.Err(). Since each batch removes the index entries it covers, a later run resumes where a
+// canceled one stopped.
+func (rdb *RetentionDB) RunRetention(ctx context.Context) (RetentionReport, error) {
+	report := RetentionReport{Deleted: make(map[string]int64)}
+	now := rdb.clock.Now()
+	for i := range rdb.rules {
+		rule := &rdb.rules[i]
+		if rule.MaxAge <= 0 {
+			continue
+		}
+		cutoff := uint64(now.Add(-rule.MaxAge).UnixNano())
+		for {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			more, err := rdb.pruneBatch(rule, cutoff, &report)
+			if err != nil {
+				return report, err
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// pruneBatch deletes the keys of up to batchSize index entries of rule written before cutoff, and
+// returns whether there may be more.
+func (rdb *RetentionDB) pruneBatch(rule *RetentionRule, cutoff uint64, report *RetentionReport) (bool, error) {
+	prefix := retentionIndexPrefix(rule)
+	entries, err := rdb.scanIndex(prefix, binary.BigEndian.AppendUint64(cp(prefix), cutoff))
+	if err != nil || len(entries) == 0 {
+		return false, err
+	}
+
+	rdb.mtx.Lock()
+	defer rdb.mtx.Unlock()
+
+	batch := rdb.DB.NewBatch()
+	defer batch.Close()
+	var deleted, stale int64
+	for _, entry := range entries {
+		t := entry[len(prefix) : len(prefix)+8]
+		key := entry[len(prefix)+8:]
+		// The entry is outdated if the key was written again or deleted since.
+		written, err := rdb.DB.Get(retentionTimeKey(key))
+		if err != nil {
+			return false, err
+		}
+		if err := batch.Delete(entry); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(written, t) {
+			stale++
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return false, err
+		}
+		if err := batch.Delete(retentionTimeKey(key)); err != nil {
+			return false, err
+		}
+		deleted++
+	}
+	if err := batch.Write(); err != nil {
+		return false, err
+	}
+	report.Deleted[string(rule.Prefix)] += deleted
+	report.Total += deleted
+	report.Stale += stale
+	return len(entries) == rdb.batchSize, nil
+}
+
+// scanIndex returns up to batchSize index entries in [start, end). The iterator is closed before
+// returning, as writes are not allowed during iteration.
+func (rdb *RetentionDB) scanIndex(start, end []byte) (entries [][]byte, err error) {
+	itr, err := rdb.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := itr.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for ; itr.Valid() && len(entries) < rdb.batchSize; itr.Next() {
+		entries = append(entries, cp(itr.Key()))
+	}
+	return entries, itr.Error()
+}
+
+// Iterator implements DB. Reserved keys are skipped.
+func (rdb *RetentionDB) Iterator(start, end []byte) (Iterator, error) {
+	itr, err := rdb.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newReservedKeyFilterIterator(itr), nil
+}
+
+// ReverseIterator implements DB. Reserved keys are skipped.
+func (rdb *RetentionDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	itr, err := rdb.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newReservedKeyFilterIterator(itr), nil
+}
+
+// NewBatch implements DB.
+func (rdb *RetentionDB) NewBatch() Batch {
+	return &retentionBatch{db: rdb, ops: []operation{}}
+}
+
+// retentionBatch buffers operations, and applies them with the write times on Write.
+type retentionBatch struct {
+	db  *RetentionDB
+	ops []operation
+}
+
+var (
+	_ Batch           = (*retentionBatch)(nil)
+	_ ResettableBatch = (*retentionBatch)(nil)
+)
+
+// Set implements Batch.
+func (b *retentionBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeSet, cp(key), cp(value)})
+	return nil
+}
+
+// Delete implements Batch.
+func (b *retentionBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeDelete, cp(key), nil})
+	return nil
+}
+
+// Write implements Batch.
+func (b *retentionBatch) Write() error {
+	return b.write(false)
+}
+
+// WriteSync implements Batch.
+func (b *retentionBatch) WriteSync() error {
+	return b.write(true)
+}
+
+func (b *retentionBatch) write(sync bool) error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	if err := b.db.write(b.ops, sync); err != nil {
+		return err
+	}
+	// Make sure batch cannot be used afterwards. Callers should still call Close(), for errors.
+	return b.Close()
+}
+
+// Close implements Batch.
+func (b *retentionBatch) Close() error {
+	b.ops = nil
+	return nil
+}
+
+// Reset implements ResettableBatch.
+func (b *retentionBatch) Reset() {
+	b.ops = []operation{}
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionDB(t *testing.T) {
+	const day = 24 * time.Hour
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	db := NewRetentionDB(NewMemDB(), []RetentionRule{
+		{Prefix: bz("index/"), MaxAge: 90 * day},
+		{Prefix: bz("index/keep/"), MaxAge: 0},
+		{Prefix: bz("light/"), MaxAge: 7 * day},
+	}, clock)
+	db.batchSize = 3
+
+	batch := db.NewBatch()
+	for i := 0; i < 5; i++ {
+		for _, prefix := range []string{"index/", "index/keep/", "light/", "block/"} {
+			require.NoError(t, batch.Set(bz(fmt.Sprintf("%s%d", prefix, i)), bz("v")))
+		}
+	}
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	// Reserved keys are hidden and cannot be written.
+	require.Len(t, collectAll(t, db), 20)
+	require.ErrorIs(t, db.Set(retentionTimeKey(bz("light/0")), bz("x")), errKeyReserved)
+
+	// Nothing has expired yet.
+	report, err := db.RunRetention(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, report.Total)
+
+	// Light client data expires after a week, except for keys written since, and deleted keys
+	// leave stale index entries.
+	clock.advance(6 * day)
+	require.NoError(t, db.Set(bz("light/0"), bz("w")))
+	require.NoError(t, db.Delete(bz("light/1")))
+	clock.advance(2 * day)
+
+	// A canceled run deletes nothing, and a later run resumes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = db.RunRetention(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, report.Total)
+
+	report, err = db.RunRetention(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, RetentionReport{Deleted: map[string]int64{"light/": 3}, Total: 3, Stale: 2}, report)
+	checkValue(t, db, bz("light/0"), bz("w"))
+	for i := 1; i < 5; i++ {
+		checkValue(t, db, bz(fmt.Sprintf("light/%d", i)), nil)
+	}
+
+	// Indexer data expires after 90 days, except for the exempted prefix, and block data is kept.
+	clock.advance(90 * day)
+	report, err = db.RunRetention(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, RetentionReport{Deleted: map[string]int64{"index/": 5, "light/": 1}, Total: 6}, report)
+
+	require.Equal(t, map[string]string{
+		"block/0": "v", "block/1": "v", "block/2": "v", "block/3": "v", "block/4": "v",
+		"index/keep/0": "v", "index/keep/1": "v", "index/keep/2": "v", "index/keep/3": "v", "index/keep/4": "v",
+	}, collectAll(t, db))
+
+	// No bookkeeping is left behind for the deleted keys.
+	itr, err := IteratePrefix(db.Unwrap(), reservedNamespace(retentionNamespace))
+	require.NoError(t, err)
+	require.False(t, itr.Valid())
+	require.NoError(t, itr.Close())
+}
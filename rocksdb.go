@@ -4,6 +4,7 @@
 package db
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
 	"runtime"
@@ -25,7 +26,21 @@ func init() {
 			return nil, errors.Wrap(errMissingOption, optionDir)
 		}
 
-		return NewRocksDB(name, dir)
+		if err := checkDataFormat(RocksDBBackend, filepath.Join(dir, name+".db"), options); err != nil {
+			return nil, err
+		}
+
+		lenient, err := lenientRanges(options)
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := NewRocksDB(name, dir)
+		if err != nil {
+			return nil, err
+		}
+		db.lenientRanges = lenient
+		return db, nil
 	}
 	registerDBCreator(RocksDBBackend, dbCreator, false)
 }
@@ -36,9 +51,16 @@ type RocksDB struct {
 	ro     *grocksdb.ReadOptions
 	wo     *grocksdb.WriteOptions
 	woSync *grocksdb.WriteOptions
+
+	// lenientRanges makes iterators with a start after their end empty instead of an error.
+	lenientRanges bool
 }
 
-var _ DB = (*RocksDB)(nil)
+var (
+	_ DB                 = (*RocksDB)(nil)
+	_ CapabilityReporter = (*RocksDB)(nil)
+	_ RangeDeleter       = (*RocksDB)(nil)
+)
 
 func NewRocksDB(name string, dir string) (*RocksDB, error) {
 	// default rocksdb option, good enough for most cases, including heavy workloads.
@@ -154,12 +176,69 @@ func (db *RocksDB) DeleteSync(key []byte) error {
 	return nil
 }
 
+// DeleteRange implements RangeDeleter, deleting the keys of [start, end) in batches of
+// defaultPruneBatchSize keys, read with a single iterator over an implicit snapshot.
+func (db *RocksDB) DeleteRange(start, end []byte) (int64, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return 0, errKeyEmpty
+	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return 0, err
+	}
+	itr := db.db.NewIterator(db.ro)
+	defer itr.Close()
+	batch := grocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	var deleted int64
+	write := func() error {
+		if err := db.db.Write(db.wo, batch); err != nil {
+			return err
+		}
+		deleted += int64(batch.Count())
+		batch.Clear()
+		return nil
+	}
+	if start == nil {
+		itr.SeekToFirst()
+	} else {
+		itr.Seek(start)
+	}
+	for ; itr.Valid(); itr.Next() {
+		key := moveSliceToBytes(itr.Key())
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		batch.Delete(key)
+		if batch.Count() == defaultPruneBatchSize {
+			if err := write(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Err(); err != nil {
+		return deleted, err
+	}
+	if batch.Count() > 0 {
+		if err := write(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
 func (db *RocksDB) DB() *grocksdb.DB {
 	return db.db
 }
 
+// Capabilities implements CapabilityReporter. Keys are unlimited.
+func (db *RocksDB) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
 // Close implements DB.
 func (db *RocksDB) Close() error {
+	reportClosed(db)
 	db.ro.Destroy()
 	db.wo.Destroy()
 	db.woSync.Destroy()
@@ -192,6 +271,12 @@ func (db *RocksDB) Stats() map[string]string {
 	return stats
 }
 
+// Compact implements DB, compacting the range with CompactRange.
+func (db *RocksDB) Compact(start, end []byte) error {
+	db.db.CompactRange(grocksdb.Range{Start: start, Limit: end})
+	return nil
+}
+
 // NewBatch implements DB.
 func (db *RocksDB) NewBatch() Batch {
 	return newRocksDBBatch(db)
@@ -202,6 +287,9 @@ func (db *RocksDB) Iterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(db.ro)
 	return newRocksDBIterator(itr, start, end, false), nil
 }
@@ -211,6 +299,9 @@ func (db *RocksDB) ReverseIterator(start, end []byte) (Iterator, error) {
 	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
 		return nil, errKeyEmpty
 	}
+	if err := checkRange(start, end, db.lenientRanges); err != nil {
+		return nil, err
+	}
 	itr := db.db.NewIterator(db.ro)
 	return newRocksDBIterator(itr, start, end, true), nil
 }
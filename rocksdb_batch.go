@@ -10,7 +10,10 @@ type rocksDBBatch struct {
 	batch *grocksdb.WriteBatch
 }
 
-var _ Batch = (*rocksDBBatch)(nil)
+var (
+	_ Batch           = (*rocksDBBatch)(nil)
+	_ ResettableBatch = (*rocksDBBatch)(nil)
+)
 
 func newRocksDBBatch(db *RocksDB) *rocksDBBatch {
 	return &rocksDBBatch{
@@ -81,3 +84,12 @@ func (b *rocksDBBatch) Close() error {
 	}
 	return nil
 }
+
+// Reset implements ResettableBatch.
+func (b *rocksDBBatch) Reset() {
+	if b.batch == nil {
+		b.batch = grocksdb.NewWriteBatch()
+	} else {
+		b.batch.Clear()
+	}
+}
@@ -10,6 +10,8 @@ import (
 )
 
 type rocksDBIterator struct {
+	iteratorGuard
+
 	source     *grocksdb.Iterator
 	start, end []byte
 	isReverse  bool
@@ -95,19 +97,25 @@ func (itr *rocksDBIterator) Valid() bool {
 
 // Key implements Iterator.
 func (itr *rocksDBIterator) Key() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return moveSliceToBytes(itr.source.Key())
 }
 
 // Value implements Iterator.
 func (itr *rocksDBIterator) Value() []byte {
-	itr.assertIsValid()
+	if !itr.assertIsValid() {
+		return nil
+	}
 	return moveSliceToBytes(itr.source.Value())
 }
 
 // Next implements Iterator.
-func (itr rocksDBIterator) Next() {
-	itr.assertIsValid()
+func (itr *rocksDBIterator) Next() {
+	if !itr.assertIsValid() {
+		return
+	}
 	if itr.isReverse {
 		itr.source.Prev()
 	} else {
@@ -117,7 +125,10 @@ func (itr rocksDBIterator) Next() {
 
 // Error implements Iterator.
 func (itr *rocksDBIterator) Error() error {
-	return itr.source.Err()
+	if err := itr.source.Err(); err != nil {
+		return err
+	}
+	return itr.misuseError()
 }
 
 // Close implements Iterator.
@@ -126,10 +137,8 @@ func (itr *rocksDBIterator) Close() error {
 	return nil
 }
 
-func (itr *rocksDBIterator) assertIsValid() {
-	if !itr.Valid() {
-		panic("iterator is invalid")
-	}
+func (itr *rocksDBIterator) assertIsValid() bool {
+	return itr.guard(itr.Valid())
 }
 
 // moveSliceToBytes will free the slice and copy out a go []byte
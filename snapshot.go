@@ -0,0 +1,135 @@
+package db
+
+import "errors"
+
+// ErrSnapshotReadOnly is returned by the writes of a snapshot, see Snapshotter.
+var ErrSnapshotReadOnly = errors.New("snapshot is read-only")
+
+// snapshotDB is a snapshot, i.e. a read-only view of a MemDB which nothing else references.
+type snapshotDB struct {
+	db *MemDB
+}
+
+var (
+	_ DB          = (*snapshotDB)(nil)
+	_ Snapshotter = (*snapshotDB)(nil)
+)
+
+// Get implements DB.
+func (s *snapshotDB) Get(key []byte) ([]byte, error) {
+	return s.db.Get(key)
+}
+
+// Has implements DB.
+func (s *snapshotDB) Has(key []byte) (bool, error) {
+	return s.db.Has(key)
+}
+
+// Set implements DB. It always fails with ErrSnapshotReadOnly.
+func (s *snapshotDB) Set(_, _ []byte) error {
+	return ErrSnapshotReadOnly
+}
+
+// SetSync implements DB. It always fails with ErrSnapshotReadOnly.
+func (s *snapshotDB) SetSync(_, _ []byte) error {
+	return ErrSnapshotReadOnly
+}
+
+// Delete implements DB. It always fails with ErrSnapshotReadOnly.
+func (s *snapshotDB) Delete(_ []byte) error {
+	return ErrSnapshotReadOnly
+}
+
+// DeleteSync implements DB. It always fails with ErrSnapshotReadOnly.
+func (s *snapshotDB) DeleteSync(_ []byte) error {
+	return ErrSnapshotReadOnly
+}
+
+// Iterator implements DB.
+func (s *snapshotDB) Iterator(start, end []byte) (Iterator, error) {
+	return s.db.Iterator(start, end)
+}
+
+// ReverseIterator implements DB.
+func (s *snapshotDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	return s.db.ReverseIterator(start, end)
+}
+
+// Close implements DB.
+func (s *snapshotDB) Close() error {
+	return s.db.Close()
+}
+
+// NewBatch implements DB. The writes of the batch fail with ErrSnapshotReadOnly.
+func (s *snapshotDB) NewBatch() Batch {
+	return &snapshotBatch{}
+}
+
+// Print implements DB.
+func (s *snapshotDB) Print() error {
+	return s.db.Print()
+}
+
+// Stats implements DB.
+func (s *snapshotDB) Stats() map[string]string {
+	return s.db.Stats()
+}
+
+// Compact implements DB.
+func (s *snapshotDB) Compact(start, end []byte) error {
+	return s.db.Compact(start, end)
+}
+
+// Snapshot implements Snapshotter. Since the snapshot never changes, it is its own snapshot.
+func (s *snapshotDB) Snapshot() (DB, error) {
+	return s, nil
+}
+
+// snapshotBatch is the batch of a snapshot, which rejects all operations.
+type snapshotBatch struct {
+	closed bool
+}
+
+var (
+	_ Batch           = (*snapshotBatch)(nil)
+	_ ResettableBatch = (*snapshotBatch)(nil)
+)
+
+// Set implements Batch. It always fails, with ErrSnapshotReadOnly unless the batch is closed.
+func (b *snapshotBatch) Set(_, _ []byte) error {
+	return b.err()
+}
+
+// Delete implements Batch. It always fails, with ErrSnapshotReadOnly unless the batch is closed.
+func (b *snapshotBatch) Delete(_ []byte) error {
+	return b.err()
+}
+
+// Write implements Batch. It always fails, with ErrSnapshotReadOnly unless the batch is closed.
+func (b *snapshotBatch) Write() error {
+	return b.err()
+}
+
+// WriteSync implements Batch. It always fails, with ErrSnapshotReadOnly unless the batch is
+// closed.
+func (b *snapshotBatch) WriteSync() error {
+	return b.err()
+}
+
+// Close implements Batch.
+func (b *snapshotBatch) Close() error {
+	b.closed = true
+	return nil
+}
+
+// Reset implements ResettableBatch.
+func (b *snapshotBatch) Reset() {
+	b.closed = false
+}
+
+func (b *snapshotBatch) err() error {
+	if b.closed {
+		return errBatchClosed
+	}
+	return ErrSnapshotReadOnly
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	goleveldb, err := NewGoLevelDB("snapshot", t.TempDir())
+	require.NoError(t, err)
+	defer goleveldb.Close()
+
+	for name, db := range map[string]DB{"memdb": NewMemDB(), "goleveldb": goleveldb} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, db.Set(bz("a"), bz("1")))
+			require.NoError(t, db.Set(bz("b"), []byte{}))
+			want := NewMemDB()
+			require.NoError(t, want.Set(bz("a"), bz("1")))
+			require.NoError(t, want.Set(bz("b"), []byte{}))
+
+			snapshot, err := Snapshot(db)
+			require.NoError(t, err)
+			clone, err := Clone(db)
+			require.NoError(t, err)
+
+			// Writes to the database affect neither the snapshot nor the clone.
+			require.NoError(t, db.Set(bz("a"), bz("2")))
+			require.NoError(t, db.Delete(bz("b")))
+			require.NoError(t, db.Set(bz("c"), bz("3")))
+			checkSameIteration(t, want, snapshot, nil, nil)
+			checkSameIteration(t, want, clone, nil, nil)
+
+			// The snapshot is read-only.
+			require.ErrorIs(t, snapshot.Set(bz("a"), bz("x")), ErrSnapshotReadOnly)
+			require.ErrorIs(t, snapshot.SetSync(bz("a"), bz("x")), ErrSnapshotReadOnly)
+			require.ErrorIs(t, snapshot.Delete(bz("a")), ErrSnapshotReadOnly)
+			require.ErrorIs(t, snapshot.DeleteSync(bz("a")), ErrSnapshotReadOnly)
+			batch := snapshot.NewBatch()
+			require.ErrorIs(t, batch.Set(bz("a"), bz("x")), ErrSnapshotReadOnly)
+			require.ErrorIs(t, batch.Write(), ErrSnapshotReadOnly)
+			require.NoError(t, batch.Close())
+			require.ErrorIs(t, batch.Write(), errBatchClosed)
+			batch.(ResettableBatch).Reset()
+			require.ErrorIs(t, batch.Write(), ErrSnapshotReadOnly)
+			checkSameIteration(t, want, snapshot, nil, nil)
+
+			// The clone is writable, and writes to it do not affect the database.
+			require.NoError(t, clone.Set(bz("a"), bz("x")))
+			checkValue(t, clone, bz("a"), bz("x"))
+			checkValue(t, db, bz("a"), bz("2"))
+
+			// Snapshots of snapshots and their clones have the same contents.
+			again, err := Snapshot(snapshot)
+			require.NoError(t, err)
+			checkSameIteration(t, want, again, nil, nil)
+			clone, err = Clone(snapshot)
+			require.NoError(t, err)
+			checkSameIteration(t, want, clone, nil, nil)
+			require.NoError(t, clone.Delete(bz("a")))
+			checkValue(t, snapshot, bz("a"), bz("1"))
+		})
+	}
+}
+
+func TestMemDBSnapshotZeroCopy(t *testing.T) {
+	db, err := NewDB(MemDBBackend, Options{optionUnsafeZeroCopy: "true"})
+	require.NoError(t, err)
+	require.NoError(t, db.Set(bz("a"), bz("value")))
+	snapshot, err := Snapshot(db)
+	require.NoError(t, err)
+
+	// Modifying a value returned without copy does not affect the snapshot.
+	value, err := db.Get(bz("a"))
+	require.NoError(t, err)
+	value[0] = 'X'
+	checkValue(t, snapshot, bz("a"), bz("value"))
+}
+
+func TestCloneConcurrentWrites(t *testing.T) {
+	const n = 2000
+	goleveldb, err := NewGoLevelDB("clone", t.TempDir())
+	require.NoError(t, err)
+	defer goleveldb.Close()
+
+	for name, db := range map[string]DB{"memdb": NewMemDB(), "goleveldb": goleveldb} {
+		t.Run(name, func(t *testing.T) {
+			key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+			for i := 0; i < n; i++ {
+				require.NoError(t, db.Set(key(i), bz("old")))
+			}
+
+			// Each key is overwritten, and new keys are added, while the database is cloned.
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					_ = db.Set(key(i), bz("new"))
+					_ = db.Set(key(n+i), bz("new"))
+				}
+			}()
+			clones := make([]DB, 0, 5)
+			for i := 0; i < cap(clones); i++ {
+				clone, err := Clone(db)
+				require.NoError(t, err)
+				clones = append(clones, clone)
+			}
+			wg.Wait()
+
+			for _, clone := range clones {
+				entries := collectAll(t, clone)
+				for i := 0; i < n; i++ {
+					value, ok := entries[string(key(i))]
+					require.True(t, ok, "missing key %s", key(i))
+					require.Contains(t, []string{"old", "new"}, value)
+				}
+				for k, value := range entries {
+					require.Regexp(t, `^key\d{5}$`, k)
+					require.Contains(t, []string{"old", "new"}, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/btree"
+)
+
+// SortedIterator returns an ascending iterator over the entries yielded by source in any order, for
+// backends which cannot iterate in key order themselves. Up to memLimit bytes of keys and values
+// are kept in memory, and larger inputs are spilled to sorted temporary files in tmpDir (the
+// default temporary directory if empty), which are merged while iterating and removed on Close.
+// At most spillMergeFanIn runs are open at once: if more are spilled, they are first merged in
+// groups into fewer, larger runs.
+// Source may reuse the slices passed to yield once it returns. If a key is yielded several times,
+// the last value is used.
+func SortedIterator(source func(yield func(k, v []byte) error) error, tmpDir string, memLimit int) (Iterator, error) {
+	if memLimit <= 0 {
+		return nil, fmt.Errorf("invalid memory limit %d", memLimit)
+	}
+
+	sorter := &spillSorter{tmpDir: tmpDir, run: NewMemDB()}
+	err := source(func(k, v []byte) error {
+		if len(k) == 0 {
+			return errKeyEmpty
+		}
+		if v == nil {
+			return errValueNil
+		}
+		sorter.run.set(cp(k), cp(v))
+		sorter.size += len(k) + len(v)
+		if sorter.size > memLimit {
+			return sorter.spill()
+		}
+		return nil
+	})
+	if err == nil {
+		// The in-memory run is merged with the spilled ones.
+		err = sorter.mergeFiles(spillMergeFanIn - 1)
+	}
+	if err != nil {
+		return nil, errors.Join(err, sorter.removeFiles())
+	}
+
+	// Runs are merged newest first, so that the last value of a key wins.
+	its := make([]Iterator, 0, len(sorter.files)+1)
+	memItr, err := sorter.run.Iterator(nil, nil)
+	if err != nil {
+		return nil, errors.Join(err, sorter.removeFiles())
+	}
+	its = append(its, memItr)
+	for i := len(sorter.files) - 1; i >= 0; i-- {
+		runItr, err := newSpillRunIterator(sorter.files[i])
+		if err != nil {
+			return nil, errors.Join(err, closeIterators(its), sorter.removeFiles())
+		}
+		its = append(its, runItr)
+	}
+	if len(its) == 1 {
+		return memItr, nil
+	}
+	return &sortedIterator{Iterator: MergeIterators(true, its...), sorter: sorter}, nil
+}
+
+// spillMergeFanIn is the maximum number of runs merged at once by SortedIterator, which bounds its
+// open files and read buffers.
+const spillMergeFanIn = 64
+
+// spillSorter collects the entries of SortedIterator in a sorted in-memory run, and spills the
+// run to a temporary file once it exceeds the memory limit.
+type spillSorter struct {
+	tmpDir string
+	files  []string
+	run    *MemDB
+	size   int
+}
+
+// spill writes the in-memory run to a new temporary file and starts a new run.
+func (s *spillSorter) spill() error {
+	name, err := s.writeRun(func(w *bufio.Writer) error {
+		var buf []byte
+		var err error
+		s.run.btree.Ascend(func(i btree.Item) bool {
+			item := i.(*item)
+			buf = appendSpillEntry(buf[:0], item.key, item.value)
+			_, err = w.Write(buf)
+			return err == nil
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, name)
+
+	s.run = NewMemDB()
+	s.size = 0
+	return nil
+}
+
+// mergeFiles merges consecutive groups of up to spillMergeFanIn spilled runs into single runs,
+// until at most maxFiles remain. Merged runs are removed.
+func (s *spillSorter) mergeFiles(maxFiles int) error {
+	for len(s.files) > maxFiles {
+		merged := make([]string, 0, (len(s.files)+spillMergeFanIn-1)/spillMergeFanIn)
+		for i := 0; i < len(s.files); i += spillMergeFanIn {
+			group := s.files[i:min(i+spillMergeFanIn, len(s.files))]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+			name, err := s.mergeGroup(group)
+			if err == nil {
+				merged = append(merged, name)
+				for _, name := range group {
+					if err = os.Remove(name); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				// The runs not merged yet are kept, so that removeFiles removes them.
+				s.files = append(merged, s.files[i:]...)
+				return err
+			}
+		}
+		s.files = merged
+	}
+	return nil
+}
+
+// mergeGroup merges spilled runs, given oldest first, into a new run.
+func (s *spillSorter) mergeGroup(group []string) (string, error) {
+	// Runs are merged newest first, so that the last value of a key wins.
+	its := make([]Iterator, 0, len(group))
+	for i := len(group) - 1; i >= 0; i-- {
+		itr, err := newSpillRunIterator(group[i])
+		if err != nil {
+			return "", errors.Join(err, closeIterators(its))
+		}
+		its = append(its, itr)
+	}
+	itr := MergeIterators(true, its...)
+	name, err := s.writeRun(func(w *bufio.Writer) error {
+		var buf []byte
+		for ; itr.Valid(); itr.Next() {
+			buf = appendSpillEntry(buf[:0], itr.Key(), itr.Value())
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return itr.Error()
+	})
+	return name, errors.Join(err, itr.Close())
+}
+
+// writeRun writes a run to a new temporary file using write, and returns the name of the file. The
+// file is removed if writing fails.
+func (s *spillSorter) writeRun(write func(w *bufio.Writer) error) (string, error) {
+	f, err := os.CreateTemp(s.tmpDir, "cometbft-db-sort-*")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		return "", errors.Join(err, os.Remove(f.Name()))
+	}
+	return f.Name(), nil
+}
+
+// appendSpillEntry appends an entry of a spilled run, as a uvarint length prefixed key and value.
+func appendSpillEntry(buf, key, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// removeFiles removes all spilled runs.
+func (s *spillSorter) removeFiles() error {
+	var errs []error
+	for _, name := range s.files {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	s.files = nil
+	return errors.Join(errs...)
+}
+
+// sortedIterator is the iterator returned by SortedIterator for spilled inputs, which removes the
+// spilled runs on Close.
+type sortedIterator struct {
+	Iterator
+	sorter *spillSorter
+}
+
+// Close implements Iterator.
+func (itr *sortedIterator) Close() error {
+	return errors.Join(itr.Iterator.Close(), itr.sorter.removeFiles())
+}
+
+// spillRunIterator iterates over a run spilled by spillSorter.
+type spillRunIterator struct {
+	iteratorGuard
+
+	f          *os.File
+	r          *bufio.Reader
+	key, value []byte
+	err        error
+}
+
+var _ Iterator = (*spillRunIterator)(nil)
+
+func newSpillRunIterator(name string) (*spillRunIterator, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	itr := &spillRunIterator{f: f, r: bufio.NewReader(f)}
+	itr.read()
+	return itr, nil
+}
+
+// read reads the next entry, or invalidates the iterator at the end of the run.
+func (itr *spillRunIterator) read() {
+	itr.key, itr.value = nil, nil
+	key, err := itr.readBytes()
+	if err == io.EOF {
+		return
+	}
+	if err != nil {
+		itr.err = err
+		return
+	}
+	value, err := itr.readBytes()
+	if err != nil {
+		itr.err = fmt.Errorf("truncated spilled run: %w", err)
+		return
+	}
+	itr.key, itr.value = key, value
+}
+
+// readBytes reads a uvarint length prefixed byte slice.
+func (itr *spillRunIterator) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(itr.r)
+	if err != nil {
+		return nil, err
+	}
+	bz := make([]byte, n)
+	if _, err := io.ReadFull(itr.r, bz); err != nil {
+		return nil, err
+	}
+	return bz, nil
+}
+
+// Domain implements Iterator.
+func (itr *spillRunIterator) Domain() (start []byte, end []byte) {
+	return nil, nil
+}
+
+// Valid implements Iterator.
+func (itr *spillRunIterator) Valid() bool {
+	return itr.key != nil
+}
+
+// Next implements Iterator.
+func (itr *spillRunIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	itr.read()
+}
+
+// Key implements Iterator.
+func (itr *spillRunIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.key
+}
+
+// Value implements Iterator.
+func (itr *spillRunIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.value
+}
+
+// Error implements Iterator.
+func (itr *spillRunIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *spillRunIterator) Close() error {
+	return itr.f.Close()
+}
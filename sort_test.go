@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortedIterator(t *testing.T) {
+	const memLimit = 4096
+	r := rand.New(rand.NewSource(1)) //nolint:gosec
+	expected := make(map[string]string)
+	var entries [][2]string
+	for size := 0; size < 10*memLimit; {
+		key := fmt.Sprintf("key%05d", r.Intn(5000))
+		value := fmt.Sprintf("value%d", len(entries))
+		entries = append(entries, [2]string{key, value})
+		expected[key] = value
+		size += len(key) + len(value)
+	}
+	sorted := make([][2]string, 0, len(expected))
+	for key, value := range expected {
+		sorted = append(sorted, [2]string{key, value})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+
+	dir := t.TempDir()
+	itr, err := SortedIterator(func(yield func(k, v []byte) error) error {
+		// The slices are reused, so the sorter must copy them.
+		var k, v []byte
+		for _, entry := range entries {
+			k, v = append(k[:0], entry[0]...), append(v[:0], entry[1]...)
+			if err := yield(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, dir, memLimit)
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(files), 9)
+
+	require.Equal(t, sorted, collectIterator(t, itr))
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestSortedIteratorManyRuns(t *testing.T) {
+	// Thousands of runs are spilled, more than may be open at once.
+	const n = 5000
+	dir := t.TempDir()
+	itr, err := SortedIterator(func(yield func(k, v []byte) error) error {
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%05d", (i*7919)%n)
+			if err := yield([]byte(key), []byte("old")); err != nil {
+				return err
+			}
+		}
+		// Later values of a key win, even across merged runs.
+		for i := 0; i < n; i += 100 {
+			if err := yield([]byte(fmt.Sprintf("key%05d", i)), []byte("new")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, dir, 64)
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Less(t, len(files), spillMergeFanIn)
+
+	expected := make([][2]string, 0, n)
+	for i := 0; i < n; i++ {
+		value := "old"
+		if i%100 == 0 {
+			value = "new"
+		}
+		expected = append(expected, [2]string{fmt.Sprintf("key%05d", i), value})
+	}
+	require.Equal(t, expected, collectIterator(t, itr))
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestSortedIteratorInMemory(t *testing.T) {
+	dir := t.TempDir()
+	itr, err := SortedIterator(func(yield func(k, v []byte) error) error {
+		for _, key := range []string{"c", "a", "b", "a"} {
+			if err := yield([]byte(key), []byte("value "+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, dir, 1024)
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"a", "value a"}, {"b", "value b"}, {"c", "value c"}}, collectIterator(t, itr))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestSortedIteratorSourceError(t *testing.T) {
+	dir := t.TempDir()
+	failure := errors.New("scan failed")
+	_, err := SortedIterator(func(yield func(k, v []byte) error) error {
+		for i := 0; i < 100; i++ {
+			if err := yield(int642Bytes(int64(i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return failure
+	}, dir, 64)
+	require.ErrorIs(t, err, failure)
+
+	// Spilled runs are removed.
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	_, err = SortedIterator(func(yield func(k, v []byte) error) error { return nil }, dir, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,436 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// StagedDB wraps a database with a staging area, so that writes staged for a later commit are
+// visible to reads before they are written. Get, Has and iterators merge the staged writes with
+// the underlying database: staged sets override persisted values and staged deletes hide
+// persisted keys. Commit writes the staged writes atomically with a single batch.
+//
+// Writes made directly via Set, Delete or NewBatch go straight to the underlying database, and are
+// overridden by staged writes to the same keys until the staged writes are committed.
+type StagedDB struct {
+	mtx sync.RWMutex
+	db  DB
+	// staged contains the staged writes, where a nil value is a staged delete.
+	staged *btree.BTree
+	// batch is the current staging batch, if any.
+	batch *stagedBatch
+}
+
+var _ DB = (*StagedDB)(nil)
+
+// NewStagedDB wraps db with an empty staging area.
+func NewStagedDB(db DB) *StagedDB {
+	return &StagedDB{
+		db:     db,
+		staged: btree.New(bTreeDegree),
+	}
+}
+
+// StageBatch returns the staging batch. Writes to it are visible to reads from the StagedDB
+// immediately. Writing the batch commits the staged writes as Commit does, while closing it
+// without writing discards them. Until then, StageBatch returns the same batch.
+func (sdb *StagedDB) StageBatch() Batch {
+	sdb.mtx.Lock()
+	defer sdb.mtx.Unlock()
+
+	if sdb.batch == nil {
+		sdb.batch = &stagedBatch{db: sdb}
+	}
+	return sdb.batch
+}
+
+// Commit atomically writes the staged writes to the underlying database, and closes the staging
+// batch.
+func (sdb *StagedDB) Commit() error {
+	sdb.mtx.Lock()
+	defer sdb.mtx.Unlock()
+
+	return sdb.commit(false)
+}
+
+// commit writes the staged writes. The caller must hold the mutex.
+func (sdb *StagedDB) commit(sync bool) error {
+	batch := sdb.db.NewBatch()
+	defer batch.Close()
+
+	var err error
+	sdb.staged.Ascend(func(i btree.Item) bool {
+		item := i.(*item)
+		if item.value == nil {
+			err = batch.Delete(item.key)
+		} else {
+			err = batch.Set(item.key, item.value)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if sync {
+		err = batch.WriteSync()
+	} else {
+		err = batch.Write()
+	}
+	if err != nil {
+		return err
+	}
+
+	sdb.discard()
+	return nil
+}
+
+// discard clears the staged writes and closes the staging batch. The caller must hold the mutex.
+func (sdb *StagedDB) discard() {
+	sdb.staged.Clear(false)
+	if sdb.batch != nil {
+		sdb.batch.closed = true
+		sdb.batch = nil
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (sdb *StagedDB) Unwrap() DB {
+	return sdb.db
+}
+
+// Get implements DB.
+func (sdb *StagedDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	sdb.mtx.RLock()
+	defer sdb.mtx.RUnlock()
+
+	if i := sdb.staged.Get(newKey(key)); i != nil {
+		return i.(*item).value, nil
+	}
+	return sdb.db.Get(key)
+}
+
+// Has implements DB.
+func (sdb *StagedDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	sdb.mtx.RLock()
+	defer sdb.mtx.RUnlock()
+
+	if i := sdb.staged.Get(newKey(key)); i != nil {
+		return i.(*item).value != nil, nil
+	}
+	return sdb.db.Has(key)
+}
+
+// Set implements DB.
+func (sdb *StagedDB) Set(key []byte, value []byte) error {
+	return sdb.db.Set(key, value)
+}
+
+// SetSync implements DB.
+func (sdb *StagedDB) SetSync(key []byte, value []byte) error {
+	return sdb.db.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (sdb *StagedDB) Delete(key []byte) error {
+	return sdb.db.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (sdb *StagedDB) DeleteSync(key []byte) error {
+	return sdb.db.DeleteSync(key)
+}
+
+// Iterator implements DB. The staged writes in the domain are captured when the iterator is
+// created.
+func (sdb *StagedDB) Iterator(start, end []byte) (Iterator, error) {
+	return sdb.iterator(start, end, false)
+}
+
+// ReverseIterator implements DB. The staged writes in the domain are captured when the iterator
+// is created.
+func (sdb *StagedDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	return sdb.iterator(start, end, true)
+}
+
+func (sdb *StagedDB) iterator(start, end []byte, reverse bool) (Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	sdb.mtx.RLock()
+	defer sdb.mtx.RUnlock()
+
+	var (
+		source Iterator
+		err    error
+	)
+	if reverse {
+		source, err = sdb.db.ReverseIterator(start, end)
+	} else {
+		source, err = sdb.db.Iterator(start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newStagedIterator(source, sdb.stagedRange(start, end, reverse), reverse), nil
+}
+
+// stagedRange returns the staged writes in [start, end) in iteration order. The caller must hold
+// the mutex.
+func (sdb *StagedDB) stagedRange(start, end []byte, reverse bool) []*item {
+	items := []*item{}
+	visitor := func(i btree.Item) bool {
+		items = append(items, i.(*item))
+		return true
+	}
+	switch {
+	case start == nil && end == nil:
+		sdb.staged.Ascend(visitor)
+	case end == nil:
+		sdb.staged.AscendGreaterOrEqual(newKey(start), visitor)
+	case start == nil:
+		sdb.staged.AscendLessThan(newKey(end), visitor)
+	default:
+		sdb.staged.AscendRange(newKey(start), newKey(end), visitor)
+	}
+	if reverse {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	return items
+}
+
+// Close implements DB. Staged writes which have not been committed are discarded.
+func (sdb *StagedDB) Close() error {
+	sdb.mtx.Lock()
+	defer sdb.mtx.Unlock()
+
+	sdb.discard()
+	return sdb.db.Close()
+}
+
+// NewBatch implements DB. The batch writes directly to the underlying database, see StageBatch for
+// staging writes.
+func (sdb *StagedDB) NewBatch() Batch {
+	return sdb.db.NewBatch()
+}
+
+// Print implements DB.
+func (sdb *StagedDB) Print() error {
+	sdb.mtx.RLock()
+	fmt.Printf("staged writes: %d\n", sdb.staged.Len())
+	sdb.mtx.RUnlock()
+	return sdb.db.Print()
+}
+
+// Stats implements DB.
+func (sdb *StagedDB) Stats() map[string]string {
+	sdb.mtx.RLock()
+	staged := sdb.staged.Len()
+	sdb.mtx.RUnlock()
+
+	stats := make(map[string]string)
+	stats["stageddb.staged"] = fmt.Sprintf("%d", staged)
+	for key, value := range sdb.db.Stats() {
+		stats["stageddb.source."+key] = value
+	}
+	return stats
+}
+
+// Compact implements DB, compacting the underlying database. Staged writes are not affected.
+func (sdb *StagedDB) Compact(start, end []byte) error {
+	return sdb.db.Compact(start, end)
+}
+
+// stagedBatch stages writes in a StagedDB.
+type stagedBatch struct {
+	db     *StagedDB
+	closed bool
+}
+
+var _ Batch = (*stagedBatch)(nil)
+
+// Set implements Batch.
+func (b *stagedBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return b.stage(newPair(key, value))
+}
+
+// Delete implements Batch.
+func (b *stagedBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return b.stage(newKey(key))
+}
+
+func (b *stagedBatch) stage(i *item) error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+	b.db.staged.ReplaceOrInsert(i)
+	return nil
+}
+
+// Write implements Batch.
+func (b *stagedBatch) Write() error {
+	return b.write(false)
+}
+
+// WriteSync implements Batch.
+func (b *stagedBatch) WriteSync() error {
+	return b.write(true)
+}
+
+func (b *stagedBatch) write(sync bool) error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	if b.closed {
+		return errBatchClosed
+	}
+	return b.db.commit(sync)
+}
+
+// Close implements Batch. If the batch has not been written, the staged writes are discarded.
+func (b *stagedBatch) Close() error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	if !b.closed {
+		b.db.discard()
+	}
+	return nil
+}
+
+// stagedIterator merges an iterator over the underlying database with a snapshot of the staged
+// writes in its domain.
+type stagedIterator struct {
+	iteratorGuard
+
+	source  Iterator
+	staged  []*item
+	pos     int
+	reverse bool
+	// useStaged is set when the current item is staged[pos] rather than the source item.
+	useStaged bool
+}
+
+var _ Iterator = (*stagedIterator)(nil)
+
+func newStagedIterator(source Iterator, staged []*item, reverse bool) *stagedIterator {
+	itr := &stagedIterator{
+		source:  source,
+		staged:  staged,
+		reverse: reverse,
+	}
+	itr.settle()
+	return itr
+}
+
+// compare compares keys in iteration order.
+func (itr *stagedIterator) compare(a, b []byte) int {
+	if itr.reverse {
+		return bytes.Compare(b, a)
+	}
+	return bytes.Compare(a, b)
+}
+
+// settle positions the iterator on the next visible item, which is the first of the source and
+// staged items in iteration order. Staged items take precedence over source items with the same
+// key, and staged deletes are skipped along with the source items they hide.
+func (itr *stagedIterator) settle() {
+	for itr.pos < len(itr.staged) {
+		staged := itr.staged[itr.pos]
+		if itr.source.Valid() {
+			c := itr.compare(itr.source.Key(), staged.key)
+			if c < 0 {
+				break
+			}
+			if c == 0 {
+				itr.source.Next()
+			}
+		}
+		if staged.value != nil {
+			itr.useStaged = true
+			return
+		}
+		itr.pos++
+	}
+	itr.useStaged = false
+}
+
+// Domain implements Iterator.
+func (itr *stagedIterator) Domain() (start []byte, end []byte) {
+	return itr.source.Domain()
+}
+
+// Valid implements Iterator.
+func (itr *stagedIterator) Valid() bool {
+	return itr.useStaged || itr.source.Valid()
+}
+
+// Next implements Iterator.
+func (itr *stagedIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	if itr.useStaged {
+		itr.pos++
+	} else {
+		itr.source.Next()
+	}
+	itr.settle()
+}
+
+// Key implements Iterator.
+func (itr *stagedIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	if itr.useStaged {
+		return itr.staged[itr.pos].key
+	}
+	return itr.source.Key()
+}
+
+// Value implements Iterator.
+func (itr *stagedIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	if itr.useStaged {
+		return itr.staged[itr.pos].value
+	}
+	return itr.source.Value()
+}
+
+// Error implements Iterator.
+func (itr *stagedIterator) Error() error {
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *stagedIterator) Close() error {
+	return itr.source.Close()
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collectIterator returns the keys and values of an iterator as strings, and closes it.
+func collectIterator(t *testing.T, itr Iterator) [][2]string {
+	t.Helper()
+	pairs := [][2]string{}
+	for ; itr.Valid(); itr.Next() {
+		pairs = append(pairs, [2]string{string(itr.Key()), string(itr.Value())})
+	}
+	require.NoError(t, itr.Error())
+	require.NoError(t, itr.Close())
+	return pairs
+}
+
+func TestStagedDB(t *testing.T) {
+	mdb := NewMemDB()
+	for _, key := range []string{"a", "c", "e", "g"} {
+		require.NoError(t, mdb.Set([]byte(key), []byte("db-"+key)))
+	}
+	sdb := NewStagedDB(mdb)
+
+	batch := sdb.StageBatch()
+	require.NoError(t, batch.Set([]byte("b"), []byte("staged-b")))
+	require.NoError(t, batch.Set([]byte("c"), []byte("staged-c")))
+	require.NoError(t, batch.Delete([]byte("e")))
+	require.NoError(t, batch.Delete([]byte("f")))
+	require.NoError(t, batch.Set([]byte("h"), []byte("staged-h")))
+	require.Same(t, batch, sdb.StageBatch())
+
+	value, err := sdb.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("staged-c"), value)
+	value, err = sdb.Get([]byte("e"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	ok, err := sdb.Has([]byte("e"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = sdb.Has([]byte("b"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The underlying database is unchanged.
+	value, err = mdb.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("db-c"), value)
+
+	testcases := map[string]struct {
+		start, end []byte
+		expect     [][2]string
+	}{
+		"all": {nil, nil, [][2]string{
+			{"a", "db-a"}, {"b", "staged-b"}, {"c", "staged-c"}, {"g", "db-g"}, {"h", "staged-h"},
+		}},
+		"from staged":  {[]byte("b"), []byte("g"), [][2]string{{"b", "staged-b"}, {"c", "staged-c"}}},
+		"from delete":  {[]byte("e"), nil, [][2]string{{"g", "db-g"}, {"h", "staged-h"}}},
+		"to staged":    {nil, []byte("h"), [][2]string{{"a", "db-a"}, {"b", "staged-b"}, {"c", "staged-c"}, {"g", "db-g"}}},
+		"deleted only": {[]byte("e"), []byte("g"), [][2]string{}},
+	}
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			itr, err := sdb.Iterator(tc.start, tc.end)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, collectIterator(t, itr))
+
+			reversed := make([][2]string, 0, len(tc.expect))
+			for i := len(tc.expect) - 1; i >= 0; i-- {
+				reversed = append(reversed, tc.expect[i])
+			}
+			itr, err = sdb.ReverseIterator(tc.start, tc.end)
+			require.NoError(t, err)
+			require.Equal(t, reversed, collectIterator(t, itr))
+		})
+	}
+
+	require.NoError(t, sdb.Commit())
+	require.Error(t, batch.Set([]byte("x"), []byte("x")))
+	itr, err := mdb.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{
+		{"a", "db-a"}, {"b", "staged-b"}, {"c", "staged-c"}, {"g", "db-g"}, {"h", "staged-h"},
+	}, collectIterator(t, itr))
+}
+
+func TestStagedDBDiscard(t *testing.T) {
+	mdb := NewMemDB()
+	require.NoError(t, mdb.Set([]byte("a"), []byte("a")))
+	sdb := NewStagedDB(mdb)
+
+	batch := sdb.StageBatch()
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.Close())
+	require.ErrorIs(t, batch.Write(), errBatchClosed)
+
+	value, err := sdb.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), value)
+
+	// A new staging batch is committed by writing it.
+	batch = sdb.StageBatch()
+	require.NoError(t, batch.Set([]byte("b"), []byte("b")))
+	require.NoError(t, batch.WriteSync())
+	require.NoError(t, batch.Close())
+	value, err = mdb.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), value)
+}
+
+func TestStagedDBRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte { return []byte{byte('a' + r.Intn(20))} }
+
+	for round := 0; round < 200; round++ {
+		mdb := NewMemDB()
+		expect := map[string]string{}
+		for i := 0; i < 10; i++ {
+			key, value := randKey(), []byte{byte('0' + r.Intn(10))}
+			require.NoError(t, mdb.Set(key, value))
+			expect[string(key)] = string(value)
+		}
+		sdb := NewStagedDB(mdb)
+		batch := sdb.StageBatch()
+		for i := 0; i < 10; i++ {
+			key := randKey()
+			if r.Intn(2) == 0 {
+				require.NoError(t, batch.Delete(key))
+				delete(expect, string(key))
+			} else {
+				value := []byte{byte('A' + r.Intn(10))}
+				require.NoError(t, batch.Set(key, value))
+				expect[string(key)] = string(value)
+			}
+		}
+
+		var start, end []byte
+		if r.Intn(2) == 0 {
+			start = randKey()
+		}
+		if r.Intn(2) == 0 {
+			end = randKey()
+		}
+		if start != nil && end != nil && string(start) > string(end) {
+			// Swapped bounds are rejected, see ErrInvalidRange.
+			start, end = end, start
+		}
+		expected := [][2]string{}
+		for key, value := range expect {
+			if (start == nil || key >= string(start)) && (end == nil || key < string(end)) {
+				expected = append(expected, [2]string{key, value})
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i][0] < expected[j][0] })
+
+		itr, err := sdb.Iterator(start, end)
+		require.NoError(t, err)
+		require.Equal(t, expected, collectIterator(t, itr), "range [%q, %q)", start, end)
+
+		for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+			expected[i], expected[j] = expected[j], expected[i]
+		}
+		itr, err = sdb.ReverseIterator(start, end)
+		require.NoError(t, err)
+		require.Equal(t, expected, collectIterator(t, itr), "reverse range [%q, %q)", start, end)
+	}
+}
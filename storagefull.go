@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// optionReadOnlyAfterStorageFull is the number of consecutive storage-full errors after which a
+// backend becomes read-only, see ErrReadOnly. Zero or absent never makes it read-only.
+const optionReadOnlyAfterStorageFull = "read_only_after_storage_full"
+
+// ErrReadOnly is returned by sets and batch writes of a database which became read-only after
+// repeated storage-full errors, see Capabilities.ReadOnly. Deletes are still allowed, so that space
+// can be freed by pruning. The database must be reopened to accept writes again.
+var ErrReadOnly = errors.New("database is read-only after repeated storage-full errors")
+
+// ErrStorageFull is returned by writes which failed because the disk is full or a storage quota
+// was exceeded, e.g. ENOSPC or EDQUOT for GoLevelDB and BadgerDB, or an out of disk space or Atlas
+// space quota error for MongoDB.
+type ErrStorageFull struct {
+	// Err is the error of the backend.
+	Err error
+}
+
+func (e ErrStorageFull) Error() string {
+	return fmt.Sprintf("storage full: %v", e.Err)
+}
+
+// Unwrap returns the error of the backend.
+func (e ErrStorageFull) Unwrap() error {
+	return e.Err
+}
+
+// readOnlyAfterStorageFull returns the threshold configured by the read_only_after_storage_full
+// option.
+func readOnlyAfterStorageFull(options Options) (int, error) {
+	s, ok := options[optionReadOnlyAfterStorageFull]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", optionReadOnlyAfterStorageFull, s, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s %d: must not be negative", optionReadOnlyAfterStorageFull, n)
+	}
+	return n, nil
+}
+
+// diskFullError wraps err in ErrStorageFull if it is caused by a full disk or an exceeded disk
+// quota. Other errors are returned unchanged.
+func diskFullError(err error) error {
+	if err != nil && isDiskFull(err) {
+		return ErrStorageFull{Err: err}
+	}
+	return err
+}
+
+// storageFullGuard counts the storage-full errors of a backend, and makes it read-only after a
+// number of consecutive ones. The zero value counts errors but never makes the backend read-only.
+type storageFullGuard struct {
+	// threshold is the number of consecutive errors after which the backend is read-only, or zero.
+	threshold int64
+
+	consecutive atomic.Int64
+	total       atomic.Uint64
+	readOnly    atomic.Bool
+}
+
+// writable returns ErrReadOnly if the backend became read-only.
+func (g *storageFullGuard) writable() error {
+	if g.readOnly.Load() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// track records the outcome of a write, whose error was already classified, and returns the error.
+// Any other outcome than ErrStorageFull ends a run of consecutive errors.
+func (g *storageFullGuard) track(err error) error {
+	var full ErrStorageFull
+	if !errors.As(err, &full) {
+		g.consecutive.Store(0)
+		return err
+	}
+	g.total.Add(1)
+	n := g.consecutive.Add(1)
+	if g.threshold > 0 && n >= g.threshold && g.readOnly.CompareAndSwap(false, true) {
+		logf("%d consecutive storage-full errors, switching to read-only: %v", n, full.Err)
+	}
+	return err
+}
+
+// isReadOnly returns whether the backend became read-only.
+func (g *storageFullGuard) isReadOnly() bool {
+	return g.readOnly.Load()
+}
+
+// addStats adds the counters under storage_full to stats.
+func (g *storageFullGuard) addStats(stats map[string]string) {
+	stats["storage_full.errors"] = strconv.FormatUint(g.total.Load(), 10)
+	stats["storage_full.consecutive"] = strconv.FormatInt(g.consecutive.Load(), 10)
+	stats["storage_full.read_only"] = strconv.FormatBool(g.readOnly.Load())
+}
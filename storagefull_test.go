@@ -0,0 +1,154 @@
+//go:build !windows
+// +build !windows
+
+package db
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// diskFullStorage is a goleveldb storage whose writes fail with ENOSPC once full is set.
+type diskFullStorage struct {
+	storage.Storage
+	full atomic.Bool
+}
+
+func (s *diskFullStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &diskFullWriter{Writer: w, storage: s}, nil
+}
+
+type diskFullWriter struct {
+	storage.Writer
+	storage *diskFullStorage
+}
+
+func (w *diskFullWriter) Write(p []byte) (int, error) {
+	if w.storage.full.Load() {
+		return 0, &os.PathError{Op: "write", Path: "000001.log", Err: syscall.ENOSPC}
+	}
+	return w.Writer.Write(p)
+}
+
+func TestDiskFullError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT} {
+		err := diskFullError(&os.PathError{Op: "write", Path: "file", Err: errno})
+		var full ErrStorageFull
+		require.ErrorAs(t, err, &full)
+		require.ErrorIs(t, err, errno)
+	}
+	err := errors.New("boom")
+	require.Equal(t, err, diskFullError(err))
+	require.NoError(t, diskFullError(nil))
+}
+
+func TestGoLevelDBStorageFull(t *testing.T) {
+	stor := &diskFullStorage{Storage: storage.NewMemStorage()}
+	ldb, err := leveldb.Open(stor, nil)
+	require.NoError(t, err)
+	db := &GoLevelDB{db: ldb, storageFull: storageFullGuard{threshold: 3}}
+	defer db.Close()
+
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	stor.full.Store(true)
+
+	// The first failures are classified, and the database stays writable until the threshold.
+	for i := 0; i < 2; i++ {
+		err := db.Set(bz("b"), bz("2"))
+		require.ErrorAs(t, err, &ErrStorageFull{})
+		require.ErrorIs(t, err, syscall.ENOSPC)
+		require.False(t, DBCapabilities(db).ReadOnly)
+	}
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set(bz("c"), bz("3")))
+	require.ErrorAs(t, batch.Write(), &ErrStorageFull{})
+	require.NoError(t, batch.Close())
+
+	require.True(t, DBCapabilities(db).ReadOnly)
+	require.ErrorIs(t, db.Set(bz("b"), bz("2")), ErrReadOnly)
+	require.ErrorIs(t, db.SetSync(bz("b"), bz("2")), ErrReadOnly)
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set(bz("c"), bz("3")))
+	require.ErrorIs(t, batch.Write(), ErrReadOnly)
+	require.NoError(t, batch.Close())
+	// Deletes are still attempted.
+	require.ErrorAs(t, db.Delete(bz("a")), &ErrStorageFull{})
+	checkValue(t, db, bz("a"), bz("1"))
+
+	stats := db.Stats()
+	require.Equal(t, "4", stats["storage_full.errors"])
+	require.Equal(t, "4", stats["storage_full.consecutive"])
+	require.Equal(t, "true", stats["storage_full.read_only"])
+}
+
+func TestStorageFullGuard(t *testing.T) {
+	g := storageFullGuard{threshold: 2}
+	full := ErrStorageFull{Err: syscall.ENOSPC}
+
+	// Other outcomes end a run of consecutive errors.
+	require.Equal(t, full, g.track(full))
+	require.NoError(t, g.track(nil))
+	require.Equal(t, full, g.track(full))
+	require.NoError(t, g.writable())
+	require.Equal(t, full, g.track(full))
+	require.ErrorIs(t, g.writable(), ErrReadOnly)
+	require.True(t, g.isReadOnly())
+
+	// Without a threshold, the database never becomes read-only.
+	g = storageFullGuard{}
+	for i := 0; i < 10; i++ {
+		g.track(full) //nolint:errcheck
+	}
+	require.NoError(t, g.writable())
+}
+
+func TestReadOnlyAfterStorageFullOption(t *testing.T) {
+	n, err := readOnlyAfterStorageFull(Options{})
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = readOnlyAfterStorageFull(Options{optionReadOnlyAfterStorageFull: "5"})
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	for _, s := range []string{"x", "-1"} {
+		_, err = readOnlyAfterStorageFull(Options{optionReadOnlyAfterStorageFull: s})
+		require.Error(t, err)
+	}
+}
+
+func TestStorageFullMongoErrors(t *testing.T) {
+	db := NewMongoDB(nil)
+	db.storageFull.threshold = 3
+
+	for _, err := range []error{
+		mongo.CommandError{Code: mongoCodeOutOfDiskSpace, Message: "out of disk space"},
+		mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: mongoCodeOutOfDiskSpace}}},
+		mongo.CommandError{Code: mongoCodeAtlasError, Message: "you are over your space quota, using 513 MB of 512 MB"},
+	} {
+		require.False(t, DBCapabilities(db).ReadOnly)
+		require.Equal(t, ErrStorageFull{Err: err}, db.wrapWriteError(err))
+	}
+	require.True(t, DBCapabilities(db).ReadOnly)
+	require.ErrorIs(t, db.Set(bz("a"), bz("1")), ErrReadOnly)
+	_, err := db.SetIfAbsent(bz("a"), bz("1"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, db.SetUpdateOnly(bz("a"), bz("1")), ErrReadOnly)
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set(bz("a"), bz("1")))
+	require.ErrorIs(t, batch.Write(), ErrReadOnly)
+
+	// Other Atlas errors are not classified.
+	err = mongo.CommandError{Code: mongoCodeAtlasError, Message: "user is not allowed to do action"}
+	require.Equal(t, err, NewMongoDB(nil).wrapWriteError(err))
+}
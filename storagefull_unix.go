@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package db
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull returns whether err is caused by a full disk or an exceeded disk quota.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultTeeMaxConcurrentRepairs is the default of TeeConfig.MaxConcurrentRepairs.
+const defaultTeeMaxConcurrentRepairs = 4
+
+// TeeConfig configures a TeeDB.
+type TeeConfig struct {
+	// RepairFraction is the fraction of Gets, between 0 and 1, whose key is also checked in the
+	// secondary and repaired if needed. Zero disables read repair.
+	RepairFraction float64
+	// MaxConcurrentRepairs caps the number of checks and repairs running at once. Sampled reads
+	// beyond the cap are not checked. Defaults to 4.
+	MaxConcurrentRepairs int
+}
+
+// TeeDB writes to two databases, e.g. while migrating from one backend to another, and reads from
+// the primary one. Each write is applied to the primary and then to the secondary, and fails if
+// either fails; a failed write to the secondary leaves the databases diverged.
+//
+// With read repair, a sample of Gets also checks the key in the secondary in the background, and
+// writes the value of the primary to the secondary if it is missing or different there. Repairs
+// never block the Get, and are skipped when too many are already running. A repair may race with a
+// concurrent write of the same key, which the next repair of the key corrects. Repair counters are
+// included in Stats under tee.repair.
+type TeeDB struct {
+	DB
+
+	secondary DB
+	fraction  float64
+
+	// sem holds a token for each running repair.
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	randMtx sync.Mutex
+	rand    *rand.Rand
+
+	checks   atomic.Uint64
+	repairs  atomic.Uint64
+	failures atomic.Uint64
+	skipped  atomic.Uint64
+}
+
+var _ DB = (*TeeDB)(nil)
+
+// NewTeeDB returns a database writing to primary and secondary, and reading from primary, without
+// read repair.
+func NewTeeDB(primary, secondary DB) *TeeDB {
+	return NewTeeDBWithConfig(primary, secondary, TeeConfig{})
+}
+
+// NewTeeDBWithConfig is like NewTeeDB, with the given configuration.
+func NewTeeDBWithConfig(primary, secondary DB, cfg TeeConfig) *TeeDB {
+	if cfg.MaxConcurrentRepairs <= 0 {
+		cfg.MaxConcurrentRepairs = defaultTeeMaxConcurrentRepairs
+	}
+	return &TeeDB{
+		DB:        primary,
+		secondary: secondary,
+		fraction:  cfg.RepairFraction,
+		sem:       make(chan struct{}, cfg.MaxConcurrentRepairs),
+		rand:      rand.New(rand.NewSource(rand.Int63())), //nolint:gosec // sampling only
+	}
+}
+
+// Unwrap implements Unwrapper, returning the primary database.
+func (tdb *TeeDB) Unwrap() DB {
+	return tdb.DB
+}
+
+// Secondary returns the secondary database.
+func (tdb *TeeDB) Secondary() DB {
+	return tdb.secondary
+}
+
+// Get implements DB.
+func (tdb *TeeDB) Get(key []byte) ([]byte, error) {
+	value, err := tdb.DB.Get(key)
+	if err == nil && tdb.sampled() {
+		tdb.repair(key)
+	}
+	return value, err
+}
+
+// sampled returns whether a read is sampled for repair.
+func (tdb *TeeDB) sampled() bool {
+	if tdb.fraction <= 0 {
+		return false
+	}
+	tdb.randMtx.Lock()
+	defer tdb.randMtx.Unlock()
+	return tdb.rand.Float64() < tdb.fraction
+}
+
+// repair starts checking a key in the background, unless too many repairs are running.
+func (tdb *TeeDB) repair(key []byte) {
+	select {
+	case tdb.sem <- struct{}{}:
+	default:
+		tdb.skipped.Add(1)
+		return
+	}
+	key = cp(key)
+	tdb.wg.Add(1)
+	go func() {
+		defer tdb.wg.Done()
+		defer func() { <-tdb.sem }()
+		tdb.checks.Add(1)
+		repaired, err := tdb.repairKey(key)
+		switch {
+		case err != nil:
+			tdb.failures.Add(1)
+			logf("tee: failed to repair key %X: %v", key, err)
+		case repaired:
+			tdb.repairs.Add(1)
+		}
+	}()
+}
+
+// repairKey writes the value of key in the primary to the secondary if it differs, and returns
+// whether it did. The primary is read again after the secondary, to narrow the window in which a
+// concurrent write can be overwritten with a stale value.
+func (tdb *TeeDB) repairKey(key []byte) (bool, error) {
+	secondary, err := tdb.secondary.Get(key)
+	if err != nil {
+		return false, err
+	}
+	primary, err := tdb.DB.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if (primary == nil) == (secondary == nil) && bytes.Equal(primary, secondary) {
+		return false, nil
+	}
+	if primary == nil {
+		return true, tdb.secondary.Delete(key)
+	}
+	return true, tdb.secondary.Set(key, primary)
+}
+
+// Set implements DB.
+func (tdb *TeeDB) Set(key, value []byte) error {
+	if err := tdb.DB.Set(key, value); err != nil {
+		return err
+	}
+	return secondaryError(tdb.secondary.Set(key, value))
+}
+
+// SetSync implements DB.
+func (tdb *TeeDB) SetSync(key, value []byte) error {
+	if err := tdb.DB.SetSync(key, value); err != nil {
+		return err
+	}
+	return secondaryError(tdb.secondary.SetSync(key, value))
+}
+
+// Delete implements DB.
+func (tdb *TeeDB) Delete(key []byte) error {
+	if err := tdb.DB.Delete(key); err != nil {
+		return err
+	}
+	return secondaryError(tdb.secondary.Delete(key))
+}
+
+// DeleteSync implements DB.
+func (tdb *TeeDB) DeleteSync(key []byte) error {
+	if err := tdb.DB.DeleteSync(key); err != nil {
+		return err
+	}
+	return secondaryError(tdb.secondary.DeleteSync(key))
+}
+
+// secondaryError wraps an error of the secondary database, if any.
+func secondaryError(err error) error {
+	if err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	return nil
+}
+
+// NewBatch implements DB.
+func (tdb *TeeDB) NewBatch() Batch {
+	return &teeBatch{Batch: tdb.DB.NewBatch(), secondary: tdb.secondary.NewBatch()}
+}
+
+// Stats implements DB, adding the read repair counters to the stats of the primary.
+func (tdb *TeeDB) Stats() map[string]string {
+	stats := tdb.DB.Stats()
+	if stats == nil {
+		stats = make(map[string]string)
+	}
+	for key, n := range map[string]*atomic.Uint64{
+		"tee.repair.checks":   &tdb.checks,
+		"tee.repair.repaired": &tdb.repairs,
+		"tee.repair.failures": &tdb.failures,
+		"tee.repair.skipped":  &tdb.skipped,
+	} {
+		stats[key] = strconv.FormatUint(n.Load(), 10)
+	}
+	return stats
+}
+
+// Close implements DB. It waits for running repairs, and closes both databases.
+func (tdb *TeeDB) Close() error {
+	tdb.wg.Wait()
+	if err := tdb.DB.Close(); err != nil {
+		return err
+	}
+	return secondaryError(tdb.secondary.Close())
+}
+
+// teeBatch applies its operations to a batch of each database.
+type teeBatch struct {
+	Batch
+
+	secondary Batch
+}
+
+// Set implements Batch.
+func (b *teeBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	return secondaryError(b.secondary.Set(key, value))
+}
+
+// Delete implements Batch.
+func (b *teeBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	return secondaryError(b.secondary.Delete(key))
+}
+
+// Write implements Batch.
+func (b *teeBatch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	return secondaryError(b.secondary.Write())
+}
+
+// WriteSync implements Batch.
+func (b *teeBatch) WriteSync() error {
+	if err := b.Batch.WriteSync(); err != nil {
+		return err
+	}
+	return secondaryError(b.secondary.WriteSync())
+}
+
+// Close implements Batch.
+func (b *teeBatch) Close() error {
+	err := b.Batch.Close()
+	if secondaryErr := b.secondary.Close(); err == nil {
+		err = secondaryError(secondaryErr)
+	}
+	return err
+}
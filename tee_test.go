@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeeDB(t *testing.T) {
+	primary, secondary := NewMemDB(), NewMemDB()
+	db := NewTeeDB(primary, secondary)
+
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.SetSync([]byte("b"), []byte("2")))
+	require.NoError(t, db.Delete([]byte("a")))
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("c"), []byte("3")))
+	require.NoError(t, batch.Delete([]byte("b")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	for _, backing := range []DB{primary, secondary} {
+		assertKeyValues(t, backing, map[string][]byte{"c": []byte("3")})
+	}
+	require.Equal(t, "0", db.Stats()["tee.repair.checks"])
+	require.NoError(t, db.Close())
+}
+
+func TestTeeDBReadRepair(t *testing.T) {
+	primary, secondary := NewMemDB(), NewMemDB()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("key%d", i)), []byte("primary")))
+	}
+	// key0 and key1 are missing from the secondary, key2 and key3 differ, key4 is up to date, and
+	// stale is missing from the primary.
+	require.NoError(t, secondary.Set([]byte("key2"), []byte("secondary")))
+	require.NoError(t, secondary.Set([]byte("key3"), []byte("secondary")))
+	require.NoError(t, secondary.Set([]byte("key4"), []byte("primary")))
+	require.NoError(t, secondary.Set([]byte("stale"), []byte("secondary")))
+
+	db := NewTeeDBWithConfig(primary, secondary, TeeConfig{RepairFraction: 1, MaxConcurrentRepairs: 100})
+	for _, key := range []string{"key0", "key2", "key4", "stale"} {
+		value, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		if key == "stale" {
+			require.Nil(t, value)
+		} else {
+			require.Equal(t, []byte("primary"), value)
+		}
+	}
+	db.wg.Wait()
+
+	// The keys read converged, the others did not.
+	checkValue(t, secondary, []byte("key0"), []byte("primary"))
+	checkValue(t, secondary, []byte("key2"), []byte("primary"))
+	checkValue(t, secondary, []byte("key4"), []byte("primary"))
+	checkValue(t, secondary, []byte("stale"), nil)
+	checkValue(t, secondary, []byte("key1"), nil)
+	checkValue(t, secondary, []byte("key3"), []byte("secondary"))
+
+	stats := db.Stats()
+	require.Equal(t, "4", stats["tee.repair.checks"])
+	require.Equal(t, "3", stats["tee.repair.repaired"])
+	require.Equal(t, "0", stats["tee.repair.failures"])
+	require.Equal(t, "0", stats["tee.repair.skipped"])
+
+	// Without sampling, nothing is checked.
+	db = NewTeeDB(primary, secondary)
+	_, err := db.Get([]byte("key1"))
+	require.NoError(t, err)
+	db.wg.Wait()
+	checkValue(t, secondary, []byte("key1"), nil)
+}
+
+// blockingDB blocks its Gets until released, and records how many run at once.
+type blockingDB struct {
+	DB
+
+	release chan struct{}
+	running atomic.Int64
+	maxMtx  sync.Mutex
+	max     int64
+}
+
+func (db *blockingDB) Get(key []byte) ([]byte, error) {
+	n := db.running.Add(1)
+	defer db.running.Add(-1)
+	db.maxMtx.Lock()
+	if n > db.max {
+		db.max = n
+	}
+	db.maxMtx.Unlock()
+	<-db.release
+	return db.DB.Get(key)
+}
+
+func TestTeeDBReadRepairCap(t *testing.T) {
+	primary := NewMemDB()
+	secondary := &blockingDB{DB: NewMemDB(), release: make(chan struct{})}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+	db := NewTeeDBWithConfig(primary, secondary, TeeConfig{RepairFraction: 1, MaxConcurrentRepairs: 3})
+
+	// Gets return while repairs are blocked on the secondary.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			value, err := db.Get([]byte(fmt.Sprintf("key%d", i)))
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), value)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked on read repair")
+	}
+
+	close(secondary.release)
+	db.wg.Wait()
+	require.LessOrEqual(t, secondary.max, int64(3))
+	stats := db.Stats()
+	require.Equal(t, "3", stats["tee.repair.checks"])
+	require.Equal(t, "3", stats["tee.repair.repaired"])
+	require.Equal(t, "17", stats["tee.repair.skipped"])
+}
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Values stored by TTLDB are framed as magic | version | expiry | value, where expiry is the
+// big-endian Unix time in nanoseconds at which the value expires, or 0 if it never expires.
+var ttlFrameMagic = []byte{0xff, 'T'}
+
+const (
+	ttlFrameVersion    = 1
+	ttlFrameHeaderSize = 2 + 1 + 8
+)
+
+// errTTLFrameInvalid is returned when a value read by TTLDB was not written by it, e.g. because
+// the wrapper was enabled on existing data.
+var errTTLFrameInvalid = errors.New("value is not a TTL frame")
+
+// Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock of the system.
+type systemClock struct{}
+
+// Now implements Clock.
+func (systemClock) Now() time.Time { return time.Now() }
+
+// TTLDB wraps a database and allows values to expire. Values written with SetTTL expire after
+// the given duration, while values written with Set never expire. Expired values are hidden from
+// Get, Has and iterators, but are only deleted by PurgeExpired.
+//
+// All values are stored with a small versioned header, so TTLDB cannot be enabled on a database
+// with existing data: reading a value which was not written by TTLDB returns an error.
+type TTLDB struct {
+	DB
+
+	clock Clock
+}
+
+var (
+	_ DB        = (*TTLDB)(nil)
+	_ TTLSetter = (*TTLDB)(nil)
+)
+
+// NewTTLDB wraps db, using clock to determine expiry. A nil clock uses the system clock.
+func NewTTLDB(db DB, clock Clock) *TTLDB {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &TTLDB{DB: db, clock: clock}
+}
+
+// encodeTTLFrame frames value with the given expiry time in Unix nanoseconds.
+func encodeTTLFrame(value []byte, expiry uint64) []byte {
+	frame := make([]byte, ttlFrameHeaderSize, ttlFrameHeaderSize+len(value))
+	copy(frame, ttlFrameMagic)
+	frame[len(ttlFrameMagic)] = ttlFrameVersion
+	binary.BigEndian.PutUint64(frame[len(ttlFrameMagic)+1:], expiry)
+	return append(frame, value...)
+}
+
+// decodeTTLFrame returns the value and expiry time of a frame.
+func decodeTTLFrame(key, frame []byte) (value []byte, expiry uint64, err error) {
+	if len(frame) < ttlFrameHeaderSize || !bytes.HasPrefix(frame, ttlFrameMagic) {
+		return nil, 0, fmt.Errorf("%w: key %X", errTTLFrameInvalid, key)
+	}
+	if version := frame[len(ttlFrameMagic)]; version != ttlFrameVersion {
+		return nil, 0, fmt.Errorf("%w: key %X has unsupported version %d", errTTLFrameInvalid, key, version)
+	}
+	expiry = binary.BigEndian.Uint64(frame[len(ttlFrameMagic)+1:])
+	return frame[ttlFrameHeaderSize:], expiry, nil
+}
+
+// ttlExpired returns whether a value with the given expiry has expired at now.
+func ttlExpired(expiry uint64, now time.Time) bool {
+	return expiry != 0 && uint64(now.UnixNano()) >= expiry
+}
+
+// get returns the value of a key, or nil if it does not exist or has expired.
+func (tdb *TTLDB) get(key []byte) ([]byte, error) {
+	frame, err := tdb.DB.Get(key)
+	if err != nil || frame == nil {
+		return nil, err
+	}
+	value, expiry, err := decodeTTLFrame(key, frame)
+	if err != nil {
+		return nil, err
+	}
+	if ttlExpired(expiry, tdb.clock.Now()) {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// Unwrap implements Unwrapper.
+func (tdb *TTLDB) Unwrap() DB {
+	return tdb.DB
+}
+
+// Get implements DB.
+func (tdb *TTLDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	return tdb.get(key)
+}
+
+// Has implements DB.
+func (tdb *TTLDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	value, err := tdb.get(key)
+	return value != nil, err
+}
+
+// Set implements DB. The value never expires.
+func (tdb *TTLDB) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return tdb.DB.Set(key, encodeTTLFrame(value, 0))
+}
+
+// SetSync implements DB. The value never expires.
+func (tdb *TTLDB) SetSync(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return tdb.DB.SetSync(key, encodeTTLFrame(value, 0))
+}
+
+// SetTTL sets a value which expires after ttl.
+func (tdb *TTLDB) SetTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if ttl <= 0 {
+		return invalidTTLError(ttl)
+	}
+	expiry := uint64(tdb.clock.Now().Add(ttl).UnixNano())
+	return tdb.DB.Set(key, encodeTTLFrame(value, expiry))
+}
+
+// SetWithTTL implements TTLSetter, see SetTTL.
+func (tdb *TTLDB) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return tdb.SetTTL(key, value, ttl)
+}
+
+// invalidTTLError returns the error of a TTL which is not positive.
+func invalidTTLError(ttl time.Duration) error {
+	return fmt.Errorf("invalid TTL %v: must be positive", ttl)
+}
+
+// Iterator implements DB.
+func (tdb *TTLDB) Iterator(start, end []byte) (Iterator, error) {
+	itr, err := tdb.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newTTLIterator(itr, tdb.clock.Now()), nil
+}
+
+// ReverseIterator implements DB.
+func (tdb *TTLDB) ReverseIterator(start, end []byte) (Iterator, error) {
+	itr, err := tdb.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newTTLIterator(itr, tdb.clock.Now()), nil
+}
+
+// NewBatch implements DB. Values set in the batch never expire.
+func (tdb *TTLDB) NewBatch() Batch {
+	return ttlBatch{tdb.DB.NewBatch()}
+}
+
+// PurgeExpired deletes all expired values, in batches of batchSize keys, and returns the number of
+// deleted keys. A batchSize of 0 or less defaults to 1000.
+func (tdb *TTLDB) PurgeExpired(batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = defaultPruneBatchSize
+	}
+
+	var (
+		start  []byte
+		purged int64
+		now    = tdb.clock.Now()
+	)
+	for {
+		keys, next, err := tdb.scanExpired(start, batchSize, now)
+		if err != nil {
+			return purged, err
+		}
+		if len(keys) > 0 {
+			if err := deleteKeys(tdb.DB, keys); err != nil {
+				return purged, err
+			}
+			purged += int64(len(keys))
+		}
+		if next == nil {
+			return purged, nil
+		}
+		start = next
+	}
+}
+
+// scanExpired returns up to limit expired keys from start onwards, and the key to continue the
+// scan from, or nil if the scan is complete. The iterator is closed before returning, as writes
+// are not allowed during iteration.
+func (tdb *TTLDB) scanExpired(start []byte, limit int, now time.Time) (keys [][]byte, next []byte, err error) {
+	itr, err := tdb.DB.Iterator(start, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if closeErr := itr.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for ; itr.Valid(); itr.Next() {
+		_, expiry, err := decodeTTLFrame(itr.Key(), itr.Value())
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ttlExpired(expiry, now) {
+			continue
+		}
+		keys = append(keys, cp(itr.Key()))
+		if len(keys) == limit {
+			return keys, append(cp(itr.Key()), 0), itr.Error()
+		}
+	}
+	return keys, nil, itr.Error()
+}
+
+// ttlIterator wraps an iterator over framed values, skipping expired values and stripping the
+// frame header.
+type ttlIterator struct {
+	iteratorGuard
+
+	source Iterator
+	now    time.Time
+	value  []byte
+	err    error
+}
+
+var _ Iterator = (*ttlIterator)(nil)
+
+func newTTLIterator(source Iterator, now time.Time) *ttlIterator {
+	itr := &ttlIterator{source: source, now: now}
+	itr.skipExpired()
+	return itr
+}
+
+// skipExpired advances the source past any expired values. An invalid frame stops the iteration.
+func (itr *ttlIterator) skipExpired() {
+	for itr.source.Valid() {
+		value, expiry, err := decodeTTLFrame(itr.source.Key(), itr.source.Value())
+		if err != nil {
+			itr.err = err
+			return
+		}
+		if !ttlExpired(expiry, itr.now) {
+			itr.value = value
+			return
+		}
+		itr.source.Next()
+	}
+}
+
+// Domain implements Iterator.
+func (itr *ttlIterator) Domain() (start []byte, end []byte) {
+	return itr.source.Domain()
+}
+
+// Valid implements Iterator.
+func (itr *ttlIterator) Valid() bool {
+	return itr.err == nil && itr.source.Valid()
+}
+
+// Next implements Iterator.
+func (itr *ttlIterator) Next() {
+	if !itr.guard(itr.Valid()) {
+		return
+	}
+	itr.source.Next()
+	itr.skipExpired()
+}
+
+// Key implements Iterator.
+func (itr *ttlIterator) Key() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.source.Key()
+}
+
+// Value implements Iterator.
+func (itr *ttlIterator) Value() []byte {
+	if !itr.guard(itr.Valid()) {
+		return nil
+	}
+	return itr.value
+}
+
+// Error implements Iterator.
+func (itr *ttlIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	if err := itr.source.Error(); err != nil {
+		return err
+	}
+	return itr.misuseError()
+}
+
+// Close implements Iterator.
+func (itr *ttlIterator) Close() error {
+	return itr.source.Close()
+}
+
+// ttlBatch frames the values set in a batch so that they never expire.
+type ttlBatch struct {
+	Batch
+}
+
+// Set implements Batch.
+func (b ttlBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return b.Batch.Set(key, encodeTTLFrame(value, 0))
+}
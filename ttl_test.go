@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock which only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestTTLDBExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	db := NewTTLDB(NewMemDB(), clock)
+
+	require.NoError(t, db.Set([]byte("forever"), []byte("f")))
+	require.NoError(t, db.SetTTL([]byte("short"), []byte("s"), time.Second))
+	require.NoError(t, db.SetTTL([]byte("empty"), []byte{}, time.Second))
+	require.Error(t, db.SetTTL([]byte("zero"), []byte("z"), 0))
+	require.Equal(t, errValueNil, db.SetTTL([]byte("nil"), nil, time.Second))
+
+	// The value is visible until just before its expiry.
+	clock.advance(time.Second - time.Nanosecond)
+	value, err := db.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("s"), value)
+	value, err = db.Get([]byte("empty"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, value)
+
+	// At its expiry, it is gone.
+	clock.advance(time.Nanosecond)
+	value, err = db.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	has, err := db.Has([]byte("short"))
+	require.NoError(t, err)
+	require.False(t, has)
+	has, err = db.Has([]byte("forever"))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// Overwriting an expired value with Set makes it permanent.
+	require.NoError(t, db.Set([]byte("short"), []byte("s2")))
+	clock.advance(time.Hour)
+	value, err = db.Get([]byte("short"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("s2"), value)
+}
+
+func TestTTLDBIterator(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	db := NewTTLDB(NewMemDB(), clock)
+	for i := 0; i < 6; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if i%2 == 0 {
+			require.NoError(t, db.SetTTL(key, []byte{byte(i)}, time.Duration(i+1)*time.Second))
+		} else {
+			require.NoError(t, db.Set(key, []byte{byte(i)}))
+		}
+	}
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("key9"), []byte{9}))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+
+	clock.advance(3 * time.Second)
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{
+		{"key1", "\x01"}, {"key3", "\x03"}, {"key4", "\x04"}, {"key5", "\x05"}, {"key9", "\x09"},
+	}, collectIterator(t, itr))
+
+	itr, err = db.ReverseIterator([]byte("key1"), []byte("key5"))
+	require.NoError(t, err)
+	require.Equal(t, [][2]string{{"key4", "\x04"}, {"key3", "\x03"}, {"key1", "\x01"}}, collectIterator(t, itr))
+}
+
+func TestTTLDBPurgeExpired(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mdb := NewMemDB()
+	db := NewTTLDB(mdb, clock)
+	for i := 0; i < 25; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if i%5 == 0 {
+			require.NoError(t, db.Set(key, []byte("keep")))
+		} else {
+			require.NoError(t, db.SetTTL(key, []byte("expire"), time.Minute))
+		}
+	}
+
+	purged, err := db.PurgeExpired(3)
+	require.NoError(t, err)
+	require.Zero(t, purged)
+
+	clock.advance(time.Minute)
+	purged, err = db.PurgeExpired(3)
+	require.NoError(t, err)
+	require.EqualValues(t, 20, purged)
+	require.Equal(t, 5, mdb.btree.Len())
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.Len(t, collectIterator(t, itr), 5)
+}
+
+func TestTTLDBUnframedData(t *testing.T) {
+	mdb := NewMemDB()
+	require.NoError(t, mdb.Set([]byte("a"), []byte("plain value")))
+	require.NoError(t, mdb.Set([]byte("b"), []byte{0xff, 'T', 2, 0, 0, 0, 0, 0, 0, 0, 0}))
+	db := NewTTLDB(mdb, nil)
+
+	_, err := db.Get([]byte("a"))
+	require.ErrorIs(t, err, errTTLFrameInvalid)
+	_, err = db.Has([]byte("b"))
+	require.ErrorIs(t, err, errTTLFrameInvalid)
+	require.ErrorContains(t, err, "unsupported version 2")
+
+	itr, err := db.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.False(t, itr.Valid())
+	require.ErrorIs(t, itr.Error(), errTTLFrameInvalid)
+	require.NoError(t, itr.Close())
+
+	_, err = db.PurgeExpired(0)
+	require.ErrorIs(t, err, errTTLFrameInvalid)
+}
+
+func TestSetWithTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	db := NewTTLDB(NewMemDB(), clock)
+	require.NoError(t, SetWithTTL(db, []byte("short"), []byte("s"), time.Second))
+	require.Error(t, SetWithTTL(db, []byte("zero"), []byte("z"), 0))
+	checkValue(t, db, []byte("short"), []byte("s"))
+	clock.advance(time.Second)
+	checkValue(t, db, []byte("short"), nil)
+
+	// Backends without expiring keys, and wrappers hiding them, do not support it.
+	var notSupported ErrNotSupported
+	require.ErrorAs(t, SetWithTTL(NewMemDB(), []byte("key"), []byte("value"), time.Second), &notSupported)
+	require.Equal(t, "SetWithTTL", notSupported.Op)
+	require.ErrorAs(t, SetWithTTL(NewPrefixDB(db, []byte("p/")), []byte("key"), []byte("value"), time.Second),
+		&notSupported)
+}
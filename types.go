@@ -1,6 +1,13 @@
 package db
 
-import "errors"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 var (
 	// errBatchClosed is returned when a closed or written batch is used.
@@ -11,8 +18,184 @@ var (
 
 	// errValueNil is returned when attempting to set a nil value.
 	errValueNil = errors.New("value cannot be nil")
+
+	// ErrKeyNotFound is returned by strict deletes and update-only sets when the key does not
+	// exist, see StrictDeleter and StrictSetter.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrUnavailable wraps the errors of networked backends which could not reach their server,
+	// e.g. while it restarts. The operation may succeed if retried later. See Pinger.
+	ErrUnavailable = errors.New("database unavailable")
 )
 
+// ErrNotSupported is returned by operations which the database does not support, e.g. SetWithTTL
+// on a backend without expiring keys.
+type ErrNotSupported struct {
+	// Op is the name of the operation, e.g. "SetWithTTL".
+	Op string
+}
+
+func (e ErrNotSupported) Error() string {
+	return fmt.Sprintf("%s is not supported by this database", e.Op)
+}
+
+// ErrKeyTooLarge is returned when setting a key which is longer than the maximum key size of the
+// backend, see Capabilities.
+type ErrKeyTooLarge struct {
+	// Size is the size of the key.
+	Size int
+	// Max is the maximum key size.
+	Max int
+}
+
+func (e ErrKeyTooLarge) Error() string {
+	return fmt.Sprintf("key of %d bytes exceeds the maximum key size of %d bytes", e.Size, e.Max)
+}
+
+// ErrValueTooLarge is returned when setting a value which is larger than the backend can store.
+type ErrValueTooLarge struct {
+	// Size is the size of the value.
+	Size int
+	// Max is the maximum value size.
+	Max int
+}
+
+func (e ErrValueTooLarge) Error() string {
+	return fmt.Sprintf("value of %d bytes exceeds the maximum value size of %d bytes", e.Size, e.Max)
+}
+
+// ErrKeyExists is returned by a WriteOnceDB when setting a key which already has a different value,
+// by insert-only sets of a key which already exists, see StrictSetter, and by restores of a key
+// which already has a different value, see RestoreOptions.
+type ErrKeyExists struct {
+	// Key is the existing key.
+	Key []byte
+}
+
+func (e ErrKeyExists) Error() string {
+	return fmt.Sprintf("key %X already exists", e.Key)
+}
+
+// ErrInvalidRange is returned when creating an iterator whose start is after its end, which
+// usually means that the bounds were swapped. Databases created with the lenient_ranges option
+// return an empty iterator instead.
+type ErrInvalidRange struct {
+	// Start is the start of the range.
+	Start []byte
+	// End is the end of the range.
+	End []byte
+}
+
+func (e ErrInvalidRange) Error() string {
+	return fmt.Sprintf("invalid iterator range: start %X is after end %X", e.Start, e.End)
+}
+
+// ErrBatchIncomplete is returned by batches written in several chunks when a chunk failed, e.g.
+// because the deadline of WriteContext passed. The chunks before Chunk were applied, while the
+// failed chunk may be partially applied. See ResumableBatch.
+type ErrBatchIncomplete struct {
+	// Chunk is the index of the failed chunk.
+	Chunk int
+	// Applied is the number of operations of the chunks before Chunk, and Total the number of
+	// operations of the batch, after coalescing.
+	Applied int
+	Total   int
+	// Err is the error of the chunk.
+	Err error
+}
+
+func (e *ErrBatchIncomplete) Error() string {
+	return fmt.Sprintf("batch incomplete: chunk %d failed with %d of %d operations applied: %v",
+		e.Chunk, e.Applied, e.Total, e.Err)
+}
+
+// Unwrap returns the error of the chunk.
+func (e *ErrBatchIncomplete) Unwrap() error {
+	return e.Err
+}
+
+// ErrCompactUnsupported is returned by DB.Compact when the database cannot be compacted, e.g.
+// because the server forbids compaction.
+type ErrCompactUnsupported struct {
+	// Backend is the backend of the database.
+	Backend BackendType
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *ErrCompactUnsupported) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s does not support compaction", e.Backend)
+	}
+	return fmt.Sprintf("%s does not support compaction: %v", e.Backend, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrCompactUnsupported) Unwrap() error {
+	return e.Err
+}
+
+// ErrAborted is returned by the operations of a ContextDB when their context was canceled or its
+// deadline passed before the operation completed. It wraps the error of the context, so that
+// errors.Is(err, context.Canceled) or errors.Is(err, context.DeadlineExceeded) holds.
+type ErrAborted struct {
+	// Op is the name of the aborted operation, e.g. "get".
+	Op string
+	// Err is the error of the context.
+	Err error
+	// Cause is the error of the operation, if it was aborted while in flight.
+	Cause error
+}
+
+func (e *ErrAborted) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("%s aborted: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s aborted: %v: %v", e.Op, e.Err, e.Cause)
+}
+
+// Unwrap returns the error of the context and the error of the operation.
+func (e *ErrAborted) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+// checkRange returns ErrInvalidRange if start is after end, unless lenient is set.
+func checkRange(start, end []byte, lenient bool) error {
+	if !lenient && start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		return ErrInvalidRange{Start: start, End: end}
+	}
+	return nil
+}
+
+// checkKeys returns an error naming the index of the first empty key, if any.
+func checkKeys(keys [][]byte) error {
+	for i, key := range keys {
+		if len(key) == 0 {
+			return fmt.Errorf("key %d: %w", i, errKeyEmpty)
+		}
+	}
+	return nil
+}
+
+// checkKeySize returns ErrKeyTooLarge if key is longer than max, where zero means unlimited.
+func checkKeySize(key []byte, max int) error {
+	if max > 0 && len(key) > max {
+		return ErrKeyTooLarge{Size: len(key), Max: max}
+	}
+	return nil
+}
+
+// checkValueLimit returns ErrValueTooLarge if value is longer than max, where zero means unlimited.
+func checkValueLimit(value []byte, max int) error {
+	if max > 0 && len(value) > max {
+		return ErrValueTooLarge{Size: len(value), Max: max}
+	}
+	return nil
+}
+
 // DB is the main interface for all database backends. DBs are concurrency-safe. Callers must call
 // Close on the database when done.
 //
@@ -41,18 +224,21 @@ type DB interface {
 	// DeleteSync deletes the key, and flushes the delete to storage before returning.
 	DeleteSync([]byte) error
 
-	// Iterator returns an iterator over a domain of keys, in ascending order. The caller must call
-	// Close when done. End is exclusive, and start must be less than end. A nil start iterates
-	// from the first key, and a nil end iterates to the last key (inclusive). Empty keys are not
-	// valid.
+	// Iterator returns an iterator over a domain of keys, in ascending order. Keys are ordered as
+	// unsigned bytes, as by bytes.Compare, on all backends, regardless of whether they are valid
+	// UTF-8. The caller must call Close when done. Start is inclusive and end is exclusive. A nil
+	// start iterates from the first key, and a nil end iterates to the last key (inclusive). Empty
+	// keys are not valid. A start equal to end gives an empty iterator, and a start after end
+	// returns ErrInvalidRange, or an empty iterator on databases created with the lenient_ranges
+	// option.
 	// CONTRACT: No writes may happen within a domain while an iterator exists over it.
 	// CONTRACT: start, end readonly []byte
 	Iterator(start, end []byte) (Iterator, error)
 
-	// ReverseIterator returns an iterator over a domain of keys, in descending order. The caller
-	// must call Close when done. End is exclusive, and start must be less than end. A nil end
-	// iterates from the last key (inclusive), and a nil start iterates to the first key (inclusive).
-	// Empty keys are not valid.
+	// ReverseIterator returns an iterator over a domain of keys, in descending order, the reverse
+	// of the order of Iterator. The caller must call Close when done. End is exclusive and start is
+	// inclusive. A nil end iterates from the last key (inclusive), and a nil start iterates to the
+	// first key (inclusive). Empty keys are not valid. Bounds are checked like those of Iterator.
 	// CONTRACT: No writes may happen within a domain while an iterator exists over it.
 	// CONTRACT: start, end readonly []byte
 	ReverseIterator(start, end []byte) (Iterator, error)
@@ -68,6 +254,359 @@ type DB interface {
 
 	// Stats returns a map of property values for all keys and the size of the cache.
 	Stats() map[string]string
+
+	// Compact compacts the storage of the domain [start, end), reclaiming the space of deleted and
+	// overwritten keys, e.g. after pruning. A nil start compacts from the first key, and a nil end
+	// to the last key, so that nil and nil compact the whole database. Backends may compact more
+	// than the domain, or do nothing if their storage needs no compaction. Compact does not change
+	// the keys and values of the database. It fails with an *ErrCompactUnsupported if the server
+	// forbids compaction.
+	// CONTRACT: start, end readonly []byte
+	Compact(start, end []byte) error
+}
+
+// Capabilities describes the limits of a backend, so that applications can avoid data which works
+// on one backend but not on another. See DBCapabilities.
+type Capabilities struct {
+	// MaxKeySize is the maximum key size in bytes, or zero if keys are unlimited. Setting a longer
+	// key fails with ErrKeyTooLarge.
+	MaxKeySize int
+
+	// ZeroCopy is set if values returned by Get and by iterators reference internal buffers of the
+	// database, enabled by the unsafe_zero_copy option. Such values must not be modified, and are
+	// only valid until the next operation on the database, or until the next call to Next or Close
+	// on the iterator. Callers must copy them to retain them.
+	ZeroCopy bool
+
+	// ReadOnly is set if the database became read-only after repeated storage-full errors, enabled
+	// by the read_only_after_storage_full option. Sets and batch writes then fail with ErrReadOnly.
+	ReadOnly bool
+}
+
+// CapabilityReporter is implemented by databases which declare their Capabilities.
+type CapabilityReporter interface {
+	// Capabilities returns the limits of the database.
+	Capabilities() Capabilities
+}
+
+// RangeDeleter is implemented by databases which can natively delete all keys in a domain, which
+// is usually much faster than deleting the keys one by one. See PruneRange.
+type RangeDeleter interface {
+	// DeleteRange deletes all keys in the domain [start, end), and returns the number of deleted
+	// keys. A nil start or end means the domain is unbounded in that direction.
+	DeleteRange(start, end []byte) (deleted int64, err error)
+}
+
+// Compacter is implemented by databases which can compact a key range to reclaim the space used by
+// deleted keys.
+//
+// Deprecated: all databases implement Compact, see DB.
+type Compacter interface {
+	// Compact compacts the underlying storage for the domain [start, end). A nil start or end means
+	// the domain is unbounded in that direction.
+	Compact(start, end []byte) error
+}
+
+// PrefixSummarizer is implemented by databases which can summarize the keys under a prefix more
+// efficiently than by iterating over them. See SummarizePrefix.
+type PrefixSummarizer interface {
+	// PrefixSummary returns the number of keys with the given prefix, and the first and last of
+	// them in key order. The keys are nil if there are none.
+	PrefixSummary(prefix []byte) (count int64, firstKey, lastKey []byte, err error)
+}
+
+// ValueSizer is implemented by databases which can return the size of a value without transferring
+// or copying it. See ValueSize.
+type ValueSizer interface {
+	// ValueSize returns the length of the value stored under key, or -1 if the key does not exist.
+	ValueSize(key []byte) (int, error)
+}
+
+// KeySpaceAnalyzer is implemented by databases which can sample their keys without iterating over
+// all of them. See AnalyzeKeySpace.
+type KeySpaceAnalyzer interface {
+	// AnalyzeKeySpace samples up to sample keys, and splits the key space into at most buckets
+	// ranges holding roughly the same number of keys.
+	AnalyzeKeySpace(buckets int, sample int) (KeySpaceReport, error)
+}
+
+// PhysicalWriteCounter is implemented by databases which report the number of bytes they wrote to
+// storage, including the writes of compactions and other internal work. See WriteAccountingDB.
+type PhysicalWriteCounter interface {
+	// PhysicalBytesWritten returns a cumulative count of the bytes written to storage. Only the
+	// difference between two counts is meaningful, as the count may start at any value.
+	PhysicalBytesWritten() (int64, error)
+}
+
+// Warmer is implemented by databases and wrappers with caches which can be prefilled, e.g. after a
+// restart. See Warmup.
+type Warmer interface {
+	// Warmup loads the keys selected by spec into the cache, until the limits of spec are reached
+	// or ctx is canceled.
+	Warmup(ctx context.Context, spec WarmupSpec) error
+}
+
+// KeyWatcher is implemented by databases and wrappers which publish the changes of keys, such as
+// WatchableDB. See WaitForKey.
+type KeyWatcher interface {
+	// Subscribe returns a channel receiving the events of keys with the given prefix, buffering up
+	// to buffer events, and a function which ends the subscription and closes the channel.
+	Subscribe(prefix []byte, buffer int) (<-chan KVEvent, func())
+}
+
+// Reconfigurable is implemented by databases and wrappers whose settings can be changed while they
+// are open. See ReconfigureAll.
+type Reconfigurable interface {
+	// Reconfigure applies the options it supports, and ignores options of other layers. Options which
+	// cannot be changed while open fail with an *ErrImmutableOptions if their value differs from
+	// the current one. Nothing is changed if an error is returned.
+	Reconfigure(opts Options) error
+}
+
+// Unwrapper is implemented by wrappers, returning the database they wrap.
+type Unwrapper interface {
+	Unwrap() DB
+}
+
+// MultiDeleter is implemented by databases which can natively delete a list of keys, which is
+// usually faster than deleting the keys one by one. See DeleteKeys.
+type MultiDeleter interface {
+	// DeleteKeys deletes the given keys, and returns the number of keys which existed. Keys may be
+	// given in any order and more than once. Nothing is deleted if any key is empty.
+	DeleteKeys(keys [][]byte) (deleted int64, err error)
+}
+
+// MultiGetter is implemented by databases which can natively fetch a list of keys, which is usually
+// faster than getting the keys one by one, e.g. because it takes fewer round trips. See GetMany.
+type MultiGetter interface {
+	// GetMany returns the values of the given keys, in the order of keys, with nil for the keys
+	// which do not exist. Keys may be given more than once. Nothing is read if any key is empty.
+	GetMany(keys [][]byte) ([][]byte, error)
+}
+
+// StrictDeleter is implemented by databases which can atomically check that a key exists when
+// deleting it. See DeleteStrict.
+type StrictDeleter interface {
+	// DeleteStrict deletes a key, and returns ErrKeyNotFound if it does not exist. Of several
+	// concurrent strict deletes of the same key, exactly one succeeds.
+	DeleteStrict(key []byte) error
+}
+
+// ConditionalSetter is implemented by databases which can atomically set a key only if it does not
+// exist. See WriteOnceDB.
+type ConditionalSetter interface {
+	// SetIfAbsent sets the value of key if the key does not exist, and returns whether it was set.
+	// Of several concurrent conditional sets of the same key, at most one succeeds.
+	SetIfAbsent(key, value []byte) (bool, error)
+}
+
+// StrictSetter is implemented by databases which can atomically check whether a key exists when
+// setting it, to set a key only if it is new or only if it exists. See SetInsertOnly and
+// SetUpdateOnly.
+type StrictSetter interface {
+	// SetInsertOnly sets a key which does not exist, and returns an ErrKeyExists if it exists.
+	SetInsertOnly(key, value []byte) error
+	// SetUpdateOnly sets a key which exists, and returns ErrKeyNotFound if it does not exist.
+	SetUpdateOnly(key, value []byte) error
+}
+
+// StrictSetBatch is implemented by batches which support insert-only and update-only sets. The
+// conditions are checked when the batch is written, against the database before the batch. If a
+// key is written several times in the batch, only its last write is applied and checked. Write
+// returns an ErrKeyExists or an error wrapping ErrKeyNotFound if a condition does not hold; whether
+// the other writes of the batch are applied then depends on the backend.
+type StrictSetBatch interface {
+	// SetInsertOnly sets a key which must not exist.
+	SetInsertOnly(key, value []byte) error
+	// SetUpdateOnly sets a key which must exist.
+	SetUpdateOnly(key, value []byte) error
+}
+
+// StrictBatch is implemented by batches which can report deletes of keys which did not exist.
+type StrictBatch interface {
+	// WriteStrict writes the batch like Write, and then returns an error wrapping ErrKeyNotFound if
+	// any of its deletes did not delete a key. The batch is written either way.
+	WriteStrict() error
+}
+
+// BatchCreator is implemented by databases which can pre-size batches for an expected number of
+// operations. See NewBatchWithSize.
+type BatchCreator interface {
+	// NewBatchWithSize creates a batch sized for the given number of operations. The size is only
+	// a hint, and the batch may hold any number of operations.
+	NewBatchWithSize(size int) Batch
+}
+
+// ProgressBatch is implemented by batches which are applied in several chunks, and can report
+// their progress while writing. See NewChunkedBatch.
+type ProgressBatch interface {
+	// SetProgressFunc sets a function which is called after each chunk is written, with the number
+	// of operations written so far and the total number of operations.
+	SetProgressFunc(fn func(done, total int))
+}
+
+// ContextBatch is implemented by batches whose write can be bounded by a context.
+type ContextBatch interface {
+	// WriteContext writes the batch like Write, within the deadline of ctx, which bounds the whole
+	// write including all its chunks and retries. A write which fails after some chunks were
+	// applied returns an *ErrBatchIncomplete, and leaves the batch open to be resumed.
+	WriteContext(ctx context.Context) error
+}
+
+// ContextDB is implemented by databases whose operations can be bounded by a context. An operation
+// whose context is canceled or whose deadline passes, before or while it runs, fails with an
+// *ErrAborted, except for batch writes, which fail like ContextBatch.WriteContext. The plain
+// methods of DB behave like these with context.Background(). See AsContextDB.
+type ContextDB interface {
+	// GetContext is like Get.
+	GetContext(ctx context.Context, key []byte) ([]byte, error)
+	// HasContext is like Has.
+	HasContext(ctx context.Context, key []byte) (bool, error)
+	// SetContext is like Set.
+	SetContext(ctx context.Context, key, value []byte) error
+	// DeleteContext is like Delete.
+	DeleteContext(ctx context.Context, key []byte) error
+	// IteratorContext is like Iterator, with ctx also bounding the reads of the returned iterator,
+	// which stops with an *ErrAborted from Error once ctx is done.
+	IteratorContext(ctx context.Context, start, end []byte) (Iterator, error)
+	// ReverseIteratorContext is like ReverseIterator, with ctx bounding the reads like
+	// IteratorContext.
+	ReverseIteratorContext(ctx context.Context, start, end []byte) (Iterator, error)
+	// NewBatchContext is like NewBatch, with ctx bounding the writes of the batch like
+	// ContextBatch.WriteContext.
+	NewBatchContext(ctx context.Context) Batch
+}
+
+// ResumableBatch is implemented by batches which are applied in several chunks, and can resume a
+// write which failed with an *ErrBatchIncomplete.
+type ResumableBatch interface {
+	// ResumeFrom makes the next write of the batch skip the chunks before chunkIndex, which were
+	// applied by an earlier write. Operations must not be added to the batch in between, so that
+	// it is split into the same chunks.
+	ResumeFrom(chunkIndex int) error
+}
+
+// BatchStats describes how the operations of a batch were coalesced. Only the last operation on
+// each key is written, so earlier sets and deletes of the same key are collapsed.
+type BatchStats struct {
+	// Staged is the number of operations added to the batch.
+	Staged int
+	// Emitted is the number of operations written after coalescing.
+	Emitted int
+	// Collapsed is the number of operations dropped by coalescing, i.e. Staged - Emitted once
+	// the batch is written.
+	Collapsed int
+	// BytesSaved is the total key and value size of the collapsed operations.
+	BytesSaved int64
+}
+
+// CoalescingBatch is implemented by batches which coalesce multiple operations on the same key.
+type CoalescingBatch interface {
+	// Stats returns the coalescing statistics of the batch. Emitted, Collapsed and BytesSaved are
+	// only known once the batch has been written.
+	Stats() BatchStats
+	// SetDuplicateHook sets a function which is called when the batch is written if more than
+	// threshold operations were collapsed, to help find wasteful write patterns.
+	SetDuplicateHook(threshold int, fn func(BatchStats))
+}
+
+// SizedBatch is implemented by batches which report the size of their pending writes, so that
+// callers can decide when to write them.
+type SizedBatch interface {
+	// Count returns the number of operations the batch will write, i.e. the number of distinct
+	// keys written since operations on the same key are coalesced.
+	Count() int
+	// SizeBytes returns the total key and value size of those operations.
+	SizeBytes() int
+}
+
+// ResettableBatch is implemented by the batches of the backends, which can be reused once written
+// or closed, e.g. by callers flushing a batch periodically. It is also implemented by the batches
+// of most wrapping databases, e.g. PrefixDB and CacheDB, which reset their own state, and close and
+// replace the wrapped batch if it does not implement it.
+type ResettableBatch interface {
+	// Reset drops the pending operations of the batch, and reopens it if it was written or
+	// closed, so that it can be used as if newly created by the same database.
+	Reset()
+}
+
+// DBStats are the statistics of a database, see StatsProvider. Sizes and counts which the
+// backend does not report are -1.
+type DBStats struct {
+	// KeyCount is the number of keys in the database, which may be an estimate.
+	KeyCount int64
+	// DiskSizeBytes is the size of the data of the database on disk.
+	DiskSizeBytes int64
+	// MemSizeBytes is the size of the data of the database held in memory.
+	MemSizeBytes int64
+	// Raw holds the backend-specific statistics, as reported by Stats besides the fields above.
+	Raw map[string]string
+}
+
+// Map returns the statistics as reported by Stats: the entries of Raw, and the fields reported by
+// the backend under stats.key_count, stats.disk_size_bytes and stats.mem_size_bytes.
+func (s DBStats) Map() map[string]string {
+	stats := make(map[string]string, len(s.Raw)+3)
+	for key, value := range s.Raw {
+		stats[key] = value
+	}
+	for key, value := range map[string]int64{
+		"stats.key_count":       s.KeyCount,
+		"stats.disk_size_bytes": s.DiskSizeBytes,
+		"stats.mem_size_bytes":  s.MemSizeBytes,
+	} {
+		if value >= 0 {
+			stats[key] = strconv.FormatInt(value, 10)
+		}
+	}
+	return stats
+}
+
+// StatsProvider is implemented by databases which report typed statistics, e.g. to export them as
+// metrics without parsing the values of Stats, which they derive from DBStats.Map.
+type StatsProvider interface {
+	// TypedStats returns the statistics of the database. If some of them cannot be read, the
+	// error is returned together with the others.
+	TypedStats() (DBStats, error)
+}
+
+// Snapshotter is implemented by databases which can take a snapshot of their contents, e.g. to
+// capture the state of a simulation at a height and restore it later. See Snapshot and Clone.
+type Snapshotter interface {
+	// Snapshot returns an independent read-only copy of the database, whose writes fail with
+	// ErrSnapshotReadOnly. Later writes to the database do not affect the snapshot.
+	Snapshot() (DB, error)
+}
+
+// TTLSetter is implemented by databases which can store values which expire, e.g. for caches. See
+// SetWithTTL.
+type TTLSetter interface {
+	// SetWithTTL sets a value which expires after ttl, which must be positive. Expired values are
+	// absent from Get, Has and iterators, and are eventually deleted. Setting the key again
+	// without a TTL makes its value permanent.
+	SetWithTTL(key, value []byte, ttl time.Duration) error
+}
+
+// Pinger is implemented by networked databases, which can check that their server is reachable,
+// e.g. for health checks. See Ping.
+type Pinger interface {
+	// Ping returns nil if the server answers before ctx is done, and an error wrapping
+	// ErrUnavailable if it cannot be reached.
+	Ping(ctx context.Context) error
+}
+
+// SeekIterator is implemented by iterators which can move to a key without visiting the keys in
+// between, e.g. to skip a range of keys of an open iterator. See GroupIterator.
+type SeekIterator interface {
+	Iterator
+
+	// Seek positions the iterator at the first key >= key for forward iterators, or at the last
+	// key <= key for reverse iterators, and returns whether it is valid. The key may be before or
+	// after the current position. Seeking before the domain in iteration order positions the
+	// iterator at the first key of its domain, while seeking past the domain invalidates it.
+	// Seeking an invalid iterator does nothing.
+	// CONTRACT: key readonly []byte
+	Seek(key []byte) bool
 }
 
 // Batch represents a group of writes. They may or may not be written atomically depending on the
@@ -120,7 +659,11 @@ type Batch interface {
 //	  ...
 //	}
 type Iterator interface {
-	// Domain returns the start (inclusive) and end (exclusive) limits of the iterator.
+	// Domain returns the start (inclusive) and end (exclusive) limits of the iterator, for both
+	// forward and reverse iterators. The limits are the logical bounds in the key space of the
+	// database the iterator was created from, i.e. wrappers such as PrefixDB report the bounds
+	// given by the caller rather than the translated bounds used by the underlying database. A nil
+	// limit means the iterator is unbounded in that direction.
 	// CONTRACT: start, end readonly []byte
 	Domain() (start []byte, end []byte)
 
@@ -2,7 +2,11 @@ package db
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"time"
 )
 
 func cp(bz []byte) (ret []byte) {
@@ -11,29 +15,49 @@ func cp(bz []byte) (ret []byte) {
 	return ret
 }
 
-// Returns a slice of the same length (big endian)
-// except incremented by one.
+// Returns the smallest key greater than all keys prefixed by bz: bz without
+// its trailing 0xFF bytes, incremented by one (big endian).
 // Returns nil on overflow (e.g. if bz bytes are all 0xFF)
 // CONTRACT: len(bz) > 0
 func cpIncr(bz []byte) (ret []byte) {
 	if len(bz) == 0 {
 		panic("cpIncr expects non-zero bz length")
 	}
-	ret = cp(bz)
 	for i := len(bz) - 1; i >= 0; i-- {
-		if ret[i] < byte(0xFF) {
+		if bz[i] < byte(0xFF) {
+			// Wrapping trailing 0xFF bytes to 0x00 instead would let keys such as bz[:i+1]
+			// incremented, which lack the prefix, sort before the result.
+			ret = cp(bz[:i+1])
 			ret[i]++
 			return
 		}
-		ret[i] = byte(0x00)
-		if i == 0 {
-			// Overflow
-			return nil
-		}
 	}
+	// Overflow
 	return nil
 }
 
+// seekDomain returns the domain of the keys which remain in iteration order once an iterator over
+// [start, end) seeks key, see SeekIterator, or false if none remains.
+func seekDomain(start, end, key []byte, reverse bool) ([]byte, []byte, bool) {
+	if reverse {
+		if start != nil && bytes.Compare(key, start) < 0 {
+			return nil, nil, false
+		}
+		if end == nil || bytes.Compare(key, end) < 0 {
+			// The keys <= key are the keys before its immediate successor.
+			end = append(cp(key), 0)
+		}
+		return start, end, true
+	}
+	if end != nil && bytes.Compare(key, end) >= 0 {
+		return nil, nil, false
+	}
+	if len(key) > 0 && (start == nil || bytes.Compare(key, start) > 0) {
+		start = cp(key)
+	}
+	return start, end, true
+}
+
 // Returns a pointer to any given value
 func ptr[T any](v T) *T {
 	return &v
@@ -54,3 +78,579 @@ func FileExists(filePath string) bool {
 	_, err := os.Stat(filePath)
 	return !os.IsNotExist(err)
 }
+
+// BatchPreview describes the effect a batch would have on a database if it were written.
+type BatchPreview struct {
+	// Inserts is the number of keys which would be set and do not currently exist.
+	Inserts int
+	// Overwrites is the number of keys which would be set and already exist.
+	Overwrites int
+	// Deletes is the number of keys which would be deleted and currently exist.
+	Deletes int
+	// DeletesOfMissing is the number of keys which would be deleted but do not currently exist.
+	DeletesOfMissing int
+	// ByteDelta is the net change in the total size of all keys and values.
+	ByteDelta int64
+}
+
+// PreviewBatch runs build against a staging batch, and computes the effect the staged operations
+// would have on db without writing anything. Only the last operation on each key is considered,
+// as with a written batch.
+func PreviewBatch(db DB, build func(Batch) error) (BatchPreview, error) {
+	var preview BatchPreview
+
+	batch := newStagingBatch()
+	if err := build(batch); err != nil {
+		return preview, err
+	}
+
+	// Keep only the final operation for each key, in order of first appearance.
+	final := make(map[string]operation, len(batch.ops))
+	order := make([]string, 0, len(batch.ops))
+	for _, op := range batch.ops {
+		k := string(op.key)
+		if _, ok := final[k]; !ok {
+			order = append(order, k)
+		}
+		final[k] = op
+	}
+
+	for _, k := range order {
+		op := final[k]
+		existing, err := db.Get(op.key)
+		if err != nil {
+			return preview, err
+		}
+		exists := existing != nil
+
+		switch op.opType {
+		case opTypeSet:
+			if exists {
+				preview.Overwrites++
+				preview.ByteDelta += int64(len(op.value) - len(existing))
+			} else {
+				preview.Inserts++
+				preview.ByteDelta += int64(len(op.key) + len(op.value))
+			}
+		case opTypeDelete:
+			if exists {
+				preview.Deletes++
+				preview.ByteDelta -= int64(len(op.key) + len(existing))
+			} else {
+				preview.DeletesOfMissing++
+			}
+		}
+	}
+
+	return preview, nil
+}
+
+// resetBatch resets batch for the ResettableBatch implementations of wrapping batches. If batch
+// does not implement ResettableBatch, it is closed and replaced with a batch of newBatch.
+func resetBatch(batch Batch, newBatch func() Batch) Batch {
+	if rb, ok := batch.(ResettableBatch); ok {
+		rb.Reset()
+		return batch
+	}
+	_ = batch.Close()
+	return newBatch()
+}
+
+// stagingBatch records operations without applying them to any database. The recorded operations
+// are kept after the batch is written or closed, so that builders may close the batch themselves.
+type stagingBatch struct {
+	ops    []operation
+	closed bool
+}
+
+var _ Batch = (*stagingBatch)(nil)
+
+func newStagingBatch() *stagingBatch {
+	return &stagingBatch{
+		ops: []operation{},
+	}
+}
+
+// Set implements Batch.
+func (b *stagingBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeSet, key, value})
+	return nil
+}
+
+// Delete implements Batch.
+func (b *stagingBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, operation{opTypeDelete, key, nil})
+	return nil
+}
+
+// Write implements Batch. Nothing is written, the staged operations are kept for the preview.
+func (b *stagingBatch) Write() error {
+	if b.closed {
+		return errBatchClosed
+	}
+	b.closed = true
+	return nil
+}
+
+// WriteSync implements Batch.
+func (b *stagingBatch) WriteSync() error {
+	return b.Write()
+}
+
+// Close implements Batch.
+func (b *stagingBatch) Close() error {
+	b.closed = true
+	return nil
+}
+
+// defaultPruneBatchSize is the number of keys deleted per batch by PruneRange by default.
+const defaultPruneBatchSize = 1000
+
+// PruneOptions configures PruneRange.
+type PruneOptions struct {
+	// BatchSize is the number of keys deleted per batch when the database does not implement
+	// RangeDeleter. Defaults to 1000.
+	BatchSize int
+	// Compact compacts the range after deleting it, see DB.Compact.
+	Compact bool
+	// Progress, if set, is called with the stats so far after every deleted batch.
+	Progress func(PruneStats)
+}
+
+// PruneStats describes the progress of PruneRange.
+type PruneStats struct {
+	// KeysDeleted is the number of deleted keys.
+	KeysDeleted int64
+	// BytesDeleted is the total size of the deleted keys and values. It is best-effort, and is 0
+	// when the database deletes the range natively.
+	BytesDeleted int64
+	// LastDeletedKey is the greatest key deleted so far, or nil if unknown. After a partial
+	// failure, pruning can be resumed from the key after it, i.e. append(LastDeletedKey, 0).
+	LastDeletedKey []byte
+	// Compacted is set if the range was compacted.
+	Compacted bool
+	// Duration is the time taken.
+	Duration time.Duration
+}
+
+// PruneRange deletes all keys in [start, end), using RangeDeleter if the database implements it
+// and batched deletes otherwise, and then optionally compacts the range to reclaim space. On
+// failure, the returned stats report how far pruning got.
+func PruneRange(db DB, start, end []byte, opts PruneOptions) (stats PruneStats, err error) {
+	began := time.Now()
+	defer func() { stats.Duration = time.Since(began) }()
+
+	if rd, ok := db.(RangeDeleter); ok {
+		deleted, err := rd.DeleteRange(start, end)
+		stats.KeysDeleted = deleted
+		if err != nil {
+			return stats, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+	} else if err = pruneBatched(db, start, end, opts, &stats); err != nil {
+		return stats, err
+	}
+
+	if opts.Compact {
+		if err = db.Compact(start, end); err != nil {
+			return stats, err
+		}
+		stats.Compacted = true
+	}
+	return stats, nil
+}
+
+// DeleteRange deletes all keys in [start, end), and returns the number of deleted keys. It uses
+// RangeDeleter if the database implements it, and batched deletes otherwise, see PruneRange.
+func DeleteRange(db DB, start, end []byte) (int64, error) {
+	stats, err := PruneRange(db, start, end, PruneOptions{})
+	return stats.KeysDeleted, err
+}
+
+// pruneBatched deletes all keys in [start, end) in batches, updating stats after every batch. The
+// iterator is closed before each batch is written, as writes are not allowed during iteration.
+func pruneBatched(db DB, start, end []byte, opts PruneOptions, stats *PruneStats) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPruneBatchSize
+	}
+
+	for {
+		itr, err := db.Iterator(start, end)
+		if err != nil {
+			return err
+		}
+		keys := make([][]byte, 0, batchSize)
+		var size int64
+		for ; itr.Valid() && len(keys) < batchSize; itr.Next() {
+			key := cp(itr.Key())
+			keys = append(keys, key)
+			size += int64(len(key) + len(itr.Value()))
+		}
+		err = itr.Error()
+		if closeErr := itr.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if err := deleteKeys(db, keys); err != nil {
+			return err
+		}
+		last := keys[len(keys)-1]
+		stats.KeysDeleted += int64(len(keys))
+		stats.BytesDeleted += size
+		stats.LastDeletedKey = last
+		if opts.Progress != nil {
+			opts.Progress(*stats)
+		}
+
+		// Continue from the smallest key after the last deleted one.
+		start = append(cp(last), 0)
+	}
+}
+
+// deleteKeys deletes the keys in a single batch.
+func deleteKeys(db DB, keys [][]byte) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// SummarizePrefix returns the number of keys with the given prefix, and the first and last of them
+// in key order. It uses PrefixSummarizer if the database implements it, and iterates over the
+// prefix otherwise.
+func SummarizePrefix(db DB, prefix []byte) (count int64, firstKey, lastKey []byte, err error) {
+	if ps, ok := db.(PrefixSummarizer); ok {
+		return ps.PrefixSummary(prefix)
+	}
+	return iteratePrefixSummary(db, prefix)
+}
+
+// iteratePrefixSummary computes the prefix summary by iterating over the prefix.
+func iteratePrefixSummary(db DB, prefix []byte) (count int64, firstKey, lastKey []byte, err error) {
+	itr, err := IteratePrefix(db, prefix)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer itr.Close()
+
+	for ; itr.Valid(); itr.Next() {
+		if firstKey == nil {
+			firstKey = cp(itr.Key())
+		}
+		lastKey = itr.Key()
+		count++
+	}
+	if err := itr.Error(); err != nil {
+		return 0, nil, nil, err
+	}
+	if lastKey != nil {
+		lastKey = cp(lastKey)
+	}
+	return count, firstKey, lastKey, nil
+}
+
+// ValueSize returns the length of the value stored under key, or -1 if the key does not exist. It
+// uses ValueSizer if the database implements it, and Get otherwise.
+func ValueSize(db DB, key []byte) (int, error) {
+	if vs, ok := db.(ValueSizer); ok {
+		return vs.ValueSize(key)
+	}
+	value, err := db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return -1, nil
+	}
+	return len(value), nil
+}
+
+// DeleteStrict deletes a key, and returns ErrKeyNotFound if it does not exist. It uses
+// StrictDeleter if the database implements it, and Has followed by Delete otherwise, in which case
+// concurrent deletes of the same key may all succeed.
+func DeleteStrict(db DB, key []byte) error {
+	if sd, ok := db.(StrictDeleter); ok {
+		return sd.DeleteStrict(key)
+	}
+	ok, err := db.Has(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return db.Delete(key)
+}
+
+// SetInsertOnly sets a key which does not exist, and returns an ErrKeyExists if it exists. It uses
+// StrictSetter if the database implements it, and Has followed by Set otherwise, in which case
+// concurrent sets of the same key may all succeed.
+func SetInsertOnly(db DB, key, value []byte) error {
+	if ss, ok := db.(StrictSetter); ok {
+		return ss.SetInsertOnly(key, value)
+	}
+	ok, err := db.Has(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrKeyExists{Key: key}
+	}
+	return db.Set(key, value)
+}
+
+// SetUpdateOnly sets a key which exists, and returns ErrKeyNotFound if it does not exist. It uses
+// StrictSetter if the database implements it, and Has followed by Set otherwise, in which case a
+// key deleted concurrently may be set again.
+func SetUpdateOnly(db DB, key, value []byte) error {
+	if ss, ok := db.(StrictSetter); ok {
+		return ss.SetUpdateOnly(key, value)
+	}
+	ok, err := db.Has(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return db.Set(key, value)
+}
+
+// DeleteKeys deletes the given keys, and returns the number of keys which existed. It uses
+// MultiDeleter if the database implements it. Otherwise, the keys are looked up and then deleted in
+// a single batch, in which case the count may be inaccurate under concurrent writes.
+func DeleteKeys(db DB, keys [][]byte) (int64, error) {
+	if md, ok := db.(MultiDeleter); ok {
+		return md.DeleteKeys(keys)
+	}
+	if err := checkKeys(keys); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		ok, err := db.Has(key)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	if err := deleteKeys(db, keys); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// GetMany returns the values of the given keys, in the order of keys, with nil for the keys which
+// do not exist. It uses MultiGetter if the database implements it. Otherwise, each distinct key is
+// looked up with Get, and keys given more than once get copies of the same value.
+func GetMany(db DB, keys [][]byte) ([][]byte, error) {
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	if mg, ok := db.(MultiGetter); ok {
+		return mg.GetMany(keys)
+	}
+
+	values := make([][]byte, len(keys))
+	first := make(map[string]int, len(keys))
+	for i, key := range keys {
+		if j, ok := first[string(key)]; ok {
+			if values[j] != nil {
+				values[i] = cp(values[j])
+			}
+			continue
+		}
+		first[string(key)] = i
+		value, err := db.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// NewBatchWithSize creates a batch for the given expected number of operations. It uses
+// BatchCreator if the database implements it, and NewBatch otherwise.
+func NewBatchWithSize(db DB, size int) Batch {
+	if bc, ok := db.(BatchCreator); ok {
+		return bc.NewBatchWithSize(size)
+	}
+	return db.NewBatch()
+}
+
+// SetWithTTL sets a value which expires after ttl, if db implements TTLSetter, and returns
+// ErrNotSupported otherwise. Wrappers which change keys or values, such as PrefixDB, hide the
+// TTLSetter of the database they wrap. See TTLDB for expiring values on any backend.
+func SetWithTTL(db DB, key, value []byte, ttl time.Duration) error {
+	if s, ok := db.(TTLSetter); ok {
+		return s.SetWithTTL(key, value, ttl)
+	}
+	return ErrNotSupported{Op: "SetWithTTL"}
+}
+
+// Ping checks that the server of db is reachable, using the first of db and the databases it wraps
+// which implements Pinger. It returns nil for local databases, which have no server.
+func Ping(ctx context.Context, db DB) error {
+	for db != nil {
+		if p, ok := db.(Pinger); ok {
+			return p.Ping(ctx)
+		}
+		u, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	return nil
+}
+
+// Snapshot returns an independent read-only copy of db, whose writes fail with
+// ErrSnapshotReadOnly. It uses Snapshotter if the database implements it, and copies the keys into
+// a MemDB otherwise, see Clone.
+func Snapshot(db DB) (DB, error) {
+	if s, ok := db.(Snapshotter); ok {
+		return s.Snapshot()
+	}
+	clone, err := cloneToMemDB(db)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotDB{db: clone}, nil
+}
+
+// Clone returns an independent writable copy of db in a MemDB. MemDBs and snapshots are copied
+// directly. Other databases are copied with a single iterator, so the copy is consistent if their
+// iterators read a snapshot, as those of goleveldb do; otherwise, keys written during the copy have
+// either their value from before or after the write.
+func Clone(db DB) (DB, error) {
+	return cloneToMemDB(db)
+}
+
+func cloneToMemDB(db DB) (*MemDB, error) {
+	switch db := db.(type) {
+	case *MemDB:
+		return db.clone(), nil
+	case *snapshotDB:
+		return db.db.clone(), nil
+	}
+
+	itr, err := db.Iterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+	clone := NewMemDB()
+	for ; itr.Valid(); itr.Next() {
+		// The copy is not shared yet, so it needs no lock.
+		clone.set(cp(itr.Key()), cp(itr.Value()))
+	}
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// WaitForKey waits until key exists in db, and returns its value, or ctx.Err() if ctx is done
+// first. If db implements KeyWatcher, the key is looked up whenever it is written, and additionally
+// every poll interval if poll is positive, to notice writes which bypass the watcher. Otherwise,
+// the key is looked up every poll interval, which must be positive.
+func WaitForKey(ctx context.Context, db DB, key []byte, poll time.Duration) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	if w, ok := db.(KeyWatcher); ok {
+		return waitForKeyEvents(ctx, db, w, key, poll)
+	}
+	if poll <= 0 {
+		return nil, fmt.Errorf("invalid poll interval %v", poll)
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		value, err := db.Get(key)
+		if err != nil || value != nil {
+			return value, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForKeyEvents waits for key using the events of w.
+func waitForKeyEvents(ctx context.Context, db DB, w KeyWatcher, key []byte, poll time.Duration) ([]byte, error) {
+	events, unsubscribe := w.Subscribe(key, 1)
+	defer unsubscribe()
+	var tick <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		// The key is looked up after subscribing, so that a write before the subscription is not
+		// missed, and after every event of the prefix rather than only those of the key, since
+		// the events of the key may have been dropped to make room for those of longer keys.
+		value, err := db.Get(key)
+		if err != nil || value != nil {
+			return value, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-tick:
+		case _, ok := <-events:
+			if !ok {
+				return nil, errors.New("watch ended while waiting for key")
+			}
+		}
+	}
+}
+
+// DBCapabilities returns the capabilities declared by db, or unlimited capabilities if db does not
+// implement CapabilityReporter.
+func DBCapabilities(db DB) Capabilities {
+	if cr, ok := db.(CapabilityReporter); ok {
+		return cr.Capabilities()
+	}
+	return Capabilities{}
+}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -118,3 +122,663 @@ func (s *BackendTestSuite) TestPrefixIteratorMatches1N() {
 		})
 	}
 }
+
+// Reverse prefix iterators iterate over everything with the same prefix in descending order,
+// including prefixes ending in 0xFF bytes.
+func (s *BackendTestSuite) TestPrefixIteratorReverse() {
+	for backend := range backends {
+		s.T().Run(fmt.Sprintf("Prefix w/ backend %s", backend), func(t *testing.T) {
+			db, dir := s.newTempDB(t, backend)
+			defer os.RemoveAll(dir)
+			checkPrefixIteratorReverse(t, db)
+		})
+	}
+}
+
+func TestPrefixIteratorReverse(t *testing.T) {
+	checkPrefixIteratorReverse(t, NewMemDB())
+}
+
+func checkPrefixIteratorReverse(t *testing.T, db DB) {
+	for _, key := range [][]byte{
+		bz("a/1"), bz("a/3"), bz("a-3"), bz("b/3"),
+		{0x01, 0xFF}, {0x01, 0xFF, 0x00}, {0x02},
+		{0xFF}, {0xFF, 0xFE}, {0xFF, 0xFF}, {0xFF, 0xFF, 0x01}, {0xFF, 0xFF, 0xFF},
+	} {
+		require.NoError(t, db.SetSync(key, bz("value")))
+	}
+
+	for _, tc := range []struct {
+		prefix []byte
+		want   [][]byte
+	}{
+		{bz("a/"), [][]byte{bz("a/3"), bz("a/1")}},
+		{bz("c"), nil},
+		{[]byte{0x01, 0xFF}, [][]byte{{0x01, 0xFF, 0x00}, {0x01, 0xFF}}},
+		{[]byte{0xFF, 0xFF}, [][]byte{{0xFF, 0xFF, 0xFF}, {0xFF, 0xFF, 0x01}, {0xFF, 0xFF}}},
+		{[]byte{0xFF}, [][]byte{{0xFF, 0xFF, 0xFF}, {0xFF, 0xFF, 0x01}, {0xFF, 0xFF}, {0xFF, 0xFE}, {0xFF}}},
+	} {
+		itr, err := IteratePrefixReverse(db, tc.prefix)
+		require.NoError(t, err)
+		var keys [][]byte
+		for ; itr.Valid(); itr.Next() {
+			keys = append(keys, itr.Key())
+		}
+		require.NoError(t, itr.Error())
+		require.NoError(t, itr.Close())
+		require.Equal(t, tc.want, keys, "prefix %X", tc.prefix)
+
+		// The same range as IteratePrefix, in the opposite order.
+		start, end, err := PrefixRange(tc.prefix)
+		require.NoError(t, err)
+		itr, err = db.Iterator(start, end)
+		require.NoError(t, err)
+		for i := len(tc.want) - 1; i >= 0; i-- {
+			checkValid(t, itr, true)
+			require.Equal(t, tc.want[i], itr.Key())
+			itr.Next()
+		}
+		checkValid(t, itr, false)
+		require.NoError(t, itr.Close())
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, end []byte
+	}{
+		{bz("a/"), bz("a0")},
+		{[]byte{0x01, 0xFF}, []byte{0x02}},
+		{[]byte{0x01, 0xFE, 0xFF, 0xFF}, []byte{0x01, 0xFF}},
+		{[]byte{0xFF}, nil},
+		{[]byte{0xFF, 0xFF}, nil},
+	} {
+		end, err := PrefixEnd(tc.prefix)
+		require.NoError(t, err)
+		require.Equal(t, tc.end, end, "prefix %X", tc.prefix)
+		start, end, err := PrefixRange(tc.prefix)
+		require.NoError(t, err)
+		require.Equal(t, tc.prefix, start)
+		require.Equal(t, tc.end, end, "prefix %X", tc.prefix)
+	}
+
+	prefix := []byte{0x01, 0xFF}
+	start, end, err := PrefixRange(prefix)
+	require.NoError(t, err)
+	start[0], end[0] = 0x09, 0x09
+	require.Equal(t, []byte{0x01, 0xFF}, prefix, "the range must not alias the prefix")
+
+	for _, prefix := range [][]byte{nil, {}} {
+		_, err := PrefixEnd(prefix)
+		require.ErrorIs(t, err, errKeyEmpty)
+		_, _, err = PrefixRange(prefix)
+		require.ErrorIs(t, err, errKeyEmpty)
+	}
+}
+
+func TestPreviewBatch(t *testing.T) {
+	db := NewMemDB()
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	require.NoError(t, db.Set(bz("b"), bz("22")))
+	require.NoError(t, db.Set(bz("c"), bz("333")))
+
+	preview, err := PreviewBatch(db, func(batch Batch) error {
+		defer batch.Close()
+		if err := batch.Set(bz("a"), bz("1111")); err != nil { // overwrite, +3
+			return err
+		}
+		if err := batch.Delete(bz("b")); err != nil { // delete, -3
+			return err
+		}
+		if err := batch.Set(bz("d"), bz("4")); err != nil { // insert, +2
+			return err
+		}
+		if err := batch.Delete(bz("x")); err != nil { // delete of missing
+			return err
+		}
+		// Only the last operation on a key counts.
+		if err := batch.Set(bz("c"), bz("0")); err != nil {
+			return err
+		}
+		return batch.Delete(bz("c")) // delete, -4
+	})
+	require.NoError(t, err)
+	require.Equal(t, BatchPreview{
+		Inserts:          1,
+		Overwrites:       1,
+		Deletes:          2,
+		DeletesOfMissing: 1,
+		ByteDelta:        -2,
+	}, preview)
+
+	// Nothing should have been written.
+	value, err := db.Get(bz("a"))
+	require.NoError(t, err)
+	require.Equal(t, bz("1"), value)
+	ok, err := db.Has(bz("d"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Builder errors are returned as-is.
+	_, err = PreviewBatch(db, func(batch Batch) error {
+		return batch.Set(nil, bz("1"))
+	})
+	require.Equal(t, errKeyEmpty, err)
+}
+
+// checkPruneRange prunes [k10, k20) from db and checks that only out-of-range keys survive.
+func checkPruneRange(t *testing.T, db DB) PruneStats {
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Set(bz(fmt.Sprintf("k%02d", i)), bz("value")))
+	}
+
+	var progress []PruneStats
+	stats, err := PruneRange(db, bz("k10"), bz("k20"), PruneOptions{
+		BatchSize: 3,
+		Compact:   true,
+		Progress:  func(stats PruneStats) { progress = append(progress, stats) },
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 10, stats.KeysDeleted)
+	require.NotEmpty(t, progress)
+	require.Equal(t, stats.KeysDeleted, progress[len(progress)-1].KeysDeleted)
+
+	itr, err := db.Iterator(bz("k10"), bz("k20"))
+	require.NoError(t, err)
+	require.False(t, itr.Valid())
+	require.NoError(t, itr.Close())
+
+	for _, key := range []string{"k00", "k09", "k20", "k29"} {
+		ok, err := db.Has(bz(key))
+		require.NoError(t, err)
+		require.True(t, ok, key)
+	}
+	return stats
+}
+
+func TestPruneRangeGoLevelDB(t *testing.T) {
+	name := fmt.Sprintf("test_%x", randStr(12))
+	db, err := NewGoLevelDB(name, "")
+	require.NoError(t, err)
+	defer cleanupDBDir("", name)
+	defer db.Close()
+
+	// GoLevelDB deletes the range natively, so the deleted bytes and keys are not reported.
+	stats := checkPruneRange(t, db)
+	require.Zero(t, stats.BytesDeleted)
+	require.Nil(t, stats.LastDeletedKey)
+	require.True(t, stats.Compacted)
+}
+
+func TestPruneRangeBatched(t *testing.T) {
+	stats := checkPruneRange(t, NewMemDB())
+	require.EqualValues(t, 10*len("k00value"), stats.BytesDeleted)
+	require.Equal(t, bz("k19"), stats.LastDeletedKey)
+	require.True(t, stats.Compacted)
+}
+
+// failingBatchDB fails every batch write after the first failAfter.
+type failingBatchDB struct {
+	DB
+	failAfter int
+}
+
+type failingBatch struct {
+	Batch
+	db *failingBatchDB
+}
+
+func (db *failingBatchDB) NewBatch() Batch {
+	return failingBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+func (b failingBatch) Write() error {
+	if b.db.failAfter == 0 {
+		return errors.New("write failed")
+	}
+	b.db.failAfter--
+	return b.Batch.Write()
+}
+
+func TestPruneRangeResume(t *testing.T) {
+	mdb := NewMemDB()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, mdb.Set(bz(fmt.Sprintf("k%d", i)), bz("v")))
+	}
+	db := &failingBatchDB{DB: mdb, failAfter: 2}
+
+	stats, err := PruneRange(db, nil, nil, PruneOptions{BatchSize: 3})
+	require.Error(t, err)
+	require.EqualValues(t, 6, stats.KeysDeleted)
+	require.Equal(t, bz("k5"), stats.LastDeletedKey)
+
+	db.failAfter = -1
+	stats, err = PruneRange(db, append(stats.LastDeletedKey, 0), nil, PruneOptions{BatchSize: 3})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, stats.KeysDeleted)
+	require.False(t, stats.Compacted)
+	require.Positive(t, stats.Duration)
+}
+
+// checkSummarizePrefix seeds db and compares SummarizePrefix with the iterator-based summary.
+func checkSummarizePrefix(t *testing.T, db DB) {
+	for _, key := range [][]byte{
+		{0x01}, {0x01, 0x00}, {0x01, 0x02, 0x03}, {0x02},
+		{0xfe, 0xff}, {0xff}, {0xff, 0x00}, {0xff, 0xff}, {0xff, 0xff, 0x01},
+	} {
+		require.NoError(t, db.Set(key, []byte{1}))
+	}
+
+	for _, tc := range []struct {
+		prefix      []byte
+		count       int64
+		first, last []byte
+	}{
+		{nil, 9, []byte{0x01}, []byte{0xff, 0xff, 0x01}},
+		{[]byte{0x01}, 3, []byte{0x01}, []byte{0x01, 0x02, 0x03}},
+		{[]byte{0x03}, 0, nil, nil},
+		{[]byte{0xfe}, 1, []byte{0xfe, 0xff}, []byte{0xfe, 0xff}},
+		{[]byte{0xff}, 4, []byte{0xff}, []byte{0xff, 0xff, 0x01}},
+		{[]byte{0xff, 0xff}, 2, []byte{0xff, 0xff}, []byte{0xff, 0xff, 0x01}},
+	} {
+		count, first, last, err := SummarizePrefix(db, tc.prefix)
+		require.NoError(t, err)
+		require.Equal(t, tc.count, count, "prefix %x", tc.prefix)
+		require.Equal(t, tc.first, first, "prefix %x", tc.prefix)
+		require.Equal(t, tc.last, last, "prefix %x", tc.prefix)
+
+		count, first, last, err = iteratePrefixSummary(db, tc.prefix)
+		require.NoError(t, err)
+		require.Equal(t, tc.count, count, "prefix %x", tc.prefix)
+		require.Equal(t, tc.first, first, "prefix %x", tc.prefix)
+		require.Equal(t, tc.last, last, "prefix %x", tc.prefix)
+	}
+}
+
+func TestSummarizePrefix(t *testing.T) {
+	checkSummarizePrefix(t, NewMemDB())
+}
+
+// checkValueSize checks ValueSize for present, missing and empty values.
+func checkValueSize(t *testing.T, db DB) {
+	require.NoError(t, db.Set(bz("present"), bz("12345")))
+	require.NoError(t, db.Set(bz("empty"), []byte{}))
+
+	for key, expect := range map[string]int{"present": 5, "empty": 0, "missing": -1} {
+		size, err := ValueSize(db, bz(key))
+		require.NoError(t, err)
+		require.Equal(t, expect, size, key)
+	}
+}
+
+func TestValueSize(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) {
+		checkValueSize(t, NewMemDB())
+	})
+
+	t.Run("GoLevelDB", func(t *testing.T) {
+		name := fmt.Sprintf("test_%x", randStr(12))
+		db, err := NewGoLevelDB(name, "")
+		require.NoError(t, err)
+		defer cleanupDBDir("", name)
+		defer db.Close()
+		checkValueSize(t, db)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		db := NewPrefixDB(NewMemDB(), bz("p"))
+		_, ok := DB(db).(ValueSizer)
+		require.False(t, ok)
+		checkValueSize(t, db)
+	})
+}
+
+// checkDeleteStrict checks DeleteStrict on db. If atomic is set, exactly one of several concurrent
+// deletes of the same key must succeed; otherwise, several may succeed as the check and delete
+// are separate operations.
+func checkDeleteStrict(t *testing.T, db DB, atomic bool) {
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	require.NoError(t, DeleteStrict(db, bz("a")))
+	has, err := db.Has(bz("a"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.ErrorIs(t, DeleteStrict(db, bz("a")), ErrKeyNotFound)
+	require.ErrorIs(t, DeleteStrict(db, bz("missing")), ErrKeyNotFound)
+	require.Equal(t, errKeyEmpty, DeleteStrict(db, nil))
+
+	for round := 0; round < 20; round++ {
+		require.NoError(t, db.Set(bz("race"), bz("1")))
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			go func() { errs <- DeleteStrict(db, bz("race")) }()
+		}
+		succeeded := 0
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err == nil {
+				succeeded++
+			} else {
+				require.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}
+		if atomic {
+			require.Equal(t, 1, succeeded)
+		} else {
+			require.GreaterOrEqual(t, succeeded, 1)
+		}
+		has, err := db.Has(bz("race"))
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+}
+
+func TestDeleteStrict(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) {
+		checkDeleteStrict(t, NewMemDB(), true)
+	})
+
+	t.Run("GoLevelDB", func(t *testing.T) {
+		name := fmt.Sprintf("test_%x", randStr(12))
+		db, err := NewGoLevelDB(name, "")
+		require.NoError(t, err)
+		defer cleanupDBDir("", name)
+		defer db.Close()
+		checkDeleteStrict(t, db, true)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		db := NewPrefixDB(NewMemDB(), bz("p"))
+		_, ok := DB(db).(StrictDeleter)
+		require.False(t, ok)
+		checkDeleteStrict(t, db, false)
+	})
+}
+
+// checkDeleteKeys checks DeleteKeys with overlapping, missing and duplicate keys.
+func checkDeleteKeys(t *testing.T, db DB) {
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set(bz(fmt.Sprintf("k%d", i)), bz("value")))
+	}
+
+	// Nothing is deleted if a key is empty.
+	_, err := DeleteKeys(db, [][]byte{bz("k0"), {}, bz("k1")})
+	require.ErrorIs(t, err, errKeyEmpty)
+	require.ErrorContains(t, err, "key 1")
+	require.Len(t, collectAll(t, db), 10)
+
+	deleted, err := DeleteKeys(db, [][]byte{bz("k1"), bz("missing"), bz("k3"), bz("k1"), bz("k5")})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, deleted)
+
+	// Keys overlapping with the previous call are no longer counted.
+	deleted, err = DeleteKeys(db, [][]byte{bz("k5"), bz("k6"), bz("k3"), bz("k7")})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, deleted)
+
+	deleted, err = DeleteKeys(db, nil)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	remaining := make(map[string][]byte)
+	for _, i := range []int{0, 2, 4, 8, 9} {
+		remaining[fmt.Sprintf("k%d", i)] = bz("value")
+	}
+	assertKeyValues(t, db, remaining)
+}
+
+func TestDeleteKeys(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) {
+		checkDeleteKeys(t, NewMemDB())
+	})
+
+	t.Run("GoLevelDB", func(t *testing.T) {
+		name := fmt.Sprintf("test_%x", randStr(12))
+		db, err := NewGoLevelDB(name, "")
+		require.NoError(t, err)
+		defer cleanupDBDir("", name)
+		defer db.Close()
+		checkDeleteKeys(t, db)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		db := NewPrefixDB(NewMemDB(), bz("p"))
+		_, ok := DB(db).(MultiDeleter)
+		require.False(t, ok)
+		checkDeleteKeys(t, db)
+	})
+}
+
+// checkGetMany checks GetMany with missing, empty and duplicate keys.
+func checkGetMany(t *testing.T, db DB) {
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set(bz(fmt.Sprintf("k%d", i)), bz(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, db.Set(bz("empty"), []byte{}))
+
+	_, err := GetMany(db, [][]byte{bz("k0"), {}, bz("k1")})
+	require.ErrorIs(t, err, errKeyEmpty)
+	require.ErrorContains(t, err, "key 1")
+
+	values, err := GetMany(db, [][]byte{bz("k3"), bz("missing"), bz("k1"), bz("empty"), bz("k3"), bz("missing")})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{bz("value3"), nil, bz("value1"), {}, bz("value3"), nil}, values)
+	require.NotNil(t, values[3])
+
+	// The values of duplicate keys do not share memory.
+	values[0][0] = 'x'
+	require.Equal(t, bz("value3"), values[4])
+
+	values, err = GetMany(db, nil)
+	require.NoError(t, err)
+	require.Empty(t, values)
+}
+
+// countingGetDB counts the keys read by Get.
+type countingGetDB struct {
+	DB
+	gets []string
+}
+
+func (db *countingGetDB) Get(key []byte) ([]byte, error) {
+	db.gets = append(db.gets, string(key))
+	return db.DB.Get(key)
+}
+
+func TestGetMany(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) {
+		checkGetMany(t, NewMemDB())
+	})
+
+	t.Run("GoLevelDB", func(t *testing.T) {
+		name := fmt.Sprintf("test_%x", randStr(12))
+		db, err := NewGoLevelDB(name, "")
+		require.NoError(t, err)
+		defer cleanupDBDir("", name)
+		defer db.Close()
+		checkGetMany(t, db)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		db := &countingGetDB{DB: NewMemDB()}
+		checkGetMany(t, db)
+		// Empty keys fail before any read, and each distinct key is read once.
+		require.Equal(t, []string{"k3", "missing", "k1", "empty"}, db.gets)
+	})
+}
+
+// checkStrictSets checks SetInsertOnly and SetUpdateOnly, including their conflicts.
+func checkStrictSets(t *testing.T, db DB) {
+	require.NoError(t, SetInsertOnly(db, bz("new"), bz("one")))
+	require.Equal(t, ErrKeyExists{Key: bz("new")}, SetInsertOnly(db, bz("new"), bz("two")))
+	require.NoError(t, SetUpdateOnly(db, bz("new"), bz("two")))
+	require.ErrorIs(t, SetUpdateOnly(db, bz("missing"), bz("value")), ErrKeyNotFound)
+	require.Equal(t, errKeyEmpty, SetInsertOnly(db, nil, bz("value")))
+	require.Equal(t, errValueNil, SetUpdateOnly(db, bz("new"), nil))
+
+	// Empty values exist.
+	require.NoError(t, SetInsertOnly(db, bz("empty"), []byte{}))
+	require.ErrorAs(t, SetInsertOnly(db, bz("empty"), bz("value")), &ErrKeyExists{})
+	require.NoError(t, SetUpdateOnly(db, bz("empty"), bz("value")))
+
+	require.NoError(t, db.Delete(bz("new")))
+	require.ErrorIs(t, SetUpdateOnly(db, bz("new"), bz("three")), ErrKeyNotFound)
+	require.NoError(t, SetInsertOnly(db, bz("new"), bz("three")))
+
+	assertKeyValues(t, db, map[string][]byte{"new": bz("three"), "empty": bz("value")})
+}
+
+// checkStrictSetBatch checks the insert-only and update-only sets of the batches of db, which must
+// implement StrictSetBatch, and returns the error of a batch whose conditions do not hold.
+func checkStrictSetBatch(t *testing.T, db DB) error {
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+
+	batch := db.NewBatch()
+	sb := batch.(StrictSetBatch)
+	require.NoError(t, sb.SetInsertOnly(bz("b"), bz("2")))
+	require.NoError(t, sb.SetUpdateOnly(bz("a"), bz("10")))
+	// Only the last write of a key is checked.
+	require.NoError(t, sb.SetUpdateOnly(bz("c"), bz("x")))
+	require.NoError(t, batch.Set(bz("c"), bz("3")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	assertKeyValues(t, db, map[string][]byte{"a": bz("10"), "b": bz("2"), "c": bz("3")})
+
+	batch = db.NewBatch()
+	require.NoError(t, batch.(StrictSetBatch).SetInsertOnly(bz("b"), bz("20")))
+	require.Equal(t, ErrKeyExists{Key: bz("b")}, batch.Write())
+	require.NoError(t, batch.Close())
+
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set(bz("d"), bz("4")))
+	require.NoError(t, batch.(StrictSetBatch).SetUpdateOnly(bz("missing"), bz("x")))
+	err := batch.Write()
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.NoError(t, batch.Close())
+	return err
+}
+
+func TestStrictSets(t *testing.T) {
+	t.Run("MemDB", func(t *testing.T) {
+		checkStrictSets(t, NewMemDB())
+	})
+
+	t.Run("GoLevelDB", func(t *testing.T) {
+		name := fmt.Sprintf("test_%x", randStr(12))
+		db, err := NewGoLevelDB(name, "")
+		require.NoError(t, err)
+		defer cleanupDBDir("", name)
+		defer db.Close()
+		checkStrictSets(t, db)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		db := NewPrefixDB(NewMemDB(), bz("p"))
+		_, ok := DB(db).(StrictSetter)
+		require.False(t, ok)
+		checkStrictSets(t, db)
+	})
+}
+
+func TestMemDBStrictSetBatch(t *testing.T) {
+	db := NewMemDB()
+	checkStrictSetBatch(t, db)
+	// MemDB batches are atomic, so nothing is written if a condition does not hold.
+	checkValue(t, db, bz("d"), nil)
+}
+
+func TestWaitForKey(t *testing.T) {
+	testCases := map[string]struct {
+		db   func() DB
+		poll time.Duration
+	}{
+		"polling": {func() DB { return NewMemDB() }, time.Millisecond},
+		"watch":   {func() DB { return NewWatchableDB(NewMemDB()) }, 0},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			db := tc.db()
+			ctx := context.Background()
+
+			// A key which already exists is returned at once.
+			require.NoError(t, db.Set(bz("present"), bz("1")))
+			value, err := WaitForKey(ctx, db, bz("present"), tc.poll)
+			require.NoError(t, err)
+			require.Equal(t, bz("1"), value)
+
+			// A key is returned once set, ignoring other writes.
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				_ = db.Set(bz("laterx"), bz("other"))
+				_ = db.Delete(bz("later"))
+				_ = db.Set(bz("later"), bz("2"))
+			}()
+			value, err = WaitForKey(ctx, db, bz("later"), tc.poll)
+			require.NoError(t, err)
+			require.Equal(t, bz("2"), value)
+
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			_, err = WaitForKey(ctx, db, bz("missing"), tc.poll)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+
+			_, err = WaitForKey(ctx, db, nil, tc.poll)
+			require.Error(t, err)
+		})
+	}
+
+	// Polling needs an interval.
+	_, err := WaitForKey(context.Background(), NewMemDB(), bz("a"), 0)
+	require.Error(t, err)
+}
+
+// setOnGetDB runs set after the first Get, before returning its result.
+type setOnGetDB struct {
+	DB
+	once sync.Once
+	set  func()
+}
+
+func (db *setOnGetDB) Get(key []byte) ([]byte, error) {
+	value, err := db.DB.Get(key)
+	db.once.Do(db.set)
+	return value, err
+}
+
+func TestWaitForKeyDroppedEvent(t *testing.T) {
+	// The key and a longer key are written while the first lookup misses, so the event of the key
+	// is dropped from the buffer of the subscription to make room for the other one.
+	inner := &setOnGetDB{DB: NewMemDB()}
+	db := NewWatchableDB(inner)
+	inner.set = func() {
+		_ = db.Set(bz("key"), bz("1"))
+		_ = db.Set(bz("keyx"), bz("2"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	value, err := WaitForKey(ctx, db, bz("key"), 0)
+	require.NoError(t, err)
+	require.Equal(t, bz("1"), value)
+}
+
+func TestWaitForKeyWatchFallback(t *testing.T) {
+	mem := NewMemDB()
+	db := NewWatchableDB(mem)
+
+	// Writes which bypass the watcher are noticed by polling.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = mem.Set(bz("key"), bz("1"))
+	}()
+	value, err := WaitForKey(context.Background(), db, bz("key"), time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, bz("1"), value)
+
+	// Closing the database ends the wait.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = db.Close()
+	}()
+	_, err = WaitForKey(context.Background(), db, bz("missing"), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// VerifyOptions configures VerifyAgainstWithOptions.
+type VerifyOptions struct {
+	// FailOnMismatch makes the verification fail with ErrReplicaDiverged if any sampled key
+	// differs, after all sampled keys were compared and repaired.
+	FailOnMismatch bool
+	// Repair writes the value of the primary to the replica for every sampled key which differs,
+	// and deletes the sampled keys which are missing from the primary.
+	Repair bool
+}
+
+// VerifyReport is the result of VerifyAgainst.
+type VerifyReport struct {
+	// Sampled is the number of keys sampled from the replica.
+	Sampled int
+	// Mismatched is the number of sampled keys whose value differs in the primary, and Missing the
+	// number of sampled keys which do not exist in the primary.
+	Mismatched int
+	Missing    int
+	// Repaired is the number of keys repaired in the replica.
+	Repaired int
+}
+
+// ErrReplicaDiverged is returned by VerifyAgainstWithOptions with FailOnMismatch when sampled keys
+// of the replica differ from the primary.
+type ErrReplicaDiverged struct {
+	Mismatched int
+	Missing    int
+}
+
+func (e ErrReplicaDiverged) Error() string {
+	return fmt.Sprintf("replica diverged from primary: %d mismatched and %d missing keys", e.Mismatched, e.Missing)
+}
+
+// VerifyAgainst samples up to sample keys of replica, e.g. a local cache of a MongoDB database
+// after a crash, and compares their values with primary. onMismatch, if not nil, is called for
+// each sampled key which differs, with its value in primary, or nil if it is missing there, and
+// its value in replica. The sample is taken by iterating over all keys of replica. Keys which only
+// exist in primary are not detected.
+func VerifyAgainst(primary DB, replica DB, sample int, onMismatch func(key, a, b []byte)) (VerifyReport, error) {
+	return VerifyAgainstWithOptions(primary, replica, sample, onMismatch, VerifyOptions{})
+}
+
+// VerifyAgainstWithOptions is like VerifyAgainst, with the given options.
+func VerifyAgainstWithOptions(
+	primary DB, replica DB, sample int, onMismatch func(key, a, b []byte), opts VerifyOptions,
+) (VerifyReport, error) {
+	if sample <= 0 {
+		return VerifyReport{}, fmt.Errorf("invalid sample size %d", sample)
+	}
+	samples, _, _, err := sampleKeys(replica, sample)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("sampling replica: %w", err)
+	}
+
+	report := VerifyReport{Sampled: len(samples)}
+	for _, s := range samples {
+		// The replica is read again, as it may have changed since it was sampled.
+		b, err := replica.Get(s.key)
+		if err != nil {
+			return report, err
+		}
+		if b == nil {
+			continue
+		}
+		a, err := primary.Get(s.key)
+		if err != nil {
+			return report, err
+		}
+		if a != nil && bytes.Equal(a, b) {
+			continue
+		}
+		if a == nil {
+			report.Missing++
+		} else {
+			report.Mismatched++
+		}
+		if onMismatch != nil {
+			onMismatch(s.key, a, b)
+		}
+		if opts.Repair {
+			if a == nil {
+				err = replica.Delete(s.key)
+			} else {
+				err = replica.Set(s.key, a)
+			}
+			if err != nil {
+				return report, fmt.Errorf("repairing key %X: %w", s.key, err)
+			}
+			report.Repaired++
+		}
+	}
+	if opts.FailOnMismatch && report.Mismatched+report.Missing > 0 {
+		return report, ErrReplicaDiverged{Mismatched: report.Mismatched, Missing: report.Missing}
+	}
+	return report, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// divergedPair returns a primary and a replica of 100 keys, of which the replica has 3 with a
+// different value and 2 which are missing from the primary.
+func divergedPair(t *testing.T) (primary, replica DB) {
+	primary, replica = NewMemDB(), NewMemDB()
+	for i := 0; i < 100; i++ {
+		key, value := bz(fmt.Sprintf("key%03d", i)), bz(fmt.Sprintf("value%d", i))
+		require.NoError(t, primary.Set(key, value))
+		require.NoError(t, replica.Set(key, value))
+	}
+	for _, i := range []int{3, 50, 99} {
+		require.NoError(t, replica.Set(bz(fmt.Sprintf("key%03d", i)), bz("stale")))
+	}
+	for _, i := range []int{10, 20} {
+		require.NoError(t, primary.Delete(bz(fmt.Sprintf("key%03d", i))))
+	}
+	// Keys only in the primary are not detected.
+	require.NoError(t, primary.Set(bz("new"), bz("1")))
+	return primary, replica
+}
+
+func TestVerifyAgainst(t *testing.T) {
+	primary, replica := divergedPair(t)
+
+	mismatches := make(map[string][2][]byte)
+	report, err := VerifyAgainst(primary, replica, 1000, func(key, a, b []byte) {
+		mismatches[string(key)] = [2][]byte{a, b}
+	})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{Sampled: 100, Mismatched: 3, Missing: 2}, report)
+	require.Len(t, mismatches, 5)
+	require.Equal(t, [2][]byte{bz("value3"), bz("stale")}, mismatches["key003"])
+	require.Equal(t, [2][]byte{nil, bz("value10")}, mismatches["key010"])
+
+	// A partial sample finds some of the divergence.
+	report, err = VerifyAgainst(primary, replica, 50, nil)
+	require.NoError(t, err)
+	require.Equal(t, 50, report.Sampled)
+	require.LessOrEqual(t, report.Mismatched+report.Missing, 5)
+
+	_, err = VerifyAgainst(primary, replica, 0, nil)
+	require.Error(t, err)
+}
+
+func TestVerifyAgainstWithOptions(t *testing.T) {
+	primary, replica := divergedPair(t)
+
+	report, err := VerifyAgainstWithOptions(primary, replica, 1000, nil, VerifyOptions{FailOnMismatch: true, Repair: true})
+	require.Equal(t, ErrReplicaDiverged{Mismatched: 3, Missing: 2}, err)
+	require.Equal(t, VerifyReport{Sampled: 100, Mismatched: 3, Missing: 2, Repaired: 5}, report)
+	checkValue(t, replica, bz("key003"), bz("value3"))
+	checkValue(t, replica, bz("key010"), nil)
+
+	// The repaired replica verifies.
+	report, err = VerifyAgainstWithOptions(primary, replica, 1000, nil, VerifyOptions{FailOnMismatch: true})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{Sampled: 98}, report)
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// WarmupSpec selects the keys loaded by Warmup.
+type WarmupSpec struct {
+	// Prefixes are the key prefixes to load, in order.
+	Prefixes []WarmupPrefix
+	// MaxBytes bounds the total size of the keys and values loaded. The warmup stops once the next
+	// key would exceed it. Zero loads up to the capacity of the cache.
+	MaxBytes int64
+	// Progress, if set, is called after each prefix, and when the warmup stops early.
+	Progress func(WarmupProgress)
+}
+
+// WarmupPrefix is a key prefix to load, see WarmupSpec.
+type WarmupPrefix struct {
+	Prefix []byte
+	// Limit is the maximum number of keys loaded under the prefix. Zero loads all of them.
+	Limit int
+}
+
+// WarmupProgress reports the progress of Warmup.
+type WarmupProgress struct {
+	// Prefix is the index in WarmupSpec.Prefixes of the last prefix loaded, and PrefixKeys the
+	// number of keys loaded under it.
+	Prefix     int
+	PrefixKeys int
+	// Keys and Bytes are the total number and size of the keys and values loaded so far.
+	Keys  int
+	Bytes int64
+}
+
+// Warmup loads the keys selected by spec into the cache of db, e.g. after a restart, so that the
+// first reads do not all miss. It uses the first Warmer among db and the databases it wraps. If
+// there is none, the keys are read without caching them, which still warms the caches of the
+// backend, e.g. the block cache of LevelDB or the WiredTiger cache of MongoDB.
+//
+// Under each prefix, the keys most recently read according to a HotKeyTrackerDB, if db wraps one
+// which tracks full keys, are loaded first, followed by the other keys in key order.
+func Warmup(ctx context.Context, db DB, spec WarmupSpec) error {
+	for source := db; source != nil; {
+		if w, ok := source.(Warmer); ok {
+			return w.Warmup(ctx, spec)
+		}
+		u, ok := source.(Unwrapper)
+		if !ok {
+			break
+		}
+		source = u.Unwrap()
+	}
+	w := &warmup{
+		spec:   spec,
+		budget: spec.MaxBytes,
+		source: db,
+		load:   func([]byte, []byte, uint64) bool { return true },
+	}
+	return w.run(ctx)
+}
+
+// Warmup implements Warmer, loading the selected keys into the cache. The byte budget is at most
+// the capacity of the cache, and at most MaxEntries keys are loaded. Keys written while they are
+// loaded are not cached.
+func (cdb *CacheDB) Warmup(ctx context.Context, spec WarmupSpec) error {
+	budget := cdb.maxBytes
+	if spec.MaxBytes > 0 && spec.MaxBytes < budget {
+		budget = spec.MaxBytes
+	}
+	w := &warmup{
+		spec:       spec,
+		budget:     budget,
+		maxKeys:    cdb.maxEntries,
+		source:     cdb.DB,
+		generation: cdb.generation,
+		load:       cdb.insert,
+	}
+	return w.run(ctx)
+}
+
+// warmup is a run of Warmup.
+type warmup struct {
+	spec   WarmupSpec
+	budget int64
+	// maxKeys, if positive, is the maximum number of keys loaded.
+	maxKeys int
+	source  DB
+	// generation, if set, returns the invalidation generation of the cache, which is passed to
+	// load to detect writes made since the value was read.
+	generation func() uint64
+	// load loads a key and its value, and returns false if keys were invalidated since gen.
+	load func(key, value []byte, gen uint64) bool
+
+	progress WarmupProgress
+	// full is set once the byte budget or maxKeys is reached.
+	full bool
+}
+
+// run loads the prefixes in order, until the budget is reached or ctx is canceled.
+func (w *warmup) run(ctx context.Context) error {
+	hot := warmupHotKeys(w.source)
+	for i, prefix := range w.spec.Prefixes {
+		w.progress.Prefix, w.progress.PrefixKeys = i, 0
+		err := w.loadPrefix(ctx, prefix, hot)
+		if err != nil {
+			return err
+		}
+		if w.spec.Progress != nil {
+			w.spec.Progress(w.progress)
+		}
+		if w.full {
+			break
+		}
+	}
+	return nil
+}
+
+// loadPrefix loads the hot keys under a prefix, followed by the other keys under it.
+func (w *warmup) loadPrefix(ctx context.Context, prefix WarmupPrefix, hot []HotKey) error {
+	limited := func() bool {
+		return w.full || (prefix.Limit > 0 && w.progress.PrefixKeys >= prefix.Limit)
+	}
+
+	loaded := make(map[string]bool)
+	for _, hk := range hot {
+		if limited() {
+			return nil
+		}
+		if !bytes.HasPrefix(hk.Key, prefix.Prefix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.read(hk.Key); err != nil {
+			return err
+		}
+		loaded[string(hk.Key)] = true
+	}
+
+	gen := w.gen()
+	itr, err := IteratePrefix(w.source, prefix.Prefix)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+	for ; itr.Valid() && !limited(); itr.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if loaded[string(itr.Key())] {
+			continue
+		}
+		if !w.add(itr.Key(), itr.Value(), gen) {
+			// The key may have been written since the iterator was created, so it is read again.
+			if err := w.read(itr.Key()); err != nil {
+				return err
+			}
+		}
+	}
+	return itr.Error()
+}
+
+// read loads a key with Get.
+func (w *warmup) read(key []byte) error {
+	gen := w.gen()
+	value, err := w.source.Get(key)
+	if err != nil || value == nil {
+		return err
+	}
+	w.add(key, value, gen)
+	return nil
+}
+
+// add loads a key, unless it would exceed the budget, and returns false if it was not loaded
+// because keys were invalidated since gen.
+func (w *warmup) add(key, value []byte, gen uint64) bool {
+	size := int64(len(key) + len(value))
+	if (w.budget > 0 && w.progress.Bytes+size > w.budget) || (w.maxKeys > 0 && w.progress.Keys >= w.maxKeys) {
+		w.full = true
+		return true
+	}
+	if !w.load(key, value, gen) {
+		return false
+	}
+	w.progress.Keys++
+	w.progress.PrefixKeys++
+	w.progress.Bytes += size
+	return true
+}
+
+// gen returns the current invalidation generation, if any.
+func (w *warmup) gen() uint64 {
+	if w.generation == nil {
+		return 0
+	}
+	return w.generation()
+}
+
+// warmupHotKeys returns the keys tracked for reads by the first HotKeyTrackerDB among db and the
+// databases it wraps, most recently read first. Returns nil if there is none, or if it tracks
+// truncated keys.
+func warmupHotKeys(db DB) []HotKey {
+	for db != nil {
+		if hdb, ok := db.(*HotKeyTrackerDB); ok {
+			if hdb.prefixLength > 0 {
+				return nil
+			}
+			hot := hdb.reads.report(-1)
+			sort.SliceStable(hot, func(i, j int) bool { return hot[i].LastAccess.After(hot[j].LastAccess) })
+			return hot
+		}
+		u, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// KVEventType is the type of a KVEvent.
+type KVEventType int
+
+const (
+	// KVEventSet is the event of a key being set.
+	KVEventSet KVEventType = iota
+	// KVEventDelete is the event of a key being deleted.
+	KVEventDelete
+)
+
+// KVEvent is a change of a key, published by a WatchableDB.
+type KVEvent struct {
+	Type KVEventType
+	Key  []byte
+	// Value is the new value of a set key, and nil for deletes.
+	Value []byte
+}
+
+// WatchPolicy is what a WatchableDB does when a subscriber's buffer is full.
+type WatchPolicy int
+
+const (
+	// WatchDropOldest discards the oldest buffered event of the subscriber to make room for the
+	// new one, so that writes never wait for subscribers.
+	WatchDropOldest WatchPolicy = iota
+	// WatchBlock makes the write wait until the subscriber has room for the event, so that no
+	// event is lost. A subscriber which stops reading blocks all writes.
+	WatchBlock
+)
+
+// WatchConfig configures a WatchableDB.
+type WatchConfig struct {
+	// Policy applies to subscribers whose buffer is full. Defaults to WatchDropOldest.
+	Policy WatchPolicy
+}
+
+// watchSubscriber is a subscription of a WatchableDB.
+type watchSubscriber struct {
+	prefix []byte
+	events chan KVEvent
+	// done is closed on unsubscribe, to release writes blocked on the subscriber.
+	done chan struct{}
+	once sync.Once
+}
+
+// WatchableDB wraps a database, and publishes an event for every key set or deleted through it to
+// the subscribers of a prefix of the key. Events are published after the write succeeded, and the
+// events of a batch only once the batch is written. Writes which bypass the WatchableDB, e.g. to
+// the wrapped database, are not published. Events of concurrent writes may be delivered in any
+// order, while the events of each write are delivered in order.
+type WatchableDB struct {
+	DB
+
+	policy WatchPolicy
+
+	// mtx is held for reading while events are sent, and for writing while subscribers are
+	// removed, so that their channels are not closed during a send.
+	mtx         sync.RWMutex
+	subscribers map[*watchSubscriber]struct{}
+	dropped     atomic.Uint64
+}
+
+var _ DB = (*WatchableDB)(nil)
+
+// NewWatchableDB wraps db with change notifications, dropping the oldest events of slow
+// subscribers.
+func NewWatchableDB(db DB) *WatchableDB {
+	return NewWatchableDBWithConfig(db, WatchConfig{})
+}
+
+// NewWatchableDBWithConfig is like NewWatchableDB, with the given configuration.
+func NewWatchableDBWithConfig(db DB, cfg WatchConfig) *WatchableDB {
+	return &WatchableDB{
+		DB:          db,
+		policy:      cfg.Policy,
+		subscribers: make(map[*watchSubscriber]struct{}),
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (wdb *WatchableDB) Unwrap() DB {
+	return wdb.DB
+}
+
+// Subscribe returns a channel receiving the events of keys with the given prefix, buffering up to
+// buffer events but at least one, and a function which ends the subscription and closes the
+// channel. A nil or empty prefix subscribes to all keys. The channel is also closed when the
+// database is closed.
+func (wdb *WatchableDB) Subscribe(prefix []byte, buffer int) (<-chan KVEvent, func()) {
+	sub := &watchSubscriber{
+		prefix: cp(prefix),
+		events: make(chan KVEvent, max(buffer, 1)),
+		done:   make(chan struct{}),
+	}
+	wdb.mtx.Lock()
+	wdb.subscribers[sub] = struct{}{}
+	wdb.mtx.Unlock()
+	return sub.events, func() { wdb.unsubscribe(sub) }
+}
+
+// unsubscribe removes a subscriber and closes its channel. It may be called several times.
+func (wdb *WatchableDB) unsubscribe(sub *watchSubscriber) {
+	sub.once.Do(func() {
+		close(sub.done)
+		wdb.mtx.Lock()
+		defer wdb.mtx.Unlock()
+		delete(wdb.subscribers, sub)
+		close(sub.events)
+	})
+}
+
+// publish sends events to the subscribers of their keys.
+func (wdb *WatchableDB) publish(events ...KVEvent) {
+	wdb.mtx.RLock()
+	defer wdb.mtx.RUnlock()
+	for sub := range wdb.subscribers {
+		for _, event := range events {
+			if bytes.HasPrefix(event.Key, sub.prefix) {
+				wdb.send(sub, event)
+			}
+		}
+	}
+}
+
+// send sends an event to a subscriber according to the policy.
+func (wdb *WatchableDB) send(sub *watchSubscriber, event KVEvent) {
+	if wdb.policy == WatchBlock {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		}
+		return
+	}
+	for {
+		select {
+		case sub.events <- event:
+			return
+		default:
+		}
+		// The subscriber may read the oldest event in the meantime, leaving room for the new one.
+		select {
+		case <-sub.events:
+			wdb.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Set implements DB.
+func (wdb *WatchableDB) Set(key, value []byte) error {
+	if err := wdb.DB.Set(key, value); err != nil {
+		return err
+	}
+	wdb.publish(KVEvent{Type: KVEventSet, Key: cp(key), Value: cp(value)})
+	return nil
+}
+
+// SetSync implements DB.
+func (wdb *WatchableDB) SetSync(key, value []byte) error {
+	if err := wdb.DB.SetSync(key, value); err != nil {
+		return err
+	}
+	wdb.publish(KVEvent{Type: KVEventSet, Key: cp(key), Value: cp(value)})
+	return nil
+}
+
+// Delete implements DB.
+func (wdb *WatchableDB) Delete(key []byte) error {
+	if err := wdb.DB.Delete(key); err != nil {
+		return err
+	}
+	wdb.publish(KVEvent{Type: KVEventDelete, Key: cp(key)})
+	return nil
+}
+
+// DeleteSync implements DB.
+func (wdb *WatchableDB) DeleteSync(key []byte) error {
+	if err := wdb.DB.DeleteSync(key); err != nil {
+		return err
+	}
+	wdb.publish(KVEvent{Type: KVEventDelete, Key: cp(key)})
+	return nil
+}
+
+// NewBatch implements DB.
+func (wdb *WatchableDB) NewBatch() Batch {
+	return &watchBatch{Batch: wdb.DB.NewBatch(), db: wdb}
+}
+
+// Stats implements DB, adding watch.subscribers and watch.dropped, the number of events dropped
+// for slow subscribers.
+func (wdb *WatchableDB) Stats() map[string]string {
+	stats := wdb.DB.Stats()
+	if stats == nil {
+		stats = make(map[string]string)
+	}
+	wdb.mtx.RLock()
+	stats["watch.subscribers"] = strconv.Itoa(len(wdb.subscribers))
+	wdb.mtx.RUnlock()
+	stats["watch.dropped"] = strconv.FormatUint(wdb.dropped.Load(), 10)
+	return stats
+}
+
+// Close implements DB. It ends all subscriptions, and closes the database.
+func (wdb *WatchableDB) Close() error {
+	wdb.mtx.RLock()
+	subscribers := make([]*watchSubscriber, 0, len(wdb.subscribers))
+	for sub := range wdb.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	wdb.mtx.RUnlock()
+	for _, sub := range subscribers {
+		wdb.unsubscribe(sub)
+	}
+	return wdb.DB.Close()
+}
+
+// watchBatch publishes the events of a batch once it is written.
+type watchBatch struct {
+	Batch
+
+	db     *WatchableDB
+	events []KVEvent
+}
+
+// Set implements Batch.
+func (b *watchBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.events = append(b.events, KVEvent{Type: KVEventSet, Key: cp(key), Value: cp(value)})
+	return nil
+}
+
+// Delete implements Batch.
+func (b *watchBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.events = append(b.events, KVEvent{Type: KVEventDelete, Key: cp(key)})
+	return nil
+}
+
+// Write implements Batch.
+func (b *watchBatch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	b.flush()
+	return nil
+}
+
+// WriteSync implements Batch.
+func (b *watchBatch) WriteSync() error {
+	if err := b.Batch.WriteSync(); err != nil {
+		return err
+	}
+	b.flush()
+	return nil
+}
+
+// flush publishes the events of the written batch.
+func (b *watchBatch) flush() {
+	events := b.events
+	b.events = nil
+	b.db.publish(events...)
+}
+
+// Close implements Batch.
+func (b *watchBatch) Close() error {
+	b.events = nil
+	return b.Batch.Close()
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receive returns the events buffered in a channel.
+func receive(events <-chan KVEvent) []KVEvent {
+	var received []KVEvent
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return received
+			}
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestWatchableDB(t *testing.T) {
+	db := NewWatchableDB(NewMemDB())
+	events, unsubscribe := db.Subscribe(bz("a/"), 10)
+	all, unsubscribeAll := db.Subscribe(nil, 10)
+	defer unsubscribeAll()
+
+	require.NoError(t, db.Set(bz("a/1"), bz("1")))
+	require.NoError(t, db.SetSync(bz("b/1"), bz("2")))
+	require.NoError(t, db.DeleteSync(bz("a/1")))
+	require.Equal(t, []KVEvent{
+		{Type: KVEventSet, Key: bz("a/1"), Value: bz("1")},
+		{Type: KVEventDelete, Key: bz("a/1")},
+	}, receive(events))
+	require.Len(t, receive(all), 3)
+
+	// Batches publish their events only once written.
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set(bz("a/2"), bz("3")))
+	require.NoError(t, batch.Delete(bz("a/3")))
+	require.Empty(t, receive(events))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	require.Equal(t, []KVEvent{
+		{Type: KVEventSet, Key: bz("a/2"), Value: bz("3")},
+		{Type: KVEventDelete, Key: bz("a/3")},
+	}, receive(events))
+
+	// Batches which are not written publish nothing.
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set(bz("a/4"), bz("4")))
+	require.NoError(t, batch.Close())
+	require.Error(t, batch.Write())
+	require.Empty(t, receive(events))
+
+	// Unsubscribing closes the channel.
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-events
+	require.False(t, ok)
+	require.NoError(t, db.Set(bz("a/5"), bz("5")))
+	require.Equal(t, "1", db.Stats()["watch.subscribers"])
+
+	// Closing the database ends the remaining subscriptions.
+	require.NoError(t, db.Close())
+	receive(all)
+	_, ok = <-all
+	require.False(t, ok)
+}
+
+func TestWatchableDBDropOldest(t *testing.T) {
+	db := NewWatchableDB(NewMemDB())
+	events, unsubscribe := db.Subscribe(nil, 2)
+	defer unsubscribe()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, db.Set(bz(key), bz("1")))
+	}
+	received := receive(events)
+	require.Len(t, received, 2)
+	require.Equal(t, bz("d"), received[0].Key)
+	require.Equal(t, bz("e"), received[1].Key)
+	require.Equal(t, "3", db.Stats()["watch.dropped"])
+}
+
+func TestWatchableDBBlock(t *testing.T) {
+	db := NewWatchableDBWithConfig(NewMemDB(), WatchConfig{Policy: WatchBlock})
+	events, unsubscribe := db.Subscribe(nil, 1)
+
+	require.NoError(t, db.Set(bz("a"), bz("1")))
+	written := make(chan error)
+	go func() { written <- db.Set(bz("b"), bz("2")) }()
+	select {
+	case <-written:
+		t.Fatal("write did not block on the full subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Equal(t, bz("a"), (<-events).Key)
+	require.NoError(t, <-written)
+	require.Equal(t, bz("b"), (<-events).Key)
+
+	// Unsubscribing releases blocked writes.
+	require.NoError(t, db.Set(bz("c"), bz("3")))
+	go func() { written <- db.Set(bz("d"), bz("4")) }()
+	time.Sleep(10 * time.Millisecond)
+	unsubscribe()
+	require.NoError(t, <-written)
+	checkValue(t, db, bz("d"), bz("4"))
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultWriteAccountingRetention is the default of WriteAccountingConfig.Retention.
+const defaultWriteAccountingRetention = 24 * time.Hour
+
+// errNoPhysicalWrites is returned when computing the write amplification of a database which does
+// not report its physical writes.
+var errNoPhysicalWrites = errors.New("database does not report physical writes")
+
+// WriteAccountingConfig configures a WriteAccountingDB.
+type WriteAccountingConfig struct {
+	// Retention is how long samples are kept, which bounds the windows of WriteAmplification.
+	// Defaults to 24 hours.
+	Retention time.Duration
+	// Clock provides the sample times. Defaults to the system clock.
+	Clock Clock
+}
+
+// WriteAmplification is the write amplification of a database over a time window.
+type WriteAmplification struct {
+	// Window is the duration covered, which is shorter than requested if the database was not
+	// sampled early enough.
+	Window time.Duration
+	// LogicalBytes is the size of the keys and values set during the window.
+	LogicalBytes int64
+	// PhysicalBytes is the number of bytes written to storage during the window.
+	PhysicalBytes int64
+	// Ratio is PhysicalBytes divided by LogicalBytes, or zero if nothing was set.
+	Ratio float64
+}
+
+// writeAccountingSample is a sample of the logical and physical byte counters.
+type writeAccountingSample struct {
+	time     time.Time
+	logical  int64
+	physical int64
+}
+
+// WriteAccountingDB wraps a database and counts the logical bytes written to it, i.e. the size of
+// the keys and values set through Set, SetSync and batches. Deletes are not counted.
+//
+// If the database, or a database it wraps, implements PhysicalWriteCounter, WriteAmplification
+// compares the logical bytes to the bytes physically written over a time window, using samples
+// taken by Sample and WriteAmplification. Stats includes the logical byte count, and the result of
+// the last WriteAmplification under write_accounting.amplification.
+type WriteAccountingDB struct {
+	DB
+
+	clock     Clock
+	retention time.Duration
+	physical  PhysicalWriteCounter
+	logical   atomic.Int64
+
+	mtx sync.Mutex
+	// samples are the samples taken within the retention, in time order.
+	samples []writeAccountingSample
+	// last is the result of the last WriteAmplification, if any.
+	last *WriteAmplification
+}
+
+var _ DB = (*WriteAccountingDB)(nil)
+
+// NewWriteAccountingDB wraps db with logical write accounting.
+func NewWriteAccountingDB(db DB) DB {
+	return NewWriteAccountingDBWithConfig(db, WriteAccountingConfig{})
+}
+
+// NewWriteAccountingDBWithConfig is like NewWriteAccountingDB, with the given configuration. A
+// first sample is taken, from which write amplification can be computed.
+func NewWriteAccountingDBWithConfig(db DB, cfg WriteAccountingConfig) *WriteAccountingDB {
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultWriteAccountingRetention
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	wdb := &WriteAccountingDB{
+		DB:        db,
+		clock:     cfg.Clock,
+		retention: cfg.Retention,
+		physical:  physicalWriteCounter(db),
+	}
+	if err := wdb.Sample(); err != nil && !errors.Is(err, errNoPhysicalWrites) {
+		logf("write accounting: failed to sample physical writes: %v", err)
+	}
+	return wdb
+}
+
+// physicalWriteCounter returns the first of db and the databases it wraps which implements
+// PhysicalWriteCounter, or nil if none does.
+func physicalWriteCounter(db DB) PhysicalWriteCounter {
+	for db != nil {
+		if c, ok := db.(PhysicalWriteCounter); ok {
+			return c
+		}
+		u, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	return nil
+}
+
+// Unwrap implements Unwrapper.
+func (wdb *WriteAccountingDB) Unwrap() DB {
+	return wdb.DB
+}
+
+// LogicalBytesWritten returns the size of the keys and values set since the database was wrapped.
+func (wdb *WriteAccountingDB) LogicalBytesWritten() int64 {
+	return wdb.logical.Load()
+}
+
+// Sample records the logical and physical byte counts, for WriteAmplification. It should be
+// called periodically, at least once per window of interest.
+func (wdb *WriteAccountingDB) Sample() error {
+	_, err := wdb.sample()
+	return err
+}
+
+// sample records and returns a new sample.
+func (wdb *WriteAccountingDB) sample() (writeAccountingSample, error) {
+	if wdb.physical == nil {
+		return writeAccountingSample{}, errNoPhysicalWrites
+	}
+	// The logical count is read first, so that writes in between are counted physically rather
+	// than only logically.
+	logical := wdb.logical.Load()
+	physical, err := wdb.physical.PhysicalBytesWritten()
+	if err != nil {
+		return writeAccountingSample{}, fmt.Errorf("physical writes: %w", err)
+	}
+	s := writeAccountingSample{time: wdb.clock.Now(), logical: logical, physical: physical}
+
+	wdb.mtx.Lock()
+	defer wdb.mtx.Unlock()
+	wdb.samples = append(wdb.samples, s)
+	// Keep the newest sample older than the retention, so that a full window remains available.
+	cutoff := s.time.Add(-wdb.retention)
+	i := 0
+	for i+1 < len(wdb.samples) && !wdb.samples[i+1].time.After(cutoff) {
+		i++
+	}
+	wdb.samples = append(wdb.samples[:0], wdb.samples[i:]...)
+	return s, nil
+}
+
+// WriteAmplification takes a sample, and returns the write amplification between it and the newest
+// sample taken at least window earlier, or the oldest sample if there is none. It fails if the
+// database does not report its physical writes.
+func (wdb *WriteAccountingDB) WriteAmplification(window time.Duration) (WriteAmplification, error) {
+	now, err := wdb.sample()
+	if err != nil {
+		return WriteAmplification{}, err
+	}
+
+	wdb.mtx.Lock()
+	defer wdb.mtx.Unlock()
+	start := wdb.samples[0]
+	for _, s := range wdb.samples {
+		if s.time.After(now.time.Add(-window)) {
+			break
+		}
+		start = s
+	}
+	wa := computeWriteAmplification(start, now)
+	wdb.last = &wa
+	return wa, nil
+}
+
+// computeWriteAmplification returns the write amplification between two samples.
+func computeWriteAmplification(start, end writeAccountingSample) WriteAmplification {
+	wa := WriteAmplification{
+		Window:        end.time.Sub(start.time),
+		LogicalBytes:  end.logical - start.logical,
+		PhysicalBytes: end.physical - start.physical,
+	}
+	if wa.LogicalBytes > 0 {
+		wa.Ratio = float64(wa.PhysicalBytes) / float64(wa.LogicalBytes)
+	}
+	return wa
+}
+
+// Set implements DB.
+func (wdb *WriteAccountingDB) Set(key, value []byte) error {
+	if err := wdb.DB.Set(key, value); err != nil {
+		return err
+	}
+	wdb.logical.Add(int64(len(key) + len(value)))
+	return nil
+}
+
+// SetSync implements DB.
+func (wdb *WriteAccountingDB) SetSync(key, value []byte) error {
+	if err := wdb.DB.SetSync(key, value); err != nil {
+		return err
+	}
+	wdb.logical.Add(int64(len(key) + len(value)))
+	return nil
+}
+
+// NewBatch implements DB.
+func (wdb *WriteAccountingDB) NewBatch() Batch {
+	return &writeAccountingBatch{Batch: wdb.DB.NewBatch(), db: wdb}
+}
+
+// Stats implements DB, adding write_accounting.logical_bytes and, once WriteAmplification was
+// called, write_accounting.amplification, write_accounting.amplification_window_seconds,
+// write_accounting.window_logical_bytes and write_accounting.window_physical_bytes.
+func (wdb *WriteAccountingDB) Stats() map[string]string {
+	stats := wdb.DB.Stats()
+	if stats == nil {
+		stats = make(map[string]string)
+	}
+	stats["write_accounting.logical_bytes"] = strconv.FormatInt(wdb.logical.Load(), 10)
+
+	wdb.mtx.Lock()
+	defer wdb.mtx.Unlock()
+	if wa := wdb.last; wa != nil {
+		stats["write_accounting.amplification"] = strconv.FormatFloat(wa.Ratio, 'f', -1, 64)
+		stats["write_accounting.amplification_window_seconds"] = strconv.FormatFloat(wa.Window.Seconds(), 'f', -1, 64)
+		stats["write_accounting.window_logical_bytes"] = strconv.FormatInt(wa.LogicalBytes, 10)
+		stats["write_accounting.window_physical_bytes"] = strconv.FormatInt(wa.PhysicalBytes, 10)
+	}
+	return stats
+}
+
+// writeAccountingBatch counts the keys and values set in a batch once it is written.
+type writeAccountingBatch struct {
+	Batch
+
+	db    *WriteAccountingDB
+	bytes int64
+}
+
+// Set implements Batch.
+func (b *writeAccountingBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.bytes += int64(len(key) + len(value))
+	return nil
+}
+
+// Write implements Batch.
+func (b *writeAccountingBatch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	b.db.logical.Add(b.bytes)
+	b.bytes = 0
+	return nil
+}
+
+// WriteSync implements Batch.
+func (b *writeAccountingBatch) WriteSync() error {
+	if err := b.Batch.WriteSync(); err != nil {
+		return err
+	}
+	b.db.logical.Add(b.bytes)
+	b.bytes = 0
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// physicalStubDB reports a settable number of physical bytes written.
+type physicalStubDB struct {
+	DB
+
+	physical int64
+}
+
+func (db *physicalStubDB) PhysicalBytesWritten() (int64, error) {
+	return db.physical, nil
+}
+
+func TestWriteAccountingDBLogicalBytes(t *testing.T) {
+	db := NewWriteAccountingDB(NewMemDB()).(*WriteAccountingDB)
+
+	require.NoError(t, db.Set([]byte("key"), []byte("value")))
+	require.NoError(t, db.SetSync([]byte("k"), []byte{}))
+	require.NoError(t, db.Delete([]byte("key")))
+	require.Error(t, db.Set(nil, []byte("value")))
+	require.EqualValues(t, 8+1, db.LogicalBytesWritten())
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("ab"), []byte("cde")))
+	require.NoError(t, batch.Delete([]byte("k")))
+	require.EqualValues(t, 9, db.LogicalBytesWritten())
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	require.EqualValues(t, 14, db.LogicalBytesWritten())
+
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set([]byte("x"), []byte("y")))
+	require.NoError(t, batch.Close())
+	require.EqualValues(t, 14, db.LogicalBytesWritten())
+
+	require.Equal(t, "14", db.Stats()["write_accounting.logical_bytes"])
+	_, err := db.WriteAmplification(time.Hour)
+	require.ErrorIs(t, err, errNoPhysicalWrites)
+}
+
+func TestWriteAccountingDBAmplification(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	stub := &physicalStubDB{DB: NewMemDB(), physical: 1000}
+	// The physical counter is found through wrappers.
+	db := NewWriteAccountingDBWithConfig(NewPrefixDB(stub, []byte("p/")), WriteAccountingConfig{
+		Retention: 2 * time.Hour,
+		Clock:     clock,
+	})
+
+	// 100 logical bytes, 300 physical bytes in the first hour.
+	require.NoError(t, db.Set([]byte("0123456789"), make([]byte, 90)))
+	stub.physical += 300
+	clock.advance(time.Hour)
+	require.NoError(t, db.Sample())
+
+	// 50 logical bytes, 500 physical bytes in the second hour.
+	require.NoError(t, db.Set([]byte("0123456789"), make([]byte, 40)))
+	stub.physical += 500
+	clock.advance(time.Hour)
+
+	wa, err := db.WriteAmplification(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, WriteAmplification{Window: time.Hour, LogicalBytes: 50, PhysicalBytes: 500, Ratio: 10}, wa)
+
+	stats := db.Stats()
+	require.Equal(t, "10", stats["write_accounting.amplification"])
+	require.Equal(t, "3600", stats["write_accounting.amplification_window_seconds"])
+	require.Equal(t, "500", stats["write_accounting.window_physical_bytes"])
+
+	// Longer windows are truncated to the samples available.
+	wa, err = db.WriteAmplification(24 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, WriteAmplification{Window: 2 * time.Hour, LogicalBytes: 150, PhysicalBytes: 800, Ratio: 800.0 / 150}, wa)
+
+	// Samples older than the retention are dropped, except for the newest of them.
+	clock.advance(time.Hour)
+	wa, err = db.WriteAmplification(24 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, wa.Window)
+
+	// Without logical writes, the ratio is zero.
+	wa, err = db.WriteAmplification(0)
+	require.NoError(t, err)
+	require.Equal(t, WriteAmplification{}, wa)
+}
+
+func TestGoLevelDBPhysicalBytesWritten(t *testing.T) {
+	db, err := NewGoLevelDB("physical", t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	before, err := db.PhysicalBytesWritten()
+	require.NoError(t, err)
+	require.NoError(t, db.SetSync([]byte("key"), make([]byte, 1024)))
+	after, err := db.PhysicalBytesWritten()
+	require.NoError(t, err)
+	require.Greater(t, after, before+1024)
+}
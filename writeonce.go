@@ -0,0 +1,235 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// ErrDeleteNotAllowed is returned when deleting a key from a WriteOnceDB which does not allow
+// deletes.
+var ErrDeleteNotAllowed = errors.New("deletes are not allowed on a write-once database")
+
+// WriteOnceConfig configures a WriteOnceDB.
+type WriteOnceConfig struct {
+	// AllowDeletes allows keys to be deleted, e.g. to prune old blocks. A deleted key may then be
+	// set again to any value. By default, deletes fail with ErrDeleteNotAllowed.
+	AllowDeletes bool
+}
+
+// WriteOnceDB wraps a database of append-only data, and prevents existing keys from being
+// overwritten. Setting a key which already exists fails with ErrKeyExists, unless the new value is
+// identical to the existing one, so that idempotent rewrites are allowed.
+//
+// If the database implements ConditionalSetter, as MemDB and MongoDB do, Set and SetSync check and
+// set the key atomically, with a single round trip to MongoDB. Otherwise, the key is read before
+// it is written, and the check is only atomic with respect to other writes through the same
+// WriteOnceDB: writes made directly to the wrapped database, or through another WriteOnceDB, may
+// race with the check. Batches always check their keys this way when written.
+type WriteOnceDB struct {
+	DB
+
+	allowDeletes bool
+	// mtx serializes the checks and writes of the read-then-write path.
+	mtx sync.Mutex
+}
+
+var _ DB = (*WriteOnceDB)(nil)
+
+// NewWriteOnceDB wraps db with an immutability guard, which does not allow deletes.
+func NewWriteOnceDB(db DB) DB {
+	return NewWriteOnceDBWithConfig(db, WriteOnceConfig{})
+}
+
+// NewWriteOnceDBWithConfig is like NewWriteOnceDB, with the given configuration.
+func NewWriteOnceDBWithConfig(db DB, cfg WriteOnceConfig) *WriteOnceDB {
+	return &WriteOnceDB{DB: db, allowDeletes: cfg.AllowDeletes}
+}
+
+// Unwrap implements Unwrapper.
+func (wdb *WriteOnceDB) Unwrap() DB {
+	return wdb.DB
+}
+
+// Set implements DB.
+func (wdb *WriteOnceDB) Set(key, value []byte) error {
+	return wdb.set(key, value, wdb.DB.Set)
+}
+
+// SetSync implements DB.
+func (wdb *WriteOnceDB) SetSync(key, value []byte) error {
+	return wdb.set(key, value, wdb.DB.SetSync)
+}
+
+func (wdb *WriteOnceDB) set(key, value []byte, apply func(key, value []byte) error) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+
+	if setter, ok := wdb.DB.(ConditionalSetter); ok {
+		for {
+			set, err := setter.SetIfAbsent(key, value)
+			if err != nil || set {
+				return err
+			}
+			existing, ok, err := wdb.existing(key)
+			if err != nil {
+				return err
+			}
+			// If the key was deleted in the meantime, try again.
+			if ok {
+				return checkWriteOnce(key, value, existing)
+			}
+		}
+	}
+
+	wdb.mtx.Lock()
+	defer wdb.mtx.Unlock()
+
+	existing, ok, err := wdb.existing(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return checkWriteOnce(key, value, existing)
+	}
+	return apply(key, value)
+}
+
+// existing returns the value of key, and whether the key exists. Some backends return nil for empty
+// values, so the existence of keys without a value is checked separately.
+func (wdb *WriteOnceDB) existing(key []byte) ([]byte, bool, error) {
+	value, err := wdb.DB.Get(key)
+	if err != nil || value != nil {
+		return value, value != nil, err
+	}
+	ok, err := wdb.DB.Has(key)
+	return []byte{}, ok, err
+}
+
+// checkWriteOnce returns ErrKeyExists if the existing value of key differs from value.
+func checkWriteOnce(key, value, existing []byte) error {
+	if !bytes.Equal(value, existing) {
+		return ErrKeyExists{Key: key}
+	}
+	return nil
+}
+
+// Delete implements DB.
+func (wdb *WriteOnceDB) Delete(key []byte) error {
+	if !wdb.allowDeletes {
+		return ErrDeleteNotAllowed
+	}
+	return wdb.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (wdb *WriteOnceDB) DeleteSync(key []byte) error {
+	if !wdb.allowDeletes {
+		return ErrDeleteNotAllowed
+	}
+	return wdb.DB.DeleteSync(key)
+}
+
+// NewBatch implements DB.
+func (wdb *WriteOnceDB) NewBatch() Batch {
+	return &writeOnceBatch{
+		Batch:   wdb.DB.NewBatch(),
+		db:      wdb,
+		sets:    make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+}
+
+// writeOnceBatch checks the keys set in a batch when the batch is written.
+type writeOnceBatch struct {
+	Batch
+
+	db *WriteOnceDB
+	// sets holds the value of each key set in the batch.
+	sets map[string][]byte
+	// deleted holds the keys deleted in the batch, whose existing values need not be checked.
+	deleted map[string]bool
+}
+
+var (
+	_ Batch           = (*writeOnceBatch)(nil)
+	_ ResettableBatch = (*writeOnceBatch)(nil)
+)
+
+// Set implements Batch. Setting a key twice in a batch fails with ErrKeyExists if the values
+// differ.
+func (b *writeOnceBatch) Set(key, value []byte) error {
+	if prior, ok := b.sets[string(key)]; ok {
+		if err := checkWriteOnce(key, value, prior); err != nil {
+			return err
+		}
+	}
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.sets[string(key)] = value
+	return nil
+}
+
+// Delete implements Batch.
+func (b *writeOnceBatch) Delete(key []byte) error {
+	if !b.db.allowDeletes {
+		return ErrDeleteNotAllowed
+	}
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	delete(b.sets, string(key))
+	b.deleted[string(key)] = true
+	return nil
+}
+
+// Write implements Batch.
+func (b *writeOnceBatch) Write() error {
+	return b.write(b.Batch.Write)
+}
+
+// WriteSync implements Batch.
+func (b *writeOnceBatch) WriteSync() error {
+	return b.write(b.Batch.WriteSync)
+}
+
+// write checks that none of the keys set in the batch exist with a different value, and then
+// writes the batch. Nothing is written if a key exists.
+func (b *writeOnceBatch) write(apply func() error) error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+
+	for key, value := range b.sets {
+		if b.deleted[key] {
+			continue
+		}
+		existing, ok, err := b.db.existing([]byte(key))
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := checkWriteOnce([]byte(key), value, existing); err != nil {
+				return err
+			}
+		}
+	}
+	return apply()
+}
+
+// Close implements Batch.
+func (b *writeOnceBatch) Close() error {
+	b.sets, b.deleted = nil, nil
+	return b.Batch.Close()
+}
+
+// Reset implements ResettableBatch.
+func (b *writeOnceBatch) Reset() {
+	b.Batch = resetBatch(b.Batch, b.db.DB.NewBatch)
+	b.sets = make(map[string][]byte)
+	b.deleted = make(map[string]bool)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOnceDB(t *testing.T) {
+	// MemDB sets keys conditionally, while PrefixDB takes the read-then-write path.
+	for name, backing := range map[string]DB{
+		"conditional":     NewMemDB(),
+		"read-then-write": NewPrefixDB(NewMemDB(), []byte("p/")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := NewWriteOnceDB(backing)
+
+			require.NoError(t, db.Set([]byte("block/1"), []byte("one")))
+			// Identical rewrites are allowed.
+			require.NoError(t, db.SetSync([]byte("block/1"), []byte("one")))
+			require.NoError(t, db.Set([]byte("empty"), []byte{}))
+			require.NoError(t, db.Set([]byte("empty"), []byte{}))
+
+			// Differing rewrites are not.
+			err := db.Set([]byte("block/1"), []byte("uno"))
+			require.Equal(t, ErrKeyExists{Key: []byte("block/1")}, err)
+			require.ErrorAs(t, db.Set([]byte("empty"), []byte("x")), &ErrKeyExists{})
+			checkValue(t, db, []byte("block/1"), []byte("one"))
+
+			require.Equal(t, errKeyEmpty, db.Set(nil, []byte("x")))
+			require.Equal(t, errValueNil, db.Set([]byte("x"), nil))
+			require.Equal(t, ErrDeleteNotAllowed, db.Delete([]byte("block/1")))
+			require.Equal(t, ErrDeleteNotAllowed, db.DeleteSync([]byte("block/1")))
+		})
+	}
+}
+
+func TestWriteOnceDBBatch(t *testing.T) {
+	db := NewWriteOnceDB(NewMemDB())
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+
+	// A batch with a differing rewrite is not written at all.
+	batch := db.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte("2")))
+	require.NoError(t, batch.Set([]byte("b"), []byte("2")))
+	require.Equal(t, ErrKeyExists{Key: []byte("a")}, batch.Write())
+	require.NoError(t, batch.Close())
+	assertKeyValues(t, db, map[string][]byte{"a": []byte("1")})
+
+	batch = db.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte("1")))
+	require.NoError(t, batch.Set([]byte("b"), []byte("2")))
+	require.NoError(t, batch.Set([]byte("b"), []byte("2")))
+	require.Equal(t, ErrKeyExists{Key: []byte("b")}, batch.Set([]byte("b"), []byte("3")))
+	require.Equal(t, ErrDeleteNotAllowed, batch.Delete([]byte("a")))
+	require.NoError(t, batch.WriteSync())
+	require.NoError(t, batch.Close())
+	assertKeyValues(t, db, map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+}
+
+func TestWriteOnceDBAllowDeletes(t *testing.T) {
+	db := NewWriteOnceDBWithConfig(NewMemDB(), WriteOnceConfig{AllowDeletes: true})
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Delete([]byte("a")))
+	require.NoError(t, db.Set([]byte("a"), []byte("2")))
+
+	// A key deleted earlier in a batch may be set to a new value.
+	batch := db.NewBatch()
+	defer batch.Close()
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.Set([]byte("a"), []byte("3")))
+	require.NoError(t, batch.Write())
+	checkValue(t, db, []byte("a"), []byte("3"))
+}
+
+func TestWriteOnceDBConcurrent(t *testing.T) {
+	for _, backing := range []DB{NewMemDB(), NewPrefixDB(NewMemDB(), []byte("p/"))} {
+		db := NewWriteOnceDB(backing)
+
+		// Of many concurrent writers with different values, exactly one succeeds.
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = db.Set([]byte("key"), []byte{byte(i)})
+			}(i)
+		}
+		wg.Wait()
+
+		var succeeded int
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+			} else {
+				require.ErrorAs(t, err, &ErrKeyExists{})
+			}
+		}
+		require.Equal(t, 1, succeeded)
+	}
+}
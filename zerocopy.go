@@ -0,0 +1,32 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// optionUnsafeZeroCopy makes backends which support it return values referencing their internal
+// buffers instead of copies, see Capabilities.ZeroCopy.
+const optionUnsafeZeroCopy = "unsafe_zero_copy"
+
+// unsafeZeroCopy returns whether the unsafe_zero_copy option is set.
+func unsafeZeroCopy(options Options) (bool, error) {
+	s, ok := options[optionUnsafeZeroCopy]
+	if !ok {
+		return false, nil
+	}
+	zeroCopy, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", optionUnsafeZeroCopy, s, err)
+	}
+	return zeroCopy, nil
+}
+
+// valueCopy returns a copy of value, or value itself if zeroCopy is set. Empty values are always
+// returned as non-nil slices.
+func valueCopy(value []byte, zeroCopy bool) []byte {
+	if zeroCopy && value != nil {
+		return value
+	}
+	return cp(value)
+}